@@ -0,0 +1,181 @@
+/*
+* Account registration and the admin commands for managing accounts.
+ */
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/dcrodman/archon/data"
+)
+
+const (
+	// Limits imposed by the size of the fields in the login packet.
+	MaxUsernameLength = 16
+	MaxPasswordLength = 16
+	MinPasswordLength = 4
+	MaxEmailLength    = 128
+)
+
+var (
+	ErrInvalidUsername = fmt.Errorf("usernames must be 1 to %d letters, numbers, or "+
+		"any of -_. characters", MaxUsernameLength)
+	ErrInvalidPassword = fmt.Errorf("passwords must be %d to %d characters long",
+		MinPasswordLength, MaxPasswordLength)
+	ErrInvalidEmail = errors.New("invalid email address")
+)
+
+// RegisterAccount validates the details for a new account and saves it with a
+// hashed password. data.ErrAccountExists or data.ErrEmailInUse is returned if
+// the username or email is already registered.
+func RegisterAccount(username, password, email string) (*data.Account, error) {
+	if err := validateUsername(username); err != nil {
+		return nil, err
+	}
+	if err := validatePassword(password); err != nil {
+		return nil, err
+	}
+	email = strings.TrimSpace(email)
+	if email != "" && !validEmail(email) {
+		return nil, ErrInvalidEmail
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	account := &data.Account{
+		Username: username,
+		Password: hash,
+		Email:    email,
+		Active:   true,
+	}
+	if err = database.CreateAccount(account); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// ResetPassword replaces the password for an existing account.
+func ResetPassword(username, password string) error {
+	if err := validatePassword(password); err != nil {
+		return err
+	}
+	twoFactor, err := database.FindTwoFactor(username)
+	if err != nil {
+		return err
+	} else if twoFactor != nil && len(password) > MaxTwoFactorPasswordLength {
+		return ErrTwoFactorPassword
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	return database.UpdatePassword(username, hash)
+}
+
+func validateUsername(username string) error {
+	if len(username) == 0 || len(username) > MaxUsernameLength {
+		return ErrInvalidUsername
+	}
+	for _, c := range username {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.':
+		default:
+			return ErrInvalidUsername
+		}
+	}
+	return nil
+}
+
+func validatePassword(password string) error {
+	if len(password) < MinPasswordLength || len(password) > MaxPasswordLength {
+		return ErrInvalidPassword
+	}
+	return nil
+}
+
+// Only a sanity check; there's no way to be sure an address works without
+// sending something to it.
+func validEmail(email string) bool {
+	at := strings.LastIndex(email, "@")
+	return len(email) <= MaxEmailLength && at > 0 && at < len(email)-1 &&
+		!strings.ContainsAny(email, " \t\r\n")
+}
+
+// Run one of the account admin commands given on the command line:
+//
+//	archon account create <username> <password> [email]
+//	archon account ban <username>
+//	archon account unban <username>
+//	archon account reset <username> <password>
+//	archon account disable-2fa <username>
+//	archon account privilege <username> <player|tester|gm|admin|root>
+func runAccountCommand(args []string) error {
+	usage := errors.New("usage: account create <username> <password> [email] | " +
+		"ban <username> | unban <username> | reset <username> <password> | disable-2fa <username> | " +
+		"privilege <username> <player|tester|gm|admin|root>")
+	if len(args) < 2 {
+		return usage
+	}
+
+	username := args[1]
+	switch {
+	case args[0] == "create" && (len(args) == 3 || len(args) == 4):
+		email := ""
+		if len(args) == 4 {
+			email = args[3]
+		}
+		account, err := RegisterAccount(username, args[2], email)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created account %s with guildcard %d\n", account.Username, account.Guildcard)
+	case (args[0] == "ban" || args[0] == "unban") && len(args) == 2:
+		// Shorthands for permanently banning the account in the bans table and
+		// for lifting all of its bans there.
+		if account, err := database.FindAccount(username); err != nil {
+			return err
+		} else if account == nil {
+			return data.ErrAccountNotFound
+		}
+		if args[0] == "ban" {
+			ban, err := createBan(data.BanAccount, username, 0, "", consoleActor())
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Account %s is now banned by ban %d\n", username, ban.Id)
+		} else {
+			lifted, err := liftAccountBans(username, consoleActor())
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Lifted %d bans on account %s\n", lifted, username)
+		}
+	case args[0] == "reset" && len(args) == 3:
+		if err := ResetPassword(username, args[2]); err != nil {
+			return err
+		}
+		fmt.Printf("Reset password for account %s\n", username)
+	case args[0] == "disable-2fa" && len(args) == 2:
+		if err := database.DeleteTwoFactor(username); err != nil {
+			return err
+		}
+		fmt.Printf("Disabled two-factor authentication for account %s\n", username)
+	case args[0] == "privilege" && len(args) == 3:
+		level, ok := parsePrivilege(args[2])
+		if !ok {
+			return errors.New("unknown privilege tier " + args[2])
+		}
+		if err := database.UpdatePrivilegeLevel(username, level); err != nil {
+			return err
+		}
+		fmt.Printf("Account %s now has %s privileges\n", username, privilegeName(level))
+	default:
+		return usage
+	}
+	return nil
+}
@@ -0,0 +1,552 @@
+/*
+* Optional HTTP API for controlling the running server, served on its own port
+* and authenticated with the token from the config:
+*
+*	GET  /admin/clients        List the connected clients.
+*	POST /admin/kick           Disconnect everyone connected with a guildcard.
+*	POST /admin/broadcast      Send a message to all of the connected players and
+*	                           the players on the other ships.
+*	POST /admin/reload         Reload the config, as with "archon reload".
+*	GET  /admin/ships          List the ships registered with the shipgate.
+*	GET  /admin/online         List the players online on every ship.
+*	GET  /admin/connections    Count the connections to each server's port.
+*	GET  /admin/logins         List the most recent logins to the login servers.
+*	GET  /admin/login-attempts Search the login audit log by username or address
+*	                           (see loginaudit.go).
+*	GET  /admin/errors         Count the warnings and errors logged each minute.
+*	GET  /admin/reports        List the most recent chat reports from the chat filter.
+*	GET  /admin/cheats         List the players flagged by the anti-cheat rules (see
+*	                           anticheat.go).
+*	GET  /admin/audit          Search the item audit log by guildcard or item (see
+*	                           itemaudit.go).
+*	GET  /admin/economy        List the daily economy stats (see economy.go).
+*	POST /admin/ban            Ban a player and disconnect them if they're online.
+*	GET  /admin/maintenance    Show whether maintenance mode is on.
+*	POST /admin/maintenance    Turn maintenance mode on or off.
+*	GET  /admin/rates          Show the drop, rare drop, and experience rates.
+*	POST /admin/rates          Change the rates (see rates.go).
+*	GET  /admin/snapshots      List the snapshots of a character (see snapshot.go).
+*	POST /admin/snapshots/restore
+*	                           Roll a character back to one of its snapshots.
+*	GET  /admin/characters/export
+*	                           Export a character as JSON (see export.go).
+*	POST /admin/characters/import
+*	                           Import a character into an empty slot.
+*
+* Requests and responses are JSON, as with the web API. Requests made through
+* the dashboard (see dashboard.go) name the operator in an X-Archon-Operator
+* header, which is recorded in the ban audit log.
+ */
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dcrodman/archon/data"
+	"github.com/dcrodman/archon/util"
+)
+
+const (
+	// Number of logins kept for /admin/logins.
+	recentLoginCount = 50
+	// How long to wait for the connected clients to answer an admin request.
+	adminClientTimeout = 2 * time.Second
+)
+
+type adminClient struct {
+	Server    string `json:"server"`
+	Id        string `json:"id"`
+	IP        string `json:"ip"`
+	Version   string `json:"version"`
+	Username  string `json:"username,omitempty"`
+	Guildcard uint32 `json:"guildcard,omitempty"`
+	Character string `json:"character,omitempty"`
+	Privilege string `json:"privilege,omitempty"`
+}
+
+type adminKickRequest struct {
+	Guildcard uint32 `json:"guildcard"`
+	// Shown to the player before they're disconnected if it's set.
+	Message string `json:"message"`
+}
+
+type adminKickResponse struct {
+	Disconnected int `json:"disconnected"`
+}
+
+type adminBroadcastRequest struct {
+	Message string `json:"message"`
+}
+
+type adminShip struct {
+	Id      uint32 `json:"id"`
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	Players int    `json:"players"`
+	// 0 if the ship has no limit.
+	MaxPlayers   int    `json:"max_players"`
+	MinPrivilege string `json:"min_privilege"`
+	// Whether the ship is hosted by another server.
+	Remote bool `json:"remote"`
+}
+
+type adminConnections struct {
+	Server      string `json:"server"`
+	Port        string `json:"port"`
+	Connections int    `json:"connections"`
+}
+
+type adminLogin struct {
+	Time      time.Time `json:"time"`
+	Username  string    `json:"username"`
+	Guildcard uint32    `json:"guildcard"`
+	IP        string    `json:"ip"`
+	Version   string    `json:"version"`
+}
+
+type adminBanRequest struct {
+	// One of guildcard, account, ip, or hardware.
+	Type   string `json:"type"`
+	Target string `json:"target"`
+	// A duration such as 30m, 12h, or 7d, or "permanent".
+	Duration string `json:"duration"`
+	Reason   string `json:"reason"`
+}
+
+type adminBanResponse struct {
+	Id           int64 `json:"id"`
+	Disconnected int   `json:"disconnected"`
+}
+
+type adminMaintenance struct {
+	Enabled bool `json:"enabled"`
+	// Ignored when turning maintenance mode on or off.
+	MinPrivilege string `json:"min_privilege"`
+	Message      string `json:"message"`
+}
+
+// Rates that are left out of a request to change them keep their current values,
+// as do the overrides unless the list is given, so that an empty list clears them.
+type adminRates struct {
+	DropRate  *float64       `json:"drop_rate"`
+	RareRate  *float64       `json:"rare_rate"`
+	ExpRate   *float64       `json:"exp_rate"`
+	Overrides []RateOverride `json:"overrides"`
+}
+
+// The most recent logins, newest last.
+type loginHistory struct {
+	logins []adminLogin
+	sync.Mutex
+}
+
+var recentLogins = new(loginHistory)
+
+// Add a login by the player on c.
+func (h *loginHistory) Add(c *Client) {
+	h.Lock()
+	defer h.Unlock()
+	if len(h.logins) == recentLoginCount {
+		h.logins = append(h.logins[:0], h.logins[1:]...)
+	}
+	h.logins = append(h.logins, adminLogin{
+		Time:      time.Now(),
+		Username:  c.username,
+		Guildcard: c.guildcard,
+		IP:        c.IPAddr(),
+		Version:   c.version.String(),
+	})
+}
+
+// List returns the logins, newest first.
+func (h *loginHistory) List() []adminLogin {
+	h.Lock()
+	defer h.Unlock()
+	logins := make([]adminLogin, len(h.logins))
+	for i, login := range h.logins {
+		logins[len(logins)-i-1] = login
+	}
+	return logins
+}
+
+// StartAdminServer starts serving the admin API for c if it's enabled.
+func StartAdminServer(c *controller) {
+	if !config.AdminEnabled {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/clients", c.handleAdminClients)
+	mux.HandleFunc("/admin/kick", c.handleAdminKick)
+	mux.HandleFunc("/admin/broadcast", c.handleAdminBroadcast)
+	mux.HandleFunc("/admin/reload", c.handleAdminReload)
+	mux.HandleFunc("/admin/ships", handleAdminShips)
+	mux.HandleFunc("/admin/online", handleAdminOnline)
+	mux.HandleFunc("/admin/connections", c.handleAdminConnections)
+	mux.HandleFunc("/admin/logins", handleAdminLogins)
+	mux.HandleFunc("/admin/login-attempts", handleAdminLoginAttempts)
+	mux.HandleFunc("/admin/errors", handleAdminErrors)
+	mux.HandleFunc("/admin/reports", handleAdminReports)
+	mux.HandleFunc("/admin/cheats", handleAdminCheats)
+	mux.HandleFunc("/admin/audit", handleAdminItemAudit)
+	mux.HandleFunc("/admin/economy", handleAdminEconomy)
+	mux.HandleFunc("/admin/ban", c.handleAdminBan)
+	mux.HandleFunc("/admin/maintenance", handleAdminMaintenance)
+	mux.HandleFunc("/admin/rates", handleAdminRates)
+	mux.HandleFunc("/admin/snapshots", handleAdminSnapshots)
+	mux.HandleFunc("/admin/snapshots/restore", handleAdminRestoreSnapshot)
+	mux.HandleFunc("/admin/characters/export", handleAdminExportCharacter)
+	mux.HandleFunc("/admin/characters/import", handleAdminImportCharacter)
+
+	server := &http.Server{
+		Addr:         net.JoinHostPort(config.Hostname, config.AdminPort),
+		Handler:      requireAdminToken(mux),
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+	go func() {
+		if config.AdminCertificateFile != "" {
+			fmt.Println("Serving the admin API over HTTPS on " + server.Addr)
+		} else {
+			fmt.Println("Serving the admin API on " + server.Addr)
+		}
+		err := serveHTTP(server, config.AdminCertificateFile, config.AdminKeyFile)
+		log.Error("Admin server stopped: " + err.Error())
+	}()
+}
+
+// Reject any request that doesn't carry the admin token.
+func requireAdminToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(config.AdminToken)) != 1 {
+			log.Warnf("Rejected admin request for %s from %s", req.URL.Path, req.RemoteAddr)
+			writeError(w, http.StatusUnauthorized, "invalid admin token")
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// Run ask for each of clients on its own goroutine, since that's the only place
+// that the player's account and character can be looked at, and return what
+// each of them answered. The answer is nil for clients that disconnected or
+// didn't get to it within adminClientTimeout.
+func askClients(clients []*Client, ask func(c *Client) interface{}) []interface{} {
+	type answer struct {
+		index int
+		value interface{}
+	}
+	answers := make(chan answer, len(clients))
+	for i, c := range clients {
+		i, c := i, c
+		c.Queue(func() { answers <- answer{i, ask(c)} })
+	}
+	results := make([]interface{}, len(clients))
+	timeout := time.After(adminClientTimeout)
+	for remaining := len(clients); remaining > 0; remaining-- {
+		select {
+		case a := <-answers:
+			results[a.index] = a.value
+		case <-timeout:
+			return results
+		}
+	}
+	return results
+}
+
+// Returns the number of answers from askClients that are true.
+func countTrue(answers []interface{}) int {
+	n := 0
+	for _, answer := range answers {
+		if answer == true {
+			n++
+		}
+	}
+	return n
+}
+
+func (controller *controller) handleAdminClients(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	clients := []adminClient{}
+	for _, s := range controller.servers {
+		connected := controller.connections.Clients(s)
+		answers := askClients(connected, func(c *Client) interface{} {
+			client := adminClient{Username: c.username, Guildcard: c.guildcard}
+			if c.character != nil {
+				client.Character = characterName(c.character)
+			}
+			if c.username != "" {
+				client.Privilege = privilegeName(c.privilegeLevel)
+			}
+			return client
+		})
+		for i, c := range connected {
+			// Clients that didn't answer are still listed with what's known
+			// about their connection.
+			client, _ := answers[i].(adminClient)
+			client.Server = s.Name()
+			client.Id = c.id
+			client.IP = c.IPAddr()
+			client.Version = c.version.String()
+			clients = append(clients, client)
+		}
+	}
+	writeJSON(w, http.StatusOK, clients)
+}
+
+func (controller *controller) handleAdminKick(w http.ResponseWriter, req *http.Request) {
+	var body adminKickRequest
+	if !readJSON(w, req, &body) {
+		return
+	} else if body.Guildcard == 0 {
+		writeError(w, http.StatusBadRequest, "guildcard is required")
+		return
+	}
+	answers := askClients(controller.connections.Clients(nil), func(c *Client) interface{} {
+		if c.guildcard != body.Guildcard {
+			return false
+		}
+		if body.Message != "" {
+			SendClientMessage(c, body.Message)
+		}
+		c.log.Info("Kicked by admin request from " + req.RemoteAddr)
+		c.Close()
+		return true
+	})
+	resp := adminKickResponse{Disconnected: countTrue(answers)}
+	if resp.Disconnected == 0 {
+		writeError(w, http.StatusNotFound, "guildcard is not connected")
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (controller *controller) handleAdminBroadcast(w http.ResponseWriter, req *http.Request) {
+	var body adminBroadcastRequest
+	if !readJSON(w, req, &body) {
+		return
+	} else if strings.TrimSpace(body.Message) == "" {
+		writeError(w, http.StatusBadRequest, "message is required")
+		return
+	}
+	log.Infof("Broadcasting admin message from %s: %s", req.RemoteAddr, body.Message)
+	controller.notifyClients(body.Message)
+	sendRemoteShipMessage(0, 0, []byte(body.Message))
+	writeJSON(w, http.StatusOK, struct{}{})
+}
+
+// Reload errors are only logged (see controller.reload), so a successful
+// response just means that the reload ran.
+func (controller *controller) handleAdminReload(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	controller.reload()
+	writeJSON(w, http.StatusOK, struct{}{})
+}
+
+func handleAdminShips(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	resp := []adminShip{}
+	for _, ship := range ships.List() {
+		ip := net.IP(ship.ipAddr[:]).String()
+		resp = append(resp, adminShip{
+			Id:      ship.id,
+			Name:    string(util.StripPadding(ship.name[:])),
+			Address: net.JoinHostPort(ip, fmt.Sprint(ship.port)),
+			Players: ship.NumPlayers(),
+			Remote:  ship.client != nil,
+
+			MaxPlayers:   ship.maxPlayers,
+			MinPrivilege: privilegeName(ship.minPrivilege),
+		})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (controller *controller) handleAdminConnections(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	resp := []adminConnections{}
+	for _, s := range controller.servers {
+		resp = append(resp, adminConnections{
+			Server:      s.Name(),
+			Port:        s.Port(),
+			Connections: len(controller.connections.Clients(s)),
+		})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func handleAdminLogins(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, recentLogins.List())
+}
+
+func handleAdminErrors(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, recentLogStats.Recent(time.Now()))
+}
+
+func handleAdminOnline(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	resp := []presenceRecord{}
+	for _, p := range onlinePlayers.List() {
+		resp = append(resp, newPresenceRecord(p))
+	}
+	sort.Slice(resp, func(i, j int) bool { return resp[i].Since.Before(resp[j].Since) })
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func handleAdminReports(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, recentChatReports.List())
+}
+
+func (controller *controller) handleAdminBan(w http.ResponseWriter, req *http.Request) {
+	var body adminBanRequest
+	if !readJSON(w, req, &body) {
+		return
+	}
+	duration, err := parseBanDuration(body.Duration)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	} else if body.Target == "" {
+		writeError(w, http.StatusBadRequest, "target is required")
+		return
+	}
+	switch body.Type {
+	case data.BanGuildcard, data.BanAccount, data.BanIP, data.BanHardware:
+	default:
+		writeError(w, http.StatusBadRequest, "unknown ban type "+body.Type)
+		return
+	}
+	ban, err := createBan(body.Type, body.Target, duration, body.Reason, adminActor(req))
+	if err != nil {
+		log.Error("Failed to create ban: " + err.Error())
+		writeError(w, http.StatusInternalServerError, "unable to create ban")
+		return
+	}
+	log.Infof("Created ban %d on %s %s by admin request from %s", ban.Id, ban.Type, ban.Target, req.RemoteAddr)
+
+	// Don't leave the player online until the ship's next ban check.
+	answers := askClients(controller.connections.Clients(nil), func(c *Client) interface{} {
+		if c.guildcard == 0 {
+			return false
+		}
+		for _, target := range banTargets(c) {
+			if target == ban.BanTarget {
+				sendBanMessage(c, ban)
+				c.Close()
+				return true
+			}
+		}
+		return false
+	})
+	resp := adminBanResponse{Id: ban.Id, Disconnected: countTrue(answers)}
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+// Turning maintenance mode on or off only lasts until the config is reloaded,
+// at which point the setting from the file is used again.
+func handleAdminMaintenance(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		var body adminMaintenance
+		if !readJSON(w, req, &body) {
+			return
+		}
+		log.Infof("Maintenance mode set to %v by admin request from %s", body.Enabled, req.RemoteAddr)
+		config.SetMaintenance(body.Enabled)
+	}
+	maintenance := config.Maintenance()
+	writeJSON(w, http.StatusOK, adminMaintenance{
+		Enabled:      maintenance.MaintenanceEnabled,
+		MinPrivilege: maintenance.MaintenanceMinPrivilege,
+		Message:      maintenance.MaintenanceMessage,
+	})
+}
+
+// As with maintenance mode, changes to the rates last until the config is reloaded.
+func handleAdminRates(w http.ResponseWriter, req *http.Request) {
+	drops := config.Drops()
+	rates, overrides := config.Rates()
+	if req.Method != http.MethodGet {
+		var body adminRates
+		if !readJSON(w, req, &body) {
+			return
+		}
+		for _, rate := range []*float64{body.DropRate, body.RareRate, body.ExpRate} {
+			if rate != nil && *rate < 0 {
+				writeError(w, http.StatusBadRequest, "rates can't be negative")
+				return
+			}
+		}
+		if body.DropRate != nil {
+			drops.DropRate = *body.DropRate
+		}
+		if body.RareRate != nil {
+			drops.RareRate = *body.RareRate
+		}
+		if body.ExpRate != nil {
+			rates.ExpRate = *body.ExpRate
+		}
+		if body.Overrides != nil {
+			var err error
+			if overrides, err = parseRateOverrides(body.Overrides); err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			rates.RateOverrides = body.Overrides
+		}
+		log.Infof("Rates set to drop %g, rare %g, and experience %g with %d overrides by admin request from %s",
+			drops.DropRate, drops.RareRate, rates.ExpRate, len(overrides), req.RemoteAddr)
+		config.SetRates(drops, rates, overrides)
+	}
+	overridesList := rates.RateOverrides
+	if overridesList == nil {
+		overridesList = []RateOverride{}
+	}
+	writeJSON(w, http.StatusOK, adminRates{
+		DropRate:  &drops.DropRate,
+		RareRate:  &drops.RareRate,
+		ExpRate:   &rates.ExpRate,
+		Overrides: overridesList,
+	})
+}
+
+// Name recorded in the audit log for bans issued through the admin API.
+func adminActor(req *http.Request) string {
+	if operator := req.Header.Get("X-Archon-Operator"); operator != "" {
+		return "dashboard:" + operator
+	}
+	return "admin:" + req.RemoteAddr
+}
@@ -0,0 +1,386 @@
+/*
+* Anti-cheat rules on the block servers. The gameplay commands that players send
+* are checked against what the server knows about their characters and games,
+* and the players who break a rule have its action taken against them. The rules
+* are read from the anti-cheat file, which is reloaded along with the config:
+*
+*	{
+*		"stats": {"action": "kick", "material_bonus": 500},
+*		"teleport": {"action": "log", "max_speed": 300},
+*		"attack_rate": {"action": "flag", "per_second": 8},
+*		"pickup": {"action": "flag"}
+*	}
+*
+* Each of the rules is optional:
+*
+*	stats        The character data that the client sends on entering a lobby
+*	             has a higher level than the server gave the character, or stats
+*	             higher than the level table allows for their class and level
+*	             plus material_bonus (the most that materials can add to one).
+*	teleport     The player moved further than max_speed units in a second on a
+*	             floor, or turned up on a floor that they didn't go to. Not
+*	             checked while a quest is being played, since quests can move
+*	             players around.
+*	attack_rate  The player started more than per_second attacks in a second.
+*	pickup       The player tried to pick up an item that was never on the floor.
+*
+* with these actions:
+*
+*	log   Log a warning.
+*	kick  Disconnect the player.
+*	flag  Log a warning, record the player in the database, listed at
+*	      /admin/cheats, and let the GMs who are online know about it. Each
+*	      player is flagged at most once for each rule per connection.
+ */
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dcrodman/archon/data"
+	"github.com/dcrodman/archon/packets"
+	"github.com/dcrodman/archon/util"
+)
+
+// Number of flags listed by /admin/cheats when it isn't given a guildcard.
+const recentCheatFlagCount = 100
+
+// What to do about a player who broke a rule.
+const (
+	cheatActionLog  = "log"
+	cheatActionKick = "kick"
+	cheatActionFlag = "flag"
+)
+
+// Names of the rules, as they're recorded when players are flagged.
+const (
+	cheatRuleStats      = "stats"
+	cheatRuleTeleport   = "teleport"
+	cheatRuleAttackRate = "attack_rate"
+	cheatRulePickup     = "pickup"
+	// Checked by the character server rather than the rules (see
+	// CharacterServer.HandleChecksum).
+	cheatRuleClientBuild = "client_build"
+)
+
+type cheatStatsRule struct {
+	Action        string `json:"action"`
+	MaterialBonus int    `json:"material_bonus"`
+}
+
+type cheatTeleportRule struct {
+	Action   string  `json:"action"`
+	MaxSpeed float64 `json:"max_speed"`
+}
+
+type cheatAttackRateRule struct {
+	Action    string `json:"action"`
+	PerSecond int    `json:"per_second"`
+}
+
+type cheatPickupRule struct {
+	Action string `json:"action"`
+}
+
+type anticheatFile struct {
+	Stats      *cheatStatsRule      `json:"stats"`
+	Teleport   *cheatTeleportRule   `json:"teleport"`
+	AttackRate *cheatAttackRateRule `json:"attack_rate"`
+	Pickup     *cheatPickupRule     `json:"pickup"`
+}
+
+// Check the file's rules, returning an error for the first that's invalid.
+func (f *anticheatFile) check() error {
+	actions := map[string]string{}
+	if r := f.Stats; r != nil {
+		if r.MaterialBonus < 0 {
+			return fmt.Errorf("the stats rule's material_bonus can't be negative")
+		}
+		actions[cheatRuleStats] = r.Action
+	}
+	if r := f.Teleport; r != nil {
+		if r.MaxSpeed <= 0 {
+			return fmt.Errorf("the teleport rule needs a max_speed")
+		}
+		actions[cheatRuleTeleport] = r.Action
+	}
+	if r := f.AttackRate; r != nil {
+		if r.PerSecond <= 0 {
+			return fmt.Errorf("the attack_rate rule needs a number of attacks per_second")
+		}
+		actions[cheatRuleAttackRate] = r.Action
+	}
+	if r := f.Pickup; r != nil {
+		actions[cheatRulePickup] = r.Action
+	}
+	for rule, action := range actions {
+		switch action {
+		case cheatActionLog, cheatActionKick, cheatActionFlag:
+		default:
+			return fmt.Errorf("the %s rule has unknown action %q", rule, action)
+		}
+	}
+	return nil
+}
+
+// Synchronized set of the rules from the anti-cheat file.
+type anticheatRules struct {
+	file *anticheatFile
+	sync.RWMutex
+}
+
+var anticheat = &anticheatRules{file: new(anticheatFile)}
+
+// Load the rules from the file at path, replacing the ones loaded before.
+// Nothing is checked if the file doesn't exist.
+func (ac *anticheatRules) Load(path string) error {
+	file := new(anticheatFile)
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		log.Warnf("Anti-cheat file %s doesn't exist; gameplay commands won't be checked", path)
+	} else if err != nil {
+		return err
+	} else {
+		decoder := json.NewDecoder(bytes.NewReader(contents))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(file); err != nil {
+			return fmt.Errorf("invalid %s: %s", path, err.Error())
+		}
+		if err := file.check(); err != nil {
+			return fmt.Errorf("invalid %s: %s", path, err.Error())
+		}
+	}
+
+	ac.Lock()
+	ac.file = file
+	ac.Unlock()
+	return nil
+}
+
+func (ac *anticheatRules) rules() *anticheatFile {
+	ac.RLock()
+	defer ac.RUnlock()
+	return ac.file
+}
+
+// What the rules know about a player's movements and attacks in their game, and
+// the rules they've been flagged for since they connected.
+type cheatState struct {
+	game *Game
+	// Floor that the player last went to, if they've gone to one since joining.
+	floor      uint16
+	floorKnown bool
+	// Where the player was last seen, if they've been seen on their floor.
+	x, z  float32
+	moved time.Time
+	// Attacks the player has started since attacksFrom.
+	attacks     int
+	attacksFrom time.Time
+	flagged     map[string]bool
+}
+
+// Forget what was known about the player's last game once they're in another.
+func (s *cheatState) reset(g *Game) {
+	if s.game != g {
+		flagged := s.flagged
+		*s = cheatState{game: g, flagged: flagged}
+	}
+}
+
+// CheckCharacterData checks the stats in the character data that the client sent
+// against the character's level. Returns false if the player was disconnected.
+func (ac *anticheatRules) CheckCharacterData(c *Client) bool {
+	rule := ac.rules().Stats
+	if rule == nil || c.character == nil || c.version != VersionBB || levels == nil ||
+		int(c.character.Class) >= NumClasses {
+		return true
+	}
+	var pkt packets.CharacterDataPacket
+	if err := c.Decode(&pkt); err != nil {
+		c.log.Warn(err.Error())
+		return true
+	}
+	if pkt.Disp.Level > c.character.Level {
+		return cheatDetected(c, cheatRuleStats, rule.Action, fmt.Sprintf("level %d, but the server has level %d",
+			pkt.Disp.Level+1, c.character.Level+1))
+	}
+	max := levels.Stats(c.character.Class, c.character.Level)
+	names := []string{"ATP", "MST", "EVP", "HP", "DFP", "ATA", "LCK"}
+	stats := []uint16{pkt.Disp.Stats.ATP, pkt.Disp.Stats.MST, pkt.Disp.Stats.EVP, pkt.Disp.Stats.HP,
+		pkt.Disp.Stats.DFP, pkt.Disp.Stats.ATA, pkt.Disp.Stats.LCK}
+	limits := []uint16{max.ATP, max.MST, max.EVP, max.HP, max.DFP, max.ATA, max.LCK}
+	for i, stat := range stats {
+		if limit := int(limits[i]) + rule.MaterialBonus; int(stat) > limit {
+			return cheatDetected(c, cheatRuleStats, rule.Action, fmt.Sprintf("%s %d at level %d (at most %d)",
+				names[i], stat, c.character.Level+1, limit))
+		}
+	}
+	return true
+}
+
+// CheckGameCommand checks a player's movements and attacks. Returns false if the
+// player was disconnected, in which case the command shouldn't be passed on.
+func (ac *anticheatRules) CheckGameCommand(c *Client) bool {
+	g := c.game
+	if g == nil {
+		return true
+	}
+	rules := ac.rules()
+	c.cheat.reset(g)
+	switch c.Data()[packets.BBHeaderSize] {
+	case packets.SubCmdSetFloor, packets.SubCmdWarp:
+		var pkt packets.SetFloorPacket
+		if err := c.Decode(&pkt); err != nil {
+			return true
+		}
+		c.cheat.floor, c.cheat.floorKnown = uint16(pkt.Floor), true
+		c.cheat.moved = time.Time{}
+	case packets.SubCmdStopMoving, packets.SubCmdSetPosition:
+		var pkt packets.SetPositionPacket
+		if err := c.Decode(&pkt); err != nil || rules.Teleport == nil || g.Quest() != nil {
+			return true
+		}
+		if c.cheat.floorKnown && pkt.Floor != c.cheat.floor {
+			from := c.cheat.floor
+			c.cheat.floor, c.cheat.moved = pkt.Floor, time.Time{}
+			if !cheatDetected(c, cheatRuleTeleport, rules.Teleport.Action,
+				fmt.Sprintf("on floor %d without going there from floor %d", pkt.Floor, from)) {
+				return false
+			}
+		}
+		return c.cheat.move(c, rules.Teleport, pkt.X, pkt.Z)
+	case packets.SubCmdWalk, packets.SubCmdRun:
+		var pkt packets.MovePacket
+		if err := c.Decode(&pkt); err != nil || rules.Teleport == nil || g.Quest() != nil {
+			return true
+		}
+		return c.cheat.move(c, rules.Teleport, pkt.X, pkt.Z)
+	case packets.SubCmdAttack1, packets.SubCmdAttack2, packets.SubCmdAttack3:
+		rule := rules.AttackRate
+		if rule == nil {
+			return true
+		}
+		now := time.Now()
+		if now.Sub(c.cheat.attacksFrom) >= time.Second {
+			c.cheat.attacks, c.cheat.attacksFrom = 0, now
+		}
+		// Only once for each second that they're over.
+		if c.cheat.attacks++; c.cheat.attacks == rule.PerSecond+1 {
+			return cheatDetected(c, cheatRuleAttackRate, rule.Action,
+				fmt.Sprintf("more than %d attacks in a second", rule.PerSecond))
+		}
+	}
+	return true
+}
+
+// Move the player to x and z on their floor, checking that they could have gone
+// that far since they were last seen. They're given at least a second to have
+// done it, since the client sends its movements in bursts.
+func (s *cheatState) move(c *Client, rule *cheatTeleportRule, x, z float32) bool {
+	now := time.Now()
+	moved, fromX, fromZ := s.moved, s.x, s.z
+	s.x, s.z, s.moved = x, z, now
+	if moved.IsZero() {
+		return true
+	}
+	distance := math.Hypot(float64(x-fromX), float64(z-fromZ))
+	if allowed := rule.MaxSpeed * math.Max(now.Sub(moved).Seconds(), 1); distance > allowed {
+		return cheatDetected(c, cheatRuleTeleport, rule.Action, fmt.Sprintf("moved %.0f units from (%.0f, %.0f) to "+
+			"(%.0f, %.0f) in %s", distance, fromX, fromZ, x, z, now.Sub(moved).Round(time.Millisecond)))
+	}
+	return true
+}
+
+// PickedUpUnknownItem acts against a player who tried to pick up an item that
+// was never on the floor of their game.
+func (ac *anticheatRules) PickedUpUnknownItem(c *Client, itemId uint32) {
+	if rule := ac.rules().Pickup; rule != nil {
+		cheatDetected(c, cheatRulePickup, rule.Action, fmt.Sprintf("picked up item %x, which was never dropped", itemId))
+	}
+}
+
+// Take a rule's action against a player who broke it. Returns false if they were
+// disconnected.
+func cheatDetected(c *Client, rule string, action string, details string) bool {
+	c.log.Warnf("Guildcard %d broke anti-cheat rule %s: %s", c.guildcard, rule, details)
+	switch action {
+	case cheatActionKick:
+		c.log.Infof("Disconnecting guildcard %d for breaking anti-cheat rule %s", c.guildcard, rule)
+		SendClientMessage(c, "You've been disconnected for cheating.")
+		c.Close()
+		return false
+	case cheatActionFlag:
+		if c.cheat.flagged[rule] {
+			break
+		}
+		if c.cheat.flagged == nil {
+			c.cheat.flagged = make(map[string]bool)
+		}
+		c.cheat.flagged[rule] = true
+		flagCheat(c, rule, details)
+	}
+	return true
+}
+
+// Record a player who broke a rule and let the GMs on the ship know about it.
+func flagCheat(c *Client, rule string, details string) {
+	flag := &data.CheatFlag{
+		Guildcard: c.guildcard,
+		Rule:      rule,
+		Details:   details,
+		CreatedAt: time.Now(),
+	}
+	if err := database.CreateCheatFlag(flag); err != nil {
+		c.log.Errorf("Failed to flag guildcard %d: %s", c.guildcard, err.Error())
+	}
+
+	var name string
+	if c.character != nil {
+		name = chatText(util.StripPadding(c.character.Name))
+	}
+	notice := fmt.Sprintf("Cheat flag (%s)\n%s (%d): %s", rule, name, c.guildcard, details)
+	for _, p := range players.List() {
+		if p != c && p.hasPrivilege(data.PrivilegeGM) {
+			SendClientMessage(p, notice)
+		}
+	}
+}
+
+// GET lists the players flagged by the rules, newest first: those flagged for a
+// guildcard if one is given, or else the most recent of them.
+func handleAdminCheats(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var flags []data.CheatFlag
+	var err error
+	if param := req.URL.Query().Get("guildcard"); param != "" {
+		guildcard, parseErr := strconv.ParseUint(param, 10, 32)
+		if parseErr != nil {
+			writeError(w, http.StatusBadRequest, "invalid guildcard")
+			return
+		}
+		flags, err = database.FindCheatFlags(uint32(guildcard))
+	} else {
+		flags, err = database.FindRecentCheatFlags(recentCheatFlagCount)
+	}
+	if err != nil {
+		log.Error("Failed to look up cheat flags: " + err.Error())
+		writeError(w, http.StatusInternalServerError, "unable to look up cheat flags")
+		return
+	}
+	if flags == nil {
+		flags = []data.CheatFlag{}
+	}
+	writeJSON(w, http.StatusOK, flags)
+}
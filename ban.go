@@ -0,0 +1,234 @@
+/*
+* Bans by guildcard, account, IP address, or hardware serial. Bans are checked
+* whenever a client logs in to one of the servers, and the ship periodically
+* disconnects any players who were banned while they were online.
+ */
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dcrodman/archon/data"
+)
+
+// How often the ship checks whether any of its players have been banned.
+const banCheckInterval = time.Minute
+
+// Returns the things that a client can be banned by.
+func banTargets(c *Client) []data.BanTarget {
+	return accountBanTargets(c.guildcard, c.username, c.IPAddr(), c.hardwareId)
+}
+
+// Returns the things that someone logging in to the account with guildcard and
+// username from ip can be banned by. hardwareId is "" if it isn't known.
+func accountBanTargets(guildcard uint32, username, ip, hardwareId string) []data.BanTarget {
+	targets := []data.BanTarget{
+		{Type: data.BanGuildcard, Target: strconv.FormatUint(uint64(guildcard), 10)},
+		{Type: data.BanAccount, Target: username},
+		{Type: data.BanIP, Target: ip},
+	}
+	if hardwareId != "" {
+		targets = append(targets, data.BanTarget{Type: data.BanHardware, Target: hardwareId})
+	}
+	return targets
+}
+
+// Returns whether the account itself is banned, by its guildcard or username.
+// Bans on the addresses and machines it's been used from aren't checked.
+func accountBanned(account *data.Account) (bool, error) {
+	ban, err := database.FindActiveBan([]data.BanTarget{
+		{Type: data.BanGuildcard, Target: strconv.FormatUint(uint64(account.Guildcard), 10)},
+		{Type: data.BanAccount, Target: account.Username},
+	})
+	return ban != nil, err
+}
+
+// Lift all of the bans on the account with username, returning how many there
+// were.
+func liftAccountBans(username, actor string) (int, error) {
+	lifted := 0
+	for {
+		ban, err := database.FindActiveBan([]data.BanTarget{{Type: data.BanAccount, Target: username}})
+		if err != nil || ban == nil {
+			return lifted, err
+		}
+		if err = database.LiftBan(ban.Id, actor, ""); err != nil {
+			return lifted, err
+		}
+		lifted++
+	}
+}
+
+// Identifies the client's machine from the hardware info in the login packet,
+// or returns "" if the client didn't send any.
+func hardwareId(info [8]byte) string {
+	for _, b := range info {
+		if b != 0 {
+			return hex.EncodeToString(info[:])
+		}
+	}
+	return ""
+}
+
+// Explain to a banned player why they can't connect.
+func sendBanMessage(c *Client, ban *data.Ban) error {
+	msg := "You have been banned from this server."
+	if !ban.Permanent() {
+		msg = "You have been suspended from this server until " +
+			ban.ExpiresAt.Local().Format("2006-01-02 15:04 MST") + "."
+	}
+	if ban.Reason != "" {
+		msg += "\n\nReason: " + ban.Reason
+	}
+	return SendClientMessage(c, msg)
+}
+
+// Loop for the life of the server, disconnecting any players on the ship who
+// have been banned since they logged in.
+func enforceBans() {
+	for {
+		time.Sleep(banCheckInterval)
+		for _, c := range players.List() {
+			ban, err := database.FindActiveBan(banTargets(c))
+			if err != nil {
+				log.Error("Failed to check bans: " + err.Error())
+				break
+			} else if ban != nil {
+				c.log.Infof("Disconnecting guildcard %d for ban %d", c.guildcard, ban.Id)
+				sendBanMessage(c, ban)
+				c.Close()
+			}
+		}
+	}
+}
+
+// Parse a ban duration such as 30m, 12h, or 7d. "permanent" returns 0.
+func parseBanDuration(s string) (time.Duration, error) {
+	if s == "permanent" {
+		return 0, nil
+	}
+	var d time.Duration
+	var err error
+	if strings.HasSuffix(s, "d") {
+		var days int
+		days, err = strconv.Atoi(strings.TrimSuffix(s, "d"))
+		d = time.Duration(days) * 24 * time.Hour
+	} else {
+		d, err = time.ParseDuration(s)
+	}
+	if err != nil || d <= 0 {
+		return 0, errors.New("invalid ban duration " + s)
+	}
+	return d, nil
+}
+
+// Ban a target of one of the ban types for duration, or permanently if it's 0.
+func createBan(banType, target string, duration time.Duration, reason, issuedBy string) (*data.Ban, error) {
+	switch banType {
+	case data.BanGuildcard, data.BanAccount, data.BanIP, data.BanHardware:
+	default:
+		return nil, errors.New("unknown ban type " + banType)
+	}
+	ban := &data.Ban{
+		BanTarget: data.BanTarget{Type: banType, Target: target},
+		Reason:    reason,
+		IssuedBy:  issuedBy,
+		IssuedAt:  time.Now(),
+	}
+	if duration > 0 {
+		ban.ExpiresAt = ban.IssuedAt.Add(duration)
+	}
+	if err := database.CreateBan(ban); err != nil {
+		return nil, err
+	}
+	return ban, nil
+}
+
+// Name recorded in the audit log for bans issued from the command line.
+func consoleActor() string {
+	if user := os.Getenv("USER"); user != "" {
+		return "console:" + user
+	}
+	return "console"
+}
+
+// Run one of the ban admin commands given on the command line:
+//
+//	archon ban <guildcard|account|ip|hardware> <target> <duration|permanent> [reason]
+//	archon unban <id> [reason]
+//	archon bans [id]
+func runBanCommand(args []string) error {
+	switch {
+	case args[0] == "ban" && len(args) >= 4:
+		duration, err := parseBanDuration(args[3])
+		if err != nil {
+			return err
+		}
+		ban, err := createBan(args[1], args[2], duration, strings.Join(args[4:], " "), consoleActor())
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created ban %d\n", ban.Id)
+	case args[0] == "unban" && len(args) >= 2:
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return errors.New("invalid ban id " + args[1])
+		}
+		if err = database.LiftBan(id, consoleActor(), strings.Join(args[2:], " ")); err != nil {
+			return err
+		}
+		fmt.Printf("Lifted ban %d\n", id)
+	case args[0] == "bans" && len(args) == 1:
+		return listBans()
+	case args[0] == "bans" && len(args) == 2:
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return errors.New("invalid ban id " + args[1])
+		}
+		return listBanAudit(id)
+	default:
+		return errors.New("usage: ban <guildcard|account|ip|hardware> <target> " +
+			"<duration|permanent> [reason] | unban <id> [reason] | bans [id]")
+	}
+	return nil
+}
+
+func listBans() error {
+	bans, err := database.FindActiveBans()
+	if err != nil {
+		return err
+	}
+	if len(bans) == 0 {
+		fmt.Println("No active bans")
+	}
+	for _, ban := range bans {
+		expires := "never"
+		if !ban.Permanent() {
+			expires = ban.ExpiresAt.Local().Format(time.RFC1123)
+		}
+		fmt.Printf("Id %d: %s %s by %s, expires %s: %s\n", ban.Id, ban.Type, ban.Target,
+			ban.IssuedBy, expires, ban.Reason)
+	}
+	return nil
+}
+
+func listBanAudit(id int64) error {
+	entries, err := database.FindBanAudit(id)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return data.ErrBanNotFound
+	}
+	for _, entry := range entries {
+		fmt.Printf("%s: %s by %s: %s\n", entry.CreatedAt.Local().Format(time.RFC1123),
+			entry.Action, entry.Actor, entry.Reason)
+	}
+	return nil
+}
@@ -0,0 +1,349 @@
+/*
+* Bank storage for the block servers. The client asks for the contents of its
+* bank when the player uses the bank counter and then reports each deposit or
+* withdrawal, which we apply and queue to be saved right away (see saves.go).
+*
+* With common_bank on, each account also has a common bank that all of its
+* characters can use. There's no room in the client's bank menu for a second
+* bank, so players switch the bank counter between the two with /bank and move
+* things between them by way of their inventory.
+ */
+package main
+
+import (
+	"errors"
+	"hash/crc32"
+	"time"
+
+	"github.com/dcrodman/archon/data"
+	"github.com/dcrodman/archon/packets"
+	"github.com/dcrodman/archon/util"
+)
+
+const (
+	// Maximum amount of meseta a character can carry or store.
+	MaxMeseta = 999999
+	// Maximum number of items carried in a player's inventory.
+	MaxInventoryItems = 30
+	// Maximum size of a stack of tools.
+	MaxStackSize = 10
+
+	// Actions sent in the BankActionPacket.
+	BankDeposit  = 0
+	BankWithdraw = 1
+)
+
+// Returns the slot under which the player's bank is stored.
+func bankSlot(c *Client) uint32 {
+	if config.SharedBank {
+		return data.SharedBankSlot
+	}
+	return uint32(c.config.SlotNum)
+}
+
+// Returns the bank that the player is using, which is their character's unless
+// they've switched to the common bank. It's nil until they open it.
+func currentBank(c *Client) *data.Bank {
+	if c.commonBankOpen {
+		return c.commonBank
+	}
+	return c.bank
+}
+
+// Returns the slot under which the bank that the player is using is stored.
+func currentBankSlot(c *Client) uint32 {
+	if c.commonBankOpen {
+		return data.SharedBankSlot
+	}
+	return bankSlot(c)
+}
+
+// Switch the player between their character's bank and the common bank, which
+// they see the next time that they open the bank.
+func runBankCommand(c *Client, args string) error {
+	if !config.CommonBank {
+		return errors.New("There's no common bank on this ship.")
+	} else if c.trading() {
+		return errors.New("You can't switch banks while trading.")
+	}
+	c.commonBankOpen = !c.commonBankOpen
+	if c.commonBankOpen {
+		return SendClientMessage(c, "The bank will now show the common bank, which all of your characters share.")
+	}
+	return SendClientMessage(c, "The bank will now show your character's bank.")
+}
+
+// The player opened the bank; load it if needed and send them its contents.
+func (server *BlockServer) HandleBankRequest(c *Client) error {
+	if c.character == nil {
+		return errors.New("Client requested their bank without a character: " + c.IPAddr())
+	}
+	if currentBank(c) == nil {
+		bank, err := c.db().FindBank(c.guildcard, currentBankSlot(c))
+		if err != nil {
+			c.log.Error(err.Error())
+			return err
+		}
+		if c.commonBankOpen {
+			c.commonBank = bank
+		} else {
+			c.bank = bank
+		}
+	}
+	return server.sendBankContents(c)
+}
+
+// Send the contents of the player's bank.
+func (server *BlockServer) sendBankContents(c *Client) error {
+	bank := currentBank(c)
+	pkt := &packets.BankContentsPacket{
+		Header:    packets.BBHeader{Type: packets.GameCommandLargeType},
+		SubHeader: packets.SubCmdHeader{Type: packets.SubCmdBankContents},
+		NumItems:  uint32(len(bank.Items)),
+		Meseta:    bank.Meseta,
+	}
+	for _, item := range bank.Items {
+		pkt.Items = append(pkt.Items, packets.BankItem{
+			Item:    newItemData(item),
+			Amount:  uint16(itemAmount(item)),
+			Present: 0x01,
+		})
+	}
+	// Size of the subcommand, which starts after the BB header.
+	pkt.Size = uint32(20 + 24*len(pkt.Items))
+	itemBytes, _ := util.BytesFromStruct(&struct{ Items []packets.BankItem }{pkt.Items})
+	pkt.Checksum = crc32.ChecksumIEEE(itemBytes)
+
+	c.log.Debug("Sending Bank Contents")
+	return EncryptAndSend(c, pkt)
+}
+
+// The player deposited or withdrew something from the bank.
+func (server *BlockServer) HandleBankAction(c *Client) error {
+	var pkt packets.BankActionPacket
+	if err := c.Decode(&pkt); err != nil {
+		return err
+	}
+	if currentBank(c) == nil || c.character == nil {
+		return errors.New("Client used the bank without opening it: " + c.IPAddr())
+	} else if c.trading() {
+		return nil
+	}
+
+	var err error
+	switch {
+	case pkt.Action == BankDeposit && pkt.ItemId == MesetaItemId:
+		err = server.depositMeseta(c, pkt.Meseta)
+	case pkt.Action == BankWithdraw && pkt.ItemId == MesetaItemId:
+		err = server.withdrawMeseta(c, pkt.Meseta)
+	case pkt.Action == BankDeposit:
+		err = server.depositItem(c, pkt.ItemId, pkt.Amount)
+	case pkt.Action == BankWithdraw:
+		err = server.withdrawItem(c, pkt.ItemId, pkt.Amount)
+	default:
+		// The bank menu was closed.
+		return nil
+	}
+	if err != nil {
+		// Don't disconnect them over a bad request; the client will resync
+		// with the bank the next time it's opened.
+		c.log.Warnf("Bank action from guildcard %d failed: %s", c.guildcard, err.Error())
+		return nil
+	}
+	return saveBank(c)
+}
+
+func (server *BlockServer) depositMeseta(c *Client, amount uint32) error {
+	bank := currentBank(c)
+	if amount > c.character.Meseta || bank.Meseta+amount > MaxMeseta {
+		return errors.New("invalid meseta deposit")
+	}
+	c.character.Meseta -= amount
+	bank.Meseta += amount
+	auditItem(c, data.ItemAuditDeposited, nil, 0, amount)
+	return nil
+}
+
+func (server *BlockServer) withdrawMeseta(c *Client, amount uint32) error {
+	bank := currentBank(c)
+	if amount > bank.Meseta || c.character.Meseta+amount > MaxMeseta {
+		return errors.New("invalid meseta withdrawal")
+	}
+	bank.Meseta -= amount
+	c.character.Meseta += amount
+	auditItem(c, data.ItemAuditWithdrawn, nil, 0, amount)
+	return nil
+}
+
+// Move an item (or part of a stack) from the player's inventory into the bank.
+func (server *BlockServer) depositItem(c *Client, itemId uint32, amount uint8) error {
+	bank := currentBank(c)
+	index := findItem(c.inventory, itemId)
+	if index < 0 {
+		return errors.New("deposited item not in inventory")
+	}
+	item := c.inventory[index]
+	if !isStackable(item) || amount == 0 || amount > itemAmount(item) {
+		amount = itemAmount(item)
+	}
+
+	stack := -1
+	if isStackable(item) {
+		stack = findStack(bank.Items, item)
+	}
+	if stack >= 0 {
+		if itemAmount(bank.Items[stack])+amount > MaxStackSize {
+			return errors.New("bank stack is full")
+		}
+		bank.Items[stack].Data[5] += amount
+	} else {
+		if len(bank.Items) >= packets.MaxBankItems {
+			return errors.New("bank is full")
+		}
+		deposited := copyItem(item)
+		deposited.ItemId = nextItemId(bank.Items)
+		deposited.Flags = 0
+		if isStackable(item) {
+			deposited.Data[5] = amount
+		}
+		bank.Items = append(bank.Items, deposited)
+	}
+
+	if isStackable(item) && amount < itemAmount(item) {
+		c.inventory[index].Data[5] -= amount
+	} else {
+		c.inventory = append(c.inventory[:index], c.inventory[index+1:]...)
+	}
+	auditItem(c, data.ItemAuditDeposited, &item, amount, 0)
+
+	// The player's client has already removed the item; let everyone else know.
+	if room := clientRoom(c); room != nil {
+		room.Broadcast(&packets.DestroyItemPacket{
+			Header:    packets.BBHeader{Type: packets.GameCommandType},
+			SubHeader: packets.SubCmdHeader{Type: packets.SubCmdDestroyItem, Size: 3, ClientId: uint16(c.clientId)},
+			ItemId:    itemId,
+			Amount:    uint32(amount),
+		}, c)
+	}
+	return nil
+}
+
+// Move an item (or part of a stack) from the bank into the player's inventory.
+func (server *BlockServer) withdrawItem(c *Client, itemId uint32, amount uint8) error {
+	bank := currentBank(c)
+	index := findItem(bank.Items, itemId)
+	if index < 0 {
+		return errors.New("withdrawn item not in bank")
+	}
+	item := bank.Items[index]
+	if !isStackable(item) || amount == 0 || amount > itemAmount(item) {
+		amount = itemAmount(item)
+	}
+
+	withdrawn := copyItem(item)
+	stack := -1
+	if isStackable(item) {
+		withdrawn.Data[5] = amount
+		stack = findStack(c.inventory, item)
+	}
+	if stack >= 0 {
+		if itemAmount(c.inventory[stack])+amount > MaxStackSize {
+			return errors.New("inventory stack is full")
+		}
+		c.inventory[stack].Data[5] += amount
+		withdrawn.ItemId = c.inventory[stack].ItemId
+	} else {
+		if len(c.inventory) >= MaxInventoryItems {
+			return errors.New("inventory is full")
+		}
+		withdrawn.ItemId = c.newItemId()
+		c.inventory = append(c.inventory, withdrawn)
+	}
+
+	if isStackable(item) && amount < itemAmount(item) {
+		bank.Items[index].Data[5] -= amount
+	} else {
+		bank.Items = append(bank.Items[:index], bank.Items[index+1:]...)
+	}
+	auditItem(c, data.ItemAuditWithdrawn, &withdrawn, 0, 0)
+
+	// Everyone (including the player) needs to be told about the new item.
+	if room := clientRoom(c); room != nil {
+		room.Broadcast(&packets.CreateInventoryItemPacket{
+			Header:    packets.BBHeader{Type: packets.GameCommandType},
+			SubHeader: packets.SubCmdHeader{Type: packets.SubCmdCreateInventoryItem, Size: 7, ClientId: uint16(c.clientId)},
+			Item:      newItemData(withdrawn),
+		}, nil)
+	}
+	return nil
+}
+
+// Save the bank along with the character, since items and meseta move between them.
+func saveBank(c *Client) error {
+	// See saveCharacter.
+	c.tradeResultLock.Lock()
+	defer c.tradeResultLock.Unlock()
+	c.applyTradeResult()
+	save := characterSaveOf(c)
+	save.BankSlot = currentBankSlot(c)
+	save.Bank = currentBank(c)
+	c.lastSave = time.Now()
+	if err := saves.Queue(save); err != nil {
+		c.log.Errorf("Failed to queue bank save for guildcard %d: %s", c.guildcard, err.Error())
+		return err
+	}
+	return nil
+}
+
+// Tools (other than technique disks) can be stacked, with the amount in the sixth byte.
+func isStackable(item data.Item) bool {
+	return len(item.Data) == 12 && item.Data[0] == 0x03 && item.Data[1] != 0x02
+}
+
+// Returns the number of items in a stack, which is 1 for anything that isn't stackable.
+func itemAmount(item data.Item) uint8 {
+	if isStackable(item) && item.Data[5] > 0 {
+		return item.Data[5]
+	}
+	return 1
+}
+
+// Returns the index of the item with the specified id or -1 if it isn't present.
+func findItem(items []data.Item, itemId uint32) int {
+	for i, item := range items {
+		if item.ItemId == itemId {
+			return i
+		}
+	}
+	return -1
+}
+
+// Returns the index of a stack of the same type of item or -1 if there isn't one.
+func findStack(items []data.Item, item data.Item) int {
+	for i, other := range items {
+		if isStackable(other) && other.Data[1] == item.Data[1] && other.Data[2] == item.Data[2] {
+			return i
+		}
+	}
+	return -1
+}
+
+// Returns an id that isn't used by any of the items.
+func nextItemId(items []data.Item) uint32 {
+	id := uint32(FirstItemId)
+	for _, item := range items {
+		if item.ItemId >= id {
+			id = item.ItemId + 1
+		}
+	}
+	return id
+}
+
+func copyItem(item data.Item) data.Item {
+	dup := item
+	dup.Data = make([]byte, 12)
+	dup.Data2 = make([]byte, 4)
+	copy(dup.Data, item.Data)
+	copy(dup.Data2, item.Data2)
+	return dup
+}
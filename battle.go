@@ -0,0 +1,406 @@
+/*
+* Battle mode. The first time the leader of a battle mode game talks to the quest
+* counter they pick one of the rulesets from the battle file, and then one of
+* the battle quests (quests with "mode": "battle" in their manifest) to fight in:
+*
+*	{
+*		"rulesets": [
+*			{
+*				"name": "Level 50",
+*				"description": "Everyone fights at level 50 with 3 lives.",
+*				"level": 50,
+*				"lives": 3,
+*				"time_limit": 15
+*			}
+*		]
+*	}
+*
+* A ruleset with a level gives everyone the stats of their class at that level
+* for the battle. The battle starts once everyone has loaded the quest and ends
+* when time_limit minutes are up or when only one player has lives left (or is
+* left in the game). Players are placed by their kills and then by who died the
+* least, and their places, kills, and deaths are saved on their characters.
+* Leaving a battle before it's over counts as a disconnect in the place the
+* player was in.
+ */
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dcrodman/archon/data"
+	"github.com/dcrodman/archon/packets"
+	"github.com/dcrodman/archon/util"
+)
+
+// Id sent in the menu selection packet to tell the server that the selection
+// was made on the battle rule menu.
+const BattleRuleMenuId uint16 = 0x15
+
+// Names of the places that a player can finish a battle in.
+var placeNames = []string{"1st", "2nd", "3rd", "4th"}
+
+type battleRuleset struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Level everyone fights at, or 0 for them to fight at their own.
+	Level uint32 `json:"level"`
+	// Number of times each player can die before they're out, or 0 for no limit.
+	Lives uint32 `json:"lives"`
+	// Length of the battle in minutes, or 0 for no limit.
+	TimeLimit uint32 `json:"time_limit"`
+}
+
+type battleFile struct {
+	Rulesets []battleRuleset `json:"rulesets"`
+}
+
+// Synchronized set of the rulesets loaded from the battle file.
+type battleRuleList struct {
+	rulesets []battleRuleset
+	sync.RWMutex
+}
+
+var battleRules = new(battleRuleList)
+
+// Load the rulesets from the file at path, replacing the ones loaded before.
+// Battle mode games can't pick any rules if the file doesn't exist.
+func (bl *battleRuleList) Load(path string) error {
+	file := new(battleFile)
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		log.Warnf("Battle file %s doesn't exist; battle mode games won't have any rules to pick", path)
+	} else if err != nil {
+		return err
+	} else {
+		decoder := json.NewDecoder(bytes.NewReader(contents))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(file); err != nil {
+			return fmt.Errorf("invalid %s: %s", path, err.Error())
+		}
+		for _, rules := range file.Rulesets {
+			if rules.Name == "" {
+				return fmt.Errorf("invalid %s: every ruleset needs a name", path)
+			} else if rules.Level > MaxLevel {
+				return fmt.Errorf("invalid %s: levels can't be higher than %d", path, MaxLevel)
+			}
+		}
+	}
+
+	bl.Lock()
+	bl.rulesets = file.Rulesets
+	bl.Unlock()
+	return nil
+}
+
+// List returns the rulesets in the order they're shown on the menu.
+func (bl *battleRuleList) List() []battleRuleset {
+	bl.RLock()
+	defer bl.RUnlock()
+	return bl.rulesets
+}
+
+// Find returns the ruleset at index on the menu or nil if there isn't one.
+func (bl *battleRuleList) Find(index uint32) *battleRuleset {
+	bl.RLock()
+	defer bl.RUnlock()
+	if int(index) >= len(bl.rulesets) {
+		return nil
+	}
+	rules := bl.rulesets[index]
+	return &rules
+}
+
+// Standing of a player in a battle.
+type battlePlayer struct {
+	client *Client
+	kills  uint32
+	deaths uint32
+	// Lives left, if the rules limit them.
+	lives uint32
+}
+
+// Battle being fought in a game.
+type battle struct {
+	rules   battleRuleset
+	players map[uint8]*battlePlayer
+	timer   *time.Timer
+}
+
+// Returns the players from first place to last.
+func (b *battle) standings() []*battlePlayer {
+	standings := make([]*battlePlayer, 0, len(b.players))
+	for _, p := range b.players {
+		standings = append(standings, p)
+	}
+	sort.Slice(standings, func(i, j int) bool {
+		if standings[i].kills != standings[j].kills {
+			return standings[i].kills > standings[j].kills
+		} else if standings[i].deaths != standings[j].deaths {
+			return standings[i].deaths < standings[j].deaths
+		}
+		return standings[i].client.clientId < standings[j].client.clientId
+	})
+	return standings
+}
+
+// Whether the battle can't go on because there's nobody left to fight.
+func (b *battle) over() bool {
+	if len(b.players) <= 1 {
+		return true
+	} else if b.rules.Lives == 0 {
+		return false
+	}
+	alive := 0
+	for _, p := range b.players {
+		if p.lives > 0 {
+			alive++
+		}
+	}
+	return alive <= 1
+}
+
+// BattleRules returns the rules picked for the game or nil if there aren't any yet.
+func (g *Game) BattleRules() *battleRuleset {
+	g.battleLock.Lock()
+	defer g.battleLock.Unlock()
+	return g.battleRules
+}
+
+// Start the battle once everyone has loaded the battle quest.
+func (g *Game) startBattle() {
+	rules := g.BattleRules()
+	if rules == nil {
+		rules = new(battleRuleset)
+	}
+	b := &battle{rules: *rules, players: make(map[uint8]*battlePlayer)}
+	clients := g.Clients()
+	for _, c := range clients {
+		b.players[c.clientId] = &battlePlayer{client: c, lives: rules.Lives}
+	}
+	if rules.TimeLimit > 0 {
+		b.timer = time.AfterFunc(time.Duration(rules.TimeLimit)*time.Minute, func() {
+			g.endBattle("Time's up!")
+		})
+	}
+	g.battleLock.Lock()
+	g.battle = b
+	g.battleLock.Unlock()
+
+	if rules.Level > 0 && levels != nil {
+		for _, c := range clients {
+			c := c
+			c.Queue(func() {
+				if c.game != g || c.character == nil || int(c.character.Class) >= NumClasses {
+					return
+				}
+				g.sendStats(c, levels.Stats(c.character.Class, rules.Level-1), rules.Level-1)
+			})
+		}
+	}
+}
+
+// Let everyone in the game know what a player's stats are.
+func (g *Game) sendStats(c *Client, stats packets.CharacterStats, level uint32) {
+	g.Broadcast(&packets.LevelUpPacket{
+		Header:    packets.BBHeader{Type: packets.GameCommandType},
+		SubHeader: packets.SubCmdHeader{Type: packets.SubCmdLevelUp, Size: 5, ClientId: uint16(c.clientId)},
+		ATP:       stats.ATP,
+		MST:       stats.MST,
+		EVP:       stats.EVP,
+		HP:        stats.HP,
+		DFP:       stats.DFP,
+		ATA:       stats.ATA,
+		Level:     level,
+	}, nil)
+}
+
+// End the battle being fought in the game, saving everyone's results. This can
+// be called from the battle's timer or any player's goroutine, so each player's
+// results are queued on their own goroutine.
+func (g *Game) endBattle(reason string) {
+	g.battleLock.Lock()
+	b := g.battle
+	g.battle = nil
+	g.battleLock.Unlock()
+	if b == nil {
+		return
+	}
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+
+	results := reason + "\n"
+	standings := b.standings()
+	for place, p := range standings {
+		results += fmt.Sprintf("%s: %s (%d kills, %d deaths)\n",
+			placeNames[place], characterName(p.client.character), p.kills, p.deaths)
+	}
+	for place, p := range standings {
+		c, place, kills, deaths := p.client, place, p.kills, p.deaths
+		c.Queue(func() {
+			if c.game != g {
+				return
+			}
+			saveBattleResult(c, func(record *data.BattleRecord) {
+				record.Places[place]++
+				record.Kills += kills
+				record.Deaths += deaths
+			})
+			if b.rules.Level > 0 && c.character != nil {
+				// Back to their own stats now that the battle is over.
+				character := c.character
+				g.sendStats(c, packets.CharacterStats{ATP: character.ATP, MST: character.MST, EVP: character.EVP,
+					HP: character.HP, DFP: character.DFP, ATA: character.ATA, LCK: character.LCK}, character.Level)
+			}
+			SendClientMessage(c, results)
+		})
+	}
+	log.Infof("Battle in game %d is over", g.id)
+}
+
+// Take a player who left the game out of the battle being fought, which ends
+// if nobody is left to fight them.
+func (g *Game) leaveBattle(c *Client) {
+	g.battleLock.Lock()
+	b := g.battle
+	if b == nil || b.players[c.clientId] == nil {
+		g.battleLock.Unlock()
+		return
+	}
+	place := 0
+	for i, p := range b.standings() {
+		if p.client == c {
+			place = i
+		}
+	}
+	p := b.players[c.clientId]
+	delete(b.players, c.clientId)
+	over := b.over()
+	g.battleLock.Unlock()
+
+	saveBattleResult(c, func(record *data.BattleRecord) {
+		record.Disconnects[place]++
+		record.Kills += p.kills
+		record.Deaths += p.deaths
+	})
+	if over {
+		g.endBattle("Everyone else has left.")
+	}
+}
+
+// Apply a change to the player's battle record and save it.
+func saveBattleResult(c *Client, update func(record *data.BattleRecord)) {
+	if c.battle == nil {
+		return
+	}
+	record := *c.battle
+	update(&record)
+	if err := database.UpdateBattleRecord(c.guildcard, uint32(c.config.SlotNum), &record); err != nil {
+		c.log.Errorf("Failed to save battle record for guildcard %d: %s", c.guildcard, err.Error())
+		return
+	}
+	*c.battle = record
+}
+
+// Show the leader of a battle mode game the rulesets they can pick from.
+func (server *BlockServer) sendBattleRuleMenu(c *Client) error {
+	pkt := &packets.QuestListPacket{Header: packets.BBHeader{Type: packets.QuestListType}}
+	for i, rules := range battleRules.List() {
+		entry := packets.QuestMenuEntry{MenuId: uint32(BattleRuleMenuId), ItemId: uint32(i)}
+		copyUtf16(entry.Name[:len(entry.Name)-1], util.ConvertToUtf16(rules.Name))
+		copyUtf16(entry.Description[:len(entry.Description)-1], util.ConvertToUtf16(rules.Description))
+		pkt.Entries = append(pkt.Entries, entry)
+	}
+	if len(pkt.Entries) == 0 {
+		return SendClientMessage(c, "There are no battle rules available.")
+	}
+	pkt.Header.Flags = uint32(len(pkt.Entries))
+	c.log.Debug("Sending Battle Rule List Packet")
+	return EncryptAndSend(c, pkt)
+}
+
+// The leader highlighted a ruleset on the menu; send them its description.
+func (server *BlockServer) HandleBattleRuleInfo(c *Client, pkt packets.MenuSelectionPacket) error {
+	rules := battleRules.Find(pkt.ItemId)
+	if rules == nil {
+		return nil
+	}
+	info := &packets.QuestInfoPacket{Header: packets.BBHeader{Type: packets.QuestInfoType}}
+	copyUtf16(info.Text[:len(info.Text)-1], util.ConvertToUtf16(rules.Description))
+	c.log.Debug("Sending Battle Rule Info Packet")
+	return EncryptAndSend(c, info)
+}
+
+// The leader picked the rules for the game; let everyone know and show the
+// leader the battle quests.
+func (server *BlockServer) HandleBattleRuleSelection(c *Client, pkt packets.MenuSelectionPacket) error {
+	g := c.game
+	if g == nil || g.battleMode == 0 {
+		return nil
+	} else if g.Leader() != c.clientId {
+		return errors.New("Client attempted to pick battle rules without being the leader: " + c.IPAddr())
+	}
+	rules := battleRules.Find(pkt.ItemId)
+	if rules == nil {
+		return SendClientMessage(c, "Those rules are no longer available.")
+	}
+	g.battleLock.Lock()
+	picked := g.battleRules == nil
+	if picked {
+		g.battleRules = rules
+	}
+	g.battleLock.Unlock()
+	if !picked {
+		return SendClientMessage(c, "The battle rules have already been picked.")
+	}
+
+	c.log.Infof("Battle rules %s picked in game %d", rules.Name, g.id)
+	for _, p := range g.Clients() {
+		SendClientMessage(p, "Battle rules: "+rules.Name+"\n"+rules.Description)
+	}
+	return server.HandleQuestListRequest(c)
+}
+
+// A player died. In a battle, it counts against them and for whoever killed them.
+func (server *BlockServer) HandlePlayerDied(c *Client) error {
+	var pkt packets.PlayerDiedPacket
+	if err := c.Decode(&pkt); err != nil {
+		return err
+	}
+	g := c.game
+	if g == nil {
+		return nil
+	}
+	g.battleLock.Lock()
+	b := g.battle
+	if b == nil || b.players[c.clientId] == nil {
+		g.battleLock.Unlock()
+		return nil
+	}
+	victim := b.players[c.clientId]
+	victim.deaths++
+	if victim.lives > 0 {
+		victim.lives--
+	}
+	if pkt.KillerClientId < MaxGamePlayers {
+		if killer := b.players[uint8(pkt.KillerClientId)]; killer != nil && killer != victim {
+			killer.kills++
+		}
+	}
+	over := b.over()
+	g.battleLock.Unlock()
+
+	if over {
+		g.endBattle("Only one player is left standing.")
+	}
+	return nil
+}
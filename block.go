@@ -4,85 +4,739 @@
 package main
 
 import (
-	"github.com/dcrodman/archon/util"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dcrodman/archon/data"
+	"github.com/dcrodman/archon/packets"
+	"github.com/dcrodman/archon/util"
 )
 
-// Info about the available block servers.
-type Block struct {
-	Unknown   uint16
-	BlockId   uint32
-	Padding   uint16
-	BlockName [36]byte
+// Synchronized set of the players connected to any of the blocks on this ship,
+// indexed by guildcard.
+type playerList struct {
+	clients map[uint32]*Client
+	sync.RWMutex
+}
+
+var players = &playerList{clients: make(map[uint32]*Client)}
+
+func (pl *playerList) Add(c *Client) {
+	pl.Lock()
+	pl.clients[c.guildcard] = c
+	pl.Unlock()
+}
+
+// Remove the client unless they've already been replaced by a newer connection,
+// returning whether they were removed.
+func (pl *playerList) Remove(c *Client) bool {
+	pl.Lock()
+	defer pl.Unlock()
+	if pl.clients[c.guildcard] != c {
+		return false
+	}
+	delete(pl.clients, c.guildcard)
+	return true
+}
+
+// Find returns the player with the specified guildcard or nil if they aren't online.
+func (pl *playerList) Find(guildcard uint32) *Client {
+	pl.RLock()
+	defer pl.RUnlock()
+	return pl.clients[guildcard]
+}
+
+func (pl *playerList) Len() int {
+	pl.RLock()
+	defer pl.RUnlock()
+	return len(pl.clients)
+}
+
+func (pl *playerList) List() []*Client {
+	pl.RLock()
+	defer pl.RUnlock()
+	clients := make([]*Client, 0, len(pl.clients))
+	for _, c := range pl.clients {
+		clients = append(clients, c)
+	}
+	return clients
 }
 
 type BlockServer struct {
 	name string
 	port string
+	id   uint16
 
-	lobbyPkt LobbyListPacket
+	blockPkt *packets.BlockListPacket
+	lobbyPkt packets.LobbyListPacket
+	lobbies  []*Lobby
 }
 
 func (server *BlockServer) Name() string { return server.name }
 
 func (server *BlockServer) Port() string { return server.port }
 
+var blockPacketSizes = map[uint16]int{
+	packets.LoginType:            packetSize(&packets.LoginPkt{}),
+	packets.LobbyChangeType:      packetSize(&packets.MenuSelectionPacket{}),
+	packets.GameCreateType:       packetSize(&packets.GameCreatePacket{}),
+	packets.MenuSelectType:       packetSize(&packets.MenuSelectionPacket{}),
+	packets.SimpleMailType:       packetSize(&packets.SimpleMailPacket{}),
+	packets.GuildcardSearchType:  packetSize(&packets.GuildcardSearchPacket{}),
+	packets.GuildcardAddType:     packetSize(&packets.GuildcardAddPacket{}),
+	packets.GuildcardRemoveType:  packetSize(&packets.GuildcardRemovePacket{}),
+	packets.GuildcardCommentType: packetSize(&packets.GuildcardCommentPacket{}),
+	packets.GuildcardBlockType:   packetSize(&packets.GuildcardBlockPacket{}),
+	packets.GuildcardUnblockType: packetSize(&packets.GuildcardUnblockPacket{}),
+	packets.MenuItemInfoType:     packetSize(&packets.MenuSelectionPacket{}),
+	packets.TradeItemsType:       packetSize(&packets.TradeItemsPacket{}),
+	packets.TeamCreateType:       packetSize(&packets.TeamCreatePacket{}),
+	packets.TeamAddMemberType:    packetSize(&packets.TeamMemberPacket{}),
+	packets.TeamRemoveMemberType: packetSize(&packets.TeamMemberPacket{}),
+	packets.TeamFlagType:         packetSize(&packets.TeamFlagPacket{}),
+}
+
+func (server *BlockServer) MinPacketSizes() map[uint16]int { return blockPacketSizes }
+
 func (server *BlockServer) Init() error {
+	// Players can switch blocks from the lobby, so the block server needs
+	// the same menu as the ship.
+	server.blockPkt = newBlockListPacket(localShip, config.NumBlocks)
+
 	// Precompute our lobby list since this won't change once the server has started.
-	server.lobbyPkt.Header.Size = BBHeaderSize
-	server.lobbyPkt.Header.Type = LobbyListType
+	server.lobbyPkt.Header.Size = packets.BBHeaderSize
+	server.lobbyPkt.Header.Type = packets.LobbyListType
 	server.lobbyPkt.Header.Flags = uint32(config.NumLobbies)
 	for i := 0; i <= config.NumLobbies; i++ {
-		server.lobbyPkt.Lobbies = append(server.lobbyPkt.Lobbies, struct {
-			MenuId  uint32
-			LobbyId uint32
-			Padding uint32
-		}{
-			MenuId:  0x1A0001,
+		server.lobbyPkt.Lobbies = append(server.lobbyPkt.Lobbies, packets.LobbyMenuEntry{
+			MenuId:  LobbyMenuId,
 			LobbyId: uint32(i),
 			Padding: 0,
 		})
 		server.lobbyPkt.Header.Size += 12
 	}
+
+	for i := 0; i < config.NumLobbies; i++ {
+		server.lobbies = append(server.lobbies, NewLobby(uint8(i), server.id))
+	}
 	return nil
 }
 
-func (server *BlockServer) NewClient(conn *net.TCPConn) (*Client, error) {
+func (server *BlockServer) NewClient(conn net.Conn) (*Client, error) {
 	return NewShipClient(conn)
 }
 
 func (server *BlockServer) Handle(c *Client) error {
-	var hdr BBHeader
-	util.StructFromBytes(c.Data()[:BBHeaderSize], &hdr)
+	var hdr packets.BBHeader
+	if err := c.Decode(&hdr); err != nil {
+		return err
+	}
 
 	var err error
 	switch hdr.Type {
-	case LoginType:
+	case packets.LoginType:
 		err = server.HandleShipLogin(c)
+	case packets.CharDataType:
+		if anticheat.CheckCharacterData(c) {
+			err = server.HandleCharacterData(c)
+		}
+	case packets.LobbyChangeType:
+		err = server.HandleLobbyChange(c)
+	case packets.ChatType:
+		server.HandleChat(c)
+	case packets.GameListType:
+		err = server.sendGameList(c)
+	case packets.GameCreateType:
+		err = server.HandleGameCreate(c)
+	case packets.GameLeaveLobbyType:
+		err = server.HandleGameLeave(c)
+	case packets.GameCommandType, packets.GameCommandTargetType, packets.GameCommandLargeType, packets.GameCommandLargeTargetType:
+		err = server.HandleGameCommand(c, hdr)
+	case packets.SimpleMailType:
+		err = server.HandleSimpleMail(c)
+	case packets.GuildcardSearchType:
+		err = server.HandleGuildcardSearch(c)
+	case packets.GuildcardAddType:
+		err = server.HandleGuildcardAdd(c)
+	case packets.GuildcardRemoveType:
+		err = server.HandleGuildcardRemove(c)
+	case packets.GuildcardCommentType:
+		err = server.HandleGuildcardComment(c)
+	case packets.GuildcardBlockType:
+		err = server.HandleGuildcardBlock(c)
+	case packets.GuildcardUnblockType:
+		err = server.HandleGuildcardUnblock(c)
+	case packets.TeamCreateType:
+		err = server.HandleTeamCreate(c)
+	case packets.TeamAddMemberType:
+		err = server.HandleTeamAddMember(c)
+	case packets.TeamRemoveMemberType:
+		err = server.HandleTeamRemoveMember(c)
+	case packets.TeamChatType:
+		server.HandleTeamChat(c)
+	case packets.TeamMemberListRequestType:
+		err = server.sendTeamMemberList(c)
+	case packets.TeamFlagType:
+		err = server.HandleTeamFlag(c)
+	case packets.TeamDisbandType:
+		err = server.HandleTeamDisband(c)
+	case packets.OptionFlagsUpdateType, packets.KeyConfigUpdateType, packets.JoystickConfigUpdateType:
+		err = server.HandleOptionsUpdate(c, hdr)
+	case packets.QuestListType:
+		err = server.HandleQuestListRequest(c)
+	case packets.QuestReadyType:
+		server.HandleQuestReady(c)
+	case packets.QuestFileType, packets.QuestChunkType, packets.QuestMenuClosedType:
+		// The client acknowledging the quest files or closing the quest menu.
+	case packets.TradeItemsType:
+		err = server.HandleTradeItems(c)
+	case packets.TradeConfirmType:
+		err = server.HandleTradeConfirm(c)
+	case packets.TradeCompleteType:
+		server.HandleTradeCancel(c)
+	case packets.MenuItemInfoType:
+		var pkt packets.MenuSelectionPacket
+		if err := c.Decode(&pkt); err != nil {
+			return err
+		}
+		switch pkt.MenuId {
+		case QuestMenuId:
+			err = server.HandleQuestInfo(c, pkt)
+		case BattleRuleMenuId:
+			err = server.HandleBattleRuleInfo(c, pkt)
+		}
+	case packets.MenuSelectType:
+		var pkt packets.MenuSelectionPacket
+		if err := c.Decode(&pkt); err != nil {
+			return err
+		}
+		switch pkt.MenuId {
+		case BlockSelectionMenuId:
+			err = server.HandleBlockSelection(c, pkt)
+		case GameSelectionMenuId:
+			err = server.HandleGameSelection(c)
+		case QuestCategoryMenuId:
+			err = server.HandleQuestCategorySelection(c, pkt)
+		case QuestMenuId:
+			err = server.HandleQuestSelection(c, pkt)
+		case BattleRuleMenuId:
+			err = server.HandleBattleRuleSelection(c, pkt)
+		default:
+			c.log.Infof("Received unknown menu selection %x", pkt.MenuId)
+		}
 	default:
-		log.Infof("Received unknown packet %02x from %s", hdr.Type, c.IPAddr())
+		c.log.Infof("Received unknown packet %02x", hdr.Type)
+	}
+	if err == nil {
+		saveCharacterIfDue(c)
 	}
 	return err
 }
 
 func (server *BlockServer) HandleShipLogin(c *Client) error {
-	if _, err := VerifyAccount(c); err != nil {
+	loginPkt, err := VerifyAccount(c)
+	if err != nil {
+		return err
+	}
+	if err := verifySession(c); err != nil {
+		server.sendSecurity(c, packets.BBLoginErrorUnknown, c.guildcard, c.teamId)
+		return err
+	}
+	// The security data is echoed back to us from the character server, so this
+	// will only be set if they came through the normal character selection.
+	if c.config.CharSelected == 0 {
+		server.sendSecurity(c, packets.BBLoginErrorUnknown, c.guildcard, c.teamId)
+		return errors.New("Client attempted to join a block without selecting a character: " + c.IPAddr())
+	}
+	if err := admitSession(c); err != nil {
 		return err
 	}
-	if err := server.sendSecurity(c, BBLoginErrorNone, c.guildcard, c.teamId); err != nil {
+	if err := server.sendSecurity(c, packets.BBLoginErrorNone, c.guildcard, c.teamId); err != nil {
 		return err
 	}
 	if err := server.sendBlockList(c); err != nil {
 		return err
 	}
-	return server.sendLobbyList(c)
+	if err := server.sendLobbyList(c); err != nil {
+		return err
+	}
+	// Players meeting someone they searched for go to that player's lobby.
+	c.preferredLobby = nil
+	if loginPkt.MenuId == LobbyMenuId && int(loginPkt.PreferredLobby) < len(server.lobbies) {
+		c.preferredLobby = server.lobbies[loginPkt.PreferredLobby]
+	}
+
+	if err := lockCharacter(c); err == errCharacterLocked {
+		SendClientMessage(c, "This character is in use on another ship.\n\nPlease try again in a moment.")
+		return fmt.Errorf("Character in slot %d for guildcard %d is locked by another ship", c.config.SlotNum, c.guildcard)
+	} else if err != nil {
+		SendClientMessage(c, "The server couldn't check whether this character is in use.\n\nPlease try again in a moment.")
+		return err
+	}
+
+	character, err := c.db().FindCharacter(c.guildcard, uint32(c.config.SlotNum))
+	if err != nil {
+		c.log.Error(err.Error())
+		return err
+	} else if character == nil {
+		return fmt.Errorf("No character in slot %d for guildcard %d", c.config.SlotNum, c.guildcard)
+	}
+	c.character = character
+	c.lastSave = time.Now()
+	c.inventory, err = c.db().FindItems(c.guildcard, uint32(c.config.SlotNum), data.ItemLocationInventory)
+	if err != nil {
+		c.log.Error(err.Error())
+		return err
+	}
+	c.techniques, err = c.db().FindTechniques(c.guildcard, uint32(c.config.SlotNum))
+	if err != nil {
+		c.log.Error(err.Error())
+		return err
+	}
+	c.challenge, err = c.db().FindChallengeRecord(c.guildcard, uint32(c.config.SlotNum))
+	if err != nil {
+		c.log.Error(err.Error())
+		return err
+	}
+	c.battle, err = c.db().FindBattleRecord(c.guildcard, uint32(c.config.SlotNum))
+	if err != nil {
+		c.log.Error(err.Error())
+		return err
+	}
+	if err = loadBlockedGuildcards(c); err != nil {
+		c.log.Error(err.Error())
+		return err
+	}
+	if err = loadTeam(c); err != nil {
+		c.log.Error(err.Error())
+		return err
+	}
+	players.Add(c)
+	reportPlayer(c, server.id)
+	return server.sendCharDataRequest(c)
+}
+
+// The player changed their option flags, key config, or joystick config. Updates
+// that are the wrong size are dropped so that they can't overwrite good options.
+func (server *BlockServer) HandleOptionsUpdate(c *Client, hdr packets.BBHeader) error {
+	playerOptions, err := loadPlayerOptions(c)
+	if err != nil {
+		c.log.Error(err.Error())
+		return err
+	}
+	var pkt interface{}
+	switch hdr.Type {
+	case packets.OptionFlagsUpdateType:
+		pkt = new(packets.OptionFlagsUpdatePacket)
+	case packets.KeyConfigUpdateType:
+		pkt = new(packets.KeyConfigUpdatePacket)
+	case packets.JoystickConfigUpdateType:
+		pkt = new(packets.JoystickConfigUpdatePacket)
+	}
+	if int(hdr.Size) != binary.Size(pkt) {
+		c.log.Warnf("Ignoring options update %04x of size %d", hdr.Type, hdr.Size)
+		return nil
+	}
+	if err := c.Decode(pkt); err != nil {
+		return err
+	}
+
+	switch p := pkt.(type) {
+	case *packets.OptionFlagsUpdatePacket:
+		playerOptions.OptionFlags = p.Flags
+	case *packets.KeyConfigUpdatePacket:
+		playerOptions.KeyConfig = p.KeyConfig[:]
+	case *packets.JoystickConfigUpdatePacket:
+		playerOptions.JoystickConfig = p.JoystickConfig[:]
+	}
+	if err := c.db().UpdatePlayerOptions(playerOptions); err != nil {
+		c.log.Errorf("Failed to save options for guildcard %d: %s", c.guildcard, err.Error())
+	}
+	cacheDelete(optionsCacheKey(c.guildcard))
+	return nil
 }
 
-func (server *BlockServer) sendSecurity(client *Client, errorCode BBLoginError,
+// The client has sent us its character data after we requested it, which
+// means it's ready to be put into a lobby.
+func (server *BlockServer) HandleCharacterData(c *Client) error {
+	if c.lobby != nil {
+		return nil
+	}
+	if l := c.preferredLobby; l != nil && !l.Full() {
+		if err := l.Join(c); err == nil {
+			return deliverPendingMail(c)
+		}
+	}
+	for _, l := range server.lobbies {
+		if !l.Full() {
+			if err := l.Join(c); err != nil {
+				return err
+			}
+			return deliverPendingMail(c)
+		}
+	}
+	SendClientMessage(c, "All of the lobbies on this block are full.")
+	return errors.New("No lobby available for " + c.IPAddr())
+}
+
+// The player used a lobby teleporter to move to another lobby.
+func (server *BlockServer) HandleLobbyChange(c *Client) error {
+	var pkt packets.MenuSelectionPacket
+	if err := c.Decode(&pkt); err != nil {
+		return err
+	}
+	if int(pkt.ItemId) >= len(server.lobbies) {
+		return fmt.Errorf("Lobby selection %v out of range %v", pkt.ItemId, len(server.lobbies))
+	}
+	dest := server.lobbies[pkt.ItemId]
+	if dest == c.lobby {
+		return nil
+	} else if dest.Full() {
+		return SendClientMessage(c, "That lobby is full.")
+	}
+	if c.lobby != nil {
+		c.lobby.Leave(c)
+	}
+	return dest.Join(c)
+}
+
+// Relay a chat message to everyone in the sender's lobby or game.
+func (server *BlockServer) HandleChat(c *Client) {
+	if (c.lobby == nil && c.game == nil) || c.character == nil {
+		return
+	}
+	var hdr packets.BBHeader
+	if c.Decode(&hdr) != nil || hdr.Size <= 16 {
+		return
+	}
+	// Strip the null terminator and any padding without splitting a character.
+	message := util.StripPadding(c.Data()[16:hdr.Size])
+	if len(message)%2 != 0 {
+		message = append(message, 0)
+	}
+	if runChatCommand(c, message) || holdMutedChat(c, packets.ChatType, message) {
+		return
+	}
+	message, ok := chatFilters.Filter(c, message)
+	if !ok {
+		return
+	}
+	pkt := newChatPacket(c, packets.ChatType, message)
+	if c.game != nil {
+		c.game.BroadcastMessage(pkt, c.guildcard)
+	} else {
+		c.lobby.BroadcastMessage(pkt, c.guildcard)
+	}
+}
+
+// The player sent a symbol chat or word select. Returns false if it shouldn't
+// be passed along: it's turned off in the player's lobby, or it isn't the size
+// the clients expect or claims to be from someone else, since a malformed one
+// can crash the clients that show it.
+func (server *BlockServer) HandleSymbolChat(c *Client, hdr packets.BBHeader) bool {
+	if c.game == nil && c.lobby != nil && config.QuietLobby(c.lobby.id) {
+		return false
+	}
+	valid := false
+	switch c.Data()[packets.BBHeaderSize] {
+	case packets.SubCmdSymbolChat:
+		var pkt packets.SymbolChatPacket
+		valid = int(hdr.Size) == packetSize(&pkt) && c.Decode(&pkt) == nil &&
+			int(pkt.SubHeader.Size)*4 == int(hdr.Size)-packets.BBHeaderSize &&
+			pkt.ClientId == uint32(c.clientId)
+	case packets.SubCmdWordSelect:
+		var pkt packets.WordSelectPacket
+		valid = int(hdr.Size) == packetSize(&pkt) && c.Decode(&pkt) == nil &&
+			int(pkt.SubHeader.Size)*4 == int(hdr.Size)-packets.BBHeaderSize &&
+			pkt.SubHeader.ClientId == uint16(c.clientId) && pkt.NumTokens <= uint16(len(pkt.Tokens))
+	}
+	if !valid {
+		c.log.Warnf("Dropping malformed symbol chat or word select from guildcard %d", c.guildcard)
+	}
+	return valid
+}
+
+// Returns the chat packet of a type for a message from the player, which the
+// client shows with the name of the player's character.
+func newChatPacket(c *Client, pktType uint16, message []byte) *packets.ChatPacket {
+	name := util.StripPadding(c.character.Name)
+	if len(name)%2 != 0 {
+		name = append(name, 0)
+	}
+	pkt := &packets.ChatPacket{
+		Header:    packets.BBHeader{Type: pktType},
+		Guildcard: c.guildcard,
+	}
+	pkt.Message = append(pkt.Message, name...)
+	pkt.Message = append(pkt.Message, util.ConvertToUtf16("\t")...)
+	pkt.Message = append(pkt.Message, message...)
+	pkt.Message = append(pkt.Message, 0, 0)
+	return pkt
+}
+
+// Process a command sent by the player. Most of these are relayed to the other
+// players as-is, but a few need to be handled by the server.
+func (server *BlockServer) HandleGameCommand(c *Client, hdr packets.BBHeader) error {
+	if hdr.Size <= packets.BBHeaderSize || int(hdr.Size) > len(c.Data()) {
+		return nil
+	}
+	if !anticheat.CheckGameCommand(c) {
+		return nil
+	}
+	switch c.Data()[packets.BBHeaderSize] {
+	case packets.SubCmdBankRequest:
+		return server.HandleBankRequest(c)
+	case packets.SubCmdBankAction:
+		return server.HandleBankAction(c)
+	case packets.SubCmdEnemyDropRequest:
+		return server.HandleDropRequest(c, DropSourceEnemy)
+	case packets.SubCmdBoxDropRequest:
+		return server.HandleDropRequest(c, DropSourceBox)
+	case packets.SubCmdPickUpItemRequest:
+		return server.HandlePickUpItem(c)
+	case packets.SubCmdSplitStack:
+		return server.HandleSplitStack(c)
+	case packets.SubCmdShopRequest:
+		return server.HandleShopRequest(c)
+	case packets.SubCmdBuyItem:
+		return server.HandleBuyItem(c)
+	case packets.SubCmdSellItem:
+		return server.HandleSellItem(c)
+	case packets.SubCmdIdentifyItem:
+		return server.HandleIdentifyItem(c)
+	case packets.SubCmdAcceptIdentify:
+		return server.HandleAcceptIdentify(c)
+	case packets.SubCmdSymbolChat, packets.SubCmdWordSelect:
+		if !server.HandleSymbolChat(c, hdr) {
+			return nil
+		}
+	case packets.SubCmdDestroyItem:
+		// Only passed along if the item is really in their inventory.
+		if ok, err := server.HandleDestroyItem(c); !ok {
+			return err
+		}
+	case packets.SubCmdDropInventoryItem:
+		if ok, err := server.HandleDropInventoryItem(c); !ok {
+			return err
+		}
+	case packets.SubCmdSetQuestFlag:
+		// Passed along afterwards so that the other players see it too.
+		if err := server.HandleSetQuestFlag(c); err != nil {
+			return err
+		}
+	case packets.SubCmdEnemyKilled:
+		if err := server.HandleEnemyKilled(c); err != nil {
+			return err
+		}
+	case packets.SubCmdPlayerDied:
+		if err := server.HandlePlayerDied(c); err != nil {
+			return err
+		}
+	case packets.SubCmdChallengeStageClear:
+		if err := server.HandleChallengeStageClear(c); err != nil {
+			return err
+		}
+	}
+
+	room := clientRoom(c)
+	if room == nil {
+		return nil
+	}
+	pkt := &packets.GameCommandPacket{
+		Header: hdr,
+		Data:   append([]byte(nil), c.Data()[packets.BBHeaderSize:hdr.Size]...),
+	}
+	if hdr.Type == packets.GameCommandTargetType || hdr.Type == packets.GameCommandLargeTargetType {
+		if target := room.Client(uint8(hdr.Flags)); target != nil {
+			return EncryptAndSend(target, pkt)
+		}
+		return nil
+	}
+	room.Broadcast(pkt, c)
+	return nil
+}
+
+// Returns the slots for the game or lobby the client is in, or nil if neither.
+func clientRoom(c *Client) *clientSlots {
+	if c.game != nil {
+		return &c.game.clientSlots
+	} else if c.lobby != nil {
+		return &c.lobby.clientSlots
+	}
+	return nil
+}
+
+// The player filled out the game creation form; move them from their lobby
+// into a new game.
+func (server *BlockServer) HandleGameCreate(c *Client) error {
+	var pkt packets.GameCreatePacket
+	if err := c.Decode(&pkt); err != nil {
+		return err
+	}
+	if c.game != nil {
+		return errors.New("Client attempted to create a game while in one: " + c.IPAddr())
+	} else if !episodeAvailable(pkt.Episode) {
+		return SendClientMessage(c, "Episode 4 isn't available on this ship.")
+	}
+	g, err := games.Create(c, &pkt)
+	if err != nil {
+		SendClientMessage(c, "Unable to create the game.")
+		return err
+	}
+	if c.lobby != nil {
+		c.lobby.Leave(c)
+	}
+	if err := g.Join(c); err != nil {
+		games.Remove(g)
+		return err
+	}
+	return nil
+}
+
+// The player picked a game from the game list.
+func (server *BlockServer) HandleGameSelection(c *Client) error {
+	var pkt packets.GameMenuSelectionPacket
+	if err := c.Decode(&pkt); err != nil {
+		return err
+	}
+	if c.game != nil {
+		return nil
+	}
+	g := games.Find(pkt.ItemId)
+	switch {
+	case g == nil:
+		return SendClientMessage(c, "That game no longer exists.")
+	case g.Full():
+		return SendClientMessage(c, "That game is full.")
+	case !g.CheckPassword(pkt.Password):
+		return SendClientMessage(c, "Incorrect password.")
+	case g.Quest() != nil:
+		return SendClientMessage(c, "That game is playing a quest.")
+	}
+	lobby := c.lobby
+	if lobby != nil {
+		lobby.Leave(c)
+	}
+	if err := g.Join(c); err != nil {
+		// Someone beat them to the last slot; put them back where they were.
+		SendClientMessage(c, "That game is full.")
+		if lobby != nil {
+			return lobby.Join(c)
+		}
+		return err
+	}
+	return nil
+}
+
+// The player is leaving their game and needs to be returned to a lobby.
+func (server *BlockServer) HandleGameLeave(c *Client) error {
+	if c.game != nil {
+		c.game.Leave(c)
+	}
+	if c.lobby != nil {
+		return nil
+	}
+	if c.lastLobby != nil && !c.lastLobby.Full() {
+		if err := c.lastLobby.Join(c); err == nil {
+			return nil
+		}
+	}
+	return server.HandleCharacterData(c)
+}
+
+// Take the client out of whatever lobby or game they were in.
+func (server *BlockServer) Disconnect(c *Client) {
+	if c.game != nil {
+		// So that a trade can't be saved for the player after they have been.
+		c.game.cancelTrade(c, true)
+	}
+	if players.Remove(c) {
+		reportPlayer(c, 0)
+		if c.character != nil {
+			// Not until the character has been saved below.
+			defer unlockCharacter(c)
+		}
+	}
+	if c.character != nil {
+		if err := saveCharacter(c); err != nil {
+			c.log.Error(err.Error())
+		}
+		saves.Flush()
+		itemAudit.Flush()
+		snapshotCharacter(c)
+	}
+	if c.game != nil {
+		c.game.Leave(c)
+	}
+	if c.lobby != nil {
+		c.lobby.Leave(c)
+	}
+}
+
+// Send the client the list of games that can be joined.
+func (server *BlockServer) sendGameList(client *Client) error {
+	pkt := &packets.GameListPacket{Header: packets.BBHeader{Type: packets.GameListType}}
+	// The first entry is the menu's title and isn't selectable.
+	title := packets.GameMenuEntry{MenuId: uint32(GameSelectionMenuId), Flags: 0x04}
+	copyUtf16(title.Name[:], util.ConvertToUtf16(config.ShipName))
+	pkt.Entries = append(pkt.Entries, title)
+
+	for _, g := range games.List() {
+		entry := packets.GameMenuEntry{
+			MenuId:     uint32(GameSelectionMenuId),
+			GameId:     g.id,
+			Difficulty: 0x22 + g.difficulty,
+			NumPlayers: uint8(len(g.Clients())),
+			Name:       g.name,
+			Episode:    g.episode,
+		}
+		if g.password[0] != 0 {
+			entry.Flags |= 0x02
+		}
+		if g.challengeMode != 0 {
+			entry.Flags |= 0x20
+		}
+		pkt.Entries = append(pkt.Entries, entry)
+	}
+	pkt.Header.Flags = uint32(len(pkt.Entries) - 1)
+	client.log.Debug("Sending Game List Packet")
+	return EncryptAndSend(client, pkt)
+}
+
+// Ask the client to send us its character data.
+func (server *BlockServer) sendCharDataRequest(client *Client) error {
+	pkt := &packets.BBHeader{Type: packets.CharDataRequestType}
+	client.log.Debug("Sending Character Data Request")
+	return EncryptAndSend(client, pkt)
+}
+
+// The player picked a different block (or the ship) from the block menu.
+func (server *BlockServer) HandleBlockSelection(c *Client, pkt packets.MenuSelectionPacket) error {
+	port, _ := strconv.ParseUint(config.ShipPort, 10, 16)
+	selectedBlock := pkt.ItemId
+	if selectedBlock == BackMenuItem {
+		// Send them back to the ship so that they're presented with the ship list.
+		selectedBlock = 0
+	} else if selectedBlock < 1 || int(selectedBlock) > config.NumBlocks {
+		return fmt.Errorf("Block selection %v out of range %v", selectedBlock, config.NumBlocks)
+	}
+	target := "ship"
+	if selectedBlock > 0 {
+		target = fmt.Sprintf("block%d", selectedBlock)
+	}
+	return SendRedirect(c, redirectIP(c, target), uint16(uint32(port)+selectedBlock))
+}
+
+func (server *BlockServer) sendSecurity(client *Client, errorCode packets.BBLoginError,
 	guildcard uint32, teamId uint32) error {
 	// Constants set according to how Newserv does it.
-	pkt := &SecurityPacket{
-		Header:       BBHeader{Type: LoginSecurityType},
+	pkt := &packets.SecurityPacket{
+		Header:       packets.BBHeader{Type: packets.LoginSecurityType},
 		ErrorCode:    uint32(errorCode),
 		PlayerTag:    0x00010000,
 		Guildcard:    guildcard,
@@ -91,20 +745,18 @@ func (server *BlockServer) sendSecurity(client *Client, errorCode BBLoginError,
 		Capabilities: 0x00000102,
 	}
 
-	DebugLog("Sending Security Packet")
+	client.log.Debug("Sending Security Packet")
 	return EncryptAndSend(client, pkt)
 }
 
 // Send the client the block list on the selection screen.
 func (server *BlockServer) sendBlockList(client *Client) error {
-	//data, size := util.BytesFromStruct(pkt)
-	DebugLog("Sending Block Packet - NOT IMPLEMENTED")
-	//return client.SendEncrypted(data, size)
-	return nil
+	client.log.Debug("Sending Block Packet")
+	return EncryptAndSend(client, server.blockPkt)
 }
 
 // Send the client the lobby list on the selection screen.
 func (server *BlockServer) sendLobbyList(client *Client) error {
-	DebugLog("Sending Lobby List Packet")
+	client.log.Debug("Sending Lobby List Packet")
 	return EncryptAndSend(client, server.lobbyPkt)
 }
@@ -0,0 +1,221 @@
+/*
+* Optional cache for the data that's loaded over and over as players go back and
+* forth through the character select screen: the previews of their characters,
+* their key configs, and their guildcard lists. Entries are keyed by guildcard,
+* dropped whenever the data behind them is written, and otherwise expire after
+* db_cache_ttl_seconds.
+*
+* The memory backend keeps the most recently used entries in the process, which
+* suits running all of the servers together. When the login and ship servers
+* run in separate processes the block servers can't drop the character server's
+* entries, so the options and guildcards that players change on a ship are
+* stale at character select until they expire. The redis backend keeps the
+* entries on the Redis server from shipgate_server, where every process sees
+* (and drops) the same ones.
+ */
+package main
+
+import (
+	"container/list"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dcrodman/archon/data"
+	"github.com/gomodule/redigo/redis"
+)
+
+// Settings for db_cache.
+const (
+	CacheBackendOff    = "off"
+	CacheBackendMemory = "memory"
+	CacheBackendRedis  = "redis"
+)
+
+// Prefix of the keys under which the entries are kept.
+const cacheKeyPrefix = "archon:cache:"
+
+// cacheStore holds encoded entries until they expire.
+type cacheStore interface {
+	// Get returns the value for key, or false if it isn't cached. The value
+	// mustn't be modified.
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte)
+	Delete(keys ...string)
+}
+
+// Cache for the configured db_cache, or nil if it's off.
+var characterCache cacheStore
+
+// Create the cache for the configured db_cache.
+func newCharacterCache() (cacheStore, error) {
+	ttl := time.Duration(config.DBCacheTTLSeconds) * time.Second
+	switch config.DBCache {
+	case CacheBackendMemory:
+		return newMemoryCache(config.DBCacheSize, ttl), nil
+	case CacheBackendRedis:
+		store := &redisCache{pool: newRedisPool(config.RedisAddress, config.RedisPassword, config.RedisDB), ttl: ttl}
+		conn := store.pool.Get()
+		defer conn.Close()
+		if _, err := conn.Do("PING"); err != nil {
+			return nil, errors.New("Failed to connect to Redis at " + config.RedisAddress + ": " + err.Error())
+		}
+		return store, nil
+	}
+	return nil, nil
+}
+
+func previewCacheKey(guildcard uint32, slotNum uint32) string {
+	return cacheKeyPrefix + "preview:" + strconv.FormatUint(uint64(guildcard), 10) + ":" +
+		strconv.FormatUint(uint64(slotNum), 10)
+}
+
+func optionsCacheKey(guildcard uint32) string {
+	return cacheKeyPrefix + "options:" + strconv.FormatUint(uint64(guildcard), 10)
+}
+
+func guildcardCacheKey(guildcard uint32) string {
+	return cacheKeyPrefix + "guildcards:" + strconv.FormatUint(uint64(guildcard), 10)
+}
+
+func cacheGet(key string) ([]byte, bool) {
+	if characterCache == nil {
+		return nil, false
+	}
+	return characterCache.Get(key)
+}
+
+func cacheSet(key string, value []byte) {
+	if characterCache != nil {
+		characterCache.Set(key, value)
+	}
+}
+
+// Drop entries after the data behind them has been written.
+func cacheDelete(keys ...string) {
+	if characterCache != nil {
+		characterCache.Delete(keys...)
+	}
+}
+
+// Returns the store to read the data for an entry from. Without a cache that
+// can be the replica, but otherwise it's the primary, since a read from a
+// replica that hasn't caught up with a write would put the old data back in
+// the cache for as long as it lasts.
+func cacheSource(c *Client) data.Store {
+	if characterCache == nil {
+		return c.db().Replica()
+	}
+	return c.db()
+}
+
+// Synchronized LRU list of entries, most recently used first, holding up to
+// size of them.
+type memoryCache struct {
+	size    int
+	ttl     time.Duration
+	entries map[string]*list.Element
+	order   *list.List
+	sync.Mutex
+}
+
+type memoryCacheEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+func newMemoryCache(size int, ttl time.Duration) *memoryCache {
+	return &memoryCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+func (m *memoryCache) Get(key string) ([]byte, bool) {
+	m.Lock()
+	defer m.Unlock()
+	e, ok := m.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := e.Value.(*memoryCacheEntry)
+	if time.Now().After(entry.expires) {
+		m.order.Remove(e)
+		delete(m.entries, key)
+		return nil, false
+	}
+	m.order.MoveToFront(e)
+	return entry.value, true
+}
+
+func (m *memoryCache) Set(key string, value []byte) {
+	m.Lock()
+	defer m.Unlock()
+	entry := &memoryCacheEntry{key: key, value: value, expires: time.Now().Add(m.ttl)}
+	if e, ok := m.entries[key]; ok {
+		e.Value = entry
+		m.order.MoveToFront(e)
+		return
+	}
+	m.entries[key] = m.order.PushFront(entry)
+	for m.order.Len() > m.size {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.entries, oldest.Value.(*memoryCacheEntry).key)
+	}
+}
+
+func (m *memoryCache) Delete(keys ...string) {
+	m.Lock()
+	defer m.Unlock()
+	for _, key := range keys {
+		if e, ok := m.entries[key]; ok {
+			m.order.Remove(e)
+			delete(m.entries, key)
+		}
+	}
+}
+
+// Entries kept in Redis, which expires them itself. As with the presence store,
+// failures to reach Redis are logged and treated as misses.
+type redisCache struct {
+	pool *redis.Pool
+	ttl  time.Duration
+}
+
+func (r *redisCache) Get(key string) ([]byte, bool) {
+	conn := r.pool.Get()
+	defer conn.Close()
+	value, err := redis.Bytes(conn.Do("GET", key))
+	if err == redis.ErrNil {
+		return nil, false
+	} else if err != nil {
+		log.Warn("Failed to look up cache entry in Redis: " + err.Error())
+		return nil, false
+	}
+	return value, true
+}
+
+func (r *redisCache) Set(key string, value []byte) {
+	conn := r.pool.Get()
+	defer conn.Close()
+	if _, err := conn.Do("SET", key, value, "PX", int64(r.ttl/time.Millisecond)); err != nil {
+		log.Warn("Failed to save cache entry to Redis: " + err.Error())
+	}
+}
+
+func (r *redisCache) Delete(keys ...string) {
+	conn := r.pool.Get()
+	defer conn.Close()
+	args := make([]interface{}, len(keys))
+	for i, key := range keys {
+		args[i] = key
+	}
+	if _, err := conn.Do("DEL", args...); err != nil {
+		log.Warn("Failed to remove cache entries from Redis: " + err.Error())
+	}
+}
@@ -0,0 +1,224 @@
+/*
+* Packet captures for protocol debugging. Every packet sent to or received from
+* a captured client is written (decrypted) to a JSON lines file for that
+* connection in the capture directory. Which clients are captured is set in the
+* capture section of the config and can be changed with "archon reload", which
+* starts or stops capturing any clients that are already connected. Passwords,
+* access keys, and game passwords in the packets that clients send are blanked
+* out, both in the files and in the packets dumped in debug mode.
+ */
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"github.com/dcrodman/archon/packets"
+	"github.com/dcrodman/archon/util"
+)
+
+// Directions recorded for captured packets.
+const (
+	captureIn  = "in"
+	captureOut = "out"
+)
+
+// One line of a capture file.
+type capturedPacket struct {
+	Time      time.Time `json:"time"`
+	Direction string    `json:"direction"`
+	Conn      string    `json:"conn"`
+	Guildcard uint32    `json:"guildcard,omitempty"`
+	Type      uint16    `json:"type"`
+	Size      int       `json:"size"`
+	Data      string    `json:"data"`
+}
+
+// Part of a packet that holds a password.
+type secretField struct {
+	offset, size int
+}
+
+// Kinds of connection whose packets have secrets in them. The packet types
+// overlap between them.
+const (
+	secretsBB = iota
+	secretsPatch
+	secretsPC
+	secretsGC
+)
+
+// Where the secrets are in the packets that clients send, by the kind of
+// connection and packet type.
+var packetSecrets = map[int]map[uint16][]secretField{
+	secretsBB: {
+		packets.LoginType:      fieldsOf(&packets.LoginPkt{}, "Password"),
+		packets.GameCreateType: fieldsOf(&packets.GameCreatePacket{}, "Password"),
+		// Only selections from the game list are long enough to have one.
+		packets.MenuSelectType: fieldsOf(&packets.GameMenuSelectionPacket{}, "Password"),
+	},
+	secretsPatch: {
+		// The patch server doesn't read it, but the client sends its username
+		// and password after 12 unused bytes.
+		packets.PatchLoginType: {{packets.PCHeaderSize + 12 + 16, 16}},
+	},
+	secretsPC: {
+		packets.PCLicenseType: fieldsOf(&packets.PCLicensePkt{}, "V1AccessKey", "AccessKey", "AccessKey2"),
+		packets.PCLoginType:   fieldsOf(&packets.LegacyLoginPkt{}, "V1AccessKey", "AccessKey", "AccessKey2"),
+	},
+	secretsGC: {
+		packets.GCVerifyLicenseType: fieldsOf(&packets.GCVerifyLicensePkt{}, "AccessKey", "AccessKey2", "Password"),
+		packets.GCLoginType:         fieldsOf(&packets.LegacyLoginPkt{}, "V1AccessKey", "AccessKey", "AccessKey2"),
+	},
+}
+
+// Returns where the named fields of the packet pkt points to are once it's
+// encoded.
+func fieldsOf(pkt interface{}, names ...string) []secretField {
+	t := reflect.TypeOf(pkt).Elem()
+	var fields []secretField
+	for _, name := range names {
+		offset := 0
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			size := binary.Size(reflect.New(field.Type).Elem().Interface())
+			if field.Name == name {
+				fields = append(fields, secretField{offset, size})
+				break
+			}
+			offset += size
+		}
+	}
+	return fields
+}
+
+// Returns a copy of a packet that c sent with its secrets blanked out, or the
+// packet itself if it doesn't have any.
+func redactSecrets(c *Client, data []byte) []byte {
+	kind := secretsBB
+	switch {
+	case c.version == VersionPC:
+		kind = secretsPC
+	case c.version == VersionGC:
+		kind = secretsGC
+	case c.hdrSize == packets.PCHeaderSize:
+		kind = secretsPatch
+	}
+	fields := packetSecrets[kind][c.packetType(data)]
+	if len(fields) == 0 {
+		return data
+	}
+	data = append([]byte(nil), data...)
+	for _, field := range fields {
+		for i := field.offset; i < field.offset+field.size && i < len(data); i++ {
+			data[i] = '*'
+		}
+	}
+	return data
+}
+
+type packetCapture struct {
+	file    *os.File
+	encoder *json.Encoder
+}
+
+// Returns whether the config says that c's packets should be captured.
+func shouldCapture(c *Client) bool {
+	cfg := config.Captures()
+	if cfg.CaptureAll {
+		return true
+	}
+	for _, ip := range cfg.CaptureIPs {
+		if ip == c.IPAddr() {
+			return true
+		}
+	}
+	for _, guildcard := range cfg.CaptureGuildcards {
+		if c.guildcard != 0 && guildcard == c.guildcard {
+			return true
+		}
+	}
+	return false
+}
+
+// Start or stop capturing c's packets to match the config.
+func updateCapture(c *Client) {
+	if shouldCapture(c) {
+		if err := c.startCapture(); err != nil {
+			c.log.Error("Failed to start packet capture: " + err.Error())
+		}
+	} else {
+		c.stopCapture()
+	}
+}
+
+// Open a new capture file for the client, if it isn't already being captured.
+func (c *Client) startCapture() error {
+	c.captureLock.Lock()
+	defer c.captureLock.Unlock()
+	if c.capture != nil {
+		return nil
+	}
+	dir := config.Captures().CaptureDir
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	filename := filepath.Join(dir, fmt.Sprintf("%s-%s.jsonl",
+		time.Now().Format("20060102-150405"), c.id))
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	c.capture = &packetCapture{file: f, encoder: json.NewEncoder(f)}
+	c.log.Info("Capturing packets to " + filename)
+	return nil
+}
+
+func (c *Client) stopCapture() {
+	c.captureLock.Lock()
+	defer c.captureLock.Unlock()
+	if c.capture != nil {
+		c.capture.file.Close()
+		c.capture = nil
+		c.log.Info("Stopped capturing packets")
+	}
+}
+
+// Record a decrypted packet sent to or received from the client. Packets are
+// also dumped to stdout in debug mode.
+func (c *Client) capturePacket(direction string, data []byte) {
+	if direction == captureIn {
+		data = redactSecrets(c, data)
+	}
+	if config.Debugging() {
+		fmt.Printf("%s: %s %v bytes (%s):\n", c.id, direction, len(data), c.IPAddr())
+		util.PrintPayload(data, len(data))
+		fmt.Println()
+	}
+
+	c.captureLock.Lock()
+	defer c.captureLock.Unlock()
+	if c.capture == nil {
+		return
+	}
+	pkt := capturedPacket{
+		Time:      time.Now(),
+		Direction: direction,
+		Conn:      c.id,
+		Guildcard: c.guildcard,
+		Type:      c.packetType(data),
+		Size:      len(data),
+		Data:      hex.EncodeToString(data),
+	}
+	if err := c.capture.encoder.Encode(&pkt); err != nil {
+		c.log.Error("Failed to write packet capture: " + err.Error())
+		c.capture.file.Close()
+		c.capture = nil
+	}
+}
@@ -0,0 +1,335 @@
+/*
+* Challenge mode. The stages are quests with a challenge_stage in their manifest
+* entry, which are only offered in challenge mode games (and are the only quests
+* offered in them). The server times each stage from when everyone has loaded it
+* until a player reports that it was cleared, and keeps each character's best
+* time for every stage.
+*
+* The ranks that can be earned in each episode are listed in the challenge file,
+* best first, with the time each stage has to be cleared in (in seconds) to earn
+* the rank, the title given for it, and the prize weapon that comes with it:
+*
+*	{
+*		"ep1": {
+*			"min_times": [300, 300, 420, 420, 540, 540, 600, 600, 900],
+*			"ranks": [
+*				{
+*					"title": "Master",
+*					"color": 31744,
+*					"item": "002c00",
+*					"times": [900, 900, 1200, 1200, 1500, 1500, 1800, 1800, 2400]
+*				}
+*			]
+*		}
+*	}
+*
+* A rank is earned once the character's best times for all of the episode's
+* stages are within its times, and its prize is given the first time it's
+* earned. The character takes on the title (and its color, in the client's
+* RGB555) of the best rank they've earned most recently.
+*
+* Each episode also lists the shortest time that each of its stages can
+* plausibly be cleared in. A clear reported any sooner is ignored, since
+* otherwise a client could report one as soon as the stage starts and take
+* every rank.
+ */
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dcrodman/archon/data"
+	"github.com/dcrodman/archon/packets"
+)
+
+const (
+	// Longest rank title, leaving room for the language marker.
+	MaxChallengeTitle = 10
+	// Most ranks that can be listed for an episode, since whether each one has
+	// been awarded is kept in a bit field.
+	MaxChallengeRanks = 32
+)
+
+type challengeRank struct {
+	Title string `json:"title"`
+	Color uint16 `json:"color"`
+	// Prize weapon in hex.
+	Item  string   `json:"item"`
+	Times []uint32 `json:"times"`
+	// Item data padded to 12 bytes.
+	data []byte
+	// Position in the episode's list, which is its bit in the awards.
+	index uint
+}
+
+type challengeEpisode struct {
+	// Shortest believable clear time for each stage, in seconds.
+	MinTimes []uint32        `json:"min_times"`
+	Ranks    []challengeRank `json:"ranks"`
+}
+
+type challengeFile struct {
+	Ep1 challengeEpisode `json:"ep1"`
+	Ep2 challengeEpisode `json:"ep2"`
+}
+
+// Synchronized set of the ranks loaded from the challenge file.
+type challengeList struct {
+	file *challengeFile
+	sync.RWMutex
+}
+
+var challenges = &challengeList{file: new(challengeFile)}
+
+// Load the ranks from the file at path, replacing the ones loaded before. No
+// ranks can be earned if the file doesn't exist.
+func (cl *challengeList) Load(path string) error {
+	file := new(challengeFile)
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		log.Warnf("Challenge file %s doesn't exist; challenge mode won't award anything", path)
+	} else if err != nil {
+		return err
+	} else {
+		decoder := json.NewDecoder(bytes.NewReader(contents))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(file); err != nil {
+			return fmt.Errorf("invalid %s: %s", path, err.Error())
+		}
+		if err := file.prepare(); err != nil {
+			return fmt.Errorf("invalid %s: %s", path, err.Error())
+		}
+	}
+
+	cl.Lock()
+	cl.file = file
+	cl.Unlock()
+	return nil
+}
+
+// Check the file and decode its prizes.
+func (f *challengeFile) prepare() error {
+	for episode := uint8(1); episode <= 2; episode++ {
+		ranks := f.episode(episode).Ranks
+		if len(ranks) > MaxChallengeRanks {
+			return fmt.Errorf("episodes can't have more than %d ranks", MaxChallengeRanks)
+		} else if len(ranks) > 0 && len(f.episode(episode).MinTimes) != challengeStages(episode) {
+			return fmt.Errorf("episode %d needs a min_times entry for each of its %d stages",
+				episode, challengeStages(episode))
+		}
+		for i := range ranks {
+			rank := &ranks[i]
+			rank.index = uint(i)
+			if len([]rune(rank.Title)) > MaxChallengeTitle {
+				return fmt.Errorf("title %q is longer than %d characters", rank.Title, MaxChallengeTitle)
+			} else if len(rank.Times) != challengeStages(episode) {
+				return fmt.Errorf("rank %q needs a time for each of the %d stages", rank.Title, challengeStages(episode))
+			} else if rank.Item == "" {
+				continue
+			}
+			itemData, err := parseDropItem(rank.Item)
+			if err != nil {
+				return err
+			}
+			rank.data = make([]byte, 12)
+			copy(rank.data, itemData)
+		}
+	}
+	return nil
+}
+
+func (f *challengeFile) episode(episode uint8) *challengeEpisode {
+	if episode == 2 {
+		return &f.Ep2
+	}
+	return &f.Ep1
+}
+
+// Award returns the ranks in an episode that a record has earned since they were
+// last awarded, marking them as awarded and changing the title to the best one.
+func (cl *challengeList) Award(episode uint8, record *data.ChallengeRecord) []challengeRank {
+	cl.RLock()
+	defer cl.RUnlock()
+	var earned []challengeRank
+	times := record.Times(episode)
+	for _, rank := range cl.file.episode(episode).Ranks {
+		if record.Awards[episode-1]&(1<<rank.index) != 0 || !rank.earned(times) {
+			continue
+		}
+		record.Awards[episode-1] |= 1 << rank.index
+		if len(earned) == 0 {
+			record.Title = rank.Title
+			record.TitleColor = rank.Color
+		}
+		earned = append(earned, rank)
+	}
+	return earned
+}
+
+// MinTime returns the shortest time that a stage of an episode can be cleared
+// in, or 0 if there isn't one.
+func (cl *challengeList) MinTime(episode uint8, stage uint8) uint32 {
+	cl.RLock()
+	defer cl.RUnlock()
+	minTimes := cl.file.episode(episode).MinTimes
+	if stage == 0 || int(stage) > len(minTimes) {
+		return 0
+	}
+	return minTimes[stage-1]
+}
+
+// Whether each stage was cleared within the rank's time.
+func (rank *challengeRank) earned(times []uint32) bool {
+	for stage, limit := range rank.Times {
+		if times[stage] == 0 || times[stage] > limit {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the number of challenge mode stages in an episode (1-3).
+func challengeStages(episode uint8) int {
+	switch episode {
+	case 1:
+		return data.NumChallengeStagesEp1
+	case 2:
+		return data.NumChallengeStagesEp2
+	}
+	return 0
+}
+
+// Start timing the stage being played in a challenge mode game.
+func (g *Game) startStage() {
+	g.questLock.Lock()
+	g.stageStarted = time.Now()
+	g.questLock.Unlock()
+}
+
+// End the stage being played, returning how long it took to clear or false if
+// it hasn't started or has already been cleared.
+func (g *Game) clearStage() (time.Duration, bool) {
+	g.questLock.Lock()
+	defer g.questLock.Unlock()
+	if g.stageStarted.IsZero() || g.stageCleared {
+		return 0, false
+	}
+	g.stageCleared = true
+	return time.Since(g.stageStarted), true
+}
+
+// Someone in a challenge mode game cleared the stage. Everyone in the game gets
+// the time the server measured, whatever the clients think it was.
+func (server *BlockServer) HandleChallengeStageClear(c *Client) error {
+	var pkt packets.ChallengeStageClearPacket
+	if err := c.Decode(&pkt); err != nil {
+		return err
+	}
+	g := c.game
+	if g == nil || g.challengeMode == 0 {
+		return nil
+	}
+	q := g.Quest()
+	if q == nil || q.challengeStage == 0 {
+		return nil
+	}
+	elapsed, ok := g.clearStage()
+	if !ok {
+		return nil
+	}
+	seconds := uint32(elapsed / time.Second)
+	if seconds == 0 {
+		seconds = 1
+	}
+	stage := q.challengeStage
+	if min := challenges.MinTime(g.episode, stage); seconds < min {
+		c.log.Warnf("Ignoring clear of challenge stage %d of episode %d by guildcard %d in %d seconds; "+
+			"it takes at least %d", stage, g.episode, c.guildcard, seconds, min)
+		return nil
+	}
+	c.log.Infof("Challenge stage %d of episode %d cleared in game %d in %d seconds",
+		stage, g.episode, g.id, seconds)
+	for _, p := range g.Clients() {
+		p := p
+		p.Queue(func() {
+			if p.game == g {
+				g.recordStageTime(p, stage, seconds)
+			}
+		})
+	}
+	return nil
+}
+
+// Update a player's best time for a stage, giving them any ranks they've earned.
+// Runs on the player's own goroutine.
+func (g *Game) recordStageTime(c *Client, stage uint8, seconds uint32) {
+	if c.challenge == nil {
+		return
+	}
+	// Prizes can't be added to an inventory that's being traded away.
+	trading := c.trading()
+	record := *c.challenge
+	times := record.Times(g.episode)
+	if int(stage) > len(times) {
+		return
+	}
+	best := times[stage-1] == 0 || seconds < times[stage-1]
+	if best {
+		times[stage-1] = seconds
+	}
+
+	var prizes []data.Item
+	earned := challenges.Award(g.episode, &record)
+	for _, rank := range earned {
+		if rank.data == nil {
+			continue
+		}
+		if trading {
+			// Left unawarded so that it's given the next time a stage is cleared.
+			record.Awards[g.episode-1] &^= 1 << rank.index
+			SendClientMessage(c, "Finish your trade to receive the prize for "+rank.Title+".")
+			continue
+		} else if len(c.inventory)+len(prizes) >= MaxInventoryItems {
+			// Left unawarded so that it's given the next time a stage is cleared.
+			record.Awards[g.episode-1] &^= 1 << rank.index
+			SendClientMessage(c, "Make room in your inventory to receive the prize for "+rank.Title+".")
+			continue
+		}
+		prize := newDropItem(rank.data)
+		prize.ItemId = c.newItemId()
+		prizes = append(prizes, prize)
+	}
+
+	*c.challenge = record
+	c.inventory = append(c.inventory, prizes...)
+	// The prizes are saved along with the awards that they were given for.
+	save := characterSaveOf(c)
+	save.Challenge = c.challenge
+	c.lastSave = time.Now()
+	if err := saves.Queue(save); err != nil {
+		c.log.Errorf("Failed to queue challenge record save for guildcard %d: %s", c.guildcard, err.Error())
+	}
+	for _, prize := range prizes {
+		auditItem(c, data.ItemAuditPrize, &prize, 0, 0)
+		g.Broadcast(&packets.CreateInventoryItemPacket{
+			Header:    packets.BBHeader{Type: packets.GameCommandType},
+			SubHeader: packets.SubCmdHeader{Type: packets.SubCmdCreateInventoryItem, Size: 7, ClientId: uint16(c.clientId)},
+			Item:      newItemData(prize),
+		}, nil)
+	}
+
+	message := fmt.Sprintf("Stage cleared in %d:%02d.", seconds/60, seconds%60)
+	if best {
+		message += "\nThat's your best time!"
+	}
+	if len(earned) > 0 {
+		message += "\nYou've earned the title " + record.Title + "."
+	}
+	SendClientMessage(c, message)
+}
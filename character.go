@@ -1,16 +1,16 @@
 package main
 
 import (
-	"errors"
+	"encoding/json"
 	"fmt"
 	"hash/crc32"
-	"io/ioutil"
 	"net"
-	"os"
 	"syscall"
 	"time"
+	"unicode/utf16"
 
-	"github.com/dcrodman/archon/prs"
+	"github.com/dcrodman/archon/data"
+	"github.com/dcrodman/archon/packets"
 	"github.com/dcrodman/archon/util"
 )
 
@@ -22,213 +22,219 @@ const (
 	// Id sent in the menu selection packet to tell the client
 	// that the selection was made on the ship menu.
 	ShipSelectionMenuId uint16 = 0x13
+	// Number of character slots available to each account.
+	NumCharacterSlots = 4
+	// How often characters past the deletion retention window are purged.
+	characterPurgeInterval = time.Hour
 )
 
-var (
-	// Connected ships. Each Ship's id corresponds to its position in the array - 1.
-	shipList []Ship = make([]Ship, 1)
-
-	// Parameter files we're expecting. I still don't really know what they're
-	// for yet, so emulating what I've seen others do.
-	paramFiles = []string{
-		"ItemMagEdit.prs",
-		"ItemPMT.prs",
-		"BattleParamEntry.dat",
-		"BattleParamEntry_on.dat",
-		"BattleParamEntry_lab.dat",
-		"BattleParamEntry_lab_on.dat",
-		"BattleParamEntry_ep4.dat",
-		"BattleParamEntry_ep4_on.dat",
-		"PlyLevelTbl.prs",
-	}
+// Settings for unique_character_names.
+const (
+	UniqueNamesOff     = "off"
+	UniqueNamesServer  = "server"
+	UniqueNamesAccount = "account"
 )
 
-// Entry in the available ships lis on the ship selection menu.
-type ShipMenuEntry struct {
-	MenuId  uint16
-	ShipId  uint32
-	Padding uint16
-
-	Shipname [23]byte
-}
-
-// Per-character stats as stored in config files.
-type CharacterStats struct {
-	ATP uint16
-	MST uint16
-	EVP uint16
-	HP  uint16
-	DFP uint16
-	ATA uint16
-	LCK uint16
-}
-
 type CharacterServer struct {
-	// Cached parameter data to avoid computing it every time.
-	paramHeaderData []byte
-	paramChunkData  map[int][]byte
+	parameters *parameterManager
 
 	// Starting stats for any new character. The CharClass constants can be used
 	// to index into this array to obtain the base stats for each class.
-	BaseStats [12]CharacterStats
+	BaseStats [NumClasses]packets.CharacterStats
 }
 
 func (server CharacterServer) Name() string { return "CHARACTER" }
 
 func (server CharacterServer) Port() string { return config.CharacterPort }
 
+func (server CharacterServer) RateLimited() {}
+
+var characterPacketSizes = map[uint16]int{
+	packets.LoginType:                  packetSize(&packets.LoginPkt{}),
+	packets.LoginCharPreviewReqType:    packetSize(&packets.CharSelectionPacket{}),
+	packets.LoginChecksumType:          packetSize(&packets.ChecksumPacket{}),
+	packets.LoginGuildcardChunkReqType: packetSize(&packets.GuildcardChunkReqPacket{}),
+	packets.LoginSetFlagType:           packetSize(&packets.SetFlagPacket{}),
+	packets.LoginCharPreviewType:       packetSize(&packets.CharPreviewPacket{Character: new(packets.CharacterPreview)}),
+	packets.MenuSelectType:             packetSize(&packets.MenuSelectionPacket{}),
+}
+
+func (server CharacterServer) MinPacketSizes() map[uint16]int { return characterPacketSizes }
+
 func (server *CharacterServer) Init() error {
-	if err := server.loadParameterFiles(); err != nil {
+	fmt.Printf("Loading parameters from %s...\n", config.ParametersDir)
+	server.parameters = new(parameterManager)
+	if err := server.parameters.Load(); err != nil {
 		return err
 	}
+	go server.parameters.watch()
 
 	// Load the base stats for creating new characters. Newserv, Sylverant, and Tethealla
 	// all seem to rely on this file, so we'll do the same.
-	paramDir := config.ParametersDir
-	statsFile, _ := os.Open(paramDir + "/PlyLevelTbl.prs")
-	compressed, err := ioutil.ReadAll(statsFile)
+	table, err := loadLevelTable(config.ParametersDir)
 	if err != nil {
-		return errors.New("Error reading stats file: " + err.Error())
+		return err
 	}
-
-	decompressedSize := prs.DecompressSize(compressed)
-	decompressed := make([]byte, decompressedSize)
-	prs.Decompress(compressed, decompressed)
-
-	for i := 0; i < 12; i++ {
-		util.StructFromBytes(decompressed[i*14:], &server.BaseStats[i])
+	server.BaseStats = table.BaseStats
+	if err := nameFilter.Load(config.NameFilterFile); err != nil {
+		return fmt.Errorf("Error loading name filter: %s", err.Error())
+	}
+	if err := infoPages.Load(config.InfoFile); err != nil {
+		return fmt.Errorf("Error loading information pages: %s", err.Error())
+	}
+	if err := syncCharacterNameKeys(); err != nil {
+		return fmt.Errorf("Error updating character name keys: %s", err.Error())
 	}
 
+	go server.purgeDeletedCharacters()
+
 	fmt.Println()
 	return nil
 }
 
-// Load the PSOBB parameter files, build the parameter header,
-// and init/cache the param file chunks for the EB packets.
-func (server *CharacterServer) loadParameterFiles() error {
-	offset := 0
-	var tmpChunkData []byte
-
-	paramDir := config.ParametersDir
-	fmt.Printf("Loading parameters from %s...\n", paramDir)
-	for _, paramFile := range paramFiles {
-		data, err := ioutil.ReadFile(paramDir + "/" + paramFile)
-		if err != nil {
-			return errors.New("Error reading parameter file: " + err.Error())
-		}
-		fileSize := len(data)
-
-		entry := new(parameterEntry)
-		entry.Size = uint32(fileSize)
-		entry.Checksum = crc32.ChecksumIEEE(data)
-		entry.Offset = uint32(offset)
-		copy(entry.Filename[:], []uint8(paramFile))
-
-		offset += fileSize
-
-		// We don't care what the actual entries are for the packet, so just append
-		// the bytes to save us having to do the conversion every time.
-		bytes, _ := util.BytesFromStruct(entry)
-		server.paramHeaderData = append(server.paramHeaderData, bytes...)
-
-		tmpChunkData = append(tmpChunkData, data...)
-		fmt.Printf("%s (%v bytes, checksum: %v)\n", paramFile, fileSize, entry.Checksum)
+// Reload re-reads the parameter files, the name filter, and the information
+// pages so that they can be changed without a restart.
+func (server *CharacterServer) Reload() error {
+	if err := server.parameters.Load(); err != nil {
+		return err
 	}
-
-	// Offset should at this point be the total size of the files
-	// to send - break it all up into indexable chunks.
-	server.paramChunkData = make(map[int][]byte)
-	chunks := offset / MaxChunkSize
-	for i := 0; i < chunks; i++ {
-		dataOff := i * MaxChunkSize
-		server.paramChunkData[i] = tmpChunkData[dataOff : dataOff+MaxChunkSize]
-		offset -= MaxChunkSize
+	log.Infof("Loaded parameter files from %s", config.ParametersDir)
+	if err := nameFilter.Load(config.NameFilterFile); err != nil {
+		return err
 	}
-	// Add any remaining data
-	if offset > 0 {
-		server.paramChunkData[chunks] = tmpChunkData[chunks*MaxChunkSize:]
+	return infoPages.Load(config.InfoFile)
+}
+
+// Loop for the life of the server, permanently removing characters that were
+// deleted longer ago than the configured retention window.
+func (server *CharacterServer) purgeDeletedCharacters() {
+	for {
+		cutoff := time.Now().AddDate(0, 0, -config.DeletedCharacterDays)
+		if n, err := database.PurgeDeletedCharacters(cutoff); err != nil {
+			log.Error("Failed to purge deleted characters: " + err.Error())
+		} else if n > 0 {
+			log.Infof("Purged %d deleted characters", n)
+		}
+		time.Sleep(characterPurgeInterval)
 	}
-	return nil
 }
 
-func (server *CharacterServer) NewClient(conn *net.TCPConn) (*Client, error) {
+func (server *CharacterServer) NewClient(conn net.Conn) (*Client, error) {
 	return NewLoginClient(conn)
 }
 
 func (server *CharacterServer) Handle(c *Client) error {
-	var hdr BBHeader
-	util.StructFromBytes(c.Data()[:BBHeaderSize], &hdr)
+	var hdr packets.BBHeader
+	if err := c.Decode(&hdr); err != nil {
+		return err
+	}
 
 	var err error
 	switch hdr.Type {
-	case LoginType:
+	case packets.LoginType:
 		err = server.HandleCharLogin(c)
-	case LoginOptionsRequestType:
+	case packets.LoginOptionsRequestType:
 		err = server.HandleOptionsRequest(c)
-	case LoginCharPreviewReqType:
+	case packets.LoginCharPreviewReqType:
 		err = server.HandleCharacterSelect(c)
-	case LoginChecksumType:
-		// Everybody else seems to ignore this, so...
-		err = server.sendChecksumAck(c)
-	case LoginGuildcardReqType:
+	case packets.LoginChecksumType:
+		err = server.HandleChecksum(c)
+	case packets.LoginGuildcardReqType:
 		err = server.HandleGuildcardDataStart(c)
-	case LoginGuildcardChunkReqType:
-		server.HandleGuildcardChunk(c)
-	case LoginParameterHeaderReqType:
-		err = server.sendParameterHeader(c, uint32(len(paramFiles)), server.paramHeaderData)
-	case LoginParameterChunkReqType:
-		var pkt BBHeader
-		util.StructFromBytes(c.Data(), &pkt)
-		err = server.sendParameterChunk(c, server.paramChunkData[int(pkt.Flags)], pkt.Flags)
-	case LoginSetFlagType:
-		var pkt SetFlagPacket
-		util.StructFromBytes(c.Data(), &pkt)
+	case packets.LoginGuildcardChunkReqType:
+		err = server.HandleGuildcardChunk(c)
+	case packets.LoginParameterHeaderReqType:
+		// The transfer holds on to the files so that a reload doesn't change
+		// them partway through the download.
+		params := server.parameters.ForVersion(c.loginVersion)
+		c.parameterTransfer = newChunkedTransfer("Parameter", params.chunks)
+		err = server.sendParameterHeader(c, uint32(params.numFiles), params.header)
+	case packets.LoginParameterChunkReqType:
+		var pkt packets.BBHeader
+		if err := c.Decode(&pkt); err != nil {
+			return err
+		}
+		err = server.HandleParameterChunk(c, pkt.Flags)
+	case packets.LoginSetFlagType:
+		var pkt packets.SetFlagPacket
+		if err := c.Decode(&pkt); err != nil {
+			return err
+		}
 		c.flag = pkt.Flag
-	case LoginCharPreviewType:
+	case packets.LoginCharPreviewType:
 		err = server.HandleCharacterUpdate(c)
-	case MenuSelectType:
-		err = server.HandleShipSelection(c)
-	case DisconnectType:
+	case packets.MenuSelectType:
+		var pkt packets.MenuSelectionPacket
+		if err := c.Decode(&pkt); err != nil {
+			return err
+		}
+		switch pkt.MenuId {
+		case ShipSelectionMenuId:
+			err = server.HandleShipSelection(c, pkt)
+		case InfoMenuId:
+			err = infoPages.HandleSelection(c, pkt, func() error {
+				return server.sendShipList(c, ships.List())
+			})
+		default:
+			err = fmt.Errorf("Unknown menu selection %x from %s", pkt.MenuId, c.IPAddr())
+		}
+	case packets.DisconnectType:
 		// Just wait until we recv 0 from the client to d/c.
 		break
 	default:
-		log.Infof("Received unknown packet %x from %s", hdr.Type, c.IPAddr())
+		c.log.Infof("Received unknown packet %x", hdr.Type)
 	}
 	return err
 }
 
 func (server *CharacterServer) HandleCharLogin(client *Client) error {
-	var err error
-	if pkt, err := VerifyAccount(client); err == nil {
-		err = server.sendSecurity(client, BBLoginErrorNone, client.guildcard, client.teamId)
-		if err != nil {
+	pkt, err := VerifyAccount(client)
+	if err != nil {
+		return err
+	}
+	client.loginVersion = pkt.ClientVersion
+	if err = verifySession(client); err != nil {
+		server.sendSecurity(client, packets.BBLoginErrorUnknown, client.guildcard, client.teamId)
+		return err
+	}
+	if err = admitSession(client); err != nil {
+		return err
+	}
+	if err = loadTeam(client); err != nil {
+		client.log.Error(err.Error())
+		return err
+	}
+	err = server.sendSecurity(client, packets.BBLoginErrorNone, client.guildcard, client.teamId)
+	if err != nil {
+		return err
+	}
+	// At this point, if we've chosen (or created) a character then the
+	// client will send us the slot number and the corresponding phase.
+	if pkt.SlotNum >= 0 && pkt.Phase == 4 {
+		if err = server.sendTimestamp(client); err != nil {
 			return err
 		}
-		// At this point, if we've chosen (or created) a character then the
-		// client will send us the slot number and the corresponding phase.
-		if pkt.SlotNum >= 0 && pkt.Phase == 4 {
-			if err = server.sendTimestamp(client); err != nil {
-				return err
-			}
-			if err = server.sendShipList(client, shipList); err != nil {
-				return err
-			}
-			if err = server.sendScrollMessage(client); err != nil {
-				return err
-			}
+		if err = infoPages.SendMOTD(client, int(pkt.SlotNum)); err != nil {
+			return err
+		}
+		if err = server.sendShipList(client, ships.List()); err != nil {
+			return err
+		}
+		if err = sendScrollMessage(client, int(pkt.SlotNum), false); err != nil {
+			return err
 		}
 	}
-	return err
+	return nil
 }
 
 // Send the security initialization packet with information about the user's
 // authentication status.
-func (server *CharacterServer) sendSecurity(client *Client, errorCode BBLoginError,
+func (server *CharacterServer) sendSecurity(client *Client, errorCode packets.BBLoginError,
 	guildcard uint32, teamId uint32) error {
 
 	// Constants set according to how Newserv does it.
-	pkt := &SecurityPacket{
-		Header:       BBHeader{Type: LoginSecurityType},
+	pkt := &packets.SecurityPacket{
+		Header:       packets.BBHeader{Type: packets.LoginSecurityType},
 		ErrorCode:    uint32(errorCode),
 		PlayerTag:    0x00010000,
 		Guildcard:    guildcard,
@@ -237,14 +243,14 @@ func (server *CharacterServer) sendSecurity(client *Client, errorCode BBLoginErr
 		Capabilities: 0x00000102,
 	}
 
-	DebugLog("Sending Security Packet")
+	client.log.Debug("Sending Security Packet")
 	return EncryptAndSend(client, pkt)
 }
 
 // Send a timestamp packet in order to indicate the server's current time.
 func (server *CharacterServer) sendTimestamp(client *Client) error {
-	pkt := new(TimestampPacket)
-	pkt.Header.Type = LoginTimestampType
+	pkt := new(packets.TimestampPacket)
+	pkt.Header.Type = packets.LoginTimestampType
 
 	var tv syscall.Timeval
 	syscall.Gettimeofday(&tv)
@@ -252,131 +258,203 @@ func (server *CharacterServer) sendTimestamp(client *Client) error {
 	stamp := fmt.Sprintf("%s.%03d", t, uint64(tv.Usec/1000))
 	copy(pkt.Timestamp[:], stamp)
 
-	DebugLog("Sending Timestamp Packet")
+	client.log.Debug("Sending Timestamp Packet")
 	return EncryptAndSend(client, pkt)
 }
 
 // Send the menu items for the ship select screen.
-func (server *CharacterServer) sendShipList(client *Client, ships []Ship) error {
-	pkt := &ShipListPacket{
-		Header:      BBHeader{Type: LoginShipListType, Flags: 0x01},
+func (server *CharacterServer) sendShipList(client *Client, ships []*Ship) error {
+	client.log.Debug("Sending Ship List Packet")
+	return EncryptAndSend(client, newShipListPacket(client, ships))
+}
+
+// Build the ship selection menu for the player on c, showing the population of
+// each ship next to its name, or whether it's full or locked to them. This is
+// shared by the character and ship servers since players can return to the
+// ship list from the block menu.
+func newShipListPacket(c *Client, ships []*Ship) *packets.ShipListPacket {
+	pkt := &packets.ShipListPacket{
+		Header:      packets.BBHeader{Type: packets.LoginShipListType, Flags: uint32(len(ships))},
 		Unknown:     0x02,
 		Unknown2:    0xFFFFFFF4,
 		Unknown3:    0x04,
-		ShipEntries: make([]ShipMenuEntry, len(ships)),
+		ShipEntries: make([]packets.ShipMenuEntry, len(ships)),
 	}
 	copy(pkt.ServerName[:], "Archon")
 
-	// TODO: Will eventually need a mutex for read.
 	for i, ship := range ships {
 		item := &pkt.ShipEntries[i]
 		item.MenuId = ShipSelectionMenuId
 		item.ShipId = ship.id
-		copy(item.Shipname[:], util.ConvertToUtf16(string(ship.name[:])))
+		name := string(util.StripPadding(ship.name[:]))
+		switch {
+		case ship.Locked(c):
+			name += " (Locked)"
+		case ship.Full():
+			name += " (Full)"
+		default:
+			name += fmt.Sprintf(" (%d)", ship.NumPlayers())
+		}
+		copy(item.Shipname[:], util.ConvertToUtf16(name))
 	}
-
-	DebugLog("Sending Ship List Packet")
-	return EncryptAndSend(client, pkt)
+	addInfoMenuItem(pkt)
+	return pkt
 }
 
-// Send whatever scrolling message was read out of the config file for the login screen.
-
-func (server *CharacterServer) sendScrollMessage(client *Client) error {
-	pkt := &ScrollMessagePacket{
-		Header:  BBHeader{Type: LoginScrollMessageType},
-		Message: config.ScrollMessageBytes(),
+// Load key config and other option data from the database or provide defaults for new accounts.
+func (server *CharacterServer) HandleOptionsRequest(client *Client) error {
+	playerOptions, err := loadPlayerOptions(client)
+	if err != nil {
+		client.log.Error(err.Error())
+		return err
 	}
-
-	data, size := util.BytesFromStruct(pkt)
-	// The end of the message appears to be garbled unless
-	// there is a block of extra bytes on the end; add an extra
-	// and let fixLength add the rest.
-	data = append(data, 0x00)
-	DebugLog("Sending Scroll Message Packet")
-	return client.SendEncrypted(data, size+1)
+	return server.sendOptions(client, playerOptions)
 }
 
-// Load key config and other option data from the database or provide defaults for new accounts.
-func (server *CharacterServer) HandleOptionsRequest(client *Client) error {
-	playerOptions, err := database.FindPlayerOptions(client.guildcard)
-	if playerOptions == nil {
-		// We don't have any saved key config - give them the defaults.
-		playerOptions = &PlayerOptions{
-			Guildcard: client.guildcard,
-			KeyConfig: make([]byte, 420),
+// Load the account's options, replacing them with the defaults if there aren't
+// any saved, they're from an older version of the defaults, or they're corrupt.
+// The options are cached as JSON.
+func loadPlayerOptions(client *Client) (*data.PlayerOptions, error) {
+	key := optionsCacheKey(client.guildcard)
+	if cached, ok := cacheGet(key); ok {
+		playerOptions := new(data.PlayerOptions)
+		if json.Unmarshal(cached, playerOptions) == nil {
+			return playerOptions, nil
 		}
-		copy(playerOptions.KeyConfig, baseKeyConfig[:])
-		database.UpdatePlayerOptions(playerOptions)
-	} else if err != nil {
-		log.Error(err.Error())
-		return err
 	}
-	return server.sendOptions(client, playerOptions.KeyConfig)
+
+	playerOptions, err := client.db().FindPlayerOptions(client.guildcard)
+	if err != nil {
+		return nil, err
+	}
+	if playerOptions == nil || playerOptions.Version < DefaultOptionsVersion ||
+		playerOptions.Validate() != nil {
+		playerOptions = defaultPlayerOptions(client.guildcard)
+		if err := client.db().UpdatePlayerOptions(playerOptions); err != nil {
+			return nil, err
+		}
+	}
+	if encoded, err := json.Marshal(playerOptions); err == nil {
+		cacheSet(key, encoded)
+	}
+	return playerOptions, nil
 }
 
-// Send the client's configuration options. keyConfig should be 420 bytes long and either
-// point to the default keys array or loaded from the database.
-func (server *CharacterServer) sendOptions(client *Client, keyConfig []byte) error {
-	if len(keyConfig) != 420 {
-		panic("Received keyConfig of length " + string(len(keyConfig)) + "; should be 420")
+// Send the client's configuration options.
+func (server *CharacterServer) sendOptions(client *Client, playerOptions *data.PlayerOptions) error {
+	pkt := &packets.OptionsPacket{
+		Header:          packets.BBHeader{Type: packets.LoginOptionsType},
+		PlayerKeyConfig: newKeyTeamConfig(client, playerOptions),
 	}
-	pkt := new(OptionsPacket)
-	pkt.Header.Type = LoginOptionsType
+	client.log.Debug("Sending Key Config Packet")
+	return EncryptAndSend(client, pkt)
+}
 
-	pkt.PlayerKeyConfig.Guildcard = client.guildcard
-	copy(pkt.PlayerKeyConfig.KeyConfig[:], keyConfig[:0x16C])
-	copy(pkt.PlayerKeyConfig.JoystickConfig[:], keyConfig[0x16C:])
+func newKeyTeamConfig(client *Client, playerOptions *data.PlayerOptions) packets.KeyTeamConfig {
+	cfg := packets.KeyTeamConfig{Guildcard: client.guildcard}
+	copy(cfg.KeyConfig[:], playerOptions.KeyConfig)
+	copy(cfg.JoystickConfig[:], playerOptions.JoystickConfig)
+	if client.team != nil {
+		cfg.TeamId = uint32(client.team.Id)
+		cfg.TeamPrivilegeLevel = uint16(client.teamPrivilege)
+		copy(cfg.Teamname[:], client.team.Name)
+		copy(cfg.TeamFlag[:], client.team.Flag)
+	}
 
 	// Sylverant sets these to enable all team rewards? Not sure what this means yet.
-	pkt.PlayerKeyConfig.TeamRewards[0] = 0xFFFFFFFF
-	pkt.PlayerKeyConfig.TeamRewards[1] = 0xFFFFFFFF
-
-	DebugLog("Sending Key Config Packet")
-	return EncryptAndSend(client, pkt)
+	cfg.TeamRewards[0] = 0xFFFFFFFF
+	cfg.TeamRewards[1] = 0xFFFFFFFF
+	return cfg
 }
 
 // Handle the character select/preview request. Will either return information
 // about a character given a particular slot in via 0xE5 response or ack the
 // selection with an 0xE4 (also used for an empty slot).
 func (server *CharacterServer) HandleCharacterSelect(client *Client) error {
-	var pkt CharSelectionPacket
-	util.StructFromBytes(client.Data(), &pkt)
+	var pkt packets.CharSelectionPacket
+	if err := client.Decode(&pkt); err != nil {
+		return err
+	}
+	if pkt.Slot >= NumCharacterSlots {
+		return server.sendCharacterAck(client, pkt.Slot, charAckNoCharacter)
+	}
 
-	character, err := database.FindCharacter(client.guildcard, pkt.Slot)
+	if pkt.Selecting != 0x01 {
+		return server.sendSlotPreview(client, pkt.Slot)
+	}
+
+	// They've selected a character from the menu. It's played with and saved,
+	// so unlike the previews it's always read from the primary.
+	character, err := client.db().FindCharacter(client.guildcard, pkt.Slot)
 	if character == nil {
 		// We don't have a character for this slot.
-		return server.sendCharacterAck(client, pkt.Slot, 2)
+		return server.sendCharacterAck(client, pkt.Slot, charAckNoCharacter)
 	} else if err != nil {
-		log.Error(err.Error())
+		client.log.Error(err.Error())
+		return err
+	}
+	client.config.SlotNum = uint8(pkt.Slot)
+	client.config.CharSelected = 1
+	if err := server.sendFullCharacter(client, character); err != nil {
 		return err
 	}
+	server.sendSecurity(client, packets.BBLoginErrorNone, client.guildcard, client.teamId)
+	return server.sendCharacterAck(client, pkt.Slot, charAckSelected)
+}
 
-	if pkt.Selecting == 0x01 {
-		// They've selected a character from the menu.
-		client.config.SlotNum = uint8(pkt.Slot)
-		server.sendSecurity(client, BBLoginErrorNone, client.guildcard, client.teamId)
-		return server.sendCharacterAck(client, pkt.Slot, 1)
+// Send the preview of the character in a slot, or the ack for an empty slot.
+// Previews are cached, with empty slots cached as empty values.
+func (server *CharacterServer) sendSlotPreview(client *Client, slotNum uint32) error {
+	key := previewCacheKey(client.guildcard, slotNum)
+	cached, ok := cacheGet(key)
+	if !ok {
+		character, err := cacheSource(client).FindCharacter(client.guildcard, slotNum)
+		if err != nil {
+			client.log.Error(err.Error())
+			return err
+		}
+		cached = []byte{}
+		if character != nil {
+			cached, _ = util.BytesFromStruct(newCharacterPreview(character))
+		}
+		cacheSet(key, cached)
+	}
+
+	preview := new(packets.CharacterPreview)
+	if len(cached) == 0 || util.DecodeStruct(cached, preview) != nil {
+		// We don't have a character for this slot.
+		return server.sendCharacterAck(client, slotNum, charAckNoCharacter)
 	}
 	// They have a character in that slot; send the character preview.
-	return server.sendCharacterPreview(client, character)
+	return server.sendCharacterPreview(client, preview)
 }
 
-// Send the character acknowledgement packet. 0 indicates a creation ack, 1 is
-// ack'ing a selected character, and 2 indicates that a character doesn't exist
-// in the slot requested via preview request.
+// Flags for the character acknowledgement packet.
+const (
+	// The character was created or updated.
+	charAckCreated = 0
+	// The character was selected.
+	charAckSelected = 1
+	// There's no character in the slot that was previewed or selected. The
+	// client also takes it as a refusal of a created or updated character and
+	// goes back to the character list.
+	charAckNoCharacter = 2
+)
+
+// Send the character acknowledgement packet with one of the charAck flags.
 func (server *CharacterServer) sendCharacterAck(client *Client, slotNum uint32, flag uint32) error {
-	pkt := &CharAckPacket{
-		Header: BBHeader{Type: LoginCharAckType},
+	pkt := &packets.CharAckPacket{
+		Header: packets.BBHeader{Type: packets.LoginCharAckType},
 		Slot:   slotNum,
 		Flag:   flag,
 	}
-	DebugLog("Sending Character Ack Packet")
+	client.log.Debug("Sending Character Ack Packet")
 	return EncryptAndSend(client, pkt)
 }
 
-// Send the preview packet containing basic details about a character in the selected slot.
-func (server *CharacterServer) sendCharacterPreview(client *Client, character *Character) error {
-	charPreview := &CharacterPreview{
+// Returns the basic details about a character that are shown in its slot.
+func newCharacterPreview(character *data.Character) *packets.CharacterPreview {
+	charPreview := &packets.CharacterPreview{
 		Experience:     character.Experience,
 		Level:          character.Level,
 		NameColor:      character.NameColor,
@@ -401,135 +479,317 @@ func (server *CharacterServer) sendCharacterPreview(client *Client, character *C
 	}
 	copy(charPreview.GuildcardStr[:], character.GuildcardStr[:])
 	copy(charPreview.Name[:], character.Name[:])
+	return charPreview
+}
 
-	pkt := &CharPreviewPacket{
-		Header:    BBHeader{Type: LoginCharPreviewType},
+// Send the preview packet containing basic details about a character in the selected slot.
+func (server *CharacterServer) sendCharacterPreview(client *Client, charPreview *packets.CharacterPreview) error {
+	pkt := &packets.CharPreviewPacket{
+		Header:    packets.BBHeader{Type: packets.LoginCharPreviewType},
 		Slot:      0,
 		Character: charPreview,
 	}
-	DebugLog("Sending Character Preview Packet")
+	client.log.Debug("Sending Character Preview Packet")
 	return EncryptAndSend(client, pkt)
 }
 
-// Acknowledge the checksum the client sent us. We don't actually do
-// anything with it but the client won't proceed otherwise.
+// Send the complete data for the selected character, with each section loaded
+// from the database.
+func (server *CharacterServer) sendFullCharacter(client *Client, character *data.Character) error {
+	items, err := client.db().FindItems(client.guildcard, character.Slot, data.ItemLocationInventory)
+	if err != nil {
+		client.log.Error(err.Error())
+		return err
+	}
+	bank, err := client.db().FindBank(client.guildcard, bankSlot(client))
+	if err != nil {
+		client.log.Error(err.Error())
+		return err
+	}
+	techniques, err := client.db().FindTechniques(client.guildcard, character.Slot)
+	if err != nil {
+		client.log.Error(err.Error())
+		return err
+	}
+	questFlags, err := client.db().FindQuestFlags(client.guildcard, character.Slot)
+	if err != nil {
+		client.log.Error(err.Error())
+		return err
+	}
+	challenge, err := client.db().FindChallengeRecord(client.guildcard, character.Slot)
+	if err != nil {
+		client.log.Error(err.Error())
+		return err
+	}
+	battle, err := client.db().FindBattleRecord(client.guildcard, character.Slot)
+	if err != nil {
+		client.log.Error(err.Error())
+		return err
+	}
+	playerOptions, err := loadPlayerOptions(client)
+	if err != nil {
+		client.log.Error(err.Error())
+		return err
+	}
+
+	pkt := &packets.FullCharacterPacket{Header: packets.BBHeader{Type: packets.LoginFullCharacterType}}
+	fullChar := &pkt.Character
+	fullChar.Inventory = newInventory(items)
+	fullChar.Character = newPlayerDispData(character)
+	fullChar.Character.Techniques = newTechniqueLevels(techniques)
+	fullChar.OptionFlags = playerOptions.OptionFlags
+	for i, flags := range questFlags {
+		copy(fullChar.QuestData1[i*len(flags):], flags[:])
+	}
+	fullChar.Bank = newCharacterBank(bank)
+	fullChar.Guildcard = client.guildcard
+	copyUtf16(fullChar.Name[:], character.Name)
+	if client.team != nil {
+		copy(fullChar.TeamName[:], client.team.Name)
+	}
+	fullChar.SectionID = character.SectionID
+	fullChar.Class = character.Class
+	fullChar.BattleRecords = newBattleRecords(battle)
+	fullChar.ChallengeRecords = newChallengeRecords(challenge)
+	fullChar.KeyConfig = newKeyTeamConfig(client, playerOptions)
+
+	client.log.Debug("Sending Full Character Packet")
+	return EncryptAndSend(client, pkt)
+}
+
+// Check the client's build against client_builds, taking client_build_action
+// against it if it isn't one of them. The client won't proceed until its
+// checksum has been acknowledged.
+func (server *CharacterServer) HandleChecksum(client *Client) error {
+	var pkt packets.ChecksumPacket
+	if err := client.Decode(&pkt); err != nil {
+		return err
+	}
+	if clientBuildAllowed(client.loginVersion, pkt.Checksum) {
+		return server.sendChecksumAck(client)
+	}
+
+	details := fmt.Sprintf("unrecognized client build (version %#x, checksum %08x)",
+		client.loginVersion, pkt.Checksum)
+	if config.ClientBuildAction == cheatActionKick {
+		SendClientMessage(client, "This version of the client isn't supported.\n\n"+
+			"Please update it to the one from the server's website.")
+		err := refuseLogin(data.LoginVersionMismatch,
+			fmt.Errorf("Refused guildcard %d with an %s", client.guildcard, details))
+		recordLoginAttempt(client, client.username, uint32(client.loginVersion), err)
+		return err
+	}
+	cheatDetected(client, cheatRuleClientBuild, config.ClientBuildAction, details)
+	return server.sendChecksumAck(client)
+}
+
+// Returns whether a client reporting version and checksum is one of the builds
+// in client_builds.
+func clientBuildAllowed(version uint16, checksum uint32) bool {
+	if len(config.ClientBuilds) == 0 {
+		return true
+	}
+	for _, build := range config.ClientBuilds {
+		if (build.Version == 0 || build.Version == version) && build.Checksum == checksum {
+			return true
+		}
+	}
+	return false
+}
+
+// Accept the checksum the client sent us.
 func (server *CharacterServer) sendChecksumAck(client *Client) error {
-	pkt := new(ChecksumAckPacket)
-	pkt.Header.Type = LoginChecksumAckType
+	pkt := new(packets.ChecksumAckPacket)
+	pkt.Header.Type = packets.LoginChecksumAckType
 	pkt.Ack = uint32(1)
 
-	DebugLog("Sending Checksum Ack Packet")
+	client.log.Debug("Sending Checksum Ack Packet")
 	return EncryptAndSend(client, pkt)
 }
 
 // Load the player's saved guildcards, build the chunk data, and send the chunk header.
 func (server *CharacterServer) HandleGuildcardDataStart(client *Client) error {
-	guildcards, err := database.FindGuildcardData(client.guildcard)
+	key := guildcardCacheKey(client.guildcard)
+	contents, ok := cacheGet(key)
+	if !ok {
+		var err error
+		if contents, err = loadGuildcardData(client); err != nil {
+			return err
+		}
+		cacheSet(key, contents)
+	}
+	client.guildcardTransfer = newChunkedTransfer("Guildcard", splitChunks(contents))
+	return server.sendGuildcardHeader(client, crc32.ChecksumIEEE(contents), uint16(len(contents)))
+}
+
+// Returns the chunk data for the player's saved and blocked guildcards.
+func loadGuildcardData(client *Client) ([]byte, error) {
+	store := cacheSource(client)
+	guildcards, err := store.FindGuildcardData(client.guildcard)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	blocked, err := store.FindBlockedGuildcards(client.guildcard)
+	if err != nil {
+		return nil, err
+	}
+	// The team names saved with the guildcards are whatever they were when the
+	// cards were added, so they're replaced with the teams the players are in now.
+	var listed []uint32
+	for _, entry := range guildcards {
+		listed = append(listed, uint32(entry.FriendGuildcard))
+	}
+	for _, entry := range blocked {
+		listed = append(listed, uint32(entry.BlockedGuildcard))
+	}
+	teamNames, err := store.FindTeamNames(listed)
+	if err != nil {
+		return nil, err
+	}
+	for i := range guildcards {
+		guildcards[i].TeamName = teamNames[uint32(guildcards[i].FriendGuildcard)]
+	}
+	for i := range blocked {
+		blocked[i].TeamName = teamNames[uint32(blocked[i].BlockedGuildcard)]
 	}
 
-	gcData := new(GuildcardData)
-	// Maximum of 140 entries can be sent.
+	gcData := new(packets.GuildcardData)
+	for i, entry := range blocked {
+		if i >= len(gcData.Blocked) {
+			break
+		}
+		gcData.Blocked[i] = newBlockedGuildcardEntry(entry)
+	}
 	for i, entry := range guildcards {
-		// TODO: This may not actually work yet, but I haven't gotten to
-		// figuring out how the other servers use it.
-		pktEntry := gcData.Entries[i]
-		pktEntry.Guildcard = uint32(entry.Guildcard)
-		copy(pktEntry.Name[:], entry.Name)
-		copy(pktEntry.TeamName[:], entry.TeamName)
-		copy(pktEntry.Description[:], entry.Description)
-		pktEntry.Language = entry.Language
-		pktEntry.SectionID = entry.SectionID
-		pktEntry.CharClass = entry.Class
-		copy(pktEntry.Comment[:], entry.Comment)
-	}
-	var size int
-	client.gcData, size = util.BytesFromStruct(gcData)
-	checksum := crc32.ChecksumIEEE(client.gcData)
-	client.gcDataSize = uint16(size)
-
-	return server.sendGuildcardHeader(client, checksum, client.gcDataSize)
+		if i >= len(gcData.Entries) {
+			break
+		}
+		gcData.Entries[i] = newGuildcardDataEntry(entry)
+	}
+	contents, _ := util.BytesFromStruct(gcData)
+	return contents, nil
 }
 
 // Send the header containing metadata about the guildcard chunk.
 func (server *CharacterServer) sendGuildcardHeader(client *Client, checksum uint32, dataLen uint16) error {
-	pkt := &GuildcardHeaderPacket{
-		Header:   BBHeader{Type: LoginGuildcardHeaderType},
+	pkt := &packets.GuildcardHeaderPacket{
+		Header:   packets.BBHeader{Type: packets.LoginGuildcardHeaderType},
 		Unknown:  0x00000001,
 		Length:   dataLen,
 		Checksum: checksum,
 	}
-	DebugLog("Sending Guildcard Header Packet")
+	client.log.Debug("Sending Guildcard Header Packet")
 	return EncryptAndSend(client, pkt)
 }
 
 // Send another chunk of the client's guildcard data.
-func (server *CharacterServer) HandleGuildcardChunk(client *Client) {
-	var chunkReq GuildcardChunkReqPacket
-	util.StructFromBytes(client.Data(), &chunkReq)
-	if chunkReq.Continue == 0x01 {
-		server.sendGuildcardChunk(client, chunkReq.ChunkRequested)
+func (server *CharacterServer) HandleGuildcardChunk(client *Client) error {
+	var chunkReq packets.GuildcardChunkReqPacket
+	if err := client.Decode(&chunkReq); err != nil {
+		return err
+	}
+	transfer := client.guildcardTransfer
+	if transfer == nil {
+		return fmt.Errorf("Guildcard chunk %d requested before the header", chunkReq.ChunkRequested)
 	}
-	// Anything else is a request to cancel sending guildcard chunks.
+	if chunkReq.Continue != 0x01 {
+		// Anything else is a request to cancel sending guildcard chunks.
+		client.log.Debug("Client cancelled the guildcard transfer")
+		transfer.Cancel()
+		return nil
+	}
+	chunk, err := transfer.Chunk(chunkReq.ChunkRequested)
+	if err != nil {
+		return err
+	}
+	return server.sendGuildcardChunk(client, chunk, chunkReq.ChunkRequested)
 }
 
 // Send the specified chunk of guildcard data.
-func (server *CharacterServer) sendGuildcardChunk(client *Client, chunkNum uint32) error {
-	pkt := new(GuildcardChunkPacket)
-	pkt.Header.Type = LoginGuildcardChunkType
-	pkt.Chunk = chunkNum
-
-	// The client will only accept 0x6800 bytes of a chunk per packet.
-	offset := uint16(chunkNum) * MaxChunkSize
-	remaining := client.gcDataSize - offset
-	if remaining > MaxChunkSize {
-		pkt.Data = client.gcData[offset : offset+MaxChunkSize]
-	} else {
-		pkt.Data = client.gcData[offset:]
+func (server *CharacterServer) sendGuildcardChunk(client *Client, chunkData []byte, chunk uint32) error {
+	pkt := &packets.GuildcardChunkPacket{
+		Header: packets.BBHeader{Type: packets.LoginGuildcardChunkType},
+		Chunk:  chunk,
+		Data:   chunkData,
 	}
-
-	DebugLog("Sending Guildcard Chunk Packet")
+	client.log.Debug("Sending Guildcard Chunk Packet")
 	return EncryptAndSend(client, pkt)
 }
 
 // Send the header for the parameter files we're about to start sending.
 func (server *CharacterServer) sendParameterHeader(client *Client, numEntries uint32, entries []byte) error {
-	pkt := &ParameterHeaderPacket{
-		Header:  BBHeader{Type: LoginParameterHeaderType, Flags: numEntries},
+	pkt := &packets.ParameterHeaderPacket{
+		Header:  packets.BBHeader{Type: packets.LoginParameterHeaderType, Flags: numEntries},
 		Entries: entries,
 	}
-	DebugLog("Sending Parameter Header Packet")
+	client.log.Debug("Sending Parameter Header Packet")
 	return EncryptAndSend(client, pkt)
 }
 
-// Index into chunkData and send the specified chunk of parameter data.
+// Send another chunk of the parameter files.
+func (server *CharacterServer) HandleParameterChunk(client *Client, chunkNum uint32) error {
+	transfer := client.parameterTransfer
+	if transfer == nil {
+		return fmt.Errorf("Parameter chunk %d requested before the header", chunkNum)
+	}
+	chunk, err := transfer.Chunk(chunkNum)
+	if err != nil {
+		return err
+	}
+	if transfer.Done() {
+		client.log.Debug("Sending the last parameter chunk")
+	}
+	return server.sendParameterChunk(client, chunk, chunkNum)
+}
+
+// Send the specified chunk of parameter data.
 func (server *CharacterServer) sendParameterChunk(client *Client, chunkData []byte, chunk uint32) error {
-	pkt := &ParameterChunkPacket{
-		Header: BBHeader{Type: LoginParameterChunkType},
+	pkt := &packets.ParameterChunkPacket{
+		Header: packets.BBHeader{Type: packets.LoginParameterChunkType},
 		Chunk:  chunk,
 		Data:   chunkData,
 	}
-	DebugLog("Sending Parameter Chunk Packet")
+	client.log.Debug("Sending Parameter Chunk Packet")
 	return EncryptAndSend(client, pkt)
 }
 
 // Player has modified a character via the dressing room or selected the recreate option.
 // Recreate or update a character in a slot depending on which it was.
 func (server *CharacterServer) HandleCharacterUpdate(client *Client) error {
-	var charPkt CharPreviewPacket
-	charPkt.Character = new(CharacterPreview)
-	util.StructFromBytes(client.Data(), &charPkt)
-
+	var charPkt packets.CharPreviewPacket
+	charPkt.Character = new(packets.CharacterPreview)
+	if err := client.Decode(&charPkt); err != nil {
+		return err
+	}
+	if charPkt.Slot >= NumCharacterSlots {
+		return fmt.Errorf("Character slot %d out of range for guildcard %d", charPkt.Slot, client.guildcard)
+	} else if int(charPkt.Character.Class) >= NumClasses {
+		return fmt.Errorf("Character class %d out of range for guildcard %d", charPkt.Character.Class, client.guildcard)
+	}
+	if err := nameFilter.CheckUtf16(utf16FromBytes(charPkt.Character.Name[:])); err != nil {
+		// The player can pick another name, so they stay connected.
+		client.log.Infof("Refused character name for guildcard %d: %s", client.guildcard, err.Error())
+		SendClientMessage(client, nameRefusedMessage("character", err))
+		return server.sendCharacterAck(client, charPkt.Slot, charAckNoCharacter)
+	}
+	// The slot's preview is dropped even if a step below fails, since the steps
+	// before it may have gone through.
+	defer cacheDelete(previewCacheKey(client.guildcard, charPkt.Slot))
 	if client.flag == 0x02 {
-		if err := server.updateCharacter(client.guildcard, &charPkt); err != nil {
-			log.Error(err.Error())
+		err := server.updateCharacter(client, &charPkt)
+		if err == data.ErrCharacterNameTaken {
+			return server.refuseTakenName(client, charPkt.Slot)
+		} else if err != nil {
+			client.log.Error(err.Error())
 			return err
 		}
 	} else {
-		// Recreating; delete the existing character and start from scratch.
-		if err := database.DeleteCharacter(client.guildcard, charPkt.Slot); err != nil {
-			log.Error(err.Error())
+		// Recreating; delete the existing character (which can still be restored
+		// by an admin for a while) and start from scratch. If the new name turns
+		// out to be taken the slot is left empty, which is what the player asked
+		// for anyway, and they can try again with another name.
+		if err := client.db().DeleteCharacter(client.guildcard, charPkt.Slot); err != nil {
+			client.log.Error(err.Error())
 			return err
 		}
 
@@ -537,7 +797,7 @@ func (server *CharacterServer) HandleCharacterUpdate(client *Client) error {
 		// Grab our base stats for this character class.
 		stats := server.BaseStats[p.Class]
 
-		character := &Character{
+		character := &data.Character{
 			Experience:        0,
 			Level:             0,
 			GuildcardStr:      p.GuildcardStr[:],
@@ -568,28 +828,111 @@ func (server *CharacterServer) HandleCharacterUpdate(client *Client) error {
 			ATA:               stats.ATA,
 			LCK:               stats.LCK,
 			Meseta:            300,
+			NameKey:           characterNameKey(client.guildcard, p.Name[:]),
 		}
 		/* TODO: Add the rest of these.
 		--unsigned char keyConfig[232]; // 0x3E8 - 0x4CF;
-		--techniques blob,
 		--options blob,
 		*/
 
-		err := database.CreateCharacter(client.guildcard, charPkt.Slot, character)
+		err := client.db().CreateCharacter(client.guildcard, charPkt.Slot, character)
+		if err == data.ErrCharacterNameTaken {
+			return server.refuseTakenName(client, charPkt.Slot)
+		} else if err != nil {
+			client.log.Error(err.Error())
+			return err
+		}
+		err = client.db().UpdateItems(client.guildcard, charPkt.Slot,
+			data.ItemLocationInventory, startingItems(p.Class))
+		if err != nil {
+			client.log.Error(err.Error())
+			return err
+		}
+		err = client.db().UpdateTechniques(client.guildcard, charPkt.Slot, startingTechniques(p.Class))
 		if err != nil {
-			log.Error(err.Error())
+			client.log.Error(err.Error())
 			return err
 		}
 	}
 	// Send the security packet with the updated state and slot number so that
 	// we know a character has been selected.
 	client.config.SlotNum = uint8(charPkt.Slot)
-	return server.sendCharacterAck(client, charPkt.Slot, 0)
+	client.config.CharSelected = 1
+	return server.sendCharacterAck(client, charPkt.Slot, charAckCreated)
+}
+
+// Tell the player that the name they gave the character in slotNum is taken
+// so that they can choose another.
+func (server *CharacterServer) refuseTakenName(client *Client, slotNum uint32) error {
+	client.log.Infof("Refused taken character name for guildcard %d", client.guildcard)
+	SendClientMessage(client, "That character name is already taken. Please choose another.")
+	return server.sendCharacterAck(client, slotNum, charAckNoCharacter)
+}
+
+// Returns the key that a character's name (as it's sent in a character preview)
+// is kept unique by under the policy set by unique_character_names, or an empty
+// string if names needn't be unique. The database refuses a second character
+// with the same key. Names are compared the same way as for the name filter,
+// or by the name itself if that leaves nothing, and names that only have to be
+// unique within the account have the guildcard in front of them.
+func characterNameKey(guildcard uint32, name []byte) string {
+	s := string(utf16.Decode(trimUtf16(utf16FromBytes(name))))
+	key := normalizeName(s)
+	if key == "" {
+		key = s
+	}
+	switch config.UniqueCharacterNames {
+	case UniqueNamesServer:
+		return key
+	case UniqueNamesAccount:
+		return fmt.Sprintf("%d:%s", guildcard, key)
+	default:
+		return ""
+	}
 }
 
-func (server *CharacterServer) updateCharacter(guildcard uint32, pkt *CharPreviewPacket) error {
+// Bring the name keys of the existing characters in line with the current
+// unique_character_names policy, since characters created before it was set
+// (or under another one) don't have the right keys. Characters whose names
+// clash with one that was seen first are left without a key and logged.
+func syncCharacterNameKeys() error {
+	names, err := database.FindCharacterNames()
+	if err != nil {
+		return err
+	}
+	// Keys are cleared first so that one character's stale key can't block
+	// another's new one.
+	var changed []data.CharacterName
+	for _, name := range names {
+		if key := characterNameKey(name.Guildcard, name.Name); key != name.Key {
+			if name.Key != "" {
+				if err := database.UpdateCharacterNameKey(name.Guildcard, name.Slot, ""); err != nil {
+					return err
+				}
+			}
+			name.Key = key
+			changed = append(changed, name)
+		}
+	}
+	for _, name := range changed {
+		if name.Key == "" {
+			continue
+		}
+		err := database.UpdateCharacterNameKey(name.Guildcard, name.Slot, name.Key)
+		if err == data.ErrCharacterNameTaken {
+			log.Warnf("Character in slot %d of guildcard %d has a name that's already taken",
+				name.Slot, name.Guildcard)
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (server *CharacterServer) updateCharacter(client *Client, pkt *packets.CharPreviewPacket) error {
+	guildcard := client.guildcard
 	// Player is using the dressing room; update the character.
-	character, err := database.FindCharacter(guildcard, pkt.Slot)
+	character, err := client.db().FindCharacter(guildcard, pkt.Slot)
 	if character == nil {
 		err = fmt.Errorf("Character does not exist in slot %d for guildcard %d",
 			pkt.Slot, guildcard)
@@ -599,30 +942,50 @@ func (server *CharacterServer) updateCharacter(guildcard uint32, pkt *CharPrevie
 		character.Model = p.Model
 		character.NameColorChecksum = p.NameColorChksm
 		character.SectionID = p.SectionID
-		character.Class = p.Class
 		character.Costume = p.Costume
 		character.Skin = p.Skin
+		character.Face = p.Face
 		character.Head = p.Head
+		character.Hair = p.Hair
 		character.HairRed = p.HairRed
 		character.HairGreen = p.HairGreen
 		character.HairBlue = p.HairBlue
 		character.ProportionX = p.PropX
 		character.ProportionY = p.PropY
-		copy(character.Name, p.Name[:])
+		character.Name = append([]byte(nil), p.Name[:]...)
+		character.NameKey = characterNameKey(guildcard, character.Name)
 
-		err = database.UpdateCharacter(guildcard, pkt.Slot, character)
+		err = client.db().UpdateAppearance(guildcard, pkt.Slot, character)
 	}
 	return err
 }
 
 // Player selected one of the items on the ship select screen.
-func (server *CharacterServer) HandleShipSelection(client *Client) error {
-	var pkt MenuSelectionPacket
-	util.StructFromBytes(client.Data(), &pkt)
-	selectedShip := pkt.ItemId - 1
-	if selectedShip < 0 || selectedShip >= uint32(len(shipList)) {
-		return errors.New("Invalid ship selection: " + string(selectedShip))
-	}
-	s := &shipList[selectedShip]
-	return SendRedirect(client, s.ipAddr[:], s.port)
+func (server *CharacterServer) HandleShipSelection(client *Client, pkt packets.MenuSelectionPacket) error {
+	return selectShip(client, pkt, func() error {
+		return server.sendShipList(client, ships.List())
+	})
+}
+
+// Send the player on c to the ship they selected from the ship list, as long as
+// it's still online, they have the privileges to join it, and it has room for
+// them. Otherwise they're told why not and sendShipList is called to show them
+// the list again. This is shared by the character and ship servers like the
+// list itself.
+func selectShip(c *Client, pkt packets.MenuSelectionPacket, sendShipList func() error) error {
+	s := ships.Find(pkt.ItemId)
+	switch {
+	case s == nil || s.expired(time.Now()):
+		// The ship may have gone offline since the list was sent.
+		SendClientMessage(c, "That ship is no longer available.")
+		return sendShipList()
+	case s.Locked(c):
+		SendClientMessage(c, "That ship is closed to you.")
+		return sendShipList()
+	case s.Full():
+		SendClientMessage(c, "That ship is full.")
+		return sendShipList()
+	}
+	c.log.Debugf("Redirecting to ship %s", util.StripPadding(s.name[:]))
+	return SendRedirect(c, s.redirectIP(c), s.port)
 }
@@ -0,0 +1,244 @@
+/*
+* Character locks, which keep a character from being loaded on two ships at once
+* (where each would overwrite the other's saves). The shipgate holds the locks:
+* a block takes the lock for the character that the player selected before it
+* loads it, and turns the player away if another ship has it. Ships registered
+* with a remote shipgate ask for their locks over the link, while the shipgate's
+* own ship (and a ship that has lost its link) uses the table directly.
+*
+* A lock belongs to a ship rather than a connection so that players can move
+* between the ship's blocks. It's released when the player logs off, dropped
+* with all of the ship's others when the ship goes away, and expires unless the
+* ship keeps refreshing it, so a ship that dies without saying so doesn't keep
+* its characters locked.
+ */
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// How long a lock lasts without being refreshed.
+	characterLockTimeout = 2 * time.Minute
+	// How long a block waits for the shipgate to answer a lock request before
+	// turning the player away.
+	characterLockWait = 5 * time.Second
+)
+
+// Actions and results in the shipgate's lock packets.
+const (
+	CharacterLockAcquire = 1
+	CharacterLockRelease = 2
+
+	CharacterLockGranted = 0
+	CharacterLockDenied  = 1
+)
+
+// A character slot on an account.
+type characterKey struct {
+	guildcard uint32
+	slot      uint32
+}
+
+type characterLock struct {
+	// Address of the ship holding the lock (see Ship.key).
+	holder  string
+	expires time.Time
+}
+
+// Synchronized table of the locks held on the shipgate.
+type characterLockTable struct {
+	locks map[characterKey]characterLock
+	sync.Mutex
+}
+
+var characterLocks = &characterLockTable{locks: make(map[characterKey]characterLock)}
+
+// Acquire takes or refreshes the lock on a character for the holder, returning
+// false if another holder has it.
+func (t *characterLockTable) Acquire(key characterKey, holder string) bool {
+	t.Lock()
+	defer t.Unlock()
+	now := time.Now()
+	if lock, ok := t.locks[key]; ok && lock.holder != holder && now.Before(lock.expires) {
+		return false
+	}
+	t.locks[key] = characterLock{holder: holder, expires: now.Add(characterLockTimeout)}
+	return true
+}
+
+// Release gives up the holder's lock on a character.
+func (t *characterLockTable) Release(key characterKey, holder string) {
+	t.Lock()
+	if lock, ok := t.locks[key]; ok && lock.holder == holder {
+		delete(t.locks, key)
+	}
+	t.Unlock()
+}
+
+// ReleaseHolder gives up all of the holder's locks.
+func (t *characterLockTable) ReleaseHolder(holder string) {
+	t.Lock()
+	defer t.Unlock()
+	for key, lock := range t.locks {
+		if lock.holder == holder {
+			delete(t.locks, key)
+		}
+	}
+}
+
+// Returns the address that identifies the ship as a lock holder.
+func (s *Ship) key() string {
+	return net.JoinHostPort(net.IP(s.ipAddr[:]).String(), strconv.Itoa(int(s.port)))
+}
+
+// Lock requests sent over the shipgate link that are waiting for an answer,
+// keyed by the id in the request's header. Refreshes aren't waited on and are
+// sent with an id of 0.
+var (
+	pendingLocks     = make(map[uint32]chan bool)
+	lastLockRequest  uint32
+	pendingLocksLock sync.Mutex
+)
+
+// Returned by lockCharacter when another ship has the character.
+var errCharacterLocked = errors.New("character is locked by another ship")
+
+func characterKeyOf(c *Client) characterKey {
+	return characterKey{guildcard: c.guildcard, slot: uint32(c.config.SlotNum)}
+}
+
+// Take the lock on the character that the player on c selected, returning
+// errCharacterLocked if it's in use on another ship. The player can't have the
+// character unless the lock is known to be theirs, so a request that can't be
+// sent or isn't answered is an error as well.
+func lockCharacter(c *Client) error {
+	key := characterKeyOf(c)
+	link := currentShipgateLink()
+	if link == nil {
+		if !characterLocks.Acquire(key, localShip.key()) {
+			return errCharacterLocked
+		}
+		return nil
+	}
+
+	answer := make(chan bool, 1)
+	pendingLocksLock.Lock()
+	lastLockRequest++
+	if lastLockRequest == 0 {
+		lastLockRequest++
+	}
+	id := lastLockRequest
+	pendingLocks[id] = answer
+	pendingLocksLock.Unlock()
+	defer func() {
+		pendingLocksLock.Lock()
+		delete(pendingLocks, id)
+		pendingLocksLock.Unlock()
+	}()
+
+	if err := sendCharacterLock(link, key, CharacterLockAcquire, id); err != nil {
+		return fmt.Errorf("Failed to request character lock: %s", err.Error())
+	}
+	select {
+	case granted := <-answer:
+		if !granted {
+			return errCharacterLocked
+		}
+		return nil
+	case <-time.After(characterLockWait):
+		return fmt.Errorf("Shipgate didn't answer the lock request for guildcard %d", c.guildcard)
+	}
+}
+
+// Give up the lock on the player's character once they've logged off.
+func unlockCharacter(c *Client) {
+	key := characterKeyOf(c)
+	if link := currentShipgateLink(); link != nil {
+		if err := sendCharacterLock(link, key, CharacterLockRelease, 0); err != nil {
+			c.log.Warn("Failed to release character lock: " + err.Error())
+		}
+		return
+	}
+	characterLocks.Release(key, localShip.key())
+}
+
+func sendCharacterLock(c *Client, key characterKey, action uint16, id uint32) error {
+	return EncryptAndSend(c, &ShipgateLockPacket{
+		Header:    ShipgateHeader{Type: ShipgateLockType, Id: id},
+		Guildcard: key.guildcard,
+		Slot:      key.slot,
+		Action:    action,
+	})
+}
+
+// A ship registered with us is taking, refreshing, or giving up a lock.
+func handleShipLock(c *Client) error {
+	var pkt ShipgateLockPacket
+	if err := c.Decode(&pkt); err != nil {
+		return err
+	}
+	key := characterKey{guildcard: pkt.Guildcard, slot: pkt.Slot}
+	switch pkt.Action {
+	case CharacterLockAcquire:
+		pkt.Status = CharacterLockGranted
+		if !characterLocks.Acquire(key, c.ship.key()) {
+			c.log.Infof("Denied lock on guildcard %d slot %d", pkt.Guildcard, pkt.Slot)
+			pkt.Status = CharacterLockDenied
+		}
+		return EncryptAndSend(c, &pkt)
+	case CharacterLockRelease:
+		characterLocks.Release(key, c.ship.key())
+	}
+	return nil
+}
+
+// The shipgate answered one of our lock requests.
+func handleShipLockReply(c *Client) error {
+	var pkt ShipgateLockPacket
+	if err := c.Decode(&pkt); err != nil {
+		return err
+	}
+	pendingLocksLock.Lock()
+	answer := pendingLocks[pkt.Header.Id]
+	pendingLocksLock.Unlock()
+	if answer != nil {
+		answer <- pkt.Status == CharacterLockGranted
+	} else if pkt.Status == CharacterLockDenied {
+		// Someone else took the character while we weren't refreshing it.
+		c.log.Warnf("Lost the lock on guildcard %d slot %d", pkt.Guildcard, pkt.Slot)
+	}
+	return nil
+}
+
+// Loop for the life of the server, refreshing the locks on the characters of
+// the players on our blocks so that they don't expire.
+func refreshCharacterLocks() {
+	for range time.Tick(characterLockTimeout / 3) {
+		renewCharacterLocks()
+	}
+}
+
+// Take the locks on the characters of the players on our blocks again, e.g.
+// after registering with the shipgate.
+func renewCharacterLocks() {
+	link := currentShipgateLink()
+	for _, c := range players.List() {
+		if c.character == nil {
+			continue
+		}
+		key := characterKeyOf(c)
+		if link == nil {
+			characterLocks.Acquire(key, localShip.key())
+		} else if err := sendCharacterLock(link, key, CharacterLockAcquire, 0); err != nil {
+			link.log.Warn(err.Error())
+			return
+		}
+	}
+}
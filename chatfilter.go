@@ -0,0 +1,344 @@
+/*
+* Chat filtering on the block servers. Every chat message (team chat included)
+* goes through a chain of filters before it's sent on, each of which can leave
+* it alone, rewrite it, or act against the player who sent it. The chain is built
+* from the chat filter file, which is reloaded along with the config:
+*
+*	{
+*		"rules": [
+*			{"name": "profanity", "words": ["darn", "heck"], "action": "censor"},
+*			{"name": "links", "pattern": "(?i)https?://", "action": "drop"},
+*			{"name": "slurs", "words": ["..."], "action": "mute", "mute_minutes": 30},
+*			{"name": "rmt", "pattern": "(?i)cheap meseta", "action": "report"}
+*		],
+*		"repeat": {"count": 3, "seconds": 30, "action": "drop"},
+*		"caps": {"min_letters": 8, "max_ratio": 0.7}
+*	}
+*
+* Words match whole words in any case, while patterns are regular expressions.
+* The rules are checked in order with these actions:
+*
+*	censor  Replace what matched with asterisks and send the rest.
+*	drop    Don't send the message.
+*	mute    Don't send the message, and mute the player for mute_minutes (see
+*	        mute.go).
+*	report  Send the message, but let the GMs who are online know about it and
+*	        list it at /admin/reports.
+*
+* The repeat rule takes its action (any but censor) against players who send the
+* same message count times in a row within that many seconds, and the caps rule
+* lowercases messages with at least min_letters letters that are mostly capitals.
+ */
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/dcrodman/archon/data"
+	"github.com/dcrodman/archon/util"
+)
+
+// Number of reports kept for /admin/reports.
+const recentChatReportCount = 100
+
+// What to do about a message that matched a rule.
+const (
+	chatActionCensor = "censor"
+	chatActionDrop   = "drop"
+	chatActionMute   = "mute"
+	chatActionReport = "report"
+)
+
+type chatRule struct {
+	Name        string   `json:"name"`
+	Words       []string `json:"words"`
+	Pattern     string   `json:"pattern"`
+	Action      string   `json:"action"`
+	MuteMinutes int      `json:"mute_minutes"`
+}
+
+type chatRepeatRule struct {
+	Count       int    `json:"count"`
+	Seconds     int    `json:"seconds"`
+	Action      string `json:"action"`
+	MuteMinutes int    `json:"mute_minutes"`
+}
+
+type chatCapsRule struct {
+	MinLetters int     `json:"min_letters"`
+	MaxRatio   float64 `json:"max_ratio"`
+}
+
+type chatFilterFile struct {
+	Rules  []chatRule      `json:"rules"`
+	Repeat *chatRepeatRule `json:"repeat"`
+	Caps   *chatCapsRule   `json:"caps"`
+}
+
+// One of the filters in the chain. Filter returns the text to send in place of
+// the message and the rule it broke, or nil if it didn't break one.
+type chatFilter interface {
+	Filter(c *Client, text string) (string, *chatRule)
+}
+
+// Checks messages against a list of words or a pattern.
+type matchFilter struct {
+	rule    chatRule
+	pattern *regexp.Regexp
+}
+
+func (f *matchFilter) Filter(c *Client, text string) (string, *chatRule) {
+	if !f.pattern.MatchString(text) {
+		return text, nil
+	}
+	if f.rule.Action == chatActionCensor {
+		text = f.pattern.ReplaceAllStringFunc(text, func(s string) string {
+			return strings.Repeat("*", len([]rune(s)))
+		})
+	}
+	return text, &f.rule
+}
+
+// Catches players sending the same message over and over.
+type repeatFilter struct {
+	rule   chatRule
+	count  int
+	window time.Duration
+}
+
+func (f *repeatFilter) Filter(c *Client, text string) (string, *chatRule) {
+	now := time.Now()
+	if text == c.lastChat && now.Sub(c.lastChatTime) <= f.window {
+		c.chatRepeats++
+	} else {
+		c.chatRepeats = 1
+	}
+	c.lastChat, c.lastChatTime = text, now
+	if c.chatRepeats >= f.count {
+		return text, &f.rule
+	}
+	return text, nil
+}
+
+// Lowercases messages that are mostly capital letters.
+type capsFilter struct {
+	minLetters int
+	maxRatio   float64
+}
+
+func (f *capsFilter) Filter(c *Client, text string) (string, *chatRule) {
+	letters, upper := 0, 0
+	for _, r := range text {
+		if unicode.IsLetter(r) {
+			letters++
+			if unicode.IsUpper(r) {
+				upper++
+			}
+		}
+	}
+	if letters >= f.minLetters && float64(upper) > f.maxRatio*float64(letters) {
+		text = strings.ToLower(text)
+	}
+	return text, nil
+}
+
+// Synchronized chain of the filters built from the chat filter file.
+type chatFilterChain struct {
+	filters []chatFilter
+	sync.RWMutex
+}
+
+var chatFilters = new(chatFilterChain)
+
+// Load the filters from the file at path, replacing the ones loaded before.
+// Chat isn't filtered if the file doesn't exist.
+func (fc *chatFilterChain) Load(path string) error {
+	var filters []chatFilter
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		log.Warnf("Chat filter file %s doesn't exist; chat won't be filtered", path)
+	} else if err != nil {
+		return err
+	} else {
+		file := new(chatFilterFile)
+		decoder := json.NewDecoder(bytes.NewReader(contents))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(file); err != nil {
+			return fmt.Errorf("invalid %s: %s", path, err.Error())
+		}
+		if filters, err = file.build(); err != nil {
+			return fmt.Errorf("invalid %s: %s", path, err.Error())
+		}
+	}
+
+	fc.Lock()
+	fc.filters = filters
+	fc.Unlock()
+	return nil
+}
+
+// Check the file's rules and build the filters for them.
+func (f *chatFilterFile) build() ([]chatFilter, error) {
+	var filters []chatFilter
+	for _, rule := range f.Rules {
+		if err := rule.check(); err != nil {
+			return nil, err
+		}
+		pattern := rule.Pattern
+		if len(rule.Words) > 0 {
+			words := make([]string, len(rule.Words))
+			for i, word := range rule.Words {
+				words[i] = regexp.QuoteMeta(word)
+			}
+			pattern = `(?i)\b(?:` + strings.Join(words, "|") + `)\b`
+		} else if pattern == "" {
+			return nil, fmt.Errorf("rule %q needs words or a pattern", rule.Name)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %s", rule.Name, err.Error())
+		}
+		filters = append(filters, &matchFilter{rule: rule, pattern: re})
+	}
+	if r := f.Repeat; r != nil {
+		rule := chatRule{Name: "repeat", Action: r.Action, MuteMinutes: r.MuteMinutes}
+		if err := rule.check(); err != nil {
+			return nil, err
+		} else if rule.Action == chatActionCensor {
+			return nil, fmt.Errorf("the repeat rule can't censor")
+		} else if r.Count < 2 || r.Seconds <= 0 {
+			return nil, fmt.Errorf("the repeat rule needs a count of at least 2 and a number of seconds")
+		}
+		filters = append(filters, &repeatFilter{rule: rule, count: r.Count, window: time.Duration(r.Seconds) * time.Second})
+	}
+	if r := f.Caps; r != nil {
+		if r.MaxRatio <= 0 || r.MaxRatio > 1 {
+			return nil, fmt.Errorf("the caps rule's max_ratio must be between 0 and 1")
+		}
+		filters = append(filters, &capsFilter{minLetters: r.MinLetters, maxRatio: r.MaxRatio})
+	}
+	return filters, nil
+}
+
+func (rule *chatRule) check() error {
+	switch rule.Action {
+	case chatActionCensor, chatActionDrop, chatActionReport:
+	case chatActionMute:
+		if rule.MuteMinutes <= 0 {
+			return fmt.Errorf("rule %q needs mute_minutes to mute", rule.Name)
+		}
+	default:
+		return fmt.Errorf("rule %q has unknown action %q", rule.Name, rule.Action)
+	}
+	return nil
+}
+
+// Filter runs a chat message from the player through the filters and returns the
+// message to send in its place, or false if it shouldn't be sent.
+func (fc *chatFilterChain) Filter(c *Client, message []byte) ([]byte, bool) {
+	fc.RLock()
+	filters := fc.filters
+	fc.RUnlock()
+	if len(filters) == 0 {
+		return message, true
+	}
+
+	// Keep the language marker that the message starts with.
+	var marker []byte
+	if len(message) >= 4 && message[0] == '\t' && message[1] == 0 {
+		marker = message[:4]
+	}
+	original := chatText(message)
+	text := original
+	for _, filter := range filters {
+		var rule *chatRule
+		if text, rule = filter.Filter(c, text); rule == nil {
+			continue
+		}
+		switch rule.Action {
+		case chatActionDrop:
+			c.log.Infof("Dropped chat from guildcard %d for breaking rule %s", c.guildcard, rule.Name)
+			return nil, false
+		case chatActionMute:
+			c.log.Infof("Muting guildcard %d for %d minutes for breaking rule %s", c.guildcard, rule.MuteMinutes, rule.Name)
+			if err := mutePlayer(c, time.Duration(rule.MuteMinutes)*time.Minute, false); err != nil {
+				c.log.Errorf("Failed to mute guildcard %d: %s", c.guildcard, err.Error())
+			}
+			SendClientMessage(c, fmt.Sprintf("You've been muted for %d minutes.", rule.MuteMinutes))
+			return nil, false
+		case chatActionReport:
+			reportChat(c, rule.Name, original)
+		}
+	}
+	if text == original {
+		return message, true
+	}
+	return append(append([]byte(nil), marker...), util.ConvertToUtf16(text)...), true
+}
+
+type chatReport struct {
+	Time      time.Time `json:"time"`
+	Guildcard uint32    `json:"guildcard"`
+	Character string    `json:"character"`
+	Rule      string    `json:"rule"`
+	Message   string    `json:"message"`
+}
+
+// The most recent chat reports, newest last.
+type chatReportHistory struct {
+	reports []chatReport
+	sync.Mutex
+}
+
+var recentChatReports = new(chatReportHistory)
+
+func (h *chatReportHistory) Add(report chatReport) {
+	h.Lock()
+	defer h.Unlock()
+	if len(h.reports) == recentChatReportCount {
+		h.reports = append(h.reports[:0], h.reports[1:]...)
+	}
+	h.reports = append(h.reports, report)
+}
+
+// List returns the reports, newest first.
+func (h *chatReportHistory) List() []chatReport {
+	h.Lock()
+	defer h.Unlock()
+	reports := make([]chatReport, len(h.reports))
+	for i, report := range h.reports {
+		reports[len(reports)-i-1] = report
+	}
+	return reports
+}
+
+// Record a message that broke a rule and let the GMs on the ship know about it.
+func reportChat(c *Client, rule string, text string) {
+	report := chatReport{
+		Time:      time.Now(),
+		Guildcard: c.guildcard,
+		Rule:      rule,
+		Message:   text,
+	}
+	if c.character != nil {
+		report.Character = chatText(util.StripPadding(c.character.Name))
+	}
+	c.log.Warnf("Chat from guildcard %d broke rule %s: %s", c.guildcard, rule, text)
+	recentChatReports.Add(report)
+
+	notice := fmt.Sprintf("Chat report (%s)\n%s (%d): %s", rule, report.Character, c.guildcard, text)
+	for _, p := range players.List() {
+		if p != c && p.hasPrivilege(data.PrivilegeGM) {
+			SendClientMessage(p, notice)
+		}
+	}
+}
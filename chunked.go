@@ -0,0 +1,86 @@
+/*
+* Chunked transfers of the data that the character server sends in pieces (the
+* guildcard list and the parameter files). The client pulls the data one chunk
+* at a time, and asking for a chunk acknowledges the ones before it, so only the
+* last chunk sent is ever unacknowledged. A transfer keeps track of how far the
+* client has got so that it can turn away requests that skip ahead (which would
+* leave more chunks outstanding), go back to chunks that have already been
+* acknowledged, or keep asking for the same chunk again, any of which means the
+* client is confused or misbehaving rather than just slow.
+ */
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Times each chunk can be sent again, on average, before the client is cut off.
+const maxChunkResends = 3
+
+var errTransferCancelled = errors.New("transfer was cancelled")
+
+// Progress of a client through one transfer.
+type chunkedTransfer struct {
+	// What's being sent, for errors and the logs.
+	name   string
+	chunks [][]byte
+	// Chunks before acked have been acknowledged and those before next have
+	// been sent.
+	acked, next uint32
+	resends     int
+	cancelled   bool
+}
+
+func newChunkedTransfer(name string, chunks [][]byte) *chunkedTransfer {
+	return &chunkedTransfer{name: name, chunks: chunks}
+}
+
+// Split data into the chunks sent to the client, which won't accept more than
+// MaxChunkSize bytes in a packet.
+func splitChunks(data []byte) [][]byte {
+	var chunks [][]byte
+	for offset := 0; offset < len(data); offset += MaxChunkSize {
+		end := offset + MaxChunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		chunks = append(chunks, data[offset:end])
+	}
+	return chunks
+}
+
+// Chunk returns the chunk the client asked for, acknowledging those before it,
+// or an error if the request is out of order or over the limits.
+func (t *chunkedTransfer) Chunk(n uint32) ([]byte, error) {
+	switch {
+	case t.cancelled:
+		return nil, fmt.Errorf("%s chunk %d requested after the %s", t.name, n, errTransferCancelled)
+	case int(n) >= len(t.chunks):
+		return nil, fmt.Errorf("%s chunk %d out of range %d", t.name, n, len(t.chunks))
+	case n < t.acked:
+		return nil, fmt.Errorf("%s chunk %d was already acknowledged", t.name, n)
+	case n > t.next:
+		return nil, fmt.Errorf("%s chunk %d requested before chunk %d", t.name, n, t.next)
+	}
+
+	t.acked = n
+	if n < t.next {
+		if t.resends++; t.resends > maxChunkResends*len(t.chunks) {
+			return nil, fmt.Errorf("%s chunk %d requested again too many times", t.name, n)
+		}
+	} else {
+		t.next++
+	}
+	return t.chunks[n], nil
+}
+
+// Cancel stops the transfer at the client's request.
+func (t *chunkedTransfer) Cancel() {
+	t.cancelled = true
+}
+
+// Done returns whether every chunk has been sent.
+func (t *chunkedTransfer) Done() bool {
+	return int(t.next) == len(t.chunks)
+}
@@ -4,68 +4,254 @@
 package main
 
 import (
+	"context"
+	"encoding"
+	"encoding/binary"
 	"errors"
 	"fmt"
-	crypto "github.com/dcrodman/archon/encryption"
-	"github.com/dcrodman/archon/util"
 	"io"
 	"net"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/dcrodman/archon/data"
+	crypto "github.com/dcrodman/archon/libarchon/encryption"
+	"github.com/dcrodman/archon/packets"
+	"github.com/dcrodman/archon/util"
+	"github.com/sirupsen/logrus"
 )
 
+// Versions of PSO that a client can be running. Everything but Blue Burst is
+// only supported by the legacy login servers (see legacy.go).
+type ClientVersion uint8
+
+const (
+	VersionBB ClientVersion = iota
+	VersionPC
+	VersionGC
+)
+
+// Names of the client versions as they're given in the config.
+var clientVersionNames = map[ClientVersion]string{
+	VersionBB: "bb",
+	VersionPC: "pc",
+	VersionGC: "gc",
+}
+
+func (v ClientVersion) String() string {
+	return clientVersionNames[v]
+}
+
+// Returns the version with the given name in the config, or false if there isn't one.
+func parseClientVersion(name string) (ClientVersion, bool) {
+	for v, n := range clientVersionNames {
+		if n == strings.ToLower(name) {
+			return v, true
+		}
+	}
+	return VersionBB, false
+}
+
+// Gamecube clients put the type and flags before the size in their packet
+// headers (see LegacyHeader).
+func (v ClientVersion) typeFirst() bool {
+	return v == VersionGC
+}
+
 // Client struct intended to be included as part of the client definitions
 // in each of the servers. This struct wraps the connection handling logic
 // used by Process() below to handle receiving packets.
 type Client struct {
-	conn   *net.TCPConn
+	conn   net.Conn
 	ipAddr string
 	port   string
+	// Whether the client connected over IPv6. IPv4 clients on a dual-stack
+	// socket count as IPv4.
+	ipv6 bool
+	// Identifies the connection in the logs. Anything logged about the client
+	// should go through log so that it's tagged with the id.
+	id  string
+	log *logrus.Entry
+	// Closed once the client has disconnected and been cleaned up.
+	disconnected chan struct{}
+	// Work that other clients' goroutines have queued to be done on this
+	// client's own (see Queue), and a signal that there's some waiting.
+	taskLock  sync.Mutex
+	tasks     []func()
+	taskReady chan struct{}
+	// Context of the packet being handled, or nil between packets. Only to be
+	// used from the client's own goroutine; see db.
+	ctx context.Context
 
+	version    ClientVersion
 	hdrSize    uint16
 	recvSize   int
 	packetSize uint16
 	buffer     []byte
 
-	clientCrypt *crypto.PSOCrypt
-	serverCrypt *crypto.PSOCrypt
+	clientCrypt crypto.Crypt
+	serverCrypt crypto.Crypt
+	// Other clients' goroutines can send to this client (e.g. lobby broadcasts),
+	// so encrypting and writing a packet needs to happen atomically.
+	sendLock sync.Mutex
+	// Set while the client's packets are being captured (see capture.go).
+	captureLock sync.Mutex
+	capture     *packetCapture
 
-	guildcard uint32
-	teamId    uint32
-	isGm      bool
+	username       string
+	hardwareId     string
+	guildcard      uint32
+	teamId         uint32
+	privilegeLevel byte
+	// When the account's chat mute ends (see mute.go).
+	muteLock    sync.Mutex
+	mutedUntil  time.Time
+	shadowMuted bool
+	// Team that the account is in (see team.go), or nil.
+	team          *data.Team
+	teamPrivilege byte
+	// The last invite to a team that the player was sent (see team.go).
+	teamInvite *teamInvite
 
-	// Patch server; list of files that need update.
+	// Patch server; the patch index the client is being checked against and the
+	// list of files that need update. Holding on to the index means that a reload
+	// won't change the files out from under a client mid-download.
+	patches    *PatchIndex
 	updateList []*PatchEntry
 
-	gcData     []byte
-	gcDataSize uint16
-	config     ClientConfig
-	flag       uint32
+	config packets.ClientConfig
+	flag   uint32
+
+	// Character server; the version number the client reported in its login
+	// packet and its progress through downloading its guildcards and the
+	// parameter files (see chunked.go).
+	loginVersion      uint16
+	guildcardTransfer *chunkedTransfer
+	parameterTransfer *chunkedTransfer
+
+	// Block server; the player's selected character and their current lobby or
+	// game. lastLobby is where they're returned to when they leave a game.
+	character  *data.Character
+	inventory  []data.Item
+	techniques data.Techniques
+	challenge  *data.ChallengeRecord
+	battle     *data.BattleRecord
+	// Id to give the next item that's added to the player's inventory.
+	nextItemId uint32
+	blocked    blockList
+	// Loaded the first time the player opens the bank, as is the common bank.
+	bank           *data.Bank
+	commonBank     *data.Bank
+	commonBankOpen bool
+	lobby          *Lobby
+	lastLobby      *Lobby
+	// Lobby that the player asked to join when they logged on to the block, if
+	// they're meeting someone.
+	preferredLobby *Lobby
+	game           *Game
+	clientId       uint8
+	// The shop the player opened last and what it had for sale.
+	shopType  uint8
+	shopStock []shopItem
+	// A trade that's been saved but not yet applied to the player's inventory
+	// (see trade.go).
+	tradeResultLock sync.Mutex
+	tradeResult     *tradeResult
+	// The tekker's last appraisal, until the player accepts or refuses it.
+	identifyResult *data.Item
+	// The player's last chat message, for the chat filter's repeat rule.
+	lastChat     string
+	lastChatTime time.Time
+	chatRepeats  int
+	// What the anti-cheat rules have seen the player do (see anticheat.go).
+	cheat cheatState
+	// When the player's character was last queued to be saved (see saves.go).
+	lastSave time.Time
+
+	// Shipgate; the ship registered over this connection.
+	ship *Ship
 }
 
-func NewClient(conn *net.TCPConn, hdrSize uint16, cCrypt, sCrypt *crypto.PSOCrypt) *Client {
-	addr := strings.Split(conn.RemoteAddr().String(), ":")
+func NewClient(conn net.Conn, hdrSize uint16, cCrypt, sCrypt crypto.Crypt) *Client {
+	host, port, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	ip := net.ParseIP(host)
 	c := &Client{
-		conn:        conn,
-		ipAddr:      addr[0],
-		port:        addr[1],
-		hdrSize:     hdrSize,
-		clientCrypt: cCrypt,
-		serverCrypt: sCrypt,
-		buffer:      make([]byte, 512),
+		conn:         conn,
+		ipAddr:       host,
+		port:         port,
+		ipv6:         ip != nil && ip.To4() == nil,
+		hdrSize:      hdrSize,
+		clientCrypt:  cCrypt,
+		serverCrypt:  sCrypt,
+		buffer:       util.GetBytes(512),
+		id:           nextConnectionId(),
+		disconnected: make(chan struct{}),
+		taskReady:    make(chan struct{}, 1),
 	}
+	c.log = log.WithFields(logrus.Fields{"conn": c.id, "ip": c.ipAddr})
 	return c
 }
 
+// Wait for up to timeout for the client's connection to be cleaned up, returning
+// false if it wasn't.
+func (c *Client) waitDisconnected(timeout time.Duration) bool {
+	select {
+	case <-c.disconnected:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Queue task to be run on the client's goroutine between its packets. Anything
+// that one player's packet sets off for another player's character (experience
+// for a kill, say) should go through here rather than touching the character
+// from the wrong goroutine. Tasks still queued when the client disconnects are
+// dropped.
+func (c *Client) Queue(task func()) {
+	c.taskLock.Lock()
+	c.tasks = append(c.tasks, task)
+	c.taskLock.Unlock()
+	select {
+	case c.taskReady <- struct{}{}:
+	default:
+	}
+}
+
+// Run the tasks queued for the client so far. Only to be called from the
+// client's own goroutine.
+func (c *Client) runTasks() {
+	c.taskLock.Lock()
+	tasks := c.tasks
+	c.tasks = nil
+	c.taskLock.Unlock()
+	for _, task := range tasks {
+		task()
+	}
+}
+
+// Returns the datastore to use while handling one of the client's packets, whose
+// queries are cancelled along with the packet. Anything that has to finish even
+// when the server is shutting down, like saving a character when the client
+// disconnects, should use database instead.
+func (c *Client) db() data.Store {
+	if c.ctx == nil {
+		return database
+	}
+	return database.WithContext(c.ctx)
+}
+
 func (c *Client) IPAddr() string {
 	return c.ipAddr
 }
 
 func (c *Client) ClientVector() []uint8 {
-	return c.clientCrypt.Vector
+	return c.clientCrypt.Key()
 }
 
 func (c *Client) ServerVector() []uint8 {
-	return c.serverCrypt.Vector
+	return c.serverCrypt.Key()
 }
 
 // Data returns the current contents of the buffer read from the client.
@@ -73,31 +259,97 @@ func (c *Client) Data() []byte {
 	return c.buffer
 }
 
-func (c *Client) SendEncrypted(data []byte, length int) error {
-	bytes, blen := fixLength(data, uint16(length), c.hdrSize)
-	if config.DebugMode {
-		util.PrintPayload(bytes, int(blen))
-		fmt.Println()
+// Returns the type from the header of a packet sent to or from the client. PC
+// headers have a one byte type followed by flags; BB and shipgate headers
+// have a two byte type. Gamecube headers start with the type.
+func (c *Client) packetType(data []byte) uint16 {
+	if c.version.typeFirst() && len(data) > 0 {
+		return uint16(data[0])
+	} else if c.hdrSize == packets.PCHeaderSize && len(data) > 2 {
+		return uint16(data[2])
+	} else if len(data) >= 4 {
+		return binary.LittleEndian.Uint16(data[2:4])
+	}
+	return 0
+}
+
+// Returns the size from the header of a packet sent to or from the client. It's
+// the first two bytes of the header for everything but Gamecube clients, which
+// put it after the type and flags.
+func (c *Client) declaredSize(data []byte) uint16 {
+	if c.version.typeFirst() {
+		if len(data) < 4 {
+			return 0
+		}
+		data = data[2:]
 	}
+	size, _ := util.GetPacketSize(data)
+	return size
+}
+
+// Decode fills in pkt from the packet in the client's buffer. Only the number
+// of bytes given in the packet's header are read, so a packet that's shorter
+// than pkt is an error instead of being filled in with leftovers from earlier
+// packets. Blue Burst packets are decoded by packets.Unmarshal.
+func (c *Client) Decode(pkt interface{}) error {
+	data := c.buffer[:c.recvSize]
+	if unmarshaler, ok := pkt.(encoding.BinaryUnmarshaler); ok && c.hdrSize == packets.BBHeaderSize {
+		if err := packets.Unmarshal(data, unmarshaler); err != nil {
+			return fmt.Errorf("Malformed %T from %s: %s", pkt, c.IPAddr(), err.Error())
+		}
+		return nil
+	}
+	size := c.declaredSize(data)
+	if int(size) > len(data) {
+		size = uint16(len(data))
+	}
+	if err := util.DecodeStruct(data[:size], pkt); err != nil {
+		return fmt.Errorf("Malformed %T of %d bytes from %s: %s", pkt, size, c.IPAddr(), err.Error())
+	}
+	return nil
+}
+
+func (c *Client) SendEncrypted(data []byte, length int) error {
+	c.sendLock.Lock()
+	defer c.sendLock.Unlock()
+
+	bytes, blen := c.fixLength(data, uint16(length))
+	c.capturePacket(captureOut, bytes[:blen])
 
 	c.Encrypt(bytes, uint32(blen))
-	return c.SendRaw(bytes, int(blen))
+	return c.write(bytes, int(blen))
 }
 
-// fixLength pads the length of a packet to a multiple of 8 and set the first two bytes of the header.
-func fixLength(data []byte, length uint16, hdrSize uint16) ([]byte, uint16) {
-	for length%hdrSize != 0 {
+// fixLength pads the length of a packet to a multiple of the header size and sets the size in the header.
+func (c *Client) fixLength(data []byte, length uint16) ([]byte, uint16) {
+	for length%c.hdrSize != 0 {
 		length++
 		data = append(data, 0)
 	}
-	data[0] = byte(length & 0xFF)
-	data[1] = byte((length & 0xFF00) >> 8)
+	switch c.version {
+	case VersionGC:
+		data[2] = byte(length & 0xFF)
+		data[3] = byte((length & 0xFF00) >> 8)
+	case VersionPC:
+		// Packets for PC clients are built with a LegacyHeader, so move the
+		// type and flags after the size.
+		data[2], data[3] = data[0], data[1]
+		fallthrough
+	default:
+		data[0] = byte(length & 0xFF)
+		data[1] = byte((length & 0xFF00) >> 8)
+	}
 	return data, length
 }
 
 // SendRow writes all data contained in the slice to the client as-is.
 // Note: Packets sent to BB Clients must have a length divisible by 8.
 func (c *Client) SendRaw(data []byte, length int) error {
+	c.capturePacket(captureOut, data[:length])
+	return c.write(data, length)
+}
+
+func (c *Client) write(data []byte, length int) error {
 	bytesSent := 0
 	for bytesSent < length {
 		b, err := c.conn.Write(data[:length])
@@ -110,15 +362,20 @@ func (c *Client) SendRaw(data []byte, length int) error {
 }
 
 // Encrypt a block of data of the given size in-place using the server's cipher
-// in order to prep it for sending to the client.
+// in order to prep it for sending to the client. Connections without a cipher
+// (i.e. those secured by TLS) are left as-is.
 func (c *Client) Encrypt(data []byte, size uint32) {
-	c.serverCrypt.Encrypt(data, size)
+	if c.serverCrypt != nil {
+		c.serverCrypt.Encrypt(data, size)
+	}
 }
 
 // Decrypt a block of data of the given size in-place using the client's cipher
 // in order to prep it for reading.
 func (c *Client) Decrypt(data []byte, size uint32) {
-	c.clientCrypt.Decrypt(data, size)
+	if c.clientCrypt != nil {
+		c.clientCrypt.Decrypt(data, size)
+	}
 }
 
 // Process blocks until we read the next packet from the client. Once we get the full
@@ -139,7 +396,6 @@ func (c *Client) Process() error {
 			// The client disconnected, we're done.
 			return err
 		} else if err != nil {
-			fmt.Println("Sockt error")
 			// Socket error, nothing we can do now.
 			return errors.New("Socket Error (" + c.ipAddr + ") " + err.Error())
 		}
@@ -148,10 +404,9 @@ func (c *Client) Process() error {
 		if c.recvSize >= hdrint {
 			// We have our header; decrypt it.
 			c.Decrypt(c.buffer[:c.hdrSize], uint32(c.hdrSize))
-			c.packetSize, err = util.GetPacketSize(c.buffer[:2])
-			if err != nil {
-				// Something is seriously wrong if this causes an error. Bail.
-				panic(err.Error())
+			c.packetSize = c.declaredSize(c.buffer[:c.hdrSize])
+			if c.packetSize < c.hdrSize {
+				return fmt.Errorf("Packet from %s declared size %d smaller than its header", c.ipAddr, c.packetSize)
 			}
 			// PSO likes to occasionally send us packets that are longer than their declared
 			// size, but are always a multiple of the length of the packet header. Adjust the
@@ -164,8 +419,9 @@ func (c *Client) Process() error {
 	// Grow the client's receive buffer if they send us a packet bigger than its current capacity.
 	if pktSize > cap(c.buffer) {
 		newSize := pktSize + len(c.buffer)
-		newBuf := make([]byte, newSize)
+		newBuf := util.GetBytes(newSize)
 		copy(newBuf, c.buffer)
+		util.PutBytes(c.buffer)
 		c.buffer = newBuf
 	}
 
@@ -188,4 +444,12 @@ func (c *Client) Process() error {
 
 func (c *Client) Close() {
 	c.conn.Close()
+	c.stopCapture()
+}
+
+// Return the client's receive buffer to the pool once its connection has been
+// cleaned up and nothing will read from it again.
+func (c *Client) releaseBuffer() {
+	util.PutBytes(c.buffer)
+	c.buffer = nil
 }
@@ -0,0 +1,578 @@
+/*
+* A Blue Burst player's path through the servers. Each method carries out one
+* step of it on the current connection, or connects to the address the last
+* step was redirected to, and returns once the server has answered.
+ */
+package client
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/dcrodman/archon/util"
+)
+
+// Phase sent in the login packet by a client that has selected a character
+// and is reconnecting to the character server for the ship list.
+const shipListPhase = 4
+
+// LoginError is returned when a server refuses a login, with the error code
+// from its security packet (e.g. 2 for a wrong password, 6 if banned).
+type LoginError uint32
+
+func (e LoginError) Error() string {
+	return fmt.Sprintf("login refused with error %d", uint32(e))
+}
+
+// MessageError is returned when the server shows the player a message instead
+// of answering, which it does before disconnecting them.
+type MessageError string
+
+func (e MessageError) Error() string {
+	return "server sent message: " + string(e)
+}
+
+// ParameterFile is one of the files sent by the character server.
+type ParameterFile struct {
+	Name string
+	Data []byte
+}
+
+// MenuEntry is a ship or block in one of the selection menus.
+type MenuEntry struct {
+	Id   uint32
+	Name string
+}
+
+// Client logs in to the servers as one player. It isn't safe for concurrent
+// use.
+type Client struct {
+	Username string
+	Password string
+	// Sent as the id of the player's machine, which the servers record.
+	HardwareId [8]byte
+	// Sent as the checksum of the client's executable.
+	Checksum uint32
+	// How long to wait to connect to a server or for it to answer.
+	Timeout time.Duration
+
+	// Set by the login server.
+	Guildcard uint32
+	// Set by the character server if it has a message of the day.
+	MOTD string
+
+	conn *Conn
+	// The state the servers have given the client, which it echoes back to them
+	// in its login packets.
+	config clientConfig
+}
+
+// New returns a client that logs in with the given account.
+func New(username, password string) *Client {
+	return &Client{Username: username, Password: password, Timeout: 30 * time.Second}
+}
+
+// Close disconnects from the current server, if any.
+func (c *Client) Close() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// Replace the current connection with one to addr.
+func (c *Client) connect(addr string, patch bool) error {
+	c.Close()
+	var err error
+	if patch {
+		c.conn, err = DialPatch(addr, c.Timeout)
+	} else {
+		c.conn, err = Dial(addr, c.Timeout)
+	}
+	return err
+}
+
+// Wait for a packet of one of the given types, skipping any others (such as
+// scroll messages and timestamps). Security packets update the client's state
+// along the way, unless they refuse the login.
+func (c *Client) next(types ...uint16) (*Packet, error) {
+	if c.conn == nil {
+		return nil, errors.New("not connected")
+	}
+	for {
+		pkt, err := c.conn.Receive()
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range types {
+			if pkt.Type == t {
+				return pkt, nil
+			}
+		}
+		if c.conn.hdrSize == pcHeaderSize {
+			continue
+		}
+		switch pkt.Type {
+		case clientMessageType:
+			return nil, MessageError(decodeMessage(pkt.Data[bbHeaderSize+4:]))
+		case securityType:
+			if err := c.handleSecurity(pkt); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// Wait for a packet of the given type and decode it into pkt.
+func (c *Client) expect(pkt interface{}, pktType uint16) error {
+	p, err := c.next(pktType)
+	if err != nil {
+		return err
+	}
+	return p.Decode(pkt)
+}
+
+func (c *Client) handleSecurity(p *Packet) error {
+	var pkt securityPacket
+	if err := p.Decode(&pkt); err != nil {
+		return err
+	} else if pkt.ErrorCode != 0 {
+		return LoginError(pkt.ErrorCode)
+	}
+	c.Guildcard = pkt.Guildcard
+	c.config = pkt.Config
+	return nil
+}
+
+// Returns the text of a UTF-16 message, on one line.
+func decodeMessage(b []byte) string {
+	var text []uint16
+	for i := 0; i+1 < len(b); i += 2 {
+		if ch := uint16(b[i]) | uint16(b[i+1])<<8; ch != 0 {
+			text = append(text, ch)
+		}
+	}
+	return strings.Replace(string(utf16.Decode(text)), "\n", " ", -1)
+}
+
+func redirectAddr(ip []uint8, port uint16) string {
+	return net.JoinHostPort(net.IP(ip).String(), strconv.Itoa(int(port)))
+}
+
+// Wait for a redirect and return the address it points to, which is an IPv6
+// address if the client connected over IPv6 and the server has one.
+func (c *Client) expectRedirect() (string, error) {
+	p, err := c.next(redirectType)
+	if err != nil {
+		return "", err
+	}
+	if binary.LittleEndian.Uint32(p.Data[4:]) == redirectIPv6Flag {
+		var pkt redirectIPv6Packet
+		if err = p.Decode(&pkt); err != nil {
+			return "", err
+		}
+		return redirectAddr(pkt.IPAddr[:], pkt.Port), nil
+	}
+	var pkt redirectPacket
+	if err = p.Decode(&pkt); err != nil {
+		return "", err
+	}
+	return redirectAddr(pkt.IPAddr[:], pkt.Port), nil
+}
+
+// Send a login packet with the client's state, and wait for the server to
+// accept it.
+func (c *Client) login(slot int8, phase uint16) error {
+	pkt := &loginPacket{
+		Header:       bbHeader{Type: loginType},
+		SlotNum:      slot,
+		Phase:        phase,
+		HardwareInfo: c.HardwareId,
+		Security:     c.config,
+	}
+	copy(pkt.Username[:], c.Username)
+	copy(pkt.Password[:], c.Password)
+	if err := c.conn.Send(pkt); err != nil {
+		return err
+	}
+	p, err := c.next(securityType)
+	if err != nil {
+		return err
+	}
+	return c.handleSecurity(p)
+}
+
+// Answer the patch or data server's welcome and log in once it's acknowledged.
+func (c *Client) patchLogin() error {
+	if err := c.conn.Send(&pcHeader{Type: patchWelcomeType}); err != nil {
+		return err
+	}
+	if _, err := c.next(patchLoginType); err != nil {
+		return err
+	}
+	return c.conn.Send(&pcHeader{Type: patchLoginType})
+}
+
+// Patch connects to the patch server at addr and returns the address of the
+// data server that it redirects to.
+func (c *Client) Patch(addr string) (string, error) {
+	if err := c.connect(addr, true); err != nil {
+		return "", err
+	}
+	if err := c.patchLogin(); err != nil {
+		return "", err
+	}
+	if _, err := c.next(patchMessageType); err != nil {
+		return "", err
+	}
+	p, err := c.next(patchRedirectType)
+	if err != nil {
+		return "", err
+	}
+	// The IPv6 redirect is only told apart by its size.
+	if len(p.Data) >= patchRedirectIPv6Size {
+		var pkt patchRedirectIPv6Packet
+		if err = p.Decode(&pkt); err != nil {
+			return "", err
+		}
+		return redirectAddr(pkt.IPAddr[:], pkt.Port>>8|pkt.Port<<8), nil
+	}
+	var pkt patchRedirectPacket
+	if err = p.Decode(&pkt); err != nil {
+		return "", err
+	}
+	return redirectAddr(pkt.IPAddr[:], pkt.Port>>8|pkt.Port<<8), nil
+}
+
+// CheckFiles connects to the data server at addr and goes through its file
+// list without reporting any files, so that the server has nothing to send.
+func (c *Client) CheckFiles(addr string) error {
+	if err := c.connect(addr, true); err != nil {
+		return err
+	}
+	if err := c.patchLogin(); err != nil {
+		return err
+	}
+	if _, err := c.next(patchFileListDoneType); err != nil {
+		return err
+	}
+	if err := c.conn.Send(&pcHeader{Type: patchClientListDoneType}); err != nil {
+		return err
+	}
+	_, err := c.next(patchUpdateCompleteType)
+	return err
+}
+
+// WaitDisconnected waits for the server to close the connection, as it does after
+// turning a login away, skipping anything it sends first.
+func (c *Client) WaitDisconnected() error {
+	if c.conn == nil {
+		return errors.New("not connected")
+	}
+	for {
+		if _, err := c.conn.Receive(); err == ErrDisconnected {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// Login logs in to the login server at addr and returns the address of the
+// character server that it redirects to.
+func (c *Client) Login(addr string) (string, error) {
+	c.config = clientConfig{}
+	if err := c.connect(addr, false); err != nil {
+		return "", err
+	}
+	if err := c.login(0, 0); err != nil {
+		return "", err
+	}
+	return c.expectRedirect()
+}
+
+// ConnectCharacter logs in to the character server at addr to choose a
+// character.
+func (c *Client) ConnectCharacter(addr string) error {
+	if err := c.connect(addr, false); err != nil {
+		return err
+	}
+	return c.login(0, 0)
+}
+
+// Options returns the player's key config and team data.
+func (c *Client) Options() ([]byte, error) {
+	if err := c.conn.Send(&bbHeader{Type: optionsRequestType}); err != nil {
+		return nil, err
+	}
+	p, err := c.next(optionsType)
+	if err != nil {
+		return nil, err
+	}
+	return p.Data[bbHeaderSize:], nil
+}
+
+// Preview returns the character in a slot, or nil if there isn't one.
+func (c *Client) Preview(slot uint32) (*CharacterPreview, error) {
+	req := &charSelectionPacket{Header: bbHeader{Type: charPreviewReqType}, Slot: slot}
+	if err := c.conn.Send(req); err != nil {
+		return nil, err
+	}
+	p, err := c.next(charPreviewType, charAckType)
+	if err != nil || p.Type == charAckType {
+		return nil, err
+	}
+	var pkt charPreviewPacket
+	if err = p.Decode(&pkt); err != nil {
+		return nil, err
+	}
+	return &pkt.Character, nil
+}
+
+// CreateCharacter creates a character in a slot, replacing any that's there.
+func (c *Client) CreateCharacter(slot uint32, character *CharacterPreview) error {
+	pkt := &charPreviewPacket{Header: bbHeader{Type: charPreviewType}, Slot: slot, Character: *character}
+	if err := c.conn.Send(pkt); err != nil {
+		return err
+	}
+	var ack charAckPacket
+	if err := c.expect(&ack, charAckType); err != nil {
+		return err
+	} else if ack.Flag != 0 {
+		return fmt.Errorf("character creation refused with flag %d", ack.Flag)
+	}
+	return nil
+}
+
+// Download chunks of a transfer until length bytes have been received. Each
+// chunk's data starts offset bytes after the header.
+func (c *Client) download(length int, offset int, request func(chunk uint32) interface{},
+	chunkType uint16) ([]byte, error) {
+
+	var contents []byte
+	for chunk := uint32(0); len(contents) < length; chunk++ {
+		if err := c.conn.Send(request(chunk)); err != nil {
+			return nil, err
+		}
+		p, err := c.next(chunkType)
+		if err != nil {
+			return nil, err
+		}
+		data := p.Data[bbHeaderSize+offset:]
+		// The last chunk is padded.
+		remaining := length - len(contents)
+		if remaining > maxChunkSize {
+			remaining = maxChunkSize
+		}
+		if len(data) > remaining {
+			data = data[:remaining]
+		}
+		contents = append(contents, data...)
+	}
+	return contents, nil
+}
+
+// Guildcards downloads the player's guildcard data and checks it against the
+// checksum that the server sends with it.
+func (c *Client) Guildcards() ([]byte, error) {
+	if err := c.conn.Send(&checksumPacket{Header: bbHeader{Type: checksumType}, Checksum: c.Checksum}); err != nil {
+		return nil, err
+	}
+	if _, err := c.next(checksumAckType); err != nil {
+		return nil, err
+	}
+	if err := c.conn.Send(&bbHeader{Type: guildcardReqType}); err != nil {
+		return nil, err
+	}
+	var header guildcardHeaderPacket
+	if err := c.expect(&header, guildcardHeaderType); err != nil {
+		return nil, err
+	}
+	contents, err := c.download(int(header.Length), 8, func(chunk uint32) interface{} {
+		return &guildcardChunkReqPacket{
+			Header:         bbHeader{Type: guildcardChunkReqType},
+			ChunkRequested: chunk,
+			Continue:       1,
+		}
+	}, guildcardChunkType)
+	if err != nil {
+		return nil, err
+	}
+	if checksum := crc32.ChecksumIEEE(contents); checksum != header.Checksum {
+		return nil, fmt.Errorf("guildcard checksum %08x, expected %08x", checksum, header.Checksum)
+	}
+	return contents, nil
+}
+
+// Parameters downloads the parameter files and checks each one against the
+// checksum that the server sends with it.
+func (c *Client) Parameters() ([]ParameterFile, error) {
+	if err := c.conn.Send(&bbHeader{Type: parameterHeaderReqType}); err != nil {
+		return nil, err
+	}
+	p, err := c.next(parameterHeaderType)
+	if err != nil {
+		return nil, err
+	}
+	var header bbHeader
+	if err = p.Decode(&header); err != nil {
+		return nil, err
+	}
+	pkt := &parameterHeaderPacket{Entries: make([]parameterEntry, header.Flags)}
+	if err = p.Decode(pkt); err != nil {
+		return nil, err
+	}
+	total := 0
+	for _, entry := range pkt.Entries {
+		total += int(entry.Size)
+	}
+
+	contents, err := c.download(total, 4, func(chunk uint32) interface{} {
+		return &bbHeader{Type: parameterChunkReqType, Flags: chunk}
+	}, parameterChunkType)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]ParameterFile, len(pkt.Entries))
+	for i, entry := range pkt.Entries {
+		if int(entry.Offset)+int(entry.Size) > len(contents) {
+			return nil, fmt.Errorf("parameter file %d out of range", i)
+		}
+		files[i] = ParameterFile{
+			Name: string(util.StripPadding(entry.Filename[:])),
+			Data: contents[entry.Offset : entry.Offset+entry.Size],
+		}
+		if checksum := crc32.ChecksumIEEE(files[i].Data); checksum != entry.Checksum {
+			return nil, fmt.Errorf("parameter file %s checksum %08x, expected %08x",
+				files[i].Name, checksum, entry.Checksum)
+		}
+	}
+	return files, nil
+}
+
+// SelectCharacter chooses the character in a slot to play, returning its full
+// character data.
+func (c *Client) SelectCharacter(slot uint32) ([]byte, error) {
+	pkt := &charSelectionPacket{Header: bbHeader{Type: charPreviewReqType}, Slot: slot, Selecting: 1}
+	if err := c.conn.Send(pkt); err != nil {
+		return nil, err
+	}
+	p, err := c.next(fullCharacterType)
+	if err != nil {
+		return nil, err
+	}
+	character := p.Data[bbHeaderSize:]
+	var ack charAckPacket
+	if err = c.expect(&ack, charAckType); err != nil {
+		return nil, err
+	} else if ack.Flag != 1 {
+		return nil, fmt.Errorf("character selection refused with flag %d", ack.Flag)
+	}
+	return character, nil
+}
+
+// ShipList reconnects to the character server at addr with the selected
+// character, as the game does, and returns the ships on the ship list.
+func (c *Client) ShipList(addr string) ([]MenuEntry, error) {
+	if err := c.connect(addr, false); err != nil {
+		return nil, err
+	}
+	if err := c.login(int8(c.config.SlotNum), shipListPhase); err != nil {
+		return nil, err
+	}
+	// The message of the day comes first, if there is one.
+	p, err := c.next(shipListType, clientMessageType)
+	if err == nil && p.Type == clientMessageType {
+		c.MOTD = decodeMessage(p.Data[bbHeaderSize+4:])
+		p, err = c.next(shipListType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var header bbHeader
+	if err = p.Decode(&header); err != nil {
+		return nil, err
+	}
+	pkt := &shipListPacket{Entries: make([]shipMenuEntry, header.Flags)}
+	if err = p.Decode(pkt); err != nil {
+		return nil, err
+	}
+	// Leaving out the other items, such as the information menu.
+	var ships []MenuEntry
+	for _, entry := range pkt.Entries {
+		if entry.MenuId == shipSelectionMenuId {
+			ships = append(ships, MenuEntry{Id: entry.ShipId, Name: decodeMessage(entry.Shipname[:])})
+		}
+	}
+	return ships, nil
+}
+
+// Pick an item from the ship or block menu and return where it redirects to.
+func (c *Client) selectMenuItem(menuId uint16, id uint32) (string, error) {
+	pkt := &menuSelectionPacket{Header: bbHeader{Type: menuSelectType}, MenuId: menuId, ItemId: id}
+	if err := c.conn.Send(pkt); err != nil {
+		return "", err
+	}
+	return c.expectRedirect()
+}
+
+// SelectShip picks a ship from the ship list and returns its address.
+func (c *Client) SelectShip(id uint32) (string, error) {
+	return c.selectMenuItem(shipSelectionMenuId, id)
+}
+
+// JoinShip logs in to the ship at addr and returns the blocks on its block
+// list.
+func (c *Client) JoinShip(addr string) ([]MenuEntry, error) {
+	if err := c.connect(addr, false); err != nil {
+		return nil, err
+	}
+	if err := c.login(int8(c.config.SlotNum), 0); err != nil {
+		return nil, err
+	}
+	p, err := c.next(blockListType)
+	if err != nil {
+		return nil, err
+	}
+	var header bbHeader
+	if err = p.Decode(&header); err != nil {
+		return nil, err
+	}
+	pkt := &blockListPacket{Entries: make([]blockMenuEntry, header.Flags)}
+	if err = p.Decode(pkt); err != nil {
+		return nil, err
+	}
+	var blocks []MenuEntry
+	for _, entry := range pkt.Entries {
+		if entry.BlockId != backMenuItem {
+			blocks = append(blocks, MenuEntry{Id: entry.BlockId, Name: decodeMessage(entry.BlockName[:])})
+		}
+	}
+	return blocks, nil
+}
+
+// SelectBlock picks a block from the block list and returns its address.
+func (c *Client) SelectBlock(id uint32) (string, error) {
+	return c.selectMenuItem(blockSelectionMenuId, id)
+}
+
+// JoinBlock logs in to the block at addr with the selected character and
+// waits for the block to ask for the character's data, which is as far as
+// logging in goes before the player is put in a lobby.
+func (c *Client) JoinBlock(addr string) error {
+	if err := c.connect(addr, false); err != nil {
+		return err
+	}
+	if err := c.login(int8(c.config.SlotNum), 0); err != nil {
+		return err
+	}
+	_, err := c.next(charDataRequestType)
+	return err
+}
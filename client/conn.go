@@ -0,0 +1,184 @@
+/*
+* The client side of the PSO protocol, for tools that need to talk to the
+* servers the way the game does (load tests, integration tests, bots, etc.)
+* without depending on the server's code.
+*
+* Conn handles a single connection: the welcome packet that carries the
+* encryption keys and the framing and encryption of the packets after it.
+* Client builds on it to log in to the servers as a Blue Burst player and
+* follow the redirects from one server to the next.
+ */
+package client
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	crypto "github.com/dcrodman/archon/libarchon/encryption"
+	"github.com/dcrodman/archon/util"
+)
+
+const (
+	// Sizes of the packet headers used by the patch and data servers (PC) and
+	// by everything else (BB).
+	pcHeaderSize = 4
+	bbHeaderSize = 8
+)
+
+// ErrDisconnected is returned when the server closes the connection.
+var ErrDisconnected = errors.New("disconnected by the server")
+
+// Packet is a packet received from a server, after decryption.
+type Packet struct {
+	Type uint16
+	// The whole packet, header included, up to the size given in its header.
+	Data []byte
+}
+
+// Decode fills in pkt, a pointer to a packet struct, from the packet's data.
+// Slices in pkt are filled in at their current length.
+func (p *Packet) Decode(pkt interface{}) error {
+	if err := util.DecodeStruct(p.Data, pkt); err != nil {
+		return fmt.Errorf("malformed %T of %d bytes: %s", pkt, len(p.Data), err.Error())
+	}
+	return nil
+}
+
+// Conn is an encrypted connection to one of the servers. It isn't safe for
+// concurrent use.
+type Conn struct {
+	conn    net.Conn
+	hdrSize int
+	// The client encrypts with the client key from the welcome packet and the
+	// server with the server key.
+	encrypt crypto.Crypt
+	decrypt crypto.Crypt
+	// How long Receive waits for a packet. Zero waits forever.
+	Timeout time.Duration
+}
+
+// DialPatch connects to a patch or data server, which use the PC protocol.
+func DialPatch(addr string, timeout time.Duration) (*Conn, error) {
+	c, err := dial(addr, timeout, pcHeaderSize)
+	if err != nil {
+		return nil, err
+	}
+	var welcome patchWelcomePacket
+	if err = c.welcome(&welcome, patchWelcomeType); err != nil {
+		return nil, err
+	}
+	if c.encrypt, err = crypto.NewPCCryptFromKey(welcome.ClientVector[:]); err == nil {
+		c.decrypt, err = crypto.NewPCCryptFromKey(welcome.ServerVector[:])
+	}
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Dial connects to any of the other servers, which use the BB protocol.
+func Dial(addr string, timeout time.Duration) (*Conn, error) {
+	c, err := dial(addr, timeout, bbHeaderSize)
+	if err != nil {
+		return nil, err
+	}
+	var welcome welcomePacket
+	if err = c.welcome(&welcome, welcomeType); err != nil {
+		return nil, err
+	}
+	if c.encrypt, err = crypto.NewBBCryptFromKey(welcome.ClientVector[:]); err == nil {
+		c.decrypt, err = crypto.NewBBCryptFromKey(welcome.ServerVector[:])
+	}
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func dial(addr string, timeout time.Duration, hdrSize int) (*Conn, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{conn: conn, hdrSize: hdrSize, Timeout: timeout}, nil
+}
+
+// Read the welcome packet, which is the only one that isn't encrypted.
+func (c *Conn) welcome(pkt interface{}, pktType uint16) error {
+	welcome, err := c.Receive()
+	if err == nil && welcome.Type != pktType {
+		err = fmt.Errorf("expected welcome packet %02x, got %02x", pktType, welcome.Type)
+	}
+	if err == nil {
+		err = welcome.Decode(pkt)
+	}
+	if err != nil {
+		c.Close()
+	}
+	return err
+}
+
+// Close the connection.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// Send encodes pkt, a pointer to a packet struct, and sends it with the size
+// in its header filled in.
+func (c *Conn) Send(pkt interface{}) error {
+	data := util.AppendStruct(util.GetBytes(0), pkt)
+	defer util.PutBytes(data)
+	for len(data)%c.hdrSize != 0 {
+		data = append(data, 0)
+	}
+	binary.LittleEndian.PutUint16(data, uint16(len(data)))
+	c.encrypt.Encrypt(data, uint32(len(data)))
+	_, err := c.conn.Write(data)
+	return err
+}
+
+// Receive waits for the next packet from the server.
+func (c *Conn) Receive() (*Packet, error) {
+	if c.Timeout > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(c.Timeout))
+	}
+	header := make([]byte, c.hdrSize)
+	if err := c.read(header); err != nil {
+		return nil, err
+	}
+	size := int(binary.LittleEndian.Uint16(header))
+	if size < c.hdrSize {
+		return nil, fmt.Errorf("packet declared size %d smaller than its header", size)
+	}
+	// Packets are padded to a multiple of the header size, which isn't always
+	// included in the size.
+	padded := size
+	for padded%c.hdrSize != 0 {
+		padded++
+	}
+	data := make([]byte, padded)
+	copy(data, header)
+	if err := c.read(data[c.hdrSize:]); err != nil {
+		return nil, err
+	}
+	return &Packet{Type: binary.LittleEndian.Uint16(data[2:]), Data: data[:size]}, nil
+}
+
+// Read and decrypt exactly len(b) bytes.
+func (c *Conn) read(b []byte) error {
+	if _, err := io.ReadFull(c.conn, b); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrDisconnected
+	} else if err != nil {
+		return err
+	}
+	if c.decrypt != nil {
+		c.decrypt.Decrypt(b, uint32(len(b)))
+	}
+	return nil
+}
@@ -0,0 +1,278 @@
+/*
+* Packets sent and received by the client, laid out as they are on the wire.
+ */
+package client
+
+// Packet types for the patch and data servers.
+const (
+	patchWelcomeType        = 0x02
+	patchLoginType          = 0x04
+	patchMessageType        = 0x13
+	patchRedirectType       = 0x14
+	patchFileListDoneType   = 0x0D
+	patchClientListDoneType = 0x10
+	patchUpdateCompleteType = 0x12
+)
+
+// Packet types for the login, character, ship, and block servers.
+const (
+	welcomeType            = 0x03
+	loginType              = 0x93
+	securityType           = 0xE6
+	redirectType           = 0x19
+	clientMessageType      = 0x1A
+	optionsRequestType     = 0xE0
+	optionsType            = 0xE2
+	charPreviewReqType     = 0xE3
+	charAckType            = 0xE4
+	charPreviewType        = 0xE5
+	fullCharacterType      = 0xE7
+	checksumType           = 0x01E8
+	checksumAckType        = 0x02E8
+	guildcardReqType       = 0x03E8
+	guildcardHeaderType    = 0x01DC
+	guildcardChunkType     = 0x02DC
+	guildcardChunkReqType  = 0x03DC
+	parameterHeaderType    = 0x01EB
+	parameterChunkType     = 0x02EB
+	parameterChunkReqType  = 0x03EB
+	parameterHeaderReqType = 0x04EB
+	shipListType           = 0xA0
+	menuSelectType         = 0x10
+	blockListType          = 0x07
+	charDataRequestType    = 0x95
+)
+
+// Ids of the menus sent in menu selection packets.
+const (
+	shipSelectionMenuId  uint16 = 0x13
+	blockSelectionMenuId uint16 = 0x12
+	// Block id of the entry at the end of the block list that goes back to the
+	// ship list.
+	backMenuItem = 0xFF
+)
+
+// Set in the header flags of a redirect to an IPv6 address.
+const redirectIPv6Flag = 0x06
+
+// Size of patchRedirectIPv6Packet, which has no flags to tell it apart.
+const patchRedirectIPv6Size = 24
+
+// Largest amount of guildcard or parameter data sent in one chunk.
+const maxChunkSize = 0x6800
+
+type pcHeader struct {
+	Size uint16
+	Type uint16
+}
+
+type bbHeader struct {
+	Size  uint16
+	Type  uint16
+	Flags uint32
+}
+
+type patchWelcomePacket struct {
+	Header       pcHeader
+	Copyright    [44]byte
+	Padding      [20]byte
+	ServerVector [4]byte
+	ClientVector [4]byte
+}
+
+type patchRedirectPacket struct {
+	Header pcHeader
+	IPAddr [4]uint8
+	// In network byte order, unlike the BB redirect.
+	Port    uint16
+	Padding uint16
+}
+
+type patchRedirectIPv6Packet struct {
+	Header  pcHeader
+	IPAddr  [16]uint8
+	Port    uint16
+	Padding uint16
+}
+
+type welcomePacket struct {
+	Header       bbHeader
+	Copyright    [96]byte
+	ServerVector [48]byte
+	ClientVector [48]byte
+}
+
+type loginPacket struct {
+	Header         bbHeader
+	Unknown        [8]byte
+	ClientVersion  uint16
+	Unknown2       [3]byte
+	SlotNum        int8
+	Phase          uint16
+	TeamId         uint32
+	Username       [16]byte
+	Padding        [32]byte
+	Password       [16]byte
+	Unknown3       [32]byte
+	MenuId         uint32
+	PreferredLobby uint32
+	HardwareInfo   [8]byte
+	Security       clientConfig
+}
+
+// State that the servers keep in the client, which sends it back to each server
+// it logs in to.
+type clientConfig struct {
+	Magic        uint32
+	CharSelected uint8
+	SlotNum      uint8
+	Flags        uint16
+	Ports        [4]uint16
+	SessionToken [16]byte
+	Unused       [2]uint32
+}
+
+type securityPacket struct {
+	Header       bbHeader
+	ErrorCode    uint32
+	PlayerTag    uint32
+	Guildcard    uint32
+	TeamId       uint32
+	Config       clientConfig
+	Capabilities uint32
+}
+
+type redirectPacket struct {
+	Header  bbHeader
+	IPAddr  [4]uint8
+	Port    uint16
+	Padding uint16
+}
+
+type redirectIPv6Packet struct {
+	Header  bbHeader
+	IPAddr  [16]uint8
+	Port    uint16
+	Padding uint16
+}
+
+type charSelectionPacket struct {
+	Header    bbHeader
+	Slot      uint32
+	Selecting uint32
+}
+
+type charAckPacket struct {
+	Header bbHeader
+	Slot   uint32
+	Flag   uint32
+}
+
+// CharacterPreview is the summary of a character shown on the character select
+// screen, which is also what's sent to create one.
+type CharacterPreview struct {
+	Experience     uint32
+	Level          uint32
+	GuildcardStr   [16]byte
+	Unknown        [2]uint32
+	NameColor      uint32
+	Model          byte
+	Padding        [15]byte
+	NameColorChksm uint32
+	SectionID      byte
+	Class          byte
+	V2Flags        byte
+	Version        byte
+	V1Flags        uint32
+	Costume        uint16
+	Skin           uint16
+	Face           uint16
+	Head           uint16
+	Hair           uint16
+	HairRed        uint16
+	HairGreen      uint16
+	HairBlue       uint16
+	PropX          float32
+	PropY          float32
+	// UTF-16, starting with a language tag such as "\tE".
+	Name     [32]uint8
+	Playtime uint32
+}
+
+type charPreviewPacket struct {
+	Header    bbHeader
+	Slot      uint32
+	Character CharacterPreview
+}
+
+type checksumPacket struct {
+	Header   bbHeader
+	Checksum uint32
+	Unused   uint32
+}
+
+type guildcardHeaderPacket struct {
+	Header   bbHeader
+	Unknown  uint32
+	Length   uint16
+	Padding  uint16
+	Checksum uint32
+}
+
+type guildcardChunkReqPacket struct {
+	Header         bbHeader
+	Unknown        uint32
+	ChunkRequested uint32
+	Continue       uint32
+}
+
+type parameterEntry struct {
+	Size     uint32
+	Checksum uint32
+	Offset   uint32
+	Filename [0x40]uint8
+}
+
+type parameterHeaderPacket struct {
+	Header  bbHeader
+	Entries []parameterEntry
+}
+
+type shipMenuEntry struct {
+	MenuId   uint16
+	ShipId   uint32
+	Padding  uint16
+	Shipname [36]byte
+}
+
+type shipListPacket struct {
+	Header   bbHeader
+	Padding  uint16
+	Unknown  uint16
+	Unknown2 uint32
+	Unknown3 uint16
+	Name     [36]byte
+	Entries  []shipMenuEntry
+}
+
+type blockMenuEntry struct {
+	MenuId    uint16
+	BlockId   uint32
+	Padding   uint16
+	BlockName [36]byte
+}
+
+type blockListPacket struct {
+	Header   bbHeader
+	Padding  [10]byte
+	ShipName [32]byte
+	Unknown  uint32
+	Entries  []blockMenuEntry
+}
+
+type menuSelectionPacket struct {
+	Header  bbHeader
+	Unknown uint16
+	MenuId  uint16
+	ItemId  uint32
+}
@@ -0,0 +1,350 @@
+/*
+* Load testing with scripted clients. "archon-stress <clients> [rounds]" starts
+* the given number of bots, each of which goes through the servers the way a
+* Blue Burst client does (patch, data, login, character select, ship, and block)
+* using the client package, and reports how long each step took
+* once they've all finished. Errors are counted by step, so that problems that
+* only show up when many clients log in at once stand out.
+*
+* Only the addresses of the patch and login servers are needed (-patch and
+* -login); the rest are followed from their redirects, so it can be run from
+* anywhere the servers are reachable.
+*
+* The bots log in as stress1, stress2, etc. with the password "stress", and
+* create a character in the first slot the first time through. Given the URL of
+* the web API with -register, the accounts are registered there first if they
+* don't exist. Otherwise they need to have been registered beforehand (for
+* example with "archon account create"). They skip the patch file checks, so
+* the data server treats them as up to date, and disconnect once the block
+* server asks for their character. The bots all connect from one address, so it should be
+* in the rate limit whitelist.
+ */
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dcrodman/archon/client"
+	"github.com/dcrodman/archon/util"
+)
+
+const (
+	stressUsernamePrefix = "stress"
+	stressPassword       = "stress"
+	// How long a bot waits for each packet before giving up on the step.
+	stressTimeout = 30 * time.Second
+	// Number of distinct errors listed for each step in the report.
+	maxStressErrors = 5
+	// Class of the characters the bots create (HUmar).
+	stressClass = 0x00
+)
+
+var (
+	patchAddr   = flag.String("patch", "127.0.0.1:11000", "Address of the patch server")
+	loginAddr   = flag.String("login", "127.0.0.1:12000", "Address of the login server")
+	registerURL = flag.String("register", "", "URL of the web API to register the bots' accounts with, "+
+		"such as http://127.0.0.1:14000")
+)
+
+// A step each bot goes through, in order, under which its latency is reported.
+type stressStep struct {
+	name string
+	run  func(b *stressBot) error
+}
+
+var stressSteps = []stressStep{
+	{"patch", (*stressBot).patch},
+	{"data", (*stressBot).checkFiles},
+	{"login", (*stressBot).login},
+	{"character", (*stressBot).loadCharacter},
+	{"guildcards", (*stressBot).guildcards},
+	{"parameters", (*stressBot).parameters},
+	{"select", (*stressBot).selectCharacter},
+	{"ship list", (*stressBot).shipList},
+	{"ship", (*stressBot).joinShip},
+	{"block", (*stressBot).joinBlock},
+}
+
+// Latencies and errors collected from all of the bots.
+type stressResults struct {
+	lock      sync.Mutex
+	latencies map[string][]time.Duration
+	errors    map[string]map[string]int
+}
+
+func (r *stressResults) record(step string, latency time.Duration, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if err == nil {
+		r.latencies[step] = append(r.latencies[step], latency)
+		return
+	}
+	if r.errors[step] == nil {
+		r.errors[step] = make(map[string]int)
+	}
+	r.errors[step][err.Error()]++
+}
+
+// Returns the latency that p percent of the sorted latencies are at or under.
+func percentile(latencies []time.Duration, p int) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+	i := (len(latencies)*p+99)/100 - 1
+	if i < 0 {
+		i = 0
+	}
+	return latencies[i]
+}
+
+func formatLatency(d time.Duration) string {
+	return d.Round(100 * time.Microsecond).String()
+}
+
+// Print the latency percentiles and error counts for each step.
+func (r *stressResults) report() {
+	fmt.Printf("%-12s %7s %7s %10s %10s %10s %10s\n", "step", "ok", "errors", "p50", "p90", "p99", "max")
+	for _, step := range stressSteps {
+		latencies := r.latencies[step.name]
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		errorCount := 0
+		for _, n := range r.errors[step.name] {
+			errorCount += n
+		}
+		fmt.Printf("%-12s %7d %7d %10s %10s %10s %10s\n", step.name, len(latencies), errorCount,
+			formatLatency(percentile(latencies, 50)), formatLatency(percentile(latencies, 90)),
+			formatLatency(percentile(latencies, 99)), formatLatency(percentile(latencies, 100)))
+	}
+
+	for _, step := range stressSteps {
+		errs := r.errors[step.name]
+		if len(errs) == 0 {
+			continue
+		}
+		messages := make([]string, 0, len(errs))
+		for message := range errs {
+			messages = append(messages, message)
+		}
+		// Most common first.
+		sort.Slice(messages, func(i, j int) bool {
+			if errs[messages[i]] != errs[messages[j]] {
+				return errs[messages[i]] > errs[messages[j]]
+			}
+			return messages[i] < messages[j]
+		})
+		fmt.Printf("\nErrors during %s:\n", step.name)
+		for i, message := range messages {
+			if i == maxStressErrors {
+				fmt.Printf("  ...and %d more\n", len(messages)-i)
+				break
+			}
+			fmt.Printf("  %dx %s\n", errs[message], message)
+		}
+	}
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: archon-stress [flags] <clients> [rounds]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if err := runStress(flag.Args()); err != nil {
+		fmt.Println("Failed: " + err.Error())
+		os.Exit(1)
+	}
+}
+
+// Run the bots given on the command line:
+//
+//	archon-stress [flags] <clients> [rounds]
+func runStress(args []string) error {
+	usage := errors.New("usage: archon-stress [flags] <clients> [rounds]")
+	if len(args) < 1 || len(args) > 2 {
+		return usage
+	}
+	clients, err := strconv.Atoi(args[0])
+	if err != nil || clients < 1 {
+		return usage
+	}
+	rounds := 1
+	if len(args) == 2 {
+		if rounds, err = strconv.Atoi(args[1]); err != nil || rounds < 1 {
+			return usage
+		}
+	}
+	if *registerURL != "" {
+		if err := registerBots(clients); err != nil {
+			return err
+		}
+	}
+
+	results := &stressResults{
+		latencies: make(map[string][]time.Duration),
+		errors:    make(map[string]map[string]int),
+	}
+	fmt.Printf("Running %d clients for %d rounds against %s\n\n", clients, rounds, *loginAddr)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 1; i <= clients; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			bot := newStressBot(id)
+			for round := 0; round < rounds; round++ {
+				bot.run(results)
+			}
+		}(i)
+	}
+	wg.Wait()
+	results.report()
+	fmt.Printf("\nFinished in %s\n", formatLatency(time.Since(start)))
+	return nil
+}
+
+// Register the accounts for the bots through the web API, leaving any that
+// already exist alone.
+func registerBots(clients int) error {
+	url := strings.TrimSuffix(*registerURL, "/") + "/api/accounts"
+	registered := 0
+	for i := 1; i <= clients; i++ {
+		body, _ := json.Marshal(map[string]string{
+			"username": stressUsernamePrefix + strconv.Itoa(i),
+			"password": stressPassword,
+		})
+		resp, err := http.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusCreated:
+			registered++
+		case http.StatusConflict:
+		default:
+			return fmt.Errorf("registering %s%d: %s", stressUsernamePrefix, i, resp.Status)
+		}
+	}
+	if registered > 0 {
+		fmt.Printf("Registered %d stress accounts\n", registered)
+	}
+	return nil
+}
+
+// A scripted player.
+type stressBot struct {
+	id     int
+	client *client.Client
+	// Addresses of the servers it was redirected to.
+	data, character, ship, block string
+}
+
+func newStressBot(id int) *stressBot {
+	b := &stressBot{id: id, client: client.New(stressUsernamePrefix+strconv.Itoa(id), stressPassword)}
+	b.client.Timeout = stressTimeout
+	// A made up machine id for each bot, so that they look like different players.
+	copy(b.client.HardwareId[:], stressUsernamePrefix)
+	binary.LittleEndian.PutUint16(b.client.HardwareId[6:], uint16(id))
+	return b
+}
+
+// Go through every step once, stopping at the first one that fails.
+func (b *stressBot) run(results *stressResults) {
+	defer b.client.Close()
+	for _, step := range stressSteps {
+		start := time.Now()
+		err := step.run(b)
+		results.record(step.name, time.Since(start), err)
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (b *stressBot) patch() (err error) {
+	b.data, err = b.client.Patch(*patchAddr)
+	return err
+}
+
+func (b *stressBot) checkFiles() error {
+	return b.client.CheckFiles(b.data)
+}
+
+func (b *stressBot) login() (err error) {
+	b.character, err = b.client.Login(*loginAddr)
+	return err
+}
+
+// Log in to the character server, load the options, and look at the first
+// slot, creating a character there if it's empty.
+func (b *stressBot) loadCharacter() error {
+	if err := b.client.ConnectCharacter(b.character); err != nil {
+		return err
+	}
+	if _, err := b.client.Options(); err != nil {
+		return err
+	}
+	preview, err := b.client.Preview(0)
+	if err != nil || preview != nil {
+		return err
+	}
+	preview = &client.CharacterPreview{Class: stressClass}
+	copy(preview.Name[:], util.ConvertToUtf16("\tE"+b.client.Username))
+	return b.client.CreateCharacter(0, preview)
+}
+
+func (b *stressBot) guildcards() error {
+	_, err := b.client.Guildcards()
+	return err
+}
+
+func (b *stressBot) parameters() error {
+	_, err := b.client.Parameters()
+	return err
+}
+
+func (b *stressBot) selectCharacter() error {
+	_, err := b.client.SelectCharacter(0)
+	return err
+}
+
+// Reconnect to the character server with the character selected and pick the
+// first ship on the list.
+func (b *stressBot) shipList() error {
+	ships, err := b.client.ShipList(b.character)
+	if err != nil {
+		return err
+	} else if len(ships) == 0 {
+		return errors.New("no ships on the ship list")
+	}
+	b.ship, err = b.client.SelectShip(ships[0].Id)
+	return err
+}
+
+// Log in to the ship and pick a block, spreading the bots across them.
+func (b *stressBot) joinShip() error {
+	blocks, err := b.client.JoinShip(b.ship)
+	if err != nil {
+		return err
+	} else if len(blocks) == 0 {
+		return errors.New("no blocks on the block list")
+	}
+	b.block, err = b.client.SelectBlock(blocks[b.id%len(blocks)].Id)
+	return err
+}
+
+func (b *stressBot) joinBlock() error {
+	return b.client.JoinBlock(b.block)
+}
@@ -0,0 +1,110 @@
+/*
+* Chat commands, mostly for testers and GMs, typed into the chat box on the block
+* server starting with a slash. Each command needs a privilege tier; if the player
+* doesn't have it (or there's no such command) the message is sent as normal
+* chat so that players can't tell which commands exist.
+ */
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/dcrodman/archon/data"
+)
+
+type chatCommand struct {
+	privilege byte
+	// Runs the command given the rest of the message after the command name.
+	run func(c *Client, args string) error
+}
+
+var chatCommands = map[string]chatCommand{
+	"info":       {data.PrivilegeTester, runInfoCommand},
+	"kick":       {data.PrivilegeGM, runKickCommand},
+	"announce":   {data.PrivilegeGM, runAnnounceCommand},
+	"mute":       {data.PrivilegeGM, runMuteCommand},
+	"unmute":     {data.PrivilegeGM, runUnmuteCommand},
+	"bank":       {data.PrivilegePlayer, runBankCommand},
+	"team":       {data.PrivilegePlayer, runTeamCommand},
+	"lobbyevent": {data.PrivilegeGM, runLobbyEventCommand},
+}
+
+// Decode a chat message from the client, dropping the language marker (e.g. \tE)
+// that it starts with.
+func chatText(message []byte) string {
+	chars := make([]uint16, len(message)/2)
+	for i := range chars {
+		chars[i] = binary.LittleEndian.Uint16(message[i*2:])
+	}
+	text := string(utf16.Decode(chars))
+	if len(text) >= 2 && text[0] == '\t' {
+		text = text[2:]
+	}
+	return text
+}
+
+// Run the chat command in message if it is one that the player on c is allowed
+// to use. Returns false if the message should be sent as chat instead.
+func runChatCommand(c *Client, message []byte) bool {
+	text := chatText(message)
+	if !strings.HasPrefix(text, "/") {
+		return false
+	}
+	name, args := text[1:], ""
+	if i := strings.IndexByte(name, ' '); i >= 0 {
+		name, args = name[:i], strings.TrimSpace(name[i+1:])
+	}
+	command, ok := chatCommands[strings.ToLower(name)]
+	if !ok || !c.hasPrivilege(command.privilege) {
+		return false
+	}
+
+	c.log.Infof("Running command %s", text)
+	if err := command.run(c, args); err != nil {
+		SendClientMessage(c, err.Error())
+	}
+	return true
+}
+
+// Show the details of the player's connection, for testing.
+func runInfoCommand(c *Client, args string) error {
+	where := "nowhere"
+	if c.game != nil {
+		where = fmt.Sprintf("game %d", c.game.id)
+	} else if c.lobby != nil {
+		where = fmt.Sprintf("block %d lobby %d", c.lobby.block, c.lobby.id+1)
+	}
+	return SendClientMessage(c, fmt.Sprintf("Connection %s\nGuildcard %d (%s)\n%s, client %d",
+		c.id, c.guildcard, privilegeName(c.privilegeLevel), where, c.clientId))
+}
+
+// Disconnect a player on the ship by guildcard, optionally with a message.
+func runKickCommand(c *Client, args string) error {
+	fields := strings.SplitN(args, " ", 2)
+	if fields[0] == "" {
+		return errors.New("Usage: /kick <guildcard> [message]")
+	}
+	target, err := findCommandTarget(c, fields[0])
+	if err != nil {
+		return err
+	}
+	if len(fields) == 2 {
+		SendClientMessage(target, fields[1])
+	}
+	target.log.Infof("Kicked by guildcard %d", c.guildcard)
+	target.Close()
+	return SendClientMessage(c, fmt.Sprintf("Kicked guildcard %d.", target.guildcard))
+}
+
+// Send a message to every player on every ship.
+func runAnnounceCommand(c *Client, args string) error {
+	if args == "" {
+		return errors.New("Usage: /announce <message>")
+	}
+	SendShipMessage(0, 0, args)
+	return nil
+}
@@ -1,10 +1,12 @@
 package main
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
 	"errors"
+	"fmt"
+	"net"
 
+	"github.com/dcrodman/archon/data"
+	"github.com/dcrodman/archon/packets"
 	"github.com/dcrodman/archon/util"
 )
 
@@ -27,135 +29,183 @@ const (
 	Ramarl              = 0x0B
 )
 
-// Per-player guildcard data chunk.
-type GuildcardData struct {
-	Unknown  [0x114]uint8
-	Blocked  [0x1DE8]uint8 //This should be a struct once implemented
-	Unknown2 [0x78]uint8
-	Entries  [104]GuildcardDataEntry
-	Unknown3 [0x1BC]uint8
-}
+// Technique ids, which are the indexes of each technique's level in the
+// character data.
+const (
+	TechFoie = iota
+	TechGifoie
+	TechRafoie
+	TechBarta
+	TechGibarta
+	TechRabarta
+	TechZonde
+	TechGizonde
+	TechRazonde
+	TechGrants
+	TechDeband
+	TechJellen
+	TechZalure
+	TechShifta
+	TechRyuker
+	TechResta
+	TechAnti
+	TechReverser
+	TechMegid
+)
 
-// Per-player friend guildcard entries.
-type GuildcardDataEntry struct {
-	Guildcard   uint32
-	Name        [24]uint16
-	TeamName    [16]uint16
-	Description [88]uint16
-	Reserved    uint8
-	Language    uint8
-	SectionID   uint8
-	CharClass   uint8
-	padding     uint32
-	Comment     [88]uint16
-}
-
-// Struct used by Character Info packet.
-type CharacterPreview struct {
-	Experience     uint32
-	Level          uint32
-	GuildcardStr   [16]byte
-	Unknown        [2]uint32
-	NameColor      uint32
-	Model          byte
-	Padding        [15]byte
-	NameColorChksm uint32
-	SectionID      byte
-	Class          byte
-	V2Flags        byte
-	Version        byte
-	V1Flags        uint32
-	Costume        uint16
-	Skin           uint16
-	Face           uint16
-	Head           uint16
-	Hair           uint16
-	HairRed        uint16
-	HairGreen      uint16
-	HairBlue       uint16
-	PropX          float32
-	PropY          float32
-	Name           [24]uint8
-	Playtime       uint32
-}
+// Level sent to the client for techniques that haven't been learned.
+const TechniqueNotLearned = 0xFF
 
 // Copyright message expected by the client when connecting.
 var LoginCopyright = []byte("Phantasy Star Online Blue Burst Game Server. Copyright 1999-2004 SONICTEAM.")
 
 // VerifyAccount performs all account verification tasks.
-func VerifyAccount(client *Client) (*LoginPkt, error) {
-	var loginPkt LoginPkt
-	util.StructFromBytes(client.Data(), &loginPkt)
+func VerifyAccount(client *Client) (*packets.LoginPkt, error) {
+	var loginPkt packets.LoginPkt
+	if err := client.Decode(&loginPkt); err != nil {
+		return nil, err
+	}
 
 	pktUsername := string(util.StripPadding(loginPkt.Username[:]))
-	pktPassword := hashPassword(loginPkt.Password[:])
-	account, err := database.FindAccount(pktUsername)
+	pktPassword := string(util.StripPadding(loginPkt.Password[:]))
+	account, err := client.db().FindAccount(pktUsername)
+	var twoFactor *data.TwoFactor
+	if err == nil && account != nil {
+		twoFactor, err = client.db().FindTwoFactor(pktUsername)
+	}
+	if twoFactor != nil && twoFactor.Enabled {
+		// The code is checked by the login server (see checkTwoFactorLogin).
+		pktPassword, _ = splitTwoFactorCode(pktPassword)
+	}
 
 	switch {
 	case err != nil:
-		SendClientMessage(client, "Encountered an unexpected error while accessing the "+
-			"database.\n\nPlease contact your server administrator.")
-		log.Error(err.Error())
+		sendDatabaseError(client, err)
+		return nil, err
 	case account == nil:
-	case account.Password != pktPassword:
 		// The same error is returned for invalid passwords as attempts to log in
 		// with a nonexistent username as some measure of account security.
-		SendSecurity(client, BBLoginErrorPassword, 0, 0)
-		return nil, errors.New("Account does not exist for username: " + pktUsername)
+		checkDecoyPassword(pktPassword)
+		SendSecurity(client, packets.BBLoginErrorPassword, 0, 0)
+		return nil, refuseLogin(data.LoginUnknownAccount,
+			errors.New("Account does not exist for username: "+pktUsername))
+	case !checkPassword(account.Password, pktPassword):
+		SendSecurity(client, packets.BBLoginErrorPassword, 0, 0)
+		return nil, refuseLogin(data.LoginBadPassword,
+			errors.New("Incorrect password for username: "+pktUsername))
 	case !account.Active:
 		SendClientMessage(client, "Encountered an unexpected error while accessing the "+
 			"database.\n\nPlease contact your server administrator.")
-		return nil, errors.New("Account must be activated for username: " + pktUsername)
-	case account.Banned:
-		SendSecurity(client, BBLoginErrorBanned, 0, 0)
-		return nil, errors.New("Account banned: " + pktUsername)
+		return nil, refuseLogin(data.LoginInactive,
+			errors.New("Account must be activated for username: "+pktUsername))
+	}
+	if needsRehash(account.Password) {
+		rehashPassword(client, pktUsername, pktPassword)
+	}
+	client.hardwareId = hardwareId(loginPkt.HardwareInfo)
+	if err = loginAccount(client, account); err != nil {
+		return nil, err
 	}
+
 	// Copy over the config, which should indicate how far they are in the login flow.
 	util.StructFromBytes(loginPkt.Security[:], &client.config)
-
-	// TODO: Account, hardware, and IP ban checks.
 	return &loginPkt, nil
 }
 
-// Passwords are stored as sha256 hashes, so hash what the client sent us for the query.
-func hashPassword(password []byte) string {
-	hasher := sha256.New()
-	hasher.Write(util.StripPadding(password))
-	return hex.EncodeToString(hasher.Sum(nil)[:])
+// Make sure that none of the bans apply to the account that the client logged in
+// with once its credentials have been checked, and then set the client up for it.
+// The client is left without an account if it's turned away. The session limits
+// are left to admitSession, once the rest of the checks on the login have passed.
+func loginAccount(client *Client, account *data.Account) error {
+	guildcard := uint32(account.Guildcard)
+	ban, err := client.db().FindActiveBan(
+		accountBanTargets(guildcard, account.Username, client.IPAddr(), client.hardwareId))
+	if err != nil {
+		sendDatabaseError(client, err)
+		return err
+	} else if ban != nil {
+		sendBanMessage(client, ban)
+		return refuseLogin(data.LoginBanned,
+			fmt.Errorf("Account %s is banned by ban %d", account.Username, ban.Id))
+	}
+
+	client.username = account.Username
+	client.guildcard = guildcard
+	client.teamId = uint32(account.TeamID)
+	client.privilegeLevel = account.PrivilegeLevel
+	client.mutedUntil, client.shadowMuted = account.MutedUntil, account.ShadowMuted
+	client.log = client.log.WithField("guildcard", client.guildcard)
+	updateCapture(client)
+	return nil
+}
+
+// Turn away the player on c if the server is in maintenance mode and their
+// account doesn't have the privileges to log in anyway.
+func checkMaintenance(client *Client) error {
+	maintenance := config.Maintenance()
+	if !maintenance.MaintenanceEnabled || client.hasPrivilege(maintenance.minPrivilege) {
+		return nil
+	}
+	if maintenance.MaintenanceMessage != "" || client.version != VersionBB {
+		message := maintenance.MaintenanceMessage
+		if message == "" {
+			message = "The server is down for maintenance."
+		}
+		SendClientMessage(client, message)
+	} else {
+		SendSecurity(client, packets.BBLoginErrorMaintenance, 0, 0)
+	}
+	return refuseLogin(data.LoginMaintenance,
+		errors.New("Refused login during maintenance for username: "+client.username))
+}
+
+// Replace an account's password hash with one using the configured scheme. The
+// player on client has already logged in, so failures are only logged.
+func rehashPassword(client *Client, username, password string) {
+	hash, err := hashPassword(password)
+	if err == nil {
+		err = client.db().UpdatePassword(username, hash)
+	}
+	if err != nil {
+		client.log.Warn("Failed to rehash password for " + username + ": " + err.Error())
+	}
 }
 
 // SendClientMessage is used for error messages to the client, usually used before disconnecting.
 func SendClientMessage(client *Client, message string) error {
-	pkt := &LoginClientMessagePacket{
-		Header: BBHeader{Type: LoginClientMessageType},
+	if client.version != VersionBB {
+		return sendLegacyClientMessage(client, message)
+	}
+	pkt := &packets.LoginClientMessagePacket{
+		Header: packets.BBHeader{Type: packets.LoginClientMessageType},
 		// English? Tethealla sets this.
 		Language: 0x00450009,
 		Message:  util.ConvertToUtf16(message),
 	}
-	DebugLog("Sending Client Message Packet")
+	client.log.Debug("Sending Client Message Packet")
 	return EncryptAndSend(client, pkt)
 }
 
 // SendWelcome transmits the welcome packet to a client with the copyright message and encryption vectors.
 func SendWelcome(client *Client) error {
-	pkt := new(WelcomePkt)
-	pkt.Header.Type = LoginWelcomeType
+	pkt := new(packets.WelcomePkt)
+	pkt.Header.Type = packets.LoginWelcomeType
 	pkt.Header.Size = 0xC8
 	copy(pkt.Copyright[:], LoginCopyright)
 	copy(pkt.ClientVector[:], client.ClientVector())
 	copy(pkt.ServerVector[:], client.ServerVector())
 
-	DebugLog("Sending Welcome Packet")
+	client.log.Debug("Sending Welcome Packet")
 	data, size := util.BytesFromStruct(pkt)
 	return client.SendRaw(data, size)
 }
 
 // SendSecurity transmits initialization packet with information about the user's
 // authentication status. This is used by everything except the patch server.
-func SendSecurity(client *Client, errorCode BBLoginError, guildcard uint32, teamId uint32) error {
+func SendSecurity(client *Client, errorCode packets.BBLoginError, guildcard uint32, teamId uint32) error {
 	// Constants set according to how Newserv does it.
-	pkt := &SecurityPacket{
-		Header:       BBHeader{Type: LoginSecurityType},
+	pkt := &packets.SecurityPacket{
+		Header:       packets.BBHeader{Type: packets.LoginSecurityType},
 		ErrorCode:    uint32(errorCode),
 		PlayerTag:    0x00010000,
 		Guildcard:    guildcard,
@@ -163,23 +213,51 @@ func SendSecurity(client *Client, errorCode BBLoginError, guildcard uint32, team
 		Config:       &client.config,
 		Capabilities: 0x00000102,
 	}
-	DebugLog("Sending Security Packet")
+	client.log.Debug("Sending Security Packet")
 	return EncryptAndSend(client, pkt)
 }
 
-// SendRedirect sends the client the address of the next server to which they should connect.
+// SendRedirect sends the client the address of the next server to which they
+// should connect, which is an IPv6 redirect if ipAddr is an IPv6 address.
 func SendRedirect(client *Client, ipAddr []byte, port uint16) error {
-	pkt := new(RedirectPacket)
-	pkt.Header.Type = RedirectType
+	if len(ipAddr) == net.IPv6len {
+		pkt := new(packets.RedirectIPv6Packet)
+		pkt.Header.Type = packets.RedirectType
+		pkt.Header.Flags = packets.RedirectIPv6Flag
+		pkt.Port = port
+		copy(pkt.IPAddr[:], ipAddr)
+
+		client.log.Debug("Sending IPv6 Redirect Packet")
+		return EncryptAndSend(client, pkt)
+	}
+	pkt := new(packets.RedirectPacket)
+	pkt.Header.Type = packets.RedirectType
 	pkt.Port = port
 	copy(pkt.IPAddr[:], ipAddr)
 
-	DebugLog("Sending Redirect Packet")
+	client.log.Debug("Sending Redirect Packet")
 	return EncryptAndSend(client, pkt)
 }
 
+// Returns the address of the named server (as in the log_levels config) to
+// redirect client to: the external IPv6 address if they connected over IPv6 and
+// there is one, or else the IPv4 address for the server and the client's subnet.
+func redirectIP(client *Client, server string) []byte {
+	return chooseRedirectIP(client, config.RedirectIP(server, net.ParseIP(client.ipAddr)), config.BroadcastIPv6())
+}
+
+// Pick between the IPv4 and IPv6 addresses of a server according to how the
+// client connected. ipv6 is all zeroes if the server has no IPv6 address.
+func chooseRedirectIP(client *Client, ipv4 [4]byte, ipv6 [16]byte) []byte {
+	if client.ipv6 && ipv6 != [16]byte{} {
+		return ipv6[:]
+	}
+	return ipv4[:]
+}
+
 // EncryptAndSend will encode the packet and let Client encrypt and transmit it.
 func EncryptAndSend(client *Client, pkt interface{}) error {
-	data, size := util.BytesFromStruct(pkt)
-	return client.SendEncrypted(data, size)
+	data := util.AppendStruct(util.GetBytes(0), pkt)
+	defer util.PutBytes(data)
+	return client.SendEncrypted(data, len(data))
 }
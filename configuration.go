@@ -8,21 +8,55 @@ package main
 import (
 	"errors"
 	"io/ioutil"
+	"math"
+	"net"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"text/template"
 
+	"github.com/dcrodman/archon/data"
 	"github.com/dcrodman/archon/util"
 	"gopkg.in/yaml.v2"
 )
 
 // DatabaseConfig contains all parameters for db initialization.
 type DatabaseConfig struct {
+	// Name of the backend to use; one of data.Drivers().
+	DBDriver   string `yaml:"db_driver"`
 	DBHost     string `yaml:"db_host"`
 	DBPort     string `yaml:"db_port"`
 	DBName     string `yaml:"db_name"`
 	DBUsername string `yaml:"db_username"`
 	DBPassword string `yaml:"db_password"`
+	// Apply any pending schema migrations at startup.
+	DBAutoMigrate bool `yaml:"db_auto_migrate"`
+	// Seconds each query or transaction is given before it's cancelled, or 0
+	// for no limit.
+	DBQueryTimeout int `yaml:"db_query_timeout_seconds"`
+	// Number of queries in a row that can fail to reach the database before the
+	// rest are refused until it's back, or 0 to keep trying every query.
+	DBBreakerFailures int `yaml:"db_breaker_failures"`
+	// Seconds between checks on whether the database is back, and between
+	// attempts to connect at startup.
+	DBRetrySeconds int `yaml:"db_retry_seconds"`
+	// Number of times to retry connecting to the database at startup.
+	DBConnectRetries int `yaml:"db_connect_retries"`
+	// Read replica for the queries that can be slightly out of date, like
+	// character previews, or blank to make every query on the primary. The
+	// port, username, and password default to the primary's.
+	DBReplicaHost     string `yaml:"db_replica_host"`
+	DBReplicaPort     string `yaml:"db_replica_port"`
+	DBReplicaUsername string `yaml:"db_replica_username"`
+	DBReplicaPassword string `yaml:"db_replica_password"`
+	// Where to cache character previews, key configs, and guildcard lists; one
+	// of off, memory, or redis (the server from shipgate_server).
+	DBCache string `yaml:"db_cache"`
+	// Most entries kept by the memory cache.
+	DBCacheSize int `yaml:"db_cache_size"`
+	// Seconds that an entry is kept before it's read from the database again.
+	DBCacheTTLSeconds int `yaml:"db_cache_ttl_seconds"`
 }
 
 // PatchConfig contains all parameters for the patch server.
@@ -39,8 +73,30 @@ type LoginConfig struct {
 	LoginPort     string `yaml:"login_port"`
 	CharacterPort string `yaml:"character_port"`
 	ParametersDir string `yaml:"parameters_dir"`
-	// Scrolling message on ship select.
+	// Scrolling message on ship select. This is a template; see scroll.go for
+	// the variables that can be used.
 	ScrollMessage string `yaml:"scroll_message"`
+	// Number of days to keep deleted characters around so that they can be restored.
+	DeletedCharacterDays int `yaml:"deleted_character_days"`
+	// Scheme used to hash new passwords; one of bcrypt or argon2id.
+	PasswordHash string `yaml:"password_hash"`
+	// Whether character names have to be unique; one of off, server, or account.
+	UniqueCharacterNames string `yaml:"unique_character_names"`
+	// Client builds that are allowed past the character server. Any build is
+	// allowed if this is empty.
+	ClientBuilds []ClientBuild `yaml:"client_builds"`
+	// What to do about clients that aren't one of the builds: log, flag, or kick.
+	ClientBuildAction string `yaml:"client_build_action"`
+}
+
+// ClientBuild identifies a Blue Burst client by the version in its login packet
+// and the checksum of its executable that it sends to the character server.
+type ClientBuild struct {
+	// Shown in the log; e.g. the name of the patch.
+	Name string `yaml:"name"`
+	// 0 matches any version.
+	Version  uint16 `yaml:"version"`
+	Checksum uint32 `yaml:"checksum"`
 }
 
 // ShipConfig contains all parameters for the ship server.
@@ -49,6 +105,49 @@ type ShipConfig struct {
 	ShipName string `yaml:"ship_name"`
 	// Number of blocks to open on the ship server.
 	NumBlocks int `yaml:"num_blocks"`
+	// Most players that can be on the ship at once, or 0 for as many as its
+	// lobbies hold.
+	ShipMaxPlayers int `yaml:"max_players"`
+	// Lowest privilege tier that can join the ship; one of player, tester, gm,
+	// admin, or root.
+	ShipMinPrivilege string `yaml:"min_privilege"`
+	// Parsed from ShipMinPrivilege.
+	shipMinPrivilege byte
+	// Share one bank between all of an account's characters.
+	SharedBank bool `yaml:"shared_bank"`
+	// Give each account a common bank that its characters can switch to in
+	// addition to their own banks.
+	CommonBank bool `yaml:"common_bank"`
+	// Allow Episode 4 games, for rulesets that only have Episodes 1 and 2 when off.
+	Episode4 bool `yaml:"episode4"`
+	// Scrolling message shown by this ship in place of the login server's.
+	ShipScrollMessage string `yaml:"scroll_message"`
+	// Directory containing a subdirectory of quests for each quest menu category.
+	QuestDir string `yaml:"quest_dir"`
+	// File listing the items sold in the shops and their prices.
+	ShopFile string `yaml:"shop_file"`
+	// File listing the experience given for killing each kind of enemy.
+	ExperienceFile string `yaml:"experience_file"`
+	// Directory containing the client's enemy layout files for the maps.
+	MapDir string `yaml:"map_dir"`
+	// File listing the challenge mode ranks and their prizes.
+	ChallengeFile string `yaml:"challenge_file"`
+	// File listing the rulesets that can be picked for battle mode games.
+	BattleFile string `yaml:"battle_file"`
+	// File listing the rules that chat messages are filtered with.
+	ChatFilterFile string `yaml:"chat_filter_file"`
+	// File listing the anti-cheat rules that gameplay commands are checked with.
+	AnticheatFile string `yaml:"anticheat_file"`
+	// Seconds between the saves of a player's character while they're playing.
+	SaveSeconds int `yaml:"save_seconds"`
+	// File in which character saves are journaled until they're written to the database.
+	SaveJournal string `yaml:"save_journal"`
+	// Number of snapshots of each character kept for rolling it back, taken each
+	// time its player logs off. 0 turns snapshots off.
+	SnapshotsPerCharacter int `yaml:"snapshots_per_character"`
+	// Days after which snapshots are removed even if they haven't been replaced
+	// by newer ones, or 0 to keep them until they are.
+	SnapshotDays int `yaml:"snapshot_days"`
 }
 
 // BlockConfig contains all parameters for the block server(s).
@@ -56,11 +155,37 @@ type BlockConfig struct {
 	BlockPort string `yaml:"block_port"`
 	// Number of lobbies available per block.
 	NumLobbies int `yaml:"num_lobbies"`
+	// Lobbies (numbered from 1) in which symbol chats and word selects aren't
+	// passed along to the other players.
+	QuietLobbies []int `yaml:"quiet_lobbies"`
 }
 
 // ShipgateConfig contains all parameters for the shipgate.
 type ShipgateConfig struct {
 	ShipgatePort string `yaml:"shipgate_port"`
+	// Address (host:port) of a remote shipgate with which to register our ship.
+	ShipgateAddress string `yaml:"shipgate_address"`
+	// Shared secret that ships must present in order to register.
+	ShipgateKey string `yaml:"shipgate_key"`
+	// TLS certificate and private key used to accept ships. Ships registering
+	// with a remote shipgate only need the certificate.
+	CertificateFile string `yaml:"certificate_file"`
+	KeyFile         string `yaml:"key_file"`
+	// Where to keep track of the players online on all of the ships; one of
+	// memory or redis.
+	PresenceBackend string `yaml:"presence_backend"`
+	// Redis server shared by the ships when PresenceBackend is redis.
+	RedisAddress  string `yaml:"redis_address"`
+	RedisPassword string `yaml:"redis_password"`
+	RedisDB       int    `yaml:"redis_db"`
+}
+
+// LegacyLoginConfig is a port on which to accept clients running one of the
+// older versions of PSO.
+type LegacyLoginConfig struct {
+	// Version of the clients that connect to the port; either pc or gc.
+	Version string `yaml:"version"`
+	Port    string `yaml:"port"`
 }
 
 // WebConfig contains all parameters for the external HTTP server,
@@ -68,6 +193,210 @@ type ShipgateConfig struct {
 // callers. This can be disabled.
 type WebConfig struct {
 	WebPort string `yaml:"http_port"`
+	// Serve the account API (registration, password resets, guildcard lookups).
+	WebEnabled bool `yaml:"enabled"`
+	// Serve over HTTPS with this certificate and private key if they're set.
+	WebCertificateFile string `yaml:"certificate_file"`
+	WebKeyFile         string `yaml:"key_file"`
+	// Link included in password reset emails, to which the token is appended.
+	PasswordResetURL string `yaml:"password_reset_url"`
+	// SMTP server (host:port) and credentials used to send password reset emails.
+	SMTPAddress  string `yaml:"smtp_address"`
+	SMTPUsername string `yaml:"smtp_username"`
+	SMTPPassword string `yaml:"smtp_password"`
+	MailFrom     string `yaml:"mail_from"`
+}
+
+// AdminConfig contains the parameters for the admin API, which is used to
+// control the running server. This is disabled by default.
+type AdminConfig struct {
+	AdminEnabled bool   `yaml:"enabled"`
+	AdminPort    string `yaml:"http_port"`
+	// Requests must send this in an "Authorization: Bearer" header.
+	AdminToken string `yaml:"token"`
+	// Serve over HTTPS with this certificate and private key if they're set.
+	AdminCertificateFile string `yaml:"certificate_file"`
+	AdminKeyFile         string `yaml:"key_file"`
+}
+
+// DashboardConfig contains the parameters for the operator dashboard, which is
+// run as its own process with "archon dashboard" and controls the server
+// through its admin API.
+type DashboardConfig struct {
+	DashboardPort string `yaml:"http_port"`
+	// Base URL of the admin API, which is called with admin.token.
+	DashboardAdminURL string `yaml:"admin_url"`
+	// Serve over HTTPS with this certificate and private key if they're set.
+	DashboardCertificateFile string `yaml:"certificate_file"`
+	DashboardKeyFile         string `yaml:"key_file"`
+}
+
+// RateLimitConfig contains the limits on new connections to the login and
+// character servers. Setting a limit to 0 disables it.
+type RateLimitConfig struct {
+	// Connections allowed per minute from one IP address before more are refused.
+	IPConnections int `yaml:"ip_connections_per_minute"`
+	// Connections allowed per minute from one /24 (or IPv6 /64) subnet.
+	SubnetConnections int `yaml:"subnet_connections_per_minute"`
+	// Addresses that have this many connections refused in a minute are blocked.
+	BlockThreshold int `yaml:"block_threshold"`
+	BlockMinutes   int `yaml:"block_minutes"`
+	// IP addresses or CIDR ranges that are never limited.
+	Whitelist []string `yaml:"whitelist"`
+}
+
+// ProxyProtocolConfig controls reading the PROXY protocol headers that TCP load
+// balancers send ahead of a client's data (see proxyproto.go).
+type ProxyProtocolConfig struct {
+	ProxyProtocolEnabled bool `yaml:"enabled"`
+	// IP addresses or CIDR ranges of the proxies. Connections from anywhere
+	// else are taken as they are, so that clients can't claim any address.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+}
+
+// NATOverride gives clients connecting from a subnet a different address for
+// the servers than ExternalIP, such as the server's LAN address for players on
+// the same network as it.
+type NATOverride struct {
+	// CIDR range, e.g. 192.168.1.0/24.
+	Subnet     string `yaml:"subnet"`
+	ExternalIP string `yaml:"external_ip"`
+}
+
+// A NATOverride after parsing.
+type natOverride struct {
+	network *net.IPNet
+	ip      [4]byte
+}
+
+// SessionLimitConfig limits how many clients can be logged in at once. Setting a
+// limit to 0 disables it.
+type SessionLimitConfig struct {
+	// Clients logged in to the same account at once.
+	SessionsPerAccount int `yaml:"per_account"`
+	// Disconnect the account's oldest session to make room for a new login
+	// instead of turning the new login away.
+	SessionTakeover bool `yaml:"takeover"`
+	// Clients logged in from the same IP address at once.
+	SessionsPerIP int `yaml:"per_ip"`
+}
+
+// LoginAuditConfig controls how long login attempts are kept in the login audit
+// log and when alerts are raised about them (see loginaudit.go). Setting a
+// threshold to 0 disables its alerts.
+type LoginAuditConfig struct {
+	// Number of days to keep login attempts for, or 0 to keep them forever.
+	LoginAuditDays int `yaml:"days"`
+	// Failed logins are counted over this many minutes.
+	LoginAlertWindowMinutes int `yaml:"alert_window_minutes"`
+	// Failed logins from one IP address within the window that raise an alert.
+	LoginAlertIPFailures int `yaml:"alert_ip_failures"`
+	// Failed logins to one account within the window that raise an alert.
+	LoginAlertAccountFailures int `yaml:"alert_account_failures"`
+	// Minutes to wait before alerting about the same address or account again.
+	LoginAlertCooldownMinutes int `yaml:"alert_cooldown_minutes"`
+	// URL to which alerts are POSTed as JSON.
+	LoginAlertWebhookURL string `yaml:"alert_webhook_url"`
+	// Addresses to which alerts are emailed through web.smtp_address.
+	LoginAlertEmails []string `yaml:"alert_emails"`
+}
+
+// CaptureConfig controls which clients have their packets written to capture
+// files for debugging.
+type CaptureConfig struct {
+	// Directory in which to write the capture files, one per connection.
+	CaptureDir string `yaml:"dir"`
+	// Capture every client, or only those with these guildcards or IP addresses.
+	CaptureAll        bool     `yaml:"all"`
+	CaptureGuildcards []uint32 `yaml:"guildcards"`
+	CaptureIPs        []string `yaml:"ips"`
+}
+
+// DropConfig controls the items dropped by enemies and boxes, which are chosen by
+// the server from the drop tables.
+type DropConfig struct {
+	// Directory containing a drop table for each episode and difficulty.
+	DropDir string `yaml:"dir"`
+	// Multiplies the chance that an enemy or box drops anything.
+	DropRate float64 `yaml:"drop_rate"`
+	// Multiplies the chance of each rare drop, e.g. 2 for a double rare event.
+	RareRate float64 `yaml:"rare_rate"`
+}
+
+// RateConfig multiplies the experience and rare drop rates, on top of the drop
+// rates, for every game and for games of particular episodes and difficulties
+// (see rates.go). Can also be changed through the admin API.
+type RateConfig struct {
+	// Multiplies the experience for each enemy.
+	ExpRate       float64        `yaml:"exp_rate"`
+	RateOverrides []RateOverride `yaml:"overrides"`
+}
+
+// RateOverride multiplies the rates for games of an episode and difficulty.
+type RateOverride struct {
+	// ep1, ep2, or ep4, or empty for every episode.
+	Episode string `yaml:"episode" json:"episode"`
+	// normal, hard, very_hard, or ultimate, or empty for every difficulty.
+	Difficulty string `yaml:"difficulty" json:"difficulty"`
+	// 0 leaves the rate as it is.
+	ExpRate  float64 `yaml:"exp_rate" json:"exp_rate"`
+	RareRate float64 `yaml:"rare_rate" json:"rare_rate"`
+}
+
+// EventConfig is an event that runs on a schedule (see events.go).
+type EventConfig struct {
+	Name string `yaml:"name"`
+	// When the event starts, as a cron expression: minute, hour, day of the
+	// month, month, and day of the week.
+	Schedule string `yaml:"schedule"`
+	// How long the event runs each time it starts, e.g. 72h.
+	Duration string `yaml:"duration"`
+	// Lobby decorations to put up while the event runs, or 0 for none.
+	LobbyEvent uint16 `yaml:"lobby_event"`
+	// Multiply the drop rates and the experience for each enemy. 0 leaves
+	// them as they are.
+	DropRate float64 `yaml:"drop_rate"`
+	RareRate float64 `yaml:"rare_rate"`
+	ExpRate  float64 `yaml:"exp_rate"`
+	// Shown instead of the login and ship scroll messages while the event runs.
+	ScrollMessage string `yaml:"scroll_message"`
+}
+
+// TekkerConfig controls the appraisal of unidentified weapons at the tekker.
+type TekkerConfig struct {
+	// Meseta charged for each appraisal.
+	TekkerCost uint32 `yaml:"cost"`
+	// Shifts the results towards better (up to 1) or worse (down to -1) weapons.
+	TekkerBias float64 `yaml:"bias"`
+}
+
+// MaintenanceConfig controls maintenance mode, during which only accounts with
+// at least a certain privilege tier can log in.
+type MaintenanceConfig struct {
+	MaintenanceEnabled bool `yaml:"enabled"`
+	// Lowest privilege tier that can still log in; one of tester, gm, admin, or root.
+	MaintenanceMinPrivilege string `yaml:"min_privilege"`
+	// Shown to the players who are turned away. Blue Burst clients show their own
+	// maintenance message if this is empty.
+	MaintenanceMessage string `yaml:"message"`
+	// Parsed from MaintenanceMinPrivilege.
+	minPrivilege byte
+}
+
+// ParameterConfig controls the parameter files that the character server sends
+// to Blue Burst clients (see parameters.go).
+type ParameterConfig struct {
+	// Files in parameters_dir sent to each client, in this order.
+	ParameterFiles []string `yaml:"files"`
+	// Lists that replace the files for clients that report a version number in
+	// their login packet.
+	ParameterVersions map[uint16][]string `yaml:"versions"`
+	// CRC32 checksums the files need to have, keyed by name. Files that aren't
+	// listed aren't checked.
+	ParameterChecksums map[string]uint32 `yaml:"checksums"`
+	// Check the files this often and reload them when they change. 0 disables
+	// this, leaving them to be reloaded with "archon reload".
+	ParameterPollSeconds int `yaml:"poll_seconds"`
 }
 
 // Configuration structure that can be shared between sub servers.
@@ -77,9 +406,43 @@ type Config struct {
 	Hostname       string `yaml:"hostname"`
 	ExternalIP     string `yaml:"external_ip"`
 	MaxConnections int    `yaml:"max_connections"`
-	Logfile        string `yaml:"log_file"`
-	LogLevel       string `yaml:"log_level"`
-	DebugMode      bool   `yaml:"debug_mode"`
+	// Sent instead of ExternalIP to clients that connected over IPv6. Optional.
+	ExternalIPv6 string `yaml:"external_ipv6"`
+	// Addresses given out for individual servers instead of ExternalIP, keyed
+	// by the same names as LogLevels (e.g. character, ship, block1).
+	ExternalIPs map[string]string `yaml:"external_ips"`
+	// Checked in order before ExternalIPs and ExternalIP for IPv4 clients.
+	NATOverrides []NATOverride `yaml:"nat_overrides"`
+	// Seconds that the server has to handle each packet before the queries it's
+	// waiting on are cancelled, or 0 for no limit.
+	PacketTimeoutSeconds int `yaml:"packet_timeout_seconds"`
+	// Clients that don't send anything for this many minutes are disconnected.
+	ClientIdleMinutes int    `yaml:"client_idle_minutes"`
+	Logfile           string `yaml:"log_file"`
+	LogLevel          string `yaml:"log_level"`
+	// Log levels for individual sub servers (e.g. login, block1), overriding LogLevel.
+	LogLevels map[string]string `yaml:"log_levels"`
+	// Write log lines as text or as JSON.
+	LogFormat string `yaml:"log_format"`
+	// Rotate the log file once it's this many megabytes, keeping this many old
+	// files. A size of 0 disables rotation.
+	LogMaxSize    int  `yaml:"log_max_size_mb"`
+	LogMaxBackups int  `yaml:"log_max_backups"`
+	DebugMode     bool `yaml:"debug_mode"`
+	// File to which the server's process id is written so that "archon drain" can find it.
+	PidFile string `yaml:"pid_file"`
+	// Port on which to serve /healthz and /readyz. Leave empty to disable them.
+	HealthPort string `yaml:"health_port"`
+	// Name of the event currently running, if any, for use in the scroll message.
+	EventName string `yaml:"event_name"`
+	// Lobby decorations to put up when no scheduled event has its own.
+	LobbyEvent uint16 `yaml:"lobby_event"`
+	// Events that start and end on their own.
+	Events []EventConfig `yaml:"events"`
+	// File listing the words and names that characters and teams can't be named.
+	NameFilterFile string `yaml:"name_filter_file"`
+	// File with the pages of the information menu and the message of the day.
+	InfoFile string `yaml:"info_file"`
 
 	DatabaseConfig `yaml:"database"`
 	PatchConfig    `yaml:"patch_server"`
@@ -88,53 +451,185 @@ type Config struct {
 	BlockConfig    `yaml:"block_server"`
 	ShipgateConfig `yaml:"shipgate_server"`
 	WebConfig      `yaml:"web"`
+	AdminConfig    `yaml:"admin"`
+
+	// Used by the dashboard process rather than the server itself.
+	DashboardConfig `yaml:"dashboard"`
+
+	// Login servers for PC and Gamecube clients. There are none by default.
+	LegacyLogins []LegacyLoginConfig `yaml:"legacy_login_servers"`
+
+	RateLimitConfig    `yaml:"rate_limit"`
+	SessionLimitConfig `yaml:"session_limits"`
+	LoginAuditConfig   `yaml:"login_audit"`
+	CaptureConfig      `yaml:"capture"`
+	DropConfig         `yaml:"drops"`
+	RateConfig         `yaml:"rates"`
+	TekkerConfig       `yaml:"tekker"`
+
+	// For running behind a load balancer.
+	ProxyProtocolConfig `yaml:"proxy_protocol"`
+
+	// Can also be turned on and off through the admin API.
+	MaintenanceConfig `yaml:"maintenance"`
 
-	cachedIPBytes   [4]byte
-	MessageBytes    []byte
-	MessageSize     uint16
-	cachedScrollMsg []byte
+	// Belongs with the login server settings, but is its own section so that the
+	// lists of files are easier to read.
+	ParameterConfig `yaml:"parameters"`
+
+	// Path of the file the config was loaded from, so that it can be reloaded.
+	filename string
+	// Guards the settings that can be changed by Reload. Use the accessors
+	// below rather than reading those fields directly.
+	lock               sync.RWMutex
+	cachedIPBytes      [4]byte
+	cachedIPv6Bytes    [16]byte
+	serverIPs          map[string][4]byte
+	natOverrides       []natOverride
+	trustedProxies     []*net.IPNet
+	scheduledEvents    []*scheduledEvent
+	rateOverrides      []rateOverride
+	cachedWelcomeMsg   []byte
+	scrollTemplate     *template.Template
+	shipScrollTemplate *template.Template
 }
 
 // Singleton instance. Provides reasonable default values so
 // that some configurations can remain simpler.
-var config *Config = &Config{
-	Hostname:       "127.0.0.1",
-	ExternalIP:     "127.0.0.1",
-	Logfile:        "",
-	LogLevel:       "warn",
-	DebugMode:      false,
-	MaxConnections: 30000,
-	DatabaseConfig: DatabaseConfig{
-		DBHost: "127.0.0.1",
-		DBPort: "3306",
-		DBName: "archondb",
-	},
-	PatchConfig: PatchConfig{
-		PatchPort:      "11000",
-		DataPort:       "11001",
-		PatchDir:       "patches/",
-		WelcomeMessage: "Unconfigured Welcome Message",
-	},
-	LoginConfig: LoginConfig{
-		LoginPort:     "12000",
-		CharacterPort: "12001",
-		ParametersDir: "parameters/",
-		ScrollMessage: "Add a welcome message here",
-	},
-	ShipConfig: ShipConfig{
-		ShipPort:  "15000",
-		ShipName:  "Unconfigured",
-		NumBlocks: 2,
-	},
-	BlockConfig: BlockConfig{
-		NumLobbies: 15,
-	},
-	ShipgateConfig: ShipgateConfig{
-		ShipgatePort: "13000",
-	},
-	WebConfig: WebConfig{
-		WebPort: "14000",
-	},
+var config = newDefaultConfig()
+
+func newDefaultConfig() *Config {
+	return &Config{
+		Hostname:             "127.0.0.1",
+		ExternalIP:           "127.0.0.1",
+		Logfile:              "",
+		LogLevel:             "warn",
+		LogFormat:            LogFormatText,
+		LogMaxBackups:        5,
+		DebugMode:            false,
+		MaxConnections:       30000,
+		ClientIdleMinutes:    60,
+		PacketTimeoutSeconds: 30,
+		NameFilterFile:       "name_filter.json",
+		InfoFile:             "info.json",
+		DatabaseConfig: DatabaseConfig{
+			DBDriver: "mysql",
+			DBHost:   "127.0.0.1",
+			DBPort:   "3306",
+			DBName:   "archondb",
+
+			DBAutoMigrate:     true,
+			DBQueryTimeout:    10,
+			DBBreakerFailures: 5,
+			DBRetrySeconds:    5,
+			DBConnectRetries:  3,
+			DBCache:           CacheBackendOff,
+			DBCacheSize:       10000,
+			DBCacheTTLSeconds: 600,
+		},
+		PatchConfig: PatchConfig{
+			PatchPort:      "11000",
+			DataPort:       "11001",
+			PatchDir:       "patches/",
+			WelcomeMessage: "Unconfigured Welcome Message",
+		},
+		LoginConfig: LoginConfig{
+			LoginPort:     "12000",
+			CharacterPort: "12001",
+			ParametersDir: "parameters/",
+			ScrollMessage: "Add a welcome message here",
+
+			DeletedCharacterDays: 30,
+			PasswordHash:         PasswordHashBcrypt,
+			UniqueCharacterNames: UniqueNamesOff,
+			ClientBuildAction:    cheatActionFlag,
+		},
+		ParameterConfig: ParameterConfig{
+			ParameterFiles: []string{
+				"ItemMagEdit.prs",
+				"ItemPMT.prs",
+				"BattleParamEntry.dat",
+				"BattleParamEntry_on.dat",
+				"BattleParamEntry_lab.dat",
+				"BattleParamEntry_lab_on.dat",
+				"BattleParamEntry_ep4.dat",
+				"BattleParamEntry_ep4_on.dat",
+				"PlyLevelTbl.prs",
+			},
+		},
+		ShipConfig: ShipConfig{
+			ShipPort:       "15000",
+			ShipName:       "Unconfigured",
+			NumBlocks:      2,
+			Episode4:       true,
+			QuestDir:       "quests",
+			ShopFile:       "shops.json",
+			ExperienceFile: "experience.json",
+			MapDir:         "maps",
+			ChallengeFile:  "challenge.json",
+			BattleFile:     "battle.json",
+			ChatFilterFile: "chat_filter.json",
+			AnticheatFile:  "anticheat.json",
+			SaveSeconds:    30,
+			SaveJournal:    "saves.journal",
+
+			SnapshotsPerCharacter: 10,
+			SnapshotDays:          30,
+
+			ShipMinPrivilege: "player",
+		},
+		BlockConfig: BlockConfig{
+			NumLobbies: 15,
+		},
+		ShipgateConfig: ShipgateConfig{
+			ShipgatePort:    "13000",
+			PresenceBackend: PresenceBackendMemory,
+		},
+		WebConfig: WebConfig{
+			WebPort: "14000",
+		},
+		AdminConfig: AdminConfig{
+			AdminPort: "14001",
+		},
+		DashboardConfig: DashboardConfig{
+			DashboardPort:     "14002",
+			DashboardAdminURL: "http://127.0.0.1:14001",
+		},
+		RateLimitConfig: RateLimitConfig{
+			IPConnections:     20,
+			SubnetConnections: 60,
+			BlockThreshold:    20,
+			BlockMinutes:      15,
+		},
+		SessionLimitConfig: SessionLimitConfig{
+			SessionsPerAccount: 1,
+			SessionTakeover:    true,
+		},
+		LoginAuditConfig: LoginAuditConfig{
+			LoginAuditDays:            90,
+			LoginAlertWindowMinutes:   10,
+			LoginAlertIPFailures:      20,
+			LoginAlertAccountFailures: 10,
+			LoginAlertCooldownMinutes: 60,
+		},
+		CaptureConfig: CaptureConfig{
+			CaptureDir: "captures",
+		},
+		DropConfig: DropConfig{
+			DropDir:  "drops",
+			DropRate: 1,
+			RareRate: 1,
+		},
+		RateConfig: RateConfig{
+			ExpRate: 1,
+		},
+		TekkerConfig: TekkerConfig{
+			TekkerCost: 100,
+		},
+		MaintenanceConfig: MaintenanceConfig{
+			MaintenanceMinPrivilege: "tester",
+		},
+	}
 }
 
 // GetConfig returns the singleton instance of the config struct containing all of
@@ -146,26 +641,227 @@ func GetConfig() *Config {
 // Populate config with the contents of a JSON file at path fileName. Config parameters
 // in the file must match the above fields exactly in order to be read.
 func (config *Config) InitFromFile(fileName string) error {
-	data, err := ioutil.ReadFile(fileName)
+	contents, err := ioutil.ReadFile(fileName)
 	if err != nil {
 		return err
 	}
 
-	if err = yaml.Unmarshal(data, config); err != nil {
+	if err = yaml.Unmarshal(contents, config); err != nil {
 		return errors.New("Failed to parse config file: " + err.Error())
 	}
+	config.filename = fileName
 
 	// Convert the welcome message to UTF-16LE and cache it.
-	config.MessageBytes = util.ConvertToUtf16(config.WelcomeMessage)
+	config.cachedWelcomeMsg = util.ConvertToUtf16(config.WelcomeMessage)
 	// PSOBB expects this prefix to the message, not completely sure why. Language perhaps?
-	config.MessageBytes = append([]byte{0xFF, 0xFE}, config.MessageBytes...)
-	msgLen := len(config.MessageBytes)
-	if msgLen > (1<<16 - 16) {
+	config.cachedWelcomeMsg = append([]byte{0xFF, 0xFE}, config.cachedWelcomeMsg...)
+	if len(config.cachedWelcomeMsg) > (1<<16 - 16) {
 		return errors.New("Message length must be less than 65,000 characters")
 	}
-	config.MessageSize = uint16(msgLen)
 
-	config.cachedScrollMsg = util.ConvertToUtf16(config.ScrollMessage)
+	if config.scrollTemplate, err = parseScrollTemplate("scroll_message", config.ScrollMessage); err != nil {
+		return errors.New("Invalid scroll_message: " + err.Error())
+	}
+	config.shipScrollTemplate = nil
+	if config.ShipScrollMessage != "" {
+		config.shipScrollTemplate, err = parseScrollTemplate("ship_server.scroll_message", config.ShipScrollMessage)
+		if err != nil {
+			return errors.New("Invalid ship_server.scroll_message: " + err.Error())
+		}
+	}
+
+	if net.ParseIP(config.ExternalIP).To4() == nil {
+		return errors.New("external_ip must be an IPv4 address")
+	}
+	if ip := net.ParseIP(config.ExternalIPv6); config.ExternalIPv6 != "" && (ip == nil || ip.To4() != nil) {
+		return errors.New("external_ipv6 must be an IPv6 address")
+	}
+	config.serverIPs = make(map[string][4]byte, len(config.ExternalIPs))
+	for name, addr := range config.ExternalIPs {
+		ip := net.ParseIP(addr).To4()
+		if ip == nil {
+			return errors.New("external_ips address for " + name + " must be an IPv4 address")
+		}
+		var ipBytes [4]byte
+		copy(ipBytes[:], ip)
+		config.serverIPs[strings.ToLower(name)] = ipBytes
+	}
+	config.natOverrides = nil
+	for _, override := range config.NATOverrides {
+		_, network, err := net.ParseCIDR(override.Subnet)
+		if err != nil {
+			return errors.New("Invalid nat_overrides subnet: " + err.Error())
+		}
+		ip := net.ParseIP(override.ExternalIP).To4()
+		if ip == nil {
+			return errors.New("nat_overrides external_ip for " + override.Subnet + " must be an IPv4 address")
+		}
+		parsed := natOverride{network: network}
+		copy(parsed.ip[:], ip)
+		config.natOverrides = append(config.natOverrides, parsed)
+	}
+
+	if config.trustedProxies, err = parseNetworks(config.TrustedProxies); err != nil {
+		return errors.New("Invalid proxy_protocol.trusted_proxies: " + err.Error())
+	} else if config.ProxyProtocolEnabled && len(config.trustedProxies) == 0 {
+		return errors.New("proxy_protocol.trusted_proxies must be set when proxy_protocol is enabled")
+	}
+
+	if config.LogFormat != LogFormatText && config.LogFormat != LogFormatJSON {
+		return errors.New("log_format must be one of " + LogFormatText + " or " + LogFormatJSON)
+	}
+	levels := make(map[string]string, len(config.LogLevels))
+	for name, level := range config.LogLevels {
+		levels[strings.ToLower(name)] = level
+	}
+	config.LogLevels = levels
+
+	if config.PasswordHash != PasswordHashBcrypt && config.PasswordHash != PasswordHashArgon2id {
+		return errors.New("password_hash must be one of " + PasswordHashBcrypt + " or " + PasswordHashArgon2id)
+	}
+
+	switch config.UniqueCharacterNames {
+	case UniqueNamesOff, UniqueNamesServer, UniqueNamesAccount:
+	default:
+		return errors.New("unique_character_names must be one of " + UniqueNamesOff + ", " +
+			UniqueNamesServer + ", or " + UniqueNamesAccount)
+	}
+
+	switch config.ClientBuildAction {
+	case cheatActionLog, cheatActionFlag, cheatActionKick:
+	default:
+		return errors.New("client_build_action must be one of " + cheatActionLog + ", " +
+			cheatActionFlag + ", or " + cheatActionKick)
+	}
+
+	switch config.PresenceBackend {
+	case PresenceBackendMemory:
+	case PresenceBackendRedis:
+		if config.RedisAddress == "" {
+			return errors.New("shipgate_server.redis_address must be set to use the redis presence_backend")
+		}
+	default:
+		return errors.New("presence_backend must be one of " + PresenceBackendMemory + " or " + PresenceBackendRedis)
+	}
+
+	if len(config.ParameterFiles) == 0 {
+		return errors.New("parameters.files must list at least one file")
+	}
+	for version, files := range config.ParameterVersions {
+		if len(files) == 0 {
+			return errors.New("parameters.versions must list at least one file for version " +
+				strconv.Itoa(int(version)))
+		}
+	}
+	if config.PacketTimeoutSeconds < 0 {
+		return errors.New("packet_timeout_seconds must be 0 or more")
+	}
+	if config.DBQueryTimeout < 0 {
+		return errors.New("db_query_timeout_seconds must be 0 or more")
+	}
+	if config.DBBreakerFailures < 0 {
+		return errors.New("db_breaker_failures must be 0 or more")
+	}
+	if config.DBRetrySeconds <= 0 {
+		return errors.New("db_retry_seconds must be greater than 0")
+	}
+	if config.DBConnectRetries < 0 {
+		return errors.New("db_connect_retries must be 0 or more")
+	}
+	if config.DBReplicaHost != "" && config.DBDriver == "sqlite" {
+		return errors.New("db_replica_host can't be used with the sqlite driver")
+	}
+	switch config.DBCache {
+	case CacheBackendOff:
+	case CacheBackendMemory:
+		if config.DBCacheSize <= 0 {
+			return errors.New("db_cache_size must be greater than 0 to use the memory db_cache")
+		}
+	case CacheBackendRedis:
+		if config.RedisAddress == "" {
+			return errors.New("shipgate_server.redis_address must be set to use the redis db_cache")
+		}
+	default:
+		return errors.New("db_cache must be one of " + CacheBackendOff + ", " + CacheBackendMemory +
+			", or " + CacheBackendRedis)
+	}
+	if config.DBCache != CacheBackendOff && config.DBCacheTTLSeconds <= 0 {
+		return errors.New("db_cache_ttl_seconds must be greater than 0")
+	}
+	if config.ParameterPollSeconds < 0 {
+		return errors.New("parameters.poll_seconds must be 0 or more")
+	}
+
+	for _, legacy := range config.LegacyLogins {
+		if v, ok := parseClientVersion(legacy.Version); !ok || v == VersionBB {
+			return errors.New("legacy_login_servers version must be either pc or gc")
+		} else if legacy.Port == "" {
+			return errors.New("legacy_login_servers entries need a port")
+		}
+	}
+
+	var ok bool
+	config.minPrivilege, ok = parsePrivilege(config.MaintenanceMinPrivilege)
+	if !ok || config.minPrivilege == data.PrivilegePlayer {
+		return errors.New("maintenance.min_privilege must be one of tester, gm, admin, or root")
+	}
+
+	if config.ShipMaxPlayers < 0 || config.ShipMaxPlayers > math.MaxUint16 {
+		return errors.New("ship_server.max_players must be between 0 and 65535")
+	}
+	if config.shipMinPrivilege, ok = parsePrivilege(config.ShipMinPrivilege); !ok {
+		return errors.New("ship_server.min_privilege must be one of player, tester, gm, admin, or root")
+	}
+	if config.SharedBank && config.CommonBank {
+		return errors.New("ship_server.shared_bank and common_bank can't both be turned on")
+	}
+	if config.SaveSeconds <= 0 {
+		return errors.New("ship_server.save_seconds must be greater than 0")
+	}
+	if config.SnapshotsPerCharacter < 0 || config.SnapshotDays < 0 {
+		return errors.New("ship_server.snapshots_per_character and snapshot_days must be at least 0")
+	}
+	if config.SessionsPerAccount < 0 || config.SessionsPerIP < 0 {
+		return errors.New("session_limits.per_account and session_limits.per_ip can't be negative")
+	}
+	if config.LoginAuditDays < 0 || config.LoginAlertIPFailures < 0 ||
+		config.LoginAlertAccountFailures < 0 || config.LoginAlertCooldownMinutes < 0 {
+		return errors.New("login_audit.days, alert_ip_failures, alert_account_failures, and " +
+			"alert_cooldown_minutes can't be negative")
+	}
+	if config.LoginAlertWindowMinutes <= 0 {
+		return errors.New("login_audit.alert_window_minutes must be greater than 0")
+	}
+	if len(config.LoginAlertEmails) > 0 && config.SMTPAddress == "" {
+		return errors.New("login_audit.alert_emails requires web.smtp_address")
+	}
+
+	config.scheduledEvents = nil
+	for _, event := range config.Events {
+		scheduled, err := newScheduledEvent(event)
+		if err != nil {
+			return errors.New("Invalid event " + event.Name + ": " + err.Error())
+		} else if containsEvent(config.scheduledEvents, event.Name) {
+			return errors.New("Duplicate event " + event.Name)
+		}
+		config.scheduledEvents = append(config.scheduledEvents, scheduled)
+	}
+
+	if config.DropRate < 0 || config.RareRate < 0 {
+		return errors.New("drops.drop_rate and drops.rare_rate can't be negative")
+	} else if config.ExpRate < 0 {
+		return errors.New("rates.exp_rate can't be negative")
+	}
+	if config.rateOverrides, err = parseRateOverrides(config.RateOverrides); err != nil {
+		return errors.New("Invalid rates.overrides: " + err.Error())
+	}
+	if config.TekkerBias < -1 || config.TekkerBias > 1 {
+		return errors.New("tekker.bias must be between -1 and 1")
+	}
+
+	if config.AdminEnabled && config.AdminToken == "" {
+		return errors.New("admin.token must be set when the admin API is enabled")
+	}
 
 	// Strip the trailing slash if needed.
 	if strings.HasSuffix(config.PatchDir, "/") {
@@ -179,18 +875,228 @@ func (config *Config) BroadcastIP() [4]byte {
 	// Hacky, but chances are the IP address isn't going to start with 0 and a
 	// fixed-length array can't be null.
 	if config.cachedIPBytes[0] == 0x00 {
-		parts := strings.Split(config.ExternalIP, ".")
-		for i := 0; i < 4; i++ {
-			tmp, _ := strconv.ParseUint(parts[i], 10, 8)
-			config.cachedIPBytes[i] = uint8(tmp)
-		}
+		copy(config.cachedIPBytes[:], net.ParseIP(config.ExternalIP).To4())
 	}
 	return config.cachedIPBytes
 }
 
-// Returns the configured scroll message for the login server.
-func (config *Config) ScrollMessageBytes() []byte {
-	return config.cachedScrollMsg[:]
+// Returns the IPv4 address to give out for the named server (as in LogLevels)
+// when there's no client to consider, such as when registering with a shipgate.
+func (config *Config) ServerIP(server string) [4]byte {
+	if ip, ok := config.serverIPs[strings.ToLower(server)]; ok {
+		return ip
+	}
+	return config.BroadcastIP()
+}
+
+// Returns the IPv4 address to give a client at clientIP for the named server:
+// that of the first NAT override covering clientIP, or else ServerIP.
+func (config *Config) RedirectIP(server string, clientIP net.IP) [4]byte {
+	for _, override := range config.natOverrides {
+		if override.network.Contains(clientIP) {
+			return override.ip
+		}
+	}
+	return config.ServerIP(server)
+}
+
+// Convert the external IPv6 address into the 16 bytes used with the IPv6
+// redirect packets. All zeroes if there isn't one.
+func (config *Config) BroadcastIPv6() [16]byte {
+	if config.cachedIPv6Bytes == [16]byte{} {
+		copy(config.cachedIPv6Bytes[:], net.ParseIP(config.ExternalIPv6).To16())
+	}
+	return config.cachedIPv6Bytes
+}
+
+// Reload re-reads the config file and applies the settings that can safely be
+// changed while the server is running: debug mode, the log level, the welcome
+// and scroll messages, the current event and lobby decorations, the rate limits, which clients have
+// their packets captured, the drop and experience rates, the tekker, maintenance mode, the
+// quiet lobbies, the scheduled events, and the parameter files. Everything else (ports, database, ship name, etc.) keeps its current value
+// until the server is restarted.
+func (config *Config) Reload() error {
+	fresh := newDefaultConfig()
+	if err := fresh.InitFromFile(config.filename); err != nil {
+		return err
+	}
+	config.lock.Lock()
+	defer config.lock.Unlock()
+	config.DebugMode = fresh.DebugMode
+	config.LogLevel = fresh.LogLevel
+	config.LogLevels = fresh.LogLevels
+	config.WelcomeMessage = fresh.WelcomeMessage
+	config.cachedWelcomeMsg = fresh.cachedWelcomeMsg
+	config.ScrollMessage = fresh.ScrollMessage
+	config.scrollTemplate = fresh.scrollTemplate
+	config.ShipScrollMessage = fresh.ShipScrollMessage
+	config.shipScrollTemplate = fresh.shipScrollTemplate
+	config.EventName = fresh.EventName
+	config.LobbyEvent = fresh.LobbyEvent
+	config.Events = fresh.Events
+	config.scheduledEvents = fresh.scheduledEvents
+	config.RateLimitConfig = fresh.RateLimitConfig
+	config.SessionLimitConfig = fresh.SessionLimitConfig
+	config.LoginAuditConfig = fresh.LoginAuditConfig
+	config.CaptureConfig = fresh.CaptureConfig
+	// The tables themselves are reloaded by the ship server.
+	config.DropRate = fresh.DropRate
+	config.RareRate = fresh.RareRate
+	config.RateConfig = fresh.RateConfig
+	config.rateOverrides = fresh.rateOverrides
+	config.TekkerConfig = fresh.TekkerConfig
+	config.MaintenanceConfig = fresh.MaintenanceConfig
+	config.QuietLobbies = fresh.QuietLobbies
+	// The files themselves are reloaded by the character server.
+	config.ParameterConfig = fresh.ParameterConfig
+	return nil
+}
+
+// Debugging returns whether debug mode is enabled.
+func (config *Config) Debugging() bool {
+	config.lock.RLock()
+	defer config.lock.RUnlock()
+	return config.DebugMode
+}
+
+// Returns the log levels set for individual sub servers, keyed by lowercase name.
+func (config *Config) SubsystemLogLevels() map[string]string {
+	config.lock.RLock()
+	defer config.lock.RUnlock()
+	return config.LogLevels
+}
+
+// Returns the configured welcome message for the patch server.
+func (config *Config) WelcomeMessageBytes() []byte {
+	config.lock.RLock()
+	defer config.lock.RUnlock()
+	return config.cachedWelcomeMsg
+}
+
+// Returns the scroll message template for the login server, or the ship's
+// override of it if ship is set and the ship has one.
+func (config *Config) ScrollTemplate(ship bool) *template.Template {
+	config.lock.RLock()
+	defer config.lock.RUnlock()
+	if ship && config.shipScrollTemplate != nil {
+		return config.shipScrollTemplate
+	}
+	return config.scrollTemplate
+}
+
+// Returns the name of the event currently running, if there is one.
+func (config *Config) CurrentEvent() string {
+	config.lock.RLock()
+	defer config.lock.RUnlock()
+	return config.EventName
+}
+
+// Returns the lobby decorations to use when no scheduled event has any.
+func (config *Config) DefaultLobbyEvent() uint16 {
+	config.lock.RLock()
+	defer config.lock.RUnlock()
+	return config.LobbyEvent
+}
+
+// Returns the events in the config, which run on their schedules.
+func (config *Config) ScheduledEvents() []*scheduledEvent {
+	config.lock.RLock()
+	defer config.lock.RUnlock()
+	return config.scheduledEvents
+}
+
+// Returns the current rate limits for new connections.
+func (config *Config) RateLimits() RateLimitConfig {
+	config.lock.RLock()
+	defer config.lock.RUnlock()
+	return config.RateLimitConfig
+}
+
+// Returns the current parameter file settings.
+func (config *Config) Parameters() ParameterConfig {
+	config.lock.RLock()
+	defer config.lock.RUnlock()
+	return config.ParameterConfig
+}
+
+// Returns the current limits on logged in sessions.
+func (config *Config) SessionLimits() SessionLimitConfig {
+	config.lock.RLock()
+	defer config.lock.RUnlock()
+	return config.SessionLimitConfig
+}
+
+// Returns the current login audit retention and alert settings.
+func (config *Config) LoginAudit() LoginAuditConfig {
+	config.lock.RLock()
+	defer config.lock.RUnlock()
+	return config.LoginAuditConfig
+}
+
+// Returns the current packet capture settings.
+func (config *Config) Captures() CaptureConfig {
+	config.lock.RLock()
+	defer config.lock.RUnlock()
+	return config.CaptureConfig
+}
+
+// Returns the current drop settings.
+func (config *Config) Drops() DropConfig {
+	config.lock.RLock()
+	defer config.lock.RUnlock()
+	return config.DropConfig
+}
+
+// Returns the current experience rate and the rate overrides.
+func (config *Config) Rates() (RateConfig, []rateOverride) {
+	config.lock.RLock()
+	defer config.lock.RUnlock()
+	return config.RateConfig, config.rateOverrides
+}
+
+// Returns the current tekker settings.
+func (config *Config) Tekker() TekkerConfig {
+	config.lock.RLock()
+	defer config.lock.RUnlock()
+	return config.TekkerConfig
+}
+
+// Returns the current maintenance mode settings.
+func (config *Config) Maintenance() MaintenanceConfig {
+	config.lock.RLock()
+	defer config.lock.RUnlock()
+	return config.MaintenanceConfig
+}
+
+// Returns whether symbol chats and word selects are turned off in a lobby (by its
+// index on the block).
+func (config *Config) QuietLobby(id uint8) bool {
+	config.lock.RLock()
+	defer config.lock.RUnlock()
+	for _, lobby := range config.QuietLobbies {
+		if lobby == int(id)+1 {
+			return true
+		}
+	}
+	return false
+}
+
+// Turn maintenance mode on or off until the config is next reloaded.
+func (config *Config) SetMaintenance(enabled bool) {
+	config.lock.Lock()
+	defer config.lock.Unlock()
+	config.MaintenanceEnabled = enabled
+}
+
+// Change the drop and experience rates until the config is reloaded. The
+// overrides have to have been checked with parseRateOverrides.
+func (config *Config) SetRates(drops DropConfig, rates RateConfig, overrides []rateOverride) {
+	config.lock.Lock()
+	defer config.lock.Unlock()
+	config.DropRate = drops.DropRate
+	config.RareRate = drops.RareRate
+	config.RateConfig = rates
+	config.rateOverrides = overrides
 }
 
 func (config *Config) String() string {
@@ -199,26 +1105,78 @@ func (config *Config) String() string {
 		outfile = "Standard Out"
 	}
 	return "Hostname: " + config.Hostname + "\n" +
+		"External IP: " + config.ExternalIP + "\n" +
+		"External IPv6: " + config.ExternalIPv6 + "\n" +
 		"Debug Mode Enabled: " + strconv.FormatBool(config.DebugMode) + "\n" +
 		"Patch Port: " + config.PatchPort + "\n" +
 		"Data Port: " + config.DataPort + "\n" +
 		"Login Port: " + config.LoginPort + "\n" +
 		"Character Port: " + config.CharacterPort + "\n" +
 		"Shipgate Port: " + config.ShipgatePort + "\n" +
+		"Shipgate Address: " + config.ShipgateAddress + "\n" +
+		"Presence Backend: " + config.PresenceBackend + "\n" +
 		"Web Port: " + config.WebPort + "\n" +
+		"Web API Enabled: " + strconv.FormatBool(config.WebEnabled) + "\n" +
+		"Admin Port: " + config.AdminPort + "\n" +
+		"Admin API Enabled: " + strconv.FormatBool(config.AdminEnabled) + "\n" +
+		"Health Port: " + config.HealthPort + "\n" +
 		"Ship Port: " + config.ShipPort + "\n" +
 		"Num Ship Blocks: " + strconv.FormatInt(int64(config.NumBlocks), 10) + "\n" +
+		"Ship Max Players: " + strconv.Itoa(config.ShipMaxPlayers) + "\n" +
+		"Ship Min Privilege: " + config.ShipMinPrivilege + "\n" +
+		"Shared Bank: " + strconv.FormatBool(config.SharedBank) + "\n" +
+		"Common Bank: " + strconv.FormatBool(config.CommonBank) + "\n" +
+		"Episode 4: " + strconv.FormatBool(config.Episode4) + "\n" +
 		"Num Lobbies: " + strconv.FormatInt(int64(config.NumLobbies), 10) + "\n" +
 		"Max Connections: " + strconv.FormatInt(int64(config.MaxConnections), 10) + "\n" +
+		"Client Idle Minutes: " + strconv.FormatInt(int64(config.ClientIdleMinutes), 10) + "\n" +
+		"Packet Timeout Seconds: " + strconv.Itoa(config.PacketTimeoutSeconds) + "\n" +
+		"Connections Per IP Per Minute: " + strconv.FormatInt(int64(config.IPConnections), 10) + "\n" +
+		"Connections Per Subnet Per Minute: " + strconv.FormatInt(int64(config.SubnetConnections), 10) + "\n" +
 		"Ship Name: " + config.ShipName + "\n" +
 		"Welcome Message: " + config.WelcomeMessage + "\n" +
+		"Event Name: " + config.EventName + "\n" +
 		"Parameters Directory: " + config.ParametersDir + "\n" +
+		"Parameter Files: " + strings.Join(config.ParameterFiles, ", ") + "\n" +
+		"Parameter Poll Seconds: " + strconv.FormatInt(int64(config.ParameterPollSeconds), 10) + "\n" +
+		"Deleted Character Days: " + strconv.FormatInt(int64(config.DeletedCharacterDays), 10) + "\n" +
+		"Password Hash: " + config.PasswordHash + "\n" +
+		"Unique Character Names: " + config.UniqueCharacterNames + "\n" +
+		"Client Builds: " + strconv.Itoa(len(config.ClientBuilds)) + "\n" +
+		"Login Audit Days: " + strconv.Itoa(config.LoginAuditDays) + "\n" +
 		"Patch Directory: " + config.PatchDir + "\n" +
+		"Quest Directory: " + config.QuestDir + "\n" +
+		"Shop File: " + config.ShopFile + "\n" +
+		"Experience File: " + config.ExperienceFile + "\n" +
+		"Map Directory: " + config.MapDir + "\n" +
+		"Challenge File: " + config.ChallengeFile + "\n" +
+		"Battle File: " + config.BattleFile + "\n" +
+		"Chat Filter File: " + config.ChatFilterFile + "\n" +
+		"Name Filter File: " + config.NameFilterFile + "\n" +
+		"Info File: " + config.InfoFile + "\n" +
+		"Anti-cheat File: " + config.AnticheatFile + "\n" +
+		"Save Seconds: " + strconv.FormatInt(int64(config.SaveSeconds), 10) + "\n" +
+		"Save Journal: " + config.SaveJournal + "\n" +
+		"Snapshots Per Character: " + strconv.FormatInt(int64(config.SnapshotsPerCharacter), 10) + "\n" +
+		"Snapshot Days: " + strconv.FormatInt(int64(config.SnapshotDays), 10) + "\n" +
+		"Drop Directory: " + config.DropDir + "\n" +
+		"Database Driver: " + config.DBDriver + "\n" +
 		"Database Host: " + config.DBHost + "\n" +
 		"Database Port: " + config.DBPort + "\n" +
 		"Database Name: " + config.DBName + "\n" +
+		"Database Auto Migrate: " + strconv.FormatBool(config.DBAutoMigrate) + "\n" +
+		"Database Query Timeout Seconds: " + strconv.Itoa(config.DBQueryTimeout) + "\n" +
+		"Database Breaker Failures: " + strconv.Itoa(config.DBBreakerFailures) + "\n" +
+		"Database Retry Seconds: " + strconv.Itoa(config.DBRetrySeconds) + "\n" +
+		"Database Connect Retries: " + strconv.Itoa(config.DBConnectRetries) + "\n" +
+		"Database Replica Host: " + config.DBReplicaHost + "\n" +
+		"Database Replica Port: " + config.DBReplicaPort + "\n" +
+		"Database Cache: " + config.DBCache + "\n" +
+		"Database Cache Size: " + strconv.Itoa(config.DBCacheSize) + "\n" +
+		"Database Cache TTL Seconds: " + strconv.Itoa(config.DBCacheTTLSeconds) + "\n" +
 		"Database Username: " + config.DBUsername + "\n" +
 		"Database Password: " + config.DBPassword + "\n" +
 		"Output Logged To: " + outfile + "\n" +
-		"Logging Level: " + config.LogLevel
+		"Logging Level: " + config.LogLevel + "\n" +
+		"Logging Format: " + config.LogFormat
 }
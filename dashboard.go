@@ -0,0 +1,96 @@
+/*
+* Web dashboard for operators, run as its own process with "archon dashboard".
+* It serves a single page showing the players who are online, recent logins,
+* the warnings and errors being logged, the registered ships, and graphs of the
+* daily economy stats (see economy.go), with buttons to kick, ban, and message
+* players. The page's requests are passed on to the server's admin API (see
+* admin.go) with the admin token, which never leaves the dashboard. Operators
+* sign in with the username and password of an account with GM privileges. GMs
+* can look at everything and use the kick, ban, and message buttons; any other
+* change, such as reloading the config, needs admin privileges.
+ */
+package main
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+
+	"github.com/dcrodman/archon/data"
+)
+
+//go:embed dashboard/index.html
+var dashboardPage []byte
+
+// Serve the dashboard until the process is killed.
+func runDashboard() error {
+	if config.AdminToken == "" {
+		return errors.New("admin.token must be set to use the dashboard")
+	}
+	adminURL, err := url.Parse(config.DashboardAdminURL)
+	if err != nil {
+		return errors.New("invalid dashboard.admin_url: " + err.Error())
+	}
+	if err = initializeLogger(); err != nil {
+		return err
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(adminURL)
+	director := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		director(req)
+		operator, _, _ := req.BasicAuth()
+		req.Header.Set("Authorization", "Bearer "+config.AdminToken)
+		req.Header.Set("X-Archon-Operator", operator)
+	}
+	proxy.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
+		log.Warn("Failed to reach the admin API: " + err.Error())
+		writeError(w, http.StatusBadGateway, "unable to reach the server")
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/admin/", proxy)
+	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/" {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(dashboardPage)
+	})
+
+	server := &http.Server{
+		Addr:         net.JoinHostPort(config.Hostname, config.DashboardPort),
+		Handler:      requirePrivilege("dashboard", dashboardPrivilege, mux),
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+	if config.DashboardCertificateFile != "" {
+		fmt.Println("Serving the dashboard over HTTPS on " + server.Addr)
+	} else {
+		fmt.Println("Serving the dashboard on " + server.Addr)
+	}
+	return serveHTTP(server, config.DashboardCertificateFile, config.DashboardKeyFile)
+}
+
+// Admin API requests other than GETs that GMs can make through the dashboard.
+var dashboardGMActions = map[string]bool{
+	"/admin/kick":      true,
+	"/admin/ban":       true,
+	"/admin/broadcast": true,
+}
+
+// Returns the privilege tier needed to make a request through the dashboard.
+// Operators need to be GMs to use it at all, and admins to change anything
+// other than the GM actions.
+func dashboardPrivilege(req *http.Request) byte {
+	if req.Method == http.MethodGet || dashboardGMActions[req.URL.Path] {
+		return data.PrivilegeGM
+	}
+	return data.PrivilegeAdmin
+}
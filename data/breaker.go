@@ -0,0 +1,160 @@
+package data
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrUnavailable is returned instead of running queries while the database
+// can't be reached (see Config.BreakerFailures), and by Open if it can't be
+// reached to begin with.
+var ErrUnavailable = errors.New("data: the database is unavailable")
+
+// breaker keeps the store from sending queries to a database that has stopped
+// answering. Once enough queries in a row have failed to reach it, the breaker
+// opens and queries fail straight away with ErrUnavailable rather than each
+// tying up a connection until it times out. While it's open the database is
+// pinged every probeInterval, and the breaker closes again as soon as it
+// answers. database/sql replaces the connections that broke in the meantime.
+// The read replica has a breaker of its own, and reads go to the primary while
+// it's open.
+type breaker struct {
+	threshold     int
+	probeInterval time.Duration
+	ping          func(ctx context.Context) error
+	changed       func(replica bool, err error)
+	// Whether the breaker is the read replica's.
+	replica bool
+
+	failures int
+	open     bool
+	closed   chan struct{}
+	sync.Mutex
+}
+
+func newBreaker(cfg Config, replica bool, ping func(ctx context.Context) error) *breaker {
+	b := &breaker{
+		threshold:     cfg.BreakerFailures,
+		probeInterval: cfg.ProbeInterval,
+		ping:          ping,
+		changed:       cfg.AvailabilityChanged,
+		replica:       replica,
+		closed:        make(chan struct{}),
+	}
+	if b.probeInterval <= 0 {
+		b.probeInterval = 5 * time.Second
+	}
+	return b
+}
+
+// Allow returns ErrUnavailable if queries shouldn't be sent to the database.
+func (b *breaker) Allow() error {
+	b.Lock()
+	defer b.Unlock()
+	if b.open {
+		return ErrUnavailable
+	}
+	return nil
+}
+
+// Record the outcome of a query. Errors that came from the database itself
+// (rather than from failing to reach it) count as it being available.
+func (b *breaker) Record(err error, connectionErr bool) {
+	b.Lock()
+	defer b.Unlock()
+	if !connectionErr {
+		b.failures = 0
+		if b.open {
+			b.setOpen(false, nil)
+		}
+		return
+	}
+	b.failures++
+	if !b.open && b.threshold > 0 && b.failures >= b.threshold {
+		b.setOpen(true, err)
+		go b.probe()
+	}
+}
+
+// Trip opens the breaker because of err, whatever the threshold.
+func (b *breaker) Trip(err error) {
+	b.Lock()
+	defer b.Unlock()
+	if !b.open {
+		b.setOpen(true, err)
+		go b.probe()
+	}
+}
+
+// Must be called with the lock held.
+func (b *breaker) setOpen(open bool, err error) {
+	b.open = open
+	if b.changed != nil {
+		go b.changed(b.replica, err)
+	}
+}
+
+// Ping the database until it answers or the store is closed.
+func (b *breaker) probe() {
+	ticker := time.NewTicker(b.probeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.closed:
+			return
+		case <-ticker.C:
+		}
+		b.Lock()
+		open := b.open
+		b.Unlock()
+		if !open {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), b.probeInterval)
+		err := b.ping(ctx)
+		cancel()
+		if err == nil {
+			b.Record(nil, false)
+			return
+		}
+	}
+}
+
+func (b *breaker) Close() {
+	b.Lock()
+	defer b.Unlock()
+	select {
+	case <-b.closed:
+	default:
+		close(b.closed)
+	}
+}
+
+// Returns whether err means that the database couldn't be reached, as opposed
+// to it refusing the query. Queries that ran out of time count too, since a
+// database that has stopped answering looks the same from here as one that's
+// gone, but not ones that were cut short because parent was done.
+func isConnectionError(err error, parent context.Context, extra []error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return parent.Err() == nil
+	}
+	var netErr net.Error
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF) || errors.As(err, &netErr) {
+		return true
+	}
+	for _, e := range extra {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,512 @@
+/*
+* Storage abstraction used by the servers for all persistent data. The servers
+* only ever talk to the repository interfaces defined here; the concrete
+* backend is chosen at startup by name via Open().
+ */
+package data
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dcrodman/archon/data/migrations"
+)
+
+// LatestSchema can be passed to Store.Migrate to apply all pending migrations.
+const LatestSchema = migrations.Latest
+
+// Config contains the parameters needed by a driver to open a connection.
+type Config struct {
+	Driver   string
+	Host     string
+	Port     string
+	Name     string
+	Username string
+	Password string
+	// How long each query or transaction is given before it's cancelled, or 0
+	// for no limit.
+	QueryTimeout time.Duration
+	// Number of queries in a row that can fail to reach the database before the
+	// rest fail with ErrUnavailable until it can be reached again, or 0 to keep
+	// trying every query.
+	BreakerFailures int
+	// How often the database is pinged while it's unavailable.
+	ProbeInterval time.Duration
+	// Called when the database (or its replica) becomes unavailable, with the
+	// error that made it so, and with nil when it can be reached again.
+	AvailabilityChanged func(replica bool, err error)
+
+	// Read replica for the queries made through Store.Replica, if ReplicaHost
+	// is set. It has the same name as the primary, and the same credentials
+	// unless ReplicaUsername is set.
+	ReplicaHost     string
+	ReplicaPort     string
+	ReplicaUsername string
+	ReplicaPassword string
+}
+
+// AccountRepository provides access to registered user accounts.
+type AccountRepository interface {
+	// FindAccount returns the account for username, or nil if none exists.
+	FindAccount(username string) (*Account, error)
+	// FindAccountByEmail returns the account registered with email (ignoring
+	// case), or nil if none exists.
+	FindAccountByEmail(email string) (*Account, error)
+	// CreateAccount registers a new account and fills in the guildcard and
+	// registration date assigned to it. ErrAccountExists or ErrEmailInUse is
+	// returned if the username or email belongs to another account.
+	CreateAccount(account *Account) error
+	// ImportAccount creates an account brought over from another server like
+	// CreateAccount, recording it under the source and its guildcard there, and
+	// creates ban along with it if it isn't nil. If an earlier import already
+	// created it, only the account's Guildcard is filled in and false is returned.
+	ImportAccount(source string, sourceGuildcard uint32, account *Account, ban *Ban) (bool, error)
+	// UpdatePassword replaces the password hash saved for username.
+	UpdatePassword(username string, password string) error
+	// UpdatePrivilegeLevel sets the privilege tier of username, marking it as
+	// a GM if the tier is PrivilegeGM or above.
+	UpdatePrivilegeLevel(username string, level byte) error
+	// UpdateMute mutes username's chat until a time, or unmutes it if the time
+	// is zero.
+	UpdateMute(username string, until time.Time, shadow bool) error
+	// CreatePasswordReset saves the hash of a password reset token for username,
+	// replacing any token that was issued before.
+	CreatePasswordReset(username string, tokenHash string, expires time.Time) error
+	// UsePasswordReset deletes the token with tokenHash and returns the username
+	// it was issued for, or "" if there's no such token or it has expired.
+	UsePasswordReset(tokenHash string) (string, error)
+}
+
+// TwoFactorRepository provides access to the two-factor authentication settings
+// and recovery codes for each account.
+type TwoFactorRepository interface {
+	// FindTwoFactor returns the two-factor settings for username, or nil if it
+	// has never enrolled.
+	FindTwoFactor(username string) (*TwoFactor, error)
+	// SaveTwoFactor creates or overwrites the two-factor settings for an
+	// account and replaces its recovery codes with the given hashes.
+	SaveTwoFactor(twoFactor *TwoFactor, recoveryCodeHashes []string) error
+	// UpdateTwoFactor saves whether two-factor authentication is enabled for the
+	// account and the last step used, leaving the secret and recovery codes alone.
+	// ErrTwoFactorStepUsed is returned if the saved step isn't older than the
+	// new one, as when the same code is used twice at once.
+	UpdateTwoFactor(twoFactor *TwoFactor) error
+	// DeleteTwoFactor turns off two-factor authentication for username and
+	// removes its recovery codes.
+	DeleteTwoFactor(username string) error
+	// UseRecoveryCode deletes the recovery code with codeHash and returns
+	// whether username had one.
+	UseRecoveryCode(username string, codeHash string) (bool, error)
+}
+
+// OptionsRepository provides access to the per-account key and option config.
+type OptionsRepository interface {
+	// FindPlayerOptions returns the options for guildcard, or nil if none exist.
+	FindPlayerOptions(guildcard uint32) (*PlayerOptions, error)
+	// UpdatePlayerOptions overwrites the options or creates them if needed.
+	// ErrInvalidOptions is returned without saving anything if the options
+	// fail validation.
+	UpdatePlayerOptions(playerOptions *PlayerOptions) error
+}
+
+// CharacterRepository provides access to the characters in an account's slots.
+type CharacterRepository interface {
+	// CreateCharacter creates a character in the specified slot. Note that this
+	// method does not delete an existing character; use DeleteCharacter to do so.
+	// ErrCharacterNameTaken is returned if another character has its NameKey.
+	CreateCharacter(guildcard uint32, slotNum uint32, character *Character) error
+	// FindCharacter returns the character in slotNum or nil if the slot is empty.
+	FindCharacter(guildcard uint32, slotNum uint32) (*Character, error)
+	// UpdateCharacter overwrites the character data in slotNum.
+	UpdateCharacter(guildcard uint32, slotNum uint32, character *Character) error
+	// UpdateAppearance overwrites only the fields of the character in slotNum
+	// that can be changed in the dressing room (name, section ID, and looks),
+	// along with its NameKey. ErrCharacterNameTaken is returned if another
+	// character has the key.
+	UpdateAppearance(guildcard uint32, slotNum uint32, character *Character) error
+	// DeleteCharacter moves the character in slotNum, along with their items,
+	// bank, and techniques, out of the account's slots. The character can be
+	// restored with RestoreCharacter until it's purged.
+	DeleteCharacter(guildcard uint32, slotNum uint32) error
+	// FindDeletedCharacters returns the account's deleted characters that
+	// haven't been purged yet.
+	FindDeletedCharacters(guildcard uint32) ([]DeletedCharacter, error)
+	// RestoreCharacter moves the deleted character with the given id back into
+	// slotNum, which must be empty. ErrCharacterNameTaken is returned if another
+	// character has taken its name since it was deleted.
+	RestoreCharacter(guildcard uint32, id uint32, slotNum uint32) error
+	// PurgeDeletedCharacters permanently removes all characters deleted before
+	// the cutoff and returns the number that were removed.
+	PurgeDeletedCharacters(before time.Time) (int, error)
+	// FindCharacterNames returns the names and name keys of every account's
+	// characters, leaving out deleted ones.
+	FindCharacterNames() ([]CharacterName, error)
+	// UpdateCharacterNameKey sets the key that the name of the character in
+	// slotNum is kept unique by (see Character.NameKey).
+	// ErrCharacterNameTaken is returned if another character has the key.
+	UpdateCharacterNameKey(guildcard uint32, slotNum uint32, key string) error
+	// ImportCharacter creates the character in the snapshot (which needn't have
+	// been saved) in the slot that it names, along with their inventory,
+	// techniques, and bank if it has one. ErrSlotInUse is returned if the slot
+	// isn't empty, and ErrCharacterNameTaken if another character has the
+	// character's NameKey.
+	ImportCharacter(snapshot *CharacterSnapshot) error
+}
+
+// SnapshotRepository provides access to the history of copies of each character
+// kept for rolling them back.
+type SnapshotRepository interface {
+	// CreateSnapshot saves a snapshot of a character, setting its Id, and then
+	// removes the character's oldest snapshots so that only keep are left.
+	CreateSnapshot(snapshot *CharacterSnapshot, keep int) error
+	// FindSnapshots returns the snapshots of the character in slotNum, newest
+	// first.
+	FindSnapshots(guildcard uint32, slotNum uint32) ([]CharacterSnapshot, error)
+	// FindSnapshot returns the snapshot with the given id, or nil if there's no
+	// such snapshot.
+	FindSnapshot(id int64) (*CharacterSnapshot, error)
+	// RestoreSnapshot overwrites the character (and their inventory, techniques,
+	// and bank) in the slot that the snapshot was taken from with its contents,
+	// creating the character if the slot is empty. Snapshots don't keep the name
+	// key, so it's given as nameKey (see Character.NameKey), and
+	// ErrCharacterNameTaken is returned if another character has it.
+	RestoreSnapshot(id int64, nameKey string) error
+	// PurgeSnapshots removes all snapshots taken before the cutoff and returns
+	// the number that were removed.
+	PurgeSnapshots(before time.Time) (int, error)
+}
+
+// ItemRepository provides access to the items owned by each character.
+type ItemRepository interface {
+	// FindItems returns the items in one of a character's locations (the
+	// inventory or bank) in the order in which they're stored.
+	FindItems(guildcard uint32, slotNum uint32, location int) ([]Item, error)
+	// UpdateItems replaces the contents of one of a character's locations.
+	UpdateItems(guildcard uint32, slotNum uint32, location int, items []Item) error
+}
+
+// BankRepository provides access to the contents of each character's bank.
+type BankRepository interface {
+	// FindBank returns the bank for the character in slotNum, or the account's
+	// shared bank if slotNum is SharedBankSlot. Empty banks are returned if
+	// nothing has been deposited yet.
+	FindBank(guildcard uint32, slotNum uint32) (*Bank, error)
+	// UpdateBank replaces the meseta and items stored in the bank.
+	UpdateBank(guildcard uint32, slotNum uint32, bank *Bank) error
+	// UpdateCharacterAndBank overwrites the character in slotNum and their
+	// inventory along with the bank stored under bankSlot in one transaction,
+	// so that the items and meseta moved between them can't be lost or
+	// duplicated.
+	UpdateCharacterAndBank(guildcard uint32, slotNum uint32, character *Character, inventory []Item,
+		bankSlot uint32, bank *Bank) error
+}
+
+// TechniqueRepository provides access to the techniques each character has learned.
+type TechniqueRepository interface {
+	// FindTechniques returns the technique levels for the character in slotNum.
+	FindTechniques(guildcard uint32, slotNum uint32) (Techniques, error)
+	// UpdateTechniques replaces the technique levels for the character in slotNum.
+	UpdateTechniques(guildcard uint32, slotNum uint32, techniques Techniques) error
+}
+
+// QuestFlagRepository provides access to the flags that quests have set on
+// each character.
+type QuestFlagRepository interface {
+	// FindQuestFlags returns the quest flags set on the character in slotNum.
+	FindQuestFlags(guildcard uint32, slotNum uint32) (QuestFlags, error)
+	// UpdateQuestFlag sets or clears one of the quest flags on the character in
+	// slotNum for a difficulty.
+	UpdateQuestFlag(guildcard uint32, slotNum uint32, difficulty byte, flag uint16, set bool) error
+}
+
+// ChallengeRepository provides access to each character's challenge mode records.
+type ChallengeRepository interface {
+	// FindChallengeRecord returns the challenge mode record for the character in
+	// slotNum, which is empty if they haven't played challenge mode.
+	FindChallengeRecord(guildcard uint32, slotNum uint32) (*ChallengeRecord, error)
+	// UpdateChallengeRecord replaces the challenge mode record for the character
+	// in slotNum.
+	UpdateChallengeRecord(guildcard uint32, slotNum uint32, record *ChallengeRecord) error
+	// UpdateCharacterAndChallengeRecord overwrites the character in slotNum and
+	// their inventory along with their challenge mode record in one transaction,
+	// so that a prize can't be saved without the rank it was given for being
+	// marked as awarded, or the other way around.
+	UpdateCharacterAndChallengeRecord(guildcard uint32, slotNum uint32, character *Character, inventory []Item,
+		record *ChallengeRecord) error
+}
+
+// BattleRepository provides access to each character's battle mode results.
+type BattleRepository interface {
+	// FindBattleRecord returns the battle mode record for the character in
+	// slotNum, which is empty if they haven't finished a battle.
+	FindBattleRecord(guildcard uint32, slotNum uint32) (*BattleRecord, error)
+	// UpdateBattleRecord replaces the battle mode record for the character in
+	// slotNum.
+	UpdateBattleRecord(guildcard uint32, slotNum uint32, record *BattleRecord) error
+}
+
+// TradeRepository saves the results of trades between players.
+type TradeRepository interface {
+	// CompleteTrade saves both characters and their inventories as they are after
+	// a trade and records the trade, filling in its id. Either all of it is saved
+	// or none of it is, so items can't end up with both characters or neither.
+	CompleteTrade(trade *Trade, characters [2]*Character, inventories [2][]Item) error
+}
+
+// TeamRepository provides access to teams and their members. An account is in at
+// most one team, which is also kept as the account's TeamID.
+type TeamRepository interface {
+	// CreateTeam saves a new team with master as its first member, filling in
+	// the team's id. ErrTeamNameTaken is returned if another team has the name.
+	CreateTeam(team *Team, master *TeamMember) error
+	// FindTeam returns the team with id or nil if it doesn't exist.
+	FindTeam(id int64) (*Team, error)
+	// FindTeamMembers returns the members of a team in the order they joined.
+	FindTeamMembers(teamId int64) ([]TeamMember, error)
+	// FindTeamNames returns the names of the teams that the accounts with the
+	// guildcards are in, keyed by guildcard. Accounts without a team are left out.
+	FindTeamNames(guildcards []uint32) (map[uint32][]uint16, error)
+	// AddTeamMember adds an account to a team.
+	AddTeamMember(member *TeamMember) error
+	// RemoveTeamMember takes an account out of a team.
+	RemoveTeamMember(teamId int64, guildcard uint32) error
+	// UpdateTeamFlag replaces a team's flag.
+	UpdateTeamFlag(teamId int64, flag []byte) error
+	// DeleteTeam disbands a team, removing all of its members.
+	DeleteTeam(id int64) error
+}
+
+// GuildcardRepository provides access to an account's friend and blocked lists.
+type GuildcardRepository interface {
+	// FindGuildcardData returns all guildcards that a user has added to their friends list.
+	FindGuildcardData(guildcard uint32) ([]GuildcardEntry, error)
+	// AddGuildcard adds a player to an account's friend list, or updates their
+	// details if they're already on it. Existing comments are left as they are.
+	AddGuildcard(entry *GuildcardEntry) error
+	// RemoveGuildcard takes a player off of an account's friend list.
+	RemoveGuildcard(guildcard uint32, friendGuildcard uint32) error
+	// UpdateGuildcardComment sets the comment on a friend's guildcard.
+	UpdateGuildcardComment(guildcard uint32, friendGuildcard uint32, comment []uint16) error
+	// FindBlockedGuildcards returns the players on an account's blocked list.
+	FindBlockedGuildcards(guildcard uint32) ([]BlockedGuildcard, error)
+	// AddBlockedGuildcard adds a player to an account's blocked list, or updates
+	// their details if they're already on it.
+	AddBlockedGuildcard(entry *BlockedGuildcard) error
+	// RemoveBlockedGuildcard takes a player off of an account's blocked list.
+	RemoveBlockedGuildcard(guildcard uint32, blockedGuildcard uint32) error
+}
+
+// MailRepository provides access to the mail waiting to be delivered to players.
+type MailRepository interface {
+	// CreateMail stores a message until the recipient logs in.
+	CreateMail(mail *Mail) error
+	// CountMail returns the number of messages waiting for recipient.
+	CountMail(recipient uint32) (int, error)
+	// FindMail returns the messages waiting for recipient, oldest first.
+	FindMail(recipient uint32) ([]Mail, error)
+	// DeleteMail removes a message once it's been delivered.
+	DeleteMail(id int64) error
+}
+
+// BanRepository provides access to bans and the log of changes to them.
+type BanRepository interface {
+	// CreateBan saves a ban, filling in its id, and records it in the audit log.
+	CreateBan(ban *Ban) error
+	// LiftBan ends a ban early and records who lifted it in the audit log.
+	// ErrBanNotFound is returned if there's no active ban with the id.
+	LiftBan(id int64, actor string, reason string) error
+	// FindActiveBan returns the longest lasting ban in effect for any of the
+	// targets, or nil if none of them are banned.
+	FindActiveBan(targets []BanTarget) (*Ban, error)
+	// FindActiveBans returns all of the bans in effect, newest first.
+	FindActiveBans() ([]Ban, error)
+	// FindBanAudit returns the audit log entries for a ban, oldest first.
+	FindBanAudit(banId int64) ([]BanAuditEntry, error)
+}
+
+// HardwareRepository provides access to the machines that each account has
+// logged in from.
+type HardwareRepository interface {
+	// RecordHardware notes that username logged in from the machine with
+	// hardwareId at the time and address given.
+	RecordHardware(username string, hardwareId string, ip string, seen time.Time) error
+	// FindAccountHardware returns the machines username has logged in from,
+	// most recently used first.
+	FindAccountHardware(username string) ([]AccountHardware, error)
+	// FindHardwareAccounts returns the accounts that have logged in from the
+	// machine with hardwareId, most recently used first.
+	FindHardwareAccounts(hardwareId string) ([]AccountHardware, error)
+	// FindSharedHardware returns the records for every machine that more than
+	// one account has logged in from, grouped by machine.
+	FindSharedHardware() ([]AccountHardware, error)
+}
+
+// ItemAuditRepository provides access to the append-only log of the items and
+// meseta that change hands.
+type ItemAuditRepository interface {
+	// CreateItemAudit appends entries to the log in one transaction.
+	CreateItemAudit(entries []ItemAuditEntry) error
+	// FindGuildcardItemAudit returns up to limit of the newest entries for
+	// things done by guildcard or traded with them, newest first.
+	FindGuildcardItemAudit(guildcard uint32, limit int) ([]ItemAuditEntry, error)
+	// FindItemAudit returns the entries for items with the same data (the
+	// first 12 bytes, which include a stack's size), oldest first.
+	FindItemAudit(itemData []byte) ([]ItemAuditEntry, error)
+}
+
+// LoginAuditRepository provides access to the log of attempts to log in.
+type LoginAuditRepository interface {
+	// CreateLoginAttempt appends an attempt to the log, filling in its id.
+	CreateLoginAttempt(attempt *LoginAttempt) error
+	// FindLoginAttempts returns up to limit of the newest attempts, newest
+	// first, leaving out any that weren't made with username or from ip when
+	// they're set.
+	FindLoginAttempts(username string, ip string, limit int) ([]LoginAttempt, error)
+	// PurgeLoginAttempts removes all attempts made before the cutoff and
+	// returns the number removed.
+	PurgeLoginAttempts(before time.Time) (int, error)
+}
+
+// CheatRepository provides access to the record of players caught breaking the
+// anti-cheat rules.
+type CheatRepository interface {
+	// CreateCheatFlag records that a player broke a rule, filling in its id.
+	CreateCheatFlag(flag *CheatFlag) error
+	// FindCheatFlags returns the flags recorded against guildcard, newest first.
+	FindCheatFlags(guildcard uint32) ([]CheatFlag, error)
+	// FindRecentCheatFlags returns the newest flags recorded against anyone, up
+	// to limit of them.
+	FindRecentCheatFlags(limit int) ([]CheatFlag, error)
+}
+
+// EconomyRepository provides the totals for the economy reports and access to
+// the daily stats they're kept in.
+type EconomyRepository interface {
+	// SumMeseta returns the meseta held by all characters and in all banks,
+	// leaving out deleted characters.
+	SumMeseta() (uint64, error)
+	// CountItemTypes returns the number of items of each type (the first three
+	// bytes of their data) held by all characters and in all banks, leaving out
+	// deleted characters. Stacks count once.
+	CountItemTypes() (map[[3]byte]int, error)
+	// CountTrades returns the number of trades made between from and to and the
+	// meseta that changed hands in them.
+	CountTrades(from, to time.Time) (trades int, meseta uint64, err error)
+	// CountActiveAccounts returns the number of accounts that last logged in
+	// between from and to.
+	CountActiveAccounts(from, to time.Time) (int, error)
+	// SaveEconomyStats creates or replaces the stats for stats.Day.
+	SaveEconomyStats(stats *EconomyStats) error
+	// FindEconomyStats returns the stats for each day from the one starting at
+	// from on, oldest first.
+	FindEconomyStats(from time.Time) ([]EconomyStats, error)
+}
+
+// SessionRepository provides access to the session tokens issued to players
+// when they log in.
+type SessionRepository interface {
+	// CreateSession saves the hash of a session token for guildcard, replacing
+	// any token that was issued to it before and clearing out expired ones.
+	CreateSession(guildcard uint32, tokenHash string, expires time.Time) error
+	// RefreshSession moves the expiry of the token with tokenHash to expires and
+	// returns the guildcard it was issued to, or 0 if there's no such token or
+	// it has already expired.
+	RefreshSession(tokenHash string, expires time.Time) (uint32, error)
+}
+
+// Store is the full set of repositories implemented by each backend.
+type Store interface {
+	AccountRepository
+	TwoFactorRepository
+	OptionsRepository
+	CharacterRepository
+	SnapshotRepository
+	ItemRepository
+	BankRepository
+	TechniqueRepository
+	QuestFlagRepository
+	ChallengeRepository
+	BattleRepository
+	TradeRepository
+	TeamRepository
+	GuildcardRepository
+	MailRepository
+	BanRepository
+	HardwareRepository
+	ItemAuditRepository
+	LoginAuditRepository
+	CheatRepository
+	EconomyRepository
+	SessionRepository
+
+	// Migrate brings the schema to the target version, applying or reverting
+	// migrations as needed. LatestSchema applies everything available.
+	Migrate(target int) error
+	// SchemaVersion returns the applied and newest available schema versions.
+	SchemaVersion() (current int, latest int, err error)
+	// Ping checks that the database can still be reached.
+	Ping(ctx context.Context) error
+	// WithContext returns a copy of the store whose queries are cancelled once
+	// ctx is done, along with after the usual timeout. The copy shares the
+	// original's connections, and closing either closes both.
+	WithContext(ctx context.Context) Store
+	// Replica returns a copy of the store whose queries outside of transactions
+	// are made on the read replica, if there is one and it can be reached, and
+	// on the primary otherwise. Writes always go to the primary. The replica
+	// can lag behind the primary, so it's only for reads that can be slightly
+	// out of date.
+	Replica() Store
+	// Close releases any connections held by the backend.
+	Close() error
+}
+
+// Driver is implemented by each backend and is responsible for returning a
+// connected Store given the configured parameters.
+type Driver interface {
+	Open(cfg Config) (Store, error)
+}
+
+var (
+	driversMu sync.RWMutex
+	drivers   = make(map[string]Driver)
+)
+
+// Register makes a backend available under the provided name. Drivers are
+// expected to call this from an init() function.
+func Register(name string, driver Driver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	if driver == nil {
+		panic("data: Register driver is nil")
+	}
+	if _, dup := drivers[name]; dup {
+		panic("data: Register called twice for driver " + name)
+	}
+	drivers[name] = driver
+}
+
+// Drivers returns a sorted list of the names of the registered backends.
+func Drivers() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open connects to the backend named by cfg.Driver.
+func Open(cfg Config) (Store, error) {
+	driversMu.RLock()
+	driver, ok := drivers[cfg.Driver]
+	driversMu.RUnlock()
+	if !ok {
+		return nil, errors.New("data: unknown driver " + cfg.Driver)
+	}
+	return driver.Open(cfg)
+}
@@ -0,0 +1,189 @@
+/*
+* Versioned schema migrations for the SQL backends. Each dialect has its own
+* directory of numbered scripts named <version>_<name>.up.sql along with a
+* matching .down.sql that reverts it. The scripts are embedded in the binary
+* and the applied version is tracked in the schema_migrations table.
+ */
+package migrations
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Latest can be passed as a target version to apply all available migrations.
+const Latest = -1
+
+//go:embed mysql/*.sql postgres/*.sql sqlite/*.sql
+var scripts embed.FS
+
+// Migration is a single versioned change to the schema.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Load returns all of the migrations for a dialect ordered by version.
+func Load(dialect string) ([]Migration, error) {
+	files, err := scripts.ReadDir(dialect)
+	if err != nil {
+		return nil, fmt.Errorf("migrations: no scripts for dialect %s", dialect)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, file := range files {
+		filename := file.Name()
+		parts := strings.SplitN(filename, "_", 2)
+		version, err := strconv.Atoi(parts[0])
+		if len(parts) != 2 || err != nil {
+			return nil, fmt.Errorf("migrations: malformed script name %s", filename)
+		}
+		contents, err := scripts.ReadFile(path.Join(dialect, filename))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version}
+			byVersion[version] = m
+		}
+		switch {
+		case strings.HasSuffix(parts[1], ".up.sql"):
+			m.Name = strings.TrimSuffix(parts[1], ".up.sql")
+			m.Up = string(contents)
+		case strings.HasSuffix(parts[1], ".down.sql"):
+			m.Down = string(contents)
+		default:
+			return nil, fmt.Errorf("migrations: malformed script name %s", filename)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migrations: version %d is missing an up or down script", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// LatestVersion returns the newest schema version available for a dialect.
+func LatestVersion(dialect string) (int, error) {
+	migrations, err := Load(dialect)
+	if err != nil || len(migrations) == 0 {
+		return 0, err
+	}
+	return migrations[len(migrations)-1].Version, nil
+}
+
+// Version returns the schema version currently applied to db, or 0 if no
+// migrations have been run.
+func Version(db *sql.DB) (int, error) {
+	if err := createVersionTable(db); err != nil {
+		return 0, err
+	}
+	var version sql.NullInt64
+	err := db.QueryRow("SELECT MAX(version) FROM schema_migrations").Scan(&version)
+	return int(version.Int64), err
+}
+
+// Migrate applies the up or down scripts needed to bring db to the target
+// version. Pass Latest to apply everything that hasn't been run yet.
+func Migrate(db *sql.DB, dialect string, target int) error {
+	migrations, err := Load(dialect)
+	if err != nil {
+		return err
+	}
+	current, err := Version(db)
+	if err != nil {
+		return err
+	}
+	if target == Latest && len(migrations) > 0 {
+		target = migrations[len(migrations)-1].Version
+	}
+
+	if target >= current {
+		for _, m := range migrations {
+			if m.Version > current && m.Version <= target {
+				if err := apply(db, m.Version, m.Up, true); err != nil {
+					return fmt.Errorf("migrations: applying %04d_%s: %v", m.Version, m.Name, err)
+				}
+			}
+		}
+		return nil
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.Version <= current && m.Version > target {
+			if err := apply(db, m.Version, m.Down, false); err != nil {
+				return fmt.Errorf("migrations: reverting %04d_%s: %v", m.Version, m.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func createVersionTable(db *sql.DB) error {
+	_, err := db.Exec("CREATE TABLE IF NOT EXISTS schema_migrations (" +
+		"version INTEGER NOT NULL PRIMARY KEY, " +
+		"applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP)")
+	return err
+}
+
+// Run each statement in a script and record the new version. Note that MySQL
+// implicitly commits DDL statements, so a failed script may need manual cleanup.
+func apply(db *sql.DB, version int, script string, up bool) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, stmt := range statements(script) {
+		if _, err = tx.Exec(stmt); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	// The version is an int we control, so it's safe to format it directly and
+	// avoids dealing with each dialect's placeholder syntax.
+	if up {
+		_, err = tx.Exec("INSERT INTO schema_migrations (version) VALUES (" + strconv.Itoa(version) + ")")
+	} else {
+		_, err = tx.Exec("DELETE FROM schema_migrations WHERE version = " + strconv.Itoa(version))
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Split a script into individual statements since not all of the drivers
+// support executing more than one at a time.
+func statements(script string) []string {
+	var lines []string
+	for _, line := range strings.Split(script, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "--") {
+			lines = append(lines, line)
+		}
+	}
+	var stmts []string
+	for _, stmt := range strings.Split(strings.Join(lines, "\n"), ";") {
+		if stmt = strings.TrimSpace(stmt); stmt != "" {
+			stmts = append(stmts, stmt)
+		}
+	}
+	return stmts
+}
@@ -0,0 +1,67 @@
+CREATE TABLE accounts (
+  username          VARCHAR(16) NOT NULL PRIMARY KEY,
+  password          VARCHAR(64) NOT NULL,
+  email             VARCHAR(128) NOT NULL DEFAULT '',
+  registration_date DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  guildcard         INT UNSIGNED NOT NULL AUTO_INCREMENT UNIQUE,
+  is_gm             BOOLEAN NOT NULL DEFAULT FALSE,
+  banned            BOOLEAN NOT NULL DEFAULT FALSE,
+  active            BOOLEAN NOT NULL DEFAULT TRUE,
+  team_id           INT UNSIGNED NOT NULL DEFAULT 0,
+  privilege_level   TINYINT UNSIGNED NOT NULL DEFAULT 0
+) AUTO_INCREMENT = 10000000;
+
+CREATE TABLE player_options (
+  guildcard  INT UNSIGNED NOT NULL PRIMARY KEY,
+  key_config BLOB NOT NULL
+);
+
+CREATE TABLE characters (
+  guildcard           INT UNSIGNED NOT NULL,
+  slot                INT UNSIGNED NOT NULL,
+  guildcard_str       BLOB NOT NULL,
+  experience          INT UNSIGNED NOT NULL DEFAULT 0,
+  level               INT UNSIGNED NOT NULL DEFAULT 0,
+  name_color          INT UNSIGNED NOT NULL DEFAULT 0,
+  model               TINYINT UNSIGNED NOT NULL DEFAULT 0,
+  name_color_checksum INT UNSIGNED NOT NULL DEFAULT 0,
+  section_id          TINYINT UNSIGNED NOT NULL DEFAULT 0,
+  class               TINYINT UNSIGNED NOT NULL DEFAULT 0,
+  v2_flags            TINYINT UNSIGNED NOT NULL DEFAULT 0,
+  version             TINYINT UNSIGNED NOT NULL DEFAULT 0,
+  v1_flags            INT UNSIGNED NOT NULL DEFAULT 0,
+  costume             SMALLINT UNSIGNED NOT NULL DEFAULT 0,
+  skin                SMALLINT UNSIGNED NOT NULL DEFAULT 0,
+  face                SMALLINT UNSIGNED NOT NULL DEFAULT 0,
+  head                SMALLINT UNSIGNED NOT NULL DEFAULT 0,
+  hair                SMALLINT UNSIGNED NOT NULL DEFAULT 0,
+  hair_red            SMALLINT UNSIGNED NOT NULL DEFAULT 0,
+  hair_green          SMALLINT UNSIGNED NOT NULL DEFAULT 0,
+  hair_blue           SMALLINT UNSIGNED NOT NULL DEFAULT 0,
+  proportion_x        FLOAT NOT NULL DEFAULT 0,
+  proportion_y        FLOAT NOT NULL DEFAULT 0,
+  name                BLOB NOT NULL,
+  playtime            INT UNSIGNED NOT NULL DEFAULT 0,
+  atp                 SMALLINT UNSIGNED NOT NULL DEFAULT 0,
+  mst                 SMALLINT UNSIGNED NOT NULL DEFAULT 0,
+  evp                 SMALLINT UNSIGNED NOT NULL DEFAULT 0,
+  hp                  SMALLINT UNSIGNED NOT NULL DEFAULT 0,
+  dfp                 SMALLINT UNSIGNED NOT NULL DEFAULT 0,
+  ata                 SMALLINT UNSIGNED NOT NULL DEFAULT 0,
+  lck                 SMALLINT UNSIGNED NOT NULL DEFAULT 0,
+  meseta              INT UNSIGNED NOT NULL DEFAULT 0,
+  PRIMARY KEY (guildcard, slot)
+);
+
+CREATE TABLE guildcard_entries (
+  guildcard        INT UNSIGNED NOT NULL,
+  friend_guildcard INT UNSIGNED NOT NULL,
+  name             BLOB NOT NULL,
+  team_name        BLOB NOT NULL,
+  description      BLOB NOT NULL,
+  language         TINYINT UNSIGNED NOT NULL DEFAULT 0,
+  section_id       TINYINT UNSIGNED NOT NULL DEFAULT 0,
+  class            TINYINT UNSIGNED NOT NULL DEFAULT 0,
+  comment          BLOB NOT NULL,
+  PRIMARY KEY (guildcard, friend_guildcard)
+);
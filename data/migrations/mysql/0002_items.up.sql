@@ -0,0 +1,13 @@
+-- Items owned by a character. Location distinguishes the inventory from the
+-- bank, and position is the item's index within that location.
+CREATE TABLE character_items (
+  guildcard INT UNSIGNED NOT NULL,
+  slot      INT UNSIGNED NOT NULL,
+  location  TINYINT UNSIGNED NOT NULL,
+  position  SMALLINT UNSIGNED NOT NULL,
+  item_id   INT UNSIGNED NOT NULL,
+  data      BLOB NOT NULL,
+  data2     BLOB NOT NULL,
+  flags     INT UNSIGNED NOT NULL DEFAULT 0,
+  PRIMARY KEY (guildcard, slot, location, position)
+);
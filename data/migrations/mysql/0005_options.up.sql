@@ -0,0 +1,9 @@
+-- Split the joystick config out of key_config (which held both) and add the
+-- remaining option fields. version is the version of the server's default
+-- options that the row was created from.
+ALTER TABLE player_options
+  ADD COLUMN joystick_config BLOB NOT NULL,
+  ADD COLUMN option_flags INT UNSIGNED NOT NULL DEFAULT 0,
+  ADD COLUMN version INT UNSIGNED NOT NULL DEFAULT 1;
+-- MySQL applies assignments in order, so joystick_config has to come first.
+UPDATE player_options SET joystick_config = SUBSTRING(key_config, 365), key_config = SUBSTRING(key_config, 1, 364);
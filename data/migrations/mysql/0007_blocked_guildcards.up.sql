@@ -0,0 +1,13 @@
+-- Players on an account's blocked list, along with the guildcard details that
+-- the client shows for them.
+CREATE TABLE blocked_guildcards (
+  guildcard         INT UNSIGNED NOT NULL,
+  blocked_guildcard INT UNSIGNED NOT NULL,
+  name              BLOB NOT NULL,
+  team_name         BLOB NOT NULL,
+  description       BLOB NOT NULL,
+  language          TINYINT UNSIGNED NOT NULL DEFAULT 0,
+  section_id        TINYINT UNSIGNED NOT NULL DEFAULT 0,
+  class             TINYINT UNSIGNED NOT NULL DEFAULT 0,
+  PRIMARY KEY (guildcard, blocked_guildcard)
+);
@@ -0,0 +1,10 @@
+-- Simple mail sent to players that weren't online, delivered when they next log in.
+CREATE TABLE mail (
+  id          INT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  recipient   INT UNSIGNED NOT NULL,
+  sender      INT UNSIGNED NOT NULL,
+  sender_name BLOB NOT NULL,
+  sent_at     DATETIME NOT NULL,
+  message     BLOB NOT NULL,
+  INDEX (recipient)
+);
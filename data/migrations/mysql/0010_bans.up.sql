@@ -0,0 +1,23 @@
+-- Bans by guildcard, account, IP address, or hardware serial, along with a log of who
+-- issued or lifted each one and why.
+CREATE TABLE bans (
+  id         INT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  ban_type   VARCHAR(16) NOT NULL,
+  target     VARCHAR(64) NOT NULL,
+  reason     VARCHAR(255) NOT NULL DEFAULT '',
+  issued_by  VARCHAR(64) NOT NULL,
+  issued_at  DATETIME NOT NULL,
+  expires_at DATETIME NULL,
+  lifted     BOOLEAN NOT NULL DEFAULT FALSE,
+  INDEX (ban_type, target)
+);
+
+CREATE TABLE ban_audit (
+  id         INT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  ban_id     INT UNSIGNED NOT NULL,
+  action     VARCHAR(16) NOT NULL,
+  actor      VARCHAR(64) NOT NULL,
+  reason     VARCHAR(255) NOT NULL DEFAULT '',
+  created_at DATETIME NOT NULL,
+  INDEX (ban_id)
+);
@@ -0,0 +1,15 @@
+-- TOTP secrets for accounts with two-factor authentication, which isn't enforced
+-- until the player confirms it with a code from their authenticator.
+CREATE TABLE two_factor (
+  username  VARCHAR(16) NOT NULL PRIMARY KEY,
+  secret    VARCHAR(64) NOT NULL,
+  enabled   BOOLEAN NOT NULL DEFAULT FALSE,
+  last_step BIGINT NOT NULL DEFAULT 0
+);
+-- One-time codes for turning two-factor authentication off without the
+-- authenticator. Only a hash of each code is stored.
+CREATE TABLE recovery_codes (
+  username  VARCHAR(16) NOT NULL,
+  code_hash CHAR(64) NOT NULL,
+  PRIMARY KEY (username, code_hash)
+);
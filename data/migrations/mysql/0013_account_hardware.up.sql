@@ -0,0 +1,11 @@
+-- Machines (by the hardware info the client sends at login) that each account
+-- has logged in from, for hardware bans and spotting players with several accounts.
+CREATE TABLE account_hardware (
+  username    VARCHAR(16) NOT NULL,
+  hardware_id VARCHAR(16) NOT NULL,
+  first_seen  DATETIME NOT NULL,
+  last_seen   DATETIME NOT NULL,
+  last_ip     VARCHAR(45) NOT NULL,
+  PRIMARY KEY (username, hardware_id),
+  INDEX (hardware_id)
+);
@@ -0,0 +1 @@
+UPDATE accounts SET privilege_level = 0;
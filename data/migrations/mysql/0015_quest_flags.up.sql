@@ -0,0 +1,9 @@
+-- Flags set by quests on each character, such as which quests have been
+-- cleared. Flags that aren't set have no row.
+CREATE TABLE character_quest_flags (
+  guildcard  INT UNSIGNED NOT NULL,
+  slot       INT UNSIGNED NOT NULL,
+  difficulty TINYINT UNSIGNED NOT NULL,
+  flag       SMALLINT UNSIGNED NOT NULL,
+  PRIMARY KEY (guildcard, slot, difficulty, flag)
+);
@@ -0,0 +1,2 @@
+DROP TABLE trade_items;
+DROP TABLE trades;
@@ -0,0 +1,23 @@
+-- Log of the trades between characters for auditing the economy. Side is 0 for
+-- the items given by the first character in the trade and 1 for the second.
+CREATE TABLE trades (
+  id         INT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  traded_at  DATETIME NOT NULL,
+  guildcard1 INT UNSIGNED NOT NULL,
+  slot1      INT UNSIGNED NOT NULL,
+  meseta1    INT UNSIGNED NOT NULL DEFAULT 0,
+  guildcard2 INT UNSIGNED NOT NULL,
+  slot2      INT UNSIGNED NOT NULL,
+  meseta2    INT UNSIGNED NOT NULL DEFAULT 0,
+  INDEX (guildcard1),
+  INDEX (guildcard2)
+);
+
+CREATE TABLE trade_items (
+  trade_id INT UNSIGNED NOT NULL,
+  side     TINYINT UNSIGNED NOT NULL,
+  position SMALLINT UNSIGNED NOT NULL,
+  data     BLOB NOT NULL,
+  data2    BLOB NOT NULL,
+  PRIMARY KEY (trade_id, side, position)
+);
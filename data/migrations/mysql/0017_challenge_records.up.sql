@@ -0,0 +1,21 @@
+-- Challenge mode progress for each character. Characters that haven't played
+-- challenge mode have no rows.
+CREATE TABLE character_challenges (
+  guildcard   INT UNSIGNED NOT NULL,
+  slot        INT UNSIGNED NOT NULL,
+  title       VARCHAR(12) NOT NULL,
+  title_color SMALLINT UNSIGNED NOT NULL,
+  awards_ep1  INT UNSIGNED NOT NULL,
+  awards_ep2  INT UNSIGNED NOT NULL,
+  PRIMARY KEY (guildcard, slot)
+);
+
+-- Best clear time in seconds for each challenge mode stage a character has cleared.
+CREATE TABLE character_challenge_times (
+  guildcard  INT UNSIGNED NOT NULL,
+  slot       INT UNSIGNED NOT NULL,
+  episode    TINYINT UNSIGNED NOT NULL,
+  stage      TINYINT UNSIGNED NOT NULL,
+  clear_time INT UNSIGNED NOT NULL,
+  PRIMARY KEY (guildcard, slot, episode, stage)
+);
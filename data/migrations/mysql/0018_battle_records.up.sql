@@ -0,0 +1,18 @@
+-- Battle mode results for each character. Characters that haven't finished a
+-- battle have no row. place1-4 count the battles finished in each place and
+-- disconnects1-4 the battles left early, by the place they were in.
+CREATE TABLE character_battle_records (
+  guildcard    INT UNSIGNED NOT NULL,
+  slot         INT UNSIGNED NOT NULL,
+  place1       INT UNSIGNED NOT NULL DEFAULT 0,
+  place2       INT UNSIGNED NOT NULL DEFAULT 0,
+  place3       INT UNSIGNED NOT NULL DEFAULT 0,
+  place4       INT UNSIGNED NOT NULL DEFAULT 0,
+  disconnects1 INT UNSIGNED NOT NULL DEFAULT 0,
+  disconnects2 INT UNSIGNED NOT NULL DEFAULT 0,
+  disconnects3 INT UNSIGNED NOT NULL DEFAULT 0,
+  disconnects4 INT UNSIGNED NOT NULL DEFAULT 0,
+  kills        INT UNSIGNED NOT NULL DEFAULT 0,
+  deaths       INT UNSIGNED NOT NULL DEFAULT 0,
+  PRIMARY KEY (guildcard, slot)
+);
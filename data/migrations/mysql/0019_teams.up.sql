@@ -0,0 +1,17 @@
+-- Teams that players have formed. Each account in a team has a row in
+-- team_members and the team's id in accounts.team_id.
+CREATE TABLE teams (
+  id         INT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  name       BLOB NOT NULL,
+  flag       BLOB NOT NULL,
+  created_at DATETIME NOT NULL
+);
+
+CREATE TABLE team_members (
+  team_id   INT UNSIGNED NOT NULL,
+  guildcard INT UNSIGNED NOT NULL PRIMARY KEY,
+  name      BLOB NOT NULL,
+  privilege TINYINT UNSIGNED NOT NULL,
+  joined_at DATETIME NOT NULL,
+  INDEX (team_id)
+);
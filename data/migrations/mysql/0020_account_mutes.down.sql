@@ -0,0 +1,3 @@
+ALTER TABLE accounts
+  DROP COLUMN shadow_muted,
+  DROP COLUMN muted_until;
@@ -0,0 +1,12 @@
+-- Copies of characters taken when their players log off, kept so that a character
+-- can be rolled back after it has been corrupted or stolen from. Contents holds
+-- the JSON encoded character, inventory, techniques, and bank.
+CREATE TABLE character_snapshots (
+  id         BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  guildcard  INT UNSIGNED NOT NULL,
+  slot       INT UNSIGNED NOT NULL,
+  created_at DATETIME NOT NULL,
+  contents   MEDIUMBLOB NOT NULL,
+  INDEX (guildcard, slot),
+  INDEX (created_at)
+);
@@ -0,0 +1,10 @@
+-- Players caught breaking one of the anti-cheat rules, kept for the GMs to look
+-- into. Details describes what the player's client sent.
+CREATE TABLE cheat_flags (
+  id         BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  guildcard  INT UNSIGNED NOT NULL,
+  rule       VARCHAR(32) NOT NULL,
+  details    VARCHAR(255) NOT NULL,
+  created_at DATETIME NOT NULL,
+  INDEX (guildcard)
+);
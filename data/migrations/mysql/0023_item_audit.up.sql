@@ -0,0 +1,17 @@
+-- Append-only log of every item and amount of meseta that changes hands, for
+-- tracing where a duplicated or stolen item came from. Partner is the other
+-- player in a trade (0 for everything else), and meseta is the meseta that
+-- was paid or received along with the item, if any.
+CREATE TABLE item_audit (
+  id         BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  created_at DATETIME NOT NULL,
+  guildcard  INT UNSIGNED NOT NULL,
+  action     VARCHAR(16) NOT NULL,
+  item_id    INT UNSIGNED NOT NULL,
+  data       VARBINARY(12) NOT NULL,
+  data2      VARBINARY(4) NOT NULL,
+  meseta     INT UNSIGNED NOT NULL DEFAULT 0,
+  partner    INT UNSIGNED NOT NULL DEFAULT 0,
+  INDEX (guildcard),
+  INDEX (data)
+);
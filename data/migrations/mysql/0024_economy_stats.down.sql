@@ -0,0 +1 @@
+DROP TABLE economy_stats;
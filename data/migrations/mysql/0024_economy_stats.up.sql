@@ -0,0 +1,14 @@
+-- Daily totals for the economy reports, one row per day (starting at midnight
+-- UTC). Meseta supply and rare items are what was held in inventories and banks
+-- when the row was last updated, and active players counts the accounts that
+-- logged in that day.
+CREATE TABLE economy_stats (
+  day            DATETIME NOT NULL PRIMARY KEY,
+  meseta_supply  BIGINT UNSIGNED NOT NULL DEFAULT 0,
+  rare_items     INT UNSIGNED NOT NULL DEFAULT 0,
+  trades         INT UNSIGNED NOT NULL DEFAULT 0,
+  trade_meseta   BIGINT UNSIGNED NOT NULL DEFAULT 0,
+  active_players INT UNSIGNED NOT NULL DEFAULT 0,
+  peak_online    INT UNSIGNED NOT NULL DEFAULT 0,
+  updated_at     DATETIME NOT NULL
+);
@@ -0,0 +1,3 @@
+DROP INDEX characters_name_key ON characters;
+ALTER TABLE deleted_characters DROP COLUMN name_key;
+ALTER TABLE characters DROP COLUMN name_key;
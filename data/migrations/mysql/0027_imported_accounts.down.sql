@@ -0,0 +1 @@
+DROP TABLE imported_accounts;
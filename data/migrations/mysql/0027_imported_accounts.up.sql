@@ -0,0 +1,9 @@
+-- Accounts created by "archon import", by the server they came from and their
+-- guildcard there, so that an import that's run again carries on with the
+-- accounts that an earlier run created rather than skipping them.
+CREATE TABLE imported_accounts (
+  source           VARCHAR(16) NOT NULL,
+  source_guildcard INT UNSIGNED NOT NULL,
+  guildcard        INT UNSIGNED NOT NULL,
+  PRIMARY KEY (source, source_guildcard)
+);
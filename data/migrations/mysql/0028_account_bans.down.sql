@@ -0,0 +1,5 @@
+-- The flag can only say that an account is banned for good, so it's set for
+-- the accounts with a permanent ban. The bans themselves are left in place.
+ALTER TABLE accounts ADD COLUMN banned BOOLEAN NOT NULL DEFAULT FALSE;
+UPDATE accounts SET banned = TRUE WHERE username IN (
+  SELECT target FROM bans WHERE ban_type = 'account' AND lifted = FALSE AND expires_at IS NULL);
@@ -0,0 +1,5 @@
+DROP TABLE guildcard_entries;
+DROP TABLE characters;
+DROP TABLE player_options;
+DROP TABLE accounts;
+DROP SEQUENCE guildcard_seq;
@@ -0,0 +1,69 @@
+CREATE SEQUENCE guildcard_seq START 10000000;
+
+CREATE TABLE accounts (
+  username          VARCHAR(16) NOT NULL PRIMARY KEY,
+  password          VARCHAR(64) NOT NULL,
+  email             VARCHAR(128) NOT NULL DEFAULT '',
+  registration_date TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  guildcard         BIGINT NOT NULL UNIQUE DEFAULT nextval('guildcard_seq'),
+  is_gm             BOOLEAN NOT NULL DEFAULT FALSE,
+  banned            BOOLEAN NOT NULL DEFAULT FALSE,
+  active            BOOLEAN NOT NULL DEFAULT TRUE,
+  team_id           BIGINT NOT NULL DEFAULT 0,
+  privilege_level   SMALLINT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE player_options (
+  guildcard  BIGINT NOT NULL PRIMARY KEY,
+  key_config BYTEA NOT NULL
+);
+
+CREATE TABLE characters (
+  guildcard           BIGINT NOT NULL,
+  slot                INTEGER NOT NULL,
+  guildcard_str       BYTEA NOT NULL,
+  experience          BIGINT NOT NULL DEFAULT 0,
+  level               BIGINT NOT NULL DEFAULT 0,
+  name_color          BIGINT NOT NULL DEFAULT 0,
+  model               SMALLINT NOT NULL DEFAULT 0,
+  name_color_checksum BIGINT NOT NULL DEFAULT 0,
+  section_id          SMALLINT NOT NULL DEFAULT 0,
+  class               SMALLINT NOT NULL DEFAULT 0,
+  v2_flags            SMALLINT NOT NULL DEFAULT 0,
+  version             SMALLINT NOT NULL DEFAULT 0,
+  v1_flags            BIGINT NOT NULL DEFAULT 0,
+  costume             INTEGER NOT NULL DEFAULT 0,
+  skin                INTEGER NOT NULL DEFAULT 0,
+  face                INTEGER NOT NULL DEFAULT 0,
+  head                INTEGER NOT NULL DEFAULT 0,
+  hair                INTEGER NOT NULL DEFAULT 0,
+  hair_red            INTEGER NOT NULL DEFAULT 0,
+  hair_green          INTEGER NOT NULL DEFAULT 0,
+  hair_blue           INTEGER NOT NULL DEFAULT 0,
+  proportion_x        REAL NOT NULL DEFAULT 0,
+  proportion_y        REAL NOT NULL DEFAULT 0,
+  name                BYTEA NOT NULL,
+  playtime            BIGINT NOT NULL DEFAULT 0,
+  atp                 INTEGER NOT NULL DEFAULT 0,
+  mst                 INTEGER NOT NULL DEFAULT 0,
+  evp                 INTEGER NOT NULL DEFAULT 0,
+  hp                  INTEGER NOT NULL DEFAULT 0,
+  dfp                 INTEGER NOT NULL DEFAULT 0,
+  ata                 INTEGER NOT NULL DEFAULT 0,
+  lck                 INTEGER NOT NULL DEFAULT 0,
+  meseta              BIGINT NOT NULL DEFAULT 0,
+  PRIMARY KEY (guildcard, slot)
+);
+
+CREATE TABLE guildcard_entries (
+  guildcard        BIGINT NOT NULL,
+  friend_guildcard BIGINT NOT NULL,
+  name             BYTEA NOT NULL,
+  team_name        BYTEA NOT NULL,
+  description      BYTEA NOT NULL,
+  language         SMALLINT NOT NULL DEFAULT 0,
+  section_id       SMALLINT NOT NULL DEFAULT 0,
+  class            SMALLINT NOT NULL DEFAULT 0,
+  comment          BYTEA NOT NULL,
+  PRIMARY KEY (guildcard, friend_guildcard)
+);
@@ -0,0 +1,13 @@
+-- Items owned by a character. Location distinguishes the inventory from the
+-- bank, and position is the item's index within that location.
+CREATE TABLE character_items (
+  guildcard BIGINT NOT NULL,
+  slot      INTEGER NOT NULL,
+  location  SMALLINT NOT NULL,
+  position  INTEGER NOT NULL,
+  item_id   BIGINT NOT NULL,
+  data      BYTEA NOT NULL,
+  data2     BYTEA NOT NULL,
+  flags     BIGINT NOT NULL DEFAULT 0,
+  PRIMARY KEY (guildcard, slot, location, position)
+);
@@ -0,0 +1,9 @@
+-- Techniques learned by each character. Level is the level shown in game
+-- (starting at 1); techniques that haven't been learned have no row.
+CREATE TABLE character_techniques (
+  guildcard BIGINT NOT NULL,
+  slot      INTEGER NOT NULL,
+  technique SMALLINT NOT NULL,
+  level     SMALLINT NOT NULL,
+  PRIMARY KEY (guildcard, slot, technique)
+);
@@ -0,0 +1,5 @@
+UPDATE player_options SET key_config = key_config || joystick_config;
+ALTER TABLE player_options
+  DROP COLUMN version,
+  DROP COLUMN option_flags,
+  DROP COLUMN joystick_config;
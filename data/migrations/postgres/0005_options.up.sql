@@ -0,0 +1,8 @@
+-- Split the joystick config out of key_config (which held both) and add the
+-- remaining option fields. version is the version of the server's default
+-- options that the row was created from.
+ALTER TABLE player_options
+  ADD COLUMN joystick_config BYTEA NOT NULL DEFAULT '',
+  ADD COLUMN option_flags BIGINT NOT NULL DEFAULT 0,
+  ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+UPDATE player_options SET joystick_config = substring(key_config from 365), key_config = substring(key_config from 1 for 364);
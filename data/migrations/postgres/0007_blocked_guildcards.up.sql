@@ -0,0 +1,13 @@
+-- Players on an account's blocked list, along with the guildcard details that
+-- the client shows for them.
+CREATE TABLE blocked_guildcards (
+  guildcard         BIGINT NOT NULL,
+  blocked_guildcard BIGINT NOT NULL,
+  name              BYTEA NOT NULL,
+  team_name         BYTEA NOT NULL,
+  description       BYTEA NOT NULL,
+  language          SMALLINT NOT NULL DEFAULT 0,
+  section_id        SMALLINT NOT NULL DEFAULT 0,
+  class             SMALLINT NOT NULL DEFAULT 0,
+  PRIMARY KEY (guildcard, blocked_guildcard)
+);
@@ -0,0 +1,10 @@
+-- Simple mail sent to players that weren't online, delivered when they next log in.
+CREATE TABLE mail (
+  id          SERIAL PRIMARY KEY,
+  recipient   BIGINT NOT NULL,
+  sender      BIGINT NOT NULL,
+  sender_name BYTEA NOT NULL,
+  sent_at     TIMESTAMP NOT NULL,
+  message     BYTEA NOT NULL
+);
+CREATE INDEX mail_recipient ON mail (recipient);
@@ -0,0 +1,2 @@
+DROP TABLE ban_audit;
+DROP TABLE bans;
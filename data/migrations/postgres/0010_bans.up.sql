@@ -0,0 +1,23 @@
+-- Bans by guildcard, account, IP address, or hardware serial, along with a log of who
+-- issued or lifted each one and why.
+CREATE TABLE bans (
+  id         SERIAL PRIMARY KEY,
+  ban_type   VARCHAR(16) NOT NULL,
+  target     VARCHAR(64) NOT NULL,
+  reason     VARCHAR(255) NOT NULL DEFAULT '',
+  issued_by  VARCHAR(64) NOT NULL,
+  issued_at  TIMESTAMP NOT NULL,
+  expires_at TIMESTAMP NULL,
+  lifted     BOOLEAN NOT NULL DEFAULT FALSE
+);
+CREATE INDEX bans_target ON bans (ban_type, target);
+
+CREATE TABLE ban_audit (
+  id         SERIAL PRIMARY KEY,
+  ban_id     BIGINT NOT NULL,
+  action     VARCHAR(16) NOT NULL,
+  actor      VARCHAR(64) NOT NULL,
+  reason     VARCHAR(255) NOT NULL DEFAULT '',
+  created_at TIMESTAMP NOT NULL
+);
+CREATE INDEX ban_audit_ban_id ON ban_audit (ban_id);
@@ -0,0 +1,8 @@
+-- Session tokens issued to players when they log in and checked when they connect
+-- to the character and ship servers. Only a hash of each token is stored.
+CREATE TABLE sessions (
+  token_hash CHAR(64) NOT NULL PRIMARY KEY,
+  guildcard  BIGINT NOT NULL,
+  expires_at TIMESTAMP NOT NULL
+);
+CREATE INDEX sessions_guildcard ON sessions (guildcard);
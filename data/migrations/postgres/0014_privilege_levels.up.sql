@@ -0,0 +1,3 @@
+-- privilege_level holds the account's privilege tier (0 player, 1 tester, 2 GM,
+-- 3 admin, 4 root). Accounts that were already GMs start at the GM tier.
+UPDATE accounts SET privilege_level = 2 WHERE is_gm AND privilege_level < 2;
@@ -0,0 +1,23 @@
+-- Log of the trades between characters for auditing the economy. Side is 0 for
+-- the items given by the first character in the trade and 1 for the second.
+CREATE TABLE trades (
+  id         SERIAL PRIMARY KEY,
+  traded_at  TIMESTAMP NOT NULL,
+  guildcard1 BIGINT NOT NULL,
+  slot1      INTEGER NOT NULL,
+  meseta1    BIGINT NOT NULL DEFAULT 0,
+  guildcard2 BIGINT NOT NULL,
+  slot2      INTEGER NOT NULL,
+  meseta2    BIGINT NOT NULL DEFAULT 0
+);
+CREATE INDEX trades_guildcard1 ON trades (guildcard1);
+CREATE INDEX trades_guildcard2 ON trades (guildcard2);
+
+CREATE TABLE trade_items (
+  trade_id BIGINT NOT NULL,
+  side     SMALLINT NOT NULL,
+  position INTEGER NOT NULL,
+  data     BYTEA NOT NULL,
+  data2    BYTEA NOT NULL,
+  PRIMARY KEY (trade_id, side, position)
+);
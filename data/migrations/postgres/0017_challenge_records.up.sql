@@ -0,0 +1,21 @@
+-- Challenge mode progress for each character. Characters that haven't played
+-- challenge mode have no rows.
+CREATE TABLE character_challenges (
+  guildcard   BIGINT NOT NULL,
+  slot        INTEGER NOT NULL,
+  title       VARCHAR(12) NOT NULL,
+  title_color INTEGER NOT NULL,
+  awards_ep1  BIGINT NOT NULL,
+  awards_ep2  BIGINT NOT NULL,
+  PRIMARY KEY (guildcard, slot)
+);
+
+-- Best clear time in seconds for each challenge mode stage a character has cleared.
+CREATE TABLE character_challenge_times (
+  guildcard  BIGINT NOT NULL,
+  slot       INTEGER NOT NULL,
+  episode    SMALLINT NOT NULL,
+  stage      SMALLINT NOT NULL,
+  clear_time BIGINT NOT NULL,
+  PRIMARY KEY (guildcard, slot, episode, stage)
+);
@@ -0,0 +1,18 @@
+-- Battle mode results for each character. Characters that haven't finished a
+-- battle have no row. place1-4 count the battles finished in each place and
+-- disconnects1-4 the battles left early, by the place they were in.
+CREATE TABLE character_battle_records (
+  guildcard    BIGINT NOT NULL,
+  slot         INTEGER NOT NULL,
+  place1       BIGINT NOT NULL DEFAULT 0,
+  place2       BIGINT NOT NULL DEFAULT 0,
+  place3       BIGINT NOT NULL DEFAULT 0,
+  place4       BIGINT NOT NULL DEFAULT 0,
+  disconnects1 BIGINT NOT NULL DEFAULT 0,
+  disconnects2 BIGINT NOT NULL DEFAULT 0,
+  disconnects3 BIGINT NOT NULL DEFAULT 0,
+  disconnects4 BIGINT NOT NULL DEFAULT 0,
+  kills        BIGINT NOT NULL DEFAULT 0,
+  deaths       BIGINT NOT NULL DEFAULT 0,
+  PRIMARY KEY (guildcard, slot)
+);
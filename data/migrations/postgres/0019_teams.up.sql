@@ -0,0 +1,17 @@
+-- Teams that players have formed. Each account in a team has a row in
+-- team_members and the team's id in accounts.team_id.
+CREATE TABLE teams (
+  id         SERIAL PRIMARY KEY,
+  name       BYTEA NOT NULL,
+  flag       BYTEA NOT NULL,
+  created_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE team_members (
+  team_id   INTEGER NOT NULL,
+  guildcard BIGINT NOT NULL PRIMARY KEY,
+  name      BYTEA NOT NULL,
+  privilege SMALLINT NOT NULL,
+  joined_at TIMESTAMP NOT NULL
+);
+CREATE INDEX team_members_team_id ON team_members (team_id);
@@ -0,0 +1,17 @@
+-- Append-only log of every item and amount of meseta that changes hands, for
+-- tracing where a duplicated or stolen item came from. Partner is the other
+-- player in a trade (0 for everything else), and meseta is the meseta that
+-- was paid or received along with the item, if any.
+CREATE TABLE item_audit (
+  id         BIGSERIAL PRIMARY KEY,
+  created_at TIMESTAMP NOT NULL,
+  guildcard  BIGINT NOT NULL,
+  action     VARCHAR(16) NOT NULL,
+  item_id    BIGINT NOT NULL,
+  data       BYTEA NOT NULL,
+  data2      BYTEA NOT NULL,
+  meseta     BIGINT NOT NULL DEFAULT 0,
+  partner    BIGINT NOT NULL DEFAULT 0
+);
+CREATE INDEX item_audit_guildcard ON item_audit (guildcard);
+CREATE INDEX item_audit_data ON item_audit (data);
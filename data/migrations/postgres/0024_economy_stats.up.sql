@@ -0,0 +1,14 @@
+-- Daily totals for the economy reports, one row per day (starting at midnight
+-- UTC). Meseta supply and rare items are what was held in inventories and banks
+-- when the row was last updated, and active players counts the accounts that
+-- logged in that day.
+CREATE TABLE economy_stats (
+  day            TIMESTAMP NOT NULL PRIMARY KEY,
+  meseta_supply  BIGINT NOT NULL DEFAULT 0,
+  rare_items     BIGINT NOT NULL DEFAULT 0,
+  trades         BIGINT NOT NULL DEFAULT 0,
+  trade_meseta   BIGINT NOT NULL DEFAULT 0,
+  active_players BIGINT NOT NULL DEFAULT 0,
+  peak_online    BIGINT NOT NULL DEFAULT 0,
+  updated_at     TIMESTAMP NOT NULL
+);
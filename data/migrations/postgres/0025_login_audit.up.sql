@@ -0,0 +1,17 @@
+-- Log of every attempt to log in to the login servers and how it turned out.
+-- Username is whatever was sent (the serial number for versions before Blue
+-- Burst), whether or not there's an account with it, and guildcard is 0 if the
+-- attempt didn't get as far as finding the account.
+CREATE TABLE login_attempts (
+  id             BIGSERIAL PRIMARY KEY,
+  created_at     TIMESTAMP NOT NULL,
+  username       VARCHAR(48) NOT NULL,
+  guildcard      BIGINT NOT NULL DEFAULT 0,
+  ip             VARCHAR(45) NOT NULL,
+  version        VARCHAR(8) NOT NULL,
+  client_version BIGINT NOT NULL,
+  result         VARCHAR(16) NOT NULL
+);
+CREATE INDEX login_attempts_created_at ON login_attempts (created_at);
+CREATE INDEX login_attempts_username ON login_attempts (username);
+CREATE INDEX login_attempts_ip ON login_attempts (ip);
@@ -0,0 +1,7 @@
+-- name_key is what a character's name is compared by when names have to be
+-- unique, prefixed with the guildcard when they only have to be unique within
+-- the account. It's NULL when names don't have to be unique, and it's moved to
+-- deleted_characters while the character is deleted so that the name is free.
+ALTER TABLE characters ADD COLUMN name_key VARCHAR(64) NULL;
+ALTER TABLE deleted_characters ADD COLUMN name_key VARCHAR(64) NULL;
+CREATE UNIQUE INDEX characters_name_key ON characters (name_key);
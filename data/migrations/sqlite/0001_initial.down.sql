@@ -0,0 +1,4 @@
+DROP TABLE guildcard_entries;
+DROP TABLE characters;
+DROP TABLE player_options;
+DROP TABLE accounts;
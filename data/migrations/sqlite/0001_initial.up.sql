@@ -0,0 +1,69 @@
+CREATE TABLE accounts (
+  guildcard         INTEGER PRIMARY KEY AUTOINCREMENT,
+  username          TEXT NOT NULL UNIQUE,
+  password          TEXT NOT NULL,
+  email             TEXT NOT NULL DEFAULT '',
+  registration_date DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  is_gm             BOOLEAN NOT NULL DEFAULT 0,
+  banned            BOOLEAN NOT NULL DEFAULT 0,
+  active            BOOLEAN NOT NULL DEFAULT 1,
+  team_id           INTEGER NOT NULL DEFAULT 0,
+  privilege_level   INTEGER NOT NULL DEFAULT 0
+);
+-- Start guildcard numbers at the same point as the other backends.
+INSERT INTO sqlite_sequence (name, seq) VALUES ('accounts', 9999999);
+
+CREATE TABLE player_options (
+  guildcard  INTEGER NOT NULL PRIMARY KEY,
+  key_config BLOB NOT NULL
+);
+
+CREATE TABLE characters (
+  guildcard           INTEGER NOT NULL,
+  slot                INTEGER NOT NULL,
+  guildcard_str       BLOB NOT NULL,
+  experience          INTEGER NOT NULL DEFAULT 0,
+  level               INTEGER NOT NULL DEFAULT 0,
+  name_color          INTEGER NOT NULL DEFAULT 0,
+  model               INTEGER NOT NULL DEFAULT 0,
+  name_color_checksum INTEGER NOT NULL DEFAULT 0,
+  section_id          INTEGER NOT NULL DEFAULT 0,
+  class               INTEGER NOT NULL DEFAULT 0,
+  v2_flags            INTEGER NOT NULL DEFAULT 0,
+  version             INTEGER NOT NULL DEFAULT 0,
+  v1_flags            INTEGER NOT NULL DEFAULT 0,
+  costume             INTEGER NOT NULL DEFAULT 0,
+  skin                INTEGER NOT NULL DEFAULT 0,
+  face                INTEGER NOT NULL DEFAULT 0,
+  head                INTEGER NOT NULL DEFAULT 0,
+  hair                INTEGER NOT NULL DEFAULT 0,
+  hair_red            INTEGER NOT NULL DEFAULT 0,
+  hair_green          INTEGER NOT NULL DEFAULT 0,
+  hair_blue           INTEGER NOT NULL DEFAULT 0,
+  proportion_x        REAL NOT NULL DEFAULT 0,
+  proportion_y        REAL NOT NULL DEFAULT 0,
+  name                BLOB NOT NULL,
+  playtime            INTEGER NOT NULL DEFAULT 0,
+  atp                 INTEGER NOT NULL DEFAULT 0,
+  mst                 INTEGER NOT NULL DEFAULT 0,
+  evp                 INTEGER NOT NULL DEFAULT 0,
+  hp                  INTEGER NOT NULL DEFAULT 0,
+  dfp                 INTEGER NOT NULL DEFAULT 0,
+  ata                 INTEGER NOT NULL DEFAULT 0,
+  lck                 INTEGER NOT NULL DEFAULT 0,
+  meseta              INTEGER NOT NULL DEFAULT 0,
+  PRIMARY KEY (guildcard, slot)
+);
+
+CREATE TABLE guildcard_entries (
+  guildcard        INTEGER NOT NULL,
+  friend_guildcard INTEGER NOT NULL,
+  name             BLOB NOT NULL,
+  team_name        BLOB NOT NULL,
+  description      BLOB NOT NULL,
+  language         INTEGER NOT NULL DEFAULT 0,
+  section_id       INTEGER NOT NULL DEFAULT 0,
+  class            INTEGER NOT NULL DEFAULT 0,
+  comment          BLOB NOT NULL,
+  PRIMARY KEY (guildcard, friend_guildcard)
+);
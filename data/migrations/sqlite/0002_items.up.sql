@@ -0,0 +1,13 @@
+-- Items owned by a character. Location distinguishes the inventory from the
+-- bank, and position is the item's index within that location.
+CREATE TABLE character_items (
+  guildcard INTEGER NOT NULL,
+  slot      INTEGER NOT NULL,
+  location  INTEGER NOT NULL,
+  position  INTEGER NOT NULL,
+  item_id   INTEGER NOT NULL,
+  data      BLOB NOT NULL,
+  data2     BLOB NOT NULL,
+  flags     INTEGER NOT NULL DEFAULT 0,
+  PRIMARY KEY (guildcard, slot, location, position)
+);
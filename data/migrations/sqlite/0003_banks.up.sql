@@ -0,0 +1,9 @@
+-- Meseta stored in each bank. The items are kept in character_items with the
+-- bank location. Slot 255 holds the account-wide bank used when shared banks
+-- are enabled.
+CREATE TABLE banks (
+  guildcard INTEGER NOT NULL,
+  slot      INTEGER NOT NULL,
+  meseta    INTEGER NOT NULL DEFAULT 0,
+  PRIMARY KEY (guildcard, slot)
+);
@@ -0,0 +1 @@
+DROP TABLE character_techniques;
@@ -0,0 +1,9 @@
+-- Techniques learned by each character. Level is the level shown in game
+-- (starting at 1); techniques that haven't been learned have no row.
+CREATE TABLE character_techniques (
+  guildcard INTEGER NOT NULL,
+  slot      INTEGER NOT NULL,
+  technique INTEGER NOT NULL,
+  level     INTEGER NOT NULL,
+  PRIMARY KEY (guildcard, slot, technique)
+);
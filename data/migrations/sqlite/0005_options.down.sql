@@ -0,0 +1,4 @@
+UPDATE player_options SET key_config = CAST(key_config || joystick_config AS BLOB);
+ALTER TABLE player_options DROP COLUMN version;
+ALTER TABLE player_options DROP COLUMN option_flags;
+ALTER TABLE player_options DROP COLUMN joystick_config;
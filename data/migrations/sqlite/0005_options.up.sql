@@ -0,0 +1,7 @@
+-- Split the joystick config out of key_config (which held both) and add the
+-- remaining option fields. version is the version of the server's default
+-- options that the row was created from.
+ALTER TABLE player_options ADD COLUMN joystick_config BLOB NOT NULL DEFAULT x'';
+ALTER TABLE player_options ADD COLUMN option_flags INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE player_options ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+UPDATE player_options SET joystick_config = substr(key_config, 365), key_config = substr(key_config, 1, 364);
@@ -0,0 +1,6 @@
+-- Deleted characters can't be restored without this table, so purge them.
+DELETE FROM characters WHERE slot >= 256;
+DELETE FROM character_items WHERE slot >= 256;
+DELETE FROM character_techniques WHERE slot >= 256;
+DELETE FROM banks WHERE slot >= 256;
+DROP TABLE deleted_characters;
@@ -0,0 +1,10 @@
+-- Characters that have been deleted but can still be restored. Their rows in the
+-- other character tables are moved from original_slot to deleted_slot (which is
+-- always at least 256) so that the slot can be reused in the meantime.
+CREATE TABLE deleted_characters (
+  guildcard     INTEGER NOT NULL,
+  deleted_slot  INTEGER NOT NULL,
+  original_slot INTEGER NOT NULL,
+  deleted_at    DATETIME NOT NULL,
+  PRIMARY KEY (guildcard, deleted_slot)
+);
@@ -0,0 +1,13 @@
+-- Players on an account's blocked list, along with the guildcard details that
+-- the client shows for them.
+CREATE TABLE blocked_guildcards (
+  guildcard         INTEGER NOT NULL,
+  blocked_guildcard INTEGER NOT NULL,
+  name              BLOB NOT NULL,
+  team_name         BLOB NOT NULL,
+  description       BLOB NOT NULL,
+  language          INTEGER NOT NULL DEFAULT 0,
+  section_id        INTEGER NOT NULL DEFAULT 0,
+  class             INTEGER NOT NULL DEFAULT 0,
+  PRIMARY KEY (guildcard, blocked_guildcard)
+);
@@ -0,0 +1,10 @@
+-- Simple mail sent to players that weren't online, delivered when they next log in.
+CREATE TABLE mail (
+  id          INTEGER PRIMARY KEY AUTOINCREMENT,
+  recipient   INTEGER NOT NULL,
+  sender      INTEGER NOT NULL,
+  sender_name BLOB NOT NULL,
+  sent_at     DATETIME NOT NULL,
+  message     BLOB NOT NULL
+);
+CREATE INDEX mail_recipient ON mail (recipient);
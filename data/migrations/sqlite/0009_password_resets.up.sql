@@ -0,0 +1,7 @@
+-- Tokens emailed to players to reset their password. Only a hash of each token is stored.
+CREATE TABLE password_resets (
+  token_hash TEXT NOT NULL PRIMARY KEY,
+  username   TEXT NOT NULL,
+  expires_at DATETIME NOT NULL
+);
+CREATE INDEX password_resets_username ON password_resets (username);
@@ -0,0 +1,23 @@
+-- Bans by guildcard, account, IP address, or hardware serial, along with a log of who
+-- issued or lifted each one and why.
+CREATE TABLE bans (
+  id         INTEGER PRIMARY KEY AUTOINCREMENT,
+  ban_type   TEXT NOT NULL,
+  target     TEXT NOT NULL,
+  reason     TEXT NOT NULL DEFAULT '',
+  issued_by  TEXT NOT NULL,
+  issued_at  DATETIME NOT NULL,
+  expires_at DATETIME NULL,
+  lifted     BOOLEAN NOT NULL DEFAULT 0
+);
+CREATE INDEX bans_target ON bans (ban_type, target);
+
+CREATE TABLE ban_audit (
+  id         INTEGER PRIMARY KEY AUTOINCREMENT,
+  ban_id     INTEGER NOT NULL,
+  action     TEXT NOT NULL,
+  actor      TEXT NOT NULL,
+  reason     TEXT NOT NULL DEFAULT '',
+  created_at DATETIME NOT NULL
+);
+CREATE INDEX ban_audit_ban_id ON ban_audit (ban_id);
@@ -0,0 +1,8 @@
+-- Session tokens issued to players when they log in and checked when they connect
+-- to the character and ship servers. Only a hash of each token is stored.
+CREATE TABLE sessions (
+  token_hash TEXT NOT NULL PRIMARY KEY,
+  guildcard  INTEGER NOT NULL,
+  expires_at DATETIME NOT NULL
+);
+CREATE INDEX sessions_guildcard ON sessions (guildcard);
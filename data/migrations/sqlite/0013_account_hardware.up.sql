@@ -0,0 +1,11 @@
+-- Machines (by the hardware info the client sends at login) that each account
+-- has logged in from, for hardware bans and spotting players with several accounts.
+CREATE TABLE account_hardware (
+  username    TEXT NOT NULL,
+  hardware_id TEXT NOT NULL,
+  first_seen  DATETIME NOT NULL,
+  last_seen   DATETIME NOT NULL,
+  last_ip     TEXT NOT NULL,
+  PRIMARY KEY (username, hardware_id)
+);
+CREATE INDEX account_hardware_hardware_id ON account_hardware (hardware_id);
@@ -0,0 +1,9 @@
+-- Flags set by quests on each character, such as which quests have been
+-- cleared. Flags that aren't set have no row.
+CREATE TABLE character_quest_flags (
+  guildcard  INTEGER NOT NULL,
+  slot       INTEGER NOT NULL,
+  difficulty INTEGER NOT NULL,
+  flag       INTEGER NOT NULL,
+  PRIMARY KEY (guildcard, slot, difficulty, flag)
+);
@@ -0,0 +1,23 @@
+-- Log of the trades between characters for auditing the economy. Side is 0 for
+-- the items given by the first character in the trade and 1 for the second.
+CREATE TABLE trades (
+  id         INTEGER PRIMARY KEY AUTOINCREMENT,
+  traded_at  DATETIME NOT NULL,
+  guildcard1 INTEGER NOT NULL,
+  slot1      INTEGER NOT NULL,
+  meseta1    INTEGER NOT NULL DEFAULT 0,
+  guildcard2 INTEGER NOT NULL,
+  slot2      INTEGER NOT NULL,
+  meseta2    INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX trades_guildcard1 ON trades (guildcard1);
+CREATE INDEX trades_guildcard2 ON trades (guildcard2);
+
+CREATE TABLE trade_items (
+  trade_id INTEGER NOT NULL,
+  side     INTEGER NOT NULL,
+  position INTEGER NOT NULL,
+  data     BLOB NOT NULL,
+  data2    BLOB NOT NULL,
+  PRIMARY KEY (trade_id, side, position)
+);
@@ -0,0 +1,2 @@
+DROP TABLE character_challenge_times;
+DROP TABLE character_challenges;
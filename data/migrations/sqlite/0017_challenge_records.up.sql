@@ -0,0 +1,21 @@
+-- Challenge mode progress for each character. Characters that haven't played
+-- challenge mode have no rows.
+CREATE TABLE character_challenges (
+  guildcard   INTEGER NOT NULL,
+  slot        INTEGER NOT NULL,
+  title       TEXT NOT NULL,
+  title_color INTEGER NOT NULL,
+  awards_ep1  INTEGER NOT NULL,
+  awards_ep2  INTEGER NOT NULL,
+  PRIMARY KEY (guildcard, slot)
+);
+
+-- Best clear time in seconds for each challenge mode stage a character has cleared.
+CREATE TABLE character_challenge_times (
+  guildcard  INTEGER NOT NULL,
+  slot       INTEGER NOT NULL,
+  episode    INTEGER NOT NULL,
+  stage      INTEGER NOT NULL,
+  clear_time INTEGER NOT NULL,
+  PRIMARY KEY (guildcard, slot, episode, stage)
+);
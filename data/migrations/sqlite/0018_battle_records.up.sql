@@ -0,0 +1,18 @@
+-- Battle mode results for each character. Characters that haven't finished a
+-- battle have no row. place1-4 count the battles finished in each place and
+-- disconnects1-4 the battles left early, by the place they were in.
+CREATE TABLE character_battle_records (
+  guildcard    INTEGER NOT NULL,
+  slot         INTEGER NOT NULL,
+  place1       INTEGER NOT NULL DEFAULT 0,
+  place2       INTEGER NOT NULL DEFAULT 0,
+  place3       INTEGER NOT NULL DEFAULT 0,
+  place4       INTEGER NOT NULL DEFAULT 0,
+  disconnects1 INTEGER NOT NULL DEFAULT 0,
+  disconnects2 INTEGER NOT NULL DEFAULT 0,
+  disconnects3 INTEGER NOT NULL DEFAULT 0,
+  disconnects4 INTEGER NOT NULL DEFAULT 0,
+  kills        INTEGER NOT NULL DEFAULT 0,
+  deaths       INTEGER NOT NULL DEFAULT 0,
+  PRIMARY KEY (guildcard, slot)
+);
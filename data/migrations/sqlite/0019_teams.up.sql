@@ -0,0 +1,17 @@
+-- Teams that players have formed. Each account in a team has a row in
+-- team_members and the team's id in accounts.team_id.
+CREATE TABLE teams (
+  id         INTEGER PRIMARY KEY AUTOINCREMENT,
+  name       BLOB NOT NULL,
+  flag       BLOB NOT NULL,
+  created_at DATETIME NOT NULL
+);
+
+CREATE TABLE team_members (
+  team_id   INTEGER NOT NULL,
+  guildcard INTEGER NOT NULL PRIMARY KEY,
+  name      BLOB NOT NULL,
+  privilege INTEGER NOT NULL,
+  joined_at DATETIME NOT NULL
+);
+CREATE INDEX team_members_team_id ON team_members (team_id);
@@ -0,0 +1,2 @@
+ALTER TABLE accounts DROP COLUMN shadow_muted;
+ALTER TABLE accounts DROP COLUMN muted_until;
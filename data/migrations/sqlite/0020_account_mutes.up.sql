@@ -0,0 +1,4 @@
+-- muted_until is when a muted account can chat again (NULL if it's never been
+-- muted), and shadow_muted hides the mute from the player.
+ALTER TABLE accounts ADD COLUMN muted_until DATETIME NULL;
+ALTER TABLE accounts ADD COLUMN shadow_muted BOOLEAN NOT NULL DEFAULT 0;
@@ -0,0 +1,12 @@
+-- Copies of characters taken when their players log off, kept so that a character
+-- can be rolled back after it has been corrupted or stolen from. Contents holds
+-- the JSON encoded character, inventory, techniques, and bank.
+CREATE TABLE character_snapshots (
+  id         INTEGER PRIMARY KEY AUTOINCREMENT,
+  guildcard  INTEGER NOT NULL,
+  slot       INTEGER NOT NULL,
+  created_at DATETIME NOT NULL,
+  contents   BLOB NOT NULL
+);
+CREATE INDEX character_snapshots_character ON character_snapshots (guildcard, slot);
+CREATE INDEX character_snapshots_created_at ON character_snapshots (created_at);
@@ -0,0 +1 @@
+DROP TABLE cheat_flags;
@@ -0,0 +1,10 @@
+-- Players caught breaking one of the anti-cheat rules, kept for the GMs to look
+-- into. Details describes what the player's client sent.
+CREATE TABLE cheat_flags (
+  id         INTEGER PRIMARY KEY AUTOINCREMENT,
+  guildcard  INTEGER NOT NULL,
+  rule       TEXT NOT NULL,
+  details    TEXT NOT NULL,
+  created_at DATETIME NOT NULL
+);
+CREATE INDEX cheat_flags_guildcard ON cheat_flags (guildcard);
@@ -0,0 +1,17 @@
+-- Append-only log of every item and amount of meseta that changes hands, for
+-- tracing where a duplicated or stolen item came from. Partner is the other
+-- player in a trade (0 for everything else), and meseta is the meseta that
+-- was paid or received along with the item, if any.
+CREATE TABLE item_audit (
+  id         INTEGER PRIMARY KEY AUTOINCREMENT,
+  created_at DATETIME NOT NULL,
+  guildcard  INTEGER NOT NULL,
+  action     TEXT NOT NULL,
+  item_id    INTEGER NOT NULL,
+  data       BLOB NOT NULL,
+  data2      BLOB NOT NULL,
+  meseta     INTEGER NOT NULL DEFAULT 0,
+  partner    INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX item_audit_guildcard ON item_audit (guildcard);
+CREATE INDEX item_audit_data ON item_audit (data);
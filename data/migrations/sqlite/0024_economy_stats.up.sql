@@ -0,0 +1,14 @@
+-- Daily totals for the economy reports, one row per day (starting at midnight
+-- UTC). Meseta supply and rare items are what was held in inventories and banks
+-- when the row was last updated, and active players counts the accounts that
+-- logged in that day.
+CREATE TABLE economy_stats (
+  day            DATETIME NOT NULL PRIMARY KEY,
+  meseta_supply  INTEGER NOT NULL DEFAULT 0,
+  rare_items     INTEGER NOT NULL DEFAULT 0,
+  trades         INTEGER NOT NULL DEFAULT 0,
+  trade_meseta   INTEGER NOT NULL DEFAULT 0,
+  active_players INTEGER NOT NULL DEFAULT 0,
+  peak_online    INTEGER NOT NULL DEFAULT 0,
+  updated_at     DATETIME NOT NULL
+);
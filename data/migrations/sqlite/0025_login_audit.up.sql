@@ -0,0 +1,17 @@
+-- Log of every attempt to log in to the login servers and how it turned out.
+-- Username is whatever was sent (the serial number for versions before Blue
+-- Burst), whether or not there's an account with it, and guildcard is 0 if the
+-- attempt didn't get as far as finding the account.
+CREATE TABLE login_attempts (
+  id             INTEGER PRIMARY KEY AUTOINCREMENT,
+  created_at     DATETIME NOT NULL,
+  username       TEXT NOT NULL,
+  guildcard      INTEGER NOT NULL DEFAULT 0,
+  ip             TEXT NOT NULL,
+  version        TEXT NOT NULL,
+  client_version INTEGER NOT NULL,
+  result         TEXT NOT NULL
+);
+CREATE INDEX login_attempts_created_at ON login_attempts (created_at);
+CREATE INDEX login_attempts_username ON login_attempts (username);
+CREATE INDEX login_attempts_ip ON login_attempts (ip);
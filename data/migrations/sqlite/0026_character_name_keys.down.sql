@@ -0,0 +1,3 @@
+DROP INDEX characters_name_key;
+ALTER TABLE deleted_characters DROP COLUMN name_key;
+ALTER TABLE characters DROP COLUMN name_key;
@@ -0,0 +1,9 @@
+-- Accounts created by "archon import", by the server they came from and their
+-- guildcard there, so that an import that's run again carries on with the
+-- accounts that an earlier run created rather than skipping them.
+CREATE TABLE imported_accounts (
+  source           TEXT NOT NULL,
+  source_guildcard INTEGER NOT NULL,
+  guildcard        INTEGER NOT NULL,
+  PRIMARY KEY (source, source_guildcard)
+);
@@ -0,0 +1,5 @@
+-- The flag can only say that an account is banned for good, so it's set for
+-- the accounts with a permanent ban. The bans themselves are left in place.
+ALTER TABLE accounts ADD COLUMN banned BOOLEAN NOT NULL DEFAULT 0;
+UPDATE accounts SET banned = 1 WHERE username IN (
+  SELECT target FROM bans WHERE ban_type = 'account' AND lifted = 0 AND expires_at IS NULL);
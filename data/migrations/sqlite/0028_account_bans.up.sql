@@ -0,0 +1,9 @@
+-- Accounts are banned in the bans table like everything else rather than with a
+-- flag of their own, so each account that was banned with the flag gets a
+-- permanent account ban in its place.
+INSERT INTO bans (ban_type, target, reason, issued_by, issued_at, expires_at, lifted)
+  SELECT 'account', username, '', 'migration', CURRENT_TIMESTAMP, NULL, 0
+  FROM accounts WHERE banned;
+INSERT INTO ban_audit (ban_id, action, actor, reason, created_at)
+  SELECT id, 'issued', 'migration', '', issued_at FROM bans WHERE issued_by = 'migration';
+ALTER TABLE accounts DROP COLUMN banned;
@@ -0,0 +1,537 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Account contains the login information specific to each registered user.
+type Account struct {
+	Username         string    `json:"username"`
+	Password         string    `json:"password"`
+	Email            string    `json:"email"`
+	RegistrationDate time.Time `json:"registration_date"`
+	Guildcard        int       `json:"guildcard"`
+	GM               bool      `json:"is_gm"`
+	Active           bool      `json:"active"`
+	TeamID           int       `json:"team_id"`
+	// One of the Privilege tiers below. GM is kept in step with it.
+	PrivilegeLevel byte `json:"privilege_level"`
+	// The account's chat is held back until MutedUntil (zero if it's never been
+	// muted). A shadow mute shows the player their own messages as if they'd been
+	// sent so that they don't notice.
+	MutedUntil  time.Time `json:"muted_until"`
+	ShadowMuted bool      `json:"shadow_muted"`
+}
+
+// Privilege tiers for accounts. Each tier has all of the privileges of the ones
+// below it.
+const (
+	PrivilegePlayer byte = iota
+	PrivilegeTester
+	PrivilegeGM
+	PrivilegeAdmin
+	PrivilegeRoot
+)
+
+var (
+	// ErrAccountNotFound is returned when updating an account that doesn't exist.
+	ErrAccountNotFound = errors.New("data: account not found")
+	// ErrAccountExists is returned when registering a username that's taken.
+	ErrAccountExists = errors.New("data: username is already registered")
+	// ErrEmailInUse is returned when registering an email that's already in use.
+	ErrEmailInUse = errors.New("data: email is already registered")
+)
+
+// TwoFactor is an account's secret for TOTP two-factor authentication.
+type TwoFactor struct {
+	Username string `json:"username"`
+	// Base32 encoded, as it's given to authenticator apps.
+	Secret string `json:"secret"`
+	// Set once the player has confirmed the secret with a code; until then
+	// the account logs in without one.
+	Enabled bool `json:"enabled"`
+	// Time step of the last code that was accepted, so that codes can't be reused.
+	LastStep int64 `json:"last_step"`
+}
+
+// ErrTwoFactorStepUsed is returned when saving a code's step after a code from
+// the same or a later step has already been accepted.
+var ErrTwoFactorStepUsed = errors.New("data: two-factor code already used")
+
+// AccountHardware records a machine that an account has logged in from.
+type AccountHardware struct {
+	Username   string    `json:"username"`
+	HardwareId string    `json:"hardware_id"`
+	FirstSeen  time.Time `json:"first_seen"`
+	LastSeen   time.Time `json:"last_seen"`
+	// Address of the account's last login from the machine.
+	LastIP string `json:"last_ip"`
+}
+
+// Actions recorded in the item audit log.
+const (
+	// Dropped by an enemy or box that the player defeated or broke.
+	ItemAuditCreated   = "created"
+	ItemAuditPickedUp  = "picked_up"
+	ItemAuditDropped   = "dropped"
+	ItemAuditDestroyed = "destroyed"
+	ItemAuditBought    = "bought"
+	ItemAuditSold      = "sold"
+	ItemAuditDeposited = "deposited"
+	ItemAuditWithdrawn = "withdrawn"
+	// Given to or received from the partner in a trade.
+	ItemAuditGave     = "gave"
+	ItemAuditReceived = "received"
+	// Paid the tekker to appraise, and then turned into what the tekker said.
+	ItemAuditAppraised  = "appraised"
+	ItemAuditIdentified = "identified"
+	// Won for clearing challenge mode stages.
+	ItemAuditPrize = "prize"
+)
+
+// ItemAuditEntry records something a player did with an item or meseta.
+type ItemAuditEntry struct {
+	Id        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Guildcard uint32    `json:"guildcard"`
+	Action    string    `json:"action"`
+	// Empty (but for ItemId) when only meseta changed hands. Stacks hold only as
+	// many of the items as were involved.
+	Item Item `json:"item"`
+	// Meseta paid or received, including the amount of a meseta drop.
+	Meseta uint32 `json:"meseta"`
+	// Other player in a trade, or 0.
+	Partner uint32 `json:"partner"`
+}
+
+// Results recorded in the login audit log.
+const (
+	LoginSucceeded = "success"
+	// The account exists but the password (or access key) was wrong.
+	LoginBadPassword = "bad_password"
+	// There's no account with the username (or serial number).
+	LoginUnknownAccount = "unknown_account"
+	LoginBadTwoFactor   = "bad_two_factor"
+	LoginInactive       = "inactive"
+	LoginBanned         = "banned"
+	LoginMaintenance    = "maintenance"
+	LoginSessionLimit   = "session_limit"
+	// The client's version isn't one of the ones the server accepts.
+	LoginVersionMismatch = "version_mismatch"
+	// The attempt couldn't be checked, usually because of a database error.
+	LoginError = "error"
+)
+
+// LoginAttempt records an attempt to log in to one of the login servers.
+type LoginAttempt struct {
+	Id        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	// Sent by the client, whether or not there's an account with it. Versions
+	// before Blue Burst send their serial number.
+	Username string `json:"username"`
+	// Guildcard of the account, or 0 if the attempt didn't get as far as
+	// finding it.
+	Guildcard uint32 `json:"guildcard"`
+	IP        string `json:"ip"`
+	// Version of PSO (bb, pc, or gc) and the version number reported by
+	// the client.
+	Version       string `json:"version"`
+	ClientVersion uint32 `json:"client_version"`
+	Result        string `json:"result"`
+}
+
+// CheatFlag records a player who was caught breaking one of the anti-cheat rules.
+type CheatFlag struct {
+	Id        int64  `json:"id"`
+	Guildcard uint32 `json:"guildcard"`
+	Rule      string `json:"rule"`
+	// What the player's client sent that broke the rule.
+	Details   string    `json:"details"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// EconomyStats holds the totals for one day of the economy reports.
+type EconomyStats struct {
+	// Midnight UTC at the start of the day.
+	Day time.Time `json:"day"`
+	// Meseta and rare items held in inventories and banks.
+	MesetaSupply uint64 `json:"meseta_supply"`
+	RareItems    int    `json:"rare_items"`
+	// Trades made that day and the meseta that changed hands in them.
+	Trades      int    `json:"trades"`
+	TradeMeseta uint64 `json:"trade_meseta"`
+	// Accounts that logged in that day, and the most players online at once.
+	ActivePlayers int       `json:"active_players"`
+	PeakOnline    int       `json:"peak_online"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// Kinds of targets that can be banned.
+const (
+	BanGuildcard = "guildcard"
+	BanAccount   = "account"
+	BanIP        = "ip"
+	BanHardware  = "hardware"
+)
+
+// Actions recorded in the ban audit log.
+const (
+	BanActionIssued = "issued"
+	BanActionLifted = "lifted"
+)
+
+// ErrBanNotFound is returned when lifting a ban that doesn't exist or was already lifted.
+var ErrBanNotFound = errors.New("data: ban not found")
+
+// BanTarget identifies something that can be banned.
+type BanTarget struct {
+	Type string `json:"type"`
+	// Guildcard number, username, IP address, or hardware serial depending on Type.
+	Target string `json:"target"`
+}
+
+// Ban keeps anyone matching the target from logging in until it expires or is lifted.
+type Ban struct {
+	BanTarget
+	Id       int64     `json:"id"`
+	Reason   string    `json:"reason"`
+	IssuedBy string    `json:"issued_by"`
+	IssuedAt time.Time `json:"issued_at"`
+	// Zero for permanent bans.
+	ExpiresAt time.Time `json:"expires_at"`
+	Lifted    bool      `json:"lifted"`
+}
+
+// Permanent returns whether the ban lasts until it's lifted.
+func (b *Ban) Permanent() bool {
+	return b.ExpiresAt.IsZero()
+}
+
+// BanAuditEntry records who issued or lifted a ban and why.
+type BanAuditEntry struct {
+	Id        int64     `json:"id"`
+	BanId     int64     `json:"ban_id"`
+	Action    string    `json:"action"`
+	Actor     string    `json:"actor"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Sizes of the config sections saved in PlayerOptions.
+const (
+	KeyConfigSize      = 0x16C
+	JoystickConfigSize = 0x38
+)
+
+// ErrInvalidOptions is returned when attempting to save malformed options.
+var ErrInvalidOptions = errors.New("data: invalid player options")
+
+// PlayerOptions holds the key config and other settings shared by all of the
+// characters on an account.
+type PlayerOptions struct {
+	Guildcard uint32 `json:"guildcard"`
+	// Version of the server's default options that these were created from.
+	Version        int    `json:"version"`
+	KeyConfig      []byte `json:"key_config"`
+	JoystickConfig []byte `json:"joystick_config"`
+	OptionFlags    uint32 `json:"option_flags"`
+}
+
+// Validate checks that each of the config sections is the expected size.
+func (o *PlayerOptions) Validate() error {
+	if len(o.KeyConfig) != KeyConfigSize || len(o.JoystickConfig) != JoystickConfigSize {
+		return ErrInvalidOptions
+	}
+	return nil
+}
+
+// Character is an instance of a character in one of the slots for an account.
+type Character struct {
+	Guildcard         int     `json:"guildcard"`
+	GuildcardStr      []byte  `json:"guildcard_str"`
+	Slot              uint32  `json:"slot"`
+	Experience        uint32  `json:"experience"`
+	Level             uint32  `json:"level"`
+	NameColor         uint32  `json:"name_color"`
+	Model             byte    `json:"model"`
+	NameColorChecksum uint32  `json:"name_color_checksum"`
+	SectionID         byte    `json:"section_id"`
+	Class             byte    `json:"class"`
+	V2Flags           byte    `json:"v2_flags"`
+	Version           byte    `json:"version"`
+	V1Flags           uint32  `json:"v1_flags"`
+	Costume           uint16  `json:"costume"`
+	Skin              uint16  `json:"skin"`
+	Face              uint16  `json:"face"`
+	Head              uint16  `json:"head"`
+	Hair              uint16  `json:"hair"`
+	HairRed           uint16  `json:"hair_red"`
+	HairGreen         uint16  `json:"hair_green"`
+	HairBlue          uint16  `json:"hair_blue"`
+	ProportionX       float32 `json:"proportion_x"`
+	ProportionY       float32 `json:"proportion_y"`
+	Name              []uint8 `json:"name"`
+	Playtime          uint32  `json:"playtime"`
+	ATP               uint16  `json:"atp"`
+	MST               uint16  `json:"mst"`
+	EVP               uint16  `json:"evp"`
+	HP                uint16  `json:"hp"`
+	DFP               uint16  `json:"dfp"`
+	ATA               uint16  `json:"ata"`
+	LCK               uint16  `json:"lck"`
+	Meseta            uint32  `json:"meseta"`
+	// What the name is compared by when names have to be unique, or empty if
+	// they don't. Every write that can change the name (CreateCharacter,
+	// UpdateAppearance, and ImportCharacter) writes it too; RestoreSnapshot
+	// takes it separately. It isn't read back by FindCharacter.
+	NameKey string `json:"-"`
+}
+
+// UnmarshalJSON also accepts heair_green for HairGreen, which is what the key
+// was called in the snapshots taken before it was fixed.
+func (c *Character) UnmarshalJSON(b []byte) error {
+	type character Character
+	var decoded struct {
+		*character
+		OldHairGreen *uint16 `json:"heair_green"`
+	}
+	decoded.character = (*character)(c)
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		return err
+	}
+	if decoded.OldHairGreen != nil {
+		c.HairGreen = *decoded.OldHairGreen
+	}
+	return nil
+}
+
+// Slots from this one up hold the data of deleted characters until they're
+// restored or purged.
+const FirstDeletedSlot = 0x100
+
+var (
+	// ErrCharacterNotFound is returned when restoring a character that doesn't exist.
+	ErrCharacterNotFound = errors.New("data: character not found")
+	// ErrSlotInUse is returned when restoring a character into an occupied slot.
+	ErrSlotInUse = errors.New("data: character slot is in use")
+	// ErrCharacterNameTaken is returned when a character's name key is already
+	// taken by another character.
+	ErrCharacterNameTaken = errors.New("data: character name taken")
+	// ErrSnapshotNotFound is returned when restoring a snapshot that doesn't exist.
+	ErrSnapshotNotFound = errors.New("data: snapshot not found")
+)
+
+// DeletedCharacter is a character that has been deleted but can still be restored.
+type DeletedCharacter struct {
+	// Identifies the character until it's restored or purged.
+	Id uint32 `json:"id"`
+	// Slot the character was in when it was deleted.
+	Slot      uint32     `json:"slot"`
+	DeletedAt time.Time  `json:"deleted_at"`
+	Character *Character `json:"character"`
+}
+
+// CharacterName identifies a character by its name.
+type CharacterName struct {
+	Guildcard uint32
+	Slot      uint32
+	// As it's stored in Character.Name.
+	Name []byte
+	// The character's name key, or empty if it doesn't have one.
+	Key string
+}
+
+// CharacterSnapshot is a copy of a character's data taken when the player logged
+// off, which the character can be rolled back to.
+type CharacterSnapshot struct {
+	Id         int64      `json:"id"`
+	Guildcard  uint32     `json:"guildcard"`
+	Slot       uint32     `json:"slot"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Character  *Character `json:"character"`
+	Inventory  []Item     `json:"inventory"`
+	Techniques Techniques `json:"techniques"`
+	// Slot that the bank is kept under, which is SharedBankSlot if the ship
+	// shares banks between an account's characters.
+	BankSlot uint32 `json:"bank_slot"`
+	Bank     *Bank  `json:"bank"`
+}
+
+// Locations in which a character's items can be stored.
+const (
+	ItemLocationInventory = 0
+	ItemLocationBank      = 1
+)
+
+// Item is an instance of an item owned by a character.
+type Item struct {
+	ItemId uint32 `json:"item_id"`
+	// Item type, subtype, and attributes (12 bytes).
+	Data []byte `json:"data"`
+	// Additional attributes such as a mag's stats (4 bytes).
+	Data2 []byte `json:"data2"`
+	// Whether or not the item is equipped, among other things.
+	Flags uint32 `json:"flags"`
+}
+
+// Character slot under which the account-wide bank is stored, whether it's used in
+// place of the characters' banks or alongside them as the common bank.
+const SharedBankSlot = 0xFF
+
+// Bank holds the meseta and items a character has deposited.
+type Bank struct {
+	Meseta uint32 `json:"meseta"`
+	Items  []Item `json:"items"`
+}
+
+// Number of techniques a character can learn.
+const NumTechniques = 19
+
+// Techniques holds the level of each technique a character has learned,
+// indexed by technique id. Techniques that haven't been learned are 0.
+type Techniques [NumTechniques]byte
+
+// Number of quest flags a character has for each difficulty.
+const NumQuestFlags = 0x400
+
+// QuestFlags holds the flags that quests have set on a character, one bit per
+// flag for each difficulty, laid out the way the client expects them.
+type QuestFlags [4][NumQuestFlags / 8]byte
+
+// IsSet returns whether flag is set for the difficulty.
+func (f *QuestFlags) IsSet(difficulty byte, flag uint16) bool {
+	return f[difficulty][flag/8]&(0x80>>(flag%8)) != 0
+}
+
+// Set sets or clears flag for the difficulty.
+func (f *QuestFlags) Set(difficulty byte, flag uint16, set bool) {
+	if set {
+		f[difficulty][flag/8] |= 0x80 >> (flag % 8)
+	} else {
+		f[difficulty][flag/8] &^= 0x80 >> (flag % 8)
+	}
+}
+
+// Number of challenge mode stages in Episodes 1 and 2, which are the only ones
+// with challenge mode.
+const (
+	NumChallengeStagesEp1 = 9
+	NumChallengeStagesEp2 = 5
+)
+
+// ChallengeRecord holds a character's progress in challenge mode.
+type ChallengeRecord struct {
+	// Best clear time for each stage in seconds, or 0 if it hasn't been cleared.
+	TimesEp1 [NumChallengeStagesEp1]uint32 `json:"times_ep1"`
+	TimesEp2 [NumChallengeStagesEp2]uint32 `json:"times_ep2"`
+	// Rank title shown on the character and its color (in the client's RGB555).
+	Title      string `json:"title"`
+	TitleColor uint16 `json:"title_color"`
+	// Bits set for the prizes awarded in each episode, indexed by episode - 1.
+	Awards [2]uint32 `json:"awards"`
+}
+
+// Times returns the best clear times for an episode (1 or 2), or nil for
+// episodes without challenge mode.
+func (r *ChallengeRecord) Times(episode byte) []uint32 {
+	switch episode {
+	case 1:
+		return r.TimesEp1[:]
+	case 2:
+		return r.TimesEp2[:]
+	}
+	return nil
+}
+
+// BattleRecord holds a character's results in battle mode.
+type BattleRecord struct {
+	// Number of battles finished in each place, first to fourth.
+	Places [4]uint32 `json:"places"`
+	// Number of battles left before they ended, by the place the character
+	// was in when they left.
+	Disconnects [4]uint32 `json:"disconnects"`
+	Kills       uint32    `json:"kills"`
+	Deaths      uint32    `json:"deaths"`
+}
+
+// Privilege levels of the members of a team.
+const (
+	TeamPrivilegeMember = 0x00
+	TeamPrivilegeMaster = 0x40
+)
+
+// Size of a team's flag, a 32x32 image with 16 bits per pixel.
+const TeamFlagSize = 0x800
+
+// ErrTeamNameTaken is returned when creating a team with a name that another
+// team already has.
+var ErrTeamNameTaken = errors.New("data: team name taken")
+
+// Team is a group of accounts that share a name, a flag, and a chat channel.
+type Team struct {
+	Id        int64     `json:"id"`
+	Name      []uint16  `json:"name"`
+	Flag      []byte    `json:"flag"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TeamMember is one of the accounts in a team.
+type TeamMember struct {
+	TeamId    int64  `json:"team_id"`
+	Guildcard uint32 `json:"guildcard"`
+	// Name of the character the member was playing when they joined.
+	Name      []uint16  `json:"name"`
+	Privilege byte      `json:"privilege"`
+	JoinedAt  time.Time `json:"joined_at"`
+}
+
+// TradeSide is one of the characters in a trade and what they gave to the other.
+type TradeSide struct {
+	Guildcard uint32 `json:"guildcard"`
+	SlotNum   uint32 `json:"slot"`
+	Meseta    uint32 `json:"meseta"`
+	Items     []Item `json:"items"`
+}
+
+// Trade records what two characters exchanged in a trade.
+type Trade struct {
+	Id       int64        `json:"id"`
+	TradedAt time.Time    `json:"traded_at"`
+	Sides    [2]TradeSide `json:"sides"`
+}
+
+type GuildcardEntry struct {
+	Guildcard       int      `json:"guildcard"`
+	FriendGuildcard int      `json:"friendGuildcard"`
+	Name            []uint16 `json:"name"`
+	TeamName        []uint16 `json:"team_name"`
+	Description     []uint16 `json:"description"`
+	Language        byte     `json:"language"`
+	SectionID       byte     `json:"section_id"`
+	Class           byte     `json:"class"`
+	Comment         []uint16 `json:"comment"`
+}
+
+// BlockedGuildcard is a player on an account's blocked list.
+type BlockedGuildcard struct {
+	Guildcard        int      `json:"guildcard"`
+	BlockedGuildcard int      `json:"blocked_guildcard"`
+	Name             []uint16 `json:"name"`
+	TeamName         []uint16 `json:"team_name"`
+	Description      []uint16 `json:"description"`
+	Language         byte     `json:"language"`
+	SectionID        byte     `json:"section_id"`
+	Class            byte     `json:"class"`
+}
+
+// Mail is a simple mail message waiting to be delivered to a player.
+type Mail struct {
+	Id         int64     `json:"id"`
+	Recipient  uint32    `json:"recipient"`
+	Sender     uint32    `json:"sender"`
+	SenderName []uint16  `json:"sender_name"`
+	SentAt     time.Time `json:"sent_at"`
+	Message    []uint16  `json:"message"`
+}
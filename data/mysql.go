@@ -0,0 +1,26 @@
+package data
+
+import (
+	"fmt"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func init() {
+	Register("mysql", sqlDriver{dialect: &dialect{
+		name:       "mysql",
+		driverName: "mysql",
+		dsn: func(cfg Config) string {
+			// clientFoundRows makes UPDATE report matched rather than changed rows,
+			// which the upsert logic relies on.
+			return fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true&clientFoundRows=true",
+				cfg.Username, cfg.Password, cfg.Host, cfg.Port, cfg.Name)
+		},
+		connectionErrors: []error{mysql.ErrInvalidConn},
+		uniqueViolation: func(err error) bool {
+			// ER_DUP_ENTRY
+			e, ok := err.(*mysql.MySQLError)
+			return ok && e.Number == 1062
+		},
+	}})
+}
@@ -0,0 +1,25 @@
+package data
+
+import (
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+func init() {
+	Register("postgres", sqlDriver{dialect: &dialect{
+		name:       "postgres",
+		driverName: "postgres",
+		dsn: func(cfg Config) string {
+			return fmt.Sprintf("host=%s port=%s dbname=%s user=%s password=%s sslmode=disable",
+				cfg.Host, cfg.Port, cfg.Name, cfg.Username, cfg.Password)
+		},
+		numberedParams: true,
+		returningId:    true,
+		uniqueViolation: func(err error) bool {
+			e, ok := err.(*pq.Error)
+			// unique_violation
+			return ok && e.Code == "23505"
+		},
+	}})
+}
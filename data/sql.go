@@ -0,0 +1,2194 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dcrodman/archon/data/migrations"
+)
+
+// dialect captures the differences between the SQL databases we support so
+// that the queries themselves only need to be written once.
+type dialect struct {
+	// Name of the dialect, which also identifies its migration scripts.
+	name string
+	// Name of the database/sql driver to pass to sql.Open.
+	driverName string
+	// Builds the data source name from our config.
+	dsn func(cfg Config) string
+	// Whether the database expects numbered ($1, $2...) placeholders.
+	numberedParams bool
+	// Whether generated ids have to be fetched with RETURNING instead of LastInsertId.
+	returningId bool
+	// Limit on open connections to the database, or 0 for unlimited.
+	maxOpenConns int
+	// Errors from the driver that mean the connection to the database was lost,
+	// besides the ones that all drivers use (see isConnectionError).
+	connectionErrors []error
+	// Reports whether an error from the driver is a unique constraint violation.
+	uniqueViolation func(err error) bool
+}
+
+// Rewrite the ? placeholders in a query into whatever the database expects.
+func (d *dialect) rebind(query string) string {
+	if !d.numberedParams {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for i := 0; i < len(query); i++ {
+		if query[i] == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+		} else {
+			b.WriteByte(query[i])
+		}
+	}
+	return b.String()
+}
+
+// sqlDriver opens a sqlStore for a particular dialect.
+type sqlDriver struct {
+	dialect *dialect
+}
+
+func (d sqlDriver) Open(cfg Config) (Store, error) {
+	primary, err := d.openPool(cfg, false)
+	if err != nil {
+		return nil, err
+	}
+	s := &sqlStore{
+		primary: primary,
+		dialect: d.dialect,
+		timeout: cfg.QueryTimeout,
+		ctx:     context.Background(),
+	}
+	if cfg.ReplicaHost != "" {
+		replicaCfg := cfg
+		replicaCfg.Host, replicaCfg.Port = cfg.ReplicaHost, cfg.ReplicaPort
+		if cfg.ReplicaUsername != "" {
+			replicaCfg.Username, replicaCfg.Password = cfg.ReplicaUsername, cfg.ReplicaPassword
+		}
+		if s.replica, err = d.openPool(replicaCfg, true); err != nil {
+			primary.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Connect to the database described by cfg. A replica that can't be reached
+// yet starts out with its breaker open, so that its reads go to the primary
+// until it can be.
+func (d sqlDriver) openPool(cfg Config, replica bool) (*pool, error) {
+	db, err := sql.Open(d.dialect.driverName, d.dialect.dsn(cfg))
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(d.dialect.maxOpenConns)
+	p := &pool{
+		db:      db,
+		stmts:   make(map[string]*sql.Stmt),
+		breaker: newBreaker(cfg, replica, db.PingContext),
+	}
+	if err = db.Ping(); err == nil {
+		return p, nil
+	} else if !isConnectionError(err, context.Background(), d.dialect.connectionErrors) {
+		db.Close()
+		return nil, err
+	} else if replica {
+		p.breaker.Trip(err)
+		return p, nil
+	}
+	db.Close()
+	return nil, fmt.Errorf("%w: %s", ErrUnavailable, err.Error())
+}
+
+// Most statements kept prepared at once. Queries with a variable number of
+// placeholders (such as IN lists) can make any number of distinct statements,
+// so once the cache is full the rest are run without being prepared.
+const maxCachedStatements = 256
+
+// pool is the connection pool for one database, along with the statements
+// prepared on it for the queries made outside of transactions (keyed by the
+// query as it's written, before rebinding) and the breaker that stops queries
+// being sent to it while it can't be reached.
+type pool struct {
+	db      *sql.DB
+	stmts   map[string]*sql.Stmt
+	breaker *breaker
+	sync.RWMutex
+}
+
+func (p *pool) Close() error {
+	p.breaker.Close()
+	p.Lock()
+	for query, stmt := range p.stmts {
+		stmt.Close()
+		delete(p.stmts, query)
+	}
+	p.Unlock()
+	return p.db.Close()
+}
+
+// sqlStore is the Store implementation shared by all database/sql backends.
+type sqlStore struct {
+	// The pools are shared with the copies of the store made by WithContext
+	// and Replica. replica is nil if there's no read replica.
+	primary *pool
+	replica *pool
+	dialect *dialect
+	// How long each query or transaction is given before it's cancelled, or 0
+	// for no limit.
+	timeout time.Duration
+	// Parent of the contexts that the queries are made with (see WithContext).
+	ctx context.Context
+	// Whether queries outside of transactions go to the replica (see Replica).
+	useReplica bool
+}
+
+func (s *sqlStore) WithContext(ctx context.Context) Store {
+	bound := *s
+	bound.ctx = ctx
+	return &bound
+}
+
+func (s *sqlStore) Replica() Store {
+	bound := *s
+	bound.useReplica = true
+	return &bound
+}
+
+func (s *sqlStore) Ping(ctx context.Context) error {
+	err := s.primary.db.PingContext(ctx)
+	s.record(s.primary, err)
+	return err
+}
+
+func (s *sqlStore) Close() error {
+	if s.replica != nil {
+		s.replica.Close()
+	}
+	return s.primary.Close()
+}
+
+func (s *sqlStore) Migrate(target int) error {
+	return migrations.Migrate(s.primary.db, s.dialect.name, target)
+}
+
+func (s *sqlStore) SchemaVersion() (int, int, error) {
+	current, err := migrations.Version(s.primary.db)
+	if err != nil {
+		return 0, 0, err
+	}
+	latest, err := migrations.LatestVersion(s.dialect.name)
+	return current, latest, err
+}
+
+// Returns a context that's cancelled once the store's timeout has passed or its
+// parent is done.
+func (s *sqlStore) context() (context.Context, context.CancelFunc) {
+	if s.timeout <= 0 {
+		return context.WithCancel(s.ctx)
+	}
+	return context.WithTimeout(s.ctx, s.timeout)
+}
+
+// Returns the pool that reads are made on: the replica if this copy of the
+// store uses it and it can be reached, or the primary.
+func (s *sqlStore) reads() *pool {
+	if s.useReplica && s.replica != nil && s.replica.breaker.Allow() == nil {
+		return s.replica
+	}
+	return s.primary
+}
+
+// Returns the prepared statement on p for query, preparing it the first time
+// it's used, or nil if it isn't cached and there's no room for it.
+func (s *sqlStore) statement(ctx context.Context, p *pool, query string) (*sql.Stmt, error) {
+	p.RLock()
+	stmt, ok := p.stmts[query]
+	full := len(p.stmts) >= maxCachedStatements
+	p.RUnlock()
+	if ok || full {
+		return stmt, nil
+	}
+
+	stmt, err := p.db.PrepareContext(ctx, s.dialect.rebind(query))
+	if err != nil {
+		return nil, err
+	}
+	p.Lock()
+	defer p.Unlock()
+	if cached, ok := p.stmts[query]; ok {
+		// Prepared by someone else in the meantime.
+		stmt.Close()
+		return cached, nil
+	} else if len(p.stmts) >= maxCachedStatements {
+		stmt.Close()
+		return nil, nil
+	}
+	p.stmts[query] = stmt
+	return stmt, nil
+}
+
+// Tell p's breaker how a query went, returning err.
+func (s *sqlStore) record(p *pool, err error) error {
+	p.breaker.Record(err, isConnectionError(err, s.ctx, s.dialect.connectionErrors))
+	return err
+}
+
+// row is a *sql.Row whose context is cancelled once it has been scanned.
+type row struct {
+	*sql.Row
+	err    error
+	cancel context.CancelFunc
+	store  *sqlStore
+	pool   *pool
+}
+
+func (r *row) Scan(dest ...interface{}) error {
+	defer r.cancel()
+	if r.err != nil {
+		return r.err
+	}
+	return r.store.record(r.pool, r.Row.Scan(dest...))
+}
+
+// rows is a *sql.Rows whose context is cancelled once it's closed.
+type rows struct {
+	*sql.Rows
+	cancel context.CancelFunc
+	store  *sqlStore
+	pool   *pool
+}
+
+func (r *rows) Close() error {
+	defer r.cancel()
+	r.store.record(r.pool, r.Rows.Err())
+	return r.Rows.Close()
+}
+
+func (s *sqlStore) queryRow(query string, args ...interface{}) *row {
+	ctx, cancel := s.context()
+	p := s.reads()
+	if err := p.breaker.Allow(); err != nil {
+		return &row{err: err, cancel: cancel}
+	}
+	stmt, err := s.statement(ctx, p, query)
+	if err != nil {
+		return &row{err: s.record(p, err), cancel: cancel}
+	} else if stmt == nil {
+		return &row{Row: p.db.QueryRowContext(ctx, s.dialect.rebind(query), args...), cancel: cancel,
+			store: s, pool: p}
+	}
+	return &row{Row: stmt.QueryRowContext(ctx, args...), cancel: cancel, store: s, pool: p}
+}
+
+func (s *sqlStore) query(query string, args ...interface{}) (*rows, error) {
+	p := s.reads()
+	if err := p.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	ctx, cancel := s.context()
+	stmt, err := s.statement(ctx, p, query)
+	var result *sql.Rows
+	if err == nil && stmt == nil {
+		result, err = p.db.QueryContext(ctx, s.dialect.rebind(query), args...)
+	} else if err == nil {
+		result, err = stmt.QueryContext(ctx, args...)
+	}
+	if err != nil {
+		cancel()
+		return nil, s.record(p, err)
+	}
+	return &rows{Rows: result, cancel: cancel, store: s, pool: p}, nil
+}
+
+func (s *sqlStore) exec(query string, args ...interface{}) (sql.Result, error) {
+	p := s.primary
+	if err := p.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	ctx, cancel := s.context()
+	defer cancel()
+	stmt, err := s.statement(ctx, p, query)
+	var result sql.Result
+	if err == nil && stmt == nil {
+		result, err = p.db.ExecContext(ctx, s.dialect.rebind(query), args...)
+	} else if err == nil {
+		result, err = stmt.ExecContext(ctx, args...)
+	}
+	return result, s.record(p, err)
+}
+
+// Run fn in a transaction, committing if it succeeds and rolling back otherwise.
+// The transaction is rolled back if it isn't finished within the store's timeout.
+// Transactions are always made on the primary.
+func (s *sqlStore) transaction(fn func(tx *sql.Tx) error) error {
+	p := s.primary
+	if err := p.breaker.Allow(); err != nil {
+		return err
+	}
+	ctx, cancel := s.context()
+	defer cancel()
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return s.record(p, err)
+	}
+	if err = fn(tx); err != nil {
+		tx.Rollback()
+		return s.record(p, err)
+	}
+	return s.record(p, tx.Commit())
+}
+
+// Find the account matching a condition on the accounts table.
+func (s *sqlStore) findAccount(where string, args ...interface{}) (*Account, error) {
+	account := new(Account)
+	var mutedUntil sql.NullTime
+	err := s.queryRow("SELECT username, password, email, registration_date, guildcard, "+
+		"is_gm, active, team_id, privilege_level, muted_until, shadow_muted "+
+		"FROM accounts WHERE "+where,
+		args...).Scan(&account.Username, &account.Password, &account.Email,
+		&account.RegistrationDate, &account.Guildcard, &account.GM, &account.Active,
+		&account.TeamID, &account.PrivilegeLevel, &mutedUntil, &account.ShadowMuted)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if mutedUntil.Valid {
+		account.MutedUntil = mutedUntil.Time
+	}
+	return account, nil
+}
+
+// Run an INSERT into a table with an id column and return the id generated for the row.
+func (s *sqlStore) insertId(tx *sql.Tx, query string, args ...interface{}) (int64, error) {
+	if s.dialect.returningId {
+		var id int64
+		err := tx.QueryRow(s.dialect.rebind(query+" RETURNING id"), args...).Scan(&id)
+		return id, err
+	}
+	result, err := tx.Exec(s.dialect.rebind(query), args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (s *sqlStore) FindAccount(username string) (*Account, error) {
+	return s.findAccount("username = ?", username)
+}
+
+func (s *sqlStore) FindAccountByEmail(email string) (*Account, error) {
+	if email == "" {
+		return nil, nil
+	}
+	return s.findAccount("LOWER(email) = LOWER(?)", email)
+}
+
+func (s *sqlStore) CreateAccount(account *Account) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		return s.createAccount(tx, account)
+	})
+}
+
+func (s *sqlStore) ImportAccount(source string, sourceGuildcard uint32, account *Account, ban *Ban) (bool, error) {
+	created := false
+	err := s.transaction(func(tx *sql.Tx) error {
+		err := tx.QueryRow(s.dialect.rebind("SELECT guildcard FROM imported_accounts "+
+			"WHERE source = ? AND source_guildcard = ?"), source, sourceGuildcard).Scan(&account.Guildcard)
+		if err != sql.ErrNoRows {
+			return err
+		}
+		if err = s.createAccount(tx, account); err != nil {
+			return err
+		}
+		_, err = tx.Exec(s.dialect.rebind("INSERT INTO imported_accounts (source, source_guildcard, "+
+			"guildcard) VALUES (?, ?, ?)"), source, sourceGuildcard, account.Guildcard)
+		if err == nil && ban != nil {
+			err = s.createBan(tx, ban)
+		}
+		created = err == nil
+		return err
+	})
+	return created, err
+}
+
+func (s *sqlStore) createAccount(tx *sql.Tx, account *Account) error {
+	var n int
+	err := tx.QueryRow(s.dialect.rebind("SELECT COUNT(*) FROM accounts WHERE username = ?"),
+		account.Username).Scan(&n)
+	if err != nil {
+		return err
+	} else if n > 0 {
+		return ErrAccountExists
+	}
+	if account.Email != "" {
+		err = tx.QueryRow(s.dialect.rebind("SELECT COUNT(*) FROM accounts "+
+			"WHERE LOWER(email) = LOWER(?)"), account.Email).Scan(&n)
+		if err != nil {
+			return err
+		} else if n > 0 {
+			return ErrEmailInUse
+		}
+	}
+
+	_, err = tx.Exec(s.dialect.rebind("INSERT INTO accounts (username, password, email, "+
+		"is_gm, active, privilege_level) VALUES (?, ?, ?, ?, ?, ?)"),
+		account.Username, account.Password, account.Email, account.GM, account.Active,
+		account.PrivilegeLevel)
+	if err != nil {
+		return err
+	}
+	return tx.QueryRow(s.dialect.rebind("SELECT guildcard, registration_date FROM accounts "+
+		"WHERE username = ?"), account.Username).Scan(&account.Guildcard, &account.RegistrationDate)
+}
+
+// Run an update against a single account, failing if it doesn't exist.
+func (s *sqlStore) updateAccount(query string, args ...interface{}) error {
+	result, err := s.exec(query, args...)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrAccountNotFound
+	}
+	return nil
+}
+
+func (s *sqlStore) UpdatePassword(username string, password string) error {
+	return s.updateAccount("UPDATE accounts SET password = ? WHERE username = ?", password, username)
+}
+
+func (s *sqlStore) UpdatePrivilegeLevel(username string, level byte) error {
+	return s.updateAccount("UPDATE accounts SET privilege_level = ?, is_gm = ? WHERE username = ?",
+		level, level >= PrivilegeGM, username)
+}
+
+func (s *sqlStore) UpdateMute(username string, until time.Time, shadow bool) error {
+	var mutedUntil interface{}
+	if !until.IsZero() {
+		mutedUntil = until.UTC()
+	}
+	return s.updateAccount("UPDATE accounts SET muted_until = ?, shadow_muted = ? WHERE username = ?",
+		mutedUntil, shadow, username)
+}
+
+func (s *sqlStore) CreatePasswordReset(username string, tokenHash string, expires time.Time) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		_, err := tx.Exec(s.dialect.rebind("DELETE FROM password_resets WHERE username = ?"), username)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(s.dialect.rebind("INSERT INTO password_resets (token_hash, username, "+
+			"expires_at) VALUES (?, ?, ?)"), tokenHash, username, expires.UTC())
+		return err
+	})
+}
+
+func (s *sqlStore) UsePasswordReset(tokenHash string) (string, error) {
+	var username string
+	err := s.transaction(func(tx *sql.Tx) error {
+		var expires time.Time
+		err := tx.QueryRow(s.dialect.rebind("SELECT username, expires_at FROM password_resets "+
+			"WHERE token_hash = ?"), tokenHash).Scan(&username, &expires)
+		if err == sql.ErrNoRows {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		_, err = tx.Exec(s.dialect.rebind("DELETE FROM password_resets WHERE token_hash = ?"), tokenHash)
+		if err == nil && time.Now().UTC().After(expires) {
+			username = ""
+		}
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return username, nil
+}
+
+func (s *sqlStore) CreateSession(guildcard uint32, tokenHash string, expires time.Time) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		_, err := tx.Exec(s.dialect.rebind("DELETE FROM sessions WHERE guildcard = ? OR expires_at < ?"),
+			guildcard, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(s.dialect.rebind("INSERT INTO sessions (token_hash, guildcard, "+
+			"expires_at) VALUES (?, ?, ?)"), tokenHash, guildcard, expires.UTC())
+		return err
+	})
+}
+
+func (s *sqlStore) RefreshSession(tokenHash string, expires time.Time) (uint32, error) {
+	var guildcard uint32
+	err := s.transaction(func(tx *sql.Tx) error {
+		var expiresAt time.Time
+		err := tx.QueryRow(s.dialect.rebind("SELECT guildcard, expires_at FROM sessions "+
+			"WHERE token_hash = ?"), tokenHash).Scan(&guildcard, &expiresAt)
+		if err == sql.ErrNoRows {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if time.Now().UTC().After(expiresAt) {
+			guildcard = 0
+			_, err = tx.Exec(s.dialect.rebind("DELETE FROM sessions WHERE token_hash = ?"), tokenHash)
+			return err
+		}
+		_, err = tx.Exec(s.dialect.rebind("UPDATE sessions SET expires_at = ? WHERE token_hash = ?"),
+			expires.UTC(), tokenHash)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return guildcard, nil
+}
+
+func (s *sqlStore) FindTwoFactor(username string) (*TwoFactor, error) {
+	twoFactor := &TwoFactor{Username: username}
+	err := s.queryRow("SELECT secret, enabled, last_step FROM two_factor "+
+		"WHERE username = ?", username).Scan(&twoFactor.Secret, &twoFactor.Enabled, &twoFactor.LastStep)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return twoFactor, nil
+}
+
+func (s *sqlStore) SaveTwoFactor(twoFactor *TwoFactor, recoveryCodeHashes []string) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		for _, table := range []string{"two_factor", "recovery_codes"} {
+			_, err := tx.Exec(s.dialect.rebind("DELETE FROM "+table+" WHERE username = ?"), twoFactor.Username)
+			if err != nil {
+				return err
+			}
+		}
+		_, err := tx.Exec(s.dialect.rebind("INSERT INTO two_factor (username, secret, enabled, "+
+			"last_step) VALUES (?, ?, ?, ?)"), twoFactor.Username, twoFactor.Secret,
+			twoFactor.Enabled, twoFactor.LastStep)
+		if err != nil {
+			return err
+		}
+		for _, hash := range recoveryCodeHashes {
+			_, err = tx.Exec(s.dialect.rebind("INSERT INTO recovery_codes (username, code_hash) "+
+				"VALUES (?, ?)"), twoFactor.Username, hash)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *sqlStore) UpdateTwoFactor(twoFactor *TwoFactor) error {
+	// Only one of the requests that read the same last step can move it on.
+	result, err := s.exec("UPDATE two_factor SET enabled = ?, last_step = ? "+
+		"WHERE username = ? AND last_step < ?", twoFactor.Enabled, twoFactor.LastStep,
+		twoFactor.Username, twoFactor.LastStep)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrTwoFactorStepUsed
+	}
+	return nil
+}
+
+func (s *sqlStore) DeleteTwoFactor(username string) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		for _, table := range []string{"two_factor", "recovery_codes"} {
+			_, err := tx.Exec(s.dialect.rebind("DELETE FROM "+table+" WHERE username = ?"), username)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *sqlStore) UseRecoveryCode(username string, codeHash string) (bool, error) {
+	res, err := s.exec("DELETE FROM recovery_codes WHERE username = ? "+
+		"AND code_hash = ?", username, codeHash)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *sqlStore) FindPlayerOptions(guildcard uint32) (*PlayerOptions, error) {
+	options := &PlayerOptions{Guildcard: guildcard}
+	err := s.queryRow("SELECT version, key_config, joystick_config, option_flags "+
+		"FROM player_options WHERE guildcard = ?", guildcard).Scan(&options.Version,
+		&options.KeyConfig, &options.JoystickConfig, &options.OptionFlags)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return options, nil
+}
+
+func (s *sqlStore) UpdatePlayerOptions(playerOptions *PlayerOptions) error {
+	if err := playerOptions.Validate(); err != nil {
+		return err
+	}
+	o := playerOptions
+	res, err := s.exec("UPDATE player_options SET version = ?, key_config = ?, "+
+		"joystick_config = ?, option_flags = ? WHERE guildcard = ?",
+		o.Version, o.KeyConfig, o.JoystickConfig, o.OptionFlags, o.Guildcard)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	_, err = s.exec("INSERT INTO player_options (guildcard, version, key_config, "+
+		"joystick_config, option_flags) VALUES ("+placeholders(5)+")",
+		o.Guildcard, o.Version, o.KeyConfig, o.JoystickConfig, o.OptionFlags)
+	return err
+}
+
+// Column order shared by all of the character queries.
+const characterColumns = "guildcard_str, experience, level, name_color, model, " +
+	"name_color_checksum, section_id, class, v2_flags, version, v1_flags, costume, " +
+	"skin, face, head, hair, hair_red, hair_green, hair_blue, proportion_x, " +
+	"proportion_y, name, playtime, atp, mst, evp, hp, dfp, ata, lck, meseta"
+
+func characterValues(c *Character) []interface{} {
+	return []interface{}{c.GuildcardStr, c.Experience, c.Level, c.NameColor, c.Model,
+		c.NameColorChecksum, c.SectionID, c.Class, c.V2Flags, c.Version, c.V1Flags,
+		c.Costume, c.Skin, c.Face, c.Head, c.Hair, c.HairRed, c.HairGreen, c.HairBlue,
+		c.ProportionX, c.ProportionY, c.Name, c.Playtime, c.ATP, c.MST, c.EVP, c.HP,
+		c.DFP, c.ATA, c.LCK, c.Meseta}
+}
+
+func characterDest(c *Character) []interface{} {
+	return []interface{}{&c.GuildcardStr, &c.Experience, &c.Level, &c.NameColor, &c.Model,
+		&c.NameColorChecksum, &c.SectionID, &c.Class, &c.V2Flags, &c.Version, &c.V1Flags,
+		&c.Costume, &c.Skin, &c.Face, &c.Head, &c.Hair, &c.HairRed, &c.HairGreen, &c.HairBlue,
+		&c.ProportionX, &c.ProportionY, &c.Name, &c.Playtime, &c.ATP, &c.MST, &c.EVP, &c.HP,
+		&c.DFP, &c.ATA, &c.LCK, &c.Meseta}
+}
+
+// Columns that can be changed from the dressing room.
+const appearanceColumns = "name_color, model, name_color_checksum, section_id, " +
+	"costume, skin, face, head, hair, hair_red, hair_green, hair_blue, " +
+	"proportion_x, proportion_y, name"
+
+func appearanceValues(c *Character) []interface{} {
+	return []interface{}{c.NameColor, c.Model, c.NameColorChecksum, c.SectionID,
+		c.Costume, c.Skin, c.Face, c.Head, c.Hair, c.HairRed, c.HairGreen, c.HairBlue,
+		c.ProportionX, c.ProportionY, c.Name}
+}
+
+// Returns the SET clause assigning a placeholder to each of the columns.
+func assignments(columns string) string {
+	return strings.Join(strings.Split(columns, ", "), " = ?, ") + " = ?"
+}
+
+func (s *sqlStore) CreateCharacter(guildcard uint32, slotNum uint32, character *Character) error {
+	character.Guildcard = int(guildcard)
+	character.Slot = slotNum
+	args := append([]interface{}{guildcard, slotNum, nameKey(character.NameKey)}, characterValues(character)...)
+	_, err := s.exec("INSERT INTO characters (guildcard, slot, name_key, "+characterColumns+
+		") VALUES ("+placeholders(len(args))+")", args...)
+	return s.nameKeyError(err)
+}
+
+// Returns the value stored in the name_key column for a key, which is NULL if
+// the name needn't be unique.
+func nameKey(key string) interface{} {
+	if key == "" {
+		return nil
+	}
+	return key
+}
+
+// Translates a unique constraint violation from writing a character's name_key
+// into ErrCharacterNameTaken. Other errors are returned unchanged.
+func (s *sqlStore) nameKeyError(err error) error {
+	if err != nil && s.dialect.uniqueViolation != nil && s.dialect.uniqueViolation(err) {
+		return ErrCharacterNameTaken
+	}
+	return err
+}
+
+func (s *sqlStore) FindCharacter(guildcard uint32, slotNum uint32) (*Character, error) {
+	character := &Character{Guildcard: int(guildcard), Slot: slotNum}
+	err := s.queryRow("SELECT "+characterColumns+" FROM characters "+
+		"WHERE guildcard = ? AND slot = ?", guildcard, slotNum).Scan(characterDest(character)...)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return character, nil
+}
+
+func (s *sqlStore) UpdateCharacter(guildcard uint32, slotNum uint32, character *Character) error {
+	args := append(characterValues(character), guildcard, slotNum)
+	_, err := s.exec("UPDATE characters SET "+assignments(characterColumns)+
+		" WHERE guildcard = ? AND slot = ?", args...)
+	return err
+}
+
+func (s *sqlStore) UpdateAppearance(guildcard uint32, slotNum uint32, character *Character) error {
+	args := append(appearanceValues(character), nameKey(character.NameKey), guildcard, slotNum)
+	_, err := s.exec("UPDATE characters SET "+assignments(appearanceColumns)+
+		", name_key = ? WHERE guildcard = ? AND slot = ?", args...)
+	return s.nameKeyError(err)
+}
+
+// Tables containing data that belongs to the character in a slot.
+var characterTables = []string{"character_items", "character_techniques", "character_quest_flags",
+	"character_challenges", "character_challenge_times", "character_battle_records", "banks", "characters"}
+
+// Move all of the data for the character in one slot to another.
+func (s *sqlStore) moveCharacter(tx *sql.Tx, guildcard uint32, from uint32, to uint32) error {
+	for _, table := range characterTables {
+		_, err := tx.Exec(s.dialect.rebind("UPDATE "+table+" SET slot = ? "+
+			"WHERE guildcard = ? AND slot = ?"), to, guildcard, from)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Permanently remove all of the data in a slot.
+func (s *sqlStore) wipeSlot(tx *sql.Tx, guildcard uint32, slotNum uint32) error {
+	for _, table := range characterTables {
+		_, err := tx.Exec(s.dialect.rebind("DELETE FROM "+table+
+			" WHERE guildcard = ? AND slot = ?"), guildcard, slotNum)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqlStore) characterExists(tx *sql.Tx, guildcard uint32, slotNum uint32) (bool, error) {
+	var count int
+	err := tx.QueryRow(s.dialect.rebind("SELECT COUNT(*) FROM characters "+
+		"WHERE guildcard = ? AND slot = ?"), guildcard, slotNum).Scan(&count)
+	return count > 0, err
+}
+
+func (s *sqlStore) DeleteCharacter(guildcard uint32, slotNum uint32) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		exists, err := s.characterExists(tx, guildcard, slotNum)
+		if err != nil {
+			return err
+		} else if !exists {
+			// Nothing worth keeping; just clear out anything left in the slot.
+			return s.wipeSlot(tx, guildcard, slotNum)
+		}
+
+		var lastSlot sql.NullInt64
+		err = tx.QueryRow(s.dialect.rebind("SELECT MAX(deleted_slot) FROM deleted_characters "+
+			"WHERE guildcard = ?"), guildcard).Scan(&lastSlot)
+		if err != nil {
+			return err
+		}
+		deletedSlot := uint32(FirstDeletedSlot)
+		if lastSlot.Valid {
+			deletedSlot = uint32(lastSlot.Int64) + 1
+		}
+		// The name's key is set aside with the deleted character so that the
+		// name is free for others until it's restored.
+		var key sql.NullString
+		err = tx.QueryRow(s.dialect.rebind("SELECT name_key FROM characters "+
+			"WHERE guildcard = ? AND slot = ?"), guildcard, slotNum).Scan(&key)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(s.dialect.rebind("UPDATE characters SET name_key = NULL "+
+			"WHERE guildcard = ? AND slot = ?"), guildcard, slotNum)
+		if err != nil {
+			return err
+		}
+		if err = s.moveCharacter(tx, guildcard, slotNum, deletedSlot); err != nil {
+			return err
+		}
+		_, err = tx.Exec(s.dialect.rebind("INSERT INTO deleted_characters (guildcard, "+
+			"deleted_slot, original_slot, deleted_at, name_key) VALUES ("+placeholders(5)+")"),
+			guildcard, deletedSlot, slotNum, time.Now().UTC(), key)
+		return err
+	})
+}
+
+func (s *sqlStore) FindCharacterNames() ([]CharacterName, error) {
+	rows, err := s.query("SELECT guildcard, slot, name, name_key FROM characters WHERE slot < ?",
+		FirstDeletedSlot)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []CharacterName
+	for rows.Next() {
+		var name CharacterName
+		var key sql.NullString
+		if err = rows.Scan(&name.Guildcard, &name.Slot, &name.Name, &key); err != nil {
+			return nil, err
+		}
+		name.Key = key.String
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+func (s *sqlStore) UpdateCharacterNameKey(guildcard uint32, slotNum uint32, key string) error {
+	_, err := s.exec("UPDATE characters SET name_key = ? WHERE guildcard = ? AND slot = ?",
+		nameKey(key), guildcard, slotNum)
+	return s.nameKeyError(err)
+}
+
+func (s *sqlStore) FindDeletedCharacters(guildcard uint32) ([]DeletedCharacter, error) {
+	rows, err := s.query("SELECT deleted_slot, original_slot, deleted_at FROM deleted_characters "+
+		"WHERE guildcard = ? ORDER BY deleted_slot", guildcard)
+	if err != nil {
+		return nil, err
+	}
+	var deleted []DeletedCharacter
+	for rows.Next() {
+		var d DeletedCharacter
+		if err = rows.Scan(&d.Id, &d.Slot, &d.DeletedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		deleted = append(deleted, d)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range deleted {
+		if deleted[i].Character, err = s.FindCharacter(guildcard, deleted[i].Id); err != nil {
+			return nil, err
+		}
+	}
+	return deleted, nil
+}
+
+func (s *sqlStore) RestoreCharacter(guildcard uint32, id uint32, slotNum uint32) error {
+	err := s.transaction(func(tx *sql.Tx) error {
+		var key sql.NullString
+		err := tx.QueryRow(s.dialect.rebind("SELECT name_key FROM deleted_characters "+
+			"WHERE guildcard = ? AND deleted_slot = ?"), guildcard, id).Scan(&key)
+		if err == sql.ErrNoRows {
+			return ErrCharacterNotFound
+		} else if err != nil {
+			return err
+		}
+		res, err := tx.Exec(s.dialect.rebind("DELETE FROM deleted_characters "+
+			"WHERE guildcard = ? AND deleted_slot = ?"), guildcard, id)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrCharacterNotFound
+		}
+
+		exists, err := s.characterExists(tx, guildcard, slotNum)
+		if err != nil {
+			return err
+		} else if exists {
+			return ErrSlotInUse
+		}
+		if err = s.wipeSlot(tx, guildcard, slotNum); err != nil {
+			return err
+		}
+		if err = s.moveCharacter(tx, guildcard, id, slotNum); err != nil {
+			return err
+		}
+		_, err = tx.Exec(s.dialect.rebind("UPDATE characters SET name_key = ? "+
+			"WHERE guildcard = ? AND slot = ?"), key, guildcard, slotNum)
+		return err
+	})
+	return s.nameKeyError(err)
+}
+
+func (s *sqlStore) PurgeDeletedCharacters(before time.Time) (int, error) {
+	var purged int
+	err := s.transaction(func(tx *sql.Tx) error {
+		rows, err := tx.Query(s.dialect.rebind("SELECT guildcard, deleted_slot "+
+			"FROM deleted_characters WHERE deleted_at < ?"), before.UTC())
+		if err != nil {
+			return err
+		}
+		type deletedSlot struct{ guildcard, slot uint32 }
+		var expired []deletedSlot
+		for rows.Next() {
+			var d deletedSlot
+			if err = rows.Scan(&d.guildcard, &d.slot); err != nil {
+				rows.Close()
+				return err
+			}
+			expired = append(expired, d)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+
+		for _, d := range expired {
+			if err = s.wipeSlot(tx, d.guildcard, d.slot); err != nil {
+				return err
+			}
+			_, err = tx.Exec(s.dialect.rebind("DELETE FROM deleted_characters "+
+				"WHERE guildcard = ? AND deleted_slot = ?"), d.guildcard, d.slot)
+			if err != nil {
+				return err
+			}
+		}
+		purged = len(expired)
+		return nil
+	})
+	return purged, err
+}
+
+// Snapshots are stored as JSON so that they don't need their own copy of every
+// character table.
+func (s *sqlStore) CreateSnapshot(snapshot *CharacterSnapshot, keep int) error {
+	contents, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	return s.transaction(func(tx *sql.Tx) error {
+		snapshot.Id, err = s.insertId(tx, "INSERT INTO character_snapshots (guildcard, slot, "+
+			"created_at, contents) VALUES ("+placeholders(4)+")",
+			snapshot.Guildcard, snapshot.Slot, snapshot.CreatedAt.UTC(), contents)
+		if err != nil {
+			return err
+		}
+
+		rows, err := tx.Query(s.dialect.rebind("SELECT id FROM character_snapshots "+
+			"WHERE guildcard = ? AND slot = ? ORDER BY created_at DESC, id DESC"),
+			snapshot.Guildcard, snapshot.Slot)
+		if err != nil {
+			return err
+		}
+		var expired []int64
+		for i := 0; rows.Next(); i++ {
+			var id int64
+			if err = rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			if i >= keep {
+				expired = append(expired, id)
+			}
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		for _, id := range expired {
+			_, err = tx.Exec(s.dialect.rebind("DELETE FROM character_snapshots WHERE id = ?"), id)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *sqlStore) findSnapshots(where string, args ...interface{}) ([]CharacterSnapshot, error) {
+	rows, err := s.query("SELECT id, guildcard, slot, created_at, contents FROM character_snapshots "+
+		where+" ORDER BY created_at DESC, id DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var snapshots []CharacterSnapshot
+	for rows.Next() {
+		var snapshot CharacterSnapshot
+		var id int64
+		var guildcard, slot uint32
+		var createdAt time.Time
+		var contents []byte
+		if err = rows.Scan(&id, &guildcard, &slot, &createdAt, &contents); err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(contents, &snapshot); err != nil {
+			return nil, err
+		}
+		snapshot.Id, snapshot.Guildcard, snapshot.Slot, snapshot.CreatedAt = id, guildcard, slot, createdAt
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots, rows.Err()
+}
+
+func (s *sqlStore) FindSnapshots(guildcard uint32, slotNum uint32) ([]CharacterSnapshot, error) {
+	return s.findSnapshots("WHERE guildcard = ? AND slot = ?", guildcard, slotNum)
+}
+
+func (s *sqlStore) FindSnapshot(id int64) (*CharacterSnapshot, error) {
+	snapshots, err := s.findSnapshots("WHERE id = ?", id)
+	if err != nil || len(snapshots) == 0 {
+		return nil, err
+	}
+	return &snapshots[0], nil
+}
+
+func (s *sqlStore) RestoreSnapshot(id int64, nameKey string) error {
+	snapshot, err := s.FindSnapshot(id)
+	if err != nil {
+		return err
+	} else if snapshot == nil || snapshot.Character == nil {
+		return ErrSnapshotNotFound
+	}
+	snapshot.Character.NameKey = nameKey
+	return s.transaction(func(tx *sql.Tx) error {
+		return s.writeSnapshot(tx, snapshot, true)
+	})
+}
+
+func (s *sqlStore) ImportCharacter(snapshot *CharacterSnapshot) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		return s.writeSnapshot(tx, snapshot, false)
+	})
+}
+
+// Write the contents of a snapshot to the slot it names, overwriting the
+// character there if replace is set and failing with ErrSlotInUse otherwise.
+// The character's NameKey is written along with it, failing with
+// ErrCharacterNameTaken if another character has it.
+func (s *sqlStore) writeSnapshot(tx *sql.Tx, snapshot *CharacterSnapshot, replace bool) error {
+	guildcard, slotNum := snapshot.Guildcard, snapshot.Slot
+	key := nameKey(snapshot.Character.NameKey)
+	exists, err := s.characterExists(tx, guildcard, slotNum)
+	if err != nil {
+		return err
+	}
+	switch {
+	case exists && !replace:
+		return ErrSlotInUse
+	case exists:
+		args := append(characterValues(snapshot.Character), key, guildcard, slotNum)
+		_, err = tx.Exec(s.dialect.rebind("UPDATE characters SET "+assignments(characterColumns)+
+			", name_key = ? WHERE guildcard = ? AND slot = ?"), args...)
+	default:
+		// Clear out anything left in the slot by a character that's gone.
+		if err = s.wipeSlot(tx, guildcard, slotNum); err != nil {
+			return err
+		}
+		args := append([]interface{}{guildcard, slotNum, key}, characterValues(snapshot.Character)...)
+		_, err = tx.Exec(s.dialect.rebind("INSERT INTO characters (guildcard, slot, name_key, "+
+			characterColumns+") VALUES ("+placeholders(len(args))+")"), args...)
+	}
+	if err != nil {
+		return s.nameKeyError(err)
+	}
+	if err = s.replaceItems(tx, guildcard, slotNum, ItemLocationInventory, snapshot.Inventory); err != nil {
+		return err
+	}
+	if err = s.replaceTechniques(tx, guildcard, slotNum, snapshot.Techniques); err != nil {
+		return err
+	}
+	if snapshot.Bank == nil {
+		return nil
+	}
+	return s.replaceBank(tx, guildcard, snapshot.BankSlot, snapshot.Bank)
+}
+
+func (s *sqlStore) PurgeSnapshots(before time.Time) (int, error) {
+	res, err := s.exec("DELETE FROM character_snapshots WHERE created_at < ?", before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (s *sqlStore) FindItems(guildcard uint32, slotNum uint32, location int) ([]Item, error) {
+	rows, err := s.query("SELECT item_id, data, data2, flags FROM character_items "+
+		"WHERE guildcard = ? AND slot = ? AND location = ? ORDER BY position",
+		guildcard, slotNum, location)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []Item
+	for rows.Next() {
+		var item Item
+		if err = rows.Scan(&item.ItemId, &item.Data, &item.Data2, &item.Flags); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+func (s *sqlStore) UpdateItems(guildcard uint32, slotNum uint32, location int, items []Item) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		return s.replaceItems(tx, guildcard, slotNum, location, items)
+	})
+}
+
+func (s *sqlStore) replaceItems(tx *sql.Tx, guildcard uint32, slotNum uint32, location int, items []Item) error {
+	_, err := tx.Exec(s.dialect.rebind("DELETE FROM character_items "+
+		"WHERE guildcard = ? AND slot = ? AND location = ?"), guildcard, slotNum, location)
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(s.dialect.rebind("INSERT INTO character_items (guildcard, " +
+		"slot, location, position, item_id, data, data2, flags) VALUES (" + placeholders(8) + ")"))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for i, item := range items {
+		_, err = stmt.Exec(guildcard, slotNum, location, i, item.ItemId, item.Data, item.Data2, item.Flags)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqlStore) FindTechniques(guildcard uint32, slotNum uint32) (Techniques, error) {
+	var techniques Techniques
+	rows, err := s.query("SELECT technique, level FROM character_techniques "+
+		"WHERE guildcard = ? AND slot = ?", guildcard, slotNum)
+	if err != nil {
+		return techniques, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var technique int
+		var level byte
+		if err = rows.Scan(&technique, &level); err != nil {
+			return techniques, err
+		}
+		if technique >= 0 && technique < NumTechniques {
+			techniques[technique] = level
+		}
+	}
+	return techniques, rows.Err()
+}
+
+func (s *sqlStore) UpdateTechniques(guildcard uint32, slotNum uint32, techniques Techniques) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		return s.replaceTechniques(tx, guildcard, slotNum, techniques)
+	})
+}
+
+func (s *sqlStore) replaceTechniques(tx *sql.Tx, guildcard uint32, slotNum uint32, techniques Techniques) error {
+	_, err := tx.Exec(s.dialect.rebind("DELETE FROM character_techniques "+
+		"WHERE guildcard = ? AND slot = ?"), guildcard, slotNum)
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(s.dialect.rebind("INSERT INTO character_techniques " +
+		"(guildcard, slot, technique, level) VALUES (" + placeholders(4) + ")"))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for technique, level := range techniques {
+		if level == 0 {
+			continue
+		}
+		if _, err = stmt.Exec(guildcard, slotNum, technique, level); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqlStore) FindQuestFlags(guildcard uint32, slotNum uint32) (QuestFlags, error) {
+	var flags QuestFlags
+	rows, err := s.query("SELECT difficulty, flag FROM character_quest_flags "+
+		"WHERE guildcard = ? AND slot = ?", guildcard, slotNum)
+	if err != nil {
+		return flags, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var difficulty byte
+		var flag uint16
+		if err = rows.Scan(&difficulty, &flag); err != nil {
+			return flags, err
+		}
+		if int(difficulty) < len(flags) && flag < NumQuestFlags {
+			flags.Set(difficulty, flag, true)
+		}
+	}
+	return flags, rows.Err()
+}
+
+func (s *sqlStore) UpdateQuestFlag(guildcard uint32, slotNum uint32, difficulty byte, flag uint16, set bool) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		_, err := tx.Exec(s.dialect.rebind("DELETE FROM character_quest_flags "+
+			"WHERE guildcard = ? AND slot = ? AND difficulty = ? AND flag = ?"),
+			guildcard, slotNum, difficulty, flag)
+		if err != nil || !set {
+			return err
+		}
+		_, err = tx.Exec(s.dialect.rebind("INSERT INTO character_quest_flags "+
+			"(guildcard, slot, difficulty, flag) VALUES ("+placeholders(4)+")"),
+			guildcard, slotNum, difficulty, flag)
+		return err
+	})
+}
+
+func (s *sqlStore) FindChallengeRecord(guildcard uint32, slotNum uint32) (*ChallengeRecord, error) {
+	record := new(ChallengeRecord)
+	err := s.queryRow("SELECT title, title_color, awards_ep1, awards_ep2 FROM character_challenges "+
+		"WHERE guildcard = ? AND slot = ?", guildcard, slotNum).Scan(
+		&record.Title, &record.TitleColor, &record.Awards[0], &record.Awards[1])
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	rows, err := s.query("SELECT episode, stage, clear_time FROM character_challenge_times "+
+		"WHERE guildcard = ? AND slot = ?", guildcard, slotNum)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var episode byte
+		var stage int
+		var clearTime uint32
+		if err = rows.Scan(&episode, &stage, &clearTime); err != nil {
+			return nil, err
+		}
+		if times := record.Times(episode); stage < len(times) {
+			times[stage] = clearTime
+		}
+	}
+	return record, rows.Err()
+}
+
+func (s *sqlStore) UpdateChallengeRecord(guildcard uint32, slotNum uint32, record *ChallengeRecord) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		return s.replaceChallengeRecord(tx, guildcard, slotNum, record)
+	})
+}
+
+func (s *sqlStore) UpdateCharacterAndChallengeRecord(guildcard uint32, slotNum uint32, character *Character,
+	inventory []Item, record *ChallengeRecord) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		args := append(characterValues(character), guildcard, slotNum)
+		_, err := tx.Exec(s.dialect.rebind("UPDATE characters SET "+assignments(characterColumns)+
+			" WHERE guildcard = ? AND slot = ?"), args...)
+		if err != nil {
+			return err
+		}
+		if err = s.replaceItems(tx, guildcard, slotNum, ItemLocationInventory, inventory); err != nil {
+			return err
+		}
+		return s.replaceChallengeRecord(tx, guildcard, slotNum, record)
+	})
+}
+
+func (s *sqlStore) replaceChallengeRecord(tx *sql.Tx, guildcard uint32, slotNum uint32, record *ChallengeRecord) error {
+	for _, table := range []string{"character_challenges", "character_challenge_times"} {
+		_, err := tx.Exec(s.dialect.rebind("DELETE FROM "+table+
+			" WHERE guildcard = ? AND slot = ?"), guildcard, slotNum)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := tx.Exec(s.dialect.rebind("INSERT INTO character_challenges "+
+		"(guildcard, slot, title, title_color, awards_ep1, awards_ep2) VALUES ("+placeholders(6)+")"),
+		guildcard, slotNum, record.Title, record.TitleColor, record.Awards[0], record.Awards[1])
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(s.dialect.rebind("INSERT INTO character_challenge_times " +
+		"(guildcard, slot, episode, stage, clear_time) VALUES (" + placeholders(5) + ")"))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for episode := byte(1); episode <= 2; episode++ {
+		for stage, clearTime := range record.Times(episode) {
+			if clearTime == 0 {
+				continue
+			}
+			if _, err = stmt.Exec(guildcard, slotNum, episode, stage, clearTime); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+const battleRecordColumns = "place1, place2, place3, place4, " +
+	"disconnects1, disconnects2, disconnects3, disconnects4, kills, deaths"
+
+func battleRecordValues(r *BattleRecord) []interface{} {
+	return []interface{}{r.Places[0], r.Places[1], r.Places[2], r.Places[3],
+		r.Disconnects[0], r.Disconnects[1], r.Disconnects[2], r.Disconnects[3], r.Kills, r.Deaths}
+}
+
+func battleRecordDest(r *BattleRecord) []interface{} {
+	return []interface{}{&r.Places[0], &r.Places[1], &r.Places[2], &r.Places[3],
+		&r.Disconnects[0], &r.Disconnects[1], &r.Disconnects[2], &r.Disconnects[3], &r.Kills, &r.Deaths}
+}
+
+func (s *sqlStore) FindBattleRecord(guildcard uint32, slotNum uint32) (*BattleRecord, error) {
+	record := new(BattleRecord)
+	err := s.queryRow("SELECT "+battleRecordColumns+" FROM character_battle_records "+
+		"WHERE guildcard = ? AND slot = ?", guildcard, slotNum).Scan(battleRecordDest(record)...)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (s *sqlStore) UpdateBattleRecord(guildcard uint32, slotNum uint32, record *BattleRecord) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		_, err := tx.Exec(s.dialect.rebind("DELETE FROM character_battle_records "+
+			"WHERE guildcard = ? AND slot = ?"), guildcard, slotNum)
+		if err != nil {
+			return err
+		}
+		args := append([]interface{}{guildcard, slotNum}, battleRecordValues(record)...)
+		_, err = tx.Exec(s.dialect.rebind("INSERT INTO character_battle_records (guildcard, slot, "+
+			battleRecordColumns+") VALUES ("+placeholders(len(args))+")"), args...)
+		return err
+	})
+}
+
+func (s *sqlStore) CompleteTrade(trade *Trade, characters [2]*Character, inventories [2][]Item) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		for i, side := range trade.Sides {
+			args := append(characterValues(characters[i]), side.Guildcard, side.SlotNum)
+			_, err := tx.Exec(s.dialect.rebind("UPDATE characters SET "+assignments(characterColumns)+
+				" WHERE guildcard = ? AND slot = ?"), args...)
+			if err != nil {
+				return err
+			}
+			err = s.replaceItems(tx, side.Guildcard, side.SlotNum, ItemLocationInventory, inventories[i])
+			if err != nil {
+				return err
+			}
+		}
+
+		first, second := trade.Sides[0], trade.Sides[1]
+		id, err := s.insertId(tx, "INSERT INTO trades (traded_at, guildcard1, slot1, meseta1, "+
+			"guildcard2, slot2, meseta2) VALUES ("+placeholders(7)+")", trade.TradedAt.UTC(),
+			first.Guildcard, first.SlotNum, first.Meseta, second.Guildcard, second.SlotNum, second.Meseta)
+		if err != nil {
+			return err
+		}
+		stmt, err := tx.Prepare(s.dialect.rebind("INSERT INTO trade_items (trade_id, side, " +
+			"position, data, data2) VALUES (" + placeholders(5) + ")"))
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for side := range trade.Sides {
+			for i, item := range trade.Sides[side].Items {
+				if _, err = stmt.Exec(id, side, i, item.Data, item.Data2); err != nil {
+					return err
+				}
+			}
+		}
+		trade.Id = id
+		return nil
+	})
+}
+
+func (s *sqlStore) FindBank(guildcard uint32, slotNum uint32) (*Bank, error) {
+	bank := new(Bank)
+	err := s.queryRow("SELECT meseta FROM banks WHERE guildcard = ? AND slot = ?",
+		guildcard, slotNum).Scan(&bank.Meseta)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	bank.Items, err = s.FindItems(guildcard, slotNum, ItemLocationBank)
+	if err != nil {
+		return nil, err
+	}
+	return bank, nil
+}
+
+func (s *sqlStore) UpdateBank(guildcard uint32, slotNum uint32, bank *Bank) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		return s.replaceBank(tx, guildcard, slotNum, bank)
+	})
+}
+
+func (s *sqlStore) UpdateCharacterAndBank(guildcard uint32, slotNum uint32, character *Character,
+	inventory []Item, bankSlot uint32, bank *Bank) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		args := append(characterValues(character), guildcard, slotNum)
+		_, err := tx.Exec(s.dialect.rebind("UPDATE characters SET "+assignments(characterColumns)+
+			" WHERE guildcard = ? AND slot = ?"), args...)
+		if err != nil {
+			return err
+		}
+		if err = s.replaceItems(tx, guildcard, slotNum, ItemLocationInventory, inventory); err != nil {
+			return err
+		}
+		return s.replaceBank(tx, guildcard, bankSlot, bank)
+	})
+}
+
+func (s *sqlStore) replaceBank(tx *sql.Tx, guildcard uint32, slotNum uint32, bank *Bank) error {
+	res, err := tx.Exec(s.dialect.rebind("UPDATE banks SET meseta = ? "+
+		"WHERE guildcard = ? AND slot = ?"), bank.Meseta, guildcard, slotNum)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		_, err = tx.Exec(s.dialect.rebind("INSERT INTO banks (guildcard, slot, meseta) "+
+			"VALUES (?, ?, ?)"), guildcard, slotNum, bank.Meseta)
+		if err != nil {
+			return err
+		}
+	}
+	return s.replaceItems(tx, guildcard, slotNum, ItemLocationBank, bank.Items)
+}
+
+func (s *sqlStore) CreateTeam(team *Team, master *TeamMember) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		var count int
+		err := tx.QueryRow(s.dialect.rebind("SELECT COUNT(*) FROM teams WHERE name = ?"),
+			fromUtf16(team.Name)).Scan(&count)
+		if err != nil {
+			return err
+		} else if count > 0 {
+			return ErrTeamNameTaken
+		}
+		id, err := s.insertId(tx, "INSERT INTO teams (name, flag, created_at) VALUES ("+placeholders(3)+")",
+			fromUtf16(team.Name), team.Flag, team.CreatedAt.UTC())
+		if err != nil {
+			return err
+		}
+		team.Id = id
+		master.TeamId = id
+		return s.addTeamMember(tx, master)
+	})
+}
+
+func (s *sqlStore) FindTeam(id int64) (*Team, error) {
+	team := &Team{Id: id}
+	var name []byte
+	err := s.queryRow("SELECT name, flag, created_at FROM teams WHERE id = ?", id).Scan(
+		&name, &team.Flag, &team.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	team.Name = toUtf16(name)
+	return team, nil
+}
+
+func (s *sqlStore) FindTeamMembers(teamId int64) ([]TeamMember, error) {
+	rows, err := s.query("SELECT guildcard, name, privilege, joined_at FROM team_members "+
+		"WHERE team_id = ? ORDER BY joined_at, guildcard", teamId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []TeamMember
+	for rows.Next() {
+		member := TeamMember{TeamId: teamId}
+		var name []byte
+		if err = rows.Scan(&member.Guildcard, &name, &member.Privilege, &member.JoinedAt); err != nil {
+			return nil, err
+		}
+		member.Name = toUtf16(name)
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+func (s *sqlStore) FindTeamNames(guildcards []uint32) (map[uint32][]uint16, error) {
+	names := make(map[uint32][]uint16)
+	if len(guildcards) == 0 {
+		return names, nil
+	}
+	args := make([]interface{}, len(guildcards))
+	for i, guildcard := range guildcards {
+		args[i] = guildcard
+	}
+	rows, err := s.query("SELECT team_members.guildcard, teams.name FROM team_members "+
+		"JOIN teams ON teams.id = team_members.team_id "+
+		"WHERE team_members.guildcard IN ("+placeholders(len(args))+")", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var guildcard uint32
+		var name []byte
+		if err = rows.Scan(&guildcard, &name); err != nil {
+			return nil, err
+		}
+		names[guildcard] = toUtf16(name)
+	}
+	return names, rows.Err()
+}
+
+func (s *sqlStore) AddTeamMember(member *TeamMember) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		return s.addTeamMember(tx, member)
+	})
+}
+
+func (s *sqlStore) addTeamMember(tx *sql.Tx, member *TeamMember) error {
+	_, err := tx.Exec(s.dialect.rebind("INSERT INTO team_members "+
+		"(team_id, guildcard, name, privilege, joined_at) VALUES ("+placeholders(5)+")"),
+		member.TeamId, member.Guildcard, fromUtf16(member.Name), member.Privilege, member.JoinedAt.UTC())
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(s.dialect.rebind("UPDATE accounts SET team_id = ? WHERE guildcard = ?"),
+		member.TeamId, member.Guildcard)
+	return err
+}
+
+func (s *sqlStore) RemoveTeamMember(teamId int64, guildcard uint32) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		_, err := tx.Exec(s.dialect.rebind("DELETE FROM team_members WHERE team_id = ? AND guildcard = ?"),
+			teamId, guildcard)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(s.dialect.rebind("UPDATE accounts SET team_id = 0 WHERE guildcard = ? AND team_id = ?"),
+			guildcard, teamId)
+		return err
+	})
+}
+
+func (s *sqlStore) UpdateTeamFlag(teamId int64, flag []byte) error {
+	_, err := s.exec("UPDATE teams SET flag = ? WHERE id = ?", flag, teamId)
+	return err
+}
+
+func (s *sqlStore) DeleteTeam(id int64) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		queries := []string{
+			"UPDATE accounts SET team_id = 0 WHERE team_id = ?",
+			"DELETE FROM team_members WHERE team_id = ?",
+			"DELETE FROM teams WHERE id = ?",
+		}
+		for _, query := range queries {
+			if _, err := tx.Exec(s.dialect.rebind(query), id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *sqlStore) FindGuildcardData(guildcard uint32) ([]GuildcardEntry, error) {
+	rows, err := s.query("SELECT friend_guildcard, name, team_name, description, "+
+		"language, section_id, class, comment FROM guildcard_entries WHERE guildcard = ? "+
+		"ORDER BY friend_guildcard", guildcard)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var guildcards []GuildcardEntry
+	for rows.Next() {
+		entry := GuildcardEntry{Guildcard: int(guildcard)}
+		var name, teamName, description, comment []byte
+		err = rows.Scan(&entry.FriendGuildcard, &name, &teamName, &description,
+			&entry.Language, &entry.SectionID, &entry.Class, &comment)
+		if err != nil {
+			return nil, err
+		}
+		entry.Name = toUtf16(name)
+		entry.TeamName = toUtf16(teamName)
+		entry.Description = toUtf16(description)
+		entry.Comment = toUtf16(comment)
+		guildcards = append(guildcards, entry)
+	}
+	return guildcards, rows.Err()
+}
+
+func (s *sqlStore) AddGuildcard(entry *GuildcardEntry) error {
+	res, err := s.exec("UPDATE guildcard_entries SET name = ?, team_name = ?, description = ?, "+
+		"language = ?, section_id = ?, class = ? WHERE guildcard = ? AND friend_guildcard = ?",
+		fromUtf16(entry.Name), fromUtf16(entry.TeamName), fromUtf16(entry.Description),
+		entry.Language, entry.SectionID, entry.Class, entry.Guildcard, entry.FriendGuildcard)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	_, err = s.exec("INSERT INTO guildcard_entries (guildcard, friend_guildcard, name, "+
+		"team_name, description, language, section_id, class, comment) VALUES ("+
+		placeholders(9)+")", entry.Guildcard, entry.FriendGuildcard, fromUtf16(entry.Name),
+		fromUtf16(entry.TeamName), fromUtf16(entry.Description), entry.Language,
+		entry.SectionID, entry.Class, fromUtf16(entry.Comment))
+	return err
+}
+
+func (s *sqlStore) RemoveGuildcard(guildcard uint32, friendGuildcard uint32) error {
+	_, err := s.exec("DELETE FROM guildcard_entries WHERE guildcard = ? AND friend_guildcard = ?",
+		guildcard, friendGuildcard)
+	return err
+}
+
+func (s *sqlStore) UpdateGuildcardComment(guildcard uint32, friendGuildcard uint32, comment []uint16) error {
+	_, err := s.exec("UPDATE guildcard_entries SET comment = ? "+
+		"WHERE guildcard = ? AND friend_guildcard = ?", fromUtf16(comment), guildcard, friendGuildcard)
+	return err
+}
+
+func (s *sqlStore) FindBlockedGuildcards(guildcard uint32) ([]BlockedGuildcard, error) {
+	rows, err := s.query("SELECT blocked_guildcard, name, team_name, description, "+
+		"language, section_id, class FROM blocked_guildcards WHERE guildcard = ?", guildcard)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var blocked []BlockedGuildcard
+	for rows.Next() {
+		entry := BlockedGuildcard{Guildcard: int(guildcard)}
+		var name, teamName, description []byte
+		err = rows.Scan(&entry.BlockedGuildcard, &name, &teamName, &description,
+			&entry.Language, &entry.SectionID, &entry.Class)
+		if err != nil {
+			return nil, err
+		}
+		entry.Name = toUtf16(name)
+		entry.TeamName = toUtf16(teamName)
+		entry.Description = toUtf16(description)
+		blocked = append(blocked, entry)
+	}
+	return blocked, rows.Err()
+}
+
+func (s *sqlStore) AddBlockedGuildcard(entry *BlockedGuildcard) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		_, err := tx.Exec(s.dialect.rebind("DELETE FROM blocked_guildcards "+
+			"WHERE guildcard = ? AND blocked_guildcard = ?"), entry.Guildcard, entry.BlockedGuildcard)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(s.dialect.rebind("INSERT INTO blocked_guildcards (guildcard, "+
+			"blocked_guildcard, name, team_name, description, language, section_id, class) "+
+			"VALUES ("+placeholders(8)+")"), entry.Guildcard, entry.BlockedGuildcard,
+			fromUtf16(entry.Name), fromUtf16(entry.TeamName), fromUtf16(entry.Description),
+			entry.Language, entry.SectionID, entry.Class)
+		return err
+	})
+}
+
+func (s *sqlStore) RemoveBlockedGuildcard(guildcard uint32, blockedGuildcard uint32) error {
+	_, err := s.exec("DELETE FROM blocked_guildcards WHERE guildcard = ? AND blocked_guildcard = ?",
+		guildcard, blockedGuildcard)
+	return err
+}
+
+func (s *sqlStore) CreateMail(mail *Mail) error {
+	_, err := s.exec("INSERT INTO mail (recipient, sender, sender_name, sent_at, message) "+
+		"VALUES ("+placeholders(5)+")", mail.Recipient, mail.Sender, fromUtf16(mail.SenderName),
+		mail.SentAt.UTC(), fromUtf16(mail.Message))
+	return err
+}
+
+func (s *sqlStore) CountMail(recipient uint32) (int, error) {
+	var count int
+	err := s.queryRow("SELECT COUNT(*) FROM mail WHERE recipient = ?", recipient).Scan(&count)
+	return count, err
+}
+
+func (s *sqlStore) FindMail(recipient uint32) ([]Mail, error) {
+	rows, err := s.query("SELECT id, sender, sender_name, sent_at, message FROM mail "+
+		"WHERE recipient = ? ORDER BY id", recipient)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mail []Mail
+	for rows.Next() {
+		m := Mail{Recipient: recipient}
+		var senderName, message []byte
+		if err = rows.Scan(&m.Id, &m.Sender, &senderName, &m.SentAt, &message); err != nil {
+			return nil, err
+		}
+		m.SenderName = toUtf16(senderName)
+		m.Message = toUtf16(message)
+		mail = append(mail, m)
+	}
+	return mail, rows.Err()
+}
+
+func (s *sqlStore) DeleteMail(id int64) error {
+	_, err := s.exec("DELETE FROM mail WHERE id = ?", id)
+	return err
+}
+
+func (s *sqlStore) CreateBan(ban *Ban) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		return s.createBan(tx, ban)
+	})
+}
+
+func (s *sqlStore) createBan(tx *sql.Tx, ban *Ban) error {
+	var expires interface{}
+	if !ban.Permanent() {
+		expires = ban.ExpiresAt.UTC()
+	}
+	id, err := s.insertId(tx, "INSERT INTO bans (ban_type, target, reason, issued_by, "+
+		"issued_at, expires_at, lifted) VALUES ("+placeholders(7)+")", ban.Type, ban.Target,
+		ban.Reason, ban.IssuedBy, ban.IssuedAt.UTC(), expires, false)
+	if err != nil {
+		return err
+	}
+	ban.Id = id
+	return s.auditBan(tx, id, BanActionIssued, ban.IssuedBy, ban.Reason)
+}
+
+func (s *sqlStore) LiftBan(id int64, actor string, reason string) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		res, err := tx.Exec(s.dialect.rebind("UPDATE bans SET lifted = ? WHERE id = ? AND lifted = ?"),
+			true, id, false)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrBanNotFound
+		}
+		return s.auditBan(tx, id, BanActionLifted, actor, reason)
+	})
+}
+
+func (s *sqlStore) auditBan(tx *sql.Tx, id int64, action string, actor string, reason string) error {
+	_, err := tx.Exec(s.dialect.rebind("INSERT INTO ban_audit (ban_id, action, actor, reason, "+
+		"created_at) VALUES ("+placeholders(5)+")"), id, action, actor, reason, time.Now().UTC())
+	return err
+}
+
+// Find the bans that are in effect, optionally limited to those matching a condition.
+func (s *sqlStore) findActiveBans(where string, args ...interface{}) ([]Ban, error) {
+	query := "SELECT id, ban_type, target, reason, issued_by, issued_at, expires_at, lifted " +
+		"FROM bans WHERE lifted = ? AND (expires_at IS NULL OR expires_at > ?)"
+	if where != "" {
+		query += " AND (" + where + ")"
+	}
+	rows, err := s.query(query+" ORDER BY id DESC", append([]interface{}{false, time.Now().UTC()}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bans []Ban
+	for rows.Next() {
+		var ban Ban
+		var expires sql.NullTime
+		err = rows.Scan(&ban.Id, &ban.Type, &ban.Target, &ban.Reason, &ban.IssuedBy,
+			&ban.IssuedAt, &expires, &ban.Lifted)
+		if err != nil {
+			return nil, err
+		}
+		if expires.Valid {
+			ban.ExpiresAt = expires.Time
+		}
+		bans = append(bans, ban)
+	}
+	return bans, rows.Err()
+}
+
+func (s *sqlStore) FindActiveBan(targets []BanTarget) (*Ban, error) {
+	if len(targets) == 0 {
+		return nil, nil
+	}
+	conditions := make([]string, len(targets))
+	args := make([]interface{}, 0, 2*len(targets))
+	for i, target := range targets {
+		conditions[i] = "(ban_type = ? AND target = ?)"
+		args = append(args, target.Type, target.Target)
+	}
+	bans, err := s.findActiveBans(strings.Join(conditions, " OR "), args...)
+	if err != nil || len(bans) == 0 {
+		return nil, err
+	}
+
+	longest := &bans[0]
+	for i := range bans {
+		if bans[i].Permanent() {
+			return &bans[i], nil
+		} else if bans[i].ExpiresAt.After(longest.ExpiresAt) {
+			longest = &bans[i]
+		}
+	}
+	return longest, nil
+}
+
+func (s *sqlStore) FindActiveBans() ([]Ban, error) {
+	return s.findActiveBans("")
+}
+
+func (s *sqlStore) FindBanAudit(banId int64) ([]BanAuditEntry, error) {
+	rows, err := s.query("SELECT id, action, actor, reason, created_at FROM ban_audit "+
+		"WHERE ban_id = ? ORDER BY id", banId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []BanAuditEntry
+	for rows.Next() {
+		entry := BanAuditEntry{BanId: banId}
+		err = rows.Scan(&entry.Id, &entry.Action, &entry.Actor, &entry.Reason, &entry.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (s *sqlStore) RecordHardware(username string, hardwareId string, ip string, seen time.Time) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		res, err := tx.Exec(s.dialect.rebind("UPDATE account_hardware SET last_seen = ?, last_ip = ? "+
+			"WHERE username = ? AND hardware_id = ?"), seen.UTC(), ip, username, hardwareId)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil || n > 0 {
+			return err
+		}
+		_, err = tx.Exec(s.dialect.rebind("INSERT INTO account_hardware (username, hardware_id, "+
+			"first_seen, last_seen, last_ip) VALUES (?, ?, ?, ?, ?)"),
+			username, hardwareId, seen.UTC(), seen.UTC(), ip)
+		return err
+	})
+}
+
+func (s *sqlStore) FindAccountHardware(username string) ([]AccountHardware, error) {
+	return s.findHardware("WHERE username = ? ORDER BY last_seen DESC", username)
+}
+
+func (s *sqlStore) FindHardwareAccounts(hardwareId string) ([]AccountHardware, error) {
+	return s.findHardware("WHERE hardware_id = ? ORDER BY last_seen DESC", hardwareId)
+}
+
+func (s *sqlStore) FindSharedHardware() ([]AccountHardware, error) {
+	return s.findHardware("WHERE hardware_id IN (SELECT hardware_id FROM account_hardware " +
+		"GROUP BY hardware_id HAVING COUNT(*) > 1) ORDER BY hardware_id, last_seen DESC")
+}
+
+func (s *sqlStore) findHardware(where string, args ...interface{}) ([]AccountHardware, error) {
+	rows, err := s.query("SELECT username, hardware_id, first_seen, last_seen, last_ip "+
+		"FROM account_hardware "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []AccountHardware
+	for rows.Next() {
+		var record AccountHardware
+		err = rows.Scan(&record.Username, &record.HardwareId, &record.FirstSeen,
+			&record.LastSeen, &record.LastIP)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+func (s *sqlStore) CreateItemAudit(entries []ItemAuditEntry) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare(s.dialect.rebind("INSERT INTO item_audit (created_at, guildcard, " +
+			"action, item_id, data, data2, meseta, partner) VALUES (" + placeholders(8) + ")"))
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, e := range entries {
+			_, err = stmt.Exec(e.CreatedAt.UTC(), e.Guildcard, e.Action, e.Item.ItemId,
+				nonNil(e.Item.Data), nonNil(e.Item.Data2), e.Meseta, e.Partner)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *sqlStore) FindGuildcardItemAudit(guildcard uint32, limit int) ([]ItemAuditEntry, error) {
+	return s.findItemAudit("WHERE guildcard = ? OR partner = ? ORDER BY id DESC LIMIT ?",
+		guildcard, guildcard, limit)
+}
+
+func (s *sqlStore) FindItemAudit(itemData []byte) ([]ItemAuditEntry, error) {
+	return s.findItemAudit("WHERE data = ? ORDER BY id", itemData)
+}
+
+func (s *sqlStore) findItemAudit(where string, args ...interface{}) ([]ItemAuditEntry, error) {
+	rows, err := s.query("SELECT id, created_at, guildcard, action, item_id, data, data2, meseta, "+
+		"partner FROM item_audit "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []ItemAuditEntry
+	for rows.Next() {
+		var e ItemAuditEntry
+		err = rows.Scan(&e.Id, &e.CreatedAt, &e.Guildcard, &e.Action, &e.Item.ItemId, &e.Item.Data,
+			&e.Item.Data2, &e.Meseta, &e.Partner)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func (s *sqlStore) CreateCheatFlag(flag *CheatFlag) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		id, err := s.insertId(tx, "INSERT INTO cheat_flags (guildcard, rule, details, created_at) "+
+			"VALUES ("+placeholders(4)+")", flag.Guildcard, flag.Rule, flag.Details, flag.CreatedAt.UTC())
+		flag.Id = id
+		return err
+	})
+}
+
+func (s *sqlStore) FindCheatFlags(guildcard uint32) ([]CheatFlag, error) {
+	return s.findCheatFlags("WHERE guildcard = ? ORDER BY id DESC", guildcard)
+}
+
+func (s *sqlStore) FindRecentCheatFlags(limit int) ([]CheatFlag, error) {
+	return s.findCheatFlags("ORDER BY id DESC LIMIT ?", limit)
+}
+
+func (s *sqlStore) findCheatFlags(where string, args ...interface{}) ([]CheatFlag, error) {
+	rows, err := s.query("SELECT id, guildcard, rule, details, created_at FROM cheat_flags "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var flags []CheatFlag
+	for rows.Next() {
+		var flag CheatFlag
+		if err = rows.Scan(&flag.Id, &flag.Guildcard, &flag.Rule, &flag.Details, &flag.CreatedAt); err != nil {
+			return nil, err
+		}
+		flags = append(flags, flag)
+	}
+	return flags, rows.Err()
+}
+
+func (s *sqlStore) CreateLoginAttempt(attempt *LoginAttempt) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		id, err := s.insertId(tx, "INSERT INTO login_attempts (created_at, username, guildcard, ip, "+
+			"version, client_version, result) VALUES ("+placeholders(7)+")",
+			attempt.CreatedAt.UTC(), attempt.Username, attempt.Guildcard, attempt.IP,
+			attempt.Version, attempt.ClientVersion, attempt.Result)
+		attempt.Id = id
+		return err
+	})
+}
+
+func (s *sqlStore) FindLoginAttempts(username string, ip string, limit int) ([]LoginAttempt, error) {
+	var conditions []string
+	var args []interface{}
+	if username != "" {
+		conditions = append(conditions, "username = ?")
+		args = append(args, username)
+	}
+	if ip != "" {
+		conditions = append(conditions, "ip = ?")
+		args = append(args, ip)
+	}
+	query := "SELECT id, created_at, username, guildcard, ip, version, client_version, result " +
+		"FROM login_attempts"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	rows, err := s.query(query+" ORDER BY id DESC LIMIT ?", append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []LoginAttempt
+	for rows.Next() {
+		var attempt LoginAttempt
+		err = rows.Scan(&attempt.Id, &attempt.CreatedAt, &attempt.Username, &attempt.Guildcard,
+			&attempt.IP, &attempt.Version, &attempt.ClientVersion, &attempt.Result)
+		if err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, attempt)
+	}
+	return attempts, rows.Err()
+}
+
+func (s *sqlStore) PurgeLoginAttempts(before time.Time) (int, error) {
+	res, err := s.exec("DELETE FROM login_attempts WHERE created_at < ?", before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (s *sqlStore) SumMeseta() (uint64, error) {
+	var characters, banks uint64
+	err := s.queryRow("SELECT COALESCE(SUM(meseta), 0) FROM characters WHERE slot < ?",
+		FirstDeletedSlot).Scan(&characters)
+	if err != nil {
+		return 0, err
+	}
+	err = s.queryRow("SELECT COALESCE(SUM(meseta), 0) FROM banks WHERE slot < ?",
+		FirstDeletedSlot).Scan(&banks)
+	return characters + banks, err
+}
+
+func (s *sqlStore) CountItemTypes() (map[[3]byte]int, error) {
+	rows, err := s.query("SELECT data FROM character_items WHERE slot < ?", FirstDeletedSlot)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[[3]byte]int)
+	for rows.Next() {
+		var itemData []byte
+		if err = rows.Scan(&itemData); err != nil {
+			return nil, err
+		}
+		var itemType [3]byte
+		copy(itemType[:], itemData)
+		counts[itemType]++
+	}
+	return counts, rows.Err()
+}
+
+func (s *sqlStore) CountTrades(from, to time.Time) (int, uint64, error) {
+	var trades int
+	var meseta uint64
+	err := s.queryRow("SELECT COUNT(*), COALESCE(SUM(meseta1 + meseta2), 0) FROM trades "+
+		"WHERE traded_at >= ? AND traded_at < ?", from.UTC(), to.UTC()).Scan(&trades, &meseta)
+	return trades, meseta, err
+}
+
+func (s *sqlStore) CountActiveAccounts(from, to time.Time) (int, error) {
+	var count int
+	err := s.queryRow("SELECT COUNT(DISTINCT username) FROM account_hardware "+
+		"WHERE last_seen >= ? AND last_seen < ?", from.UTC(), to.UTC()).Scan(&count)
+	return count, err
+}
+
+func (s *sqlStore) SaveEconomyStats(stats *EconomyStats) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		day := stats.Day.UTC()
+		var count int
+		err := tx.QueryRow(s.dialect.rebind("SELECT COUNT(*) FROM economy_stats WHERE day = ?"),
+			day).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			_, err = tx.Exec(s.dialect.rebind("UPDATE economy_stats SET meseta_supply = ?, "+
+				"rare_items = ?, trades = ?, trade_meseta = ?, active_players = ?, peak_online = ?, "+
+				"updated_at = ? WHERE day = ?"), stats.MesetaSupply, stats.RareItems, stats.Trades,
+				stats.TradeMeseta, stats.ActivePlayers, stats.PeakOnline, stats.UpdatedAt.UTC(), day)
+			return err
+		}
+		_, err = tx.Exec(s.dialect.rebind("INSERT INTO economy_stats (day, meseta_supply, rare_items, "+
+			"trades, trade_meseta, active_players, peak_online, updated_at) VALUES ("+placeholders(8)+")"),
+			day, stats.MesetaSupply, stats.RareItems, stats.Trades, stats.TradeMeseta,
+			stats.ActivePlayers, stats.PeakOnline, stats.UpdatedAt.UTC())
+		return err
+	})
+}
+
+func (s *sqlStore) FindEconomyStats(from time.Time) ([]EconomyStats, error) {
+	rows, err := s.query("SELECT day, meseta_supply, rare_items, trades, trade_meseta, "+
+		"active_players, peak_online, updated_at FROM economy_stats WHERE day >= ? ORDER BY day",
+		from.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var days []EconomyStats
+	for rows.Next() {
+		var stats EconomyStats
+		err = rows.Scan(&stats.Day, &stats.MesetaSupply, &stats.RareItems, &stats.Trades,
+			&stats.TradeMeseta, &stats.ActivePlayers, &stats.PeakOnline, &stats.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		days = append(days, stats)
+	}
+	return days, rows.Err()
+}
+
+// Returns b, or an empty slice if it's nil so that it isn't stored as NULL.
+func nonNil(b []byte) []byte {
+	if b == nil {
+		return []byte{}
+	}
+	return b
+}
+
+// Builds a comma-separated list of n placeholders for an INSERT.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// The UTF-16 text fields are stored as their raw little endian bytes.
+func toUtf16(b []byte) []uint16 {
+	s := make([]uint16, len(b)/2)
+	for i := range s {
+		s[i] = binary.LittleEndian.Uint16(b[i*2:])
+	}
+	return s
+}
+
+func fromUtf16(s []uint16) []byte {
+	b := make([]byte, len(s)*2)
+	for i, c := range s {
+		binary.LittleEndian.PutUint16(b[i*2:], c)
+	}
+	return b
+}
@@ -0,0 +1,309 @@
+package data
+
+import (
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Returns a store backed by a fresh SQLite database that's removed along with
+// the test's temporary directory.
+func openTestStore(t *testing.T) Store {
+	store, err := Open(Config{Driver: "sqlite", Name: filepath.Join(t.TempDir(), "archon.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	if err = store.Migrate(LatestSchema); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestAppearanceColumnsMatchValues(t *testing.T) {
+	columns := strings.Split(appearanceColumns, ", ")
+	values := appearanceValues(new(Character))
+	if len(columns) != len(values) {
+		t.Fatalf("%d appearance columns but %d values", len(columns), len(values))
+	}
+	if placeholders := strings.Count(assignments(appearanceColumns), "?"); placeholders != len(columns) {
+		t.Errorf("%d placeholders for %d columns", placeholders, len(columns))
+	}
+}
+
+func TestUpdateAppearance(t *testing.T) {
+	store := openTestStore(t)
+	const guildcard, slot = 42000001, 2
+	original := &Character{
+		Experience: 1000, Level: 10, Class: 3, Playtime: 3600, ATP: 50, Meseta: 300,
+		NameColor: 1, Model: 2, NameColorChecksum: 3, SectionID: 4,
+		Costume: 5, Skin: 6, Face: 7, Head: 8, Hair: 9,
+		HairRed: 10, HairGreen: 11, HairBlue: 12,
+		ProportionX: 0.25, ProportionY: 0.5,
+		Name: []byte("\tEOld\x00"), GuildcardStr: []byte("42000001"),
+	}
+	if err := store.CreateCharacter(guildcard, slot, original); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every field gets a value of its own so that one bound to the wrong column
+	// shows up. The stats and progress are changed too, and shouldn't be saved.
+	updated := &Character{
+		Experience: 9999, Level: 99, Class: 7, Playtime: 1, ATP: 1, Meseta: 1,
+		NameColor: 101, Model: 102, NameColorChecksum: 103, SectionID: 104,
+		Costume: 105, Skin: 106, Face: 107, Head: 108, Hair: 109,
+		HairRed: 110, HairGreen: 111, HairBlue: 112,
+		ProportionX: 0.75, ProportionY: 0.125,
+		Name: []byte("\tENew\x00"),
+	}
+	if err := store.UpdateAppearance(guildcard, slot, updated); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := store.FindCharacter(guildcard, slot)
+	if err != nil {
+		t.Fatal(err)
+	} else if saved == nil {
+		t.Fatal("character disappeared")
+	}
+	appearance := []struct {
+		field       string
+		got, wanted interface{}
+	}{
+		{"NameColor", saved.NameColor, updated.NameColor},
+		{"Model", saved.Model, updated.Model},
+		{"NameColorChecksum", saved.NameColorChecksum, updated.NameColorChecksum},
+		{"SectionID", saved.SectionID, updated.SectionID},
+		{"Costume", saved.Costume, updated.Costume},
+		{"Skin", saved.Skin, updated.Skin},
+		{"Face", saved.Face, updated.Face},
+		{"Head", saved.Head, updated.Head},
+		{"Hair", saved.Hair, updated.Hair},
+		{"HairRed", saved.HairRed, updated.HairRed},
+		{"HairGreen", saved.HairGreen, updated.HairGreen},
+		{"HairBlue", saved.HairBlue, updated.HairBlue},
+		{"ProportionX", saved.ProportionX, updated.ProportionX},
+		{"ProportionY", saved.ProportionY, updated.ProportionY},
+		{"Name", saved.Name, updated.Name},
+		{"Experience", saved.Experience, original.Experience},
+		{"Level", saved.Level, original.Level},
+		{"Class", saved.Class, original.Class},
+		{"Playtime", saved.Playtime, original.Playtime},
+		{"ATP", saved.ATP, original.ATP},
+		{"Meseta", saved.Meseta, original.Meseta},
+	}
+	for _, check := range appearance {
+		if !reflect.DeepEqual(check.got, check.wanted) {
+			t.Errorf("%s saved as %v, expected %v", check.field, check.got, check.wanted)
+		}
+	}
+}
+
+func TestUpdateAppearanceLeavesOtherSlots(t *testing.T) {
+	store := openTestStore(t)
+	const guildcard = 42000001
+	for slot := uint32(0); slot < 2; slot++ {
+		if err := store.CreateCharacter(guildcard, slot, &Character{
+			GuildcardStr: []byte("42000001"), Face: 1, Hair: 1, Name: []byte("x"),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.UpdateAppearance(guildcard, 1, &Character{Face: 2, Hair: 3, Name: []byte("y")}); err != nil {
+		t.Fatal(err)
+	}
+	other, err := store.FindCharacter(guildcard, 0)
+	if err != nil {
+		t.Fatal(err)
+	} else if other.Face != 1 || other.Hair != 1 || string(other.Name) != "x" {
+		t.Errorf("character in slot 0 was changed to face %d, hair %d, name %q", other.Face, other.Hair, other.Name)
+	}
+}
+
+func TestCharacterNameKeyIsUnique(t *testing.T) {
+	store := openTestStore(t)
+	newCharacter := func(key string) *Character {
+		return &Character{GuildcardStr: []byte("42000001"), Name: []byte("x"), NameKey: key}
+	}
+	if err := store.CreateCharacter(42000001, 0, newCharacter("gm")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CreateCharacter(42000002, 0, newCharacter("gm")); err != ErrCharacterNameTaken {
+		t.Fatalf("created a character with a taken key: %v", err)
+	}
+	// Characters that don't need unique names can share the empty key.
+	for guildcard := uint32(42000003); guildcard < 42000005; guildcard++ {
+		if err := store.CreateCharacter(guildcard, 0, newCharacter("")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.UpdateAppearance(42000003, 0, newCharacter("gm")); err != ErrCharacterNameTaken {
+		t.Fatalf("renamed a character to a taken key: %v", err)
+	}
+
+	// Deleting the character frees the key, and it can't be restored once
+	// another character has taken it.
+	if err := store.DeleteCharacter(42000001, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CreateCharacter(42000002, 0, newCharacter("gm")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RestoreCharacter(42000001, FirstDeletedSlot, 0); err != ErrCharacterNameTaken {
+		t.Fatalf("restored a character with a taken key: %v", err)
+	}
+}
+
+func TestImportedCharacterNameKeyIsUnique(t *testing.T) {
+	store := openTestStore(t)
+	newSnapshot := func(guildcard uint32, key string) *CharacterSnapshot {
+		return &CharacterSnapshot{
+			Guildcard: guildcard,
+			Character: &Character{GuildcardStr: []byte("42000001"), Name: []byte("x"), NameKey: key},
+		}
+	}
+	if err := store.CreateCharacter(42000001, 0, newSnapshot(42000001, "gm").Character); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.ImportCharacter(newSnapshot(42000002, "gm")); err != ErrCharacterNameTaken {
+		t.Fatalf("imported a character with a taken key: %v", err)
+	} else if character, err := store.FindCharacter(42000002, 0); err != nil || character != nil {
+		t.Fatalf("refused import left character %+v, %v", character, err)
+	}
+
+	// Restoring a snapshot writes the key it's given too.
+	if err := store.ImportCharacter(newSnapshot(42000002, "")); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := newSnapshot(42000002, "")
+	if err := store.CreateSnapshot(snapshot, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RestoreSnapshot(snapshot.Id, "gm"); err != ErrCharacterNameTaken {
+		t.Fatalf("restored a snapshot with a taken key: %v", err)
+	}
+	if err := store.RestoreSnapshot(snapshot.Id, "other"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CreateCharacter(42000003, 0, newSnapshot(42000003, "other").Character); err != ErrCharacterNameTaken {
+		t.Fatalf("restored snapshot didn't take its key: %v", err)
+	}
+}
+
+func TestImportAccountResumes(t *testing.T) {
+	store := openTestStore(t)
+	first := &Account{Username: "imported", Password: "x", Active: true}
+	if created, err := store.ImportAccount("tethealla", 10000001, first, nil); err != nil || !created {
+		t.Fatalf("first import: created %v, %v", created, err)
+	}
+	again := &Account{Username: "imported", Password: "x", Active: true}
+	if created, err := store.ImportAccount("tethealla", 10000001, again, nil); err != nil || created {
+		t.Fatalf("second import: created %v, %v", created, err)
+	} else if again.Guildcard != first.Guildcard {
+		t.Errorf("second import found guildcard %d, expected %d", again.Guildcard, first.Guildcard)
+	}
+	// The same guildcard from another server is another account.
+	other := &Account{Username: "imported", Password: "x", Active: true}
+	if _, err := store.ImportAccount("sylverant", 10000001, other, nil); err != ErrAccountExists {
+		t.Errorf("import from another server: %v", err)
+	}
+}
+
+func TestBannedAccountsMoveToBans(t *testing.T) {
+	store, err := Open(Config{Driver: "sqlite", Name: filepath.Join(t.TempDir(), "archon.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	// The last version with the banned flag on accounts.
+	if err = store.Migrate(27); err != nil {
+		t.Fatal(err)
+	}
+	for _, username := range []string{"banned", "allowed"} {
+		if err = store.CreateAccount(&Account{Username: username, Password: "x", Active: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db := store.(*sqlStore).primary.db
+	if _, err = db.Exec("UPDATE accounts SET banned = 1 WHERE username = 'banned'"); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Migrate(LatestSchema); err != nil {
+		t.Fatal(err)
+	}
+
+	ban, err := store.FindActiveBan([]BanTarget{{Type: BanAccount, Target: "banned"}})
+	if err != nil || ban == nil || !ban.Permanent() {
+		t.Fatalf("banned account has ban %+v, %v", ban, err)
+	}
+	if entries, err := store.FindBanAudit(ban.Id); err != nil || len(entries) != 1 {
+		t.Errorf("migrated ban has audit entries %+v, %v", entries, err)
+	}
+	if ban, err = store.FindActiveBan([]BanTarget{{Type: BanAccount, Target: "allowed"}}); err != nil || ban != nil {
+		t.Errorf("allowed account has ban %+v, %v", ban, err)
+	}
+}
+
+func TestUpdateTwoFactorRejectsUsedSteps(t *testing.T) {
+	store := openTestStore(t)
+	twoFactor := &TwoFactor{Username: "player", Secret: "secret", Enabled: true, LastStep: 10}
+	if err := store.SaveTwoFactor(twoFactor, nil); err != nil {
+		t.Fatal(err)
+	}
+	// Two logins that read step 10 and both accepted a code from step 11.
+	first, second := *twoFactor, *twoFactor
+	first.LastStep, second.LastStep = 11, 11
+	if err := store.UpdateTwoFactor(&first); err != nil {
+		t.Fatalf("first use of the step: %v", err)
+	}
+	if err := store.UpdateTwoFactor(&second); err != ErrTwoFactorStepUsed {
+		t.Errorf("second use of the step: %v", err)
+	}
+}
+
+func TestUnreachableReplica(t *testing.T) {
+	// A port that nothing is listening on.
+	socket, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(socket.Addr().String())
+	socket.Close()
+
+	changed := make(chan error, 1)
+	cfg := Config{
+		Host:     "127.0.0.1",
+		Port:     port,
+		Name:     "archon",
+		Username: "archon",
+		AvailabilityChanged: func(replica bool, err error) {
+			if replica {
+				changed <- err
+			}
+		},
+	}
+	// SQLite has no replicas of its own, so the store's replica is a Postgres
+	// pool that can't connect.
+	store := openTestStore(t).(*sqlStore)
+	if store.replica, err = drivers["postgres"].(sqlDriver).openPool(cfg, true); err != nil {
+		t.Fatalf("opening the replica: %v", err)
+	}
+	select {
+	case err = <-changed:
+		if err == nil {
+			t.Error("replica reported as available")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("replica wasn't reported as unavailable")
+	}
+
+	if err = store.CreateAccount(&Account{Username: "player", Password: "x", Active: true}); err != nil {
+		t.Fatal(err)
+	}
+	if account, err := store.Replica().FindAccount("player"); err != nil || account == nil {
+		t.Errorf("read through the replica got %+v, %v", account, err)
+	}
+}
@@ -0,0 +1,27 @@
+package data
+
+import (
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// The sqlite backend is intended for small servers running on a single host.
+// The database name is treated as the path to the database file and the
+// host, port, and credentials are ignored.
+func init() {
+	Register("sqlite", sqlDriver{dialect: &dialect{
+		name:       "sqlite",
+		driverName: "sqlite3",
+		dsn: func(cfg Config) string {
+			return fmt.Sprintf("file:%s?_busy_timeout=5000&_journal_mode=WAL", cfg.Name)
+		},
+		// SQLite only allows one writer at a time; serialize access through a
+		// single connection rather than fight over the lock.
+		maxOpenConns: 1,
+		uniqueViolation: func(err error) bool {
+			e, ok := err.(sqlite3.Error)
+			return ok && e.ExtendedCode == sqlite3.ErrConstraintUnique
+		},
+	}})
+}
@@ -4,23 +4,27 @@ import (
 	"fmt"
 	"net/http"
 	"runtime/pprof"
+
+	"github.com/dcrodman/archon/data"
 )
 
 // StartDebugServer will, If we're in debug mode, spawn off an HTTP server that dumps
-// pprof output containing the stack traces of all running goroutines.
+// pprof output containing the stack traces of all running goroutines. If the web
+// API is enabled then the dump is served by it at /debug/goroutines instead. Only
+// accounts with admin privileges can see it.
 func StartDebugServer() {
-	if config.DebugMode {
-		fmt.Println("Opening Debug port on " + config.WebPort)
-		http.HandleFunc("/", func(resp http.ResponseWriter, req *http.Request) {
-			pprof.Lookup("goroutine").WriteTo(resp, 1)
-		})
-		go http.ListenAndServe(":"+config.WebPort, nil)
+	if !config.DebugMode {
+		return
 	}
-}
-
-// DebugLog is a trivial utility that will only write message if debug mode is on.
-func DebugLog(message string) {
-	if config.DebugMode {
-		fmt.Println(message)
+	dumpGoroutines := requirePrivilege("debug", func(*http.Request) byte { return data.PrivilegeAdmin },
+		http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			pprof.Lookup("goroutine").WriteTo(resp, 1)
+		}))
+	if config.WebEnabled {
+		webMux.Handle("/debug/goroutines", dumpGoroutines)
+		return
 	}
+	fmt.Println("Opening Debug port on " + config.WebPort)
+	http.Handle("/", dumpGoroutines)
+	go http.ListenAndServe(":"+config.WebPort, nil)
 }
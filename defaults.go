@@ -1,6 +1,10 @@
 // Constants and structs associated with character data.
 package main
 
+import (
+	"github.com/dcrodman/archon/data"
+)
+
 // Default keyboard/joystick configuration used for players who are
 // logging in for the first time.
 var baseKeyConfig = [420]byte{
@@ -113,3 +117,70 @@ var baseSymbolChats = [1248]byte{
 	0xff, 0x00, 0x00, 0x00, 0xff, 0x00, 0x00, 0x00, 0xff, 0x00, 0x00, 0x00, 0xff, 0x00, 0x00, 0x00,
 	0xff, 0x00, 0x00, 0x00, 0xff, 0x00, 0x00, 0x00, 0xff, 0x00, 0x00, 0x00, 0xff, 0x00, 0x00, 0x00,
 }
+
+// Version of the default options built from baseKeyConfig. Bump this when the
+// defaults change; options saved under an older version are replaced with the
+// new defaults the next time they're loaded.
+const DefaultOptionsVersion = 1
+
+// Returns the options used for accounts that haven't saved any of their own.
+func defaultPlayerOptions(guildcard uint32) *data.PlayerOptions {
+	return &data.PlayerOptions{
+		Guildcard:      guildcard,
+		Version:        DefaultOptionsVersion,
+		KeyConfig:      append([]byte(nil), baseKeyConfig[:data.KeyConfigSize]...),
+		JoystickConfig: append([]byte(nil), baseKeyConfig[data.KeyConfigSize:]...),
+	}
+}
+
+// Items common to the starting gear for every class.
+var (
+	startingFrame = data.Item{Data: []byte{0x01, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}}
+	// Level 5 mag with 5.00 DEF and 20% synchro.
+	startingMag = data.Item{
+		Data:  []byte{0x02, 0x00, 0x05, 0x00, 0xF4, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+		Data2: []byte{0x14, 0x00, 0x00, 0x00},
+	}
+	startingMonomates  = data.Item{Data: []byte{0x03, 0x00, 0x00, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}}
+	startingMonofluids = data.Item{Data: []byte{0x03, 0x01, 0x00, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}}
+)
+
+// Returns the techniques known by a newly created character of the specified
+// class. Forces start out knowing level 1 Foie.
+func startingTechniques(class byte) data.Techniques {
+	var techniques data.Techniques
+	switch CharClass(class) {
+	case Fomarl, Fonewm, Fonewearl, Fomar:
+		techniques[TechFoie] = 1
+	}
+	return techniques
+}
+
+// Returns the inventory given to a newly created character of the specified class:
+// a class-appropriate weapon, frame, and mag (all equipped) plus some healing items.
+func startingItems(class byte) []data.Item {
+	weapon := data.Item{Data: make([]byte, 12)}
+	weapon.Data[1] = 0x01 // Saber
+	switch CharClass(class) {
+	case Ramar, Racast, Racaseal, Ramarl:
+		weapon.Data[1] = 0x06 // Handgun
+	case Fomarl, Fonewm, Fonewearl, Fomar:
+		weapon.Data[1] = 0x0A // Cane
+	}
+
+	items := []data.Item{weapon, startingFrame, startingMag, startingMonomates}
+	switch CharClass(class) {
+	case Fomarl, Fonewm, Fonewearl, Fomar:
+		items = append(items, startingMonofluids)
+	}
+	for i := range items {
+		items[i].ItemId = FirstItemId + uint32(i)
+		if i < 3 {
+			items[i].Flags = ItemEquipped
+		}
+		if items[i].Data2 == nil {
+			items[i].Data2 = make([]byte, 4)
+		}
+	}
+	return items
+}
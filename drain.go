@@ -0,0 +1,138 @@
+/*
+* Graceful shutdown and drain mode. On SIGTERM (or SIGINT) the servers stop
+* accepting connections, tell the players that the server is going down, and
+* wait for their characters to be saved before exiting. Draining (SIGUSR1 or
+* "archon drain") stops new logins but lets the players who are already on
+* the ship keep playing, then shuts down once the last of them leaves.
+ */
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/dcrodman/archon/packets"
+)
+
+const (
+	// How long to wait for clients to disconnect and for their characters to
+	// be saved before giving up on a clean shutdown.
+	shutdownTimeout = 30 * time.Second
+	// How often a draining server checks whether everyone has left.
+	drainCheckInterval = 10 * time.Second
+)
+
+// Returns whether c understands the message packet, which patch clients and
+// ships don't.
+func takesMessages(c *Client) bool {
+	return (c.hdrSize == packets.BBHeaderSize || c.version != VersionBB) && c.serverCrypt != nil
+}
+
+// Send a message to each of the connected Blue Burst clients.
+func (controller *controller) notifyClients(message string) {
+	for _, c := range controller.connections.Clients(nil) {
+		if takesMessages(c) {
+			SendClientMessage(c, message)
+		}
+	}
+}
+
+// Tell a client that the server is going down. Players' characters are saved
+// first, and they're only told that it was once the save has been journaled.
+// Only to be called from the client's own goroutine.
+func notifyShutdown(c *Client) {
+	if !takesMessages(c) {
+		return
+	}
+	message := "The server is shutting down."
+	if c.character != nil && players.Find(c.guildcard) == c {
+		if err := saveCharacter(c); err != nil {
+			c.log.Error(err.Error())
+			message += "\n\nYour character could not be saved."
+		} else {
+			message += "\n\nYour character has been saved."
+		}
+	}
+	SendClientMessage(c, message)
+}
+
+// Tell everyone that the server is going down and disconnect them. Exits the
+// process if the clients haven't all been cleaned up within shutdownTimeout.
+// Only the first call does anything, so draining and a signal can both ask.
+func (controller *controller) gracefulShutdown() {
+	controller.shuttingDown.Do(func() {
+		controller.stopAccepting(func(Server) bool { return true })
+		askClients(controller.connections.Clients(nil), func(c *Client) interface{} {
+			notifyShutdown(c)
+			return nil
+		})
+		controller.shutdown()
+
+		go func() {
+			time.Sleep(shutdownTimeout)
+			log.Errorf("Clients still connected after %v; exiting anyway", shutdownTimeout)
+			os.Exit(1)
+		}()
+	})
+}
+
+// Players who are already logged in connect to these while moving between
+// ships and blocks, so they stay open while draining.
+func acceptsDuringDrain(s Server) bool {
+	switch s.(type) {
+	case *ShipServer, *BlockServer, *ShipgateServer:
+		return true
+	}
+	return false
+}
+
+// Stop accepting new logins and shut down once the players on the ship have
+// all logged off.
+func (controller *controller) drain() {
+	controller.draining.Do(func() {
+		log.Infof("Draining; shutting down once %d players have left", players.Len())
+		controller.stopAccepting(func(s Server) bool { return !acceptsDuringDrain(s) })
+		for _, c := range players.List() {
+			SendClientMessage(c, "The server will be shutting down for maintenance.\n\n"+
+				"Please finish what you're doing and log off.")
+		}
+
+		go func() {
+			for players.Len() > 0 {
+				time.Sleep(drainCheckInterval)
+			}
+			log.Infof("All players have left; shutting down")
+			controller.gracefulShutdown()
+		}()
+	})
+}
+
+// Record our process id so that "archon drain" and "archon reload" can find us.
+func writePidFile() error {
+	if config.PidFile == "" {
+		return nil
+	}
+	return ioutil.WriteFile(config.PidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
+
+// Send a signal to the running server, found through the pid file. Used by the
+// drain and reload commands.
+func signalServer(sig syscall.Signal) error {
+	if config.PidFile == "" {
+		return errors.New("pid_file must be set in the config to find the running server")
+	}
+	contents, err := ioutil.ReadFile(config.PidFile)
+	if err != nil {
+		return err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(contents)))
+	if err != nil {
+		return errors.New("malformed pid file " + config.PidFile)
+	}
+	return syscall.Kill(pid, sig)
+}
@@ -0,0 +1,414 @@
+/*
+* Item drops for the block servers. Blue Burst clients ask the server what an
+* enemy or box drops rather than deciding for themselves, so the drops are rolled
+* here from the drop tables and placed on the floor for everyone in the game. The
+* items on the floor are kept with the game until somebody picks them up.
+*
+* The tables are JSON files in the drop directory, one for each episode and
+* difficulty (ep1_normal.json, ep2_hard.json, ep4_very_hard.json, and so on).
+* Each file has a table for each section ID by name (viridia, greennill, ...),
+* with "default" used for any that aren't listed:
+*
+*	{
+*		"default": {
+*			"enemy_rate": 0.3,
+*			"box_rate": 0.6,
+*			"meseta_rate": 0.5,
+*			"enemy_meseta": [10, 40],
+*			"box_meseta": [20, 80],
+*			"items": [{"item": "030000", "weight": 10}, {"item": "030100", "weight": 5}],
+*			"enemy_rares": {"1": [{"item": "000300", "rate": 0.001}]},
+*			"box_rares": {"3": [{"item": "000a00", "rate": 0.0005}]}
+*		}
+*	}
+*
+* Rates are chances from 0 to 1. Items are given as the hex of the item's data,
+* up to 12 bytes. Common items are picked by weight, while each rare is rolled
+* separately for enemies by the index of their type in the client's rare tables
+* and for boxes by floor. The drop and rare rates are multiplied by the game's
+* rates (see rates.go).
+ */
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/dcrodman/archon/data"
+	"github.com/dcrodman/archon/packets"
+)
+
+const (
+	// Where the item in a DropItemPacket came from.
+	DropSourceEnemy = 1
+	DropSourceBox   = 2
+	// Item ids assigned to the items dropped in a game begin here.
+	FirstDropItemId = 0x00810000
+)
+
+// Names of the section IDs as they're given in the drop tables, indexed by id.
+var sectionIdNames = []string{"viridia", "greennill", "skyly", "bluefull", "purplenum",
+	"pinkal", "redria", "oran", "yellowboze", "whitill"}
+
+// Item that can be picked from the common drops.
+type commonDrop struct {
+	// Item data in hex.
+	Item   string  `json:"item"`
+	Weight float64 `json:"weight"`
+	data   []byte
+}
+
+// Rare item with its own chance of dropping.
+type rareDrop struct {
+	// Item data in hex.
+	Item string  `json:"item"`
+	Rate float64 `json:"rate"`
+	data []byte
+}
+
+// Drops for one section ID in an episode and difficulty.
+type dropTable struct {
+	// Chance that a killed enemy or a broken box drops anything.
+	EnemyRate float64 `json:"enemy_rate"`
+	BoxRate   float64 `json:"box_rate"`
+	// Chance that a drop is meseta rather than an item, and the range of amounts.
+	MesetaRate  float64      `json:"meseta_rate"`
+	EnemyMeseta [2]uint32    `json:"enemy_meseta"`
+	BoxMeseta   [2]uint32    `json:"box_meseta"`
+	Items       []commonDrop `json:"items"`
+	// Rare items keyed by enemy type for enemies and by floor for boxes.
+	EnemyRares map[uint8][]rareDrop `json:"enemy_rares"`
+	BoxRares   map[uint8][]rareDrop `json:"box_rares"`
+	// Sum of the weights of the common items.
+	totalWeight float64
+}
+
+// Identifies the drop table for a game.
+type dropTableKey struct {
+	episode    uint8
+	difficulty uint8
+	sectionId  uint8
+}
+
+// Synchronized set of the drop tables loaded from the drop directory.
+type dropTableList struct {
+	tables map[dropTableKey]*dropTable
+	sync.RWMutex
+}
+
+var dropTables = &dropTableList{tables: make(map[dropTableKey]*dropTable)}
+
+// Load the drop tables in dir, replacing any that were loaded before, and return
+// the number of files loaded. Nothing is replaced if any of them are invalid.
+func (dl *dropTableList) Load(dir string) (int, error) {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		log.Warnf("Drop directory %s doesn't exist; enemies and boxes won't drop anything", dir)
+		return 0, nil
+	}
+	loaded := 0
+	tables := make(map[dropTableKey]*dropTable)
+	// Episodes as they're numbered in the file names, indexed by game episode.
+	for episode, name := range []string{1: "ep1", 2: "ep2", 3: "ep4"} {
+		if name == "" || !episodeAvailable(uint8(episode)) {
+			continue
+		}
+		for difficulty, difficultyName := range difficultyNames {
+			path := filepath.Join(dir, name+"_"+difficultyName+".json")
+			sections, err := loadDropTableFile(path)
+			if os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				return 0, fmt.Errorf("invalid %s: %s", path, err.Error())
+			}
+			for sectionId, sectionName := range sectionIdNames {
+				table, ok := sections[sectionName]
+				if !ok {
+					table = sections["default"]
+				}
+				if table != nil {
+					key := dropTableKey{uint8(episode), uint8(difficulty), uint8(sectionId)}
+					tables[key] = table
+				}
+			}
+			loaded++
+		}
+	}
+
+	dl.Lock()
+	dl.tables = tables
+	dl.Unlock()
+	return loaded, nil
+}
+
+// Find returns the drop table for a game or nil if there isn't one.
+func (dl *dropTableList) Find(g *Game) *dropTable {
+	dl.RLock()
+	defer dl.RUnlock()
+	return dl.tables[dropTableKey{g.episode, g.difficulty, g.sectionId}]
+}
+
+// RareTypes returns the types (the first three bytes of their data) of the items
+// that any of the tables drop as rares.
+func (dl *dropTableList) RareTypes() map[[3]byte]bool {
+	dl.RLock()
+	defer dl.RUnlock()
+	types := make(map[[3]byte]bool)
+	for _, table := range dl.tables {
+		for _, rares := range []map[uint8][]rareDrop{table.EnemyRares, table.BoxRares} {
+			for _, list := range rares {
+				for _, rare := range list {
+					var itemType [3]byte
+					copy(itemType[:], rare.data)
+					types[itemType] = true
+				}
+			}
+		}
+	}
+	return types
+}
+
+// Read the tables for each section ID in one of the drop table files.
+func loadDropTableFile(path string) (map[string]*dropTable, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var sections map[string]*dropTable
+	if err := json.Unmarshal(contents, &sections); err != nil {
+		return nil, err
+	}
+	for name, table := range sections {
+		if name != "default" && !isSectionIdName(name) {
+			return nil, errors.New("unknown section ID " + name)
+		} else if table == nil {
+			continue
+		}
+		if err := table.prepare(); err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err.Error())
+		}
+	}
+	return sections, nil
+}
+
+func isSectionIdName(name string) bool {
+	for _, n := range sectionIdNames {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Check the table and decode its items.
+func (t *dropTable) prepare() error {
+	for _, rate := range []float64{t.EnemyRate, t.BoxRate, t.MesetaRate} {
+		if rate < 0 || rate > 1 {
+			return errors.New("rates must be between 0 and 1")
+		}
+	}
+	if t.EnemyMeseta[0] > t.EnemyMeseta[1] || t.BoxMeseta[0] > t.BoxMeseta[1] {
+		return errors.New("meseta ranges must be [min, max]")
+	}
+	var err error
+	t.totalWeight = 0
+	for i := range t.Items {
+		if t.Items[i].data, err = parseDropItem(t.Items[i].Item); err != nil {
+			return err
+		} else if t.Items[i].Weight < 0 {
+			return errors.New("weights can't be negative")
+		}
+		t.totalWeight += t.Items[i].Weight
+	}
+	for _, rares := range []map[uint8][]rareDrop{t.EnemyRares, t.BoxRares} {
+		for _, list := range rares {
+			for i := range list {
+				if list[i].data, err = parseDropItem(list[i].Item); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Decode the hex of an item's data.
+func parseDropItem(s string) ([]byte, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) == 0 || len(b) > 12 {
+		return nil, fmt.Errorf("invalid item %q; items must be up to 12 bytes of hex", s)
+	}
+	return b, nil
+}
+
+// Roll for what an enemy of a type or a box on a floor drops, with the chance of
+// a drop and of each rare multiplied by the rates, returning false if it doesn't
+// drop anything.
+func (t *dropTable) roll(source uint8, enemyType uint8, floor uint8, dropRate, rareRate float64) (data.Item, bool) {
+	rares, rate, meseta := t.EnemyRares[enemyType], t.EnemyRate, t.EnemyMeseta
+	if source == DropSourceBox {
+		rares, rate, meseta = t.BoxRares[floor], t.BoxRate, t.BoxMeseta
+	}
+
+	for _, rare := range rares {
+		if rand.Float64() < rare.Rate*rareRate {
+			return unidentified(newDropItem(rare.data), true), true
+		}
+	}
+	if rand.Float64() >= rate*dropRate {
+		return data.Item{}, false
+	}
+	if rand.Float64() < t.MesetaRate || t.totalWeight == 0 {
+		if meseta[1] == 0 {
+			return data.Item{}, false
+		}
+		return newMesetaItem(meseta[0] + uint32(rand.Int63n(int64(meseta[1]-meseta[0])+1))), true
+	}
+	n := rand.Float64() * t.totalWeight
+	for _, common := range t.Items {
+		if n < common.Weight {
+			return unidentified(newDropItem(common.data), false), true
+		}
+		n -= common.Weight
+	}
+	return unidentified(newDropItem(t.Items[len(t.Items)-1].data), false), true
+}
+
+func newDropItem(itemData []byte) data.Item {
+	item := data.Item{Data: make([]byte, 12), Data2: make([]byte, 4)}
+	copy(item.Data, itemData)
+	if isStackable(item) && item.Data[5] == 0 {
+		item.Data[5] = 1
+	}
+	return item
+}
+
+// Meseta is dropped as an item of type 4 with the amount in Data2.
+func newMesetaItem(amount uint32) data.Item {
+	item := data.Item{Data: make([]byte, 12), Data2: make([]byte, 4)}
+	item.Data[0] = 0x04
+	binary.LittleEndian.PutUint32(item.Data2, amount)
+	return item
+}
+
+func isMeseta(item data.Item) bool {
+	return len(item.Data) == 12 && item.Data[0] == 0x04
+}
+
+func mesetaAmount(item data.Item) uint32 {
+	return binary.LittleEndian.Uint32(item.Data2)
+}
+
+// Roll the drop from an enemy or box, returning false if it doesn't drop anything
+// or has already dropped something. The item is placed on the floor.
+func (g *Game) rollDrop(table *dropTable, pkt *packets.DropRequestPacket, source uint8) (data.Item, bool) {
+	g.itemLock.Lock()
+	defer g.itemLock.Unlock()
+	// Everyone in the game can ask for the same drop.
+	key := uint32(pkt.Floor)<<24 | uint32(source)<<16 | uint32(pkt.EntityId)
+	if g.dropped[key] {
+		return data.Item{}, false
+	}
+	g.dropped[key] = true
+
+	dropRate, rareRate, _ := g.rates()
+	item, ok := table.roll(source, pkt.EnemyType, pkt.Floor, dropRate, rareRate)
+	if !ok {
+		return item, false
+	}
+	item.ItemId = g.nextItemId
+	g.nextItemId++
+	g.floorItems[item.ItemId] = &floorItem{item: item, floor: pkt.Floor}
+	return item, true
+}
+
+// An enemy died or a box was broken; roll what it drops and put it on the floor.
+func (server *BlockServer) HandleDropRequest(c *Client, source uint8) error {
+	var pkt packets.DropRequestPacket
+	if err := c.Decode(&pkt); err != nil {
+		return err
+	}
+	g := c.game
+	if g == nil {
+		return nil
+	}
+	if source == DropSourceEnemy {
+		pkt.EnemyType = g.enemyIdentified(pkt.EntityId, pkt.EnemyType, pkt.Floor)
+	}
+	table := dropTables.Find(g)
+	if table == nil {
+		return nil
+	}
+	item, ok := g.rollDrop(table, &pkt, source)
+	if !ok {
+		return nil
+	}
+	c.log.Debugf("Dropping item %x (%x) in game %d", item.ItemId, item.Data, g.id)
+	auditItem(c, data.ItemAuditCreated, &item, 0, 0)
+	g.Broadcast(&packets.DropItemPacket{
+		Header:    packets.BBHeader{Type: packets.GameCommandType},
+		SubHeader: packets.SubCmdHeader{Type: packets.SubCmdDropItem, Size: 11},
+		Floor:     pkt.Floor,
+		Source:    source,
+		EntityId:  pkt.EntityId,
+		X:         pkt.X,
+		Z:         pkt.Z,
+		Item:      newItemData(item),
+	}, nil)
+	return nil
+}
+
+// The player is picking up an item from the floor. Whoever asks first gets it.
+func (server *BlockServer) HandlePickUpItem(c *Client) error {
+	var pkt packets.PickUpItemRequestPacket
+	if err := c.Decode(&pkt); err != nil {
+		return err
+	}
+	g := c.game
+	if g == nil || c.character == nil || c.trading() {
+		return nil
+	}
+	item, ok := g.takeFloorItem(pkt.ItemId, pkt.Floor)
+	if !ok {
+		if !g.wasOnFloor(pkt.ItemId) {
+			anticheat.PickedUpUnknownItem(c, pkt.ItemId)
+		}
+		return nil
+	}
+
+	switch stack := findStack(c.inventory, item); {
+	case isMeseta(item):
+		c.character.Meseta += mesetaAmount(item)
+		if c.character.Meseta > MaxMeseta {
+			c.character.Meseta = MaxMeseta
+		}
+	case isStackable(item) && stack >= 0:
+		if itemAmount(c.inventory[stack])+itemAmount(item) > MaxStackSize {
+			g.placeFloorItem(item, pkt.Floor)
+			return nil
+		}
+		c.inventory[stack].Data[5] += itemAmount(item)
+	case len(c.inventory) >= MaxInventoryItems:
+		g.placeFloorItem(item, pkt.Floor)
+		return nil
+	default:
+		c.inventory = append(c.inventory, item)
+	}
+	auditItem(c, data.ItemAuditPickedUp, &item, 0, 0)
+
+	g.Broadcast(&packets.PickUpItemPacket{
+		Header:    packets.BBHeader{Type: packets.GameCommandType},
+		SubHeader: packets.SubCmdHeader{Type: packets.SubCmdPickUpItem, Size: 3, ClientId: uint16(c.clientId)},
+		ClientId:  uint16(c.clientId),
+		Floor:     uint16(pkt.Floor),
+		ItemId:    item.ItemId,
+	}, nil)
+	return nil
+}
@@ -0,0 +1,137 @@
+/*
+* Economy reports. The ship keeps a row of stats for each day (UTC) with the
+* meseta and rare items held in all inventories and banks, the trades made and
+* the meseta that changed hands in them, the accounts that logged in, and the
+* most players online at once on any ship. Today's row is brought up to date
+* every economyStatsInterval and finished off when the day ends, so the stats
+* for past days are as they stood at midnight.
+*
+* Rare items are the items that the drop tables list as rares, matched by type
+* (the first three bytes of their data). The days are listed at /admin/economy
+* and graphed on the dashboard.
+ */
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dcrodman/archon/data"
+)
+
+const (
+	// How often today's stats are written.
+	economyStatsInterval = 15 * time.Minute
+	// How often the number of players online is checked for the peak.
+	economyPeakInterval = time.Minute
+	// Number of days listed at /admin/economy if none are asked for, and the
+	// most that can be.
+	defaultEconomyDays = 30
+	maxEconomyDays     = 366
+)
+
+// Loop for the life of the server, sampling the number of players online and
+// writing the stats for the day.
+func runEconomyStats() {
+	day := economyDay(time.Now())
+	peak := len(onlinePlayers.List())
+	updateEconomyStats(day, peak)
+	lastUpdate := time.Now()
+	for range time.Tick(economyPeakInterval) {
+		now := time.Now()
+		if today := economyDay(now); !today.Equal(day) {
+			updateEconomyStats(day, peak)
+			day, peak, lastUpdate = today, 0, now
+		}
+		if online := len(onlinePlayers.List()); online > peak {
+			peak = online
+		}
+		if now.Sub(lastUpdate) >= economyStatsInterval {
+			updateEconomyStats(day, peak)
+			lastUpdate = now
+		}
+	}
+}
+
+// Returns midnight UTC at the start of the day t is in.
+func economyDay(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+// Work out and save the stats for the day starting at day. The peak and the
+// number of active players never go down, since the server may have restarted
+// during the day and logins from the day are overwritten by later ones.
+func updateEconomyStats(day time.Time, peak int) {
+	stats, err := collectEconomyStats(day, peak)
+	if err == nil {
+		err = database.SaveEconomyStats(stats)
+	}
+	if err != nil {
+		log.Error("Failed to update economy stats: " + err.Error())
+	}
+}
+
+func collectEconomyStats(day time.Time, peak int) (*data.EconomyStats, error) {
+	stats := &data.EconomyStats{Day: day, PeakOnline: peak, UpdatedAt: time.Now()}
+	end := day.AddDate(0, 0, 1)
+	// The totals are read from the replica, since they scan whole tables.
+	replica := database.Replica()
+	var err error
+	if stats.MesetaSupply, err = replica.SumMeseta(); err != nil {
+		return nil, err
+	}
+	itemTypes, err := replica.CountItemTypes()
+	if err != nil {
+		return nil, err
+	}
+	for itemType := range dropTables.RareTypes() {
+		stats.RareItems += itemTypes[itemType]
+	}
+	if stats.Trades, stats.TradeMeseta, err = replica.CountTrades(day, end); err != nil {
+		return nil, err
+	}
+	if stats.ActivePlayers, err = replica.CountActiveAccounts(day, end); err != nil {
+		return nil, err
+	}
+
+	previous, err := database.FindEconomyStats(day)
+	if err != nil {
+		return nil, err
+	}
+	if len(previous) > 0 && previous[0].Day.Equal(day) {
+		if previous[0].PeakOnline > stats.PeakOnline {
+			stats.PeakOnline = previous[0].PeakOnline
+		}
+		if previous[0].ActivePlayers > stats.ActivePlayers {
+			stats.ActivePlayers = previous[0].ActivePlayers
+		}
+	}
+	return stats, nil
+}
+
+// GET lists the stats for each of the last days (30 by default), oldest first.
+func handleAdminEconomy(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	days := defaultEconomyDays
+	if s := req.URL.Query().Get("days"); s != "" {
+		var err error
+		if days, err = strconv.Atoi(s); err != nil || days <= 0 || days > maxEconomyDays {
+			writeError(w, http.StatusBadRequest, "days must be between 1 and "+strconv.Itoa(maxEconomyDays))
+			return
+		}
+	}
+	stats, err := database.Replica().FindEconomyStats(economyDay(time.Now()).AddDate(0, 0, 1-days))
+	if err != nil {
+		log.Error("Failed to look up economy stats: " + err.Error())
+		writeError(w, http.StatusInternalServerError, "unable to look up economy stats")
+		return
+	}
+	if stats == nil {
+		stats = []data.EconomyStats{}
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
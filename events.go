@@ -0,0 +1,343 @@
+/*
+* Scheduled events. Each event in the config has a cron-style schedule for when
+* it starts and a duration, and while it runs it can put up lobby decorations,
+* multiply the drop and experience rates, and replace the scroll message:
+*
+*	events:
+*	  - name: Christmas
+*	    schedule: "0 0 20 12 *"
+*	    duration: 336h
+*	    lobby_event: 1
+*	    rare_rate: 2
+*
+* The schedules are checked at the start of every minute, so events start and
+* end without a restart, and changes to them take effect with "archon reload".
+* When more than one event is running, their rates are multiplied together and
+* the first one in the config with decorations or a scroll message wins.
+ */
+package main
+
+import (
+	"errors"
+	"math/bits"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/dcrodman/archon/packets"
+)
+
+// Longest an event can run each time it starts.
+const maxEventDuration = 366 * 24 * time.Hour
+
+// An event from the config, parsed.
+type scheduledEvent struct {
+	EventConfig
+	schedule       *cronSchedule
+	duration       time.Duration
+	scrollTemplate *template.Template
+}
+
+func newScheduledEvent(cfg EventConfig) (*scheduledEvent, error) {
+	event := &scheduledEvent{EventConfig: cfg}
+	var err error
+	if cfg.Name == "" {
+		return nil, errors.New("events need a name")
+	} else if event.schedule, err = parseCronSchedule(cfg.Schedule); err != nil {
+		return nil, err
+	} else if event.duration, err = time.ParseDuration(cfg.Duration); err != nil {
+		return nil, errors.New("invalid duration: " + err.Error())
+	} else if event.duration < time.Minute || event.duration > maxEventDuration {
+		return nil, errors.New("duration must be between a minute and a year")
+	} else if cfg.DropRate < 0 || cfg.RareRate < 0 || cfg.ExpRate < 0 {
+		return nil, errors.New("rates can't be negative")
+	}
+	if cfg.ScrollMessage != "" {
+		if event.scrollTemplate, err = parseScrollTemplate(cfg.Name, cfg.ScrollMessage); err != nil {
+			return nil, errors.New("invalid scroll_message: " + err.Error())
+		}
+	}
+	return event, nil
+}
+
+// Returns whether the event is running at t, which it is if it started within
+// its duration before t.
+func (e *scheduledEvent) runningAt(t time.Time) bool {
+	t = t.Truncate(time.Minute)
+	start, ok := e.schedule.lastAt(t, t.Add(-e.duration))
+	return ok && t.Sub(start) < e.duration
+}
+
+// A cron expression, with each field as the set of values that it matches.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// Whether the day of the month and week fields were "*", which changes how
+	// they're combined.
+	anyDom, anyDow bool
+}
+
+// Parse a cron expression: minute (0-59), hour (0-23), day of the month (1-31),
+// month (1-12), and day of the week (0-6, from Sunday, or 7 for Sunday). Each
+// field is "*", a number, a range such as 1-5, any of those followed by a step
+// such as */15, or a comma separated list of them.
+func parseCronSchedule(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, errors.New("schedule must have 5 fields: minute, hour, day of the month, month, and day of the week")
+	}
+	s := &cronSchedule{anyDom: fields[2] == "*", anyDow: fields[4] == "*"}
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, err
+	} else if s.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, err
+	} else if s.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, err
+	} else if s.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, err
+	} else if s.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, err
+	}
+	// 7 is another name for Sunday.
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+// Returns the set of values between min and max that field matches.
+func parseCronField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return 0, errors.New("invalid step in schedule field " + field)
+			}
+			part = part[:i]
+		}
+		lo, hi := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, errors.New("invalid schedule field " + field)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, errors.New("invalid schedule field " + field)
+				}
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, errors.New("schedule field " + field + " is out of range")
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+// Returns whether the schedule fires at the minute t is in.
+func (s *cronSchedule) matches(t time.Time) bool {
+	return s.minute&(1<<uint(t.Minute())) != 0 && s.hour&(1<<uint(t.Hour())) != 0 && s.matchesDay(t)
+}
+
+// Returns whether the schedule fires at some time on the day t is in. As with
+// cron, when both day fields are restricted, a day matches if either of them
+// does.
+func (s *cronSchedule) matchesDay(t time.Time) bool {
+	if s.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.anyDom || s.anyDow {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Returns the last minute at or before t that the schedule fires at, or false if
+// it doesn't fire between since and t. Rather than trying each minute, it steps
+// back a day at a time and picks the latest hour and minute on the first day
+// that matches.
+func (s *cronSchedule) lastAt(t, since time.Time) (time.Time, bool) {
+	t = t.Truncate(time.Minute)
+	y, m, d := t.Date()
+	day := time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	for lastHour, lastMinute := t.Hour(), t.Minute(); !day.Add(24 * time.Hour).Before(since); day = day.AddDate(0, 0, -1) {
+		if s.matchesDay(day) {
+			for hour := highestBit(s.hour, lastHour); hour >= 0; hour = highestBit(s.hour, hour-1) {
+				if hour != lastHour {
+					lastMinute = 59
+				}
+				if minute := highestBit(s.minute, lastMinute); minute >= 0 {
+					start := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, t.Location())
+					return start, !start.Before(since)
+				}
+			}
+		}
+		lastHour, lastMinute = 23, 59
+	}
+	return time.Time{}, false
+}
+
+// Returns the highest value no greater than max in a set of values, or -1 if
+// there isn't one.
+func highestBit(set uint64, max int) int {
+	if max < 0 {
+		return -1
+	}
+	set &= 1<<uint(max+1) - 1
+	if set == 0 {
+		return -1
+	}
+	return 63 - bits.LeadingZeros64(set)
+}
+
+// The events running now, in the order they're listed in the config.
+var runningEvents struct {
+	sync.RWMutex
+	events []*scheduledEvent
+	// The decorations the lobbies were last given, guarded by updating.
+	lobbyEvent uint16
+	// Held while working out which events are running, so that the scheduler
+	// and a reload don't both announce the same change.
+	updating sync.Mutex
+}
+
+func currentEvents() []*scheduledEvent {
+	runningEvents.RLock()
+	defer runningEvents.RUnlock()
+	return runningEvents.events
+}
+
+// Returns the name to show for the events running now: event_name if it's set,
+// or else the names of the scheduled events.
+func currentEventName() string {
+	if name := config.CurrentEvent(); name != "" {
+		return name
+	}
+	var names []string
+	for _, event := range currentEvents() {
+		names = append(names, event.Name)
+	}
+	return strings.Join(names, " and ")
+}
+
+// Returns the lobby decorations for the events running now, or lobby_event
+// from the config if none of them have any.
+func currentLobbyEvent() uint16 {
+	for _, event := range currentEvents() {
+		if event.LobbyEvent != 0 {
+			return event.LobbyEvent
+		}
+	}
+	return config.DefaultLobbyEvent()
+}
+
+// Returns the scroll message template of the events running now, or nil if
+// none of them have one.
+func currentEventScrollTemplate() *template.Template {
+	for _, event := range currentEvents() {
+		if event.scrollTemplate != nil {
+			return event.scrollTemplate
+		}
+	}
+	return nil
+}
+
+// Returns how much the events running now multiply the drop, rare drop, and
+// experience rates by.
+func currentEventRates() (dropRate, rareRate, expRate float64) {
+	dropRate, rareRate, expRate = 1, 1, 1
+	for _, event := range currentEvents() {
+		if event.DropRate > 0 {
+			dropRate *= event.DropRate
+		}
+		if event.RareRate > 0 {
+			rareRate *= event.RareRate
+		}
+		if event.ExpRate > 0 {
+			expRate *= event.ExpRate
+		}
+	}
+	return dropRate, rareRate, expRate
+}
+
+// Loop for the life of the server, starting and ending the events at the start
+// of each minute.
+func runEventScheduler() {
+	updateEvents(time.Now())
+	for {
+		now := time.Now()
+		time.Sleep(now.Truncate(time.Minute).Add(time.Minute).Sub(now))
+		updateEvents(time.Now())
+	}
+}
+
+// Work out which events are running at now, and redecorate the lobbies if that
+// changes their decorations.
+func updateEvents(now time.Time) {
+	runningEvents.updating.Lock()
+	defer runningEvents.updating.Unlock()
+	var running []*scheduledEvent
+	for _, event := range config.ScheduledEvents() {
+		if event.runningAt(now) {
+			running = append(running, event)
+		}
+	}
+	runningEvents.Lock()
+	previous := runningEvents.events
+	runningEvents.events = running
+	runningEvents.Unlock()
+
+	for _, event := range running {
+		if !containsEvent(previous, event.Name) {
+			log.Infof("Event %s started", event.Name)
+		}
+	}
+	for _, event := range previous {
+		if !containsEvent(running, event.Name) {
+			log.Infof("Event %s ended", event.Name)
+		}
+	}
+	if next := currentLobbyEvent(); next != runningEvents.lobbyEvent {
+		runningEvents.lobbyEvent = next
+		sendLobbyEvent(next)
+	}
+}
+
+// Events are compared by name, since reloading the config replaces them.
+func containsEvent(events []*scheduledEvent, name string) bool {
+	for _, event := range events {
+		if event.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Change the decorations for everyone in a lobby on our blocks, except for the
+// lobbies that a GM has decorated. Players in games see the new ones when they
+// return to the lobby.
+func sendLobbyEvent(event uint16) {
+	for _, c := range players.List() {
+		// The lobby can only be looked at from the player's own goroutine.
+		c := c
+		c.Queue(func() {
+			if lobby := c.lobby; lobby == nil || lobby.EventOverridden() {
+				return
+			}
+			if err := EncryptAndSend(c, &packets.BBHeader{Type: packets.LobbyEventType, Flags: uint32(event)}); err != nil {
+				c.log.Warn("Failed to send lobby event: " + err.Error())
+			}
+		})
+	}
+}
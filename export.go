@@ -0,0 +1,349 @@
+/*
+* Exporting characters to JSON and importing them again, for moving characters
+* between servers and for players' own backups. Exports look like this:
+*
+*	{
+*	  "format": 1,
+*	  "exported_at": "2026-01-02T15:04:05Z",
+*	  "preview": {"name": "Sue", "class": 9, "section_id": 4, "level": 42},
+*	  "character": { ... },
+*	  "inventory": [ ... ],
+*	  "bank": {"meseta": 1000, "items": [ ... ]},
+*	  "techniques": [ ... ],
+*	  "options": { ... }
+*	}
+*
+* format is bumped whenever a change would keep older servers from importing the
+* file correctly, and files from newer formats are refused. character holds the
+* stats and looks from the character's preview with the JSON names given in
+* data.Character, items are as in data.Item, techniques has the level of each of
+* the 19 techniques (0 if it hasn't been learned), and options holds the key
+* config and other options of the account (see data.PlayerOptions). Fields that
+* are raw bytes, such as the UTF-16 name and the items' data, are base64 encoded.
+* The preview is only there for people reading the file and is ignored when it's
+* imported.
+*
+* Characters are only imported into empty slots. The options are only imported
+* if the account doesn't have any yet, since they're shared by all of the
+* account's characters, and the bank is left behind if the ship shares banks
+* between characters rather than overwriting the shared one. Exports are of the
+* character as it was last saved, so exporting a character while its player is
+* online may miss their last few minutes.
+ */
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dcrodman/archon/data"
+	"github.com/dcrodman/archon/packets"
+)
+
+const (
+	// Version of the export format written by this server.
+	characterExportFormat = 1
+	// Exports are too large for the web API's usual limit on requests.
+	maxCharacterImportSize = 1 << 20
+)
+
+type characterExport struct {
+	Format     int                 `json:"format"`
+	ExportedAt time.Time           `json:"exported_at"`
+	Preview    characterPreview    `json:"preview"`
+	Character  *data.Character     `json:"character"`
+	Inventory  []data.Item         `json:"inventory"`
+	Bank       *data.Bank          `json:"bank"`
+	Techniques data.Techniques     `json:"techniques"`
+	Options    *data.PlayerOptions `json:"options,omitempty"`
+}
+
+// The parts of the character worth showing to someone reading an export.
+type characterPreview struct {
+	Name      string `json:"name"`
+	Class     byte   `json:"class"`
+	SectionID byte   `json:"section_id"`
+	Level     uint32 `json:"level"`
+}
+
+type adminImportRequest struct {
+	Account   string          `json:"account"`
+	Slot      uint32          `json:"slot"`
+	Character characterExport `json:"character"`
+}
+
+type adminImportResponse struct {
+	Guildcard uint32 `json:"guildcard"`
+	Slot      uint32 `json:"slot"`
+	// Whether the options and bank in the export were imported (see above).
+	Options bool `json:"options"`
+	Bank    bool `json:"bank"`
+}
+
+// Look up the guildcard of the account named username.
+func accountGuildcard(username string) (uint32, error) {
+	account, err := database.FindAccount(username)
+	if err != nil {
+		return 0, err
+	} else if account == nil {
+		return 0, errors.New("no account named " + username)
+	}
+	return uint32(account.Guildcard), nil
+}
+
+// Export the character in a slot of the account with a guildcard.
+func exportCharacter(guildcard, slot uint32) (*characterExport, error) {
+	character, err := database.FindCharacter(guildcard, slot)
+	if err != nil {
+		return nil, err
+	} else if character == nil {
+		return nil, data.ErrCharacterNotFound
+	}
+	export := &characterExport{
+		Format:     characterExportFormat,
+		ExportedAt: time.Now().UTC(),
+		Preview: characterPreview{
+			Name:      characterName(character),
+			Class:     character.Class,
+			SectionID: character.SectionID,
+			Level:     character.Level + 1,
+		},
+		Character: character,
+	}
+	if export.Inventory, err = database.FindItems(guildcard, slot, data.ItemLocationInventory); err != nil {
+		return nil, err
+	}
+	bankSlot := slot
+	if config.SharedBank {
+		bankSlot = data.SharedBankSlot
+	}
+	if export.Bank, err = database.FindBank(guildcard, bankSlot); err != nil {
+		return nil, err
+	}
+	if export.Techniques, err = database.FindTechniques(guildcard, slot); err != nil {
+		return nil, err
+	}
+	if export.Options, err = database.FindPlayerOptions(guildcard); err != nil {
+		return nil, err
+	}
+	return export, nil
+}
+
+// Returns an error if an export can't be imported as it is.
+func (export *characterExport) validate() error {
+	if export.Format < 1 || export.Format > characterExportFormat {
+		return fmt.Errorf("unsupported export format %d", export.Format)
+	} else if export.Character == nil {
+		return errors.New("the export has no character")
+	}
+	character := export.Character
+	if len(character.Name) > 32 || len(character.GuildcardStr) > 16 {
+		return errors.New("the character's name is too long")
+	} else if character.Meseta > MaxMeseta {
+		return errors.New("the character has too much meseta")
+	} else if character.Level >= MaxLevel {
+		return errors.New("the character's level is too high")
+	} else if len(export.Inventory) > MaxInventoryItems {
+		return errors.New("the inventory has too many items")
+	}
+	if err := validateExportedItems(export.Inventory); err != nil {
+		return err
+	}
+	if bank := export.Bank; bank != nil {
+		if bank.Meseta > MaxMeseta {
+			return errors.New("the bank has too much meseta")
+		} else if len(bank.Items) > packets.MaxBankItems {
+			return errors.New("the bank has too many items")
+		}
+		if err := validateExportedItems(bank.Items); err != nil {
+			return err
+		}
+	}
+	if export.Options != nil {
+		return export.Options.Validate()
+	}
+	return nil
+}
+
+func validateExportedItems(items []data.Item) error {
+	for i, item := range items {
+		if len(item.Data) != 12 || len(item.Data2) != 4 {
+			return fmt.Errorf("item %d is malformed", i)
+		}
+	}
+	return nil
+}
+
+// Import a character into an empty slot of the account with a guildcard.
+func importCharacter(guildcard, slot uint32, export *characterExport) (adminImportResponse, error) {
+	resp := adminImportResponse{Guildcard: guildcard, Slot: slot}
+	if slot >= NumCharacterSlots {
+		return resp, fmt.Errorf("slot must be less than %d", NumCharacterSlots)
+	} else if err := export.validate(); err != nil {
+		return resp, err
+	}
+
+	character := *export.Character
+	character.Guildcard = int(guildcard)
+	character.Slot = slot
+	character.NameKey = characterNameKey(guildcard, character.Name)
+	snapshot := &data.CharacterSnapshot{
+		Guildcard:  guildcard,
+		Slot:       slot,
+		Character:  &character,
+		Inventory:  export.Inventory,
+		Techniques: export.Techniques,
+	}
+	if export.Bank != nil && !config.SharedBank {
+		snapshot.BankSlot, snapshot.Bank = slot, export.Bank
+		resp.Bank = true
+	}
+	if err := database.ImportCharacter(snapshot); err != nil {
+		return resp, err
+	}
+	cacheDelete(previewCacheKey(guildcard, slot))
+
+	if export.Options != nil {
+		options, err := database.FindPlayerOptions(guildcard)
+		if err != nil {
+			return resp, err
+		} else if options == nil {
+			imported := *export.Options
+			imported.Guildcard = guildcard
+			if err = database.UpdatePlayerOptions(&imported); err != nil {
+				return resp, err
+			}
+			cacheDelete(optionsCacheKey(guildcard))
+			resp.Options = true
+		}
+	}
+	return resp, nil
+}
+
+func runCharacterExportCommand(args []string) error {
+	usage := errors.New("usage: character export <username> <slot> [file] | import <username> <slot> <file>")
+	if len(args) < 3 {
+		return usage
+	}
+	guildcard, err := accountGuildcard(args[1])
+	if err != nil {
+		return err
+	}
+	slot, err := strconv.ParseUint(args[2], 10, 32)
+	if err != nil || slot >= NumCharacterSlots {
+		return fmt.Errorf("slot must be less than %d", NumCharacterSlots)
+	}
+
+	switch {
+	case args[0] == "export" && len(args) <= 4:
+		export, err := exportCharacter(guildcard, uint32(slot))
+		if err != nil {
+			return err
+		}
+		contents, err := json.MarshalIndent(export, "", "  ")
+		if err != nil {
+			return err
+		}
+		if len(args) == 3 {
+			fmt.Println(string(contents))
+			return nil
+		}
+		if err = ioutil.WriteFile(args[3], append(contents, '\n'), 0644); err != nil {
+			return err
+		}
+		fmt.Printf("Exported %s from slot %d of %s to %s\n", export.Preview.Name, slot, args[1], args[3])
+	case args[0] == "import" && len(args) == 4:
+		contents, err := ioutil.ReadFile(args[3])
+		if err != nil {
+			return err
+		}
+		var export characterExport
+		if err = json.Unmarshal(contents, &export); err != nil {
+			return errors.New("malformed export: " + err.Error())
+		}
+		resp, err := importCharacter(guildcard, uint32(slot), &export)
+		if err == data.ErrCharacterNameTaken {
+			return errors.New("another character already has that name")
+		} else if err != nil {
+			return err
+		}
+		fmt.Printf("Imported %s into slot %d of %s\n", export.Preview.Name, slot, args[1])
+		if export.Options != nil && !resp.Options {
+			fmt.Println("The account already has options, so the exported ones were left out")
+		}
+		if export.Bank != nil && !resp.Bank {
+			fmt.Println("Banks are shared on this server, so the exported bank was left out")
+		}
+	default:
+		return usage
+	}
+	return nil
+}
+
+// GET exports the character in a slot given by the account and slot query
+// parameters.
+func handleAdminExportCharacter(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	guildcard, err := accountGuildcard(req.URL.Query().Get("account"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	slot, err := strconv.ParseUint(req.URL.Query().Get("slot"), 10, 32)
+	if err != nil || slot >= NumCharacterSlots {
+		writeError(w, http.StatusBadRequest, "slot is required")
+		return
+	}
+	export, err := exportCharacter(guildcard, uint32(slot))
+	if err == data.ErrCharacterNotFound {
+		writeError(w, http.StatusNotFound, "no character in that slot")
+		return
+	} else if err != nil {
+		log.Error("Failed to export character: " + err.Error())
+		writeError(w, http.StatusInternalServerError, "unable to export character")
+		return
+	}
+	writeJSON(w, http.StatusOK, export)
+}
+
+// POST imports a character into an empty slot.
+func handleAdminImportCharacter(w http.ResponseWriter, req *http.Request) {
+	var body adminImportRequest
+	if !readJSONUpTo(w, req, &body, maxCharacterImportSize) {
+		return
+	}
+	guildcard, err := accountGuildcard(body.Account)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if body.Slot >= NumCharacterSlots {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("slot must be less than %d", NumCharacterSlots))
+		return
+	} else if err = body.Character.validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	resp, err := importCharacter(guildcard, body.Slot, &body.Character)
+	switch {
+	case err == data.ErrSlotInUse:
+		writeError(w, http.StatusConflict, "there's already a character in that slot")
+	case err == data.ErrCharacterNameTaken:
+		writeError(w, http.StatusConflict, "another character already has that name")
+	case err != nil:
+		log.Error("Failed to import character: " + err.Error())
+		writeError(w, http.StatusInternalServerError, "unable to import character")
+	default:
+		log.Infof("Imported character into slot %d for guildcard %d by admin request from %s",
+			body.Slot, guildcard, req.RemoteAddr)
+		writeJSON(w, http.StatusCreated, resp)
+	}
+}
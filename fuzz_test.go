@@ -0,0 +1,128 @@
+/*
+* Fuzz tests for the packet handling of the login and character servers. Each
+* input is handed to the server as one packet from a fresh client, through the
+* same size checks and Handle call that the connection loop uses; handlers are
+* expected to refuse bad packets with an error, never to panic.
+*
+* The seed corpora are the packets that the client package sent while logging
+* in to the servers and going through character creation and selection, taken
+* from packet captures (see capture.go) in testdata/captures. The account and
+* password in them are the ones the integration test uses, which are registered
+* here so that the seeds get past the login checks.
+ */
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/dcrodman/archon/packets"
+)
+
+// Add the packets that clients sent in a capture file in testdata/captures to
+// the seed corpus.
+func addCapturedPackets(f *testing.F, name string) {
+	file, err := os.Open(filepath.Join("testdata", "captures", name))
+	if err != nil {
+		f.Fatal(err)
+	}
+	defer file.Close()
+	decoder := json.NewDecoder(file)
+	for {
+		var pkt capturedPacket
+		if err = decoder.Decode(&pkt); err == io.EOF {
+			break
+		} else if err != nil {
+			f.Fatal(err)
+		}
+		if pkt.Direction != captureIn {
+			continue
+		}
+		packet, err := hex.DecodeString(pkt.Data)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(packet)
+	}
+}
+
+// Set up the config and database for fuzzing s with the captured packets in
+// the named file.
+func setupFuzzing(f *testing.F, s Server, captures string) {
+	setupTestEnvironment(f)
+	// Checking the password in a login packet would otherwise take up nearly
+	// all of the fuzzer's time.
+	bcryptCost = bcrypt.MinCost
+	f.Cleanup(func() { bcryptCost = bcrypt.DefaultCost })
+	if _, err := RegisterAccount(integrationUsername, integrationPassword, ""); err != nil {
+		f.Fatal(err)
+	}
+	if err := s.Init(); err != nil {
+		f.Fatal(err)
+	}
+	addCapturedPackets(f, captures)
+}
+
+// Hand packet to s as though a newly connected client had sent it, then clean
+// up after the client the way the connection loop would.
+func handleFuzzedPacket(s Server, packet []byte) {
+	conn, other := net.Pipe()
+	go io.Copy(ioutil.Discard, other)
+	c := NewClient(conn, packets.BBHeaderSize, nil, nil)
+	defer func() {
+		if dh, ok := s.(DisconnectHandler); ok {
+			dh.Disconnect(c)
+		}
+		c.Close()
+		other.Close()
+		loginSessions.Release(c)
+		close(c.disconnected)
+		c.releaseBuffer()
+	}()
+
+	// Lay the packet out in the buffer as Process would have after reading it.
+	// Process stops at a declared size smaller than the header and waits for
+	// the rest of a packet that's longer than what was sent.
+	if len(packet) < packets.BBHeaderSize {
+		return
+	}
+	size := c.declaredSize(packet)
+	if size < packets.BBHeaderSize {
+		return
+	}
+	size += size % packets.BBHeaderSize
+	if len(packet) < int(size) {
+		return
+	}
+	c.releaseBuffer()
+	c.buffer = append([]byte(nil), packet[:size]...)
+	c.recvSize, c.packetSize = int(size), size
+
+	if validatePacket(c, s) == nil {
+		s.Handle(c)
+	}
+}
+
+func FuzzLoginPacket(f *testing.F) {
+	server := new(LoginServer)
+	setupFuzzing(f, server, "login.jsonl")
+	f.Fuzz(func(t *testing.T, packet []byte) {
+		handleFuzzedPacket(server, packet)
+	})
+}
+
+func FuzzCharacterPacket(f *testing.F) {
+	server := new(CharacterServer)
+	setupFuzzing(f, server, "character.jsonl")
+	f.Fuzz(func(t *testing.T, packet []byte) {
+		handleFuzzedPacket(server, packet)
+	})
+}
@@ -0,0 +1,243 @@
+/*
+* Games (parties) created by players on the block servers. Games aren't tied
+* to a particular block, so the registry is shared by all of them.
+ */
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dcrodman/archon/packets"
+)
+
+const (
+	// MaxGamePlayers is the number of players allowed in a game.
+	MaxGamePlayers = 4
+	// Id sent in the menu selection packet to tell the server that
+	// the selection was made on the game menu.
+	GameSelectionMenuId uint16 = 0x11
+)
+
+// Game is an instance of a party created by a player.
+type Game struct {
+	id       uint32
+	name     [16]uint16
+	password [16]uint16
+
+	difficulty    uint8
+	battleMode    uint8
+	challengeMode uint8
+	episode       uint8
+	soloMode      uint8
+	sectionId     uint8
+	rareSeed      uint32
+	// Seeds the stock of the shops so that it stays the same for the whole game.
+	shopSeed uint32
+
+	// Set once the leader starts a quest. questLoading holds the client ids of
+	// the players who haven't finished loading it yet.
+	questLock    sync.Mutex
+	quest        *Quest
+	questLoading map[uint8]bool
+	// When everyone finished loading the challenge mode stage being played, and
+	// whether it's been cleared.
+	stageStarted time.Time
+	stageCleared bool
+
+	// Rules the leader picked for a battle mode game and the battle being
+	// fought once everyone has loaded the battle quest.
+	battleLock  sync.Mutex
+	battleRules *battleRuleset
+	battle      *battle
+
+	// Items dropped in the game that are lying on the floor, keyed by item id.
+	// dropped holds the enemies and boxes that have already dropped something,
+	// and pickedUp the ids of the items that have been taken off the floor.
+	itemLock   sync.Mutex
+	floorItems map[uint32]*floorItem
+	nextItemId uint32
+	dropped    map[uint32]bool
+	pickedUp   map[uint32]bool
+
+	// Trades that players have offered, keyed by client id.
+	tradeLock sync.Mutex
+	trades    map[uint8]*tradeOffer
+
+	// Types of the enemies that players have asked for drops from and the client
+	// ids of the players who killed them, keyed by enemy number (see enemyNumber).
+	enemyLock  sync.Mutex
+	enemyTypes map[uint16]uint8
+	kills      map[uint16]uint8
+	// Enemies in the game, if the server has its map (see maps.go).
+	layout *mapLayout
+
+	clientSlots
+}
+
+// Returns true if the password matches the one set when the game was created.
+func (g *Game) CheckPassword(password [16]uint16) bool {
+	return g.password[0] == 0 || g.password == password
+}
+
+// Join adds the client to the game, sends them the game state, and lets
+// the other players know that they've arrived.
+func (g *Game) Join(c *Client) error {
+	if _, err := g.add(c); err != nil {
+		return errors.New("Game is full")
+	}
+	c.game = g
+	c.assignItemIds()
+	if c.lastLobby != nil {
+		reportPlayer(c, c.lastLobby.block)
+	}
+	leader := g.Leader()
+	clients := g.Clients()
+
+	pkt := &packets.GameJoinPacket{
+		Header:        packets.BBHeader{Type: packets.GameJoinType, Flags: uint32(len(clients))},
+		ClientId:      c.clientId,
+		LeaderId:      leader,
+		DisableUDP:    0x01,
+		Difficulty:    g.difficulty,
+		BattleMode:    g.battleMode,
+		SectionId:     g.sectionId,
+		ChallengeMode: g.challengeMode,
+		RareSeed:      g.rareSeed,
+		Episode:       g.episode,
+		SoloMode:      g.soloMode,
+	}
+	for _, other := range clients {
+		pkt.Players[other.clientId] = newPlayerJoinEntry(other).Player
+	}
+	c.log.Debug("Sending Game Join Packet")
+	if err := EncryptAndSend(c, pkt); err != nil {
+		return err
+	}
+
+	addPkt := &packets.LobbyJoinPacket{
+		Header:     packets.BBHeader{Type: packets.GameAddPlayerType, Flags: 1},
+		ClientId:   c.clientId,
+		LeaderId:   leader,
+		DisableUDP: 0x01,
+		Entries:    []packets.PlayerJoinEntry{newPlayerJoinEntry(c)},
+	}
+	g.Broadcast(addPkt, c)
+	return nil
+}
+
+// Leave removes the client from the game and notifies the other players. The
+// game is removed from the registry once the last player leaves.
+func (g *Game) Leave(c *Client) {
+	if !g.remove(c) {
+		return
+	}
+	c.game = nil
+	if len(g.Clients()) == 0 {
+		games.Remove(g)
+		return
+	}
+	g.questLoaded(c)
+	g.cancelTrade(c, true)
+	g.leaveBattle(c)
+	pkt := &packets.LobbyLeavePacket{
+		Header:   packets.BBHeader{Type: packets.GameLeaveType, Flags: uint32(c.clientId)},
+		ClientId: c.clientId,
+		LeaderId: g.Leader(),
+	}
+	g.Broadcast(pkt, nil)
+}
+
+// Synchronized registry of the active games.
+type gameList struct {
+	games  map[uint32]*Game
+	nextId uint32
+	sync.RWMutex
+}
+
+var games = &gameList{games: make(map[uint32]*Game)}
+
+// Returns whether games of an episode (1-3, as the client numbers them) can be
+// played on the ship. Episode 4 can be turned off for rulesets with only
+// Episodes 1 and 2, in which case its quests, drop tables, experience, and map
+// layouts aren't loaded either.
+func episodeAvailable(episode uint8) bool {
+	return episode != 3 || config.Episode4
+}
+
+// Create registers a new game from the client's request. The creator's
+// section ID determines the section ID of the game.
+func (gl *gameList) Create(creator *Client, pkt *packets.GameCreatePacket) (*Game, error) {
+	if pkt.Name[0] == 0 {
+		return nil, errors.New("Game name is required")
+	} else if pkt.Difficulty > 3 {
+		return nil, errors.New("Invalid difficulty")
+	} else if pkt.Episode < 1 || pkt.Episode > 3 {
+		return nil, errors.New("Invalid episode")
+	} else if pkt.ChallengeMode != 0 && (pkt.BattleMode != 0 || pkt.SoloMode != 0) ||
+		pkt.BattleMode != 0 && pkt.SoloMode != 0 {
+		return nil, errors.New("Invalid game mode")
+	} else if pkt.ChallengeMode != 0 && challengeStages(pkt.Episode) == 0 {
+		return nil, errors.New("Challenge mode isn't available in this episode")
+	}
+
+	g := &Game{
+		name:          pkt.Name,
+		password:      pkt.Password,
+		difficulty:    pkt.Difficulty,
+		battleMode:    pkt.BattleMode,
+		challengeMode: pkt.ChallengeMode,
+		episode:       pkt.Episode,
+		soloMode:      pkt.SoloMode,
+		floorItems:    make(map[uint32]*floorItem),
+		nextItemId:    FirstDropItemId,
+		dropped:       make(map[uint32]bool),
+		pickedUp:      make(map[uint32]bool),
+		trades:        make(map[uint8]*tradeOffer),
+		enemyTypes:    make(map[uint16]uint8),
+		kills:         make(map[uint16]uint8),
+		clientSlots:   newClientSlots(MaxGamePlayers),
+	}
+	if creator.character != nil {
+		g.sectionId = creator.character.SectionID
+	}
+	g.layout = mapLayouts.Find(g)
+	binary.Read(rand.Reader, binary.LittleEndian, &g.rareSeed)
+	binary.Read(rand.Reader, binary.LittleEndian, &g.shopSeed)
+
+	gl.Lock()
+	gl.nextId++
+	g.id = gl.nextId
+	gl.games[g.id] = g
+	gl.Unlock()
+	return g, nil
+}
+
+// Find returns the game with the specified id or nil if it doesn't exist.
+func (gl *gameList) Find(id uint32) *Game {
+	gl.RLock()
+	defer gl.RUnlock()
+	return gl.games[id]
+}
+
+func (gl *gameList) Remove(g *Game) {
+	gl.Lock()
+	delete(gl.games, g.id)
+	gl.Unlock()
+}
+
+// List returns the active games in the order in which they were created.
+func (gl *gameList) List() []*Game {
+	gl.RLock()
+	list := make([]*Game, 0, len(gl.games))
+	for _, g := range gl.games {
+		list = append(list, g)
+	}
+	gl.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].id < list[j].id })
+	return list
+}
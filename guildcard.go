@@ -0,0 +1,216 @@
+/*
+* Guildcard lists kept for each account. The character server sends them to the
+* client at login and the block servers save any changes the player makes.
+ */
+package main
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/dcrodman/archon/data"
+	"github.com/dcrodman/archon/packets"
+)
+
+// Guildcards that a player has blocked. Other players' goroutines check this
+// before relaying messages to the player, so access is synchronized.
+type blockList struct {
+	sync.RWMutex
+	guildcards map[uint32]bool
+}
+
+func (b *blockList) set(entries []data.BlockedGuildcard) {
+	b.Lock()
+	defer b.Unlock()
+	b.guildcards = make(map[uint32]bool)
+	for _, entry := range entries {
+		b.guildcards[uint32(entry.BlockedGuildcard)] = true
+	}
+}
+
+// Adds a guildcard to the list, returning false if the list is full.
+func (b *blockList) add(guildcard uint32) bool {
+	b.Lock()
+	defer b.Unlock()
+	if b.guildcards == nil {
+		b.guildcards = make(map[uint32]bool)
+	}
+	if !b.guildcards[guildcard] && len(b.guildcards) >= packets.MaxBlockedGuildcards {
+		return false
+	}
+	b.guildcards[guildcard] = true
+	return true
+}
+
+func (b *blockList) remove(guildcard uint32) {
+	b.Lock()
+	defer b.Unlock()
+	delete(b.guildcards, guildcard)
+}
+
+// Blocks returns whether or not the player has blocked guildcard.
+func (b *blockList) Blocks(guildcard uint32) bool {
+	b.RLock()
+	defer b.RUnlock()
+	return b.guildcards[guildcard]
+}
+
+// Load the player's blocked list from the database.
+func loadBlockedGuildcards(c *Client) error {
+	blocked, err := c.db().FindBlockedGuildcards(c.guildcard)
+	if err != nil {
+		return err
+	}
+	c.blocked.set(blocked)
+	return nil
+}
+
+func newGuildcardDataEntry(entry data.GuildcardEntry) packets.GuildcardDataEntry {
+	pktEntry := packets.GuildcardDataEntry{
+		Guildcard: uint32(entry.FriendGuildcard),
+		Language:  entry.Language,
+		SectionID: entry.SectionID,
+		CharClass: entry.Class,
+	}
+	copy(pktEntry.Name[:], entry.Name)
+	copy(pktEntry.TeamName[:], entry.TeamName)
+	copy(pktEntry.Description[:], entry.Description)
+	copy(pktEntry.Comment[:], entry.Comment)
+	return pktEntry
+}
+
+func newBlockedGuildcardEntry(entry data.BlockedGuildcard) packets.GuildcardEntry {
+	pktEntry := packets.GuildcardEntry{
+		Guildcard: uint32(entry.BlockedGuildcard),
+		Language:  entry.Language,
+		SectionID: entry.SectionID,
+		CharClass: entry.Class,
+	}
+	copy(pktEntry.Name[:], entry.Name)
+	copy(pktEntry.TeamName[:], entry.TeamName)
+	copy(pktEntry.Description[:], entry.Description)
+	return pktEntry
+}
+
+// Returns the text in a fixed-length UTF-16 field up to its null terminator.
+func trimUtf16(s []uint16) []uint16 {
+	for i, c := range s {
+		if c == 0 {
+			return s[:i]
+		}
+	}
+	return s
+}
+
+// The player added someone to their friend list.
+func (server *BlockServer) HandleGuildcardAdd(c *Client) error {
+	var pkt packets.GuildcardAddPacket
+	if err := c.Decode(&pkt); err != nil {
+		return err
+	}
+	e := pkt.Entry
+	if e.Guildcard == 0 || e.Guildcard == c.guildcard {
+		return nil
+	}
+
+	guildcards, err := c.db().FindGuildcardData(c.guildcard)
+	if err != nil {
+		return err
+	}
+	exists := false
+	for _, entry := range guildcards {
+		exists = exists || uint32(entry.FriendGuildcard) == e.Guildcard
+	}
+	if !exists && len(guildcards) >= packets.MaxGuildcards {
+		SendClientMessage(c, "Your guildcard list is full.")
+		return nil
+	}
+
+	err = c.db().AddGuildcard(&data.GuildcardEntry{
+		Guildcard:       int(c.guildcard),
+		FriendGuildcard: int(e.Guildcard),
+		Name:            trimUtf16(e.Name[:]),
+		TeamName:        trimUtf16(e.TeamName[:]),
+		Description:     trimUtf16(e.Description[:]),
+		Language:        e.Language,
+		SectionID:       e.SectionID,
+		Class:           e.CharClass,
+	})
+	if err != nil {
+		return errors.New("Failed to save guildcard: " + err.Error())
+	}
+	cacheDelete(guildcardCacheKey(c.guildcard))
+	return nil
+}
+
+// The player removed someone from their friend list.
+func (server *BlockServer) HandleGuildcardRemove(c *Client) error {
+	var pkt packets.GuildcardRemovePacket
+	if err := c.Decode(&pkt); err != nil {
+		return err
+	}
+	if err := c.db().RemoveGuildcard(c.guildcard, pkt.Guildcard); err != nil {
+		return errors.New("Failed to remove guildcard: " + err.Error())
+	}
+	cacheDelete(guildcardCacheKey(c.guildcard))
+	return nil
+}
+
+// The player changed the comment on a guildcard on their friend list.
+func (server *BlockServer) HandleGuildcardComment(c *Client) error {
+	var pkt packets.GuildcardCommentPacket
+	if err := c.Decode(&pkt); err != nil {
+		return err
+	}
+	err := c.db().UpdateGuildcardComment(c.guildcard, pkt.Guildcard, trimUtf16(pkt.Comment[:]))
+	if err != nil {
+		return errors.New("Failed to save guildcard comment: " + err.Error())
+	}
+	cacheDelete(guildcardCacheKey(c.guildcard))
+	return nil
+}
+
+// The player added someone to their blocked list.
+func (server *BlockServer) HandleGuildcardBlock(c *Client) error {
+	var pkt packets.GuildcardBlockPacket
+	if err := c.Decode(&pkt); err != nil {
+		return err
+	}
+	e := pkt.Entry
+	if e.Guildcard == 0 || e.Guildcard == c.guildcard {
+		return nil
+	}
+	if !c.blocked.add(e.Guildcard) {
+		SendClientMessage(c, "Your blocked list is full.")
+		return nil
+	}
+	err := c.db().AddBlockedGuildcard(&data.BlockedGuildcard{
+		Guildcard:        int(c.guildcard),
+		BlockedGuildcard: int(e.Guildcard),
+		Name:             trimUtf16(e.Name[:]),
+		TeamName:         trimUtf16(e.TeamName[:]),
+		Description:      trimUtf16(e.Description[:]),
+		Language:         e.Language,
+		SectionID:        e.SectionID,
+		Class:            e.CharClass,
+	})
+	if err != nil {
+		return errors.New("Failed to save blocked guildcard: " + err.Error())
+	}
+	cacheDelete(guildcardCacheKey(c.guildcard))
+	return nil
+}
+
+// The player removed someone from their blocked list.
+func (server *BlockServer) HandleGuildcardUnblock(c *Client) error {
+	var pkt packets.GuildcardUnblockPacket
+	if err := c.Decode(&pkt); err != nil {
+		return err
+	}
+	c.blocked.remove(pkt.Guildcard)
+	if err := c.db().RemoveBlockedGuildcard(c.guildcard, pkt.Guildcard); err != nil {
+		return errors.New("Failed to remove blocked guildcard: " + err.Error())
+	}
+	cacheDelete(guildcardCacheKey(c.guildcard))
+	return nil
+}
@@ -1,14 +1,16 @@
 package main
 
 import (
-	"container/list"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
-	"os"
 	"runtime/debug"
 	"sync"
+	"time"
 
+	"github.com/dcrodman/archon/data"
 	"github.com/dcrodman/archon/util"
 )
 
@@ -23,63 +25,141 @@ type Server interface {
 	Init() error
 	// Client factory responsible for performing whatever initialization is
 	// needed for Client objects to represent new connections.
-	NewClient(conn *net.TCPConn) (*Client, error)
+	NewClient(conn net.Conn) (*Client, error)
 	// Process the packet in the client's buffer. The dispatcher will
 	// read the latest packet from the client before calling.
 	Handle(c *Client) error
 }
 
-// Synchronized list for maintaining a list of connected clients.
-type clientList struct {
-	clients *list.List
-	sync.RWMutex
+// DisconnectHandler can be implemented by servers that need to clean up after
+// a client (removing them from a lobby, for example) when the connection closes.
+type DisconnectHandler interface {
+	Disconnect(c *Client)
 }
 
-func (c *clientList) Add(cl *Client) {
-	c.Lock()
-	c.clients.PushBack(cl)
-	c.Unlock()
+// Reloader can be implemented by servers that are able to refresh their data
+// (e.g. the patch files) while running. Reload is called on SIGHUP.
+type Reloader interface {
+	Reload() error
 }
 
-func (c *clientList) Remove(cl *Client) {
-	clAddr := cl.IPAddr()
-	c.RLock()
-	for clientElem := c.clients.Front(); clientElem != nil; clientElem = clientElem.Next() {
-		client := clientElem.Value.(*Client)
-		if client.IPAddr() == clAddr {
-			c.clients.Remove(clientElem)
-			break
-		}
-	}
-	c.RUnlock()
+// RateLimited can be implemented by servers whose new connections should be
+// throttled per address, generally the ones that check passwords.
+type RateLimited interface {
+	RateLimited()
 }
 
-// Returns true if the list has a Client matching the IP address of c.
-// Note that this comparison is by IP address, not element value.
-func (c *clientList) Has(cl *Client) bool {
-	clAddr := cl.IPAddr()
-	c.RLock()
-	defer c.RUnlock()
-	for clientElem := c.clients.Front(); clientElem != nil; clientElem = clientElem.Next() {
-		if cl.IPAddr() == clAddr {
-			return true
+// PacketSizer can be implemented by servers to give the smallest valid size of
+// each type of packet they handle. Shorter packets are rejected (and the client
+// disconnected) before they're passed to Handle.
+type PacketSizer interface {
+	MinPacketSizes() map[uint16]int
+}
+
+// Returns the number of bytes in the serialized form of a packet struct.
+func packetSize(pkt interface{}) int {
+	_, size := util.BytesFromStruct(pkt)
+	return size
+}
+
+// Check that the packet in the client's buffer is no longer than what was
+// received and, if the server knows, long enough for its type.
+func validatePacket(c *Client, s Server) error {
+	size := c.declaredSize(c.Data())
+	if size < c.hdrSize {
+		return fmt.Errorf("declared size %d is smaller than the header", size)
+	} else if int(size) > c.recvSize {
+		return fmt.Errorf("declared size %d is larger than the %d bytes received", size, c.recvSize)
+	}
+	if sizer, ok := s.(PacketSizer); ok {
+		pktType := c.packetType(c.Data())
+		if min, ok := sizer.MinPacketSizes()[pktType]; ok && int(size) < min {
+			return fmt.Errorf("packet %04x is %d bytes; expected at least %d", pktType, size, min)
 		}
 	}
-	return false
+	return nil
+}
+
+// Registry of the clients connected to each of the servers.
+type connRegistry struct {
+	clients map[*Client]Server
+	sync.RWMutex
 }
 
-func (c *clientList) Len() int {
-	c.RLock()
-	defer c.RUnlock()
-	return c.clients.Len()
+func newConnRegistry() *connRegistry {
+	return &connRegistry{clients: make(map[*Client]Server)}
+}
+
+func (r *connRegistry) Add(c *Client, s Server) {
+	r.Lock()
+	r.clients[c] = s
+	r.Unlock()
+}
+
+func (r *connRegistry) Remove(c *Client) {
+	r.Lock()
+	delete(r.clients, c)
+	r.Unlock()
+}
+
+func (r *connRegistry) Len() int {
+	r.RLock()
+	defer r.RUnlock()
+	return len(r.clients)
+}
+
+// Clients returns the clients connected to a server, or to any server if s is nil.
+func (r *connRegistry) Clients(s Server) []*Client {
+	r.RLock()
+	defer r.RUnlock()
+	clients := make([]*Client, 0, len(r.clients))
+	for c, server := range r.clients {
+		if s == nil || server == s {
+			clients = append(clients, c)
+		}
+	}
+	return clients
 }
 
 // controller is responsible for standing up the server instances we need and
-// for dispatching handlers for each connected client.
+// for managing the lifecycle of each connection: accepting it, running its
+// goroutine, and tearing it down when either side hangs up or we shut down.
 type controller struct {
 	host        string
 	servers     []Server
-	connections *clientList
+	connections *connRegistry
+	// Shared by all of the RateLimited servers.
+	limiter *rateLimiter
+
+	listeners map[Server]*net.TCPListener
+	// Servers whose accept loops have exited, and whether that was expected
+	// because stopAccepting was called. Guarded by listenerLock.
+	listenerLock sync.Mutex
+	stopped      map[Server]bool
+	stopping     bool
+	// Tracks the accept loops and client goroutines.
+	handlers sync.WaitGroup
+	// Set once the server has started draining or shutting down (see drain.go).
+	draining     sync.Once
+	shuttingDown sync.Once
+	stopOnce     sync.Once
+	// Parent of the contexts that packets are handled with, cancelled on
+	// shutdown so that the queries they're waiting on give up.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newController(host string, limiter *rateLimiter) *controller {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &controller{
+		host:        host,
+		connections: newConnRegistry(),
+		limiter:     limiter,
+		listeners:   make(map[Server]*net.TCPListener),
+		stopped:     make(map[Server]bool),
+		ctx:         ctx,
+		cancel:      cancel,
+	}
 }
 
 // Registers a server instance to be brought up once the dispatcher is run.
@@ -88,105 +168,293 @@ func (controller *controller) registerServer(s Server) {
 }
 
 // Iterate over our registered servers, initializing TCP sockets on each of the
-// defined ports and setting up the connection handlers.
-func (controller *controller) start() *sync.WaitGroup {
-	var wg sync.WaitGroup
+// defined ports and setting up the connection handlers. Returns false if any
+// of the servers fail to start.
+func (controller *controller) start() bool {
 	for _, s := range controller.servers {
 		if err := s.Init(); err != nil {
-			fmt.Printf("Error initializing %s: %s\n", s.Name(), err.Error())
-			return nil
+			log.Errorf("Error initializing %s: %s", s.Name(), err.Error())
+			return false
 		}
 
 		// Open our server socket. All sockets must be open for the server
 		// to launch correctly, so errors are terminal.
-		hostAddr, err := net.ResolveTCPAddr("tcp", config.Hostname+":"+s.Port())
+		// An unspecified address ("::" or "0.0.0.0") listens on both IPv4 and IPv6.
+		hostAddr, err := net.ResolveTCPAddr("tcp", net.JoinHostPort(config.Hostname, s.Port()))
 		if err != nil {
-			fmt.Println("Error creating socket: " + err.Error())
-			os.Exit(1)
+			log.Fatal("Error creating socket: " + err.Error())
 		}
 		socket, err := net.ListenTCP("tcp", hostAddr)
 		if err != nil {
-			fmt.Println("Error listening on socket: " + err.Error())
-			os.Exit(1)
+			log.Fatal("Error listening on socket: " + err.Error())
 		}
+		controller.listeners[s] = socket
 
-		wg.Add(1)
+		controller.handlers.Add(1)
 		go func(s Server, socket *net.TCPListener) {
-			controller.startHandler(s, socket)
-			wg.Done()
+			defer controller.handlers.Done()
+			controller.acceptConnections(s, socket)
 		}(s, socket)
 	}
 
 	for _, s := range controller.servers {
-		fmt.Printf("Waiting for %s connections on %v:%v\n", s.Name(), controller.host, s.Port())
+		fmt.Printf("Waiting for %s connections on %v\n", s.Name(), net.JoinHostPort(controller.host, s.Port()))
 	}
 	log.Infof("Controller: Server Initialized")
-	return &wg
+	return true
+}
+
+// Block until the servers have been shut down and every client has disconnected.
+func (controller *controller) wait() {
+	controller.handlers.Wait()
+}
+
+// Stop accepting new connections on each server for which stop returns true.
+// Clients that are already connected are left alone. Listeners that have
+// already been closed are skipped.
+func (controller *controller) stopAccepting(stop func(s Server) bool) {
+	controller.listenerLock.Lock()
+	defer controller.listenerLock.Unlock()
+	controller.stopping = true
+	for s, socket := range controller.listeners {
+		if stop(s) {
+			socket.Close()
+			delete(controller.listeners, s)
+		}
+	}
 }
 
-// Client connection handling loop, started for each server.
-func (controller *controller) startHandler(server Server, socket *net.TCPListener) {
-	defer fmt.Println(server.Name() + " shutdown.")
+// Shut down the servers: stop accepting connections, cancel the packets being
+// handled, and disconnect everyone. wait() returns once all of the client
+// goroutines have cleaned up. Calls after the first do nothing.
+func (controller *controller) shutdown() {
+	controller.stopOnce.Do(func() {
+		controller.stopAccepting(func(Server) bool { return true })
+		controller.cancel()
+		for _, c := range controller.connections.Clients(nil) {
+			c.Close()
+		}
+	})
+}
+
+// Reload the config file and ask each server that supports it to reload its
+// data. Errors are logged rather than returned since a failed reload leaves
+// the old data in place.
+func (controller *controller) reload() {
+	log.Infof("Reloading config from %s", config.filename)
+	if err := config.Reload(); err != nil {
+		log.Errorf("Failed to reload config: %s", err.Error())
+	} else {
+		if err = setLogLevels(); err != nil {
+			log.Errorf("Failed to apply log levels: %s", err.Error())
+		}
+		if err = controller.limiter.configure(config.RateLimits()); err != nil {
+			log.Errorf("Failed to apply rate limits: %s", err.Error())
+		}
+		for _, c := range controller.connections.Clients(nil) {
+			updateCapture(c)
+		}
+		updateEvents(time.Now())
+	}
 
-	// Poll until we can accept more clients.
-	for controller.connections.Len() < config.MaxConnections {
+	for _, s := range controller.servers {
+		if r, ok := s.(Reloader); ok {
+			log.Infof("Reloading %s", s.Name())
+			if err := r.Reload(); err != nil {
+				log.Errorf("Failed to reload %s: %s", s.Name(), err.Error())
+			}
+		}
+	}
+}
+
+// Client connection accept loop, started for each server.
+func (controller *controller) acceptConnections(server Server, socket *net.TCPListener) {
+	defer subsystemLogger(server.Name()).Info("Shut down")
+	defer func() {
+		controller.listenerLock.Lock()
+		controller.stopped[server] = true
+		controller.listenerLock.Unlock()
+	}()
+
+	for {
 		conn, err := socket.AcceptTCP()
-		if err != nil {
+		if errors.Is(err, net.ErrClosed) {
+			return
+		} else if err != nil {
 			log.Warnf("Failed to accept connection: %v", err.Error())
 			continue
 		}
-		c, err := server.NewClient(conn)
-		// TODO: Disconnect the client if we already have a matching connection.
-		if err != nil {
-			log.Warn(err.Error())
-		} else {
-			log.Infof("Accepted %s connection from %s", server.Name(), c.IPAddr())
-			controller.handleClient(c, server)
+		if controller.connections.Len() >= config.MaxConnections {
+			log.Warnf("Refusing %s connection from %s: at max connections", server.Name(), conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+		if !proxyTrusted(conn.RemoteAddr()) {
+			controller.admit(server, conn)
+			continue
 		}
+		// The header is read off of the accept loop so that a slow proxy doesn't
+		// hold up everyone else's connections.
+		controller.handlers.Add(1)
+		go func(conn *net.TCPConn) {
+			defer controller.handlers.Done()
+			remote, err := readProxyHeader(conn)
+			if err != nil {
+				log.Warnf("Dropping %s connection from proxy %s: %s", server.Name(), conn.RemoteAddr(), err.Error())
+				conn.Close()
+				return
+			}
+			controller.admit(server, &proxiedConn{Conn: conn, remote: remote})
+		}(conn)
+	}
+}
+
+// Hand a new connection to its server, unless it's over the rate limits.
+func (controller *controller) admit(server Server, conn net.Conn) {
+	if _, ok := server.(RateLimited); ok && !controller.limiter.Allow(conn.RemoteAddr()) {
+		conn.Close()
+		return
+	}
+
+	c, err := server.NewClient(conn)
+	if err != nil {
+		log.Warn(err.Error())
+		conn.Close()
+	} else {
+		c.log = clientLogger(server.Name(), c)
+		c.log.Info("Accepted connection")
+		updateCapture(c)
+		controller.handleClient(c, server)
+	}
+}
+
+// Returns whether s is still accepting connections.
+func (controller *controller) listening(s Server) bool {
+	controller.listenerLock.Lock()
+	defer controller.listenerLock.Unlock()
+	return !controller.stopped[s]
+}
+
+// Returns whether the server has started draining or shutting down.
+func (controller *controller) isStopping() bool {
+	controller.listenerLock.Lock()
+	defer controller.listenerLock.Unlock()
+	return controller.stopping
+}
+
+// Pass the packet in the client's buffer to its server, with a context that's
+// cancelled once packet_timeout_seconds have passed or the server shuts down.
+// Players are asked to try again if the packet couldn't be handled because the
+// database is unavailable.
+func (controller *controller) handlePacket(c *Client, s Server) error {
+	ctx, cancel := controller.ctx, context.CancelFunc(func() {})
+	if config.PacketTimeoutSeconds > 0 {
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(config.PacketTimeoutSeconds)*time.Second)
 	}
+	defer cancel()
+	c.ctx = ctx
+	err := s.Handle(c)
+	c.ctx = nil
+	if ctx.Err() == context.DeadlineExceeded {
+		c.log.Warnf("Packet %04x took longer than %d seconds to handle",
+			c.packetType(c.Data()), config.PacketTimeoutSeconds)
+	}
+	if errors.Is(err, data.ErrUnavailable) {
+		SendClientMessage(c, "The server can't reach its database right now.\n\n"+
+			"Please try again in a few minutes.")
+	}
+	return err
 }
 
 // Spawn a dedicated goroutine for each Client for the length of each connection.
 func (controller *controller) handleClient(c *Client, s Server) {
+	controller.connections.Add(c, s)
+	controller.handlers.Add(1)
 	go func() {
+		// Packets are read on a goroutine of their own so that this one can run
+		// the tasks other clients queue for this client (see Client.Queue)
+		// while it waits on the connection.
+		packets := make(chan error)
+		resume, stop, readerDone := make(chan struct{}), make(chan struct{}), make(chan struct{})
+		go func() {
+			readPackets(c, packets, resume, stop)
+			close(readerDone)
+		}()
+
 		// Defer so that we catch any panics, disconnect the client, and
 		// remove them from the list regardless of the connection state.
 		defer func() {
 			if err := recover(); err != nil {
-				log.Errorf("Error in client communication: %s: %s\n%s\n",
-					c.IPAddr(), err, debug.Stack())
+				c.log.Errorf("Error in client communication: %s\n%s\n", err, debug.Stack())
+			}
+			if dh, ok := s.(DisconnectHandler); ok {
+				dh.Disconnect(c)
 			}
 			c.Close()
+			close(stop)
+			<-readerDone
 			controller.connections.Remove(c)
-			log.Infof("Disconnected %s client %s", s.Name(), c.IPAddr())
+			loginSessions.Release(c)
+			close(c.disconnected)
+			c.releaseBuffer()
+			c.log.Info("Disconnected")
+			controller.handlers.Done()
 		}()
-		controller.connections.Add(c)
 
 		// Connection loop; process packets until the connection is closed.
-		var pktHeader PCHeader
 		for {
-			err := c.Process()
+			var err error
+			select {
+			case <-c.taskReady:
+				c.runTasks()
+				continue
+			case err = <-packets:
+			}
 			if err == io.EOF {
 				break
 			} else if err != nil {
-				// Error communicating with the client.
-				log.Warn(err.Error())
+				// Error communicating with the client, or they timed out.
+				c.log.Warn(err.Error())
 				break
 			}
 
-			// PC and BB header packets have the same structure for the first four
-			// bytes, so for basic inspection it's safe to treat them the same way.
-			util.StructFromBytes(c.Data()[:PCHeaderSize], &pktHeader)
-			if config.DebugMode {
-				fmt.Printf("%s: Got %v bytes from client:\n", s.Name(), pktHeader.Size)
-				util.PrintPayload(c.Data(), int(pktHeader.Size))
-				fmt.Println()
+			if err = validatePacket(c, s); err != nil {
+				c.log.Warn("Disconnecting client after malformed packet: " + err.Error())
+				break
 			}
+			c.capturePacket(captureIn, c.Data()[:c.declaredSize(c.Data())])
 
-			if err = s.Handle(c); err != nil {
-				log.Warn("Error in client communication: " + err.Error())
+			if err = controller.handlePacket(c, s); err != nil {
+				c.log.Warn("Error in client communication: " + err.Error())
 				return
 			}
+			resume <- struct{}{}
 		}
 	}()
 }
+
+// Read packets from the client for handleClient, sending the result of each read
+// to packets and then waiting for resume before reading the next one into the
+// client's buffer. Returns after a failed read or once stop is closed.
+func readPackets(c *Client, packets chan<- error, resume, stop <-chan struct{}) {
+	idleTimeout := time.Duration(config.ClientIdleMinutes) * time.Minute
+	for {
+		if idleTimeout > 0 {
+			c.conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		}
+		err := c.Process()
+		select {
+		case packets <- err:
+		case <-stop:
+			return
+		}
+		if err != nil {
+			return
+		}
+		select {
+		case <-resume:
+		case <-stop:
+			return
+		}
+	}
+}
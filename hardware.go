@@ -0,0 +1,95 @@
+/*
+* Tracking of the machines that players log in from, identified by the hardware
+* info that the Blue Burst client sends with its login. The login server records
+* the machine for each login so that admins can ban every machine an account has
+* used and find players who have several accounts.
+ */
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dcrodman/archon/data"
+)
+
+// Note the machine the player on c logged in from. Failures are only logged
+// since they shouldn't keep the player from logging in.
+func recordHardware(c *Client) {
+	if c.hardwareId == "" {
+		return
+	}
+	if err := c.db().RecordHardware(c.username, c.hardwareId, c.IPAddr(), time.Now()); err != nil {
+		c.log.Warn("Failed to record hardware: " + err.Error())
+	}
+}
+
+// Run one of the hardware admin commands given on the command line:
+//
+//	archon hardware account <username>
+//	archon hardware machine <hardware id>
+//	archon hardware shared
+//	archon hardware ban <username> <duration|permanent> [reason]
+func runHardwareCommand(args []string) error {
+	switch {
+	case len(args) == 2 && args[0] == "account":
+		records, err := database.FindAccountHardware(args[1])
+		if err != nil {
+			return err
+		}
+		printHardware(records, "No hardware recorded for account "+args[1])
+	case len(args) == 2 && args[0] == "machine":
+		records, err := database.FindHardwareAccounts(args[1])
+		if err != nil {
+			return err
+		}
+		printHardware(records, "No accounts have logged in from "+args[1])
+	case len(args) == 1 && args[0] == "shared":
+		records, err := database.FindSharedHardware()
+		if err != nil {
+			return err
+		}
+		printHardware(records, "No machines are shared by more than one account")
+	case len(args) >= 3 && args[0] == "ban":
+		return banAccountHardware(args[1], args[2], strings.Join(args[3:], " "))
+	default:
+		return errors.New("usage: hardware account <username> | machine <hardware id> | " +
+			"shared | ban <username> <duration|permanent> [reason]")
+	}
+	return nil
+}
+
+func printHardware(records []data.AccountHardware, none string) {
+	if len(records) == 0 {
+		fmt.Println(none)
+	}
+	for _, record := range records {
+		fmt.Printf("%s %s: first seen %s, last seen %s from %s\n", record.HardwareId,
+			record.Username, record.FirstSeen.Local().Format(time.RFC1123),
+			record.LastSeen.Local().Format(time.RFC1123), record.LastIP)
+	}
+}
+
+// Ban every machine that an account has logged in from.
+func banAccountHardware(username, durationArg, reason string) error {
+	duration, err := parseBanDuration(durationArg)
+	if err != nil {
+		return err
+	}
+	records, err := database.FindAccountHardware(username)
+	if err != nil {
+		return err
+	} else if len(records) == 0 {
+		return errors.New("no hardware recorded for account " + username)
+	}
+	for _, record := range records {
+		ban, err := createBan(data.BanHardware, record.HardwareId, duration, reason, consoleActor())
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created ban %d for %s\n", ban.Id, record.HardwareId)
+	}
+	return nil
+}
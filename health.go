@@ -0,0 +1,98 @@
+/*
+* Health checks for load balancers and orchestrators, served on their own port:
+*
+*	GET /healthz    Fails if the database can't be reached or one of the servers
+*	                has stopped accepting connections when it shouldn't have.
+*	GET /readyz     Also fails once the server has started draining or shutting
+*	                down, so that new players are sent elsewhere.
+*
+* Both return the status of each server and the database as JSON, with a 200
+* if everything is up and a 503 if not.
+ */
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// How long to wait for the database to answer a health check.
+const healthCheckTimeout = 5 * time.Second
+
+type healthServer struct {
+	Name      string `json:"name"`
+	Port      string `json:"port"`
+	Listening bool   `json:"listening"`
+}
+
+type healthResponse struct {
+	OK       bool           `json:"ok"`
+	Database string         `json:"database"`
+	Draining bool           `json:"draining"`
+	Servers  []healthServer `json:"servers"`
+}
+
+// StartHealthServer starts serving the health checks for c if a port is set.
+func StartHealthServer(c *controller) {
+	if config.HealthPort == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, req *http.Request) {
+		c.writeHealth(w, req, false)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, req *http.Request) {
+		c.writeHealth(w, req, true)
+	})
+
+	server := &http.Server{
+		Addr:         net.JoinHostPort(config.Hostname, config.HealthPort),
+		Handler:      mux,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+	go func() {
+		fmt.Println("Serving health checks on " + server.Addr)
+		log.Error("Health server stopped: " + serveHTTP(server, "", "").Error())
+	}()
+}
+
+// Check the servers and the database. If ready is set then the check also
+// fails while we're draining or shutting down.
+func (controller *controller) writeHealth(w http.ResponseWriter, req *http.Request, ready bool) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	resp := healthResponse{OK: true, Database: "ok", Servers: []healthServer{}}
+
+	ctx, cancel := context.WithTimeout(req.Context(), healthCheckTimeout)
+	defer cancel()
+	if err := database.Ping(ctx); err != nil {
+		log.Warn("Health check failed to reach the database: " + err.Error())
+		resp.OK = false
+		resp.Database = err.Error()
+	}
+
+	resp.Draining = controller.isStopping()
+	for _, s := range controller.servers {
+		listening := controller.listening(s)
+		resp.Servers = append(resp.Servers, healthServer{Name: s.Name(), Port: s.Port(), Listening: listening})
+		// Servers stop listening on purpose while draining.
+		if !listening && !resp.Draining {
+			resp.OK = false
+		}
+	}
+	if ready && resp.Draining {
+		resp.OK = false
+	}
+
+	status := http.StatusOK
+	if !resp.OK {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, resp)
+}
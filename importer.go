@@ -0,0 +1,823 @@
+/*
+* Importing the accounts, characters, and guildcards from another server's
+* database, for moving a community over to Archon:
+*
+*	archon import tethealla <mysql dsn>
+*	archon import sylverant <mysql dsn>
+*	archon import newserv <system directory>
+*
+* where the DSN is in the format used by github.com/go-sql-driver/mysql, e.g.
+* "user:password@tcp(localhost:3306)/tethealla". The accounts are created with
+* new guildcard numbers, and whatever the source keeps of the characters and
+* their banks, key configs, and friend lists is carried over to them. Accounts
+* whose usernames (or emails) are already registered here are skipped along
+* with everything of theirs. Friends that weren't imported are left off of the
+* friend lists since their guildcard numbers mean nothing here. Accounts that
+* are banned on the source are given an account ban here that ends when theirs
+* does (or never, since only newserv's bans end).
+*
+* Tethealla keeps the client's full character data, its common banks, key
+* configs, and friend lists in tables of their own. Passwords keep their
+* Tethealla hashes until each player's next login (see password.go).
+*
+* Sylverant keeps Blue Burst logins in blueburst_clients and the characters in
+* character_data, compressed with zlib and in its own layout of the client's
+* sections. It salts its password hashes the same way as Tethealla, so they're
+* kept until the next login as well. Key configs and friend lists aren't
+* carried over.
+*
+* newserv keeps its licenses in licenses.nsi, and each player's account data
+* and characters in players/account_<username>.nsa and
+* players/player_<username>_<slot>.nsc, all under its system directory. Its
+* guildcard numbers are the licenses' serial numbers, and its passwords are kept
+* as they were typed, so they're hashed here. Newer versions of newserv that
+* keep licenses as JSON and characters in .psochar files aren't supported.
+*
+* The accounts that an import creates are remembered, so an import can be run
+* again after fixing whatever stopped it partway: the accounts it already
+* created are carried on with rather than skipped, and nothing that's already
+* been brought over (or that the players have changed since) is overwritten.
+* Characters are only imported into empty slots, common banks into empty banks,
+* and key configs into accounts without one, while friend list entries are
+* simply written again.
+ */
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dcrodman/archon/data"
+	"github.com/dcrodman/archon/packets"
+	"github.com/dcrodman/archon/util"
+)
+
+// Tethealla stores the full character packet, header and all.
+const tetheallaCharacterHeaderSize = 8
+
+// Names that imported accounts are recorded under, by the server they came from.
+const (
+	importSourceTethealla = "tethealla"
+	importSourceSylverant = "sylverant"
+	importSourceNewserv   = "newserv"
+)
+
+// Signatures at the start of newserv's account and player files.
+const (
+	newservAccountSignature = "newserv account file format; 7 sections present; sequential;"
+	newservPlayerSignature  = "newserv saved player file format; 10 sections present; sequential;"
+	newservSignatureSize    = 0x40
+	newservLicenseSize      = 0x54
+)
+
+// Counts of what an import created and skipped, for the summary.
+type importTally struct {
+	accounts, characters, banks, options, guildcards int
+	skipped                                          []string
+}
+
+func (t *importTally) skip(format string, args ...interface{}) {
+	t.skipped = append(t.skipped, fmt.Sprintf(format, args...))
+}
+
+func runImportCommand(args []string) error {
+	const usage = "usage: import tethealla|sylverant <mysql dsn> or import newserv <system directory>"
+	if len(args) != 2 {
+		return errors.New(usage)
+	}
+	var tally importTally
+	var err error
+	switch args[0] {
+	case importSourceTethealla:
+		err = importFromDatabase(args[1], "Tethealla", importTethealla, &tally)
+	case importSourceSylverant:
+		err = importFromDatabase(args[1], "Sylverant", importSylverant, &tally)
+	case importSourceNewserv:
+		err = importNewserv(args[1], &tally)
+	default:
+		return errors.New(usage)
+	}
+	if err != nil {
+		return err
+	}
+	for _, reason := range tally.skipped {
+		fmt.Println("Skipped " + reason)
+	}
+	fmt.Printf("Imported %d accounts, %d characters, %d common banks, %d key configs, and %d guildcards\n",
+		tally.accounts, tally.characters, tally.banks, tally.options, tally.guildcards)
+	return nil
+}
+
+// Connect to the MySQL database of another server and import from it.
+func importFromDatabase(dsn string, name string, importFn func(*sql.DB, *importTally) error, tally *importTally) error {
+	source, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	if err = source.Ping(); err != nil {
+		return fmt.Errorf("unable to connect to the %s database: %s", name, err.Error())
+	}
+	return importFn(source, tally)
+}
+
+func importTethealla(source *sql.DB, tally *importTally) error {
+	guildcards, err := importTetheallaAccounts(source, tally)
+	if err != nil {
+		return errors.New("importing accounts: " + err.Error())
+	}
+	if err = importTetheallaCharacters(source, guildcards, tally); err != nil {
+		return errors.New("importing characters: " + err.Error())
+	}
+	if config.SharedBank || config.CommonBank {
+		if err = importTetheallaBanks(source, guildcards, tally); err != nil {
+			return errors.New("importing common banks: " + err.Error())
+		}
+	}
+	if err = importTetheallaKeyConfigs(source, guildcards, tally); err != nil {
+		return errors.New("importing key configs: " + err.Error())
+	}
+	if err = importTetheallaGuildcards(source, guildcards, tally); err != nil {
+		return errors.New("importing guildcards: " + err.Error())
+	}
+	return nil
+}
+
+// Create the accounts, returning the guildcard each one was given here keyed by
+// its guildcard on Tethealla.
+func importTetheallaAccounts(source *sql.DB, tally *importTally) (map[uint32]uint32, error) {
+	rows, err := source.Query("SELECT username, password, email, regtime, guildcard, " +
+		"isgm, isbanned, isactive FROM account_data")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	guildcards := make(map[uint32]uint32)
+	for rows.Next() {
+		var username, password, email, regtime string
+		var guildcard uint32
+		var gm, banned, active bool
+		if err = rows.Scan(&username, &password, &email, &regtime, &guildcard, &gm, &banned, &active); err != nil {
+			return nil, err
+		}
+		account := &data.Account{
+			Username: username,
+			Password: tetheallaHashPrefix + regtime + "$" + password,
+			Email:    email,
+			Active:   active,
+		}
+		if gm {
+			account.GM, account.PrivilegeLevel = true, data.PrivilegeGM
+		}
+		var ban *data.Ban
+		if banned {
+			ban = importedBan(importSourceTethealla, username, time.Time{})
+		}
+		if err = importAccount(importSourceTethealla, guildcard, account, ban, guildcards, tally); err != nil {
+			return nil, err
+		}
+	}
+	return guildcards, rows.Err()
+}
+
+// Returns a ban for an account that's banned on source until expires, or for
+// good if it's zero.
+func importedBan(source, username string, expires time.Time) *data.Ban {
+	return &data.Ban{
+		BanTarget: data.BanTarget{Type: data.BanAccount, Target: username},
+		Reason:    "Banned on " + source,
+		IssuedBy:  "import:" + source,
+		IssuedAt:  time.Now(),
+		ExpiresAt: expires,
+	}
+}
+
+// Create an account brought over from a source, along with its ban if it isn't
+// nil, or find the one that an earlier import created, and add its guildcard
+// here to guildcards keyed by its old one.
+func importAccount(source string, oldGuildcard uint32, account *data.Account, ban *data.Ban,
+	guildcards map[uint32]uint32, tally *importTally) error {
+	if validateUsername(account.Username) != nil {
+		tally.skip("account %s: the username isn't allowed here", account.Username)
+		return nil
+	}
+	created, err := database.ImportAccount(source, oldGuildcard, account, ban)
+	switch err {
+	case nil:
+		guildcards[oldGuildcard] = uint32(account.Guildcard)
+		if created {
+			tally.accounts++
+		}
+	case data.ErrAccountExists:
+		tally.skip("account %s: the username is already registered", account.Username)
+	case data.ErrEmailInUse:
+		tally.skip("account %s: the email %s is already registered", account.Username, account.Email)
+	default:
+		return err
+	}
+	return nil
+}
+
+func importTetheallaCharacters(source *sql.DB, guildcards map[uint32]uint32, tally *importTally) error {
+	rows, err := source.Query("SELECT guildcard, slot, data FROM character_data")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var oldGuildcard, slot uint32
+		var contents []byte
+		if err = rows.Scan(&oldGuildcard, &slot, &contents); err != nil {
+			return err
+		}
+		guildcard, ok := guildcards[oldGuildcard]
+		if !ok {
+			continue
+		}
+		var full packets.FullCharacter
+		if len(contents) < tetheallaCharacterHeaderSize ||
+			util.DecodeStruct(contents[tetheallaCharacterHeaderSize:], &full) != nil {
+			tally.skip("slot %d of guildcard %d: the character data is malformed", slot, oldGuildcard)
+			continue
+		}
+		if err = importFullCharacter(guildcard, oldGuildcard, slot, &full, tally); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Import a character into an empty slot of the account it was given. Only the
+// inventory, character, and bank sections of full are used.
+func importFullCharacter(guildcard, oldGuildcard, slot uint32, full *packets.FullCharacter, tally *importTally) error {
+	if slot >= NumCharacterSlots {
+		tally.skip("slot %d of guildcard %d: there are only %d slots", slot, oldGuildcard, NumCharacterSlots)
+		return nil
+	}
+	snapshot := newImportedSnapshot(guildcard, slot, full)
+	if config.SharedBank {
+		// The characters' own banks would never be seen.
+		snapshot.Bank = nil
+	}
+	switch err := database.ImportCharacter(snapshot); err {
+	case nil:
+		tally.characters++
+	case data.ErrSlotInUse:
+		tally.skip("slot %d of guildcard %d: the slot is already in use", slot, oldGuildcard)
+	case data.ErrCharacterNameTaken:
+		tally.skip("slot %d of guildcard %d: another character already has the name", slot, oldGuildcard)
+	default:
+		return err
+	}
+	return nil
+}
+
+// Build the snapshot to import from a character in the client's layout.
+func newImportedSnapshot(guildcard, slot uint32, full *packets.FullCharacter) *data.CharacterSnapshot {
+	disp := &full.Character
+	character := &data.Character{
+		Guildcard:         int(guildcard),
+		GuildcardStr:      append([]byte(nil), disp.GuildcardStr[:]...),
+		Slot:              slot,
+		Experience:        disp.Experience,
+		Level:             disp.Level,
+		NameColor:         disp.NameColor,
+		Model:             disp.Model,
+		NameColorChecksum: disp.NameColorChksm,
+		SectionID:         disp.SectionID,
+		Class:             disp.Class,
+		V2Flags:           disp.V2Flags,
+		Version:           disp.Version,
+		V1Flags:           disp.V1Flags,
+		Costume:           disp.Costume,
+		Skin:              disp.Skin,
+		Face:              disp.Face,
+		Head:              disp.Head,
+		Hair:              disp.Hair,
+		HairRed:           disp.HairRed,
+		HairGreen:         disp.HairGreen,
+		HairBlue:          disp.HairBlue,
+		ProportionX:       disp.PropX,
+		ProportionY:       disp.PropY,
+		Name:              util.ExpandUtf16(disp.Name[:]),
+		Playtime:          disp.Playtime,
+		ATP:               disp.Stats.ATP,
+		MST:               disp.Stats.MST,
+		EVP:               disp.Stats.EVP,
+		HP:                disp.Stats.HP,
+		DFP:               disp.Stats.DFP,
+		ATA:               disp.Stats.ATA,
+		LCK:               disp.Stats.LCK,
+		Meseta:            disp.Meseta,
+	}
+	character.NameKey = characterNameKey(guildcard, character.Name)
+	snapshot := &data.CharacterSnapshot{
+		Guildcard: guildcard,
+		Slot:      slot,
+		Character: character,
+		BankSlot:  slot,
+		Bank:      newImportedBank(&full.Bank),
+	}
+	for i := 0; i < int(full.Inventory.NumItems) && i < len(full.Inventory.Items); i++ {
+		item := full.Inventory.Items[i]
+		if item.Present != 0 {
+			snapshot.Inventory = append(snapshot.Inventory, newImportedItem(item.Item, item.Flags))
+		}
+	}
+	for tech := range snapshot.Techniques {
+		if level := disp.Techniques[tech]; level != TechniqueNotLearned {
+			snapshot.Techniques[tech] = level + 1
+		}
+	}
+	return snapshot
+}
+
+func newImportedBank(charBank *packets.CharacterBank) *data.Bank {
+	bank := &data.Bank{Meseta: charBank.Meseta}
+	for i := 0; i < int(charBank.NumItems) && i < len(charBank.Items); i++ {
+		if item := charBank.Items[i]; item.Present != 0 {
+			bank.Items = append(bank.Items, newImportedItem(item.Item, 0))
+		}
+	}
+	return bank
+}
+
+func newImportedItem(item packets.ItemData, flags uint32) data.Item {
+	return data.Item{
+		ItemId: item.ItemId,
+		Data:   append([]byte(nil), item.Data[:]...),
+		Data2:  append([]byte(nil), item.Data2[:]...),
+		Flags:  flags,
+	}
+}
+
+// Tethealla's common banks are account-wide like the shared and common banks here.
+func importTetheallaBanks(source *sql.DB, guildcards map[uint32]uint32, tally *importTally) error {
+	rows, err := source.Query("SELECT guildcard, data FROM bank_common")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var oldGuildcard uint32
+		var contents []byte
+		if err = rows.Scan(&oldGuildcard, &contents); err != nil {
+			return err
+		}
+		guildcard, ok := guildcards[oldGuildcard]
+		if !ok {
+			continue
+		}
+		var charBank packets.CharacterBank
+		if util.DecodeStruct(contents, &charBank) != nil {
+			tally.skip("common bank of guildcard %d: the bank data is malformed", oldGuildcard)
+			continue
+		}
+		if err = importBank(guildcard, newImportedBank(&charBank), tally); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func importTetheallaKeyConfigs(source *sql.DB, guildcards map[uint32]uint32, tally *importTally) error {
+	rows, err := source.Query("SELECT guildcard, controls FROM key_data")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var oldGuildcard uint32
+		var controls []byte
+		if err = rows.Scan(&oldGuildcard, &controls); err != nil {
+			return err
+		}
+		guildcard, ok := guildcards[oldGuildcard]
+		if !ok {
+			continue
+		}
+		if len(controls) < data.KeyConfigSize+data.JoystickConfigSize {
+			tally.skip("key config of guildcard %d: the config is malformed", oldGuildcard)
+			continue
+		}
+		options := defaultPlayerOptions(guildcard)
+		copy(options.KeyConfig, controls)
+		copy(options.JoystickConfig, controls[data.KeyConfigSize:])
+		if err = importPlayerOptions(options, tally); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Save an account's common bank unless there's already something in it.
+func importBank(guildcard uint32, bank *data.Bank, tally *importTally) error {
+	existing, err := database.FindBank(guildcard, data.SharedBankSlot)
+	if err != nil {
+		return err
+	} else if existing.Meseta > 0 || len(existing.Items) > 0 {
+		return nil
+	}
+	if err = database.UpdateBank(guildcard, data.SharedBankSlot, bank); err != nil {
+		return err
+	}
+	tally.banks++
+	return nil
+}
+
+// Save an account's key config unless it already has one.
+func importPlayerOptions(options *data.PlayerOptions, tally *importTally) error {
+	existing, err := database.FindPlayerOptions(options.Guildcard)
+	if err != nil || existing != nil {
+		return err
+	}
+	if err = database.UpdatePlayerOptions(options); err != nil {
+		return err
+	}
+	tally.options++
+	return nil
+}
+
+func importTetheallaGuildcards(source *sql.DB, guildcards map[uint32]uint32, tally *importTally) error {
+	rows, err := source.Query("SELECT accountid, friendid, friendname, friendtext, " +
+		"sectionid, pclass, comment FROM guild_data")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var oldGuildcard, oldFriend uint32
+		var name, description, comment []byte
+		var sectionId, class byte
+		if err = rows.Scan(&oldGuildcard, &oldFriend, &name, &description, &sectionId, &class, &comment); err != nil {
+			return err
+		}
+		guildcard, ok := guildcards[oldGuildcard]
+		friend, known := guildcards[oldFriend]
+		if !ok || !known {
+			continue
+		}
+		err = importGuildcard(&data.GuildcardEntry{
+			Guildcard:       int(guildcard),
+			FriendGuildcard: int(friend),
+			Name:            trimUtf16(utf16FromBytes(name)),
+			Description:     trimUtf16(utf16FromBytes(description)),
+			SectionID:       sectionId,
+			Class:           class,
+			Comment:         trimUtf16(utf16FromBytes(comment)),
+		}, tally)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Add an entry to a friend list, replacing the one that's there for the friend.
+func importGuildcard(entry *data.GuildcardEntry, tally *importTally) error {
+	if err := database.AddGuildcard(entry); err != nil {
+		return err
+	}
+	// AddGuildcard leaves the comment of an existing entry alone.
+	if len(entry.Comment) > 0 {
+		err := database.UpdateGuildcardComment(uint32(entry.Guildcard), uint32(entry.FriendGuildcard), entry.Comment)
+		if err != nil {
+			return err
+		}
+	}
+	tally.guildcards++
+	return nil
+}
+
+func importSylverant(source *sql.DB, tally *importTally) error {
+	guildcards, err := importSylverantAccounts(source, tally)
+	if err != nil {
+		return errors.New("importing accounts: " + err.Error())
+	}
+	if err = importSylverantCharacters(source, guildcards, tally); err != nil {
+		return errors.New("importing characters: " + err.Error())
+	}
+	return nil
+}
+
+// Create the Blue Burst accounts, returning the guildcard each one was given
+// here keyed by its guildcard on Sylverant.
+func importSylverantAccounts(source *sql.DB, tally *importTally) (map[uint32]uint32, error) {
+	rows, err := source.Query("SELECT b.guildcard, b.username, b.password, b.regtime, " +
+		"b.privlevel, b.isbanned, b.isactive, COALESCE(a.email, '') FROM blueburst_clients b " +
+		"LEFT JOIN account_data a ON a.account_id = b.account_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	guildcards := make(map[uint32]uint32)
+	for rows.Next() {
+		var guildcard uint32
+		var username, password, regtime, email string
+		var privileges uint32
+		var banned, active bool
+		err = rows.Scan(&guildcard, &username, &password, &regtime, &privileges, &banned, &active, &email)
+		if err != nil {
+			return nil, err
+		}
+		account := &data.Account{
+			Username: username,
+			Password: tetheallaHashPrefix + regtime + "$" + password,
+			Email:    email,
+			Active:   active,
+		}
+		if privileges != 0 {
+			account.GM, account.PrivilegeLevel = true, data.PrivilegeGM
+		}
+		var ban *data.Ban
+		if banned {
+			ban = importedBan(importSourceSylverant, username, time.Time{})
+		}
+		if err = importAccount(importSourceSylverant, guildcard, account, ban, guildcards, tally); err != nil {
+			return nil, err
+		}
+	}
+	return guildcards, rows.Err()
+}
+
+// Sections of a Sylverant Blue Burst character, in order. Only the inventory,
+// character, and bank are imported.
+var sylverantCharacterSections = []int{
+	binary.Size(packets.Inventory{}),
+	binary.Size(packets.PlayerDispData{}),
+	0x208, // Quest data
+	binary.Size(packets.CharacterBank{}),
+	0xB0,  // Guildcard description
+	0x158, // Auto reply
+	0x158, // Info board
+	0x140, // Challenge data
+	0x28,  // Tech menu
+	0x58,  // More quest data
+}
+
+func importSylverantCharacters(source *sql.DB, guildcards map[uint32]uint32, tally *importTally) error {
+	rows, err := source.Query("SELECT guildcard, slot, size, data FROM character_data")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var oldGuildcard, slot uint32
+		var size int
+		var compressed []byte
+		if err = rows.Scan(&oldGuildcard, &slot, &size, &compressed); err != nil {
+			return err
+		}
+		guildcard, ok := guildcards[oldGuildcard]
+		if !ok {
+			// Characters from the other versions belong to other guildcards.
+			continue
+		}
+		var sections [][]byte
+		contents, err := inflate(compressed)
+		if err == nil && len(contents) == size {
+			sections, ok = splitSections(contents, sylverantCharacterSections...)
+		}
+		var full packets.FullCharacter
+		if err != nil || !ok ||
+			util.DecodeStruct(sections[0], &full.Inventory) != nil ||
+			util.DecodeStruct(sections[1], &full.Character) != nil ||
+			util.DecodeStruct(sections[3], &full.Bank) != nil {
+			tally.skip("slot %d of guildcard %d: the character data is malformed", slot, oldGuildcard)
+			continue
+		}
+		if err = importFullCharacter(guildcard, oldGuildcard, slot, &full, tally); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Decompress data compressed with zlib.
+func inflate(compressed []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// Split contents into consecutive sections of the given sizes, returning false
+// if it isn't exactly as long as them.
+func splitSections(contents []byte, sizes ...int) ([][]byte, bool) {
+	sections := make([][]byte, len(sizes))
+	for i, size := range sizes {
+		if len(contents) < size {
+			return nil, false
+		}
+		sections[i], contents = contents[:size], contents[size:]
+	}
+	return sections, len(contents) == 0
+}
+
+// Read the licenses from newserv's system directory, then the account data and
+// characters of each one with a Blue Burst username.
+func importNewserv(dir string, tally *importTally) error {
+	licenses, err := ioutil.ReadFile(filepath.Join(dir, "licenses.nsi"))
+	if err != nil {
+		return err
+	} else if len(licenses)%newservLicenseSize != 0 {
+		return fmt.Errorf("invalid licenses.nsi: size isn't a multiple of %d", newservLicenseSize)
+	}
+
+	guildcards := make(map[uint32]uint32)
+	// Usernames of the imported accounts by their serial numbers.
+	usernames := make(map[uint32]string)
+	for offset := 0; offset < len(licenses); offset += newservLicenseSize {
+		license := licenses[offset : offset+newservLicenseSize]
+		username := cString(license[0x00:0x14])
+		password := cString(license[0x14:0x28])
+		serial := binary.LittleEndian.Uint32(license[0x28:])
+		privileges := binary.LittleEndian.Uint32(license[0x40:])
+		banEnd := binary.LittleEndian.Uint64(license[0x44:])
+		if username == "" {
+			// Licenses for the other versions.
+			continue
+		}
+		hash, err := hashPassword(password)
+		if err != nil {
+			return errors.New("importing accounts: " + err.Error())
+		}
+		account := &data.Account{
+			Username: username,
+			Password: hash,
+			Active:   true,
+		}
+		if privileges != 0 {
+			account.GM, account.PrivilegeLevel = true, data.PrivilegeGM
+		}
+		var ban *data.Ban
+		// Ban end times are in microseconds.
+		if end := time.Unix(0, int64(banEnd)*int64(time.Microsecond)); banEnd != 0 && end.After(time.Now()) {
+			ban = importedBan(importSourceNewserv, username, end)
+		}
+		if err = importAccount(importSourceNewserv, serial, account, ban, guildcards, tally); err != nil {
+			return errors.New("importing accounts: " + err.Error())
+		}
+		if _, ok := guildcards[serial]; ok {
+			usernames[serial] = username
+		}
+	}
+
+	// Everyone's accounts are made before the friend lists are read so that
+	// friends can be found regardless of the order of the licenses.
+	players := filepath.Join(dir, "players")
+	for serial, username := range usernames {
+		guildcard := guildcards[serial]
+		if err = importNewservCharacters(players, username, guildcard, tally); err != nil {
+			return errors.New("importing characters: " + err.Error())
+		}
+		if err = importNewservAccountData(players, username, guildcard, guildcards, tally); err != nil {
+			return errors.New("importing account data: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// Sections of a newserv player file after the signature, in order. Only the
+// bank, character, and inventory are imported.
+var newservPlayerSections = []int{
+	binary.Size(packets.CharacterPreview{}),
+	0x158, // Auto reply
+	binary.Size(packets.CharacterBank{}),
+	0x140, // Challenge data
+	binary.Size(packets.PlayerDispData{}),
+	0xB0,  // Guildcard description
+	0x158, // Info board
+	binary.Size(packets.Inventory{}),
+	0x208, // Quest data
+	0x58,  // More quest data
+	0x28,  // Tech menu
+}
+
+func importNewservCharacters(dir string, username string, guildcard uint32, tally *importTally) error {
+	for slot := uint32(0); slot < NumCharacterSlots; slot++ {
+		path := filepath.Join(dir, fmt.Sprintf("player_%s_%d.nsc", username, slot))
+		contents, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		sections, ok := newservSections(contents, newservPlayerSignature, newservPlayerSections)
+		var full packets.FullCharacter
+		if !ok || util.DecodeStruct(sections[2], &full.Bank) != nil ||
+			util.DecodeStruct(sections[4], &full.Character) != nil ||
+			util.DecodeStruct(sections[7], &full.Inventory) != nil {
+			tally.skip("%s: the character data is malformed", path)
+			continue
+		}
+		if err = importFullCharacter(guildcard, guildcard, slot, &full, tally); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sections of a newserv account file after the signature, in order.
+var newservAccountSections = []int{
+	0x78, // Blocked senders
+	binary.Size(packets.GuildcardData{}),
+	binary.Size(packets.KeyTeamConfig{}),
+	4,     // Option flags
+	0xA40, // Shortcuts
+	0x4E0, // Symbol chats
+	0x20,  // Team name
+}
+
+// Import the key config and friend list from the account's data file.
+func importNewservAccountData(dir string, username string, guildcard uint32,
+	guildcards map[uint32]uint32, tally *importTally) error {
+	path := filepath.Join(dir, "account_"+username+".nsa")
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	sections, ok := newservSections(contents, newservAccountSignature, newservAccountSections)
+	var gcData packets.GuildcardData
+	var keyConfig packets.KeyTeamConfig
+	if !ok || util.DecodeStruct(sections[1], &gcData) != nil || util.DecodeStruct(sections[2], &keyConfig) != nil {
+		tally.skip("%s: the account data is malformed", path)
+		return nil
+	}
+
+	options := defaultPlayerOptions(guildcard)
+	copy(options.KeyConfig, keyConfig.KeyConfig[:])
+	copy(options.JoystickConfig, keyConfig.JoystickConfig[:])
+	options.OptionFlags = binary.LittleEndian.Uint32(sections[3])
+	if err = importPlayerOptions(options, tally); err != nil {
+		return err
+	}
+
+	for _, entry := range gcData.Entries {
+		friend, known := guildcards[entry.Guildcard]
+		if entry.Guildcard == 0 || !known {
+			continue
+		}
+		err = importGuildcard(&data.GuildcardEntry{
+			Guildcard:       int(guildcard),
+			FriendGuildcard: int(friend),
+			Name:            trimUtf16(entry.Name[:]),
+			TeamName:        trimUtf16(entry.TeamName[:]),
+			Description:     trimUtf16(entry.Description[:]),
+			Language:        entry.Language,
+			SectionID:       entry.SectionID,
+			Class:           entry.CharClass,
+			Comment:         trimUtf16(entry.Comment[:]),
+		}, tally)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check the signature at the start of one of newserv's files and split the rest
+// of it into sections.
+func newservSections(contents []byte, signature string, sizes []int) ([][]byte, bool) {
+	if len(contents) < newservSignatureSize || cString(contents[:newservSignatureSize]) != signature {
+		return nil, false
+	}
+	return splitSections(contents[newservSignatureSize:], sizes...)
+}
+
+// Returns the string in a null padded field.
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// Convert a UTF-16LE string stored as bytes into its characters.
+func utf16FromBytes(b []byte) []uint16 {
+	s := make([]uint16, len(b)/2)
+	for i := range s {
+		s[i] = binary.LittleEndian.Uint16(b[i*2:])
+	}
+	return s
+}
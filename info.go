@@ -0,0 +1,203 @@
+/*
+* Information pages offered on the ship select screen, for the server's rules,
+* news, or the events that are on. The pages are read from the info file, which
+* is reloaded along with the config:
+*
+*	{
+*		"motd": "Welcome back, {{.PlayerName}}!",
+*		"pages": [
+*			{"title": "Rules", "text": "Be nice to each other."},
+*			{"title": "Events", "text": "{{if .EventName}}{{.EventName}} is on now!{{else}}No events right now.{{end}}"}
+*		]
+*	}
+*
+* If there are any pages, an "Information" item is added to the bottom of the
+* ship list, which opens a menu of the page titles. Choosing one shows its text
+* in a message box and goes back to the menu. The message of the day is shown
+* once, just before the character server first sends the ship list.
+* Both are templates taking the same variables as the scroll message (see
+* scroll.go).
+ */
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/dcrodman/archon/packets"
+	"github.com/dcrodman/archon/util"
+)
+
+// Id sent in the menu selection packet to tell the server that the selection
+// was made on the information menu, or was its item on the ship list.
+const InfoMenuId uint16 = 0x16
+
+// Item on the ship list that opens the information menu. Items on the menu
+// itself are numbered from 1, with BackMenuItem returning to the ship list.
+const infoMenuItem = 0xFFFFFFFE
+
+type infoFile struct {
+	MOTD  string         `json:"motd"`
+	Pages []infoFilePage `json:"pages"`
+}
+
+type infoFilePage struct {
+	Title string `json:"title"`
+	Text  string `json:"text"`
+}
+
+type infoPage struct {
+	title string
+	text  *template.Template
+}
+
+// Synchronized pages from the info file.
+type infoPageList struct {
+	motd  *template.Template
+	pages []infoPage
+	sync.RWMutex
+}
+
+var infoPages = new(infoPageList)
+
+// Load the pages from the file at path, replacing the ones loaded before.
+// There are no pages if the file doesn't exist.
+func (l *infoPageList) Load(path string) error {
+	file := new(infoFile)
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		log.Warnf("Info file %s doesn't exist; there won't be any information pages", path)
+	} else if err != nil {
+		return err
+	} else {
+		decoder := json.NewDecoder(bytes.NewReader(contents))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(file); err != nil {
+			return fmt.Errorf("invalid %s: %s", path, err.Error())
+		}
+	}
+	if len(file.Pages) >= BackMenuItem {
+		return fmt.Errorf("invalid %s: there can't be more than %d pages", path, BackMenuItem-1)
+	}
+
+	var motd *template.Template
+	if file.MOTD != "" {
+		if motd, err = parseScrollTemplate("motd", file.MOTD); err != nil {
+			return fmt.Errorf("invalid %s: motd: %s", path, err.Error())
+		}
+	}
+	pages := make([]infoPage, len(file.Pages))
+	for i, page := range file.Pages {
+		if strings.TrimSpace(page.Title) == "" {
+			return fmt.Errorf("invalid %s: page %d has no title", path, i+1)
+		}
+		pages[i].title = page.Title
+		if pages[i].text, err = parseScrollTemplate(page.Title, page.Text); err != nil {
+			return fmt.Errorf("invalid %s: page %q: %s", path, page.Title, err.Error())
+		}
+	}
+
+	l.Lock()
+	l.motd, l.pages = motd, pages
+	l.Unlock()
+	return nil
+}
+
+// Returns whether there are any pages to show.
+func (l *infoPageList) Available() bool {
+	l.RLock()
+	defer l.RUnlock()
+	return len(l.pages) > 0
+}
+
+// Send the message of the day to the player on c, who is playing the character
+// in slot, if there is one.
+func (l *infoPageList) SendMOTD(c *Client, slot int) error {
+	l.RLock()
+	motd := l.motd
+	l.RUnlock()
+	if motd == nil {
+		return nil
+	}
+	return sendInfoText(c, motd, slot)
+}
+
+// Send the menu of page titles.
+func (l *infoPageList) SendMenu(c *Client) error {
+	l.RLock()
+	pkt := &packets.ShipListPacket{
+		Header:      packets.BBHeader{Type: packets.LoginShipListType, Flags: uint32(len(l.pages) + 1)},
+		Unknown:     0x02,
+		Unknown2:    0xFFFFFFF4,
+		Unknown3:    0x04,
+		ShipEntries: make([]packets.ShipMenuEntry, len(l.pages)+1),
+	}
+	for i, page := range l.pages {
+		item := &pkt.ShipEntries[i]
+		item.MenuId = InfoMenuId
+		item.ShipId = uint32(i + 1)
+		copy(item.Shipname[:len(item.Shipname)-2], util.ConvertToUtf16(page.title))
+	}
+	l.RUnlock()
+	copy(pkt.ServerName[:], "Information")
+	back := &pkt.ShipEntries[len(pkt.ShipEntries)-1]
+	back.MenuId = InfoMenuId
+	back.ShipId = BackMenuItem
+	copy(back.Shipname[:], util.ConvertToUtf16("Ship Selection"))
+
+	c.log.Debug("Sending Information Menu Packet")
+	return EncryptAndSend(c, pkt)
+}
+
+// Handle a selection from the information menu, or of its item on the ship list.
+// sendShipList is called to go back to the ship list.
+func (l *infoPageList) HandleSelection(c *Client, pkt packets.MenuSelectionPacket, sendShipList func() error) error {
+	switch {
+	case pkt.ItemId == infoMenuItem:
+		return l.SendMenu(c)
+	case pkt.ItemId == BackMenuItem:
+		return sendShipList()
+	}
+	l.RLock()
+	var page *infoPage
+	if pkt.ItemId >= 1 && int(pkt.ItemId) <= len(l.pages) {
+		page = &l.pages[pkt.ItemId-1]
+	}
+	l.RUnlock()
+	if page == nil {
+		// The pages may have been reloaded since the menu was sent.
+		return l.SendMenu(c)
+	}
+	if err := sendInfoText(c, page.text, int(c.config.SlotNum)); err != nil {
+		return err
+	}
+	return l.SendMenu(c)
+}
+
+// Fill in text for the player on c and show it in a message box.
+func sendInfoText(c *Client, text *template.Template, slot int) error {
+	var b strings.Builder
+	if err := text.Execute(&b, scrollMessageVars(c, slot)); err != nil {
+		c.log.Warnf("Failed to fill in information text %s: %s", text.Name(), err.Error())
+		return nil
+	}
+	return SendClientMessage(c, b.String())
+}
+
+// Add the item that opens the information menu to a ship list, if there are
+// any pages.
+func addInfoMenuItem(pkt *packets.ShipListPacket) {
+	if !infoPages.Available() {
+		return
+	}
+	item := packets.ShipMenuEntry{MenuId: InfoMenuId, ShipId: infoMenuItem}
+	copy(item.Shipname[:], util.ConvertToUtf16("Information"))
+	pkt.ShipEntries = append(pkt.ShipEntries, item)
+	pkt.Header.Flags++
+}
@@ -0,0 +1,447 @@
+/*
+* End to end tests of the login and character servers. The servers are started
+* in the test on free ports, with a SQLite database in a temporary directory and
+* the rest of the config from setup/, and a client from the client package goes
+* through registering an account, creating a character, loading its saved
+* options, and downloading its guildcards and the parameter files. The contents
+* of the packets the servers send back are checked against what's in the
+* database and on disk.
+*
+* The steps run in order as subtests, each carrying on from where the last one
+* left off, so the test stops at the first one that fails. Skipped with -short.
+ */
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/dcrodman/archon/client"
+	"github.com/dcrodman/archon/data"
+	"github.com/dcrodman/archon/packets"
+	"github.com/dcrodman/archon/util"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	integrationUsername = "integration"
+	integrationPassword = "integration"
+	// The account whose guildcard is saved by the first.
+	integrationFriend = "integration2"
+	integrationName   = "Tester"
+	integrationSlot   = 0
+
+	// An account with two-factor authentication, whose password leaves room
+	// for the code.
+	integrationTwoFactor         = "integration3"
+	integrationTwoFactorPassword = "twofactor"
+)
+
+// State shared between the steps, which run in order.
+type integrationRun struct {
+	loginAddr     string
+	characterAddr string
+	account       *data.Account
+	friend        *data.Account
+	client        *client.Client
+}
+
+var integrationSteps = []struct {
+	name string
+	run  func(r *integrationRun, t *testing.T)
+}{
+	{"account registration", (*integrationRun).registerAccounts},
+	{"wrong password is refused", (*integrationRun).wrongPassword},
+	{"login", (*integrationRun).login},
+	{"login audit", (*integrationRun).loginAudit},
+	{"character creation", (*integrationRun).createCharacter},
+	{"options save and load", (*integrationRun).options},
+	{"guildcard transfer", (*integrationRun).guildcards},
+	{"parameter transfer", (*integrationRun).parameters},
+	{"character selection", (*integrationRun).selectCharacter},
+	{"refused session is disconnected", (*integrationRun).refusedSession},
+	{"wrong two-factor code leaves sessions alone", (*integrationRun).wrongTwoFactorCode},
+}
+
+// Returns a port that's free to listen on.
+func freePort(t *testing.T) string {
+	socket, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socket.Close()
+	_, port, _ := net.SplitHostPort(socket.Addr().String())
+	return port
+}
+
+// Load the config from setup/ and open a fresh database, which is removed once
+// the test is over.
+func setupTestEnvironment(tb testing.TB) {
+	config = newDefaultConfig()
+	if err := config.InitFromFile(filepath.Join("setup", "config.yaml")); err != nil {
+		tb.Fatal(err)
+	}
+	config.ParametersDir = filepath.Join("setup", "parameters")
+	// Packet dumps would bury the test output.
+	config.DebugMode = false
+	config.Hostname, config.ExternalIP = "127.0.0.1", "127.0.0.1"
+	config.cachedIPBytes = [4]byte{}
+
+	var err error
+	database, err = data.Open(data.Config{Driver: "sqlite", Name: filepath.Join(tb.TempDir(), "archon.db")})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { database.Close() })
+	if err = database.Migrate(data.LatestSchema); err != nil {
+		tb.Fatal(err)
+	}
+	// Cached so that tests cover dropping the entries that they change.
+	characterCache = newMemoryCache(100, time.Minute)
+	tb.Cleanup(func() { characterCache = nil })
+
+	logOutput, logFormatter = ioutil.Discard, new(logrus.TextFormatter)
+	log = newLogger(logrus.InfoLevel)
+}
+
+// Start the login and character servers on free ports with a fresh database,
+// shutting them down again once the test is over.
+func startIntegrationServers(t *testing.T) {
+	setupTestEnvironment(t)
+	config.LoginPort, config.CharacterPort = freePort(t), freePort(t)
+	limiter, err := newRateLimiter(RateLimitConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newController(config.Hostname, limiter)
+	c.registerServer(new(LoginServer))
+	c.registerServer(new(CharacterServer))
+	if !c.start() {
+		t.Fatal("failed to start the servers")
+	}
+	t.Cleanup(func() {
+		c.shutdown()
+		c.wait()
+	})
+}
+
+func TestIntegration(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts the login and character servers")
+	}
+	startIntegrationServers(t)
+
+	r := &integrationRun{loginAddr: net.JoinHostPort(config.Hostname, config.LoginPort)}
+	defer func() {
+		if r.client != nil {
+			r.client.Close()
+		}
+	}()
+	for _, step := range integrationSteps {
+		if !t.Run(step.name, func(t *testing.T) { step.run(r, t) }) {
+			t.FailNow()
+		}
+	}
+}
+
+func (r *integrationRun) registerAccounts(t *testing.T) {
+	var err error
+	if r.account, err = RegisterAccount(integrationUsername, integrationPassword, ""); err != nil {
+		t.Fatal(err)
+	}
+	if r.friend, err = RegisterAccount(integrationFriend, integrationPassword, ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (r *integrationRun) wrongPassword(t *testing.T) {
+	c := client.New(integrationUsername, integrationPassword+"x")
+	defer c.Close()
+	if _, err := c.Login(r.loginAddr); err != client.LoginError(packets.BBLoginErrorPassword) {
+		t.Fatalf("expected login error %d, got %v", packets.BBLoginErrorPassword, err)
+	}
+}
+
+func (r *integrationRun) login(t *testing.T) {
+	var err error
+	r.client = client.New(integrationUsername, integrationPassword)
+	if r.characterAddr, err = r.client.Login(r.loginAddr); err != nil {
+		t.Fatal(err)
+	}
+	if _, port, _ := net.SplitHostPort(r.characterAddr); port != config.CharacterPort {
+		t.Fatalf("redirected to %s instead of the character server", r.characterAddr)
+	} else if r.client.Guildcard != uint32(r.account.Guildcard) {
+		t.Fatalf("logged in as guildcard %d, expected %d", r.client.Guildcard, r.account.Guildcard)
+	}
+	if err = r.client.ConnectCharacter(r.characterAddr); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (r *integrationRun) loginAudit(t *testing.T) {
+	// Attempts are recorded once the reply has been sent, so give the last one
+	// a moment to land.
+	var attempts []data.LoginAttempt
+	var err error
+	for wait := 0; wait < 10; wait++ {
+		if attempts, err = database.FindLoginAttempts(integrationUsername, "", 10); err != nil || len(attempts) >= 2 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []string
+	for _, attempt := range attempts {
+		results = append(results, attempt.Result)
+	}
+	if len(attempts) != 2 || results[0] != data.LoginSucceeded || results[1] != data.LoginBadPassword {
+		t.Fatalf("expected attempts [%s %s], got %v", data.LoginSucceeded, data.LoginBadPassword, results)
+	} else if attempts[0].Guildcard != uint32(r.account.Guildcard) || attempts[0].IP == "" {
+		t.Errorf("recorded guildcard %d from %q", attempts[0].Guildcard, attempts[0].IP)
+	}
+}
+
+func (r *integrationRun) createCharacter(t *testing.T) {
+	if preview, err := r.client.Preview(integrationSlot); err != nil {
+		t.Fatal(err)
+	} else if preview != nil {
+		t.Fatal("new account already has a character")
+	}
+	created := &client.CharacterPreview{Class: Ramarl, SectionID: 3}
+	copy(created.Name[:], util.ConvertToUtf16("\tE"+integrationName))
+	if err := r.client.CreateCharacter(integrationSlot, created); err != nil {
+		t.Fatal(err)
+	}
+
+	preview, err := r.client.Preview(integrationSlot)
+	if err != nil {
+		t.Fatal(err)
+	} else if preview == nil {
+		t.Fatal("no character in the slot after creating one")
+	} else if preview.Name != created.Name || preview.Class != created.Class ||
+		preview.SectionID != created.SectionID {
+		t.Error("preview doesn't match the character that was created")
+	}
+	character, err := database.FindCharacter(r.client.Guildcard, integrationSlot)
+	if err != nil {
+		t.Fatal(err)
+	} else if character == nil {
+		t.Fatal("character wasn't saved")
+	} else if characterName(character) != integrationName {
+		t.Errorf("character saved with name %q", characterName(character))
+	}
+}
+
+// Decode the options packet sent by the character server.
+func (r *integrationRun) loadOptions(t *testing.T) *packets.KeyTeamConfig {
+	options, err := r.client.Options()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := new(packets.KeyTeamConfig)
+	if err = util.DecodeStruct(options, cfg); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func (r *integrationRun) options(t *testing.T) {
+	cfg := r.loadOptions(t)
+	defaults := defaultPlayerOptions(r.client.Guildcard)
+	if cfg.Guildcard != r.client.Guildcard {
+		t.Errorf("options sent for guildcard %d", cfg.Guildcard)
+	} else if !bytes.Equal(cfg.KeyConfig[:], defaults.KeyConfig) {
+		t.Error("new account wasn't sent the default key config")
+	}
+
+	// Save a key config as the block server would when the player changes it.
+	saved := defaultPlayerOptions(r.client.Guildcard)
+	for i := range saved.KeyConfig {
+		saved.KeyConfig[i] = byte(i)
+	}
+	if err := database.UpdatePlayerOptions(saved); err != nil {
+		t.Fatal(err)
+	}
+	cacheDelete(optionsCacheKey(r.client.Guildcard))
+	cfg = r.loadOptions(t)
+	if !bytes.Equal(cfg.KeyConfig[:], saved.KeyConfig) {
+		t.Error("saved key config wasn't sent")
+	} else if !bytes.Equal(cfg.JoystickConfig[:], saved.JoystickConfig) {
+		t.Error("saved joystick config wasn't sent")
+	}
+}
+
+func (r *integrationRun) guildcards(t *testing.T) {
+	entry := &data.GuildcardEntry{
+		Guildcard:       r.account.Guildcard,
+		FriendGuildcard: r.friend.Guildcard,
+		Name:            utf16.Encode([]rune("\tEFriend")),
+		Description:     utf16.Encode([]rune("Hello")),
+		SectionID:       5,
+		Class:           Fomarl,
+		Comment:         utf16.Encode([]rune("Met in lobby 1")),
+	}
+	if err := database.AddGuildcard(entry); err != nil {
+		t.Fatal(err)
+	}
+	cacheDelete(guildcardCacheKey(uint32(r.account.Guildcard)))
+	contents, err := r.client.Guildcards()
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcData := new(packets.GuildcardData)
+	if err = util.DecodeStruct(contents, gcData); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := gcData.Entries[0]
+	if sent.Guildcard != uint32(entry.FriendGuildcard) {
+		t.Errorf("first guildcard is %d, expected %d", sent.Guildcard, entry.FriendGuildcard)
+	}
+	if name := utf16String(sent.Name[:]); name != utf16String(entry.Name) {
+		t.Errorf("guildcard sent with name %q", name)
+	}
+	if comment := utf16String(sent.Comment[:]); comment != utf16String(entry.Comment) {
+		t.Errorf("guildcard sent with comment %q", comment)
+	}
+	if sent.SectionID != entry.SectionID || sent.CharClass != entry.Class {
+		t.Error("guildcard sent with the wrong section id or class")
+	}
+	if gcData.Entries[1].Guildcard != 0 {
+		t.Error("more guildcards sent than were saved")
+	}
+}
+
+// Returns the text in a null padded UTF-16 field.
+func utf16String(s []uint16) string {
+	return strings.TrimRight(string(utf16.Decode(s)), "\x00")
+}
+
+func (r *integrationRun) parameters(t *testing.T) {
+	files, err := r.client.Parameters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := config.Parameters().ParameterFiles
+	if len(files) != len(expected) {
+		t.Fatalf("%d parameter files sent, expected %d", len(files), len(expected))
+	}
+	for i, file := range files {
+		if file.Name != expected[i] {
+			t.Errorf("parameter file %d is %s, expected %s", i, file.Name, expected[i])
+			continue
+		}
+		contents, err := ioutil.ReadFile(filepath.Join(config.ParametersDir, file.Name))
+		if err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(file.Data, contents) {
+			t.Errorf("contents of %s don't match the file", file.Name)
+		}
+	}
+}
+
+func (r *integrationRun) selectCharacter(t *testing.T) {
+	contents, err := r.client.SelectCharacter(integrationSlot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	full := new(packets.FullCharacter)
+	if err = util.DecodeStruct(contents, full); err != nil {
+		t.Fatal(err)
+	}
+	if name := utf16String(full.Name[:]); name != "\tE"+integrationName {
+		t.Errorf("full character sent with name %q", name)
+	}
+	if full.Class != Ramarl || full.SectionID != 3 {
+		t.Error("full character sent with the wrong class or section id")
+	}
+	if full.Guildcard != r.client.Guildcard {
+		t.Errorf("full character sent with guildcard %d", full.Guildcard)
+	}
+}
+
+// A login to the character server that's over the session limits should be
+// turned away and have its connection closed, leaving the session it would have
+// counted against alone.
+func (r *integrationRun) refusedSession(t *testing.T) {
+	friend := client.New(integrationFriend, integrationPassword)
+	friend.Timeout = 5 * time.Second
+	defer friend.Close()
+	characterAddr, err := friend.Login(r.loginAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first account's session on the character server is from the same
+	// address, so this leaves no room for the friend.
+	config.lock.Lock()
+	config.SessionsPerIP = 1
+	config.lock.Unlock()
+	defer func() {
+		config.lock.Lock()
+		config.SessionsPerIP = 0
+		config.lock.Unlock()
+	}()
+	if err = friend.ConnectCharacter(characterAddr); err == nil {
+		t.Fatal("login over the session limit was let in")
+	} else if _, ok := err.(client.MessageError); !ok {
+		t.Fatalf("expected a message before being turned away, got %v", err)
+	}
+	if err = friend.WaitDisconnected(); err != nil {
+		t.Fatalf("connection wasn't closed after the refused login: %v", err)
+	}
+
+	if preview, err := r.client.Preview(integrationSlot); err != nil {
+		t.Fatalf("existing session was disturbed: %v", err)
+	} else if preview == nil {
+		t.Error("existing session lost its character")
+	}
+}
+
+// A login with the right password but the wrong two-factor code should be turned
+// away before it can take over the account's other sessions.
+func (r *integrationRun) wrongTwoFactorCode(t *testing.T) {
+	if _, err := RegisterAccount(integrationTwoFactor, integrationTwoFactorPassword, ""); err != nil {
+		t.Fatal(err)
+	}
+	existing := client.New(integrationTwoFactor, integrationTwoFactorPassword)
+	existing.Timeout = 5 * time.Second
+	defer existing.Close()
+	characterAddr, err := existing.Login(r.loginAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = existing.ConnectCharacter(characterAddr); err != nil {
+		t.Fatal(err)
+	}
+
+	// No code is accepted, since the last step used is ahead of the clock.
+	twoFactor := &data.TwoFactor{
+		Username: integrationTwoFactor,
+		Secret:   "JBSWY3DPEHPK3PXP",
+		Enabled:  true,
+		LastStep: time.Now().Unix()/totpPeriod + 10,
+	}
+	if err = database.SaveTwoFactor(twoFactor, nil); err != nil {
+		t.Fatal(err)
+	}
+	// The setup config takes over sessions once an account has one, so without
+	// the code this would disconnect the existing session.
+	intruder := client.New(integrationTwoFactor, integrationTwoFactorPassword+"000000")
+	defer intruder.Close()
+	if _, err = intruder.Login(r.loginAddr); err != client.LoginError(packets.BBLoginErrorPassword) {
+		t.Fatalf("expected login error %d, got %v", packets.BBLoginErrorPassword, err)
+	}
+
+	if _, err = existing.Preview(integrationSlot); err != nil {
+		t.Errorf("existing session was disconnected: %v", err)
+	}
+}
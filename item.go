@@ -0,0 +1,198 @@
+/*
+* Item ids and the items held by the players in a game. The server decides the id
+* of every item in a game and keeps track of where each one is, either in one of
+* the players' inventories or on the floor, so that the commands players send
+* about items can be checked against the items they really have. Commands about
+* items that the server doesn't know of are dropped instead of passed along,
+* which keeps modified clients from creating or duplicating items.
+ */
+package main
+
+import (
+	"github.com/dcrodman/archon/data"
+	"github.com/dcrodman/archon/packets"
+)
+
+const (
+	// Each player in a game is given a range of this many item ids, starting
+	// from FirstItemId for the player with client id 0.
+	PlayerItemIdRange = 0x00200000
+	// Item id used to indicate that an action is for meseta.
+	MesetaItemId = 0xFFFFFFFF
+)
+
+// Number the items in the player's inventory from the start of the range for their
+// client id. The client numbers them the same way when it joins a game.
+func (c *Client) assignItemIds() {
+	c.nextItemId = FirstItemId + PlayerItemIdRange*uint32(c.clientId)
+	for i := range c.inventory {
+		c.inventory[i].ItemId = c.newItemId()
+	}
+}
+
+// Returns an id for a new item in the player's inventory.
+func (c *Client) newItemId() uint32 {
+	id := c.nextItemId
+	c.nextItemId++
+	return id
+}
+
+// Item lying on the floor of a game.
+type floorItem struct {
+	item  data.Item
+	floor uint8
+}
+
+// Put an item on the floor, either because a player dropped it or because it
+// couldn't be picked up.
+func (g *Game) placeFloorItem(item data.Item, floor uint8) {
+	g.itemLock.Lock()
+	g.floorItems[item.ItemId] = &floorItem{item: item, floor: floor}
+	g.itemLock.Unlock()
+}
+
+// Give a new item an id and put it on the floor.
+func (g *Game) newFloorItem(item data.Item, floor uint8) data.Item {
+	g.itemLock.Lock()
+	defer g.itemLock.Unlock()
+	item.ItemId = g.nextItemId
+	g.nextItemId++
+	g.floorItems[item.ItemId] = &floorItem{item: item, floor: floor}
+	return item
+}
+
+// Remove an item from the floor, returning false if it isn't there (because
+// somebody else picked it up first).
+func (g *Game) takeFloorItem(itemId uint32, floor uint8) (data.Item, bool) {
+	g.itemLock.Lock()
+	defer g.itemLock.Unlock()
+	fi, ok := g.floorItems[itemId]
+	if !ok || fi.floor != floor {
+		return data.Item{}, false
+	}
+	delete(g.floorItems, itemId)
+	g.pickedUp[itemId] = true
+	return fi.item, true
+}
+
+// Returns whether an item has ever been on the floor of the game.
+func (g *Game) wasOnFloor(itemId uint32) bool {
+	g.itemLock.Lock()
+	defer g.itemLock.Unlock()
+	return g.floorItems[itemId] != nil || g.pickedUp[itemId]
+}
+
+// Log a command about an item that the player doesn't have, which is either a
+// desync or an attempt to duplicate it.
+func rejectItemCommand(c *Client, action string, itemId uint32) {
+	c.log.Warnf("Guildcard %d tried to %s item %x, which isn't in their inventory",
+		c.guildcard, action, itemId)
+}
+
+// The player destroyed an item or some of a stack, e.g. by using it. Returns false
+// if the item isn't in their inventory, in which case the command isn't passed on.
+func (server *BlockServer) HandleDestroyItem(c *Client) (bool, error) {
+	var pkt packets.DestroyItemPacket
+	if err := c.Decode(&pkt); err != nil {
+		return false, err
+	}
+	if c.trading() {
+		return false, nil
+	}
+	index := findItem(c.inventory, pkt.ItemId)
+	if index < 0 {
+		rejectItemCommand(c, "destroy", pkt.ItemId)
+		return false, nil
+	}
+	item := c.inventory[index]
+	if isStackable(item) && pkt.Amount > 0 && pkt.Amount < uint32(itemAmount(item)) {
+		auditItem(c, data.ItemAuditDestroyed, &item, uint8(pkt.Amount), 0)
+		c.inventory[index].Data[5] -= uint8(pkt.Amount)
+	} else {
+		auditItem(c, data.ItemAuditDestroyed, &item, 0, 0)
+		c.inventory = append(c.inventory[:index], c.inventory[index+1:]...)
+	}
+	return true, nil
+}
+
+// The player dropped an item from their inventory onto the floor. Returns false
+// if the item isn't in their inventory, in which case the command isn't passed on.
+func (server *BlockServer) HandleDropInventoryItem(c *Client) (bool, error) {
+	var pkt packets.DropInventoryItemPacket
+	if err := c.Decode(&pkt); err != nil {
+		return false, err
+	}
+	g := c.game
+	if g == nil || c.trading() {
+		return false, nil
+	}
+	index := findItem(c.inventory, pkt.ItemId)
+	if index < 0 || c.inventory[index].Flags&ItemEquipped != 0 {
+		rejectItemCommand(c, "drop", pkt.ItemId)
+		return false, nil
+	}
+	item := c.inventory[index]
+	item.Flags = 0
+	c.inventory = append(c.inventory[:index], c.inventory[index+1:]...)
+	g.placeFloorItem(item, uint8(pkt.Floor))
+	auditItem(c, data.ItemAuditDropped, &item, 0, 0)
+	return true, nil
+}
+
+// The player dropped some of a stack of items or some of their meseta. The server
+// creates the item that's dropped, so everyone (including the player) is told
+// about it.
+func (server *BlockServer) HandleSplitStack(c *Client) error {
+	var pkt packets.SplitStackPacket
+	if err := c.Decode(&pkt); err != nil {
+		return err
+	}
+	g := c.game
+	if g == nil || c.character == nil || pkt.Amount == 0 || c.trading() {
+		return nil
+	}
+
+	var dropped data.Item
+	if pkt.ItemId == MesetaItemId {
+		if pkt.Amount > c.character.Meseta {
+			c.log.Warnf("Guildcard %d tried to drop %d meseta but only has %d",
+				c.guildcard, pkt.Amount, c.character.Meseta)
+			return nil
+		}
+		c.character.Meseta -= pkt.Amount
+		dropped = newMesetaItem(pkt.Amount)
+	} else {
+		index := findItem(c.inventory, pkt.ItemId)
+		if index < 0 || !isStackable(c.inventory[index]) || pkt.Amount > uint32(itemAmount(c.inventory[index])) {
+			rejectItemCommand(c, "split", pkt.ItemId)
+			return nil
+		}
+		dropped = copyItem(c.inventory[index])
+		dropped.Flags = 0
+		dropped.Data[5] = uint8(pkt.Amount)
+		if pkt.Amount < uint32(itemAmount(c.inventory[index])) {
+			c.inventory[index].Data[5] -= uint8(pkt.Amount)
+		} else {
+			c.inventory = append(c.inventory[:index], c.inventory[index+1:]...)
+		}
+		// The player's client has already taken them out of the stack.
+		g.Broadcast(&packets.DestroyItemPacket{
+			Header:    packets.BBHeader{Type: packets.GameCommandType},
+			SubHeader: packets.SubCmdHeader{Type: packets.SubCmdDestroyItem, Size: 3, ClientId: uint16(c.clientId)},
+			ItemId:    pkt.ItemId,
+			Amount:    pkt.Amount,
+		}, c)
+	}
+
+	dropped = g.newFloorItem(dropped, uint8(pkt.Floor))
+	auditItem(c, data.ItemAuditDropped, &dropped, 0, 0)
+	g.Broadcast(&packets.DropStackPacket{
+		Header:    packets.BBHeader{Type: packets.GameCommandType},
+		SubHeader: packets.SubCmdHeader{Type: packets.SubCmdDropStack, Size: 10, ClientId: uint16(c.clientId)},
+		Floor:     pkt.Floor,
+		X:         pkt.X,
+		Z:         pkt.Z,
+		Item:      newItemData(dropped),
+	}, nil)
+	return nil
+}
@@ -0,0 +1,237 @@
+/*
+* Audit log of the items and meseta that change hands on the ship. Every item
+* that's dropped by an enemy or box, picked up, dropped, destroyed, bought, sold,
+* banked, traded, appraised, or won is recorded along with the guildcard of the
+* player who did it, in a table that's only ever appended to. Entries are queued
+* as they happen and written in batches, as well as when a player logs off.
+*
+* Items are identified by their data, which is all that's left of them between
+* games, so the log for an item's data traces it from the enemy that dropped it
+* to whoever has it now, and shows up duplicates as copies that turn up without
+* having been dropped or traded:
+*
+*	archon audit guildcard <guildcard> [limit]
+*	archon audit item <data in hex>
+*
+* The same queries can be made at /admin/audit.
+ */
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dcrodman/archon/data"
+)
+
+const (
+	// How often the queued entries are written.
+	itemAuditInterval = 5 * time.Second
+	// Number of entries listed for a guildcard if no limit is given.
+	defaultItemAuditLimit = 50
+)
+
+// Entries waiting to be written to the database, oldest first.
+type itemAuditLog struct {
+	pending []data.ItemAuditEntry
+	sync.Mutex
+	// Held while a batch is being written so that flushes don't overlap.
+	flushing sync.Mutex
+}
+
+var itemAudit = new(itemAuditLog)
+
+func (l *itemAuditLog) Add(entries ...data.ItemAuditEntry) {
+	l.Lock()
+	l.pending = append(l.pending, entries...)
+	l.Unlock()
+}
+
+// Flush writes the queued entries to the database. If they can't be written
+// they're kept for the next flush.
+func (l *itemAuditLog) Flush() {
+	l.flushing.Lock()
+	defer l.flushing.Unlock()
+	l.Lock()
+	batch := l.pending
+	l.pending = nil
+	l.Unlock()
+	if len(batch) == 0 {
+		return
+	}
+	if err := database.CreateItemAudit(batch); err != nil {
+		log.Error("Failed to write item audit log: " + err.Error())
+		l.Lock()
+		l.pending = append(batch, l.pending...)
+		l.Unlock()
+	}
+}
+
+// Loop for the life of the server, writing the queued entries every interval.
+func (l *itemAuditLog) run(interval time.Duration) {
+	for range time.Tick(interval) {
+		l.Flush()
+	}
+}
+
+// Record something the player did with an item, or with meseta alone if item is
+// nil. Only amount of a stack is recorded, or all of it if amount is 0.
+func auditItem(c *Client, action string, item *data.Item, amount uint8, meseta uint32) {
+	itemAudit.Add(newItemAuditEntry(c.guildcard, action, item, amount, meseta, 0))
+}
+
+func newItemAuditEntry(guildcard uint32, action string, item *data.Item, amount uint8, meseta uint32,
+	partner uint32) data.ItemAuditEntry {
+	entry := data.ItemAuditEntry{
+		CreatedAt: time.Now(),
+		Guildcard: guildcard,
+		Action:    action,
+		Meseta:    meseta,
+		Partner:   partner,
+	}
+	if item != nil {
+		// Copied, since the player's inventory can change before it's written.
+		entry.Item = copyItem(*item)
+		entry.Item.Flags = 0
+		if amount > 0 && isStackable(*item) {
+			entry.Item.Data[5] = amount
+		}
+		if isMeseta(*item) {
+			entry.Meseta = mesetaAmount(*item)
+		}
+	}
+	return entry
+}
+
+// Record what each player gave and received in a trade.
+func auditTrade(a *Client, offerA *tradeOffer, b *Client, offerB *tradeOffer) {
+	var entries []data.ItemAuditEntry
+	side := func(giver, receiver uint32, offer *tradeOffer) {
+		for i := range offer.items {
+			entries = append(entries,
+				newItemAuditEntry(giver, data.ItemAuditGave, &offer.items[i], 0, 0, receiver),
+				newItemAuditEntry(receiver, data.ItemAuditReceived, &offer.items[i], 0, 0, giver))
+		}
+		if offer.meseta > 0 {
+			entries = append(entries,
+				newItemAuditEntry(giver, data.ItemAuditGave, nil, 0, offer.meseta, receiver),
+				newItemAuditEntry(receiver, data.ItemAuditReceived, nil, 0, offer.meseta, giver))
+		}
+	}
+	side(a.guildcard, b.guildcard, offerA)
+	side(b.guildcard, a.guildcard, offerB)
+	itemAudit.Add(entries...)
+}
+
+// Run one of the audit log queries given on the command line.
+func runItemAuditCommand(args []string) error {
+	var entries []data.ItemAuditEntry
+	switch {
+	case (len(args) == 2 || len(args) == 3) && args[0] == "guildcard":
+		guildcard, err := strconv.ParseUint(args[1], 10, 32)
+		if err != nil {
+			return errors.New("invalid guildcard " + args[1])
+		}
+		limit := defaultItemAuditLimit
+		if len(args) == 3 {
+			if limit, err = strconv.Atoi(args[2]); err != nil || limit <= 0 {
+				return errors.New("invalid limit " + args[2])
+			}
+		}
+		if entries, err = database.FindGuildcardItemAudit(uint32(guildcard), limit); err != nil {
+			return err
+		}
+	case len(args) == 2 && args[0] == "item":
+		itemData, err := parseAuditItem(args[1])
+		if err != nil {
+			return err
+		}
+		if entries, err = database.FindItemAudit(itemData); err != nil {
+			return err
+		}
+	default:
+		return errors.New("usage: audit guildcard <guildcard> [limit] | item <data in hex>")
+	}
+	if len(entries) == 0 {
+		fmt.Println("No entries found")
+	}
+	for _, e := range entries {
+		fmt.Println(formatItemAuditEntry(e))
+	}
+	return nil
+}
+
+// Decode the hex of the item data to look for, padding it out to 12 bytes.
+func parseAuditItem(s string) ([]byte, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) == 0 || len(b) > 12 {
+		return nil, fmt.Errorf("invalid item %q; items must be up to 12 bytes of hex", s)
+	}
+	return append(b, make([]byte, 12-len(b))...), nil
+}
+
+func formatItemAuditEntry(e data.ItemAuditEntry) string {
+	s := fmt.Sprintf("%s guildcard %d %s", e.CreatedAt.Local().Format(time.RFC1123), e.Guildcard, e.Action)
+	if len(e.Item.Data) > 0 {
+		s += fmt.Sprintf(" item %x (%x, id %x)", e.Item.Data, e.Item.Data2, e.Item.ItemId)
+	}
+	if e.Meseta > 0 {
+		s += fmt.Sprintf(" %d meseta", e.Meseta)
+	}
+	if e.Partner != 0 {
+		s += fmt.Sprintf(" with guildcard %d", e.Partner)
+	}
+	return s
+}
+
+// GET lists the entries for a guildcard (newest first, up to limit) or for an
+// item's data (oldest first).
+func handleAdminItemAudit(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	query := req.URL.Query()
+	var entries []data.ItemAuditEntry
+	var err error
+	switch {
+	case query.Get("guildcard") != "":
+		guildcard, parseErr := strconv.ParseUint(query.Get("guildcard"), 10, 32)
+		if parseErr != nil {
+			writeError(w, http.StatusBadRequest, "invalid guildcard")
+			return
+		}
+		limit := defaultItemAuditLimit
+		if query.Get("limit") != "" {
+			if limit, parseErr = strconv.Atoi(query.Get("limit")); parseErr != nil || limit <= 0 {
+				writeError(w, http.StatusBadRequest, "invalid limit")
+				return
+			}
+		}
+		entries, err = database.Replica().FindGuildcardItemAudit(uint32(guildcard), limit)
+	case query.Get("item") != "":
+		itemData, parseErr := parseAuditItem(query.Get("item"))
+		if parseErr != nil {
+			writeError(w, http.StatusBadRequest, parseErr.Error())
+			return
+		}
+		entries, err = database.Replica().FindItemAudit(itemData)
+	default:
+		writeError(w, http.StatusBadRequest, "guildcard or item is required")
+		return
+	}
+	if err != nil {
+		log.Error("Failed to look up item audit log: " + err.Error())
+		writeError(w, http.StatusInternalServerError, "unable to look up item audit log")
+		return
+	}
+	if entries == nil {
+		entries = []data.ItemAuditEntry{}
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
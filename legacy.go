@@ -0,0 +1,241 @@
+/*
+* Login servers for the versions of PSO before Blue Burst: PC and Episode I & II
+* on the Gamecube. Dreamcast clients aren't supported. Each version connects to
+* its own port, which is how the server knows the version (and so the cipher
+* and header layout) to use before the client has sent anything; the packets
+* the client sends to log in are then checked against that version. Instead of
+* a username and password the client sends the serial number and access key of
+* its license, which are checked against the account with the serial number as
+* its username and the access key as its password. Accounts are checked for
+* bans the same way as for Blue Burst, but these clients have nowhere to type a
+* two-factor code, so accounts with two-factor authentication turned on can
+* only log in with Blue Burst. The ship and block servers only speak the Blue
+* Burst protocol, so for now these players are told that once they've logged in.
+ */
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/dcrodman/archon/data"
+	crypto "github.com/dcrodman/archon/libarchon/encryption"
+	"github.com/dcrodman/archon/packets"
+	"github.com/dcrodman/archon/util"
+)
+
+// Copyright message expected by legacy clients when connecting.
+var LegacyCopyright = []byte("DreamCast Port Map. Copyright SEGA Enterprises. 1999")
+
+// Create and initialize a new legacy client so long as we're able
+// to send the welcome packet to begin encryption.
+func NewLegacyClient(conn net.Conn, version ClientVersion) (*Client, error) {
+	var err error
+	var cCrypt, sCrypt *crypto.PSOCrypt
+	if version == VersionGC {
+		cCrypt = crypto.NewGCCrypt()
+		sCrypt = crypto.NewGCCrypt()
+	} else {
+		cCrypt = crypto.NewPCCrypt()
+		sCrypt = crypto.NewPCCrypt()
+	}
+	lc := NewClient(conn, packets.PCHeaderSize, cCrypt, sCrypt)
+	lc.version = version
+	if SendLegacyWelcome(lc) != nil {
+		err = errors.New("Error sending welcome packet to: " + lc.IPAddr())
+		lc = nil
+	}
+	return lc, err
+}
+
+// SendLegacyWelcome transmits the welcome packet with the encryption vectors to a legacy client.
+func SendLegacyWelcome(client *Client) error {
+	pkt := new(packets.LegacyWelcomePkt)
+	pkt.Header.Type = packets.LegacyWelcomeType
+	copy(pkt.Copyright[:], LegacyCopyright)
+	copy(pkt.ClientVector[:], client.ClientVector())
+	copy(pkt.ServerVector[:], client.ServerVector())
+
+	data, size := util.BytesFromStruct(pkt)
+	// The welcome isn't encrypted but the header still needs laying out for the version.
+	data, blen := client.fixLength(data, uint16(size))
+	client.log.Debug("Sending Legacy Welcome Packet")
+	return client.SendRaw(data, int(blen))
+}
+
+// Tell a legacy client whether its license was accepted.
+func sendLicenseResult(client *Client, result uint8) error {
+	pkt := &packets.LegacyHeader{Type: packets.LegacyLicenseResultType, Flags: result}
+	client.log.Debug("Sending License Result Packet")
+	return EncryptAndSend(client, pkt)
+}
+
+func sendLegacySecurity(client *Client) error {
+	pkt := &packets.LegacySecurityPkt{
+		Header:    packets.LegacyHeader{Type: packets.LegacySecurityType},
+		PlayerTag: 0x00010000,
+		Guildcard: client.guildcard,
+	}
+	client.log.Debug("Sending Legacy Security Packet")
+	return EncryptAndSend(client, pkt)
+}
+
+func sendLegacyClientMessage(client *Client, message string) error {
+	pkt := &packets.LegacyClientMessagePkt{Header: packets.LegacyHeader{Type: packets.LegacyClientMessageType}}
+	if client.version == VersionPC {
+		pkt.Message = append(util.ConvertToUtf16(message), 0, 0)
+	} else {
+		pkt.Message = append([]byte(message), 0)
+	}
+	client.log.Debug("Sending Legacy Client Message Packet")
+	return EncryptAndSend(client, pkt)
+}
+
+// LegacyLoginServer accepts clients running one version of PSO other than Blue
+// Burst and logs them in to their accounts.
+type LegacyLoginServer struct {
+	version ClientVersion
+	port    string
+}
+
+func (server LegacyLoginServer) Name() string {
+	return strings.ToUpper(server.version.String()) + "LOGIN"
+}
+
+func (server LegacyLoginServer) Port() string { return server.port }
+
+func (server LegacyLoginServer) RateLimited() {}
+
+var legacyLoginPacketSizes = map[uint16]int{
+	packets.PCLicenseType:       packetSize(&packets.PCLicensePkt{}),
+	packets.PCLoginType:         packetSize(&packets.LegacyLoginPkt{}),
+	packets.GCVerifyLicenseType: packetSize(&packets.GCVerifyLicensePkt{}),
+	packets.GCLoginType:         packetSize(&packets.LegacyLoginPkt{}),
+}
+
+func (server LegacyLoginServer) MinPacketSizes() map[uint16]int { return legacyLoginPacketSizes }
+
+func (server *LegacyLoginServer) Init() error { return nil }
+
+func (server *LegacyLoginServer) NewClient(conn net.Conn) (*Client, error) {
+	return NewLegacyClient(conn, server.version)
+}
+
+func (server *LegacyLoginServer) Handle(c *Client) error {
+	pktType := c.packetType(c.Data())
+	gamecube := server.version == VersionGC
+
+	var err error
+	switch {
+	case pktType == packets.PCLicenseType && !gamecube:
+		err = server.HandlePCLicense(c)
+	case pktType == packets.GCVerifyLicenseType && gamecube:
+		err = server.HandleGCLicense(c)
+	case pktType == packets.PCLoginType && !gamecube, pktType == packets.GCLoginType && gamecube:
+		err = server.HandleLogin(c)
+	case pktType == packets.DisconnectType:
+		// Just wait until we recv 0 from the client to d/c.
+		break
+	default:
+		c.log.Infof("Received unknown packet %x", pktType)
+	}
+	return err
+}
+
+// PC clients check their license when they first connect.
+func (server *LegacyLoginServer) HandlePCLicense(c *Client) error {
+	var pkt packets.PCLicensePkt
+	if err := c.Decode(&pkt); err != nil {
+		return err
+	}
+	c.log.Debugf("Client sub-version %02x", pkt.SubVersion)
+	if err := verifyLicense(c, pkt.SerialNumber[:], pkt.AccessKey[:]); err != nil {
+		recordLoginAttempt(c, string(util.StripPadding(pkt.SerialNumber[:])), pkt.SubVersion, err)
+		return err
+	}
+	return sendLicenseResult(c, packets.LicenseOK)
+}
+
+// Gamecube clients check their license when they first connect.
+func (server *LegacyLoginServer) HandleGCLicense(c *Client) error {
+	var pkt packets.GCVerifyLicensePkt
+	if err := c.Decode(&pkt); err != nil {
+		return err
+	}
+	c.log.Debugf("Client sub-version %02x", pkt.SubVersion)
+	if err := verifyLicense(c, pkt.SerialNumber[:], pkt.AccessKey[:]); err != nil {
+		recordLoginAttempt(c, string(util.StripPadding(pkt.SerialNumber[:])), pkt.SubVersion, err)
+		return err
+	}
+	return sendLicenseResult(c, packets.LicenseOK)
+}
+
+// Once its license has been accepted the client sends it again to log in.
+func (server *LegacyLoginServer) HandleLogin(c *Client) error {
+	var pkt packets.LegacyLoginPkt
+	if err := c.Decode(&pkt); err != nil {
+		return err
+	}
+	err := verifyLicense(c, pkt.SerialNumber[:], pkt.AccessKey[:])
+	if err == nil {
+		err = checkMaintenance(c)
+	}
+	if err == nil {
+		err = admitSession(c)
+	}
+	recordLoginAttempt(c, string(util.StripPadding(pkt.SerialNumber[:])), pkt.SubVersion, err)
+	if err != nil {
+		return err
+	}
+	if err := sendLegacySecurity(c); err != nil {
+		return err
+	}
+	recentLogins.Add(c)
+	return SendClientMessage(c, fmt.Sprintf("Welcome to %s!\n\n"+
+		"The ships on this server can't be joined from this version of PSO yet.", config.ShipName))
+}
+
+// Check the serial number and access key sent by a legacy client against its
+// account. The client is told why if they're rejected.
+func verifyLicense(c *Client, serialNumber, accessKey []byte) error {
+	serial := string(util.StripPadding(serialNumber))
+	key := string(util.StripPadding(accessKey))
+	account, err := c.db().FindAccount(serial)
+
+	switch {
+	case err != nil:
+		sendDatabaseError(c, err)
+		return err
+	case account == nil:
+		sendLicenseResult(c, packets.LicenseUnregistered)
+		return refuseLogin(data.LoginUnknownAccount,
+			errors.New("No account for serial number: "+serial))
+	case !checkPassword(account.Password, key):
+		// As with Blue Burst, nothing more is given away without the access key.
+		sendLicenseResult(c, packets.LicenseBadAccessKey)
+		return refuseLogin(data.LoginBadPassword,
+			errors.New("Incorrect access key for serial number: "+serial))
+	case !account.Active:
+		sendLicenseResult(c, packets.LicenseUnregistered)
+		return refuseLogin(data.LoginInactive,
+			errors.New("No active account for serial number: "+serial))
+	}
+
+	twoFactor, err := c.db().FindTwoFactor(serial)
+	if err != nil {
+		sendDatabaseError(c, err)
+		return err
+	} else if twoFactor != nil && twoFactor.Enabled {
+		SendClientMessage(c, "This account uses two-factor authentication, which this "+
+			"version of PSO can't send a code for.\n\nPlease log in with Blue Burst.")
+		sendLicenseResult(c, packets.LicenseBadAccessKey)
+		return refuseLogin(data.LoginBadTwoFactor,
+			errors.New("Two-factor authentication is on for serial number: "+serial))
+	}
+	if needsRehash(account.Password) {
+		rehashPassword(c, serial, key)
+	}
+	return loginAccount(c, account)
+}
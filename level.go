@@ -0,0 +1,344 @@
+/*
+* Experience and leveling. Players earn experience for the enemies they kill,
+* which the server hands out and uses to level up their characters with the stat
+* growth for their class from the level table (PlyLevelTbl.prs, the same file the
+* character server reads the starting stats from).
+*
+* The server learns what kind of enemy was killed from the game's map if it has
+* it (see maps.go), or else from the drop request that the client sends for it,
+* which is checked against the map when the enemy could be a rare version. Each
+* enemy only gives experience once, and when the server has the map, kills and
+* drops for enemies that aren't on it are ignored. Quests bring their own
+* enemies, so kills in them can only be checked for repeats.
+* Experience for each kind of enemy is listed in the experience file by episode,
+* difficulty, and the enemy type in the drop request (the same keys as
+* enemy_rares in the drop tables):
+*
+*	{
+*		"ep1": {
+*			"normal": {"1": 19, "5": 3, "9": 5},
+*			"hard": {"1": 68, "5": 11, "9": 19}
+*		}
+*	}
+*
+* The amounts are multiplied by the game's experience rate (see rates.go).
+ */
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/dcrodman/archon/packets"
+	"github.com/dcrodman/archon/prs"
+	"github.com/dcrodman/archon/util"
+)
+
+const (
+	// Number of character classes in the level table.
+	NumClasses = 12
+	// Highest level a character can reach.
+	MaxLevel = 200
+)
+
+// Stats gained on reaching a level and the experience needed to reach it.
+type LevelStats struct {
+	ATP        uint8
+	MST        uint8
+	EVP        uint8
+	HP         uint8
+	DFP        uint8
+	ATA        uint8
+	LCK        uint8
+	TP         uint8
+	Experience uint32
+}
+
+// Layout of the decompressed PlyLevelTbl.prs. Levels are indexed from 0, as
+// they're stored on the character.
+type LevelTable struct {
+	BaseStats [NumClasses]packets.CharacterStats
+	Unknown   [NumClasses]uint32
+	Levels    [NumClasses][MaxLevel]LevelStats
+}
+
+// Level table loaded by the ship server.
+var levels *LevelTable
+
+// Read the level table from the parameters directory.
+func loadLevelTable(paramDir string) (*LevelTable, error) {
+	compressed, err := ioutil.ReadFile(paramDir + "/PlyLevelTbl.prs")
+	if err != nil {
+		return nil, errors.New("Error reading stats file: " + err.Error())
+	}
+	decompressed := make([]byte, prs.DecompressSize(compressed))
+	prs.Decompress(compressed, decompressed)
+
+	table := new(LevelTable)
+	if err = util.DecodeStruct(decompressed, table); err != nil {
+		return nil, errors.New("Invalid stats file: " + err.Error())
+	}
+	return table, nil
+}
+
+// Stats returns the stats that a character of a class has at a level (starting
+// at 0, as it's stored) from leveling up alone.
+func (t *LevelTable) Stats(class uint8, level uint32) packets.CharacterStats {
+	stats := t.BaseStats[class]
+	for l := uint32(1); l <= level && l < MaxLevel; l++ {
+		gained := t.Levels[class][l]
+		stats.ATP += uint16(gained.ATP)
+		stats.MST += uint16(gained.MST)
+		stats.EVP += uint16(gained.EVP)
+		stats.HP += uint16(gained.HP)
+		stats.DFP += uint16(gained.DFP)
+		stats.ATA += uint16(gained.ATA)
+		stats.LCK += uint16(gained.LCK)
+	}
+	return stats
+}
+
+// Synchronized set of the experience given for each kind of enemy.
+type experienceList struct {
+	// Keyed by game episode (1-3) and difficulty, then by enemy type.
+	enemies map[dropTableKey]map[uint8]uint32
+	sync.RWMutex
+}
+
+var experience = &experienceList{enemies: make(map[dropTableKey]map[uint8]uint32)}
+
+// Load the experience file at path, replacing the one loaded before. Enemies
+// don't give any experience if the file doesn't exist.
+func (el *experienceList) Load(path string) error {
+	enemies := make(map[dropTableKey]map[uint8]uint32)
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		log.Warnf("Experience file %s doesn't exist; enemies won't give experience", path)
+	} else if err != nil {
+		return err
+	} else {
+		var file map[string]map[string]map[uint8]uint32
+		decoder := json.NewDecoder(bytes.NewReader(contents))
+		if err := decoder.Decode(&file); err != nil {
+			return fmt.Errorf("invalid %s: %s", path, err.Error())
+		}
+		for episodeName, difficulties := range file {
+			episode := parseEpisode(episodeName)
+			if episode == 0 {
+				return fmt.Errorf("invalid %s: episodes must be ep1, ep2, or ep4", path)
+			} else if !episodeAvailable(episode) {
+				continue
+			}
+			for difficultyName, amounts := range difficulties {
+				difficulty, ok := parseDifficulty(difficultyName)
+				if !ok {
+					return fmt.Errorf("invalid %s: difficulties must be normal, hard, very_hard, or ultimate", path)
+				}
+				enemies[dropTableKey{episode: episode, difficulty: difficulty}] = amounts
+			}
+		}
+	}
+
+	el.Lock()
+	el.enemies = enemies
+	el.Unlock()
+	return nil
+}
+
+// Find returns the experience for killing an enemy of a type in a game.
+func (el *experienceList) Find(g *Game, enemyType uint8) uint32 {
+	el.RLock()
+	defer el.RUnlock()
+	return el.enemies[dropTableKey{episode: g.episode, difficulty: g.difficulty}][enemyType]
+}
+
+// Returns the game episode (1-3) for the name used in file names, or 0.
+func parseEpisode(name string) uint8 {
+	switch name {
+	case "ep1":
+		return 1
+	case "ep2":
+		return 2
+	case "ep4":
+		return 3
+	}
+	return 0
+}
+
+// Returns the layout of the enemies in the game, or nil if the server doesn't
+// know it, as when the game is playing a quest.
+func (g *Game) enemyLayout() *mapLayout {
+	g.questLock.Lock()
+	defer g.questLock.Unlock()
+	if g.quest != nil {
+		return nil
+	}
+	return g.layout
+}
+
+// Record the type of an enemy from the drop request for it, giving experience
+// for the kill if it's already been reported, and return the type. The type is
+// checked against the map if the server has it.
+func (g *Game) enemyIdentified(entityId uint16, enemyType uint8, floor uint8) uint8 {
+	entityId = enemyNumber(entityId)
+	if layout := g.enemyLayout(); layout != nil {
+		enemy := layout.Enemy(entityId)
+		if enemy == nil || len(enemy.types) == 0 {
+			log.Debugf("Drop requested for unknown enemy %d in game %d", entityId, g.id)
+			return enemyType
+		}
+		if enemy.floor == floor && !enemy.couldBe(enemyType) {
+			log.Debugf("Enemy %d in game %d reported as type %d instead of %d", entityId, g.id, enemyType, enemy.types[0])
+			enemyType = enemy.types[0]
+		}
+	}
+
+	g.enemyLock.Lock()
+	if known, ok := g.enemyTypes[entityId]; ok {
+		// Everyone in the game can ask for the same drop.
+		g.enemyLock.Unlock()
+		return known
+	}
+	g.enemyTypes[entityId] = enemyType
+	killer, killed := g.kills[entityId]
+	g.enemyLock.Unlock()
+
+	if killed {
+		g.enemyKilled(killer, enemyType)
+	}
+	return enemyType
+}
+
+func (g *Game) enemyKilled(killer uint8, enemyType uint8) {
+	if c := g.Client(killer); c != nil {
+		// The kill is reported by whoever's client noticed it, so the killer's
+		// character is changed on the killer's own goroutine.
+		amount := experience.Find(g, enemyType)
+		c.Queue(func() {
+			if c.game == g {
+				g.giveExperience(c, amount)
+			}
+		})
+	}
+}
+
+// Add experience to the player's character, leveling them up if they've earned
+// enough, and let everyone in the game know.
+func (g *Game) giveExperience(c *Client, amount uint32) {
+	character := c.character
+	if character == nil || levels == nil || int(character.Class) >= NumClasses {
+		return
+	} else if g.battleMode != 0 {
+		// Battles don't give experience.
+		return
+	}
+	table := &levels.Levels[character.Class]
+	var remaining uint32
+	if max := table[MaxLevel-1].Experience; character.Experience < max {
+		remaining = max - character.Experience
+	}
+	// The rates are multiplied in floating point so that a large rate (or the
+	// product of several) can't wrap the amount around.
+	scaled := float64(amount)
+	if _, _, expRate := g.rates(); expRate != 1 {
+		scaled *= expRate
+	}
+	if scaled >= float64(remaining) {
+		amount = remaining
+	} else {
+		amount = uint32(scaled)
+	}
+	if amount == 0 {
+		return
+	}
+	character.Experience += amount
+	g.Broadcast(&packets.GiveExperiencePacket{
+		Header:    packets.BBHeader{Type: packets.GameCommandType},
+		SubHeader: packets.SubCmdHeader{Type: packets.SubCmdGiveExperience, Size: 2, ClientId: uint16(c.clientId)},
+		Amount:    amount,
+	}, nil)
+
+	leveled := false
+	for character.Level+1 < MaxLevel && character.Experience >= table[character.Level+1].Experience {
+		character.Level++
+		stats := table[character.Level]
+		character.ATP += uint16(stats.ATP)
+		character.MST += uint16(stats.MST)
+		character.EVP += uint16(stats.EVP)
+		character.HP += uint16(stats.HP)
+		character.DFP += uint16(stats.DFP)
+		character.ATA += uint16(stats.ATA)
+		character.LCK += uint16(stats.LCK)
+		leveled = true
+	}
+	if !leveled {
+		return
+	}
+	c.log.Debugf("Guildcard %d reached level %d", c.guildcard, character.Level+1)
+	g.Broadcast(&packets.LevelUpPacket{
+		Header:    packets.BBHeader{Type: packets.GameCommandType},
+		SubHeader: packets.SubCmdHeader{Type: packets.SubCmdLevelUp, Size: 5, ClientId: uint16(c.clientId)},
+		ATP:       character.ATP,
+		MST:       character.MST,
+		EVP:       character.EVP,
+		HP:        character.HP,
+		DFP:       character.DFP,
+		ATA:       character.ATA,
+		Level:     character.Level,
+	}, nil)
+	if err := saveCharacter(c); err != nil {
+		c.log.Error(err.Error())
+	}
+}
+
+// An enemy was killed. Experience goes to whoever landed the killing blow once
+// the server knows what kind of enemy it was, which is right away if the map
+// says and it doesn't have a rare version.
+func (server *BlockServer) HandleEnemyKilled(c *Client) error {
+	var pkt packets.EnemyKilledPacket
+	if err := c.Decode(&pkt); err != nil {
+		return err
+	}
+	g := c.game
+	if g == nil {
+		return nil
+	}
+	killer := uint8(pkt.KillerClientId)
+	if g.Client(killer) == nil {
+		killer = c.clientId
+	}
+	// Kills are recorded by the enemy's number so that adding 0x1000 to it
+	// doesn't count as a different enemy.
+	enemyId := enemyNumber(pkt.EnemyId)
+	var enemy *mapEnemy
+	if layout := g.enemyLayout(); layout != nil {
+		if enemy = layout.Enemy(enemyId); enemy == nil || len(enemy.types) == 0 {
+			c.log.Debugf("Ignoring kill of unknown enemy %d in game %d", pkt.EnemyId, g.id)
+			return nil
+		}
+	}
+
+	g.enemyLock.Lock()
+	if _, reported := g.kills[enemyId]; reported {
+		// Everyone in the game can report the same kill.
+		g.enemyLock.Unlock()
+		return nil
+	}
+	g.kills[enemyId] = killer
+	enemyType, identified := g.enemyTypes[enemyId]
+	if !identified && enemy != nil && len(enemy.types) == 1 {
+		enemyType, identified = enemy.types[0], true
+		g.enemyTypes[enemyId] = enemyType
+	}
+	g.enemyLock.Unlock()
+
+	if identified {
+		g.enemyKilled(killer, enemyType)
+	}
+	return nil
+}
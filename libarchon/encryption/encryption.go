@@ -0,0 +1,158 @@
+/*
+* Blowfish implementation adapted to work with PSOBB's protocol.
+*
+* The package has no dependencies on the server so that other tools (proxies,
+* packet decoders, etc.) can use it. A server creates a PSOCrypt for each
+* direction of a connection with NewPCCrypt, NewGCCrypt, or NewBBCrypt and sends
+* the keys to the client in its welcome packet; the other end of the connection
+* creates matching ciphers from those keys with the FromKey constructors.
+ */
+package encryption
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+)
+
+// Sizes of the keys exchanged in the welcome packets.
+const (
+	PCKeySize = 4
+	GCKeySize = 4
+	BBKeySize = 48
+)
+
+// Crypt encrypts or decrypts the packets sent in one direction over a
+// connection. Implementations aren't safe for concurrent use.
+type Crypt interface {
+	// Encrypt the first size bytes of data in place, rounded up to a whole
+	// number of the cipher's blocks.
+	Encrypt(data []byte, size uint32)
+	Decrypt(data []byte, size uint32)
+	// Key returns the key the cipher was created with, which is what the other
+	// end of the connection needs in order to create a matching cipher.
+	Key() []byte
+}
+
+// Internal representation of a cipher capable of performing
+// encryption and decryption on blocks.
+type psoCipher interface {
+	encrypt(data []byte)
+	decrypt(data []byte)
+	blockSize() int
+}
+
+// PSOCrypt object to be used per-client for crypto.
+type PSOCrypt struct {
+	cipher psoCipher
+	Vector []uint8
+}
+
+// Generate a cryptographially secure random string of bytes.
+func createKey(size int) []byte {
+	key := make([]byte, size)
+	for i := 0; i < size; i++ {
+		binary.Read(rand.Reader, binary.LittleEndian, &key[i])
+	}
+	return key
+}
+
+// Condense four bytes into a LE 32-bit value.
+func le(b []byte) uint32 {
+	for i := 3; len(b) < 4; i-- {
+		b = append(b, 0)
+	}
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+}
+
+// Returns a newly allocated PSOCrypt with randomly generated, appropriately
+// sized keys for encrypting packets over PSOPC connections.
+func NewPCCrypt() *PSOCrypt {
+	crypt, err := NewPCCryptFromKey(createKey(PCKeySize))
+	if err != nil {
+		panic(err)
+	}
+	return crypt
+}
+
+// NewPCCryptFromKey returns a PSOCrypt for a PSOPC connection using a key
+// received from the other end.
+func NewPCCryptFromKey(key []byte) (*PSOCrypt, error) {
+	if len(key) != PCKeySize {
+		return nil, PCKeySizeError(len(key))
+	}
+	cipher, err := newPCCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &PSOCrypt{cipher: cipher, Vector: append([]byte(nil), key...)}, nil
+}
+
+// Returns a newly allocated PSOCrypt with randomly generated, appropriately
+// sized keys for encrypting packets over PSO Episode I & II (Gamecube) connections.
+func NewGCCrypt() *PSOCrypt {
+	crypt, err := NewGCCryptFromKey(createKey(GCKeySize))
+	if err != nil {
+		panic(err)
+	}
+	return crypt
+}
+
+// NewGCCryptFromKey returns a PSOCrypt for a Gamecube connection using a key
+// received from the other end.
+func NewGCCryptFromKey(key []byte) (*PSOCrypt, error) {
+	if len(key) != GCKeySize {
+		return nil, GCKeySizeError(len(key))
+	}
+	cipher, err := newGCCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &PSOCrypt{cipher: cipher, Vector: append([]byte(nil), key...)}, nil
+}
+
+// Returns a newly allocated PSOCrypt with randomly generated, appropriately
+// sized keys for encrypting packets over PSOBB connections.
+func NewBBCrypt() *PSOCrypt {
+	crypt, err := NewBBCryptFromKey(createKey(BBKeySize))
+	if err != nil {
+		panic(err)
+	}
+	return crypt
+}
+
+// NewBBCryptFromKey returns a PSOCrypt for a PSOBB connection using a key
+// received from the other end.
+func NewBBCryptFromKey(key []byte) (*PSOCrypt, error) {
+	// The key is salted in place 48 bytes at a time, so it can't be any shorter.
+	if len(key) != BBKeySize {
+		return nil, KeySizeError(len(key))
+	}
+	cipher, err := newCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &PSOCrypt{cipher: cipher, Vector: append([]byte(nil), key...)}, nil
+}
+
+// Key returns the key the cipher was created with.
+func (crypt *PSOCrypt) Key() []byte {
+	return crypt.Vector
+}
+
+// Encrypt a block of data in place.
+func (crypt *PSOCrypt) Encrypt(data []byte, size uint32) {
+	blockSize := crypt.cipher.blockSize()
+	for i := 0; i < int(size); i += blockSize {
+		block := data[i : i+blockSize]
+		crypt.cipher.encrypt(block)
+	}
+}
+
+// Decrypt a block of data in place.
+func (crypt *PSOCrypt) Decrypt(data []byte, size uint32) {
+	blockSize := crypt.cipher.blockSize()
+	for i := 0; i < int(size); i += blockSize {
+		block := data[i : i+blockSize]
+		crypt.cipher.decrypt(block)
+	}
+}
@@ -0,0 +1,352 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+// Known answers for each cipher. They weren't produced by this package but by a
+// separate reference implementation written from the algorithms in Fuzziqer
+// Software's encryption library (Blowfish's key schedule over PSOBB's tables
+// with four rounds for the data, and the PSOPC and Gamecube key streams), so a
+// mistake in the ciphers can't find its way into the answers. Each plaintext is
+// the bytes 0, 1, 2, ... and the stream ciphers encrypt it twice in a row to
+// cover the key stream carrying on between calls. The long answers are the
+// SHA-256 of 4096 bytes of sequence(4096, 5, 1) encrypted, which is long enough
+// for the PSOPC and Gamecube ciphers to mix their keys again part way through.
+
+func sequence(n int, mul, add byte) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i)*mul + add
+	}
+	return b
+}
+
+func unhex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+var bbVectors = []struct {
+	key        []byte
+	p          [4]uint32
+	s0         [2]uint32
+	s3Last     uint32
+	ciphertext string
+	long       string
+}{
+	{
+		key:        sequence(BBKeySize, 1, 0),
+		p:          [4]uint32{0xee76292c, 0x9feb4a61, 0xc1cdc28a, 0x1c9543f9},
+		s0:         [2]uint32{0x2a36f1f1, 0xa67c2330},
+		s3Last:     0xc661ad7c,
+		ciphertext: "2164dfb1f07a6aa0f6cb0b29c51fbd19a48f5179bf36a831948b4636578164c9",
+		long:       "ad32704bc7ed536631b95db6e7bc7518b5641f55bcb92b9371fbe8c3d3785ada",
+	},
+	{
+		key:        sequence(BBKeySize, 7, 3),
+		p:          [4]uint32{0x0ce034b5, 0x6480a0af, 0xe7fca60f, 0x514dcf14},
+		s0:         [2]uint32{0xb4a7f94c, 0xc180fa2f},
+		s3Last:     0x8b27e4ce,
+		ciphertext: "5f2d9fca45b99a2728ae5a7b244e1693fc26519ec8a29ce0c336888febc22582",
+		long:       "53a8ea763a1892de8d9b94cc7c9b4edf4089489548547b90c0963cd5c565caf7",
+	},
+	{
+		key:        make([]byte, BBKeySize),
+		p:          [4]uint32{0x8bab1278, 0xc1460684, 0x3037c395, 0x17f59b27},
+		s0:         [2]uint32{0xe734235e, 0xaf5fcfb1},
+		s3Last:     0xe4d5d3f7,
+		ciphertext: "909abca789ef4bc4ca6cb0c805efa6291776d9ed17e9aa86917db0fa1828dd38",
+		long:       "b3593a03e83e472cc024de64f9be94f17ce513c51f3d9d9194c3ff48518e24f8",
+	},
+	{
+		key:        bytes.Repeat([]byte{0xff}, BBKeySize),
+		p:          [4]uint32{0x5c4a329a, 0xd0b71f28, 0xfce953fd, 0xaa4b06d7},
+		s0:         [2]uint32{0x085e419b, 0x2ab820e3},
+		s3Last:     0x6861ca7b,
+		ciphertext: "209732955415ece73359555905d7159394f48183dea7457adf2508af24a00884",
+		long:       "8b6cd49f502e42c67e6d0b00d67cec52e286a7ec0a03cb04542b6e79dac95bee",
+	},
+}
+
+func TestBBKeySetup(t *testing.T) {
+	for _, v := range bbVectors {
+		crypt, err := NewBBCryptFromKey(append([]byte(nil), v.key...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := crypt.cipher.(*Cipher)
+		var p [4]uint32
+		copy(p[:], c.p[:4])
+		if p != v.p || c.s0[0] != v.s0[0] || c.s0[1] != v.s0[1] || c.s3[255] != v.s3Last {
+			t.Errorf("key %x: got p %#x, s0 %#x, s3[255] %#x", v.key, p, c.s0[:2], c.s3[255])
+		}
+		if !bytes.Equal(crypt.Key(), v.key) {
+			t.Errorf("key %x: Key returned %x", v.key, crypt.Key())
+		}
+	}
+}
+
+func TestBBEncrypt(t *testing.T) {
+	for _, v := range bbVectors {
+		crypt, err := NewBBCryptFromKey(append([]byte(nil), v.key...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := sequence(32, 1, 0)
+		crypt.Encrypt(data, uint32(len(data)))
+		if want := unhex(t, v.ciphertext); !bytes.Equal(data, want) {
+			t.Errorf("key %x: encrypted to %x, expected %x", v.key, data, want)
+		}
+	}
+}
+
+func TestBBDecrypt(t *testing.T) {
+	for _, v := range bbVectors {
+		crypt, err := NewBBCryptFromKey(append([]byte(nil), v.key...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := unhex(t, v.ciphertext)
+		crypt.Decrypt(data, uint32(len(data)))
+		if want := sequence(32, 1, 0); !bytes.Equal(data, want) {
+			t.Errorf("key %x: decrypted to %x, expected %x", v.key, data, want)
+		}
+	}
+}
+
+// Vectors for the PSOPC and Gamecube ciphers, which both XOR the data with a
+// stream of keys generated from a 32-bit seed.
+type streamVector struct {
+	key       []byte
+	keys      [4]uint32
+	lastKey   uint32
+	first     string
+	following string
+	long      string
+}
+
+var pcVectors = []streamVector{
+	{
+		key:       []byte{0x01, 0x02, 0x03, 0x04},
+		keys:      [4]uint32{0x00000000, 0x71466a9c, 0xe3137836, 0xdca412eb},
+		lastKey:   0x04030201,
+		first:     "bc1e7ebf30b235e25f23781db85b12c9",
+		following: "600a0b1323ae8a5647ba584fcf8b2f76",
+		long:      "1c7a46fbaca6bfa8704391a5b657fee12901ffdf7a85946394a691e04a69c808",
+	},
+	{
+		key:       []byte{0xef, 0xbe, 0xad, 0xde},
+		keys:      [4]uint32{0x00000000, 0x3eb55222, 0x16819d4e, 0xf977657b},
+		lastKey:   0xdeadbeef,
+		first:     "702ee8003ac3400571b75aaa92e84ecb",
+		following: "b42e1515539cc8015de91666df6e8dbb",
+		long:      "9bfe85a18c25c459cabb063b00a9446387d280c965d90293b34f240b3cc65fac",
+	},
+	{
+		key:       []byte{0x00, 0x00, 0x00, 0x00},
+		keys:      [4]uint32{0x00000000, 0xc4875487, 0x11a5e762, 0xf69f97f3},
+		lastKey:   0x00000000,
+		first:     "4689e462559192c4a8af48be4d85e8ba",
+		following: "bac6b7927b4e7162f2b0e062079abf72",
+		long:      "7e3320c67e9ea0bfc89f90cff174484aa9ec9498d0e93010a340252914311204",
+	},
+	{
+		key:       []byte{0xff, 0xff, 0xff, 0xff},
+		keys:      [4]uint32{0x00000000, 0x7fdf6872, 0x8a85fe8e, 0x9e990cfb},
+		lastKey:   0xffffffff,
+		first:     "d0dd0b986a325ebae1996924c292d44d",
+		following: "14d14175d33e7f43ad632a6d5f1a677d",
+		long:      "a469a732598351896b1a3054eeb1fa8b7d9f9972edc8978c24e18fd5bba0c36a",
+	},
+}
+
+var gcVectors = []streamVector{
+	{
+		key:       []byte{0x01, 0x02, 0x03, 0x04},
+		keys:      [4]uint32{0x8e7b0e88, 0x53d2db81, 0x4b2d196f, 0x5243a4cb},
+		lastKey:   0x30c4b2ea,
+		first:     "eab3c63353466ca8fa77b9681dad38c9",
+		following: "19c8ee8ed624441ac3cf5ee962084e4c",
+		long:      "c551a165342df1a6db6822940344b1171031fc7df2a70b177b3934110af74231",
+	},
+	{
+		key:       []byte{0xef, 0xbe, 0xad, 0xde},
+		keys:      [4]uint32{0x70f8ca85, 0xe7f69b2d, 0x7738342d, 0xf3c0b66a},
+		lastKey:   0x1e62469d,
+		first:     "9d47601d1783773639e54d46fc8db863",
+		following: "dda972e356621d6ccff31e880f19b170",
+		long:      "1e7df508f41c4c945da8b3a94c7567c2aa2f7a63bb2416c58bd7e3026574b58c",
+	},
+	{
+		key:       []byte{0x00, 0x00, 0x00, 0x00},
+		keys:      [4]uint32{0x18a1f089, 0x3ba769ca, 0x157ce11a, 0xbe8444bc},
+		lastKey:   0xbd1aa238,
+		first:     "38a318be9f1c3c200e34544c4a0d077d",
+		following: "651c0b3c8d797923be4137b8d302dd54",
+		long:      "cd7a940edf07cbd38a221b01abfa4ae153eb37e2f0f373befb3e17576d0b924e",
+	},
+	{
+		key:       []byte{0xff, 0xff, 0xff, 0xff},
+		keys:      [4]uint32{0x8b04d395, 0xdf3c4e2c, 0x939d1ac0, 0x64675bdb},
+		lastKey:   0xc8a94556,
+		first:     "5644abcbc3f0ef36dedb378a9949d959",
+		following: "658faa15a1e4bea466d6ccff13182392",
+		long:      "bd1703a51fe17331a283982f687d32972e19cef417b70f5c2acf41c34361073b",
+	},
+}
+
+func TestPCKeySetup(t *testing.T) {
+	for _, v := range pcVectors {
+		crypt, err := NewPCCryptFromKey(v.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := crypt.cipher.(*PCCrypt)
+		var keys [4]uint32
+		copy(keys[:], c.keys[:4])
+		if keys != v.keys || c.keys[56] != v.lastKey {
+			t.Errorf("key %x: got keys %#x, last %#x", v.key, keys, c.keys[56])
+		}
+	}
+}
+
+func TestGCKeySetup(t *testing.T) {
+	for _, v := range gcVectors {
+		crypt, err := NewGCCryptFromKey(v.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := crypt.cipher.(*GCCrypt)
+		var keys [4]uint32
+		copy(keys[:], c.keys[:4])
+		if keys != v.keys || c.keys[gcKeyCount-1] != v.lastKey {
+			t.Errorf("key %x: got keys %#x, last %#x", v.key, keys, c.keys[gcKeyCount-1])
+		}
+	}
+}
+
+// Encrypt and decrypt the plaintext twice with fresh ciphers from newCrypt and
+// compare the results with the vectors.
+func testStream(t *testing.T, vectors []streamVector, newCrypt func(key []byte) (*PSOCrypt, error)) {
+	for _, v := range vectors {
+		encrypter, err := newCrypt(v.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decrypter, err := newCrypt(v.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, expected := range []string{v.first, v.following} {
+			data := sequence(16, 1, 0)
+			encrypter.Encrypt(data, uint32(len(data)))
+			if want := unhex(t, expected); !bytes.Equal(data, want) {
+				t.Errorf("key %x: encrypted to %x, expected %x", v.key, data, want)
+			}
+			decrypter.Decrypt(data, uint32(len(data)))
+			if want := sequence(16, 1, 0); !bytes.Equal(data, want) {
+				t.Errorf("key %x: decrypted to %x, expected %x", v.key, data, want)
+			}
+		}
+	}
+}
+
+// Encrypt a long plaintext with a fresh cipher from newCrypt, a packet's worth
+// at a time, and compare its hash with the long answer, then decrypt it again.
+func testLong(t *testing.T, key []byte, long string, newCrypt func(key []byte) (*PSOCrypt, error)) {
+	encrypter, err := newCrypt(append([]byte(nil), key...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypter, err := newCrypt(append([]byte(nil), key...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const chunk = 64
+	data := sequence(4096, 5, 1)
+	for i := 0; i < len(data); i += chunk {
+		encrypter.Encrypt(data[i:i+chunk], uint32(chunk))
+	}
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != long {
+		t.Errorf("key %x: long plaintext encrypted to %x, expected %s", key, sum, long)
+	}
+	for i := 0; i < len(data); i += chunk {
+		decrypter.Decrypt(data[i:i+chunk], uint32(chunk))
+	}
+	if !bytes.Equal(data, sequence(4096, 5, 1)) {
+		t.Errorf("key %x: long plaintext didn't decrypt back", key)
+	}
+}
+
+func TestBBLong(t *testing.T) {
+	for _, v := range bbVectors {
+		testLong(t, v.key, v.long, NewBBCryptFromKey)
+	}
+}
+
+func TestPCLong(t *testing.T) {
+	for _, v := range pcVectors {
+		testLong(t, v.key, v.long, NewPCCryptFromKey)
+	}
+}
+
+func TestGCLong(t *testing.T) {
+	for _, v := range gcVectors {
+		testLong(t, v.key, v.long, NewGCCryptFromKey)
+	}
+}
+
+func TestPCStream(t *testing.T) {
+	testStream(t, pcVectors, NewPCCryptFromKey)
+}
+
+func TestGCStream(t *testing.T) {
+	testStream(t, gcVectors, NewGCCryptFromKey)
+}
+
+func TestKeySizes(t *testing.T) {
+	if _, err := NewBBCryptFromKey(make([]byte, BBKeySize-1)); err != KeySizeError(BBKeySize-1) {
+		t.Errorf("short PSOBB key: got %v", err)
+	}
+	if _, err := NewPCCryptFromKey(make([]byte, PCKeySize+1)); err != PCKeySizeError(PCKeySize+1) {
+		t.Errorf("long PSOPC key: got %v", err)
+	}
+	if _, err := NewGCCryptFromKey(make([]byte, GCKeySize-1)); err != GCKeySizeError(GCKeySize-1) {
+		t.Errorf("short Gamecube key: got %v", err)
+	}
+}
+
+// A cipher with a random key and one set up from its key on the other end of
+// the connection should agree.
+func TestKeyExchange(t *testing.T) {
+	for name, fns := range map[string]struct {
+		random  func() *PSOCrypt
+		fromKey func([]byte) (*PSOCrypt, error)
+	}{
+		"bb": {NewBBCrypt, NewBBCryptFromKey},
+		"pc": {NewPCCrypt, NewPCCryptFromKey},
+		"gc": {NewGCCrypt, NewGCCryptFromKey},
+	} {
+		local := fns.random()
+		remote, err := fns.fromKey(append([]byte(nil), local.Key()...))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		data := sequence(64, 3, 1)
+		local.Encrypt(data, uint32(len(data)))
+		if bytes.Equal(data, sequence(64, 3, 1)) {
+			t.Errorf("%s: encrypting didn't change the data", name)
+		}
+		remote.Decrypt(data, uint32(len(data)))
+		if !bytes.Equal(data, sequence(64, 3, 1)) {
+			t.Errorf("%s: decrypted to %x", name, data)
+		}
+	}
+}
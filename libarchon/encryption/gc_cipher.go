@@ -0,0 +1,106 @@
+/*
+* PSO Episode I & II (Gamecube) encryption algorithm. Like the PSOPC
+* cipher this is a stream of 32-bit keys XORed with the data, but the
+* stream is generated from a much larger table. Implementation based
+* on the encryption library included with Fuzziqer Software's newserv
+* code and Sylverant.
+ */
+
+package encryption
+
+import "strconv"
+
+const (
+	GCBlockSize = 4
+	gcKeyCount  = 521
+)
+
+type GCCrypt struct {
+	seed     uint32
+	position uint32
+	keys     []uint32
+}
+
+type GCKeySizeError int
+
+func (k GCKeySizeError) Error() string {
+	return "encryption/gccrypt: invalid key size " + strconv.Itoa(int(k))
+}
+
+func newGCCipher(key []byte) (psoCipher, error) {
+	if len(key) > GCKeySize {
+		return nil, GCKeySizeError(len(key))
+	}
+	// Key is expected to be in little endian.
+	crypt := &GCCrypt{seed: le(key), position: 0, keys: make([]uint32, gcKeyCount)}
+	crypt.createKeys()
+	return crypt, nil
+}
+
+func (crypt *GCCrypt) blockSize() int { return GCBlockSize }
+
+// Initialize the cipher.
+func (crypt *GCCrypt) createKeys() {
+	seed := crypt.seed
+	var basekey uint32
+	for i := 0; i <= 16; i++ {
+		for j := 0; j < 32; j++ {
+			seed = seed*0x5D588B65 + 1
+			basekey >>= 1
+			if seed&0x80000000 != 0 {
+				basekey |= 0x80000000
+			}
+		}
+		crypt.keys[i] = basekey
+	}
+	crypt.keys[16] = (crypt.keys[0] >> 9) ^ (crypt.keys[16] << 23) ^ crypt.keys[15]
+	for i, source := 17, 0; i < gcKeyCount; i, source = i+1, source+1 {
+		crypt.keys[i] = crypt.keys[source+16] ^
+			((crypt.keys[source] << 23) & 0xFF800000) ^
+			((crypt.keys[source+1] >> 9) & 0x007FFFFF)
+	}
+	for i := 0; i < 3; i++ {
+		crypt.mixKeys()
+	}
+	crypt.position = gcKeyCount - 1
+}
+
+func (crypt *GCCrypt) mixKeys() {
+	i := 0
+	for j := 489; j < gcKeyCount; i, j = i+1, j+1 {
+		crypt.keys[i] ^= crypt.keys[j]
+	}
+	for j := 0; i < gcKeyCount; i, j = i+1, j+1 {
+		crypt.keys[i] ^= crypt.keys[j]
+	}
+}
+
+func (crypt *GCCrypt) getNextKey() uint32 {
+	if crypt.position == gcKeyCount {
+		crypt.mixKeys()
+		crypt.position = 0
+	}
+	re := crypt.keys[crypt.position]
+	crypt.position++
+	return re
+}
+
+func (crypt *GCCrypt) encrypt(src []byte) {
+	crypt.process(src, len(src))
+}
+
+func (crypt *GCCrypt) decrypt(src []byte) {
+	crypt.process(src, len(src))
+}
+
+// Perform the actual encryption/decryption. The operation is
+// symmetrical, so the same algorithm can be applied for both.
+func (crypt *GCCrypt) process(data []byte, size int) {
+	for x := 0; x < size; x += 4 {
+		tmp := le(data[x:x+4]) ^ crypt.getNextKey()
+		data[x] = byte(tmp)
+		data[x+1] = byte(tmp >> 8)
+		data[x+2] = byte(tmp >> 16)
+		data[x+3] = byte(tmp >> 24)
+	}
+}
@@ -26,8 +26,8 @@ func (k PCKeySizeError) Error() string {
 }
 
 func newPCCipher(key []byte) (psoCipher, error) {
-	if len(key) > 4 {
-		return nil, PCKeySizeError(1)
+	if len(key) > PCKeySize {
+		return nil, PCKeySizeError(len(key))
 	}
 	// Key is expected to be in little endian.
 	crypt := &PCCrypt{seed: le(key), position: 0, keys: make([]uint32, 57)}
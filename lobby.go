@@ -0,0 +1,291 @@
+/*
+* Lobbies on the block servers. Each block has its own set of lobbies and each
+* lobby holds up to MaxLobbyPlayers clients, identified by their slot (client id).
+*
+* Lobbies are decorated for the scheduled event that's running (see events.go),
+* or else with lobby_event from the config. GMs can override the decorations
+* in a lobby with /lobbyevent, e.g. for screenshots, until they turn the override
+* off again.
+ */
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dcrodman/archon/packets"
+)
+
+// MaxLobbyPlayers is the number of players the client can display in a lobby.
+const MaxLobbyPlayers = 12
+
+// Menu id of the entries in the lobby list. The client also sends it back with
+// the lobby it wants when it follows a guildcard search to another player.
+const LobbyMenuId uint32 = 0x1A0001
+
+// Lobby decorations, as they're accepted by /lobbyevent.
+var lobbyEventNames = map[string]uint16{
+	"none":       0,
+	"christmas":  1,
+	"valentines": 3,
+	"easter":     4,
+	"halloween":  5,
+	"sonic":      6,
+	"newyear":    7,
+	"whiteday":   9,
+	"wedding":    10,
+}
+
+// Returns the lobby decorations for a name from lobbyEventNames or a number.
+func parseLobbyEvent(s string) (uint16, bool) {
+	if event, ok := lobbyEventNames[strings.ToLower(s)]; ok {
+		return event, true
+	}
+	event, err := strconv.ParseUint(s, 10, 16)
+	return uint16(event), err == nil
+}
+
+// clientSlots is a fixed set of numbered positions in which players can be
+// placed. The slot a player occupies is their client id; this is shared by
+// lobbies and games since they only differ in capacity.
+type clientSlots struct {
+	clients []*Client
+	sync.RWMutex
+}
+
+func newClientSlots(size int) clientSlots {
+	return clientSlots{clients: make([]*Client, size)}
+}
+
+// Place the client in the first open slot and return the client id assigned to them.
+func (s *clientSlots) add(c *Client) (uint8, error) {
+	s.Lock()
+	defer s.Unlock()
+	for i, slot := range s.clients {
+		if slot == nil {
+			s.clients[i] = c
+			c.clientId = uint8(i)
+			return uint8(i), nil
+		}
+	}
+	return 0, errors.New("No open slots")
+}
+
+// Remove the client from its slot, returning false if it wasn't there.
+func (s *clientSlots) remove(c *Client) bool {
+	s.Lock()
+	defer s.Unlock()
+	if int(c.clientId) < len(s.clients) && s.clients[c.clientId] == c {
+		s.clients[c.clientId] = nil
+		return true
+	}
+	return false
+}
+
+// Clients returns the clients currently occupying a slot.
+func (s *clientSlots) Clients() []*Client {
+	s.RLock()
+	defer s.RUnlock()
+	clients := make([]*Client, 0, len(s.clients))
+	for _, c := range s.clients {
+		if c != nil {
+			clients = append(clients, c)
+		}
+	}
+	return clients
+}
+
+// Client returns the client in the specified slot or nil if it's empty.
+func (s *clientSlots) Client(id uint8) *Client {
+	s.RLock()
+	defer s.RUnlock()
+	if int(id) >= len(s.clients) {
+		return nil
+	}
+	return s.clients[id]
+}
+
+// Leader returns the client id of the player in the lowest occupied slot.
+func (s *clientSlots) Leader() uint8 {
+	s.RLock()
+	defer s.RUnlock()
+	for i, c := range s.clients {
+		if c != nil {
+			return uint8(i)
+		}
+	}
+	return 0
+}
+
+// Full returns true if there is no room for another player.
+func (s *clientSlots) Full() bool {
+	return len(s.Clients()) >= len(s.clients)
+}
+
+// Broadcast sends the packet to every client except the sender (which may be
+// nil in order to send it to everyone).
+func (s *clientSlots) Broadcast(pkt interface{}, sender *Client) {
+	for _, c := range s.Clients() {
+		if c == sender {
+			continue
+		}
+		if err := EncryptAndSend(c, pkt); err != nil {
+			c.log.Warn(err.Error())
+		}
+	}
+}
+
+// Send a message from the player with the sender guildcard to everyone except
+// for the players who have blocked them.
+func (s *clientSlots) BroadcastMessage(pkt interface{}, sender uint32) {
+	for _, c := range s.Clients() {
+		if c.blocked.Blocks(sender) {
+			continue
+		}
+		if err := EncryptAndSend(c, pkt); err != nil {
+			c.log.Warn(err.Error())
+		}
+	}
+}
+
+// Lobby is one of the lobbies available on a block.
+type Lobby struct {
+	id    uint8
+	block uint16
+	clientSlots
+
+	eventLock sync.RWMutex
+	// Decorations set by a GM in place of the usual ones.
+	eventOverride   uint16
+	eventOverridden bool
+}
+
+func NewLobby(id uint8, block uint16) *Lobby {
+	return &Lobby{id: id, block: block, clientSlots: newClientSlots(MaxLobbyPlayers)}
+}
+
+// Build the join packet entry describing a player to the others in the lobby.
+func newPlayerJoinEntry(c *Client) packets.PlayerJoinEntry {
+	entry := packets.PlayerJoinEntry{
+		Player: packets.PlayerLobbyData{
+			PlayerTag: 0x00010000,
+			Guildcard: c.guildcard,
+			ClientId:  uint32(c.clientId),
+		},
+	}
+	if c.character != nil {
+		copyUtf16(entry.Player.Name[:], c.character.Name)
+		entry.Disp = newPlayerDispData(c.character)
+		entry.Disp.Techniques = newTechniqueLevels(c.techniques)
+	}
+	entry.Inventory = newInventory(c.inventory)
+	return entry
+}
+
+// Returns the decorations for the lobby.
+func (l *Lobby) Event() uint16 {
+	l.eventLock.RLock()
+	defer l.eventLock.RUnlock()
+	if l.eventOverridden {
+		return l.eventOverride
+	}
+	return currentLobbyEvent()
+}
+
+// Returns whether a GM has set the decorations for the lobby.
+func (l *Lobby) EventOverridden() bool {
+	l.eventLock.RLock()
+	defer l.eventLock.RUnlock()
+	return l.eventOverridden
+}
+
+// Put up the decorations for an event in place of the usual ones, or go back to
+// the usual ones if overridden is false, and show them to everyone in the lobby.
+func (l *Lobby) OverrideEvent(event uint16, overridden bool) {
+	l.eventLock.Lock()
+	l.eventOverride, l.eventOverridden = event, overridden
+	l.eventLock.Unlock()
+	l.Broadcast(&packets.BBHeader{Type: packets.LobbyEventType, Flags: uint32(l.Event())}, nil)
+}
+
+// Decorate the player's lobby for an event, by name or number, or go back to the
+// usual decorations with "off":
+//
+//	/lobbyevent <event|off>
+func runLobbyEventCommand(c *Client, args string) error {
+	lobby := c.lobby
+	if lobby == nil {
+		return errors.New("You have to be in a lobby to decorate it.")
+	}
+	if strings.ToLower(args) == "off" {
+		lobby.OverrideEvent(0, false)
+		c.log.Infof("Lobby %d decorations reset by guildcard %d", lobby.id+1, c.guildcard)
+		return SendClientMessage(c, "This lobby is back to its usual decorations.")
+	}
+	event, ok := parseLobbyEvent(args)
+	if !ok {
+		return errors.New("Usage: /lobbyevent <event|off>\nEvents: none, christmas, valentines, easter,\nhalloween, sonic, newyear, whiteday, wedding,\nor a number")
+	}
+	lobby.OverrideEvent(event, true)
+	c.log.Infof("Lobby %d decorated with event %d by guildcard %d", lobby.id+1, event, c.guildcard)
+	return SendClientMessage(c, "This lobby keeps these decorations until /lobbyevent off.")
+}
+
+// Add the client to the lobby and let everyone know they've arrived.
+func (l *Lobby) Join(c *Client) error {
+	if _, err := l.add(c); err != nil {
+		return errors.New("Lobby is full")
+	}
+	c.lobby = l
+	c.lastLobby = l
+	reportPlayer(c, l.block)
+	leader := l.Leader()
+	clients := l.Clients()
+	event := l.Event()
+
+	pkt := &packets.LobbyJoinPacket{
+		Header:      packets.BBHeader{Type: packets.LobbyJoinType, Flags: uint32(len(clients))},
+		ClientId:    c.clientId,
+		LeaderId:    leader,
+		DisableUDP:  0x01,
+		LobbyNumber: l.id,
+		BlockNumber: l.block,
+		Event:       event,
+	}
+	for _, other := range clients {
+		pkt.Entries = append(pkt.Entries, newPlayerJoinEntry(other))
+	}
+	c.log.Debug("Sending Lobby Join Packet")
+	if err := EncryptAndSend(c, pkt); err != nil {
+		return err
+	}
+
+	addPkt := &packets.LobbyJoinPacket{
+		Header:      packets.BBHeader{Type: packets.LobbyAddPlayerType, Flags: 1},
+		ClientId:    c.clientId,
+		LeaderId:    leader,
+		DisableUDP:  0x01,
+		LobbyNumber: l.id,
+		BlockNumber: l.block,
+		Event:       event,
+		Entries:     []packets.PlayerJoinEntry{newPlayerJoinEntry(c)},
+	}
+	l.Broadcast(addPkt, c)
+	return nil
+}
+
+// Remove the client from the lobby and notify everyone else.
+func (l *Lobby) Leave(c *Client) {
+	if !l.remove(c) {
+		return
+	}
+	c.lobby = nil
+	pkt := &packets.LobbyLeavePacket{
+		Header:   packets.BBHeader{Type: packets.LobbyLeaveType, Flags: uint32(c.clientId)},
+		ClientId: c.clientId,
+		LeaderId: l.Leader(),
+	}
+	l.Broadcast(pkt, nil)
+}
@@ -0,0 +1,253 @@
+/*
+* Logging. Everything is written through logrus: log is the logger for the
+* server as a whole and each sub server (PATCH, LOGIN, BLOCK1, etc.) gets its
+* own logger so that its level can be set separately in log_levels. Lines logged
+* while handling a client's packets carry the id of its connection so that
+* everything that happened on one connection can be pulled out of the log.
+ */
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Formats in which log lines can be written.
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+var (
+	logOutput    io.Writer = os.Stdout
+	logFormatter logrus.Formatter
+
+	subsystemLock sync.Mutex
+	// Loggers for each of the sub servers, keyed by lowercase name.
+	subsystemLoggers = make(map[string]*logrus.Logger)
+
+	// Last id assigned to a client connection.
+	lastConnectionId uint64
+
+	// Counts of the warnings and errors logged recently by any of the loggers.
+	recentLogStats = new(logStats)
+)
+
+// Number of minutes of warning and error counts that are kept.
+const logStatsMinutes = 60
+
+// Set up the global logger according to the config.
+func initializeLogger() error {
+	if config.Logfile != "" {
+		f, err := openRotatingFile(config.Logfile, int64(config.LogMaxSize)*1024*1024, config.LogMaxBackups)
+		if err != nil {
+			return errors.New("Failed to open log file " + config.Logfile + ": " + err.Error())
+		}
+		logOutput = f
+	}
+	if config.LogFormat == LogFormatJSON {
+		logFormatter = &logrus.JSONFormatter{}
+	} else {
+		logFormatter = &logrus.TextFormatter{
+			TimestampFormat: "2006-1-_2 15:04:05",
+			FullTimestamp:   true,
+			DisableSorting:  true,
+		}
+	}
+	log = newLogger(logrus.InfoLevel)
+	return setLogLevels()
+}
+
+func newLogger(level logrus.Level) *logrus.Logger {
+	hooks := make(logrus.LevelHooks)
+	hooks.Add(recentLogStats)
+	return &logrus.Logger{
+		Out:       logOutput,
+		Formatter: logFormatter,
+		Hooks:     hooks,
+		Level:     level,
+	}
+}
+
+// Apply the log levels from the config to all of the loggers.
+func setLogLevels() error {
+	level, err := logrus.ParseLevel(config.LogLevel)
+	if err != nil {
+		return errors.New("Failed to parse log level: " + err.Error())
+	}
+	levels := config.SubsystemLogLevels()
+	for name, l := range levels {
+		if _, err = logrus.ParseLevel(l); err != nil {
+			return fmt.Errorf("Failed to parse log level for %s: %s", name, err.Error())
+		}
+	}
+	log.SetLevel(level)
+
+	subsystemLock.Lock()
+	defer subsystemLock.Unlock()
+	for name, logger := range subsystemLoggers {
+		logger.SetLevel(subsystemLevel(name, level, levels))
+	}
+	return nil
+}
+
+// Returns the level set for a subsystem in log_levels, or level if there isn't one.
+func subsystemLevel(name string, level logrus.Level, levels map[string]string) logrus.Level {
+	if l, ok := levels[name]; ok {
+		level, _ = logrus.ParseLevel(l)
+	}
+	return level
+}
+
+// Returns the logger for one of the sub servers. Each line it writes is tagged
+// with the name of the subsystem.
+func subsystemLogger(name string) *logrus.Entry {
+	key := strings.ToLower(name)
+	subsystemLock.Lock()
+	defer subsystemLock.Unlock()
+	logger, ok := subsystemLoggers[key]
+	if !ok {
+		level, _ := logrus.ParseLevel(config.LogLevel)
+		logger = newLogger(subsystemLevel(key, level, config.SubsystemLogLevels()))
+		subsystemLoggers[key] = logger
+	}
+	return logger.WithField("subsystem", name)
+}
+
+// Returns the logger for a client of one of the sub servers.
+func clientLogger(subsystem string, c *Client) *logrus.Entry {
+	return subsystemLogger(subsystem).WithFields(logrus.Fields{"conn": c.id, "ip": c.IPAddr()})
+}
+
+// Returns a new id with which to tag a client's log lines.
+func nextConnectionId() string {
+	return strconv.FormatUint(atomic.AddUint64(&lastConnectionId, 1), 36)
+}
+
+// LogMinute is the number of warnings and errors logged during one minute.
+type LogMinute struct {
+	Time     time.Time `json:"time"`
+	Warnings int       `json:"warnings"`
+	Errors   int       `json:"errors"`
+}
+
+// Hook that counts the warnings and errors logged in each of the last
+// logStatsMinutes minutes. Lines below a logger's level aren't counted.
+type logStats struct {
+	sync.Mutex
+	minutes [logStatsMinutes]LogMinute
+}
+
+func (stats *logStats) Levels() []logrus.Level {
+	return []logrus.Level{logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel, logrus.WarnLevel}
+}
+
+func (stats *logStats) Fire(entry *logrus.Entry) error {
+	minute := entry.Time.Truncate(time.Minute)
+	stats.Lock()
+	defer stats.Unlock()
+	m := &stats.minutes[minute.Unix()/60%logStatsMinutes]
+	if !m.Time.Equal(minute) {
+		*m = LogMinute{Time: minute}
+	}
+	if entry.Level == logrus.WarnLevel {
+		m.Warnings++
+	} else {
+		m.Errors++
+	}
+	return nil
+}
+
+// Recent returns the counts for each of the last logStatsMinutes minutes up to
+// now, oldest first.
+func (stats *logStats) Recent(now time.Time) []LogMinute {
+	stats.Lock()
+	defer stats.Unlock()
+	recent := make([]LogMinute, logStatsMinutes)
+	now = now.Truncate(time.Minute)
+	for i := range recent {
+		minute := now.Add(time.Duration(i-logStatsMinutes+1) * time.Minute)
+		recent[i] = stats.minutes[minute.Unix()/60%logStatsMinutes]
+		if !recent[i].Time.Equal(minute) {
+			recent[i] = LogMinute{Time: minute}
+		}
+	}
+	return recent
+}
+
+// A log file that's rotated once it reaches maxSize bytes, keeping up to
+// backups old files named filename.1 (the newest) through filename.<backups>.
+// A maxSize of 0 disables rotation.
+type rotatingFile struct {
+	sync.Mutex
+	filename string
+	maxSize  int64
+	backups  int
+
+	file *os.File
+	size int64
+}
+
+func openRotatingFile(filename string, maxSize int64, backups int) (*rotatingFile, error) {
+	rf := &rotatingFile{filename: filename, maxSize: maxSize, backups: backups}
+	return rf, rf.open()
+}
+
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.file, rf.size = f, info.Size()
+	return nil
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.Lock()
+	defer rf.Unlock()
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			// Keep writing to the current file rather than losing the line.
+			fmt.Fprintln(os.Stderr, "Failed to rotate log file: "+err.Error())
+		}
+	}
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *rotatingFile) rotate() error {
+	if rf.backups == 0 {
+		if err := rf.file.Truncate(0); err != nil {
+			return err
+		}
+		rf.size = 0
+		return nil
+	}
+	for i := rf.backups - 1; i > 0; i-- {
+		os.Rename(rf.filename+"."+strconv.Itoa(i), rf.filename+"."+strconv.Itoa(i+1))
+	}
+	if err := os.Rename(rf.filename, rf.filename+".1"); err != nil {
+		return err
+	}
+	// If the new file can't be opened then we'll keep appending to the old one.
+	old := rf.file
+	if err := rf.open(); err != nil {
+		return err
+	}
+	return old.Close()
+}
@@ -5,26 +5,18 @@ import (
 	"net"
 	"strconv"
 
-	crypto "github.com/dcrodman/archon/encryption"
+	crypto "github.com/dcrodman/archon/libarchon/encryption"
+	"github.com/dcrodman/archon/packets"
 	"github.com/dcrodman/archon/util"
 )
 
-// Struct for caching the parameter chunk data and header so
-// that the param files aren't re-read every time.
-type parameterEntry struct {
-	Size     uint32
-	Checksum uint32
-	Offset   uint32
-	Filename [0x40]uint8
-}
-
 // Create and initialize a new Login client so long as we're able
 // to send the welcome packet to begin encryption.
-func NewLoginClient(conn *net.TCPConn) (*Client, error) {
+func NewLoginClient(conn net.Conn) (*Client, error) {
 	var err error
 	cCrypt := crypto.NewBBCrypt()
 	sCrypt := crypto.NewBBCrypt()
-	lc := NewClient(conn, BBHeaderSize, cCrypt, sCrypt)
+	lc := NewClient(conn, packets.BBHeaderSize, cCrypt, sCrypt)
 	if SendWelcome(lc) != nil {
 		err = errors.New("Error sending welcome packet to: " + lc.IPAddr())
 		lc = nil
@@ -42,39 +34,71 @@ func (server LoginServer) Name() string { return "LOGIN" }
 
 func (server LoginServer) Port() string { return config.LoginPort }
 
+func (server LoginServer) RateLimited() {}
+
+var loginPacketSizes = map[uint16]int{
+	packets.LoginType: packetSize(&packets.LoginPkt{}),
+}
+
+func (server LoginServer) MinPacketSizes() map[uint16]int { return loginPacketSizes }
+
 func (server *LoginServer) Init() error {
 	charPort, _ := strconv.ParseUint(config.CharacterPort, 10, 16)
 	server.charRedirectPort = uint16(charPort)
+	go purgeLoginAttempts()
 	return nil
 }
 
-func (server *LoginServer) NewClient(conn *net.TCPConn) (*Client, error) {
+func (server *LoginServer) NewClient(conn net.Conn) (*Client, error) {
 	return NewLoginClient(conn)
 }
 
 func (server *LoginServer) Handle(c *Client) error {
-	var hdr BBHeader
-	util.StructFromBytes(c.Data()[:BBHeaderSize], &hdr)
+	var hdr packets.BBHeader
+	if err := c.Decode(&hdr); err != nil {
+		return err
+	}
 
 	var err error
 	switch hdr.Type {
-	case LoginType:
+	case packets.LoginType:
 		err = server.HandleLogin(c)
-	case DisconnectType:
+	case packets.DisconnectType:
 		// Just wait until we recv 0 from the client to d/c.
 		break
 	default:
-		log.Infof("Received unknown packet %x from %s", hdr.Type, c.IPAddr())
+		c.log.Infof("Received unknown packet %x", hdr.Type)
 	}
 	return err
 }
 
+// Log a player in and record the attempt in the login audit log.
 func (server *LoginServer) HandleLogin(client *Client) error {
-	//loginPkt, err := VerifyAccount(client)
-	_, err := VerifyAccount(client)
+	var attempt packets.LoginPkt
+	if err := client.Decode(&attempt); err != nil {
+		return err
+	}
+	err := server.login(client)
+	recordLoginAttempt(client, string(util.StripPadding(attempt.Username[:])),
+		uint32(attempt.ClientVersion), err)
+	return err
+}
+
+func (server *LoginServer) login(client *Client) error {
+	loginPkt, err := VerifyAccount(client)
 	if err != nil {
 		return err
 	}
+	if err = checkMaintenance(client); err != nil {
+		return err
+	}
+	if err = checkTwoFactorLogin(client, loginPkt); err != nil {
+		return err
+	}
+	if err = admitSession(client); err != nil {
+		return err
+	}
+	recordHardware(client)
 
 	// The first time we receive this packet the client will have included the
 	// version string in the security data; check it.
@@ -87,8 +111,12 @@ func (server *LoginServer) HandleLogin(client *Client) error {
 	// used to indicate that the client has made it through the LOGIN server,
 	// but for now we'll just set it and leave it alone.
 	client.config.Magic = 0x48615467
+	if err = startSession(client); err != nil {
+		sendDatabaseError(client, err)
+		return err
+	}
+	recentLogins.Add(client)
 
-	ipAddr := config.BroadcastIP()
-	SendSecurity(client, BBLoginErrorNone, client.guildcard, client.teamId)
-	return SendRedirect(client, ipAddr[:], server.charRedirectPort)
+	SendSecurity(client, packets.BBLoginErrorNone, client.guildcard, client.teamId)
+	return SendRedirect(client, redirectIP(client, "character"), server.charRedirectPort)
 }
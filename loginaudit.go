@@ -0,0 +1,268 @@
+/*
+* Audit log of the attempts to log in to the login servers. Every attempt is
+* recorded with the username (or serial number) that was sent, the address it
+* came from, the version of the client, and whether it succeeded or why it was
+* refused. Versions before Blue Burst check their license when they connect and
+* again when they log in, so only the refusals of the first check are recorded;
+* the second records the outcome either way. Blue Burst clients that the
+* character server disconnects for not being one of the client_builds are
+* recorded as version mismatches. Attempts are kept for
+* login_audit.days, and can be searched by username or address at
+* /admin/login-attempts.
+*
+* Failed logins are also counted over the last alert_window_minutes, and an
+* alert is raised when the count for one address (alert_ip_failures) or one
+* account (alert_account_failures) reaches its threshold: the signs of someone
+* guessing passwords. Only wrong passwords, two-factor codes, and usernames
+* count. Alerts are logged and sent to the webhook and email addresses in the
+* config, and aren't repeated for the same address or account until
+* alert_cooldown_minutes have passed. The counts are kept by each process, so
+* they don't add up the failures on login servers run separately.
+ */
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dcrodman/archon/data"
+)
+
+const (
+	// How often attempts older than login_audit.days are removed.
+	loginAuditPurgeInterval = time.Hour
+	// Number of attempts listed if no limit is given.
+	defaultLoginAttemptLimit = 50
+	// How long to wait for the alert webhook to answer.
+	loginAlertTimeout = 10 * time.Second
+)
+
+// loginRefusal is returned by the login checks when they turn a player away,
+// recording which result to log the attempt with.
+type loginRefusal struct {
+	result string
+	err    error
+}
+
+func (r *loginRefusal) Error() string { return r.err.Error() }
+
+func (r *loginRefusal) Unwrap() error { return r.err }
+
+// Wrap err, the reason that a login was refused, with its result for the audit
+// log (one of the data.Login* results).
+func refuseLogin(result string, err error) error {
+	return &loginRefusal{result: result, err: err}
+}
+
+// Returns the result to log for an attempt that ended with err.
+func loginResult(err error) string {
+	var refusal *loginRefusal
+	switch {
+	case err == nil:
+		return data.LoginSucceeded
+	case errors.As(err, &refusal):
+		return refusal.result
+	}
+	return data.LoginError
+}
+
+// Record an attempt to log in on c with username that ended with err, and
+// count it towards the alerts if it failed.
+func recordLoginAttempt(c *Client, username string, clientVersion uint32, err error) {
+	attempt := &data.LoginAttempt{
+		CreatedAt:     time.Now(),
+		Username:      strings.ToValidUTF8(username, "?"),
+		Guildcard:     c.guildcard,
+		IP:            c.IPAddr(),
+		Version:       c.version.String(),
+		ClientVersion: clientVersion,
+		Result:        loginResult(err),
+	}
+	// The packet's deadline may already have passed by the time it failed.
+	if dbErr := database.CreateLoginAttempt(attempt); dbErr != nil {
+		c.log.Warn("Failed to record login attempt: " + dbErr.Error())
+	}
+	loginFailures.Add(attempt)
+}
+
+// Synchronized counts of the recent failed logins from each address and to each
+// account.
+type loginFailureTracker struct {
+	// Times of the failures within the window, oldest first, by key.
+	failures map[string][]time.Time
+	// Time of the last alert by key.
+	alerted map[string]time.Time
+	swept   time.Time
+	sync.Mutex
+}
+
+var loginFailures = &loginFailureTracker{
+	failures: make(map[string][]time.Time),
+	alerted:  make(map[string]time.Time),
+}
+
+// Add counts attempt if it failed, raising any alerts that it sets off.
+func (t *loginFailureTracker) Add(attempt *data.LoginAttempt) {
+	cfg := config.LoginAudit()
+	switch attempt.Result {
+	case data.LoginBadPassword, data.LoginBadTwoFactor:
+		if n := t.count("account "+strings.ToLower(attempt.Username), attempt.CreatedAt, cfg,
+			cfg.LoginAlertAccountFailures); n > 0 {
+			go sendLoginAlert(cfg, loginAlert{
+				Text: fmt.Sprintf("%d failed logins to the account %s in the last %d minutes",
+					n, attempt.Username, cfg.LoginAlertWindowMinutes),
+				Username: attempt.Username,
+				Failures: n,
+			})
+		}
+	case data.LoginUnknownAccount:
+	default:
+		return
+	}
+	if n := t.count("ip "+attempt.IP, attempt.CreatedAt, cfg, cfg.LoginAlertIPFailures); n > 0 {
+		go sendLoginAlert(cfg, loginAlert{
+			Text: fmt.Sprintf("%d failed logins from %s in the last %d minutes",
+				n, attempt.IP, cfg.LoginAlertWindowMinutes),
+			IP:       attempt.IP,
+			Failures: n,
+		})
+	}
+}
+
+// Count a failure for key at now, returning the number of failures within the
+// window if that's enough to raise an alert, or 0 otherwise.
+func (t *loginFailureTracker) count(key string, now time.Time, cfg LoginAuditConfig, threshold int) int {
+	if threshold <= 0 {
+		return 0
+	}
+	window := time.Duration(cfg.LoginAlertWindowMinutes) * time.Minute
+	cooldown := time.Duration(cfg.LoginAlertCooldownMinutes) * time.Minute
+	t.Lock()
+	defer t.Unlock()
+	t.sweep(now, window, cooldown)
+
+	times := append(t.failures[key], now)
+	for len(times) > 0 && now.Sub(times[0]) > window {
+		times = times[1:]
+	}
+	// Only the last threshold failures matter.
+	if len(times) > threshold {
+		times = times[len(times)-threshold:]
+	}
+	t.failures[key] = times
+	if len(times) < threshold {
+		return 0
+	}
+	if last, ok := t.alerted[key]; ok && now.Sub(last) < cooldown {
+		return 0
+	}
+	t.alerted[key] = now
+	return len(times)
+}
+
+// Forget the keys that haven't failed within the window or been alerted about
+// within the cooldown, at most once per window. Must be called with the lock
+// held.
+func (t *loginFailureTracker) sweep(now time.Time, window, cooldown time.Duration) {
+	if now.Sub(t.swept) < window {
+		return
+	}
+	t.swept = now
+	for key, times := range t.failures {
+		if now.Sub(times[len(times)-1]) > window {
+			delete(t.failures, key)
+		}
+	}
+	for key, last := range t.alerted {
+		if now.Sub(last) >= cooldown {
+			delete(t.alerted, key)
+		}
+	}
+}
+
+// Body of the requests made to the alert webhook.
+type loginAlert struct {
+	// The message, under the names that Slack and Discord expect.
+	Text    string `json:"text"`
+	Content string `json:"content"`
+	// Address or account that the logins failed from or to.
+	IP            string `json:"ip,omitempty"`
+	Username      string `json:"username,omitempty"`
+	Failures      int    `json:"failures"`
+	WindowMinutes int    `json:"window_minutes"`
+}
+
+// Log an alert and send it to the webhook and email addresses in cfg.
+func sendLoginAlert(cfg LoginAuditConfig, alert loginAlert) {
+	alert.Content = alert.Text
+	alert.WindowMinutes = cfg.LoginAlertWindowMinutes
+	log.Warn("Login alert: " + alert.Text)
+
+	if cfg.LoginAlertWebhookURL != "" {
+		body, _ := json.Marshal(alert)
+		client := &http.Client{Timeout: loginAlertTimeout}
+		resp, err := client.Post(cfg.LoginAlertWebhookURL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Error("Failed to send login alert to webhook: " + err.Error())
+		} else {
+			resp.Body.Close()
+			if resp.StatusCode >= http.StatusMultipleChoices {
+				log.Error("Login alert webhook returned " + resp.Status)
+			}
+		}
+	}
+	if len(cfg.LoginAlertEmails) > 0 {
+		err := sendEmail(cfg.LoginAlertEmails, config.ShipName+" login alert", alert.Text+".\r\n")
+		if err != nil {
+			log.Error("Failed to email login alert: " + err.Error())
+		}
+	}
+}
+
+// Loop for the life of the server, removing login attempts that are older than
+// login_audit.days.
+func purgeLoginAttempts() {
+	for {
+		if days := config.LoginAudit().LoginAuditDays; days > 0 {
+			if n, err := database.PurgeLoginAttempts(time.Now().AddDate(0, 0, -days)); err != nil {
+				log.Error("Failed to purge login attempts: " + err.Error())
+			} else if n > 0 {
+				log.Infof("Purged %d login attempts", n)
+			}
+		}
+		time.Sleep(loginAuditPurgeInterval)
+	}
+}
+
+func handleAdminLoginAttempts(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	query := req.URL.Query()
+	limit := defaultLoginAttemptLimit
+	if query.Get("limit") != "" {
+		var err error
+		if limit, err = strconv.Atoi(query.Get("limit")); err != nil || limit <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+	}
+	attempts, err := database.Replica().FindLoginAttempts(query.Get("username"), query.Get("ip"), limit)
+	if err != nil {
+		log.Error("Failed to look up login attempts: " + err.Error())
+		writeError(w, http.StatusInternalServerError, "unable to look up login attempts")
+		return
+	}
+	if attempts == nil {
+		attempts = []data.LoginAttempt{}
+	}
+	writeJSON(w, http.StatusOK, attempts)
+}
@@ -0,0 +1,107 @@
+/*
+* Simple mail sent between players by guildcard number. Mail for players who
+* are online is delivered immediately, by way of the shipgate if they're on
+* another ship; everything else is stored until the recipient's next login.
+ */
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dcrodman/archon/data"
+	"github.com/dcrodman/archon/packets"
+	"github.com/dcrodman/archon/util"
+)
+
+const (
+	// Maximum number of undelivered messages that can be waiting for a player.
+	MaxPendingMail = 20
+	// Format of the date shown on each message.
+	MailDateFormat = "2006.01.02 15:04"
+)
+
+// The player sent a simple mail to someone.
+func (server *BlockServer) HandleSimpleMail(c *Client) error {
+	var pkt packets.SimpleMailPacket
+	if err := c.Decode(&pkt); err != nil {
+		return err
+	}
+	if c.character == nil {
+		return errors.New("Client sent mail without a character: " + c.IPAddr())
+	}
+
+	mail := &data.Mail{
+		Recipient: pkt.Recipient,
+		Sender:    c.guildcard,
+		SentAt:    time.Now(),
+		Message:   trimUtf16(pkt.Message[:]),
+	}
+	var name [16]uint16
+	copyUtf16(name[:], c.character.Name)
+	mail.SenderName = trimUtf16(name[:])
+
+	if recipient := players.Find(pkt.Recipient); recipient != nil {
+		// Mail from blocked players is dropped without telling the sender.
+		if !recipient.blocked.Blocks(c.guildcard) {
+			return sendSimpleMail(recipient, mail)
+		}
+		return nil
+	} else if routeShipMail(mail) {
+		return nil
+	}
+
+	blocked, err := c.db().FindBlockedGuildcards(pkt.Recipient)
+	if err != nil {
+		return err
+	}
+	for _, entry := range blocked {
+		if uint32(entry.BlockedGuildcard) == c.guildcard {
+			return nil
+		}
+	}
+	pending, err := c.db().CountMail(pkt.Recipient)
+	if err != nil {
+		return err
+	}
+	if pending >= MaxPendingMail {
+		return SendClientMessage(c, "That player's mailbox is full.")
+	}
+	if err := c.db().CreateMail(mail); err != nil {
+		return errors.New("Failed to save mail: " + err.Error())
+	}
+	return nil
+}
+
+// Send a piece of mail to the recipient.
+func sendSimpleMail(c *Client, mail *data.Mail) error {
+	pkt := &packets.SimpleMailPacket{
+		Header:    packets.BBHeader{Type: packets.SimpleMailType},
+		Tag:       0x00010000,
+		Guildcard: mail.Sender,
+		Recipient: mail.Recipient,
+	}
+	copy(pkt.Name[:], mail.SenderName)
+	copyUtf16(pkt.Date[:], util.ConvertToUtf16(mail.SentAt.Local().Format(MailDateFormat)))
+	copy(pkt.Message[:], mail.Message)
+
+	c.log.Debug("Sending Simple Mail Packet")
+	return EncryptAndSend(c, pkt)
+}
+
+// Deliver any mail that arrived while the player was offline.
+func deliverPendingMail(c *Client) error {
+	mail, err := c.db().FindMail(c.guildcard)
+	if err != nil {
+		return err
+	}
+	for i := range mail {
+		if err := sendSimpleMail(c, &mail[i]); err != nil {
+			return err
+		}
+		if err := c.db().DeleteMail(mail[i].Id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,13 +1,16 @@
 package main
 
 import (
-	"container/list"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
+	"time"
 
+	"github.com/dcrodman/archon/data"
 	"github.com/sirupsen/logrus"
 )
 
@@ -20,18 +23,21 @@ const (
 
 // Global variables that should not be globals at some point.
 var (
-	log        *logrus.Logger
+	// Replaced by initializeLogger once the servers are starting. Until then,
+	// and for the commands that don't start them, messages go to stderr.
+	log        = logrus.New()
 	configPath = flag.String("conf", "", "Full path to a custom config file location")
+	migrateTo  = flag.Int("migrate", 0, "Migrate the database schema to a specific version and exit")
 )
 
 func main() {
-	fmt.Println("Archon PSO Server, Copyright (C) 2014 Andrew Rodman\n" +
+	fmt.Print("Archon PSO Server, Copyright (C) 2014 Andrew Rodman\n" +
 		"=====================================================\n" +
 		"This program is free software: you can redistribute it and/or\n" +
 		"modify it under the terms of the GNU General Public License as\n" +
 		"published by the Free Software Foundation, either version 3 of\n" +
 		"the License, or (at your option) any later version.\n" +
-		"This program is distributed WITHOUT ANY WARRANTY; See LICENSE for details.\n")
+		"This program is distributed WITHOUT ANY WARRANTY; See LICENSE for details.\n\n")
 	flag.Parse()
 
 	// Initialize our config singleton from one of two expected file locations.
@@ -55,6 +61,14 @@ func main() {
 	}
 	fmt.Printf("Done.\n\n--Configuration Parameters--\n%v\n\n", config.String())
 
+	if ran, err := runServerCommand(flag.Arg(0)); ran {
+		if err != nil {
+			fmt.Println("Failed: " + err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Set up the database singleton with the params from the config file.
 	fmt.Printf("Connecting to database %s:%s...", config.DBHost, config.DBPort)
 	database, err = InitializeDatabase()
@@ -66,61 +80,225 @@ func main() {
 	defer database.Close()
 	fmt.Print("Done.\n\n")
 
+	if err = initializeSchema(); err != nil {
+		fmt.Println("Failed: " + err.Error())
+		database.Close()
+		os.Exit(1)
+	}
+	if *migrateTo > 0 {
+		return
+	}
+	if characterCache, err = newCharacterCache(); err != nil {
+		fmt.Println("Failed: " + err.Error())
+		database.Close()
+		os.Exit(1)
+	}
+	services := parseServices(flag.Args())
+	if cmd := flag.Arg(0); cmd != "" && services == nil {
+		switch cmd {
+		case "account":
+			err = runAccountCommand(flag.Args()[1:])
+		case "ban", "unban", "bans":
+			err = runBanCommand(flag.Args())
+		case "hardware":
+			err = runHardwareCommand(flag.Args()[1:])
+		case "snapshot":
+			err = runSnapshotCommand(flag.Args()[1:])
+		case "audit":
+			err = runItemAuditCommand(flag.Args()[1:])
+		case "character":
+			err = runCharacterCommand(flag.Args()[1:])
+		case "import":
+			err = runImportCommand(flag.Args()[1:])
+		default:
+			err = errors.New("unknown command " + cmd)
+		}
+		if err != nil {
+			fmt.Println("Failed: " + err.Error())
+			database.Close()
+			os.Exit(1)
+		}
+		return
+	}
+	if err = checkServices(services); err != nil {
+		fmt.Println("ERROR: " + err.Error())
+		os.Exit(1)
+	}
+	if err = initializeLogger(); err != nil {
+		fmt.Println("ERROR: " + err.Error())
+		os.Exit(1)
+	}
+	limiter, err := newRateLimiter(config.RateLimits())
+	if err != nil {
+		fmt.Println("Failed to parse rate limit whitelist: " + err.Error())
+		os.Exit(1)
+	}
 	StartDebugServer()
-	initializeLogger(config.Logfile)
-
-	c := controller{
-		host:        config.Hostname,
-		servers:     make([]Server, 0),
-		connections: &clientList{clients: list.New()},
+	if services[serviceLogin] {
+		StartWebServer(limiter)
+	}
+	if services[serviceLogin] || services[serviceShip] {
+		if err = initShips(services[serviceShip]); err != nil {
+			fmt.Println("ERROR: " + err.Error())
+			os.Exit(1)
+		}
 	}
-	registerServers(&c)
+	c := newController(config.Hostname, limiter)
+	registerServers(c, services)
 
 	// Start up all of our servers and block until they exit.
-	wg := c.start()
-	if wg != nil {
-		wg.Wait()
+	if c.start() {
+		handleReloadSignal(c)
+		handleShutdownSignal(c)
+		go runEventScheduler()
+		StartAdminServer(c)
+		StartHealthServer(c)
+		if err = writePidFile(); err != nil {
+			log.Warn("Failed to write pid file: " + err.Error())
+		}
+		c.wait()
+		if config.PidFile != "" {
+			os.Remove(config.PidFile)
+		}
+		fmt.Println("Shutdown complete.")
 	}
 }
 
-// Set up the logger to write to the specified filename.
-func initializeLogger(filename string) {
-	var w io.Writer
-	var err error
-	if filename != "" {
-		w, err = os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-		if err != nil {
-			fmt.Println("ERROR: Failed to open log file " + config.Logfile)
-			os.Exit(1)
-		}
-	} else {
-		w = os.Stdout
+// Run one of the commands that act on a running server. They only need the
+// config to find it, so they're run before connecting to the database. Returns
+// false if cmd isn't one of them.
+func runServerCommand(cmd string) (bool, error) {
+	switch cmd {
+	case "dashboard":
+		return true, runDashboard()
+	case "drain":
+		return true, signalServer(syscall.SIGUSR1)
+	case "reload":
+		return true, signalServer(syscall.SIGHUP)
 	}
+	return false, nil
+}
+
+// Reload the config and the servers' data (such as the patch files) whenever
+// we receive SIGHUP.
+func handleReloadSignal(c *controller) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			c.reload()
+		}
+	}()
+}
 
-	logLvl, err := logrus.ParseLevel(config.LogLevel)
+// Shut down cleanly on SIGINT or SIGTERM and start draining on SIGUSR1.
+func handleShutdownSignal(c *controller) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGUSR1)
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGUSR1 {
+				c.drain()
+				continue
+			}
+			log.Infof("Received %v, shutting down", sig)
+			c.gracefulShutdown()
+			return
+		}
+	}()
+}
+
+// Make sure the database schema matches what this version of the server expects,
+// either by migrating it or bailing so that queries don't fail in strange ways.
+func initializeSchema() error {
+	current, latest, err := database.SchemaVersion()
 	if err != nil {
-		fmt.Println("ERROR: Failed to parse log level: " + err.Error())
-		os.Exit(1)
+		return err
+	}
+
+	target := data.LatestSchema
+	switch {
+	case *migrateTo > 0:
+		target = *migrateTo
+	case current == latest:
+		return nil
+	case current > latest:
+		return fmt.Errorf("database schema version %d is newer than the latest known version %d",
+			current, latest)
+	case !config.DBAutoMigrate:
+		return fmt.Errorf("database schema is at version %d but version %d is required; "+
+			"enable db_auto_migrate or run with -migrate %d", current, latest, latest)
+	}
+
+	fmt.Printf("Migrating database schema from version %d...", current)
+	if err = database.Migrate(target); err != nil {
+		return err
+	}
+	current, _, err = database.SchemaVersion()
+	fmt.Printf("Done (now at version %d).\n\n", current)
+	return err
+}
+
+// Groups of servers that can be run in a process of their own with "archon
+// <service>", e.g. to put the patch servers on a different machine than the
+// ships. The shipgate runs with the login service, since the character server
+// lists the ships that register with it.
+const (
+	servicePatch = "patch"
+	serviceLogin = "login"
+	serviceShip  = "ship"
+)
+
+// Returns the services named on the command line (all of them for "all" or no
+// arguments), or nil if the arguments aren't a list of services.
+func parseServices(args []string) map[string]bool {
+	if len(args) == 0 || (len(args) == 1 && args[0] == "all") {
+		return map[string]bool{servicePatch: true, serviceLogin: true, serviceShip: true}
+	}
+	services := make(map[string]bool)
+	for _, arg := range args {
+		switch arg {
+		case servicePatch, serviceLogin, serviceShip:
+			services[arg] = true
+		default:
+			return nil
+		}
 	}
-	log = &logrus.Logger{
-		Out: w,
-		Formatter: &logrus.TextFormatter{
-			TimestampFormat: "2006-1-_2 15:04:05",
-			FullTimestamp:   true,
-			DisableSorting:  true,
-		},
-		Hooks: make(logrus.LevelHooks),
-		Level: logLvl,
+	return services
+}
+
+// Check that the services can do without the ones run by other processes.
+func checkServices(services map[string]bool) error {
+	if services[serviceShip] && !services[serviceLogin] && config.ShipgateAddress == "" {
+		return errors.New("shipgate_address must be set to run the ship without the login " +
+			"service, so that the ship can register with its shipgate")
+	}
+	if services[serviceLogin] && !services[serviceShip] && (config.CertificateFile == "" || config.KeyFile == "") {
+		return errors.New("certificate_file and key_file must be set to run the login service " +
+			"without the ship, so that ships can register with its shipgate")
 	}
+	return nil
 }
 
-// Register all of the server handlers and their corresponding ports.
-func registerServers(controller *controller) {
-	controller.registerServer(new(PatchServer))
-	controller.registerServer(new(DataServer))
-	controller.registerServer(new(LoginServer))
-	controller.registerServer(new(CharacterServer))
-	controller.registerServer(new(ShipgateServer))
+// Register the server handlers and their corresponding ports for the services
+// that this process runs.
+func registerServers(controller *controller, services map[string]bool) {
+	if services[servicePatch] {
+		controller.registerServer(new(PatchServer))
+		controller.registerServer(new(DataServer))
+	}
+	if services[serviceLogin] {
+		controller.registerServer(new(LoginServer))
+		controller.registerServer(new(CharacterServer))
+		controller.registerServer(new(ShipgateServer))
+		for _, legacy := range config.LegacyLogins {
+			version, _ := parseClientVersion(legacy.Version)
+			controller.registerServer(&LegacyLoginServer{version: version, port: legacy.Port})
+		}
+	}
+	if !services[serviceShip] {
+		return
+	}
 	controller.registerServer(new(ShipServer))
 
 	// The available block ports will depend on how the server is configured,
@@ -128,8 +306,96 @@ func registerServers(controller *controller) {
 	shipPort, _ := strconv.ParseInt(config.ShipPort, 10, 16)
 	for i := 1; i <= config.NumBlocks; i++ {
 		controller.registerServer(&BlockServer{
+			id:   uint16(i),
 			name: fmt.Sprintf("BLOCK%d", i),
 			port: strconv.FormatInt(shipPort+int64(i), 10),
 		})
 	}
 }
+
+// Run one of the admin commands for characters:
+//
+//	archon character deleted <guildcard>
+//	archon character restore <guildcard> <id> [slot]
+//	archon character export <username> <slot> [file]
+//	archon character import <username> <slot> <file>
+//
+// Export and import are handled in export.go.
+func runCharacterCommand(args []string) error {
+	switch {
+	case len(args) == 2 && args[0] == "deleted":
+		guildcard, err := strconv.ParseUint(args[1], 10, 32)
+		if err != nil {
+			return errors.New("invalid guildcard " + args[1])
+		}
+		return listDeletedCharacters(uint32(guildcard))
+	case (len(args) == 3 || len(args) == 4) && args[0] == "restore":
+		return restoreDeletedCharacter(args[1:])
+	case len(args) > 0 && (args[0] == "export" || args[0] == "import"):
+		return runCharacterExportCommand(args)
+	}
+	return errors.New("usage: character deleted <guildcard> | restore <guildcard> <id> [slot] | " +
+		"export <username> <slot> [file] | import <username> <slot> <file>")
+}
+
+func listDeletedCharacters(guildcard uint32) error {
+	deleted, err := database.FindDeletedCharacters(guildcard)
+	if err != nil {
+		return err
+	}
+	if len(deleted) == 0 {
+		fmt.Printf("No deleted characters for guildcard %d\n", guildcard)
+	}
+	for _, d := range deleted {
+		name, level := "(missing)", uint32(0)
+		if d.Character != nil {
+			name, level = characterName(d.Character), d.Character.Level+1
+		}
+		fmt.Printf("Id %d: %s (level %d) from slot %d, deleted %s\n", d.Id, name, level,
+			d.Slot, d.DeletedAt.Local().Format(time.RFC1123))
+	}
+	return nil
+}
+
+// Restore a deleted character given as a guildcard and id, where id is one
+// listed by "character deleted". The character goes back to its original slot
+// unless another slot is given after the id.
+func restoreDeletedCharacter(args []string) error {
+	values := make([]uint32, len(args))
+	for i, part := range args {
+		v, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return errors.New("invalid number " + part)
+		}
+		values[i] = uint32(v)
+	}
+	guildcard, id := values[0], values[1]
+
+	var slot uint32
+	if len(values) == 3 {
+		slot = values[2]
+		if slot >= NumCharacterSlots {
+			return fmt.Errorf("slot must be less than %d", NumCharacterSlots)
+		}
+	} else {
+		deleted, err := database.FindDeletedCharacters(guildcard)
+		if err != nil {
+			return err
+		}
+		slot = data.FirstDeletedSlot
+		for _, d := range deleted {
+			if d.Id == id {
+				slot = d.Slot
+			}
+		}
+		if slot == data.FirstDeletedSlot {
+			return data.ErrCharacterNotFound
+		}
+	}
+	if err := database.RestoreCharacter(guildcard, id, slot); err != nil {
+		return err
+	}
+	cacheDelete(previewCacheKey(guildcard, slot))
+	fmt.Printf("Restored character %d to slot %d for guildcard %d\n", id, slot, guildcard)
+	return nil
+}
@@ -0,0 +1,356 @@
+/*
+* Enemy layouts of the maps, loaded by the ship server from the client's layout
+* files (map_forest01_00e.dat, etc.) in the map directory, so that the server
+* knows which enemies are in a game rather than relying on what the clients
+* say. Games are always sent 0 for each floor's variation, so only the files
+* for that variation are needed; solo mode games use the offline versions of the
+* files (map_forest01_00_offe.dat), if they're there.
+*
+* Enemies are numbered in the order that they appear in the files, floor by
+* floor, and most enemies take more than one number, e.g. a Monest is followed
+* by its 30 Mothmants. The regular enemies of each episode are understood, but
+* most of the bosses (and Episode 2's Recoboxes, which spawn a varying number of
+* Recons) aren't yet. A layout is used up to the first enemy that isn't (or the
+* first missing file), since the numbers of the enemies after it can't be known,
+* and games fall back to trusting the clients from there on. Episode 2's Seaside
+* at night and Control Tower are only played in quests, so they have no files.
+*
+* With the layout, the drop for an enemy is rolled for the kind of enemy that's
+* really there, and enemies that don't have a rare version give experience as
+* soon as they're killed instead of once someone asks for their drop. Games
+* playing a quest use the quest's own maps, so the layouts aren't used once one
+* is started, or in battle or challenge mode.
+ */
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Size of each enemy in a layout file.
+const mapEnemySize = 0x48
+
+// Layout files for each floor of an episode, for variation 0. Pioneer 2 doesn't
+// have any enemies.
+var mapFiles = map[uint8][]string{
+	1: {
+		"",
+		"map_forest01_00e.dat",
+		"map_forest02_00e.dat",
+		"map_cave01_00_00e.dat",
+		"map_cave02_00_00e.dat",
+		"map_cave03_00_00e.dat",
+		"map_machine01_00_00e.dat",
+		"map_machine02_00_00e.dat",
+		"map_ancient01_00_00e.dat",
+		"map_ancient02_00_00e.dat",
+		"map_ancient03_00_00e.dat",
+		"map_boss01e.dat",
+		"map_boss02e.dat",
+		"map_boss03e.dat",
+		"map_boss04e.dat",
+	},
+	2: {
+		"",
+		"map_ruins01_00_00e.dat",
+		"map_ruins02_00_00e.dat",
+		"map_space01_00_00e.dat",
+		"map_space02_00_00e.dat",
+		"map_jungle01_00e.dat",
+		"map_jungle02_00e.dat",
+		"map_jungle03_00e.dat",
+		"map_jungle04_00_00e.dat",
+		"map_jungle05_00e.dat",
+		"map_seabed01_00_00e.dat",
+		"map_seabed02_00_00e.dat",
+		"map_boss05e.dat",
+		"map_boss06e.dat",
+		"map_boss07e.dat",
+		"map_boss08e.dat",
+	},
+	// Episode 4, which the client calls episode 3.
+	3: {
+		"",
+		"map_wilds01_00_00e.dat",
+		"map_wilds01_01_00e.dat",
+		"map_wilds01_02_00e.dat",
+		"map_wilds01_03_00e.dat",
+		"map_crater01_00_00e.dat",
+		"map_desert01_00_00e.dat",
+		"map_desert02_00_00e.dat",
+		"map_desert03_00_00e.dat",
+		"map_boss09_00_00e.dat",
+	},
+}
+
+// A kind of enemy as it appears in a layout file.
+type mapEnemyKind struct {
+	// Indices in the rare tables (as in the drop requests and the experience
+	// file) of the enemies it could be; the usual one first, then its rare or
+	// alternate versions. Empty if it doesn't have one.
+	types []uint8
+	// Numbers taken by the enemies that come with it, e.g. Mothmants for a
+	// Monest, each with its own types.
+	followers [][]uint8
+	// Copies to make when the file doesn't give a number of them.
+	defaultClones int
+}
+
+// Kinds of enemies in Episode 1 by the type in the layout file.
+var episode1Enemies = map[uint16]mapEnemyKind{
+	0x40: {types: []uint8{1, 2}},                                 // Hildebear, Hildeblue
+	0x41: {types: []uint8{5, 6}},                                 // Rag Rappy, Al Rappy
+	0x42: {types: []uint8{4}, followers: repeatTypes(30, 3)},     // Monest, Mothmants
+	0x43: {types: []uint8{7, 8}},                                 // Savage Wolf, Barbarous Wolf
+	0x44: {types: []uint8{9, 10, 11}},                            // Booma, Gobooma, Gigobooma
+	0x60: {types: []uint8{12}},                                   // Grass Assassin
+	0x61: {types: []uint8{13, 14}},                               // Poison Lily, Nar Lily
+	0x62: {types: []uint8{15}},                                   // Nano Dragon
+	0x63: {types: []uint8{16, 17, 18}},                           // Evil Shark, Pal Shark, Guil Shark
+	0x64: {types: []uint8{19, 20}, defaultClones: 4},             // Pofuilly Slime, Pouilly Slime
+	0x65: {types: []uint8{21}, followers: [][]uint8{{22}, {23}}}, // Pan Arms, Migium, Hidoom
+	0x80: {types: []uint8{24, 50}},                               // Dubchic, Gilchic
+	0x81: {types: []uint8{25}},                                   // Garanz
+	0x82: {types: []uint8{26, 27}, defaultClones: 4},             // Sinow Beat, Sinow Gold
+	0x83: {types: []uint8{28}},                                   // Canadine
+	0x84: {types: []uint8{29}, followers: repeatTypes(8, 28)},    // Canane, Canadines
+	0x85: {},                                                     // Dubwitch
+	0xA0: {types: []uint8{30}},                                   // Delsaber
+	0xA1: {types: []uint8{31}, followers: [][]uint8{{32}, {33}}}, // Chaos Sorcerer, Bee R, Bee L
+	0xA2: {types: []uint8{34}},                                   // Dark Gunner
+	0xA3: {types: []uint8{35}},                                   // Death Gunner
+	0xA4: {types: []uint8{36}},                                   // Chaos Bringer
+	0xA5: {types: []uint8{37}},                                   // Dark Belra
+	0xA6: {types: []uint8{41, 42, 43}},                           // Dimenian, La Dimenian, So Dimenian
+	0xA7: {types: []uint8{40}, followers: repeatTypes(4, 38)},    // Bulclaw, Claws
+	0xA8: {types: []uint8{38}},                                   // Claw
+	0xC0: {types: []uint8{44}},                                   // Dragon
+}
+
+// Kinds of enemies in Episode 2 by the type in the layout file. The areas based
+// on Episode 1's have the same enemies, except that the Rappies and Lilies have
+// Episode 2's rare versions (including the Rappies that only appear during
+// events).
+var episode2Enemies = map[uint16]mapEnemyKind{
+	0x40: {types: []uint8{1, 2}},                                 // Hildebear, Hildeblue
+	0x41: {types: []uint8{5, 51, 79, 80, 81}},                    // Rag Rappy, Love, Saint, Hallo, and Egg Rappy
+	0x42: {types: []uint8{4}, followers: repeatTypes(30, 3)},     // Monest, Mothmants
+	0x43: {types: []uint8{7, 8}},                                 // Savage Wolf, Barbarous Wolf
+	0x44: {types: []uint8{9, 10, 11}},                            // Booma, Gobooma, Gigobooma
+	0x60: {types: []uint8{12}},                                   // Grass Assassin
+	0x61: {types: []uint8{13, 14, 83}},                           // Poison Lily, Nar Lily, Del Lily
+	0x62: {types: []uint8{15}},                                   // Nano Dragon
+	0x63: {types: []uint8{16, 17, 18}},                           // Evil Shark, Pal Shark, Guil Shark
+	0x64: {types: []uint8{19, 20}, defaultClones: 4},             // Pofuilly Slime, Pouilly Slime
+	0x65: {types: []uint8{21}, followers: [][]uint8{{22}, {23}}}, // Pan Arms, Migium, Hidoom
+	0x80: {types: []uint8{24, 50}},                               // Dubchic, Gilchic
+	0x81: {types: []uint8{25}},                                   // Garanz
+	0x82: {types: []uint8{26, 27}, defaultClones: 4},             // Sinow Beat, Sinow Gold
+	0x83: {types: []uint8{28}},                                   // Canadine
+	0x84: {types: []uint8{29}, followers: repeatTypes(8, 28)},    // Canane, Canadines
+	0x85: {},                                                     // Dubwitch
+	0xA0: {types: []uint8{30}},                                   // Delsaber
+	0xA1: {types: []uint8{31}, followers: [][]uint8{{32}, {33}}}, // Chaos Sorcerer, Bee R, Bee L
+	0xA2: {types: []uint8{34}},                                   // Dark Gunner
+	0xA3: {types: []uint8{35}},                                   // Death Gunner
+	0xA4: {types: []uint8{36}},                                   // Chaos Bringer
+	0xA5: {types: []uint8{37}},                                   // Dark Belra
+	0xA6: {types: []uint8{41, 42, 43}},                           // Dimenian, La Dimenian, So Dimenian
+	0xA7: {types: []uint8{40}, followers: repeatTypes(4, 38)},    // Bulclaw, Claws
+	0xA8: {types: []uint8{38}},                                   // Claw
+	0xD4: {types: []uint8{62, 63}, defaultClones: 4},             // Sinow Berill, Sinow Spigell
+	0xD5: {types: []uint8{52, 53}},                               // Merillia, Meriltas
+	0xD6: {types: []uint8{56, 57, 58}},                           // Mericarol, Merikle, Mericus
+	0xD7: {types: []uint8{59, 60}},                               // Ul Gibbon, Zol Gibbon
+	0xD8: {types: []uint8{61}},                                   // Gibbles
+	0xD9: {types: []uint8{54}},                                   // Gee
+	0xDA: {types: []uint8{55}},                                   // Gi Gue
+	0xDB: {types: []uint8{71}},                                   // Deldepth
+	0xDC: {types: []uint8{72}},                                   // Delbiter
+	0xDD: {types: []uint8{64, 65}},                               // Dolmolm, Dolmdarl
+	0xDE: {types: []uint8{66}},                                   // Morfos
+	0xE0: {types: []uint8{69, 70}},                               // Sinow Zoa, Sinow Zele
+	0xE1: {types: []uint8{82}},                                   // Ill Gill
+}
+
+// Kinds of enemies in Episode 4 by the type in the layout file. Episode 4 has its
+// own numbering in the rare tables.
+var episode4Enemies = map[uint16]mapEnemyKind{
+	0x41:  {types: []uint8{17, 18}},     // Sand Rappy, Del Rappy
+	0x110: {types: []uint8{1}},          // Astark
+	0x111: {types: []uint8{2, 3}},       // Satellite Lizard, Yowie
+	0x112: {types: []uint8{4, 5}},       // Merissa A, Merissa AA
+	0x113: {types: []uint8{6}},          // Girtablulu
+	0x114: {types: []uint8{7, 8}},       // Zu, Pazuzu
+	0x115: {types: []uint8{9, 10, 11}},  // Boota, Ze Boota, Ba Boota
+	0x116: {types: []uint8{12, 13}},     // Dorphon, Dorphon Eclair
+	0x117: {types: []uint8{14, 15, 16}}, // Goran, Pyro Goran, Goran Detonator
+}
+
+// Kinds of enemies understood in each episode, by the episode number that the
+// client uses.
+var mapEnemyKinds = map[uint8]map[uint16]mapEnemyKind{
+	1: episode1Enemies,
+	2: episode2Enemies,
+	3: episode4Enemies,
+}
+
+func repeatTypes(n int, enemyType uint8) [][]uint8 {
+	types := make([][]uint8, n)
+	for i := range types {
+		types[i] = []uint8{enemyType}
+	}
+	return types
+}
+
+// One of the numbered enemies in a layout.
+type mapEnemy struct {
+	floor uint8
+	// Shared with the mapEnemyKind it came from.
+	types []uint8
+}
+
+// Returns whether the enemy could be of the type from the rare tables.
+func (e *mapEnemy) couldBe(enemyType uint8) bool {
+	for _, t := range e.types {
+		if t == enemyType {
+			return true
+		}
+	}
+	return false
+}
+
+// The enemies in a game, indexed by their numbers.
+type mapLayout struct {
+	enemies []mapEnemy
+}
+
+// Returns the number of an enemy without the 0x1000 that the client sometimes
+// adds to it.
+func enemyNumber(id uint16) uint16 {
+	return id & 0x0FFF
+}
+
+// Returns the enemy with a number (with or without the 0x1000 that the client
+// sometimes adds to it), or nil if the layout doesn't go that far.
+func (l *mapLayout) Enemy(id uint16) *mapEnemy {
+	id = enemyNumber(id)
+	if int(id) >= len(l.enemies) {
+		return nil
+	}
+	return &l.enemies[id]
+}
+
+type mapLayoutKey struct {
+	episode uint8
+	solo    bool
+}
+
+// Synchronized set of the layouts loaded from the map directory.
+type mapLayoutList struct {
+	layouts map[mapLayoutKey]*mapLayout
+	sync.RWMutex
+}
+
+var mapLayouts = &mapLayoutList{layouts: make(map[mapLayoutKey]*mapLayout)}
+
+// Load the layouts in dir, replacing any that were loaded before, and return the
+// number loaded. Nothing is replaced if any of the files are invalid.
+func (ml *mapLayoutList) Load(dir string) (int, error) {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		log.Warnf("Map directory %s doesn't exist; enemies will be identified by the clients", dir)
+		return 0, nil
+	}
+	layouts := make(map[mapLayoutKey]*mapLayout)
+	for episode, files := range mapFiles {
+		if !episodeAvailable(episode) {
+			continue
+		}
+		for _, solo := range []bool{false, true} {
+			layout, err := loadMapLayout(dir, episode, files, solo)
+			if err != nil {
+				return 0, err
+			} else if layout != nil {
+				layouts[mapLayoutKey{episode, solo}] = layout
+			}
+		}
+	}
+
+	ml.Lock()
+	ml.layouts = layouts
+	ml.Unlock()
+	return len(layouts), nil
+}
+
+// Find returns the layout for a game or nil if there isn't one.
+func (ml *mapLayoutList) Find(g *Game) *mapLayout {
+	if g.battleMode != 0 || g.challengeMode != 0 {
+		return nil
+	}
+	ml.RLock()
+	defer ml.RUnlock()
+	return ml.layouts[mapLayoutKey{g.episode, g.soloMode != 0}]
+}
+
+// Read the layout files for each floor of an episode, stopping at the first one
+// that's missing or has an enemy that isn't understood. Returns nil if there's
+// nothing to use.
+func loadMapLayout(dir string, episode uint8, files []string, solo bool) (*mapLayout, error) {
+	layout := new(mapLayout)
+	for floor, name := range files {
+		if name == "" {
+			continue
+		} else if solo {
+			name = strings.TrimSuffix(name, "e.dat") + "_offe.dat"
+		}
+		path := filepath.Join(dir, name)
+		contents, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			if len(layout.enemies) > 0 {
+				log.Warnf("Map %s doesn't exist; enemies from floor %d on will be identified by the clients", path, floor)
+			}
+			break
+		} else if err != nil {
+			return nil, err
+		} else if len(contents)%mapEnemySize != 0 {
+			return nil, fmt.Errorf("invalid %s: size isn't a multiple of %d", path, mapEnemySize)
+		}
+		if !layout.add(contents, uint8(floor), mapEnemyKinds[episode]) {
+			log.Warnf("Map %s has enemies that aren't understood; enemies from there on will be identified by the clients", path)
+			break
+		}
+	}
+	if len(layout.enemies) == 0 {
+		return nil, nil
+	}
+	return layout, nil
+}
+
+// Number the enemies in a layout file, returning false if it has one that isn't
+// in kinds, in which case the enemies before it are still added.
+func (l *mapLayout) add(contents []byte, floor uint8, kinds map[uint16]mapEnemyKind) bool {
+	for offset := 0; offset < len(contents); offset += mapEnemySize {
+		entry := contents[offset : offset+mapEnemySize]
+		kind, ok := kinds[binary.LittleEndian.Uint16(entry)]
+		if !ok {
+			return false
+		}
+		clones := int(binary.LittleEndian.Uint16(entry[0x06:]))
+		if clones == 0 {
+			clones = kind.defaultClones
+		}
+		for i := 0; i <= clones; i++ {
+			l.enemies = append(l.enemies, mapEnemy{floor: floor, types: kind.types})
+			for _, types := range kind.followers {
+				l.enemies = append(l.enemies, mapEnemy{floor: floor, types: types})
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,116 @@
+/*
+* Chat mutes. GMs mute players for a while with /mute (as does the chat filter's
+* mute rule), after which nothing the player says in chat or team chat is sent on
+* until the mute runs out. A shadow mute shows the player their own messages as
+* though they'd been sent so that they don't notice. Mutes are saved with the
+* account, so reconnecting doesn't lift them.
+ */
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Returns when the player's mute ends (in the past if they aren't muted) and
+// whether it's a shadow mute.
+func (c *Client) muteState() (time.Time, bool) {
+	c.muteLock.Lock()
+	defer c.muteLock.Unlock()
+	return c.mutedUntil, c.shadowMuted
+}
+
+// Mute the account of the player on c for d, or unmute it if d is 0.
+func mutePlayer(c *Client, d time.Duration, shadow bool) error {
+	var until time.Time
+	if d > 0 {
+		until = time.Now().Add(d)
+	} else {
+		shadow = false
+	}
+	if err := database.UpdateMute(c.username, until, shadow); err != nil {
+		return err
+	}
+	c.muteLock.Lock()
+	c.mutedUntil, c.shadowMuted = until, shadow
+	c.muteLock.Unlock()
+	return nil
+}
+
+// Hold back a chat message of a type from the player if they're muted, returning
+// true if it was held back.
+func holdMutedChat(c *Client, pktType uint16, message []byte) bool {
+	until, shadow := c.muteState()
+	remaining := time.Until(until)
+	if remaining <= 0 {
+		return false
+	}
+	if shadow {
+		EncryptAndSend(c, newChatPacket(c, pktType, message))
+	} else {
+		SendClientMessage(c, fmt.Sprintf("You can't chat for another %d minutes.", int(remaining/time.Minute)+1))
+	}
+	return true
+}
+
+// Find the online player that a GM's command is aimed at by guildcard. GMs can
+// only use it on players with lower privileges than their own.
+func findCommandTarget(c *Client, arg string) (*Client, error) {
+	guildcard, err := strconv.ParseUint(arg, 10, 32)
+	if err != nil {
+		return nil, errors.New("Invalid guildcard " + arg + ".")
+	}
+	target := players.Find(uint32(guildcard))
+	if target == nil {
+		return nil, fmt.Errorf("Guildcard %d isn't online.", guildcard)
+	} else if target.privilegeLevel >= c.privilegeLevel {
+		return nil, fmt.Errorf("You can't do that to guildcard %d.", guildcard)
+	}
+	return target, nil
+}
+
+// Mute a player on the ship for a while, optionally without them knowing.
+func runMuteCommand(c *Client, args string) error {
+	fields := strings.Fields(args)
+	if len(fields) < 2 || len(fields) > 3 || (len(fields) == 3 && fields[2] != "shadow") {
+		return errors.New("Usage: /mute <guildcard> <duration> [shadow]")
+	}
+	d, err := parseBanDuration(fields[1])
+	if err != nil || d == 0 {
+		return errors.New("Mutes need a duration such as 30m, 12h, or 7d.")
+	}
+	target, err := findCommandTarget(c, fields[0])
+	if err != nil {
+		return err
+	}
+	shadow := len(fields) == 3
+	if err := mutePlayer(target, d, shadow); err != nil {
+		c.log.Errorf("Failed to mute guildcard %d: %s", target.guildcard, err.Error())
+		return errors.New("Failed to save the mute.")
+	}
+	target.log.Infof("Muted for %s by guildcard %d (shadow: %v)", d, c.guildcard, shadow)
+	if !shadow {
+		SendClientMessage(target, "You've been muted for "+fields[1]+".")
+	}
+	return SendClientMessage(c, fmt.Sprintf("Muted guildcard %d for %s.", target.guildcard, fields[1]))
+}
+
+// Lift a player's mute.
+func runUnmuteCommand(c *Client, args string) error {
+	if args == "" {
+		return errors.New("Usage: /unmute <guildcard>")
+	}
+	target, err := findCommandTarget(c, args)
+	if err != nil {
+		return err
+	}
+	if err := mutePlayer(target, 0, false); err != nil {
+		c.log.Errorf("Failed to unmute guildcard %d: %s", target.guildcard, err.Error())
+		return errors.New("Failed to save the mute.")
+	}
+	target.log.Infof("Unmuted by guildcard %d", c.guildcard)
+	return SendClientMessage(c, fmt.Sprintf("Unmuted guildcard %d.", target.guildcard))
+}
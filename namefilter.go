@@ -0,0 +1,147 @@
+/*
+* Filter for the names that players give their characters and teams, checked by
+* the character server when a character is created or renamed and by the block
+* servers when a team is created. The lists are read from the name filter file,
+* which is reloaded along with the config:
+*
+*	{
+*		"banned_words": ["darn", "heck"],
+*		"reserved_names": ["GM", "Admin", "Sonic Team"]
+*	}
+*
+* Names that contain one of the banned words anywhere in them, or that are one of
+* the reserved names, are refused. Both the names and the lists are normalized
+* before they're compared: the language marker that the client puts in front of
+* names is dropped, compatibility forms (such as full-width letters and digits)
+* are folded by NFKC, accents are stripped, case is folded, and spaces,
+* punctuation, and symbols are left out, so "G.M.", "ＧＭ", and "Ǵḿ" are all
+* taken to be "gm".
+ */
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf16"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+type nameFilterFile struct {
+	BannedWords   []string `json:"banned_words"`
+	ReservedNames []string `json:"reserved_names"`
+}
+
+// Reasons that names are refused.
+var (
+	errNameBanned   = errors.New("contains a word that isn't allowed")
+	errNameReserved = errors.New("is reserved")
+)
+
+// Synchronized lists of the normalized words and names from the file.
+type nameFilterList struct {
+	bannedWords   []string
+	reservedNames map[string]bool
+	sync.RWMutex
+}
+
+var nameFilter = &nameFilterList{reservedNames: make(map[string]bool)}
+
+// Load the lists from the file at path, replacing the ones loaded before. Names
+// aren't filtered if the file doesn't exist.
+func (nf *nameFilterList) Load(path string) error {
+	file := new(nameFilterFile)
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		log.Warnf("Name filter file %s doesn't exist; names won't be filtered", path)
+	} else if err != nil {
+		return err
+	} else {
+		decoder := json.NewDecoder(bytes.NewReader(contents))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(file); err != nil {
+			return fmt.Errorf("invalid %s: %s", path, err.Error())
+		}
+	}
+
+	var bannedWords []string
+	reservedNames := make(map[string]bool)
+	for _, word := range file.BannedWords {
+		normalized := normalizeName(word)
+		if normalized == "" {
+			return fmt.Errorf("invalid %s: banned word %q has no letters or digits", path, word)
+		}
+		bannedWords = append(bannedWords, normalized)
+	}
+	for _, name := range file.ReservedNames {
+		normalized := normalizeName(name)
+		if normalized == "" {
+			return fmt.Errorf("invalid %s: reserved name %q has no letters or digits", path, name)
+		}
+		reservedNames[normalized] = true
+	}
+
+	nf.Lock()
+	nf.bannedWords = bannedWords
+	nf.reservedNames = reservedNames
+	nf.Unlock()
+	return nil
+}
+
+// Check returns an error saying why a name isn't allowed, or nil if it is.
+func (nf *nameFilterList) Check(name string) error {
+	normalized := normalizeName(name)
+	nf.RLock()
+	defer nf.RUnlock()
+	if nf.reservedNames[normalized] {
+		return errNameReserved
+	}
+	for _, word := range nf.bannedWords {
+		if strings.Contains(normalized, word) {
+			return errNameBanned
+		}
+	}
+	return nil
+}
+
+// CheckUtf16 checks a name from one of the client's null padded UTF-16 fields.
+func (nf *nameFilterList) CheckUtf16(name []uint16) error {
+	return nf.Check(string(utf16.Decode(trimUtf16(name))))
+}
+
+// Reduce a name to the case folded letters and digits that it's compared by.
+func normalizeName(name string) string {
+	if len(name) >= 2 && name[0] == '\t' {
+		name = name[2:]
+	}
+	// Decompose so that accents become separate marks that can be dropped, then
+	// recompose with the compatibility mappings that fold full-width and other
+	// variant forms. Transformers keep state, so they're made for each call.
+	strip := transform.Chain(norm.NFKD, runes.Remove(runes.In(unicode.Mn)), norm.NFKC, cases.Fold())
+	folded, _, err := transform.String(strip, name)
+	if err != nil {
+		folded = name
+	}
+	var b strings.Builder
+	for _, r := range folded {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// Returns the message shown to a player whose name was refused.
+func nameRefusedMessage(kind string, err error) string {
+	return fmt.Sprintf("That %s name %s. Please choose another.", kind, err.Error())
+}
@@ -0,0 +1,302 @@
+/*
+* Registry of the players online on every ship, so that mail, guildcard searches,
+* and GM broadcasts can reach players on other ships. Each ship reports the
+* players logging on to and off of its blocks to the shipgate, which relays the
+* reports to the rest of the ships; every ship ends up with the same view of who
+* is online where. Ships that register with the shipgate are sent everything it
+* knows, and the players on a ship are dropped once the ship goes away. Ships
+* that share the registry through Redis (see presence.go) skip all of this and
+* only record their own players.
+*
+* Packets for a player on another ship are routed through the shipgate, which
+* passes them on to the ship that the player is on.
+ */
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/dcrodman/archon/data"
+	"github.com/dcrodman/archon/packets"
+	"github.com/dcrodman/archon/util"
+)
+
+// Players who are online on all of the ships (see presence.go). Replaced with
+// the configured store when the shipgate starts.
+var onlinePlayers presenceStore = newMemoryPresence()
+
+// Returns the connection to the remote shipgate, or nil if this ship isn't
+// registered with one.
+func currentShipgateLink() *Client {
+	shipgateLinkLock.RLock()
+	defer shipgateLinkLock.RUnlock()
+	return shipgateLink
+}
+
+// Let the other ships know that the player on c logged on to one of our blocks,
+// or off of it if block is 0.
+func reportPlayer(c *Client, block uint16) {
+	pkt := ShipgatePlayerPacket{
+		Header:    ShipgateHeader{Type: ShipgatePlayerType},
+		Guildcard: c.guildcard,
+		ShipId:    localShip.id,
+		Block:     uint32(block),
+		ShipName:  localShip.name,
+		IPAddr:    localShip.ipAddr,
+		Port:      localShip.port + block,
+		Since:     time.Now().Unix(),
+	}
+	if c.lobby != nil {
+		pkt.Lobby = uint16(c.lobby.id) + 1
+	}
+	if c.character != nil {
+		copyUtf16(pkt.Name[:], c.character.Name)
+	}
+	if p, ok := onlinePlayers.Find(c.guildcard); ok && p.onShip(localShip) {
+		pkt.Since = p.Since
+	}
+	publishPlayer(pkt)
+}
+
+// Record a report about a player on this ship and pass it on to the others.
+func publishPlayer(pkt ShipgatePlayerPacket) {
+	onlinePlayers.Update(onlinePlayer{pkt})
+	relayPlayer(pkt, localShip)
+	if link := currentShipgateLink(); link != nil {
+		if err := EncryptAndSend(link, &pkt); err != nil {
+			log.Warn(err.Error())
+		}
+	}
+}
+
+// Pass a report about a player on to every ship registered with us other than
+// the one it came from.
+func relayPlayer(pkt ShipgatePlayerPacket, from *Ship) {
+	for _, ship := range ships.List() {
+		if ship == from || ship.client == nil {
+			continue
+		}
+		if err := EncryptAndSend(ship.client, &pkt); err != nil {
+			log.Warn(err.Error())
+		}
+	}
+}
+
+// A ship told us about a player logging on or off. Reports from ships registered
+// with us are relayed to the others, with the id that we know the ship by.
+func handleShipPlayer(c *Client) error {
+	var pkt ShipgatePlayerPacket
+	if err := c.Decode(&pkt); err != nil {
+		return err
+	}
+	if c.ship != nil {
+		pkt.ShipId = c.ship.id
+		relayPlayer(pkt, c.ship)
+	}
+	if !onlinePlayers.Shared() {
+		onlinePlayers.Update(onlinePlayer{pkt})
+	}
+	return nil
+}
+
+// Send a ship that just registered everyone we know to be online, or just the
+// players on this ship.
+func sendOnlinePlayers(c *Client, localOnly bool) error {
+	for _, p := range onlinePlayers.List() {
+		if localOnly && !p.onShip(localShip) {
+			continue
+		}
+		if err := EncryptAndSend(c, &p.ShipgatePlayerPacket); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Forget the players on a ship that went away and tell the other ships that
+// they've logged off. A shared registry is left alone since the ship may still
+// be up; its players expire if it isn't.
+func dropShipPlayers(ship *Ship) {
+	if onlinePlayers.Shared() {
+		return
+	}
+	for _, p := range onlinePlayers.Remove(func(p onlinePlayer) bool { return p.onShip(ship) }) {
+		pkt := p.ShipgatePlayerPacket
+		pkt.Port -= uint16(pkt.Block)
+		pkt.Block = 0
+		relayPlayer(pkt, ship)
+	}
+}
+
+// Forget the players on the other ships once we've lost the shipgate. They're
+// reported again once we're reconnected.
+func forgetRemotePlayers() {
+	if !onlinePlayers.Shared() {
+		onlinePlayers.Remove(func(p onlinePlayer) bool { return !p.onShip(localShip) })
+	}
+}
+
+// Deal with whatever the registry says the player logging in on c left behind.
+// Another ship is asked to disconnect them (see handleShipKick), while an entry
+// for this ship that has no client behind it is cleared.
+func clearGhostPresence(c *Client) {
+	p, ok := onlinePlayers.Find(c.guildcard)
+	if !ok {
+		return
+	}
+	if !p.onShip(localShip) {
+		pkt := &ShipgateKickPacket{
+			Header:    ShipgateHeader{Type: ShipgateKickType},
+			Guildcard: c.guildcard,
+		}
+		copy(pkt.IPAddr[:], net.ParseIP(c.IPAddr()).To4())
+		sendToPlayerShip(p, pkt)
+	} else if players.Find(c.guildcard) == nil {
+		c.log.Infof("Clearing stale presence for guildcard %d", c.guildcard)
+		pkt := p.ShipgatePlayerPacket
+		pkt.Port -= uint16(pkt.Block)
+		pkt.Block = 0
+		publishPlayer(pkt)
+	}
+}
+
+// Another ship is asking us to disconnect a player who has logged in over there.
+// It's passed on if the player is on another ship registered with us.
+func handleShipKick(c *Client) error {
+	var pkt ShipgateKickPacket
+	if err := c.Decode(&pkt); err != nil {
+		return err
+	}
+	if p, ok := onlinePlayers.Find(pkt.Guildcard); ok && !p.onShip(localShip) {
+		for _, ship := range ships.List() {
+			if ship.client != nil && ship.client != c && p.onShip(ship) {
+				return EncryptAndSend(ship.client, &pkt)
+			}
+		}
+		return nil
+	}
+	takeOverGhosts(pkt.Guildcard, net.IP(pkt.IPAddr[:]).String())
+	return nil
+}
+
+// Send a packet towards the ship that a player is on: straight to it if it's
+// registered with us, or otherwise to the shipgate to pass on. Returns false if
+// there's no way to reach it.
+func sendToPlayerShip(p onlinePlayer, pkt interface{}) bool {
+	c := currentShipgateLink()
+	for _, ship := range ships.List() {
+		if ship.client != nil && p.onShip(ship) {
+			c = ship.client
+		}
+	}
+	if c == nil {
+		return false
+	}
+	if err := EncryptAndSend(c, pkt); err != nil {
+		log.Warn(err.Error())
+		return false
+	}
+	return true
+}
+
+// Send mail to the ship that its recipient is on, returning false if they aren't
+// online on another ship.
+func routeShipMail(mail *data.Mail) bool {
+	p, ok := onlinePlayers.Find(mail.Recipient)
+	if !ok || p.onShip(localShip) {
+		return false
+	}
+	pkt := &ShipgateMailPacket{
+		Header:    ShipgateHeader{Type: ShipgateMailType},
+		Sender:    mail.Sender,
+		Recipient: mail.Recipient,
+		SentAt:    mail.SentAt.Unix(),
+	}
+	copy(pkt.SenderName[:], mail.SenderName)
+	copy(pkt.Message[:], mail.Message)
+	return sendToPlayerShip(p, pkt)
+}
+
+// Mail arrived from another ship. It's delivered if the recipient is here, passed
+// on if they're on another ship registered with us, and otherwise kept until they
+// next log on.
+func handleShipMail(c *Client) error {
+	var pkt ShipgateMailPacket
+	if err := c.Decode(&pkt); err != nil {
+		return err
+	}
+	mail := &data.Mail{
+		Recipient:  pkt.Recipient,
+		Sender:     pkt.Sender,
+		SenderName: trimUtf16(pkt.SenderName[:]),
+		SentAt:     time.Unix(pkt.SentAt, 0),
+		Message:    trimUtf16(pkt.Message[:]),
+	}
+	if recipient := players.Find(mail.Recipient); recipient != nil {
+		if !recipient.blocked.Blocks(mail.Sender) {
+			return sendSimpleMail(recipient, mail)
+		}
+		return nil
+	}
+	if p, ok := onlinePlayers.Find(mail.Recipient); ok {
+		for _, ship := range ships.List() {
+			if ship.client != nil && ship.client != c && p.onShip(ship) {
+				return EncryptAndSend(ship.client, &pkt)
+			}
+		}
+	}
+	if err := database.CreateMail(mail); err != nil {
+		return errors.New("Failed to save mail: " + err.Error())
+	}
+	return nil
+}
+
+// The player is looking for someone by guildcard, wherever they are. The reply
+// says where they are and carries the redirect that the client follows if the
+// player chooses to meet them, along with the lobby to join (see
+// HandleShipLogin).
+func (server *BlockServer) HandleGuildcardSearch(c *Client) error {
+	var pkt packets.GuildcardSearchPacket
+	if err := c.Decode(&pkt); err != nil {
+		return err
+	}
+	p, ok := onlinePlayers.Find(pkt.Target)
+	if !ok {
+		// The client gives up on its own if nobody answers.
+		return nil
+	}
+	if target := players.Find(pkt.Target); target != nil && target.blocked.Blocks(c.guildcard) {
+		return nil
+	}
+	// Players on this ship are reached through whichever address the searcher
+	// was given for the block, which depends on where they're connecting from.
+	ipAddr := p.IPAddr
+	if p.onShip(localShip) {
+		ipAddr = config.RedirectIP(fmt.Sprintf("block%d", p.Block), net.ParseIP(c.IPAddr()))
+	}
+
+	reply := &packets.GuildcardSearchReplyPacket{
+		Header:    packets.BBHeader{Type: packets.GuildcardSearchReplyType},
+		PlayerTag: 0x00010000,
+		Searcher:  c.guildcard,
+		Target:    pkt.Target,
+		Redirect: packets.RedirectPacket{
+			Header: packets.BBHeader{Type: packets.RedirectType, Size: uint16(packetSize(&packets.RedirectPacket{}))},
+			IPAddr: ipAddr,
+			Port:   p.Port,
+		},
+	}
+	location := fmt.Sprintf("BLOCK%02d,%s", p.Block, util.StripPadding(p.ShipName[:]))
+	if p.Lobby > 0 {
+		reply.MenuId = LobbyMenuId
+		reply.LobbyId = uint32(p.Lobby - 1)
+		location = fmt.Sprintf("LOBBY%02d,%s", p.Lobby, location)
+	}
+	copyUtf16(reply.Location[:], util.ConvertToUtf16(location))
+	copy(reply.Name[:], p.Name[:])
+	c.log.Debug("Sending Guildcard Search Reply")
+	return EncryptAndSend(c, reply)
+}
@@ -0,0 +1,210 @@
+// Structures for the character and guildcard data that's sent inside packets.
+package packets
+
+import "github.com/dcrodman/archon/data"
+
+const (
+	// Maximum number of items that can be stored in a bank.
+	MaxBankItems = 200
+	// Maximum number of entries on the friend and blocked lists.
+	MaxGuildcards        = 104
+	MaxBlockedGuildcards = 29
+)
+
+// Per-character stats as stored in config files.
+type CharacterStats struct {
+	ATP uint16
+	MST uint16
+	EVP uint16
+	HP  uint16
+	DFP uint16
+	ATA uint16
+	LCK uint16
+}
+
+// Identifying data for an instance of an item.
+type ItemData struct {
+	Data   [12]byte
+	ItemId uint32
+	Data2  [4]byte
+}
+
+// Item in a player's inventory.
+type InventoryItem struct {
+	Present uint16
+	Unknown uint16
+	Flags   uint32
+	Item    ItemData
+}
+
+// Items carried by a player.
+type Inventory struct {
+	NumItems uint8
+	HPMats   uint8
+	TPMats   uint8
+	Language uint8
+	Items    [30]InventoryItem
+}
+
+// Character stats, appearance, and config that's visible to other players.
+type PlayerDispData struct {
+	Stats          CharacterStats
+	Unknown        uint16
+	Unknown2       [2]float32
+	Level          uint32
+	Experience     uint32
+	Meseta         uint32
+	GuildcardStr   [16]byte
+	Unknown3       [2]uint32
+	NameColor      uint32
+	Model          byte
+	Padding        [15]byte
+	NameColorChksm uint32
+	SectionID      byte
+	Class          byte
+	V2Flags        byte
+	Version        byte
+	V1Flags        uint32
+	Costume        uint16
+	Skin           uint16
+	Face           uint16
+	Head           uint16
+	Hair           uint16
+	HairRed        uint16
+	HairGreen      uint16
+	HairBlue       uint16
+	PropX          float32
+	PropY          float32
+	Name           [16]uint16
+	Playtime       uint32
+	Unknown4       uint32
+	Config         [0xE8]byte
+	Techniques     [0x14]byte
+}
+
+// Struct used by Character Info packet.
+type CharacterPreview struct {
+	Experience     uint32
+	Level          uint32
+	GuildcardStr   [16]byte
+	Unknown        [2]uint32
+	NameColor      uint32
+	Model          byte
+	Padding        [15]byte
+	NameColorChksm uint32
+	SectionID      byte
+	Class          byte
+	V2Flags        byte
+	Version        byte
+	V1Flags        uint32
+	Costume        uint16
+	Skin           uint16
+	Face           uint16
+	Head           uint16
+	Hair           uint16
+	HairRed        uint16
+	HairGreen      uint16
+	HairBlue       uint16
+	PropX          float32
+	PropY          float32
+	Name           [32]uint8
+	Playtime       uint32
+}
+
+// Bank contents as they're stored in the full character data.
+type CharacterBank struct {
+	NumItems uint32
+	Meseta   uint32
+	Items    [MaxBankItems]BankItem
+}
+
+// Battle mode section of the full character data.
+type BattleRecords struct {
+	PlaceCounts      [4]uint16
+	DisconnectCounts [4]uint16
+	Unknown          [2]uint32
+}
+
+// Challenge mode section of the full character data. Times are in seconds.
+type ChallengeRecords struct {
+	TitleColor uint16
+	Unknown    [2]byte
+	// UTF-16 with a language marker in front of it, like the character's name.
+	RankTitle [12]uint16
+	TimesEp1  [data.NumChallengeStagesEp1]uint32
+	TimesEp2  [data.NumChallengeStagesEp2]uint32
+	// Offline (single player) times, which the server doesn't know about.
+	TimesEp1Offline [data.NumChallengeStagesEp1]uint32
+	// Where the player last died in challenge mode, which isn't tracked.
+	Grave    [0x84]byte
+	Unknown2 [0x2C]byte
+	Awards   [2]uint32
+	Unknown3 [0x10]byte
+}
+
+// Complete data for the selected character, based on the full character
+// structures from sylverant and newserv.
+type FullCharacter struct {
+	Inventory        Inventory
+	Character        PlayerDispData
+	Unknown          [0x10]byte
+	OptionFlags      uint32
+	QuestData1       [0x208]byte
+	Bank             CharacterBank
+	Guildcard        uint32
+	Name             [24]uint16
+	TeamName         [16]uint16
+	GuildcardDesc    [88]uint16
+	Reserved1        uint8
+	Reserved2        uint8
+	SectionID        uint8
+	Class            uint8
+	Unknown2         uint32
+	SymbolChats      [0x04E0]byte
+	Shortcuts        [0x0A40]byte
+	AutoReply        [172]uint16
+	InfoBoard        [172]uint16
+	BattleRecords    BattleRecords
+	Unknown3         [4]byte
+	ChallengeRecords ChallengeRecords
+	TechMenu         [0x28]byte
+	Unknown4         [0x2C]byte
+	QuestData2       [0x58]byte
+	KeyConfig        KeyTeamConfig
+}
+
+// Per-player guildcard data chunk.
+type GuildcardData struct {
+	Unknown  [0x114]uint8
+	Blocked  [MaxBlockedGuildcards]GuildcardEntry
+	Unknown2 [0x78]uint8
+	Entries  [MaxGuildcards]GuildcardDataEntry
+	Unknown3 [0x1BC]uint8
+}
+
+// Guildcard details for another player, as sent by the client when it adds them
+// to a list and stored on the blocked list.
+type GuildcardEntry struct {
+	Guildcard   uint32
+	Name        [24]uint16
+	TeamName    [16]uint16
+	Description [88]uint16
+	Reserved    uint8
+	Language    uint8
+	SectionID   uint8
+	CharClass   uint8
+}
+
+// Per-player friend guildcard entries.
+type GuildcardDataEntry struct {
+	Guildcard   uint32
+	Name        [24]uint16
+	TeamName    [16]uint16
+	Description [88]uint16
+	Reserved    uint8
+	Language    uint8
+	SectionID   uint8
+	CharClass   uint8
+	padding     uint32
+	Comment     [88]uint16
+}
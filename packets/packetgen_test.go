@@ -0,0 +1,362 @@
+/*
+* Checks the encodings generated into packets_gen.go (see setup/tools/packetgen.go)
+* against the reflection that util falls back on for every other struct. Each
+* generated type is filled with random values and encoded and decoded both ways;
+* the reflection side sees the same memory through a copy of the type that has
+* no methods, so that util can't find the generated ones, and whose padding
+* fields are exported, so that reflection can set them.
+ */
+package packets
+
+import (
+	"bytes"
+	"encoding"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"unsafe"
+
+	"github.com/dcrodman/archon/util"
+)
+
+// Every type in packets_gen.go.
+var generatedTypes = []interface{}{
+	BBHeader{},
+	BankActionPacket{},
+	BankContentsPacket{},
+	BankItem{},
+	BattleRecords{},
+	Block{},
+	BlockListPacket{},
+	BuyItemPacket{},
+	ChallengeRecords{},
+	ChallengeStageClearPacket{},
+	ChangeDirPacket{},
+	CharAckPacket{},
+	CharPreviewPacket{},
+	CharSelectionPacket{},
+	CharacterBank{},
+	CharacterDataPacket{},
+	CharacterPreview{},
+	CharacterStats{},
+	ChatPacket{},
+	CheckFilePacket{},
+	ChecksumAckPacket{},
+	ChecksumPacket{},
+	ClientConfig{},
+	CreateInventoryItemPacket{},
+	DestroyItemPacket{},
+	DropInventoryItemPacket{},
+	DropItemPacket{},
+	DropRequestPacket{},
+	DropStackPacket{},
+	EnemyKilledPacket{},
+	FileChunkPacket{},
+	FileHeaderPacket{},
+	FileStatusPacket{},
+	FullCharacter{},
+	FullCharacterPacket{},
+	GCVerifyLicensePkt{},
+	GameCommandPacket{},
+	GameCreatePacket{},
+	GameJoinPacket{},
+	GameListPacket{},
+	GameMenuEntry{},
+	GameMenuSelectionPacket{},
+	GiveExperiencePacket{},
+	GuildcardAddPacket{},
+	GuildcardBlockPacket{},
+	GuildcardChunkPacket{},
+	GuildcardChunkReqPacket{},
+	GuildcardCommentPacket{},
+	GuildcardData{},
+	GuildcardDataEntry{},
+	GuildcardEntry{},
+	GuildcardHeaderPacket{},
+	GuildcardRemovePacket{},
+	GuildcardSearchPacket{},
+	GuildcardSearchReplyPacket{},
+	GuildcardUnblockPacket{},
+	IdentifyItemPacket{},
+	IdentifyResultPacket{},
+	Inventory{},
+	InventoryItem{},
+	ItemData{},
+	JoystickConfigUpdatePacket{},
+	KeyConfigUpdatePacket{},
+	KeyTeamConfig{},
+	LegacyClientMessagePkt{},
+	LegacyHeader{},
+	LegacyLoginPkt{},
+	LegacySecurityPkt{},
+	LegacyWelcomePkt{},
+	LevelUpPacket{},
+	LobbyJoinPacket{},
+	LobbyLeavePacket{},
+	LobbyListPacket{},
+	LobbyMenuEntry{},
+	LoginClientMessagePacket{},
+	LoginPkt{},
+	MenuSelectionPacket{},
+	MovePacket{},
+	OptionFlagsUpdatePacket{},
+	OptionsPacket{},
+	PCHeader{},
+	PCLicensePkt{},
+	ParameterChunkPacket{},
+	ParameterHeaderPacket{},
+	PatchRedirectIPv6Packet{},
+	PatchRedirectPacket{},
+	PatchWelcomeMessage{},
+	PatchWelcomePkt{},
+	PickUpItemPacket{},
+	PickUpItemRequestPacket{},
+	PlayerDiedPacket{},
+	PlayerDispData{},
+	PlayerJoinEntry{},
+	PlayerLobbyData{},
+	QuestChunkPacket{},
+	QuestFilePacket{},
+	QuestInfoPacket{},
+	QuestListPacket{},
+	QuestMenuEntry{},
+	RedirectIPv6Packet{},
+	RedirectPacket{},
+	ScrollMessagePacket{},
+	SecurityPacket{},
+	SellItemPacket{},
+	SetFlagPacket{},
+	SetFloorPacket{},
+	SetPositionPacket{},
+	SetQuestFlagPacket{},
+	ShipListPacket{},
+	ShipMenuEntry{},
+	ShopContentsPacket{},
+	ShopRequestPacket{},
+	SimpleMailPacket{},
+	SplitStackPacket{},
+	SubCmdHeader{},
+	SymbolChatPacket{},
+	TeamCreatePacket{},
+	TeamFlagPacket{},
+	TeamInfoPacket{},
+	TeamMemberListEntry{},
+	TeamMemberListPacket{},
+	TeamMemberPacket{},
+	TimestampPacket{},
+	TradeItemsPacket{},
+	UpdateFilesPacket{},
+	WelcomePkt{},
+	WordSelectPacket{},
+}
+
+func TestGeneratedTypesListed(t *testing.T) {
+	file, err := parser.ParseFile(token.NewFileSet(), "packets_gen.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var generated []string
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Recv == nil || fn.Name.Name != "MarshalBinary" {
+			continue
+		}
+		generated = append(generated, fn.Recv.List[0].Type.(*ast.StarExpr).X.(*ast.Ident).Name)
+	}
+	var listed []string
+	for _, v := range generatedTypes {
+		listed = append(listed, reflect.TypeOf(v).Name())
+	}
+	sort.Strings(generated)
+	sort.Strings(listed)
+	if !reflect.DeepEqual(generated, listed) {
+		t.Errorf("packets_gen.go has %v, test lists %v", generated, listed)
+	}
+}
+
+// Returns a type with the same layout as t but no methods or unexported fields,
+// all the way down.
+func withoutMethods(t reflect.Type) reflect.Type {
+	switch t.Kind() {
+	case reflect.Struct:
+		fields := make([]reflect.StructField, t.NumField())
+		for i := range fields {
+			fields[i] = t.Field(i)
+			fields[i].Type = withoutMethods(fields[i].Type)
+			if fields[i].PkgPath != "" {
+				fields[i].Name = strings.ToUpper(fields[i].Name[:1]) + fields[i].Name[1:]
+				fields[i].PkgPath = ""
+			}
+		}
+		return reflect.StructOf(fields)
+	case reflect.Array:
+		return reflect.ArrayOf(t.Len(), withoutMethods(t.Elem()))
+	case reflect.Slice:
+		return reflect.SliceOf(withoutMethods(t.Elem()))
+	case reflect.Ptr:
+		return reflect.PtrTo(withoutMethods(t.Elem()))
+	}
+	return t
+}
+
+// Returns ptr, a pointer to a struct, as a pointer to its type without methods.
+func reflectionView(ptr reflect.Value) interface{} {
+	return reflect.NewAt(withoutMethods(ptr.Type().Elem()), unsafe.Pointer(ptr.Pointer())).Interface()
+}
+
+// Fill in every field of v with random values.
+func fillRandom(v reflect.Value, r *rand.Rand) {
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			fillRandom(v.Field(i), r)
+		}
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			fillRandom(v.Index(i), r)
+		}
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(v.Type(), r.Intn(8), r.Intn(8)+8))
+		for i := 0; i < v.Len(); i++ {
+			fillRandom(v.Index(i), r)
+		}
+	case reflect.Ptr:
+		v.Set(reflect.New(v.Type().Elem()))
+		fillRandom(v.Elem(), r)
+	case reflect.Bool:
+		v.SetBool(r.Intn(2) == 1)
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(r.Uint64())
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(int64(r.Uint64()))
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(r.NormFloat64() * 1000)
+	}
+}
+
+// Returns a new value of the type ptr points to with slices the same length as
+// its, since slices are decoded into whatever room they have, and the same
+// pointers set.
+func newDecodeTarget(ptr reflect.Value) reflect.Value {
+	target := reflect.New(ptr.Type().Elem())
+	sizeLike(reflect.ValueOf(reflectionView(target)).Elem(), reflect.ValueOf(reflectionView(ptr)).Elem())
+	return target
+}
+
+func sizeLike(dst, src reflect.Value) {
+	switch dst.Kind() {
+	case reflect.Struct:
+		for i := 0; i < dst.NumField(); i++ {
+			sizeLike(dst.Field(i), src.Field(i))
+		}
+	case reflect.Array:
+		for i := 0; i < dst.Len(); i++ {
+			sizeLike(dst.Index(i), src.Index(i))
+		}
+	case reflect.Slice:
+		dst.Set(reflect.MakeSlice(dst.Type(), src.Len(), src.Len()))
+		for i := 0; i < dst.Len(); i++ {
+			sizeLike(dst.Index(i), src.Index(i))
+		}
+	case reflect.Ptr:
+		if !src.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+			sizeLike(dst.Elem(), src.Elem())
+		}
+	}
+}
+
+func TestGeneratedEncodings(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, v := range generatedTypes {
+		typ := reflect.TypeOf(v)
+		t.Run(typ.Name(), func(t *testing.T) {
+			var original reflect.Value
+			for i := 0; i < 20; i++ {
+				original = reflect.New(typ)
+				fillRandom(reflect.ValueOf(reflectionView(original)).Elem(), r)
+
+				generated, err := original.Interface().(encoding.BinaryMarshaler).MarshalBinary()
+				if err != nil {
+					t.Fatal(err)
+				}
+				reflected, _ := util.BytesFromStruct(reflectionView(original))
+				if !bytes.Equal(generated, reflected) {
+					t.Fatalf("generated encoding\n%x\ndoesn't match reflection\n%x", generated, reflected)
+				}
+				if appended, _ := original.Interface().(interface {
+					AppendBinary([]byte) ([]byte, error)
+				}).AppendBinary([]byte{0xff}); !bytes.Equal(appended[1:], generated) {
+					t.Fatal("AppendBinary doesn't append the encoding")
+				}
+
+				decoded := newDecodeTarget(original)
+				if err = decoded.Interface().(encoding.BinaryUnmarshaler).UnmarshalBinary(reflected); err != nil {
+					t.Fatal(err)
+				} else if !reflect.DeepEqual(decoded.Interface(), original.Interface()) {
+					t.Fatal("generated decoding doesn't match what was encoded")
+				}
+				decoded = newDecodeTarget(original)
+				if err = util.DecodeStruct(generated, reflectionView(decoded)); err != nil {
+					t.Fatal(err)
+				} else if !reflect.DeepEqual(decoded.Interface(), original.Interface()) {
+					t.Fatal("reflection decoding doesn't match what was encoded")
+				}
+			}
+
+			size := len(util.AppendStruct(nil, reflectionView(original)))
+			if size == 0 {
+				return
+			}
+			short := make([]byte, size-1)
+			generatedErr := newDecodeTarget(original).Interface().(encoding.BinaryUnmarshaler).UnmarshalBinary(short)
+			reflectedErr := util.DecodeStruct(short, reflectionView(newDecodeTarget(original)))
+			if generatedErr == nil || reflectedErr == nil {
+				t.Errorf("decoded data that was too short: generated %v, reflection %v", generatedErr, reflectedErr)
+			}
+		})
+	}
+}
+
+func BenchmarkGeneratedEncodings(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	for _, v := range generatedTypes {
+		typ := reflect.TypeOf(v)
+		value := reflect.New(typ)
+		fillRandom(reflect.ValueOf(reflectionView(value)).Elem(), r)
+		generated, reflected := value.Interface(), reflectionView(value)
+		data := util.AppendStruct(nil, generated)
+
+		b.Run(typ.Name()+"/marshal/generated", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				util.BytesFromStruct(generated)
+			}
+		})
+		b.Run(typ.Name()+"/marshal/reflection", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				util.BytesFromStruct(reflected)
+			}
+		})
+		b.Run(typ.Name()+"/unmarshal/generated", func(b *testing.B) {
+			b.ReportAllocs()
+			target := newDecodeTarget(value).Interface()
+			for i := 0; i < b.N; i++ {
+				util.DecodeStruct(data, target)
+			}
+		})
+		b.Run(typ.Name()+"/unmarshal/reflection", func(b *testing.B) {
+			b.ReportAllocs()
+			target := reflectionView(newDecodeTarget(value))
+			for i := 0; i < b.N; i++ {
+				util.DecodeStruct(data, target)
+			}
+		})
+	}
+}
@@ -0,0 +1,1292 @@
+/*
+* Packet constants and structures. Every struct in the package has generated
+* AppendBinary, MarshalBinary, and UnmarshalBinary methods (see packets_gen.go)
+* that encode it the same way as util.BytesFromStruct, and Unmarshal decodes a
+* Blue Burst packet without reading past the size in its header.
+ */
+package packets
+
+import (
+	"encoding"
+	"encoding/binary"
+	"fmt"
+)
+
+// Run go generate after adding or changing any of the structs in the package.
+//go:generate go run ../setup/tools/packetgen.go -root .. -o packets_gen.go
+
+const (
+	PCHeaderSize = 0x04
+	BBHeaderSize = 0x08
+)
+
+// Limits on the number of entries in the packets with lists of items.
+const (
+	// Maximum number of items a shop can show.
+	MaxShopItems = 20
+	// Maximum number of items (including meseta) that a player can give in a trade.
+	MaxTradeItems = 0x20
+	// Size of the pieces in which the quest files are sent.
+	QuestChunkSize = 0x400
+)
+
+// Packet types handled by the patch and data servers.
+const (
+	PatchWelcomeType        = 0x02
+	PatchLoginType          = 0x04
+	PatchMessageType        = 0x13
+	PatchRedirectType       = 0x14
+	PatchDataAckType        = 0x0B
+	PatchDirAboveType       = 0x0A
+	PatchChangeDirType      = 0x09
+	PatchCheckFileType      = 0x0C
+	PatchFileListDoneType   = 0x0D
+	PatchFileStatusType     = 0x0F
+	PatchClientListDoneType = 0x10
+	PatchUpdateFilesType    = 0x11
+	PatchFileHeaderType     = 0x06
+	PatchFileChunkType      = 0x07
+	PatchFileCompleteType   = 0x08
+	PatchUpdateCompleteType = 0x12
+)
+
+// Packet types for packets sent to and from the login and character servers.
+const (
+	LoginWelcomeType            = 0x03
+	LoginType                   = 0x93
+	LoginSecurityType           = 0xE6
+	LoginClientMessageType      = 0x1A
+	LoginOptionsRequestType     = 0xE0
+	LoginOptionsType            = 0xE2
+	LoginCharPreviewReqType     = 0xE3
+	LoginCharAckType            = 0xE4
+	LoginCharPreviewType        = 0xE5
+	LoginFullCharacterType      = 0xE7
+	LoginChecksumType           = 0x01E8
+	LoginChecksumAckType        = 0x02E8
+	LoginGuildcardReqType       = 0x03E8
+	LoginGuildcardHeaderType    = 0x01DC
+	LoginGuildcardChunkType     = 0x02DC
+	LoginGuildcardChunkReqType  = 0x03DC
+	LoginParameterHeaderType    = 0x01EB
+	LoginParameterChunkType     = 0x02EB
+	LoginParameterChunkReqType  = 0x03EB
+	LoginParameterHeaderReqType = 0x04EB
+	LoginSetFlagType            = 0xEC
+	LoginTimestampType          = 0xB1
+	LoginShipListType           = 0xA0
+	LoginScrollMessageType      = 0xEE
+)
+
+// Packet types for packets sent to and from the ship and block servers.
+const (
+	BlockListType       = 0x07
+	LobbyListType       = 0x83
+	LobbyChangeType     = 0x84
+	LobbyJoinType       = 0x67
+	LobbyAddPlayerType  = 0x68
+	LobbyLeaveType      = 0x69
+	LobbyEventType      = 0xDA
+	CharDataRequestType = 0x95
+	CharDataType        = 0x61
+	ChatType            = 0x06
+	GameListType        = 0x08
+	GameCreateType      = 0xC1
+	GameJoinType        = 0x64
+	GameAddPlayerType   = 0x65
+	GameLeaveType       = 0x66
+	GameLeaveLobbyType  = 0x98
+	SimpleMailType      = 0x81
+	// Sent by the client to find a player by guildcard, and by the server with
+	// where they are if they're online.
+	GuildcardSearchType      = 0x40
+	GuildcardSearchReplyType = 0x41
+
+	// Sent by the client when the player changes their guildcard lists.
+	GuildcardAddType     = 0x04E8
+	GuildcardRemoveType  = 0x05E8
+	GuildcardBlockType   = 0x07E8
+	GuildcardUnblockType = 0x08E8
+	GuildcardCommentType = 0x09E8
+
+	// Sent by the client to manage the player's team. The server answers the
+	// requests that change the team with the matching result type, with Flags
+	// set to one of the TeamResult values.
+	TeamCreateType             = 0x01EA
+	TeamCreateResultType       = 0x02EA
+	TeamAddMemberType          = 0x03EA
+	TeamAddMemberResultType    = 0x04EA
+	TeamRemoveMemberType       = 0x05EA
+	TeamRemoveMemberResultType = 0x06EA
+	TeamChatType               = 0x07EA
+	TeamMemberListRequestType  = 0x08EA
+	TeamMemberListType         = 0x09EA
+	TeamFlagType               = 0x0FEA
+	TeamDisbandType            = 0x10EA
+	TeamInfoType               = 0x12EA
+
+	// Sent by the client when the player changes their settings.
+	OptionFlagsUpdateType    = 0x01ED
+	KeyConfigUpdateType      = 0x04ED
+	JoystickConfigUpdateType = 0x05ED
+
+	// Commands sent between players in a lobby or game. The targeted variants
+	// go only to the client whose id is in the header flags.
+	GameCommandType            = 0x60
+	GameCommandTargetType      = 0x62
+	GameCommandLargeType       = 0x6C
+	GameCommandLargeTargetType = 0x6D
+
+	// Sent while choosing and loading a quest at the quest counter.
+	MenuItemInfoType    = 0x09
+	QuestListType       = 0xA2
+	QuestInfoType       = 0xA3
+	QuestMenuClosedType = 0xA9
+	QuestReadyType      = 0xAC
+	QuestFileType       = 0x44
+	QuestChunkType      = 0x13
+	// Download quests use the same packets with these types. They're only
+	// found in .qst files.
+	QuestDownloadFileType  = 0xA6
+	QuestDownloadChunkType = 0xA7
+
+	// Sent to carry out a trade once the players have agreed on it in the trade
+	// window. The client cancels a trade with TradeCompleteType and the server
+	// sends it with Flags set to 1 if the trade went through or 0 if it didn't.
+	TradeItemsType    = 0xD0
+	TradeReadyType    = 0xD1
+	TradeConfirmType  = 0xD2
+	TradeCompleteType = 0xD4
+)
+
+// Subcommands contained in the game command packets.
+const (
+	SubCmdSymbolChat          = 0x07
+	SubCmdSetFloor            = 0x1F
+	SubCmdWarp                = 0x21
+	SubCmdDestroyItem         = 0x29
+	SubCmdDropInventoryItem   = 0x2A
+	SubCmdLevelUp             = 0x30
+	SubCmdStopMoving          = 0x3E
+	SubCmdSetPosition         = 0x3F
+	SubCmdWalk                = 0x40
+	SubCmdRun                 = 0x42
+	SubCmdAttack1             = 0x43
+	SubCmdAttack2             = 0x44
+	SubCmdAttack3             = 0x45
+	SubCmdPlayerDied          = 0x4D
+	SubCmdPickUpItem          = 0x59
+	SubCmdPickUpItemRequest   = 0x5A
+	SubCmdDropStack           = 0x5D
+	SubCmdDropItem            = 0x5F
+	SubCmdEnemyDropRequest    = 0x60
+	SubCmdWordSelect          = 0x74
+	SubCmdSetQuestFlag        = 0x75
+	SubCmdChallengeStageClear = 0x7C
+	SubCmdBoxDropRequest      = 0xA2
+	SubCmdShopRequest         = 0xB5
+	SubCmdShopContents        = 0xB6
+	SubCmdBuyItem             = 0xB7
+	SubCmdIdentifyItem        = 0xB8
+	SubCmdIdentifyResult      = 0xB9
+	SubCmdAcceptIdentify      = 0xBA
+	SubCmdBankRequest         = 0xBB
+	SubCmdBankContents        = 0xBC
+	SubCmdBankAction          = 0xBD
+	SubCmdCreateInventoryItem = 0xBE
+	SubCmdGiveExperience      = 0xBF
+	SubCmdSellItem            = 0xC0
+	SubCmdSplitStack          = 0xC3
+	SubCmdEnemyKilled         = 0xC8
+)
+
+// Packet types for packets sent to and from PC, Dreamcast, and Gamecube clients
+// by the legacy login servers.
+const (
+	LegacyWelcomeType       = 0x17
+	LegacySecurityType      = 0x04
+	LegacyClientMessageType = 0x1A
+	LegacyLicenseResultType = 0x9A
+	// PC and Dreamcast clients send their license in a 9A and log in with a 9D,
+	// Gamecube clients with a DB and a 9E.
+	PCLicenseType       = 0x9A
+	PCLoginType         = 0x9D
+	GCLoginType         = 0x9E
+	GCVerifyLicenseType = 0xDB
+)
+
+// Results of a license check, sent as the flags of packet 9A.
+const (
+	LicenseBadPassword  = 0x01
+	LicenseOK           = 0x02
+	LicenseBadAccessKey = 0x03
+	LicenseUnregistered = 0x04
+)
+
+// Packet types common to multiple servers.
+const (
+	DisconnectType = 0x05
+	RedirectType   = 0x19
+	MenuSelectType = 0x10
+)
+
+// Set in the header flags of a redirect that carries an IPv6 address. Only
+// clients patched (or proxied) to connect over IPv6 understand these, and they
+// are only sent to clients that connected over IPv6.
+const RedirectIPv6Flag = 0x06
+
+// Error code types used for packet E6.
+type BBLoginError uint32
+
+const (
+	BBLoginErrorNone         = 0x0
+	BBLoginErrorUnknown      = 0x1
+	BBLoginErrorPassword     = 0x2
+	BBLoginErrorPassword2    = 0x3 // Same as password
+	BBLoginErrorMaintenance  = 0x4
+	BBLoginErrorUserInUse    = 0x5
+	BBLoginErrorBanned       = 0x6
+	BBLoginErrorBanned2      = 0x7 // Same as banned
+	BBLoginErrorUnregistered = 0x8
+	BBLoginErrorExpiredSub   = 0x9
+	BBLoginErrorLocked       = 0xA
+	BBLoginErrorPatch        = 0xB
+	BBLoginErrorDisconnect   = 0xC
+	// The client has no code of its own for a full server, so players are sent
+	// a message saying why before they're disconnected.
+	BBLoginErrorFull = BBLoginErrorDisconnect
+)
+
+// Blueburst, PC, and Gamecube clients all use a 4 byte header to
+// communicate with the patch server instead of the 8 byte one used
+// by Blueburst for the other servers.
+type PCHeader struct {
+	Size uint16
+	Type uint16
+}
+
+// Packet header for the packets sent between the legacy login servers and PC,
+// Dreamcast, and Gamecube clients. It's laid out the way that Dreamcast and
+// Gamecube clients expect; for PC clients the size is moved in front of the
+// type and flags when the packet is sent (see Client.fixLength).
+type LegacyHeader struct {
+	Type  uint8
+	Flags uint8
+	Size  uint16
+}
+
+// Packet header for every packet sent between the server and BlueBurst clients.
+type BBHeader struct {
+	Size  uint16
+	Type  uint16
+	Flags uint32
+}
+
+// Unmarshal fills in pkt from the Blue Burst packet at the start of data. Only
+// the number of bytes given in the packet's header are read, so a packet that's
+// shorter than pkt is an error instead of being filled in with whatever follows
+// it, as is a header giving a size that data doesn't have room for.
+func Unmarshal(data []byte, pkt encoding.BinaryUnmarshaler) error {
+	if len(data) < BBHeaderSize {
+		return fmt.Errorf("packet of %d bytes is shorter than its header", len(data))
+	}
+	size := int(binary.LittleEndian.Uint16(data))
+	if size < BBHeaderSize || size > len(data) {
+		return fmt.Errorf("header gives a size of %d for a packet of %d bytes", size, len(data))
+	}
+	return pkt.UnmarshalBinary(data[:size])
+}
+
+// Welcome packet with encryption vectors sent to the client upon initial connection.
+type PatchWelcomePkt struct {
+	Header       PCHeader
+	Copyright    [44]byte
+	Padding      [20]byte
+	ServerVector [4]byte
+	ClientVector [4]byte
+}
+
+// Packet containing the patch server welcome message.
+type PatchWelcomeMessage struct {
+	Header  PCHeader
+	Message []byte
+}
+
+// Redirect packet for patch to send character server IP.
+type PatchRedirectPacket struct {
+	Header  PCHeader
+	IPAddr  [4]uint8
+	Port    uint16
+	Padding uint16
+}
+
+// Patch redirect for clients that connected over IPv6, told apart from the
+// IPv4 one by its size since the PC header has no flags.
+type PatchRedirectIPv6Packet struct {
+	Header  PCHeader
+	IPAddr  [16]uint8
+	Port    uint16
+	Padding uint16
+}
+
+// Instruct the client to chdir into Dirname (one level below).
+type ChangeDirPacket struct {
+	Header  PCHeader
+	Dirname [64]byte
+}
+
+// Request a check on a file in the client's working directory.
+type CheckFilePacket struct {
+	Header   PCHeader
+	PatchId  uint32
+	Filename [32]byte
+}
+
+// Response to CheckFilePacket from the client with the properties of a file.
+type FileStatusPacket struct {
+	Header   PCHeader
+	PatchId  uint32
+	Checksum uint32
+	FileSize uint32
+}
+
+// Size and number of files that need to be updated.
+type UpdateFilesPacket struct {
+	Header    PCHeader
+	TotalSize uint32
+	NumFiles  uint32
+}
+
+// File header for a series of file chunks.
+type FileHeaderPacket struct {
+	Header   PCHeader
+	Padding  uint32
+	FileSize uint32
+	Filename [48]byte
+}
+
+// Chunk of data from a file.
+type FileChunkPacket struct {
+	Header   PCHeader
+	Chunk    uint32
+	Checksum uint32
+	Size     uint32
+	Data     []byte
+}
+
+// Welcome packet with encryption vectors sent to the client upon initial connection.
+type WelcomePkt struct {
+	Header       BBHeader
+	Copyright    [96]byte
+	ServerVector [48]byte
+	ClientVector [48]byte
+}
+
+// Login Packet (0x93) sent to both the login and character servers.
+type LoginPkt struct {
+	Header        BBHeader
+	Unknown       [8]byte
+	ClientVersion uint16
+	Unknown2      [3]byte
+	SlotNum       int8
+	Phase         uint16 // differentiate login packet?
+	TeamId        uint32
+	Username      [16]byte
+	Padding       [32]byte
+	Password      [16]byte
+	Unknown3      [32]byte
+	// Set by the block server's guildcard search reply when the player follows
+	// it to meet someone.
+	MenuId         uint32
+	PreferredLobby uint32
+	HardwareInfo   [8]byte
+	Security       [40]byte
+}
+
+// Represent the client's progression through the login process.
+type ClientConfig struct {
+	Magic        uint32 // Must be set to 0x48615467
+	CharSelected uint8  // Has a character been selected?
+	SlotNum      uint8  // Slot number of selected Character
+	Flags        uint16
+	Ports        [4]uint16
+	// Issued by the login server and checked by the others (see session.go).
+	SessionToken [16]byte
+	Unused2      [2]uint32
+}
+
+// Security packet (0xE6) sent to the client to indicate the state of client login.
+type SecurityPacket struct {
+	Header       BBHeader
+	ErrorCode    uint32
+	PlayerTag    uint32
+	Guildcard    uint32
+	TeamId       uint32
+	Config       *ClientConfig
+	Capabilities uint32
+}
+
+// The address of the next server; in this case, the character server.
+type RedirectPacket struct {
+	Header  BBHeader
+	IPAddr  [4]uint8
+	Port    uint16
+	Padding uint16
+}
+
+// RedirectPacket for clients that connected over IPv6, sent with
+// RedirectIPv6Flag set.
+type RedirectIPv6Packet struct {
+	Header  BBHeader
+	IPAddr  [16]uint8
+	Port    uint16
+	Padding uint16
+}
+
+// Based on the key config structure from sylverant and newserv. KeyConfig
+// and JoystickConfig are saved in the database.
+type KeyTeamConfig struct {
+	Unknown            [0x114]uint8
+	KeyConfig          [0x16C]uint8
+	JoystickConfig     [0x38]uint8
+	Guildcard          uint32
+	TeamId             uint32
+	TeamInfo           [2]uint32
+	TeamPrivilegeLevel uint16
+	Reserved           uint16
+	Teamname           [0x10]uint16
+	TeamFlag           [0x0800]uint8
+	TeamRewards        [2]uint32
+}
+
+// Option packet containing keyboard and joystick config, team options, etc.
+type OptionsPacket struct {
+	Header          BBHeader
+	PlayerKeyConfig KeyTeamConfig
+}
+
+type CharSelectionPacket struct {
+	Header    BBHeader
+	Slot      uint32
+	Selecting uint32
+}
+
+// Acknowledge a character selection from the client or indicate an error.
+type CharAckPacket struct {
+	Header BBHeader
+	Slot   uint32
+	Flag   uint32
+}
+
+// Sent by the client with the checksum of its executable.
+type ChecksumPacket struct {
+	Header   BBHeader
+	Checksum uint32
+	Unused   uint32
+}
+
+// Sent in response to 0x01E8 to accept the client's checksum.
+type ChecksumAckPacket struct {
+	Header BBHeader
+	Ack    uint32
+}
+
+// Chunk header with info about the guildcard data we're about to send.
+type GuildcardHeaderPacket struct {
+	Header   BBHeader
+	Unknown  uint32
+	Length   uint16
+	Padding  uint16
+	Checksum uint32
+}
+
+// Received from the client to request a guildcard data chunk.
+type GuildcardChunkReqPacket struct {
+	Header         BBHeader
+	Unknown        uint32
+	ChunkRequested uint32
+	Continue       uint32
+}
+
+type GuildcardChunkPacket struct {
+	Header  BBHeader
+	Unknown uint32
+	Chunk   uint32
+	Data    []uint8
+}
+
+// Parameter header containing details about the param files we're about to send.
+type ParameterHeaderPacket struct {
+	Header  BBHeader
+	Entries []byte
+}
+
+type ParameterChunkPacket struct {
+	Header BBHeader
+	Chunk  uint32
+	Data   []byte
+}
+
+// Used by the client to indicate whether a character should be recreated or updated.
+type SetFlagPacket struct {
+	Header BBHeader
+	Flag   uint32
+}
+
+// Sent to the client for the selection menu and received for updating a character.
+type CharPreviewPacket struct {
+	Header    BBHeader
+	Slot      uint32
+	Character *CharacterPreview
+}
+
+// Simple mail sent by a player to a guildcard number.
+type SimpleMailPacket struct {
+	Header    BBHeader
+	Tag       uint32
+	Guildcard uint32
+	Name      [16]uint16
+	Recipient uint32
+	Date      [20]uint16
+	Message   [0x200]uint16
+}
+
+// Player is looking for someone by guildcard.
+type GuildcardSearchPacket struct {
+	Header    BBHeader
+	PlayerTag uint32
+	Searcher  uint32
+	Target    uint32
+}
+
+// Where the player that someone searched for is, with the redirect the client
+// follows if they choose to go there.
+type GuildcardSearchReplyPacket struct {
+	Header    BBHeader
+	PlayerTag uint32
+	Searcher  uint32
+	Target    uint32
+	Redirect  RedirectPacket
+	Location  [0x44]uint16
+	MenuId    uint32
+	LobbyId   uint32
+	Unused    [0x3C]byte
+	Name      [0x20]uint16
+}
+
+// Player added someone to their friend list.
+type GuildcardAddPacket struct {
+	Header BBHeader
+	Entry  GuildcardEntry
+}
+
+// Player removed someone from their friend list.
+type GuildcardRemovePacket struct {
+	Header    BBHeader
+	Guildcard uint32
+}
+
+// Player changed the comment on one of the guildcards on their friend list.
+type GuildcardCommentPacket struct {
+	Header    BBHeader
+	Guildcard uint32
+	Comment   [88]uint16
+}
+
+// Player added someone to their blocked list.
+type GuildcardBlockPacket struct {
+	Header BBHeader
+	Entry  GuildcardEntry
+}
+
+// Player removed someone from their blocked list.
+type GuildcardUnblockPacket struct {
+	Header    BBHeader
+	Guildcard uint32
+}
+
+// Player is creating a team with themselves as its master.
+type TeamCreatePacket struct {
+	Header BBHeader
+	Name   [16]uint16
+}
+
+// Player is adding someone to their team or removing them from it.
+type TeamMemberPacket struct {
+	Header    BBHeader
+	Guildcard uint32
+}
+
+type TeamMemberListEntry struct {
+	Index     uint32
+	Privilege uint32
+	Guildcard uint32
+	Name      [16]uint16
+}
+
+// Members of the player's team, in the order they joined.
+type TeamMemberListPacket struct {
+	Header     BBHeader
+	NumMembers uint32
+	Entries    []TeamMemberListEntry
+}
+
+// Player is changing their team's flag, a 32x32 image with 16 bits per pixel.
+type TeamFlagPacket struct {
+	Header BBHeader
+	Flag   [0x800]byte
+}
+
+// Tells the client which team the player is in after it changes. The fields are
+// the same as the ones in KeyTeamConfig.
+type TeamInfoPacket struct {
+	Header         BBHeader
+	Guildcard      uint32
+	TeamId         uint32
+	TeamInfo       [2]uint32
+	PrivilegeLevel uint16
+	Reserved       uint16
+	TeamName       [0x10]uint16
+	TeamFlag       [0x800]uint8
+}
+
+// Player changed one of the options saved for their account.
+type OptionFlagsUpdatePacket struct {
+	Header BBHeader
+	Flags  uint32
+}
+
+type KeyConfigUpdatePacket struct {
+	Header    BBHeader
+	KeyConfig [0x16C]uint8
+}
+
+type JoystickConfigUpdatePacket struct {
+	Header         BBHeader
+	JoystickConfig [0x38]uint8
+}
+
+// Complete data for the character the client selected.
+type FullCharacterPacket struct {
+	Header    BBHeader
+	Character FullCharacter
+}
+
+// Message in a large text box, usually sent right before a disconnect.
+type LoginClientMessagePacket struct {
+	Header   BBHeader
+	Language uint32
+	Message  []byte
+}
+
+// Indicate the server's current time.
+type TimestampPacket struct {
+	Header    BBHeader
+	Timestamp [28]byte
+}
+
+// Entry in the available ships lis on the ship selection menu.
+type ShipMenuEntry struct {
+	MenuId  uint16
+	ShipId  uint32
+	Padding uint16
+
+	Shipname [36]byte
+}
+
+// The list of menu items to display to the client.
+type ShipListPacket struct {
+	Header      BBHeader
+	Padding     uint16
+	Unknown     uint16 // set to 0xFFFFFFF4
+	Unknown2    uint32 // set to 0x02
+	Unknown3    uint16 // set to 0x04
+	ServerName  [36]byte
+	ShipEntries []ShipMenuEntry
+}
+
+// Scroll message the client should display on the ship select screen.
+type ScrollMessagePacket struct {
+	Header  BBHeader
+	Padding [2]uint32
+	Message []byte
+}
+
+// Client's selection from the ship or block selection menu.
+type MenuSelectionPacket struct {
+	Header  BBHeader
+	Unknown uint16
+	MenuId  uint16
+	ItemId  uint32
+}
+
+// Info about the available block servers.
+type Block struct {
+	MenuId    uint16
+	BlockId   uint32
+	Padding   uint16
+	BlockName [36]byte
+}
+
+// List containing the available blocks on a ship.
+type BlockListPacket struct {
+	Header   BBHeader
+	Padding  [10]byte
+	ShipName [32]byte
+	Unknown  uint32
+	Blocks   []Block
+}
+
+// Entry for one lobby in the lobby list.
+type LobbyMenuEntry struct {
+	MenuId  uint32
+	LobbyId uint32
+	Padding uint32
+}
+
+// Available lobbies on a block.
+type LobbyListPacket struct {
+	Header  BBHeader
+	Lobbies []LobbyMenuEntry
+}
+
+// Sent by the client with its copy of the player's character when it's ready to
+// enter a lobby. More of the character follows these.
+type CharacterDataPacket struct {
+	Header    BBHeader
+	Inventory Inventory
+	Disp      PlayerDispData
+}
+
+// Sent by the client to chat with the other players in its lobby or game. The
+// same structure is relayed to the other players with the sender's name prepended.
+type ChatPacket struct {
+	Header    BBHeader
+	Unused    uint32
+	Guildcard uint32
+	Message   []byte
+}
+
+// Player-specific information included for each player in lobby join packets.
+type PlayerLobbyData struct {
+	PlayerTag uint32
+	Guildcard uint32
+	IPAddr    uint32
+	Unknown   [16]byte
+	ClientId  uint32
+	Name      [16]uint16
+	Unknown2  uint32
+}
+
+// Entry for each player in a lobby or game join packet.
+type PlayerJoinEntry struct {
+	Player    PlayerLobbyData
+	Inventory Inventory
+	Disp      PlayerDispData
+}
+
+// Sent to the player joining a lobby (with everyone in the lobby) and to the other
+// players in the lobby (with just the new player) when somebody joins.
+type LobbyJoinPacket struct {
+	Header      BBHeader
+	ClientId    uint8
+	LeaderId    uint8
+	DisableUDP  uint8
+	LobbyNumber uint8
+	BlockNumber uint16
+	Event       uint16
+	Padding     uint32
+	Entries     []PlayerJoinEntry
+}
+
+// Notify the remaining players in a lobby that someone has left.
+type LobbyLeavePacket struct {
+	Header   BBHeader
+	ClientId uint8
+	LeaderId uint8
+	Padding  uint16
+}
+
+// Sent by the client to create a new game.
+type GameCreatePacket struct {
+	Header        BBHeader
+	Unknown       [2]uint32
+	Name          [16]uint16
+	Password      [16]uint16
+	Difficulty    uint8
+	BattleMode    uint8
+	ChallengeMode uint8
+	Episode       uint8
+	SoloMode      uint8
+	Padding       [3]uint8
+}
+
+// Client's selection from the game menu, which includes the password they entered.
+type GameMenuSelectionPacket struct {
+	Header   BBHeader
+	Unknown  uint16
+	MenuId   uint16
+	ItemId   uint32
+	Password [16]uint16
+}
+
+// Entry in the list of active games.
+type GameMenuEntry struct {
+	MenuId     uint32
+	GameId     uint32
+	Difficulty uint8
+	NumPlayers uint8
+	Name       [16]uint16
+	Episode    uint8
+	// 0x02 if the game has a password and 0x20 if it's in challenge mode.
+	Flags uint8
+}
+
+// List of the games available to join.
+type GameListPacket struct {
+	Header  BBHeader
+	Entries []GameMenuEntry
+}
+
+// Sent to a player joining a game with the state of the game and its players.
+type GameJoinPacket struct {
+	Header        BBHeader
+	Variations    [0x20]uint32
+	Players       [4]PlayerLobbyData
+	ClientId      uint8
+	LeaderId      uint8
+	DisableUDP    uint8
+	Difficulty    uint8
+	BattleMode    uint8
+	Event         uint8
+	SectionId     uint8
+	ChallengeMode uint8
+	RareSeed      uint32
+	Episode       uint8
+	Unknown       uint8
+	SoloMode      uint8
+	Unknown2      uint8
+}
+
+// Game command that's passed along to the other players as-is.
+type GameCommandPacket struct {
+	Header BBHeader
+	Data   []byte
+}
+
+// Header common to each subcommand in a game command packet. Size is the length
+// of the subcommand in units of 4 bytes (or 0 if it's followed by a uint32 size).
+type SubCmdHeader struct {
+	Type     uint8
+	Size     uint8
+	ClientId uint16
+}
+
+// Item as it's stored in the bank.
+type BankItem struct {
+	Item    ItemData
+	Amount  uint16
+	Present uint16
+}
+
+// Contents of the player's bank, sent when they open it.
+type BankContentsPacket struct {
+	Header    BBHeader
+	SubHeader SubCmdHeader
+	Size      uint32
+	Checksum  uint32
+	NumItems  uint32
+	Meseta    uint32
+	Items     []BankItem
+}
+
+// Player deposited or withdrew meseta (if ItemId is 0xFFFFFFFF) or an item.
+type BankActionPacket struct {
+	Header    BBHeader
+	SubHeader SubCmdHeader
+	ItemId    uint32
+	Meseta    uint32
+	Action    uint8
+	Amount    uint8
+	Unused    uint16
+}
+
+// Symbol chat made of up to 12 face parts and 4 corner objects.
+type SymbolChatPacket struct {
+	Header        BBHeader
+	SubHeader     SubCmdHeader
+	ClientId      uint32
+	Spec          uint32
+	CornerObjects [4]uint16
+	FaceParts     [12]uint32
+}
+
+// Word select message made of up to 8 phrases from the client's word list.
+type WordSelectPacket struct {
+	Header          BBHeader
+	SubHeader       SubCmdHeader
+	NumTokens       uint16
+	TargetType      uint16
+	Tokens          [8]uint16
+	NumericArgument uint32
+	Unknown         uint32
+}
+
+// Removes an item (or some of a stack) from a player's inventory.
+type DestroyItemPacket struct {
+	Header    BBHeader
+	SubHeader SubCmdHeader
+	ItemId    uint32
+	Amount    uint32
+}
+
+// Adds an item to a player's inventory.
+type CreateInventoryItemPacket struct {
+	Header    BBHeader
+	SubHeader SubCmdHeader
+	Item      ItemData
+	Unused    uint32
+}
+
+// Sent by the client when the player drops an item from their inventory.
+type DropInventoryItemPacket struct {
+	Header    BBHeader
+	SubHeader SubCmdHeader
+	Unknown   uint16
+	Floor     uint16
+	ItemId    uint32
+	X         float32
+	Z         float32
+}
+
+// Sent by the client when the player drops some of a stack of items or some of
+// their meseta (if ItemId is 0xFFFFFFFF).
+type SplitStackPacket struct {
+	Header    BBHeader
+	SubHeader SubCmdHeader
+	Floor     uint16
+	Unused    uint16
+	X         float32
+	Z         float32
+	ItemId    uint32
+	Amount    uint32
+}
+
+// Places the items or meseta split from a stack on the floor of the game.
+type DropStackPacket struct {
+	Header    BBHeader
+	SubHeader SubCmdHeader
+	Floor     uint16
+	Unused    uint16
+	X         float32
+	Z         float32
+	Item      ItemData
+	Unused2   uint32
+}
+
+// Items and meseta that a player is giving in a trade. Meseta is sent as an item
+// of type 4 with the amount in Data2.
+type TradeItemsPacket struct {
+	Header         BBHeader
+	TargetClientId uint16
+	NumItems       uint16
+	Items          [MaxTradeItems]ItemData
+}
+
+// Sent by the client when the player talks to one of the shopkeepers.
+type ShopRequestPacket struct {
+	Header    BBHeader
+	SubHeader SubCmdHeader
+	ShopType  uint8
+	Unused    [3]byte
+}
+
+// Items for sale in a shop, with the price of each in Data2.
+type ShopContentsPacket struct {
+	Header    BBHeader
+	SubHeader SubCmdHeader
+	ShopType  uint8
+	NumItems  uint8
+	Unused    uint16
+	Items     [MaxShopItems]ItemData
+}
+
+// Sent by the client when the player buys an item (or Amount of a tool) from the
+// shop they last opened.
+type BuyItemPacket struct {
+	Header    BBHeader
+	SubHeader SubCmdHeader
+	ItemId    uint32
+	ShopType  uint8
+	Index     uint8
+	Amount    uint8
+	Unknown   uint8
+}
+
+// Sent by the client when the player sells an item (or some of a stack) at a shop.
+type SellItemPacket struct {
+	Header    BBHeader
+	SubHeader SubCmdHeader
+	ItemId    uint32
+	Amount    uint32
+}
+
+// Sent by the client when the player asks the tekker to appraise an unidentified
+// weapon and again when they accept the result.
+type IdentifyItemPacket struct {
+	Header    BBHeader
+	SubHeader SubCmdHeader
+	ItemId    uint32
+}
+
+// What the tekker made of the weapon, which the player can accept or refuse.
+type IdentifyResultPacket struct {
+	Header    BBHeader
+	SubHeader SubCmdHeader
+	Item      ItemData
+}
+
+// Sent when an enemy is killed or a box is broken to ask the server what it drops.
+// Requests for boxes carry more fields after these, which aren't needed.
+type DropRequestPacket struct {
+	Header    BBHeader
+	SubHeader SubCmdHeader
+	Floor     uint8
+	// Index of the enemy's type in the client's rare tables.
+	EnemyType uint8
+	EntityId  uint16
+	X         float32
+	Z         float32
+	Unknown   uint32
+}
+
+// Places an item on the floor of the game.
+type DropItemPacket struct {
+	Header    BBHeader
+	SubHeader SubCmdHeader
+	Floor     uint8
+	// DropSourceEnemy or DropSourceBox.
+	Source   uint8
+	EntityId uint16
+	X        float32
+	Z        float32
+	Unknown  uint32
+	Item     ItemData
+	Unused   uint32
+}
+
+// Sent by the client when the player tries to pick up an item from the floor.
+type PickUpItemRequestPacket struct {
+	Header    BBHeader
+	SubHeader SubCmdHeader
+	ItemId    uint32
+	Floor     uint8
+	Unused    [3]byte
+}
+
+// Tells everyone in the game that a player picked up an item.
+type PickUpItemPacket struct {
+	Header    BBHeader
+	SubHeader SubCmdHeader
+	ClientId  uint16
+	Floor     uint16
+	ItemId    uint32
+}
+
+// Sent by the client when the player arrives on a floor or warps to another one.
+type SetFloorPacket struct {
+	Header    BBHeader
+	SubHeader SubCmdHeader
+	Floor     uint32
+}
+
+// Sent by the client when the player stops moving or is put somewhere.
+type SetPositionPacket struct {
+	Header    BBHeader
+	SubHeader SubCmdHeader
+	Unknown   uint16
+	Angle     uint16
+	Floor     uint16
+	Room      uint16
+	X         float32
+	Y         float32
+	Z         float32
+}
+
+// Sent by the client as the player walks or runs.
+type MovePacket struct {
+	Header    BBHeader
+	SubHeader SubCmdHeader
+	X         float32
+	Z         float32
+}
+
+// Sent by the client when an enemy dies, with the client id of the player who
+// killed it.
+type EnemyKilledPacket struct {
+	Header         BBHeader
+	SubHeader      SubCmdHeader
+	EnemyId        uint16
+	KillerClientId uint16
+	Unknown        uint32
+}
+
+// Sent by a player's client when they die, with the client id of the player who
+// killed them in battle mode (0xFFFF if it wasn't another player).
+type PlayerDiedPacket struct {
+	Header         BBHeader
+	SubHeader      SubCmdHeader
+	KillerClientId uint16
+	Unknown        uint16
+}
+
+// Gives experience to the player in the SubHeader's ClientId.
+type GiveExperiencePacket struct {
+	Header    BBHeader
+	SubHeader SubCmdHeader
+	Amount    uint32
+}
+
+// Tells everyone in the game that a player leveled up, with their new stats.
+type LevelUpPacket struct {
+	Header    BBHeader
+	SubHeader SubCmdHeader
+	ATP       uint16
+	MST       uint16
+	EVP       uint16
+	HP        uint16
+	DFP       uint16
+	ATA       uint16
+	Level     uint32
+}
+
+// Sent by the client when the players clear a stage in challenge mode. It
+// carries the client's records after these, which the server doesn't trust.
+type ChallengeStageClearPacket struct {
+	Header    BBHeader
+	SubHeader SubCmdHeader
+	ClientId  uint16
+	Unknown   uint16
+	Time      uint32
+}
+
+// Sets (Action 0) or clears (Action 1) one of the quest flags on the sender's
+// character for the game's difficulty.
+type SetQuestFlagPacket struct {
+	Header    BBHeader
+	SubHeader SubCmdHeader
+	Flag      uint16
+	Action    uint16
+}
+
+// Entry in the quest menu, which is either a category or a quest.
+type QuestMenuEntry struct {
+	MenuId      uint32
+	ItemId      uint32
+	Name        [32]uint16
+	Description [122]uint16
+}
+
+// List of quest categories or of the quests in one.
+type QuestListPacket struct {
+	Header  BBHeader
+	Entries []QuestMenuEntry
+}
+
+// Long description of a quest, shown when the player highlights it on the menu.
+type QuestInfoPacket struct {
+	Header BBHeader
+	Text   [288]uint16
+}
+
+// Tells the client to start receiving one of the quest's files.
+type QuestFilePacket struct {
+	Header   BBHeader
+	Name     [32]byte
+	Unused   uint16
+	Flags    uint16
+	Filename [16]byte
+	Length   uint32
+	Unused2  [24]byte
+}
+
+// Chunk of one of the quest's files. The chunk's index is in the header flags.
+type QuestChunkPacket struct {
+	Header   BBHeader
+	Filename [16]byte
+	Data     [QuestChunkSize]byte
+	Length   uint32
+}
+
+// Welcome packet with encryption vectors sent to PC, Dreamcast, and Gamecube
+// clients upon initial connection.
+type LegacyWelcomePkt struct {
+	Header       LegacyHeader
+	Copyright    [64]byte
+	ServerVector [4]byte
+	ClientVector [4]byte
+}
+
+// License check sent by PC and Dreamcast clients when they first connect.
+type PCLicensePkt struct {
+	Header         LegacyHeader
+	V1SerialNumber [16]byte
+	V1AccessKey    [16]byte
+	SerialNumber   [16]byte
+	AccessKey      [16]byte
+	PlayerTag      uint32
+	Guildcard      uint32
+	SubVersion     uint32
+	SerialNumber2  [48]byte
+	AccessKey2     [48]byte
+	Email          [48]byte
+}
+
+// License check sent by Gamecube clients when they first connect.
+type GCVerifyLicensePkt struct {
+	Header        LegacyHeader
+	Unknown       [32]byte
+	SerialNumber  [16]byte
+	AccessKey     [16]byte
+	Unknown2      [8]byte
+	SubVersion    uint32
+	Unknown3      [96]byte
+	SerialNumber2 [16]byte
+	AccessKey2    [16]byte
+	Password      [16]byte
+}
+
+// Login sent once the client's license has been accepted. Gamecube clients
+// (packet 9E) append their config, which the server doesn't need.
+type LegacyLoginPkt struct {
+	Header         LegacyHeader
+	PlayerTag      uint32
+	Guildcard      uint32
+	Unknown        [8]byte
+	SubVersion     uint32
+	IsExtended     uint8
+	Language       uint8
+	Unused         uint16
+	V1SerialNumber [16]byte
+	V1AccessKey    [16]byte
+	SerialNumber   [16]byte
+	AccessKey      [16]byte
+	SerialNumber2  [48]byte
+	AccessKey2     [48]byte
+	Name           [16]byte
+}
+
+// Gives a legacy client its guildcard number and config.
+type LegacySecurityPkt struct {
+	Header    LegacyHeader
+	PlayerTag uint32
+	Guildcard uint32
+	Config    [32]byte
+}
+
+// Message box shown to legacy clients. The message is in UTF-16 for PC clients
+// and ASCII for the others.
+type LegacyClientMessagePkt struct {
+	Header  LegacyHeader
+	Message []byte
+}
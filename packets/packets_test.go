@@ -0,0 +1,180 @@
+/*
+* Round trips every packet through its golden file in testdata, which holds the
+* encoding (as printed by hex.Dump) of the packet with its fields counting up
+* from 1, so that a change to the layout of a packet shows up as a change to its
+* golden file. Run "go test -update" to rewrite them after changing a packet on
+* purpose.
+ */
+package packets
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"encoding/hex"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// Number of elements given to each slice in the golden packets.
+const goldenSliceLen = 2
+
+// Fill in every field of v with the next value counting up from *n.
+func fillCounting(v reflect.Value, n *uint64) {
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			fillCounting(v.Field(i), n)
+		}
+		return
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			fillCounting(v.Index(i), n)
+		}
+		return
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(v.Type(), goldenSliceLen, goldenSliceLen))
+		for i := 0; i < v.Len(); i++ {
+			fillCounting(v.Index(i), n)
+		}
+		return
+	case reflect.Ptr:
+		v.Set(reflect.New(v.Type().Elem()))
+		fillCounting(v.Elem(), n)
+		return
+	case reflect.Bool:
+		v.SetBool(*n%2 == 1)
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(*n)
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(int64(*n))
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(float64(*n) / 2)
+	}
+	*n++
+}
+
+// Returns the packet of type typ for its golden file, with the size in its
+// header filled in.
+func newGoldenPacket(typ reflect.Type) reflect.Value {
+	pkt := reflect.New(typ)
+	n := uint64(1)
+	fillCounting(reflect.ValueOf(reflectionView(pkt)).Elem(), &n)
+	data, _ := pkt.Interface().(encoding.BinaryMarshaler).MarshalBinary()
+	switch header := pkt.Elem().Field(0).Addr().Interface().(type) {
+	case *BBHeader:
+		header.Size = uint16(len(data))
+	case *PCHeader:
+		header.Size = uint16(len(data))
+	case *LegacyHeader:
+		header.Size = uint16(len(data))
+	}
+	return pkt
+}
+
+// Whether typ is a packet rather than a structure that's sent inside of them.
+func isPacket(typ reflect.Type) bool {
+	if typ.NumField() == 0 || typ.Field(0).Name != "Header" {
+		return false
+	}
+	switch typ.Field(0).Type {
+	case reflect.TypeOf(BBHeader{}), reflect.TypeOf(PCHeader{}), reflect.TypeOf(LegacyHeader{}):
+		return true
+	}
+	return false
+}
+
+func readGolden(t *testing.T, path string) []byte {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data []byte
+	for _, line := range strings.Split(string(contents), "\n") {
+		if end := strings.IndexByte(line, '|'); end >= 0 {
+			line = line[:end]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		b, err := hex.DecodeString(strings.Join(fields[1:], ""))
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		data = append(data, b...)
+	}
+	return data
+}
+
+func TestGoldenPackets(t *testing.T) {
+	for _, v := range generatedTypes {
+		typ := reflect.TypeOf(v)
+		if !isPacket(typ) {
+			continue
+		}
+		t.Run(typ.Name(), func(t *testing.T) {
+			pkt := newGoldenPacket(typ)
+			encoded, err := pkt.Interface().(encoding.BinaryMarshaler).MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join("testdata", typ.Name()+".golden")
+			if *update {
+				if err = ioutil.WriteFile(path, []byte(hex.Dump(encoded)), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			golden := readGolden(t, path)
+			if !bytes.Equal(encoded, golden) {
+				t.Fatalf("encoded\n%s\nexpected\n%s", hex.Dump(encoded), hex.Dump(golden))
+			}
+
+			decoded := newDecodeTarget(pkt)
+			unmarshaler := decoded.Interface().(encoding.BinaryUnmarshaler)
+			if typ.Field(0).Type == reflect.TypeOf(BBHeader{}) {
+				err = Unmarshal(golden, unmarshaler)
+			} else {
+				err = unmarshaler.UnmarshalBinary(golden)
+			}
+			if err != nil {
+				t.Fatal(err)
+			} else if !reflect.DeepEqual(decoded.Interface(), pkt.Interface()) {
+				t.Fatalf("decoded %+v, expected %+v", decoded.Interface(), pkt.Interface())
+			}
+		})
+	}
+}
+
+// Unmarshal should only read as far as the size in the header, and only when
+// that's within the data it's given.
+func TestUnmarshalBounds(t *testing.T) {
+	for _, v := range generatedTypes {
+		typ := reflect.TypeOf(v)
+		if !isPacket(typ) || typ.Field(0).Type != reflect.TypeOf(BBHeader{}) {
+			continue
+		}
+		t.Run(typ.Name(), func(t *testing.T) {
+			pkt := newGoldenPacket(typ)
+			data, _ := pkt.Interface().(encoding.BinaryMarshaler).MarshalBinary()
+			// Room for more of the packet past its declared size shouldn't help.
+			shortened := append(append([]byte(nil), data...), make([]byte, 16)...)
+			binary.LittleEndian.PutUint16(shortened, uint16(len(data)-1))
+			if err := Unmarshal(shortened, newDecodeTarget(pkt).Interface().(encoding.BinaryUnmarshaler)); err == nil {
+				t.Error("decoded a packet whose header gives a size that's too small")
+			}
+			if err := Unmarshal(data[:len(data)-1], newDecodeTarget(pkt).Interface().(encoding.BinaryUnmarshaler)); err == nil {
+				t.Error("decoded a packet whose header gives a size larger than the data")
+			}
+			if err := Unmarshal(data[:BBHeaderSize-1], newDecodeTarget(pkt).Interface().(encoding.BinaryUnmarshaler)); err == nil {
+				t.Error("decoded a packet shorter than its header")
+			}
+		})
+	}
+}
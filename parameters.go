@@ -0,0 +1,203 @@
+/*
+* The parameter files (item tables, battle parameters, etc.) that the character
+* server sends to Blue Burst clients when they log in. The files are read from
+* parameters_dir and checked when the server starts, which fails with a list of
+* any that are missing or don't match the checksums in the parameters section of
+* the config. Clients that report a version listed there are sent that version's
+* files instead of the usual ones.
+*
+* The files are reloaded with "archon reload" and, if poll_seconds is set,
+* whenever one of them changes. A reload that fails leaves the files that were
+* loaded before in place. Clients that are partway through downloading the files
+* carry on with the set they started with.
+ */
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dcrodman/archon/util"
+)
+
+// How often to check whether polling has been turned on while it's off.
+const parameterPollOffInterval = time.Minute
+
+// Entry in the parameter header describing one of the files.
+type parameterEntry struct {
+	Size     uint32
+	Checksum uint32
+	Offset   uint32
+	Filename [0x40]uint8
+}
+
+// The files sent to a client, laid out for the parameter packets so that they
+// don't have to be built again for every client.
+type parameterSet struct {
+	numFiles int
+	header   []byte
+	chunks   [][]byte
+}
+
+// Build a set from the contents of the named files.
+func newParameterSet(names []string, contents map[string][]byte) *parameterSet {
+	set := &parameterSet{numFiles: len(names)}
+	var combined []byte
+	for _, name := range names {
+		data := contents[name]
+		entry := &parameterEntry{
+			Size:     uint32(len(data)),
+			Checksum: crc32.ChecksumIEEE(data),
+			Offset:   uint32(len(combined)),
+		}
+		copy(entry.Filename[:], name)
+		bytes, _ := util.BytesFromStruct(entry)
+		set.header = append(set.header, bytes...)
+		combined = append(combined, data...)
+	}
+	set.chunks = splitChunks(combined)
+	return set
+}
+
+// When a file was last changed, used to notice when it needs reloading.
+type parameterStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+// Holds the current parameter sets and reloads them.
+type parameterManager struct {
+	// Set sent to clients that don't have their own.
+	standard *parameterSet
+	// Sets for the client versions listed in the config.
+	versions map[uint16]*parameterSet
+	stamps   map[string]parameterStamp
+	lock     sync.RWMutex
+}
+
+// Load reads and checks the files named in the config, replacing the current
+// sets if they're all present and correct.
+func (m *parameterManager) Load() error {
+	params := config.Parameters()
+	names := append([]string(nil), params.ParameterFiles...)
+	for _, files := range params.ParameterVersions {
+		names = append(names, files...)
+	}
+
+	contents := make(map[string][]byte)
+	stamps := make(map[string]parameterStamp)
+	var missing, mismatched []string
+	for _, name := range names {
+		if _, seen := stamps[name]; seen {
+			continue
+		}
+		// Stamp the file before reading it so that a change made in between is
+		// noticed the next time around.
+		stamps[name] = currentParameterStamp(name)
+		data, err := ioutil.ReadFile(filepath.Join(config.ParametersDir, name))
+		if os.IsNotExist(err) {
+			missing = append(missing, name)
+			continue
+		} else if err != nil {
+			return errors.New("Error reading parameter file: " + err.Error())
+		}
+		if expected, ok := params.ParameterChecksums[name]; ok {
+			if checksum := crc32.ChecksumIEEE(data); checksum != expected {
+				mismatched = append(mismatched, fmt.Sprintf("%s (%08x, expected %08x)", name, checksum, expected))
+			}
+		}
+		contents[name] = data
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("parameter files missing from %s: %s", config.ParametersDir, strings.Join(missing, ", "))
+	} else if len(mismatched) > 0 {
+		return errors.New("parameter files with the wrong checksum: " + strings.Join(mismatched, ", "))
+	}
+
+	standard := newParameterSet(params.ParameterFiles, contents)
+	versions := make(map[uint16]*parameterSet, len(params.ParameterVersions))
+	for version, files := range params.ParameterVersions {
+		versions[version] = newParameterSet(files, contents)
+	}
+	m.lock.Lock()
+	m.standard, m.versions, m.stamps = standard, versions, stamps
+	m.lock.Unlock()
+	return nil
+}
+
+// ForVersion returns the set to send to a client that reported a version in
+// its login packet.
+func (m *parameterManager) ForVersion(version uint16) *parameterSet {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if set, ok := m.versions[version]; ok {
+		return set
+	}
+	return m.standard
+}
+
+// Returns the current stamp of a file, or the zero stamp if it can't be read.
+func currentParameterStamp(name string) parameterStamp {
+	info, err := os.Stat(filepath.Join(config.ParametersDir, name))
+	if err != nil {
+		return parameterStamp{}
+	}
+	return parameterStamp{size: info.Size(), modTime: info.ModTime()}
+}
+
+// Returns the names of the files that have changed since they were last seen.
+func (m *parameterManager) changed() []string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	var changed []string
+	for name, stamp := range m.stamps {
+		current := currentParameterStamp(name)
+		if current.size != stamp.size || !current.modTime.Equal(stamp.modTime) {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// Mark the files as seen as they are now, without loading them.
+func (m *parameterManager) restamp(names []string) {
+	m.lock.Lock()
+	for _, name := range names {
+		m.stamps[name] = currentParameterStamp(name)
+	}
+	m.lock.Unlock()
+}
+
+// Loop for the life of the server, reloading the files when any of them change
+// while poll_seconds is set.
+func (m *parameterManager) watch() {
+	for {
+		seconds := config.Parameters().ParameterPollSeconds
+		if seconds <= 0 {
+			// Polling is off, but may be turned on by a reload of the config.
+			time.Sleep(parameterPollOffInterval)
+			continue
+		}
+		time.Sleep(time.Duration(seconds) * time.Second)
+		changed := m.changed()
+		if len(changed) == 0 {
+			continue
+		}
+		log.Infof("Reloading parameter files after changes to %s", strings.Join(changed, ", "))
+		if err := m.Load(); err != nil {
+			// Keep the old files until these change again (e.g. once a file
+			// that was being copied has been fixed) rather than retrying.
+			log.Error("Failed to reload parameter files: " + err.Error())
+			m.restamp(changed)
+		}
+	}
+}
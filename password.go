@@ -0,0 +1,165 @@
+/*
+* Password hashing. New passwords are hashed with bcrypt or argon2id depending on
+* the config; hashes from older or imported databases (hex encoded MD5, SHA-1,
+* or SHA-256 digests, and salted MD5 digests from Tethealla and Sylverant) are
+* still accepted and replaced on the next login.
+ */
+package main
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Hash schemes that can be used for new passwords.
+const (
+	PasswordHashBcrypt   = "bcrypt"
+	PasswordHashArgon2id = "argon2id"
+)
+
+// Parameters for argon2id hashes, per the recommendations in RFC 9106.
+const (
+	argon2Time    = 1
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+	argon2KeyLen  = 32
+	argon2SaltLen = 16
+)
+
+// Cost of new bcrypt hashes. Only lowered by tests, which would otherwise spend
+// most of their time hashing.
+var bcryptCost = bcrypt.DefaultCost
+
+var errMalformedHash = errors.New("malformed password hash")
+
+// Prefix of the hashes imported from Tethealla (or Sylverant, which salts them
+// the same way), which are saved as
+// $tethealla$<registration time>$<hex encoded MD5 digest> since the digest is of
+// the password salted with the time the account was registered.
+const tetheallaHashPrefix = "$tethealla$"
+
+// Hashes from databases that predate bcrypt, identified by their length.
+var legacyHashes = map[int]func() hash.Hash{
+	hex.EncodedLen(md5.Size):    md5.New,
+	hex.EncodedLen(sha1.Size):   sha1.New,
+	hex.EncodedLen(sha256.Size): sha256.New,
+}
+
+// Hash a password with the scheme set in the config.
+func hashPassword(password string) (string, error) {
+	if config.PasswordHash == PasswordHashArgon2id {
+		return hashArgon2id(password)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Returns whether password matches the hash saved for an account.
+func checkPassword(hash string, password string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, "$argon2id$"):
+		ok, err := checkArgon2id(hash, password)
+		return err == nil && ok
+	case strings.HasPrefix(hash, tetheallaHashPrefix):
+		parts := strings.SplitN(strings.TrimPrefix(hash, tetheallaHashPrefix), "$", 2)
+		if len(parts) != 2 {
+			return false
+		}
+		hash, password = parts[1], password+"_"+parts[0]+"_salt"
+	}
+	newHash, ok := legacyHashes[len(hash)]
+	if !ok {
+		return false
+	}
+	h := newHash()
+	h.Write([]byte(password))
+	sum := hex.EncodeToString(h.Sum(nil))
+	return subtle.ConstantTimeCompare([]byte(strings.ToLower(hash)), []byte(sum)) == 1
+}
+
+var (
+	decoyHashOnce sync.Once
+	decoyHash     string
+)
+
+// Check password against a hash of a random password, taking as long as
+// checking an account's. Called when there's no account with the username that
+// was given so that the time taken doesn't give that away.
+func checkDecoyPassword(password string) {
+	decoyHashOnce.Do(func() {
+		random := make([]byte, 16)
+		rand.Read(random)
+		decoyHash, _ = hashPassword(hex.EncodeToString(random))
+	})
+	checkPassword(decoyHash, password)
+}
+
+// Returns whether a hash should be replaced with one using the configured
+// scheme (or stronger parameters) the next time the player logs in.
+func needsRehash(hash string) bool {
+	if config.PasswordHash == PasswordHashArgon2id {
+		var memory, time uint32
+		var threads uint8
+		_, err := fmt.Sscanf(hash, "$argon2id$v=19$m=%d,t=%d,p=%d$", &memory, &time, &threads)
+		return err != nil || memory < argon2Memory || time < argon2Time
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost < bcryptCost
+}
+
+// Argon2id hashes are saved in the PHC string format used by the reference
+// implementation: $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<key>
+func hashArgon2id(password string) (string, error) {
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, argon2Memory,
+		argon2Time, argon2Threads, base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func checkArgon2id(hash string, password string) (bool, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return false, errMalformedHash
+	}
+	var version int
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, errMalformedHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false, errMalformedHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, errMalformedHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, errMalformedHash
+	}
+	other := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
@@ -12,10 +12,12 @@ import (
 	"io/ioutil"
 	"net"
 	"os"
+	"path/filepath"
 	"strconv"
-	"strings"
+	"sync"
 
-	crypto "github.com/dcrodman/archon/encryption"
+	crypto "github.com/dcrodman/archon/libarchon/encryption"
+	"github.com/dcrodman/archon/packets"
 	"github.com/dcrodman/archon/util"
 )
 
@@ -28,12 +30,13 @@ var PatchCopyright = []byte("Patch Server. Copyright SonicTeam, LTD. 2001")
 // PatchEntry instances contain metadata about each of the files in the patches directory.
 type PatchEntry struct {
 	filename string
-	// Path relative to the patch dir for convenience.
+	// Path to the file (including the patch dir) for convenience.
 	relativePath string
-	pathDirs     []string
-	index        uint32
-	checksum     uint32
-	fileSize     uint32
+	// Directories between the top of the patch tree and the file.
+	pathDirs []string
+	index    uint32
+	checksum uint32
+	fileSize uint32
 }
 
 // PatchDir is a tree structure for holding patch data that more closely represents
@@ -48,11 +51,11 @@ type PatchDir struct {
 
 // Create and initialize a new Patch client so long as we're able
 // to send the welcome packet to begin encryption.
-func NewPatchClient(conn *net.TCPConn) (*Client, error) {
+func NewPatchClient(conn net.Conn) (*Client, error) {
 	var err error
 	cCrypt := crypto.NewPCCrypt()
 	sCrypt := crypto.NewPCCrypt()
-	pc := NewClient(conn, PCHeaderSize, cCrypt, sCrypt)
+	pc := NewClient(conn, packets.PCHeaderSize, cCrypt, sCrypt)
 	if SendPCWelcome(pc) != nil {
 		err = errors.New("Error sending welcome packet to: " + pc.IPAddr())
 		pc = nil
@@ -62,19 +65,15 @@ func NewPatchClient(conn *net.TCPConn) (*Client, error) {
 
 // Send the welcome packet to a client with the copyright message and encryption vectors.
 func SendPCWelcome(client *Client) error {
-	pkt := new(PatchWelcomePkt)
-	pkt.Header.Type = PatchWelcomeType
+	pkt := new(packets.PatchWelcomePkt)
+	pkt.Header.Type = packets.PatchWelcomeType
 	pkt.Header.Size = 0x4C
 	copy(pkt.Copyright[:], PatchCopyright)
 	copy(pkt.ClientVector[:], client.ClientVector())
 	copy(pkt.ServerVector[:], client.ServerVector())
 
 	data, size := util.BytesFromStruct(pkt)
-	if config.DebugMode {
-		fmt.Println("Sending Welcome Packet")
-		util.PrintPayload(data, size)
-		fmt.Println()
-	}
+	client.log.Debug("Sending Welcome Packet")
 	return client.SendRaw(data, size)
 }
 
@@ -96,145 +95,155 @@ func (server *PatchServer) Init() error {
 	return nil
 }
 
-func (server *PatchServer) NewClient(conn *net.TCPConn) (*Client, error) {
+func (server *PatchServer) NewClient(conn net.Conn) (*Client, error) {
 	return NewPatchClient(conn)
 }
 
 func (server *PatchServer) Handle(c *Client) error {
-	var hdr PCHeader
-	util.StructFromBytes(c.Data()[:PCHeaderSize], &hdr)
+	var hdr packets.PCHeader
+	util.StructFromBytes(c.Data()[:packets.PCHeaderSize], &hdr)
 
 	var err error
 	switch hdr.Type {
-	case PatchWelcomeType:
+	case packets.PatchWelcomeType:
 		err = server.sendWelcomeAck(c)
-	case PatchLoginType:
+	case packets.PatchLoginType:
 		if err := server.sendWelcomeMessage(c); err == nil {
 			err = server.sendPatchRedirect(c)
 		}
 	default:
-		log.Infof("Received unknown packet %2x from %s", hdr.Type, c.IPAddr())
+		c.log.Infof("Received unknown packet %2x", hdr.Type)
 	}
 	return err
 }
 
 func (server *PatchServer) sendWelcomeAck(client *Client) error {
 	// PatchLoginType is treated as an ack in this case.
-	pkt := &PCHeader{
+	pkt := &packets.PCHeader{
 		Size: 0x04,
-		Type: PatchLoginType,
+		Type: packets.PatchLoginType,
 	}
 
-	DebugLog("Sending Welcome Ack")
+	client.log.Debug("Sending Welcome Ack")
 	data, _ := util.BytesFromStruct(pkt)
 	return client.SendEncrypted(data, 0x04)
 }
 
 // Message displayed on the patch download screen.
 func (server *PatchServer) sendWelcomeMessage(client *Client) error {
-	pkt := new(PatchWelcomeMessage)
-	pkt.Header = PCHeader{Size: PCHeaderSize + config.MessageSize, Type: PatchMessageType}
-	pkt.Message = config.MessageBytes
+	pkt := new(packets.PatchWelcomeMessage)
+	pkt.Message = config.WelcomeMessageBytes()
+	pkt.Header = packets.PCHeader{Size: packets.PCHeaderSize + uint16(len(pkt.Message)), Type: packets.PatchMessageType}
 
-	DebugLog("Sending Welcome Message")
+	client.log.Debug("Sending Welcome Message")
 	return EncryptAndSend(client, pkt)
 }
 
 // Send the redirect packet, providing the IP and port of the next server.
 func (server *PatchServer) sendPatchRedirect(client *Client) error {
-	pkt := new(PatchRedirectPacket)
-	pkt.Header.Type = PatchRedirectType
+	ipAddr := redirectIP(client, "data")
+	if len(ipAddr) == net.IPv6len {
+		pkt := new(packets.PatchRedirectIPv6Packet)
+		pkt.Header.Type = packets.PatchRedirectType
+		pkt.Port = server.dataRedirectPort
+		copy(pkt.IPAddr[:], ipAddr)
+
+		client.log.Debug("Sending IPv6 Patch Redirect")
+		return EncryptAndSend(client, pkt)
+	}
+	pkt := new(packets.PatchRedirectPacket)
+	pkt.Header.Type = packets.PatchRedirectType
 	pkt.Port = server.dataRedirectPort
-	hostnameBytes := config.BroadcastIP()
-	copy(pkt.IPAddr[:], hostnameBytes[:])
+	copy(pkt.IPAddr[:], ipAddr)
 
-	DebugLog("Sending Patch Redirect")
+	client.log.Debug("Sending Patch Redirect")
 	return EncryptAndSend(client, pkt)
 }
 
-// Data sub-server definition.
-type DataServer struct {
-	// File names that should be ignored when searching for patch files.
-	SkipPaths []string
-
+// PatchIndex is the set of patch files found in the patch directory. It's built
+// once and then shared by every connection, since computing the checksums means
+// reading every file in the tree.
+type PatchIndex struct {
+	tree PatchDir
 	// Each index corresponds to a patch file. This is constructed in the order
 	// that the patch tree will be traversed and makes it faster to locate a
 	// patch entry when the client sends us an index in the FileStatusPacket.
-	patchTree  PatchDir
-	patchIndex []*PatchEntry
+	entries []*PatchEntry
 }
 
-func (server DataServer) Name() string { return "DATA" }
-
-func (server DataServer) Port() string { return config.DataPort }
-
-func (server *DataServer) Init() error {
-	server.SkipPaths = []string{".", "..", ".DS_Store", ".rid"}
-
-	wd, _ := os.Getwd()
-	if err := os.Chdir(config.PatchDir); err != nil {
-		return errors.New("Unable to cd to patches directory: " + err.Error())
+// Entry returns the patch file with the specified index or nil if it doesn't exist.
+func (index *PatchIndex) Entry(id uint32) *PatchEntry {
+	if int(id) >= len(index.entries) {
+		return nil
 	}
+	return index.entries[id]
+}
 
-	// Construct our patch tree from the specified directory.
-	fmt.Printf("Loading patches from %s...\n", config.PatchDir)
-	if err := server.loadPatches(&server.patchTree, "."); err != nil {
-		return errors.New("Failed to load patches: " + err.Error())
+// LoadPatchIndex walks the patch directory and computes the size and checksum
+// of each file, skipping any whose names are in skipPaths.
+func LoadPatchIndex(patchDir string, skipPaths []string) (*PatchIndex, error) {
+	index := new(PatchIndex)
+	if err := index.loadPatches(&index.tree, patchDir, nil, skipPaths); err != nil {
+		return nil, err
 	}
-	server.buildPatchIndex(&server.patchTree)
-	if len(server.patchIndex) < 1 {
-		return errors.New("Failed: At least one patch file must be present.")
+	index.build(&index.tree)
+	if len(index.entries) < 1 {
+		return nil, errors.New("At least one patch file must be present.")
 	}
-	os.Chdir(wd)
-
-	fmt.Println()
-	return nil
+	return index, nil
 }
 
 // Recursively build the list of patch files present in the patch directory
 // to sync with the client. Files are represented in a tree, directories act
-// as nodes (PatchDir) and each keeps a list of patches/subdirectories.
-func (server *DataServer) loadPatches(node *PatchDir, path string) error {
+// as nodes (PatchDir) and each keeps a list of patches/subdirectories. dirs
+// is the path from the top of the patch tree to node.
+func (index *PatchIndex) loadPatches(node *PatchDir, path string, dirs []string, skipPaths []string) error {
 	files, err := ioutil.ReadDir(path)
 	if err != nil {
-		fmt.Printf("Couldn't parse %s\n", path)
 		return err
 	}
-	dirs := strings.Split(path, "/")
-	node.dirname = dirs[len(dirs)-1]
+	if len(dirs) == 0 {
+		node.dirname = "."
+	} else {
+		node.dirname = dirs[len(dirs)-1]
+	}
 
 	for _, file := range files {
 		filename := file.Name()
 		skip := false
-		for _, path := range server.SkipPaths {
-			if filename == path {
+		for _, skipPath := range skipPaths {
+			if filename == skipPath {
 				skip = true
 				break
 			}
 		}
+		filePath := filepath.Join(path, filename)
 
 		if skip {
 			continue
 		} else if file.IsDir() {
 			subdir := new(PatchDir)
 			node.subdirs = append(node.subdirs, subdir)
-			server.loadPatches(subdir, path+"/"+filename)
+			subdirs := append(append([]string{}, dirs...), filename)
+			if err := index.loadPatches(subdir, filePath, subdirs, skipPaths); err != nil {
+				return err
+			}
 		} else {
-			data, err := ioutil.ReadFile(path + "/" + filename)
+			checksum, err := fileChecksum(filePath)
 			if err != nil {
 				return err
 			}
 			patch := &PatchEntry{
 				filename:     filename,
-				relativePath: config.PatchDir + "/" + path + "/" + filename,
+				relativePath: filePath,
 				pathDirs:     dirs,
 				fileSize:     uint32(file.Size()),
-				checksum:     crc32.ChecksumIEEE(data),
+				checksum:     checksum,
 			}
 
 			node.patches = append(node.patches, patch)
 			fmt.Printf("%s (%d bytes, checksum: %v)\n",
-				path+"/"+filename, patch.fileSize, patch.checksum)
+				filePath, patch.fileSize, patch.checksum)
 		}
 	}
 	return nil
@@ -243,36 +252,106 @@ func (server *DataServer) loadPatches(node *PatchDir, path string) error {
 // Build the patch index, performing a depth-first search and mapping
 // each patch entry to an array so that they're quickly indexable when
 // we need to look up the patch data.
-func (server *DataServer) buildPatchIndex(node *PatchDir) {
+func (index *PatchIndex) build(node *PatchDir) {
 	for _, dir := range node.subdirs {
-		server.buildPatchIndex(dir)
+		index.build(dir)
 	}
 	for _, patch := range node.patches {
-		server.patchIndex = append(server.patchIndex, patch)
-		patch.index = uint32(len(server.patchIndex) - 1)
+		index.entries = append(index.entries, patch)
+		patch.index = uint32(len(index.entries) - 1)
+	}
+}
+
+// Compute the CRC32 of a file without reading the whole thing into memory.
+func fileChecksum(path string) (uint32, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	hash := crc32.NewIEEE()
+	if _, err := io.Copy(hash, file); err != nil {
+		return 0, err
+	}
+	return hash.Sum32(), nil
+}
+
+// Data sub-server definition.
+type DataServer struct {
+	// File names that should be ignored when searching for patch files.
+	SkipPaths []string
+
+	patchLock sync.RWMutex
+	patches   *PatchIndex
+}
+
+func (server *DataServer) Name() string { return "DATA" }
+
+func (server *DataServer) Port() string { return config.DataPort }
+
+var dataPacketSizes = map[uint16]int{
+	packets.PatchFileStatusType: packetSize(&packets.FileStatusPacket{}),
+}
+
+func (server *DataServer) MinPacketSizes() map[uint16]int { return dataPacketSizes }
+
+func (server *DataServer) Init() error {
+	server.SkipPaths = []string{".", "..", ".DS_Store", ".rid"}
+
+	// Construct our patch tree from the specified directory.
+	fmt.Printf("Loading patches from %s...\n", config.PatchDir)
+	patches, err := LoadPatchIndex(config.PatchDir, server.SkipPaths)
+	if err != nil {
+		return errors.New("Failed to load patches: " + err.Error())
+	}
+	server.patches = patches
+
+	fmt.Println()
+	return nil
+}
+
+// Reload rebuilds the patch index from the patch directory so that files can be
+// added or changed without a restart. Clients that have already logged in keep
+// using the index they were given.
+func (server *DataServer) Reload() error {
+	patches, err := LoadPatchIndex(config.PatchDir, server.SkipPaths)
+	if err != nil {
+		return err
 	}
+	server.patchLock.Lock()
+	server.patches = patches
+	server.patchLock.Unlock()
+	log.Infof("Loaded %d patch files from %s", len(patches.entries), config.PatchDir)
+	return nil
 }
 
-func (server DataServer) NewClient(conn *net.TCPConn) (*Client, error) {
+// Returns the current patch index.
+func (server *DataServer) currentPatches() *PatchIndex {
+	server.patchLock.RLock()
+	defer server.patchLock.RUnlock()
+	return server.patches
+}
+
+func (server *DataServer) NewClient(conn net.Conn) (*Client, error) {
 	return NewPatchClient(conn)
 }
 
-func (server DataServer) Handle(c *Client) error {
-	var hdr PCHeader
-	util.StructFromBytes(c.Data()[:PCHeaderSize], &hdr)
+func (server *DataServer) Handle(c *Client) error {
+	var hdr packets.PCHeader
+	util.StructFromBytes(c.Data()[:packets.PCHeaderSize], &hdr)
 
 	var err error
 	switch hdr.Type {
-	case PatchWelcomeType:
+	case packets.PatchWelcomeType:
 		err = server.sendWelcomeAck(c)
-	case PatchLoginType:
+	case packets.PatchLoginType:
 		err = server.HandlePatchLogin(c)
-	case PatchFileStatusType:
-		server.HandleFileStatus(c)
-	case PatchClientListDoneType:
+	case packets.PatchFileStatusType:
+		err = server.HandleFileStatus(c)
+	case packets.PatchClientListDoneType:
 		err = server.UpdateClientFiles(c)
 	default:
-		log.Infof("Received unknown packet %02x from %s", hdr.Type, c.IPAddr())
+		c.log.Infof("Received unknown packet %02x", hdr.Type)
 	}
 	return err
 }
@@ -280,11 +359,11 @@ func (server DataServer) Handle(c *Client) error {
 // Simple acknowledgement to the welcome response.
 func (server *DataServer) sendWelcomeAck(client *Client) error {
 	// PatchLoginType is treated as an ack in this case.
-	pkt := &PCHeader{
+	pkt := &packets.PCHeader{
 		Size: 0x04,
-		Type: PatchLoginType,
+		Type: packets.PatchLoginType,
 	}
-	DebugLog("Sending Welcome Ack")
+	client.log.Debug("Sending Welcome Ack")
 	data, _ := util.BytesFromStruct(pkt)
 	return client.SendEncrypted(data, 0x04)
 }
@@ -294,7 +373,10 @@ func (server *DataServer) HandlePatchLogin(c *Client) error {
 	if err := server.sendDataAck(c); err != nil {
 		return err
 	}
-	if err := server.sendFileList(c, &server.patchTree); err != nil {
+	// Start with a clean slate in case the client is checking its files again.
+	c.patches = server.currentPatches()
+	c.updateList = nil
+	if err := server.sendFileList(c, &c.patches.tree); err != nil {
 		return err
 	}
 	return server.sendFileListDone(c)
@@ -302,8 +384,8 @@ func (server *DataServer) HandlePatchLogin(c *Client) error {
 
 // Acknowledgement sent after the DATA connection handshake.
 func (server *DataServer) sendDataAck(client *Client) error {
-	pkt := &PCHeader{Type: PatchDataAckType, Size: 0x04}
-	DebugLog("Sending Data Ack")
+	pkt := &packets.PCHeader{Type: packets.PatchDataAckType, Size: 0x04}
+	client.log.Debug("Sending Data Ack")
 	return EncryptAndSend(client, pkt)
 }
 
@@ -333,49 +415,60 @@ func (server *DataServer) sendFileList(client *Client, node *PatchDir) error {
 
 // Tell the client to change to some directory within its file tree.
 func (server *DataServer) sendChangeDir(client *Client, dir string) error {
-	pkt := new(ChangeDirPacket)
-	pkt.Header.Type = PatchChangeDirType
+	pkt := new(packets.ChangeDirPacket)
+	pkt.Header.Type = packets.PatchChangeDirType
 	copy(pkt.Dirname[:], dir)
 
-	DebugLog("Sending Change Directory")
+	client.log.Debug("Sending Change Directory")
 	return EncryptAndSend(client, pkt)
 }
 
 // Tell the client to change to one directory above.
 func (server *DataServer) sendDirAbove(client *Client) error {
-	pkt := &PCHeader{Type: PatchDirAboveType, Size: 0x04}
-	DebugLog("Sending Dir Above")
+	pkt := &packets.PCHeader{Type: packets.PatchDirAboveType, Size: 0x04}
+	client.log.Debug("Sending Dir Above")
 	return EncryptAndSend(client, pkt)
 }
 
 // Inform the client that we've finished sending the patch list.
 func (server *DataServer) sendFileListDone(client *Client) error {
-	pkt := &PCHeader{Type: PatchFileListDoneType, Size: 0x04}
-	DebugLog("Sending List Done")
+	pkt := &packets.PCHeader{Type: packets.PatchFileListDoneType, Size: 0x04}
+	client.log.Debug("Sending List Done")
 	return EncryptAndSend(client, pkt)
 }
 
 // Tell the client to check a file in its current working directory.
 func (server *DataServer) sendCheckFile(client *Client, index uint32, filename string) error {
-	pkt := new(CheckFilePacket)
-	pkt.Header.Type = PatchCheckFileType
+	pkt := new(packets.CheckFilePacket)
+	pkt.Header.Type = packets.PatchCheckFileType
 	pkt.PatchId = index
 	copy(pkt.Filename[:], filename)
 
-	DebugLog("Sending Check File")
+	client.log.Debug("Sending Check File")
 	return EncryptAndSend(client, pkt)
 }
 
 // The client sent us a checksum for one of the patch files. Compare it to what we
 // have and add it to the list of files to update if there is any discrepancy.
-func (server *DataServer) HandleFileStatus(client *Client) {
-	var fileStatus FileStatusPacket
-	util.StructFromBytes(client.Data(), &fileStatus)
+func (server *DataServer) HandleFileStatus(client *Client) error {
+	var fileStatus packets.FileStatusPacket
+	if err := client.Decode(&fileStatus); err != nil {
+		return err
+	}
 
-	patch := server.patchIndex[fileStatus.PatchId]
+	if client.patches == nil {
+		client.log.Warn("Client sent a file status before logging in")
+		return nil
+	}
+	patch := client.patches.Entry(fileStatus.PatchId)
+	if patch == nil {
+		client.log.Warnf("Client sent status for unknown patch %d", fileStatus.PatchId)
+		return nil
+	}
 	if fileStatus.Checksum != patch.checksum || fileStatus.FileSize != patch.fileSize {
 		client.updateList = append(client.updateList, patch)
 	}
+	return nil
 }
 
 // The client finished sending all of the file check packets. If they have
@@ -389,98 +482,119 @@ func (server *DataServer) UpdateClientFiles(client *Client) error {
 
 	// Send files, if we have any.
 	if numFiles > 0 {
-		server.sendUpdateFiles(client, numFiles, totalSize)
-		server.sendChangeDir(client, ".")
+		if err := server.sendUpdateFiles(client, numFiles, totalSize); err != nil {
+			return err
+		}
+		if err := server.sendChangeDir(client, "."); err != nil {
+			return err
+		}
 		chunkBuf := make([]byte, MaxFileChunkSize)
 
 		for _, patch := range client.updateList {
 			// Descend into the correct directory if needed.
-			ascendCtr := 0
-			for i := 1; i < len(patch.pathDirs); i++ {
-				ascendCtr++
-				server.sendChangeDir(client, patch.pathDirs[i])
+			for _, dir := range patch.pathDirs {
+				if err := server.sendChangeDir(client, dir); err != nil {
+					return err
+				}
 			}
-			server.sendFileHeader(client, patch)
-
-			// Divide the file into chunks and send each one.
-			chunks := int((patch.fileSize / MaxFileChunkSize) + 1)
-			file, err := os.Open(patch.relativePath)
-			if err != nil {
-				// Critical since this is most likely a filesystem error.
-				log.Error(err.Error())
+			if err := server.sendFile(client, patch, chunkBuf); err != nil {
 				return err
 			}
-			for i := 0; i < chunks; i++ {
-				bytes, err := file.ReadAt(chunkBuf, int64(MaxFileChunkSize*i))
-				if err != nil && err != io.EOF {
+			// Change back to the top level directory.
+			for range patch.pathDirs {
+				if err := server.sendDirAbove(client); err != nil {
 					return err
 				}
-				chksm := crc32.ChecksumIEEE(chunkBuf)
-				server.sendFileChunk(client, uint32(i), chksm, uint32(bytes), chunkBuf)
-			}
-
-			server.sendFileComplete(client)
-			// Change back to the top level directory.
-			for ascendCtr > 0 {
-				ascendCtr--
-				server.sendDirAbove(client)
 			}
 		}
+		client.updateList = nil
 	}
 	return server.sendUpdateComplete(client)
 }
 
+// Divide the file into chunks of at most MaxFileChunkSize and send each one.
+func (server *DataServer) sendFile(client *Client, patch *PatchEntry, chunkBuf []byte) error {
+	file, err := os.Open(patch.relativePath)
+	if err != nil {
+		// Critical since this is most likely a filesystem error.
+		client.log.Error(err.Error())
+		return err
+	}
+	defer file.Close()
+
+	if err := server.sendFileHeader(client, patch); err != nil {
+		return err
+	}
+	for chunk := uint32(0); ; chunk++ {
+		bytes, err := io.ReadFull(file, chunkBuf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		if bytes == 0 && chunk > 0 {
+			break
+		}
+		chksm := crc32.ChecksumIEEE(chunkBuf[:bytes])
+		if err := server.sendFileChunk(client, chunk, chksm, uint32(bytes), chunkBuf); err != nil {
+			return err
+		}
+		if bytes < len(chunkBuf) {
+			break
+		}
+	}
+	return server.sendFileComplete(client)
+}
+
 // Send the total number and cumulative size of files that need updating.
 func (server *DataServer) sendUpdateFiles(client *Client, num, totalSize uint32) error {
-	pkt := new(UpdateFilesPacket)
-	pkt.Header.Type = PatchUpdateFilesType
+	pkt := new(packets.UpdateFilesPacket)
+	pkt.Header.Type = packets.PatchUpdateFilesType
 	pkt.NumFiles = num
 	pkt.TotalSize = totalSize
 
-	DebugLog("Sending Update Files")
+	client.log.Debug("Sending Update Files")
 	return EncryptAndSend(client, pkt)
 }
 
 // Send the header for a file we're about to update.
 func (server *DataServer) sendFileHeader(client *Client, patch *PatchEntry) error {
-	pkt := new(FileHeaderPacket)
-	pkt.Header.Type = PatchFileHeaderType
+	pkt := new(packets.FileHeaderPacket)
+	pkt.Header.Type = packets.PatchFileHeaderType
 	pkt.FileSize = patch.fileSize
 	copy(pkt.Filename[:], patch.filename)
 
-	DebugLog("Sending File Header")
+	client.log.Debug("Sending File Header")
 	return EncryptAndSend(client, pkt)
 }
 
 // Send a chunk of file data.
 func (server *DataServer) sendFileChunk(client *Client, chunk, chksm, chunkSize uint32, fdata []byte) error {
 	if chunkSize > MaxFileChunkSize {
-		log.Errorf("Attempted to send %v byte chunk; max is %v",
-			string(chunkSize), string(MaxFileChunkSize))
+		client.log.Errorf("Attempted to send %v byte chunk; max is %v",
+			chunkSize, MaxFileChunkSize)
 		panic(errors.New("File chunk size exceeds maximum"))
 	}
-	pkt := &FileChunkPacket{
-		Header:   PCHeader{Type: PatchFileChunkType},
+	pkt := &packets.FileChunkPacket{
+		Header:   packets.PCHeader{Type: packets.PatchFileChunkType},
 		Chunk:    chunk,
 		Checksum: chksm,
 		Size:     chunkSize,
 		Data:     fdata[:chunkSize],
 	}
 
-	DebugLog("Sending File Chunk")
+	client.log.Debug("Sending File Chunk")
 	return EncryptAndSend(client, pkt)
 }
 
 // Finished sending a particular file.
 func (server *DataServer) sendFileComplete(client *Client) error {
-	pkt := &PCHeader{Type: PatchFileCompleteType, Size: 0x04}
-	DebugLog("Sending File Complete")
+	pkt := &packets.PCHeader{Type: packets.PatchFileCompleteType, Size: 0x04}
+	client.log.Debug("Sending File Complete")
 	return EncryptAndSend(client, pkt)
 }
 
 // We've finished updating files.
 func (server *DataServer) sendUpdateComplete(client *Client) error {
-	pkt := &PCHeader{Type: PatchUpdateCompleteType, Size: 0x04}
-	DebugLog("Sending File Update Done")
+	pkt := &packets.PCHeader{Type: packets.PatchUpdateCompleteType, Size: 0x04}
+	client.log.Debug("Sending File Update Done")
 	return EncryptAndSend(client, pkt)
 }
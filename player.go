@@ -0,0 +1,164 @@
+// Builds the structures (see packets/character.go) that describe a player's
+// character to the other players.
+package main
+
+import (
+	"encoding/binary"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/dcrodman/archon/data"
+	"github.com/dcrodman/archon/packets"
+	"github.com/dcrodman/archon/util"
+)
+
+const (
+	// Flag set on items that the player has equipped.
+	ItemEquipped = 0x08
+	// Item ids assigned to a new character's starting items begin here.
+	FirstItemId = 0x00010000
+)
+
+// Build the inventory sent to other clients from a character's saved items.
+func newInventory(items []data.Item) packets.Inventory {
+	inv := packets.Inventory{Language: 0x01}
+	for i, item := range items {
+		if i >= len(inv.Items) {
+			break
+		}
+		entry := &inv.Items[i]
+		entry.Present = 0x01
+		entry.Flags = item.Flags
+		entry.Item = newItemData(item)
+		inv.NumItems++
+	}
+	return inv
+}
+
+func newItemData(item data.Item) packets.ItemData {
+	itemData := packets.ItemData{ItemId: item.ItemId}
+	copy(itemData.Data[:], item.Data)
+	copy(itemData.Data2[:], item.Data2)
+	return itemData
+}
+
+// Build the display data for a character loaded from the database.
+func newPlayerDispData(character *data.Character) packets.PlayerDispData {
+	disp := packets.PlayerDispData{
+		Stats: packets.CharacterStats{
+			ATP: character.ATP,
+			MST: character.MST,
+			EVP: character.EVP,
+			HP:  character.HP,
+			DFP: character.DFP,
+			ATA: character.ATA,
+			LCK: character.LCK,
+		},
+		Level:          character.Level,
+		Experience:     character.Experience,
+		Meseta:         character.Meseta,
+		NameColor:      character.NameColor,
+		Model:          character.Model,
+		NameColorChksm: character.NameColorChecksum,
+		SectionID:      character.SectionID,
+		Class:          character.Class,
+		V2Flags:        character.V2Flags,
+		Version:        character.Version,
+		V1Flags:        character.V1Flags,
+		Costume:        character.Costume,
+		Skin:           character.Skin,
+		Face:           character.Face,
+		Head:           character.Head,
+		Hair:           character.Hair,
+		HairRed:        character.HairRed,
+		HairGreen:      character.HairGreen,
+		HairBlue:       character.HairBlue,
+		PropX:          character.ProportionX,
+		PropY:          character.ProportionY,
+		Playtime:       character.Playtime,
+	}
+	copy(disp.GuildcardStr[:], character.GuildcardStr)
+	copyUtf16(disp.Name[:], character.Name)
+	return disp
+}
+
+// Convert saved technique levels into the zero-based levels sent to the client.
+func newTechniqueLevels(techniques data.Techniques) [0x14]byte {
+	var levels [0x14]byte
+	for i := range levels {
+		levels[i] = TechniqueNotLearned
+	}
+	for tech, level := range techniques {
+		if level > 0 {
+			levels[tech] = level - 1
+		}
+	}
+	return levels
+}
+
+// Copy a UTF-16LE string stored as bytes into a fixed-length array of characters.
+func copyUtf16(dst []uint16, src []byte) {
+	for i := 0; i < len(dst) && i*2+1 < len(src); i++ {
+		dst[i] = binary.LittleEndian.Uint16(src[i*2:])
+	}
+}
+
+// Build the bank section of the full character data.
+func newCharacterBank(bank *data.Bank) packets.CharacterBank {
+	charBank := packets.CharacterBank{Meseta: bank.Meseta}
+	for i, item := range bank.Items {
+		if i >= len(charBank.Items) {
+			break
+		}
+		charBank.Items[i] = packets.BankItem{
+			Item:    newItemData(item),
+			Amount:  uint16(itemAmount(item)),
+			Present: 0x01,
+		}
+		charBank.NumItems++
+	}
+	return charBank
+}
+
+// Build the battle mode section of the full character data.
+func newBattleRecords(record *data.BattleRecord) packets.BattleRecords {
+	var records packets.BattleRecords
+	for i := range records.PlaceCounts {
+		records.PlaceCounts[i] = clampUint16(record.Places[i])
+		records.DisconnectCounts[i] = clampUint16(record.Disconnects[i])
+	}
+	return records
+}
+
+func clampUint16(n uint32) uint16 {
+	if n > 0xFFFF {
+		return 0xFFFF
+	}
+	return uint16(n)
+}
+
+// Build the challenge mode section of the full character data.
+func newChallengeRecords(record *data.ChallengeRecord) packets.ChallengeRecords {
+	records := packets.ChallengeRecords{
+		TitleColor: record.TitleColor,
+		TimesEp1:   record.TimesEp1,
+		TimesEp2:   record.TimesEp2,
+		Awards:     record.Awards,
+	}
+	if record.Title != "" {
+		copyUtf16(records.RankTitle[:], util.ConvertToUtf16("\tE"+record.Title))
+	}
+	return records
+}
+
+// Returns a character's name as a string, without the language marker that the
+// client puts in front of it.
+func characterName(character *data.Character) string {
+	var name [16]uint16
+	copyUtf16(name[:], character.Name)
+	s := strings.TrimRight(string(utf16.Decode(name[:])), "\x00")
+	if len(s) >= 2 && s[0] == '\t' {
+		s = s[2:]
+	}
+	return s
+}
@@ -0,0 +1,397 @@
+/*
+* Stores for the registry of players who are online on every ship (see
+* online.go). By default each ship keeps its own copy in memory, built from the
+* reports relayed by the shipgate. Ships that share a Redis server can instead
+* keep the registry there, in which case each ship only writes its own players
+* and everyone (the dashboard included) reads the same data. Entries in Redis
+* expire unless the ship that wrote them keeps refreshing them, so the players
+* on a ship that goes down don't linger. Each ship writes its players' entries
+* again when it refreshes them, so that they come back if Redis is restarted or
+* flushed.
+ */
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf16"
+
+	"github.com/dcrodman/archon/util"
+	"github.com/gomodule/redigo/redis"
+)
+
+// Settings for presence_backend.
+const (
+	PresenceBackendMemory = "memory"
+	PresenceBackendRedis  = "redis"
+)
+
+const (
+	// Prefix of the keys under which the players are kept in Redis.
+	presenceKeyPrefix = "archon:online:"
+	// How long an entry in Redis lasts without being refreshed.
+	presenceTTL = 90 * time.Second
+)
+
+// A player on one of the ships.
+type onlinePlayer struct {
+	ShipgatePlayerPacket
+}
+
+// Returns true if the player is on one of the ship's blocks, going by the
+// address of their block. Ship ids can't be used for this since each server
+// numbers the ships it knows about itself.
+func (p onlinePlayer) onShip(s *Ship) bool {
+	return p.IPAddr == s.ipAddr && p.Port-uint16(p.Block) == s.port
+}
+
+// Returns true if the two reports are from the same ship.
+func (p onlinePlayer) sameShip(other onlinePlayer) bool {
+	return p.IPAddr == other.IPAddr && p.Port-uint16(p.Block) == other.Port-uint16(other.Block)
+}
+
+// presenceStore keeps track of the players who are online on all of the ships.
+type presenceStore interface {
+	// Update records a report that a player logged on or moved, or that they
+	// logged off if Block is 0. A late report from a ship that the player has
+	// since left doesn't clear them.
+	Update(p onlinePlayer)
+	// Find returns the player with a guildcard if they're online on any ship.
+	Find(guildcard uint32) (onlinePlayer, bool)
+	List() []onlinePlayer
+	// Remove drops the players for which drop returns true, returning them.
+	Remove(drop func(p onlinePlayer) bool) []onlinePlayer
+	// Shared returns true if the other ships use the same store, in which case
+	// each ship only records the players on its own blocks.
+	Shared() bool
+}
+
+// Create the store for the configured presence_backend.
+func newPresenceStore() (presenceStore, error) {
+	if config.PresenceBackend != PresenceBackendRedis {
+		return newMemoryPresence(), nil
+	}
+	store := &redisPresence{
+		pool:  newRedisPool(config.RedisAddress, config.RedisPassword, config.RedisDB),
+		local: make(map[uint32]onlinePlayer),
+	}
+	conn := store.pool.Get()
+	defer conn.Close()
+	if _, err := conn.Do("PING"); err != nil {
+		return nil, errors.New("Failed to connect to Redis at " + config.RedisAddress + ": " + err.Error())
+	}
+	go store.refresh()
+	return store, nil
+}
+
+// Synchronized set of the players online, keyed by guildcard.
+type memoryPresence struct {
+	players map[uint32]onlinePlayer
+	sync.RWMutex
+}
+
+func newMemoryPresence() *memoryPresence {
+	return &memoryPresence{players: make(map[uint32]onlinePlayer)}
+}
+
+func (m *memoryPresence) Update(p onlinePlayer) {
+	m.Lock()
+	defer m.Unlock()
+	if p.Block == 0 {
+		if current, ok := m.players[p.Guildcard]; ok && current.sameShip(p) {
+			delete(m.players, p.Guildcard)
+		}
+		return
+	}
+	m.players[p.Guildcard] = p
+}
+
+func (m *memoryPresence) Find(guildcard uint32) (onlinePlayer, bool) {
+	m.RLock()
+	defer m.RUnlock()
+	p, ok := m.players[guildcard]
+	return p, ok
+}
+
+func (m *memoryPresence) List() []onlinePlayer {
+	m.RLock()
+	defer m.RUnlock()
+	list := make([]onlinePlayer, 0, len(m.players))
+	for _, p := range m.players {
+		list = append(list, p)
+	}
+	return list
+}
+
+func (m *memoryPresence) Remove(drop func(p onlinePlayer) bool) []onlinePlayer {
+	m.Lock()
+	defer m.Unlock()
+	var removed []onlinePlayer
+	for guildcard, p := range m.players {
+		if drop(p) {
+			delete(m.players, guildcard)
+			removed = append(removed, p)
+		}
+	}
+	return removed
+}
+
+func (m *memoryPresence) Shared() bool { return false }
+
+// Players kept in Redis as JSON under presenceKeyPrefix and their guildcard.
+// Failures to reach Redis are logged and treated as if nobody were online so
+// that they don't hold up the block servers.
+type redisPresence struct {
+	pool *redis.Pool
+	// The entries this ship has written for its own players, to be written
+	// again by refresh.
+	local     map[uint32]onlinePlayer
+	localLock sync.Mutex
+}
+
+// Returns a pool of connections to the Redis server at address, which is also
+// used for the cache (see cache.go).
+func newRedisPool(address, password string, db int) *redis.Pool {
+	return &redis.Pool{
+		MaxIdle:     4,
+		IdleTimeout: 5 * time.Minute,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", address,
+				redis.DialPassword(password),
+				redis.DialDatabase(db),
+				redis.DialConnectTimeout(5*time.Second),
+				redis.DialReadTimeout(5*time.Second),
+				redis.DialWriteTimeout(5*time.Second),
+			)
+		},
+	}
+}
+
+func presenceKey(guildcard uint32) string {
+	return presenceKeyPrefix + strconv.FormatUint(uint64(guildcard), 10)
+}
+
+func (r *redisPresence) Update(p onlinePlayer) {
+	if localShip != nil && p.onShip(localShip) {
+		r.localLock.Lock()
+		if p.Block == 0 {
+			delete(r.local, p.Guildcard)
+		} else {
+			r.local[p.Guildcard] = p
+		}
+		r.localLock.Unlock()
+	}
+
+	conn := r.pool.Get()
+	defer conn.Close()
+	if p.Block == 0 {
+		if err := r.removeFromShip(conn, p); err != nil {
+			log.Warn("Failed to remove player from Redis: " + err.Error())
+		}
+		return
+	}
+	r.set(conn, p)
+}
+
+// Remove the player's entry if it's from the same ship as p. The key is watched
+// while it's checked so that an entry written for the player by another ship
+// in the meantime isn't removed with it.
+func (r *redisPresence) removeFromShip(conn redis.Conn, p onlinePlayer) error {
+	key := presenceKey(p.Guildcard)
+	for attempt := 0; attempt < 3; attempt++ {
+		if _, err := conn.Do("WATCH", key); err != nil {
+			return err
+		}
+		if current, ok := r.get(conn, p.Guildcard); !ok || !current.sameShip(p) {
+			_, err := conn.Do("UNWATCH")
+			return err
+		}
+		conn.Send("MULTI")
+		conn.Send("DEL", key)
+		reply, err := conn.Do("EXEC")
+		if err != nil {
+			return err
+		} else if reply != nil {
+			return nil
+		}
+		// The entry changed after it was checked, so check it again.
+	}
+	return errors.New("entry for guildcard " + strconv.FormatUint(uint64(p.Guildcard), 10) + " kept changing")
+}
+
+// Write the player's entry, which expires unless it's written again.
+func (r *redisPresence) set(conn redis.Conn, p onlinePlayer) {
+	value, err := json.Marshal(newPresenceRecord(p))
+	if err != nil {
+		log.Warn("Failed to encode player for Redis: " + err.Error())
+		return
+	}
+	_, err = conn.Do("SET", presenceKey(p.Guildcard), value, "PX", int64(presenceTTL/time.Millisecond))
+	if err != nil {
+		log.Warn("Failed to save player to Redis: " + err.Error())
+	}
+}
+
+func (r *redisPresence) Find(guildcard uint32) (onlinePlayer, bool) {
+	conn := r.pool.Get()
+	defer conn.Close()
+	return r.get(conn, guildcard)
+}
+
+func (r *redisPresence) get(conn redis.Conn, guildcard uint32) (onlinePlayer, bool) {
+	value, err := redis.Bytes(conn.Do("GET", presenceKey(guildcard)))
+	if err == redis.ErrNil {
+		return onlinePlayer{}, false
+	} else if err != nil {
+		log.Warn("Failed to look up player in Redis: " + err.Error())
+		return onlinePlayer{}, false
+	}
+	return decodePresence(value)
+}
+
+func (r *redisPresence) List() []onlinePlayer {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	var keys []interface{}
+	cursor := 0
+	for {
+		values, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", presenceKeyPrefix+"*", "COUNT", 100))
+		if err != nil {
+			log.Warn("Failed to list players in Redis: " + err.Error())
+			return nil
+		}
+		var batch []string
+		if _, err := redis.Scan(values, &cursor, &batch); err != nil {
+			log.Warn("Failed to list players in Redis: " + err.Error())
+			return nil
+		}
+		for _, key := range batch {
+			keys = append(keys, key)
+		}
+		if cursor == 0 {
+			break
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	values, err := redis.ByteSlices(conn.Do("MGET", keys...))
+	if err != nil {
+		log.Warn("Failed to list players in Redis: " + err.Error())
+		return nil
+	}
+	var list []onlinePlayer
+	for _, value := range values {
+		// Entries that expired since the scan come back empty.
+		if p, ok := decodePresence(value); ok {
+			list = append(list, p)
+		}
+	}
+	return list
+}
+
+func (r *redisPresence) Remove(drop func(p onlinePlayer) bool) []onlinePlayer {
+	var removed []onlinePlayer
+	for _, p := range r.List() {
+		if drop(p) {
+			removed = append(removed, p)
+		}
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+	conn := r.pool.Get()
+	defer conn.Close()
+	keys := make([]interface{}, len(removed))
+	for i, p := range removed {
+		keys[i] = presenceKey(p.Guildcard)
+	}
+	if _, err := conn.Do("DEL", keys...); err != nil {
+		log.Warn("Failed to remove players from Redis: " + err.Error())
+	}
+	return removed
+}
+
+func (r *redisPresence) Shared() bool { return true }
+
+// Keep the entries for the players on this ship from expiring, writing them
+// again in case they've been lost. The lock is held throughout so that an entry
+// can't be written again after the player has logged off.
+func (r *redisPresence) refresh() {
+	for range time.Tick(presenceTTL / 3) {
+		conn := r.pool.Get()
+		r.localLock.Lock()
+		for _, p := range r.local {
+			r.set(conn, p)
+		}
+		r.localLock.Unlock()
+		conn.Close()
+	}
+}
+
+// How a player is kept in Redis and listed by the admin API.
+type presenceRecord struct {
+	Guildcard uint32 `json:"guildcard"`
+	Name      string `json:"name"`
+	Ship      string `json:"ship"`
+	// Address of the player's block.
+	Address string `json:"address"`
+	Block   uint32 `json:"block"`
+	// Counting from 1, or 0 if the player is in a game.
+	Lobby uint16    `json:"lobby"`
+	Since time.Time `json:"since"`
+}
+
+func newPresenceRecord(p onlinePlayer) presenceRecord {
+	name := string(utf16.Decode(trimUtf16(p.Name[:])))
+	// Drop the language marker that names start with.
+	if len(name) >= 2 && name[0] == '\t' {
+		name = name[2:]
+	}
+	return presenceRecord{
+		Guildcard: p.Guildcard,
+		Name:      name,
+		Ship:      string(util.StripPadding(p.ShipName[:])),
+		Address:   net.JoinHostPort(net.IP(p.IPAddr[:]).String(), strconv.Itoa(int(p.Port))),
+		Block:     p.Block,
+		Lobby:     p.Lobby,
+		Since:     time.Unix(p.Since, 0).UTC(),
+	}
+}
+
+// Returns the player kept in Redis as value, or false if it isn't a valid entry.
+func decodePresence(value []byte) (onlinePlayer, bool) {
+	var record presenceRecord
+	if len(value) == 0 || json.Unmarshal(value, &record) != nil {
+		return onlinePlayer{}, false
+	}
+	host, port, err := net.SplitHostPort(record.Address)
+	if err != nil {
+		return onlinePlayer{}, false
+	}
+	portNum, err := strconv.ParseUint(port, 10, 16)
+	ip := net.ParseIP(host).To4()
+	if err != nil || ip == nil {
+		return onlinePlayer{}, false
+	}
+
+	p := onlinePlayer{ShipgatePlayerPacket{
+		Header:    ShipgateHeader{Type: ShipgatePlayerType},
+		Guildcard: record.Guildcard,
+		Block:     record.Block,
+		Port:      uint16(portNum),
+		Lobby:     record.Lobby,
+		Since:     record.Since.Unix(),
+	}}
+	copy(p.IPAddr[:], ip)
+	copy(p.ShipName[:], record.Ship)
+	// The marker is put back so that the name shows up the same as a local one.
+	copy(p.Name[:], utf16.Encode([]rune("\tE"+record.Name)))
+	return p, true
+}
@@ -0,0 +1,256 @@
+/*
+* Account privilege tiers: player, tester, GM, admin, and root, each of which can
+* do everything the ones below it can. The tier is loaded when the player logs
+* in and checked before running the GM chat commands (see command.go). Operators
+* using the dashboard and the debug pages sign in with their accounts, which are
+* checked against the tier needed for the page.
+*
+* Operators sign in the same way that players log in: with their password,
+* followed by the current code if they have two-factor authentication turned
+* on, and not if the account is banned. Since a code can only be used once,
+* signing in starts a session kept in a cookie for operatorSessionTimeout, and
+* the account is checked for bans again with each request.
+ */
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dcrodman/archon/data"
+)
+
+const (
+	// How long operators stay signed in to the dashboard and debug pages.
+	operatorSessionTimeout = 12 * time.Hour
+	operatorCookieName     = "archon_operator"
+)
+
+// Names of the privilege tiers, indexed by level.
+var privilegeNames = []string{
+	data.PrivilegePlayer: "player",
+	data.PrivilegeTester: "tester",
+	data.PrivilegeGM:     "gm",
+	data.PrivilegeAdmin:  "admin",
+	data.PrivilegeRoot:   "root",
+}
+
+// Returns the level of the privilege tier with a name.
+func parsePrivilege(name string) (byte, bool) {
+	for level, n := range privilegeNames {
+		if strings.EqualFold(name, n) {
+			return byte(level), true
+		}
+	}
+	return 0, false
+}
+
+func privilegeName(level byte) string {
+	if int(level) < len(privilegeNames) {
+		return privilegeNames[level]
+	}
+	return fmt.Sprintf("level %d", level)
+}
+
+// Returns whether the player on c has at least the privilege tier level.
+func (c *Client) hasPrivilege(level byte) bool {
+	return c.privilegeLevel >= level
+}
+
+// Only let accounts with at least the privilege tier returned by level for the
+// request through. The browser asks for the username and password and sends
+// them with each request until the operator has a session. Since it sends them
+// no matter which site the request came from, requests other than GETs have to
+// come from the page itself.
+func requirePrivilege(realm string, level func(req *http.Request) byte, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !sameOrigin(req) {
+			log.Warnf("Refused %s request for %s from another site: %s", realm,
+				req.URL.Path, req.Header.Get("Origin"))
+			writeError(w, http.StatusForbidden, "cross-site requests aren't allowed")
+			return
+		}
+		account, err := operatorAccount(w, req)
+		if err != nil {
+			log.Error("Failed to look up account: " + err.Error())
+			writeError(w, http.StatusInternalServerError, "unable to look up account")
+			return
+		}
+		if account == nil {
+			if username, _, ok := req.BasicAuth(); ok {
+				log.Warnf("Rejected %s login for %s from %s", realm, username, req.RemoteAddr)
+			}
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", config.ShipName+" "+realm))
+			writeError(w, http.StatusUnauthorized, "invalid username or password")
+			return
+		}
+		if needed := level(req); account.PrivilegeLevel < needed {
+			log.Warnf("Refused %s request for %s from %s: %s privileges needed", realm,
+				req.URL.Path, account.Username, privilegeName(needed))
+			writeError(w, http.StatusForbidden, privilegeName(needed)+" privileges are needed")
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// Returns whether req came from a page served from the same host, going by the
+// Origin header that browsers send with requests other than GETs (or the
+// Referer if they don't). Requests with neither aren't from a browser.
+func sameOrigin(req *http.Request) bool {
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		return true
+	}
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		origin = req.Header.Get("Referer")
+	}
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, req.Host)
+}
+
+// Returns the account of the operator making req, who has either signed in
+// already or is signing in with the username and password in the request.
+// Signing in starts a session, whose cookie is set on w. Returns nil if they
+// haven't signed in, or if their account has been deactivated or banned.
+func operatorAccount(w http.ResponseWriter, req *http.Request) (*data.Account, error) {
+	if cookie, err := req.Cookie(operatorCookieName); err == nil {
+		if username, ok := operatorSessions.Find(cookie.Value); ok {
+			account, err := database.FindAccount(username)
+			if err != nil || account == nil {
+				return nil, err
+			}
+			return allowedOperator(account, req)
+		}
+	}
+
+	username, password, ok := req.BasicAuth()
+	if !ok {
+		return nil, nil
+	}
+	account, err := database.FindAccount(username)
+	if err != nil || account == nil {
+		return nil, err
+	}
+	twoFactor, err := database.FindTwoFactor(username)
+	if err != nil {
+		return nil, err
+	}
+	twoFactorEnabled := twoFactor != nil && twoFactor.Enabled
+	var code string
+	if twoFactorEnabled {
+		password, code = splitTwoFactorCode(password)
+	}
+	if !checkPassword(account.Password, password) {
+		return nil, nil
+	}
+	if twoFactorEnabled {
+		if !checkTOTP(twoFactor, code, time.Now()) {
+			return nil, nil
+		} else if err = database.UpdateTwoFactor(twoFactor); err == data.ErrTwoFactorStepUsed {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if account, err = allowedOperator(account, req); account == nil {
+		return nil, err
+	}
+
+	token, err := operatorSessions.Start(account.Username)
+	if err != nil {
+		return nil, err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     operatorCookieName,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(operatorSessionTimeout.Seconds()),
+		Secure:   req.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return account, nil
+}
+
+// Returns account if the operator making req can still use it, or nil if it's
+// been deactivated or banned.
+func allowedOperator(account *data.Account, req *http.Request) (*data.Account, error) {
+	if !account.Active {
+		return nil, nil
+	}
+	ip, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		ip = req.RemoteAddr
+	}
+	ban, err := database.FindActiveBan(accountBanTargets(uint32(account.Guildcard), account.Username, ip, ""))
+	if err != nil || ban != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+type operatorSession struct {
+	username string
+	expires  time.Time
+}
+
+// Synchronized sessions of the signed in operators, by the hash of their token.
+type operatorSessionList struct {
+	sessions map[string]operatorSession
+	sync.Mutex
+}
+
+var operatorSessions = &operatorSessionList{sessions: make(map[string]operatorSession)}
+
+func hashOperatorToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Start a session for the operator signed in to the account with username and
+// return its token.
+func (l *operatorSessionList) Start(username string) (string, error) {
+	var token [16]byte
+	if _, err := rand.Read(token[:]); err != nil {
+		return "", err
+	}
+	encoded := hex.EncodeToString(token[:])
+	now := time.Now()
+
+	l.Lock()
+	defer l.Unlock()
+	for hash, session := range l.sessions {
+		if now.After(session.expires) {
+			delete(l.sessions, hash)
+		}
+	}
+	l.sessions[hashOperatorToken(encoded)] = operatorSession{
+		username: username,
+		expires:  now.Add(operatorSessionTimeout),
+	}
+	return encoded, nil
+}
+
+// Find returns the username of the operator with a session token, if it hasn't
+// expired.
+func (l *operatorSessionList) Find(token string) (string, bool) {
+	l.Lock()
+	defer l.Unlock()
+	session, ok := l.sessions[hashOperatorToken(token)]
+	if !ok || time.Now().After(session.expires) {
+		return "", false
+	}
+	return session.username, true
+}
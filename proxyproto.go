@@ -0,0 +1,221 @@
+/*
+* PROXY protocol support, for running behind a TCP load balancer or tunnel
+* such as HAProxy. The proxy sends a header (in the text format of version 1 or
+* the binary format of version 2) ahead of the client's data with the address
+* of the client that it's connecting for, which is used in place of the
+* proxy's own for logging, rate limiting, and IP bans.
+*
+* Headers are only expected from the addresses in proxy_protocol.trusted_proxies.
+* Connections from anywhere else are used as they are, since otherwise anyone
+* could claim to be connecting from any address.
+ */
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Longest allowed version 1 header, including the CRLF.
+	proxyV1MaxLength = 107
+	// How long a proxy has to send the header before the connection is dropped.
+	proxyHeaderTimeout = 5 * time.Second
+
+	proxyV2Version    = 0x2
+	proxyV2CmdLocal   = 0x0
+	proxyV2CmdProxy   = 0x1
+	proxyV2FamilyIPv4 = 0x1
+	proxyV2FamilyIPv6 = 0x2
+)
+
+// Every version 2 header starts with this.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// Returns whether a connection from addr should start with a PROXY header.
+func proxyTrusted(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !config.ProxyProtocolEnabled || !ok {
+		return false
+	}
+	for _, network := range config.trustedProxies {
+		if network.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reads the PROXY header from conn and returns the address of the client that
+// the proxy is connecting for, or the proxy's own address if it's connecting on
+// its own behalf (as it does for health checks). Nothing after the header is
+// read, so the connection can be handed on as it is.
+func readProxyHeader(conn net.Conn) (net.Addr, error) {
+	conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	// Long enough for the version 2 signature, and shorter than any version 1
+	// header, so that nothing past the header is read.
+	prefix := make([]byte, len(proxyV2Signature))
+	if _, err := io.ReadFull(conn, prefix); err != nil {
+		return nil, errors.New("no PROXY header: " + err.Error())
+	}
+	var addr net.Addr
+	var err error
+	if bytes.Equal(prefix, proxyV2Signature) {
+		addr, err = readProxyV2(conn)
+	} else if bytes.HasPrefix(prefix, []byte("PROXY ")) {
+		addr, err = readProxyV1(conn, prefix)
+	} else {
+		err = errors.New("no PROXY header")
+	}
+	if err == nil && addr == nil {
+		addr = conn.RemoteAddr()
+	}
+	return addr, err
+}
+
+// Read the rest of a version 1 header, which is a line such as
+// "PROXY TCP4 <source> <destination> <source port> <destination port>\r\n".
+func readProxyV1(r io.Reader, prefix []byte) (net.Addr, error) {
+	line := append(make([]byte, 0, proxyV1MaxLength), prefix...)
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) == proxyV1MaxLength {
+			return nil, errors.New("PROXY header is too long")
+		} else if _, err := io.ReadFull(r, b); err != nil {
+			return nil, errors.New("incomplete PROXY header: " + err.Error())
+		}
+		line = append(line, b[0])
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	} else if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errors.New("malformed PROXY header")
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, errors.New("malformed PROXY header")
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// Read the rest of a version 2 header after the signature.
+func readProxyV2(r io.Reader) (net.Addr, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, errors.New("incomplete PROXY header: " + err.Error())
+	}
+	version, command, family := header[0]>>4, header[0]&0x0F, header[1]>>4
+	if version != proxyV2Version {
+		return nil, errors.New("unsupported PROXY header version " + strconv.Itoa(int(version)))
+	}
+	// The addresses, followed by any TLVs, which are ignored.
+	body := make([]byte, binary.BigEndian.Uint16(header[2:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, errors.New("incomplete PROXY header: " + err.Error())
+	}
+
+	if command == proxyV2CmdLocal {
+		return nil, nil
+	} else if command != proxyV2CmdProxy {
+		return nil, errors.New("unknown PROXY command " + strconv.Itoa(int(command)))
+	}
+	var ipLen int
+	switch family {
+	case proxyV2FamilyIPv4:
+		ipLen = net.IPv4len
+	case proxyV2FamilyIPv6:
+		ipLen = net.IPv6len
+	default:
+		// Unix sockets and unspecified addresses; there's nothing to use.
+		return nil, nil
+	}
+	// Source address, destination address, source port, destination port.
+	if len(body) < 2*ipLen+4 {
+		return nil, errors.New("PROXY header is too short for its addresses")
+	}
+	ip := net.IP(append([]byte(nil), body[:ipLen]...))
+	port := binary.BigEndian.Uint16(body[2*ipLen:])
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// A connection that reports the address from its PROXY header, read by the
+// controller before the connection is handed to a server.
+type proxiedConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c *proxiedConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// A connection accepted by an HTTP server from a trusted proxy. The header is
+// read the first time that the connection is read from or asked for its
+// address, which happens in the connection's own goroutine, so that a slow
+// proxy doesn't hold up the listener.
+type lazyProxiedConn struct {
+	net.Conn
+	once   sync.Once
+	remote net.Addr
+	err    error
+}
+
+func (c *lazyProxiedConn) readHeader() {
+	c.once.Do(func() {
+		if c.remote, c.err = readProxyHeader(c.Conn); c.err != nil {
+			c.remote = c.Conn.RemoteAddr()
+		}
+	})
+}
+
+func (c *lazyProxiedConn) Read(b []byte) (int, error) {
+	if c.readHeader(); c.err != nil {
+		return 0, c.err
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *lazyProxiedConn) RemoteAddr() net.Addr {
+	c.readHeader()
+	return c.remote
+}
+
+// Wraps the connections from trusted proxies so that their headers are read.
+type proxyListener struct {
+	net.Listener
+}
+
+func (l proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil || !proxyTrusted(conn.RemoteAddr()) {
+		return conn, err
+	}
+	return &lazyProxiedConn{Conn: conn}, nil
+}
+
+// Serve an HTTP server on its address, over TLS if certFile is set, reading
+// the PROXY headers of connections from trusted proxies.
+func serveHTTP(server *http.Server, certFile, keyFile string) error {
+	socket, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return err
+	}
+	listener := proxyListener{socket}
+	if certFile != "" {
+		return server.ServeTLS(listener, certFile, keyFile)
+	}
+	return server.Serve(listener)
+}
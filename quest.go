@@ -0,0 +1,528 @@
+/*
+* Quests for the block servers. Quests are loaded from the quest directory when
+* the ship starts, with each subdirectory being a category on the quest counter's
+* menu. A quest is either a .qst file (which holds the packets used to download
+* it) or a .bin and .dat file with the same name. When the leader of a game picks
+* one, its files are sent to everyone in the game and the quest starts once all
+* of them have loaded it. The flags that quests set on a character (such as which
+* quests have been cleared) are saved as they change.
+*
+* Each category directory can also have a manifest that changes how the category
+* and its quests appear on the menu (see questmanifest.go). The quests and their
+* manifests are reloaded along with the config.
+ */
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf16"
+
+	"github.com/dcrodman/archon/data"
+	"github.com/dcrodman/archon/packets"
+	"github.com/dcrodman/archon/prs"
+	"github.com/dcrodman/archon/util"
+)
+
+const (
+	// Ids sent in the menu selection packet to tell the server that the
+	// selection was made on the quest category or quest menu.
+	QuestCategoryMenuId uint16 = 0x13
+	QuestMenuId         uint16 = 0x14
+	// Offsets of the fields in the header of a Blue Burst quest's .bin file
+	// (once it's decompressed). The strings are UTF-16.
+	questNumberOffset    = 0x10
+	questEpisodeOffset   = 0x14
+	questNameOffset      = 0x18
+	questShortDescOffset = 0x58
+	questLongDescOffset  = 0x158
+	questHeaderSize      = 0x398
+)
+
+// Quest is one of the quests that can be played from the quest counter.
+type Quest struct {
+	id     uint32
+	number uint16
+	// 1 for Episode 1, 2 for Episode 2, and 3 for Episode 4, as with games.
+	episode uint8
+	// Difficulties on which the quest is offered, or nil for all of them.
+	difficulties []uint8
+	// One of questModeSolo or questModeMulti to only offer the quest in games
+	// of that mode, or empty for both. Quests with questModeBattle are only
+	// offered in battle mode games and are the only ones offered there.
+	mode string
+	// Stage of challenge mode the quest is (starting at 1), or 0 if it isn't one.
+	// Challenge stages are only offered in challenge mode games.
+	challengeStage uint8
+	// Taken from the quest's header unless they're set in the manifest.
+	name             localizedText
+	shortDescription localizedText
+	longDescription  localizedText
+	// Contents of the .bin (still compressed) and .dat files as they're sent.
+	bin []byte
+	dat []byte
+}
+
+// Returns whether the quest can be played in a game.
+func (q *Quest) Playable(g *Game) bool {
+	if q.episode != g.episode {
+		return false
+	} else if q.mode == questModeSolo && g.soloMode == 0 || q.mode == questModeMulti && g.soloMode != 0 {
+		return false
+	} else if (q.challengeStage != 0) != (g.challengeMode != 0) {
+		return false
+	} else if (q.mode == questModeBattle) != (g.battleMode != 0) {
+		return false
+	}
+	if q.difficulties == nil {
+		return true
+	}
+	for _, difficulty := range q.difficulties {
+		if difficulty == g.difficulty {
+			return true
+		}
+	}
+	return false
+}
+
+type questCategory struct {
+	// Name of the category's directory.
+	dir         string
+	name        localizedText
+	description localizedText
+	// Position on the menu relative to the other categories.
+	order  int
+	quests []*Quest
+}
+
+// Returns the quests in the category that can be played in a game.
+func (qc *questCategory) Quests(g *Game) []*Quest {
+	var list []*Quest
+	for _, q := range qc.quests {
+		if q.Playable(g) {
+			list = append(list, q)
+		}
+	}
+	return list
+}
+
+// Synchronized registry of the quests loaded from the quest directory.
+type questList struct {
+	categories []*questCategory
+	quests     map[uint32]*Quest
+	sync.RWMutex
+}
+
+var quests = &questList{quests: make(map[uint32]*Quest)}
+
+// Load the quests in each category directory under dir, replacing any that were
+// loaded before, and return the number loaded. Quests that can't be read are
+// skipped with a warning, but nothing is replaced if a manifest is invalid.
+func (ql *questList) Load(dir string) (int, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		log.Warnf("Quest directory %s doesn't exist; no quests will be available", dir)
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	var categories []*questCategory
+	byId := make(map[uint32]*Quest)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		category, err := loadQuestCategory(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return 0, err
+		}
+		available := category.quests[:0]
+		for _, q := range category.quests {
+			if !episodeAvailable(q.episode) {
+				continue
+			}
+			q.id = uint32(len(byId) + 1)
+			byId[q.id] = q
+			available = append(available, q)
+		}
+		category.quests = available
+		if len(category.quests) > 0 {
+			categories = append(categories, category)
+		}
+	}
+	// ReadDir sorts by name, which breaks ties in the order.
+	sort.SliceStable(categories, func(i, j int) bool {
+		return categories[i].order < categories[j].order
+	})
+
+	ql.Lock()
+	ql.categories = categories
+	ql.quests = byId
+	ql.Unlock()
+	return len(byId), nil
+}
+
+// Load the quests in a category directory, in the order given by its manifest.
+func loadQuestCategory(dir string) (*questCategory, error) {
+	manifest, err := loadQuestManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var filenames []string
+	loaded := make(map[string]*Quest)
+	for _, file := range files {
+		path := filepath.Join(dir, file.Name())
+		var q *Quest
+		switch strings.ToLower(filepath.Ext(file.Name())) {
+		case ".qst":
+			q, err = loadQstFile(path)
+		case ".bin":
+			q, err = loadBinDatFiles(path)
+		default:
+			continue
+		}
+		if err != nil {
+			log.Warnf("Skipping quest %s: %s", path, err.Error())
+			continue
+		}
+		filenames = append(filenames, file.Name())
+		loaded[file.Name()] = q
+	}
+	return manifest.apply(filepath.Base(dir), filenames, loaded)
+}
+
+// Categories returns the quest categories in the order they appear on the menu.
+func (ql *questList) Categories() []*questCategory {
+	ql.RLock()
+	defer ql.RUnlock()
+	return ql.categories
+}
+
+// Category returns the category at index on the menu or nil if there isn't one.
+func (ql *questList) Category(index uint32) *questCategory {
+	ql.RLock()
+	defer ql.RUnlock()
+	if int(index) >= len(ql.categories) {
+		return nil
+	}
+	return ql.categories[index]
+}
+
+// Find returns the quest with the specified id or nil if it doesn't exist.
+func (ql *questList) Find(id uint32) *Quest {
+	ql.RLock()
+	defer ql.RUnlock()
+	return ql.quests[id]
+}
+
+// Load a quest from a .bin file and the .dat file next to it.
+func loadBinDatFiles(binPath string) (*Quest, error) {
+	bin, err := ioutil.ReadFile(binPath)
+	if err != nil {
+		return nil, err
+	}
+	dat, err := ioutil.ReadFile(strings.TrimSuffix(binPath, filepath.Ext(binPath)) + ".dat")
+	if err != nil {
+		return nil, err
+	}
+	return newQuest(bin, dat)
+}
+
+// Load a quest from a .qst file, which contains the packets that would be sent
+// to a client to download the quest's .bin and .dat files.
+func loadQstFile(path string) (*Quest, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var bin, dat []byte
+	files := make(map[string][]byte)
+	for offset := 0; offset+packets.BBHeaderSize <= len(contents); {
+		var hdr packets.BBHeader
+		util.StructFromBytes(contents[offset:], &hdr)
+		size := int(hdr.Size)
+		if size < packets.BBHeaderSize || offset+size > len(contents) {
+			return nil, errors.New("malformed packet; only Blue Burst quests are supported")
+		}
+		pkt := contents[offset : offset+size]
+
+		switch hdr.Type {
+		case packets.QuestFileType, packets.QuestDownloadFileType:
+			var file packets.QuestFilePacket
+			if err := util.DecodeStruct(pkt, &file); err != nil {
+				return nil, err
+			}
+			filename := string(util.StripPadding(file.Filename[:]))
+			files[filename] = make([]byte, file.Length)
+			switch strings.ToLower(filepath.Ext(filename)) {
+			case ".bin":
+				bin = files[filename]
+			case ".dat":
+				dat = files[filename]
+			}
+		case packets.QuestChunkType, packets.QuestDownloadChunkType:
+			var chunk packets.QuestChunkPacket
+			if err := util.DecodeStruct(pkt, &chunk); err != nil {
+				return nil, err
+			}
+			filename := string(util.StripPadding(chunk.Filename[:]))
+			buf, ok := files[filename]
+			start := int(hdr.Flags) * packets.QuestChunkSize
+			if !ok || chunk.Length > packets.QuestChunkSize || start+int(chunk.Length) > len(buf) {
+				return nil, fmt.Errorf("invalid chunk %d of %s", hdr.Flags, filename)
+			}
+			copy(buf[start:], chunk.Data[:chunk.Length])
+		default:
+			return nil, fmt.Errorf("unexpected packet %02x", hdr.Type)
+		}
+		// Blue Burst packets are padded to a multiple of 8 bytes.
+		offset += (size + 7) &^ 7
+	}
+	return newQuest(bin, dat)
+}
+
+// Build a quest from the contents of its files, reading its details from the
+// header of the .bin file.
+func newQuest(bin []byte, dat []byte) (*Quest, error) {
+	if len(bin) == 0 || len(dat) == 0 {
+		return nil, errors.New("missing or empty .bin or .dat file")
+	}
+	header := make([]byte, prs.DecompressSize(bin))
+	if len(header) < questHeaderSize {
+		return nil, errors.New("header is too short; only Blue Burst quests are supported")
+	}
+	prs.Decompress(bin, header)
+
+	q := &Quest{
+		number:           binary.LittleEndian.Uint16(header[questNumberOffset:]),
+		episode:          header[questEpisodeOffset] + 1,
+		name:             localizedText{"": questText(header[questNameOffset:questShortDescOffset])},
+		shortDescription: localizedText{"": questText(header[questShortDescOffset:questLongDescOffset])},
+		longDescription:  localizedText{"": questText(header[questLongDescOffset:questHeaderSize])},
+		bin:              bin,
+		dat:              dat,
+	}
+	if q.episode > 3 {
+		return nil, fmt.Errorf("invalid episode %d", header[questEpisodeOffset])
+	}
+	return q, nil
+}
+
+// Decode a null-terminated UTF-16 string from a quest header.
+func questText(b []byte) string {
+	chars := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		c := binary.LittleEndian.Uint16(b[i:])
+		if c == 0 {
+			break
+		}
+		chars = append(chars, c)
+	}
+	return string(utf16.Decode(chars))
+}
+
+// StartQuest sets the quest being played in the game, returning false if one
+// has already been started. Everyone in the game needs to load it before it begins.
+func (g *Game) StartQuest(q *Quest) bool {
+	g.questLock.Lock()
+	defer g.questLock.Unlock()
+	if g.quest != nil {
+		return false
+	}
+	g.quest = q
+	g.questLoading = make(map[uint8]bool)
+	for _, c := range g.Clients() {
+		g.questLoading[c.clientId] = true
+	}
+	return true
+}
+
+// Quest returns the quest being played in the game, or nil if there isn't one.
+func (g *Game) Quest() *Quest {
+	g.questLock.Lock()
+	defer g.questLock.Unlock()
+	return g.quest
+}
+
+// Note that a player has loaded the quest (or left the game) and let everyone
+// start playing once nobody else is still loading it.
+func (g *Game) questLoaded(c *Client) {
+	g.questLock.Lock()
+	if g.quest == nil || !g.questLoading[c.clientId] {
+		g.questLock.Unlock()
+		return
+	}
+	delete(g.questLoading, c.clientId)
+	done := len(g.questLoading) == 0
+	g.questLock.Unlock()
+
+	if done {
+		if g.challengeMode != 0 {
+			g.startStage()
+		} else if g.battleMode != 0 {
+			g.startBattle()
+		}
+		g.Broadcast(&packets.BBHeader{Type: packets.QuestReadyType}, nil)
+	}
+}
+
+// The leader talked to the quest counter; show them the categories that have
+// quests they can play in their game.
+func (server *BlockServer) HandleQuestListRequest(c *Client) error {
+	if c.game == nil {
+		return nil
+	} else if c.game.battleMode != 0 && c.game.BattleRules() == nil {
+		return server.sendBattleRuleMenu(c)
+	}
+	lang := clientLanguage(c)
+	pkt := &packets.QuestListPacket{Header: packets.BBHeader{Type: packets.QuestListType}}
+	for i, category := range quests.Categories() {
+		if len(category.Quests(c.game)) == 0 {
+			continue
+		}
+		entry := packets.QuestMenuEntry{MenuId: uint32(QuestCategoryMenuId), ItemId: uint32(i)}
+		copyUtf16(entry.Name[:len(entry.Name)-1], util.ConvertToUtf16(category.name.In(lang)))
+		copyUtf16(entry.Description[:len(entry.Description)-1], util.ConvertToUtf16(category.description.In(lang)))
+		pkt.Entries = append(pkt.Entries, entry)
+	}
+	if len(pkt.Entries) == 0 {
+		return SendClientMessage(c, "There are no quests available.")
+	}
+	pkt.Header.Flags = uint32(len(pkt.Entries))
+	c.log.Debug("Sending Quest Category List Packet")
+	return EncryptAndSend(c, pkt)
+}
+
+// The leader picked a category from the quest menu; show them its quests.
+func (server *BlockServer) HandleQuestCategorySelection(c *Client, pkt packets.MenuSelectionPacket) error {
+	if c.game == nil {
+		return nil
+	}
+	category := quests.Category(pkt.ItemId)
+	if category == nil {
+		return SendClientMessage(c, "That category is no longer available.")
+	}
+	lang := clientLanguage(c)
+	list := &packets.QuestListPacket{Header: packets.BBHeader{Type: packets.QuestListType}}
+	for _, q := range category.Quests(c.game) {
+		entry := packets.QuestMenuEntry{MenuId: uint32(QuestMenuId), ItemId: q.id}
+		copyUtf16(entry.Name[:len(entry.Name)-1], util.ConvertToUtf16(q.name.In(lang)))
+		copyUtf16(entry.Description[:len(entry.Description)-1], util.ConvertToUtf16(q.shortDescription.In(lang)))
+		list.Entries = append(list.Entries, entry)
+	}
+	list.Header.Flags = uint32(len(list.Entries))
+	c.log.Debug("Sending Quest List Packet")
+	return EncryptAndSend(c, list)
+}
+
+// The leader highlighted a quest on the menu; send them its long description.
+func (server *BlockServer) HandleQuestInfo(c *Client, pkt packets.MenuSelectionPacket) error {
+	q := quests.Find(pkt.ItemId)
+	if q == nil {
+		return nil
+	}
+	info := &packets.QuestInfoPacket{Header: packets.BBHeader{Type: packets.QuestInfoType}}
+	copyUtf16(info.Text[:len(info.Text)-1], util.ConvertToUtf16(q.longDescription.In(clientLanguage(c))))
+	c.log.Debug("Sending Quest Info Packet")
+	return EncryptAndSend(c, info)
+}
+
+// The leader picked a quest; send it to everyone in the game.
+func (server *BlockServer) HandleQuestSelection(c *Client, pkt packets.MenuSelectionPacket) error {
+	g := c.game
+	if g == nil {
+		return nil
+	} else if g.Leader() != c.clientId {
+		return errors.New("Client attempted to start a quest without being the leader: " + c.IPAddr())
+	}
+	q := quests.Find(pkt.ItemId)
+	if q == nil || !q.Playable(g) {
+		return SendClientMessage(c, "That quest is no longer available.")
+	}
+	if !g.StartQuest(q) {
+		return SendClientMessage(c, "A quest has already been started.")
+	}
+	c.log.Infof("Starting quest %s in game %d", q.name.In(""), g.id)
+	for _, p := range g.Clients() {
+		if err := sendQuest(p, q); err != nil {
+			p.log.Warn("Failed to send quest: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// The player's client finished loading the quest.
+func (server *BlockServer) HandleQuestReady(c *Client) {
+	if c.game != nil {
+		c.game.questLoaded(c)
+	}
+}
+
+// Send a quest's .bin and .dat files to a player, each in a QuestFilePacket
+// followed by the chunks of the file.
+func sendQuest(c *Client, q *Quest) error {
+	files := []struct {
+		name     string
+		contents []byte
+	}{
+		{fmt.Sprintf("quest%d.bin", q.number), q.bin},
+		{fmt.Sprintf("quest%d.dat", q.number), q.dat},
+	}
+	for _, file := range files {
+		pkt := &packets.QuestFilePacket{
+			Header: packets.BBHeader{Type: packets.QuestFileType},
+			Flags:  2,
+			Length: uint32(len(file.contents)),
+		}
+		copy(pkt.Name[:len(pkt.Name)-1], "PSO/"+q.name.In(""))
+		copy(pkt.Filename[:], file.name)
+		c.log.Debug("Sending Quest File Packet")
+		if err := EncryptAndSend(c, pkt); err != nil {
+			return err
+		}
+
+		for i := 0; i*packets.QuestChunkSize < len(file.contents); i++ {
+			chunk := &packets.QuestChunkPacket{Header: packets.BBHeader{Type: packets.QuestChunkType, Flags: uint32(i)}}
+			copy(chunk.Filename[:], file.name)
+			chunk.Length = uint32(copy(chunk.Data[:], file.contents[i*packets.QuestChunkSize:]))
+			if err := EncryptAndSend(c, chunk); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// A quest set or cleared one of the flags on the player's character, which is
+// saved right away so that it isn't lost if they disconnect.
+func (server *BlockServer) HandleSetQuestFlag(c *Client) error {
+	var pkt packets.SetQuestFlagPacket
+	if err := c.Decode(&pkt); err != nil {
+		return err
+	}
+	if c.game == nil || c.game.Quest() == nil || c.character == nil {
+		return nil
+	} else if pkt.Flag >= data.NumQuestFlags {
+		c.log.Warnf("Ignoring quest flag %d from guildcard %d", pkt.Flag, c.guildcard)
+		return nil
+	}
+	if err := saveQuestFlag(c, c.game.difficulty, pkt.Flag, pkt.Action == 0); err != nil {
+		c.log.Error(err.Error())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+/*
+* Quest manifests, which let operators change how a category of quests appears
+* on the quest menu without touching the quest files. A manifest is a file named
+* quests.json in the category's directory, for example:
+*
+*	{
+*		"name": {"en": "Retrieval"},
+*		"description": {"en": "Recover what was lost."},
+*		"order": 1,
+*		"quests": [
+*			{
+*				"file": "q058.qst",
+*				"episode": 1,
+*				"difficulties": ["normal", "hard"],
+*				"mode": "multi",
+*				"name": {"en": "Lost HEAT SWORD"},
+*				"short_description": {"en": "Retrieve a weapon from a Dragon!"},
+*				"long_description": {"en": "..."}
+*			}
+*		]
+*	}
+*
+* Everything is optional. Categories are listed by order and then by directory
+* name. The quests listed in the manifest come first, in the order they're
+* listed, followed by the rest of the quests in the directory; set "hidden" on
+* a quest to leave it off the menu. Names and descriptions can be given in
+* English (en) and Japanese (ja) and default to the ones in the quest file.
+* Give a quest a challenge_stage to make it one of the stages of challenge mode
+* (see challenge.go).
+ */
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// Name of the manifest file in each category directory.
+	questManifestFile = "quests.json"
+
+	// Modes that a quest can be restricted to.
+	questModeSolo   = "solo"
+	questModeMulti  = "multi"
+	questModeBattle = "battle"
+)
+
+// Names of the difficulties as they're given in manifests, indexed by difficulty.
+var difficultyNames = []string{"normal", "hard", "very_hard", "ultimate"}
+
+// Text in each of the languages it's available in, keyed by language code. The
+// empty key holds the text to fall back on.
+type localizedText map[string]string
+
+// Returns the text in lang, or in English if it isn't available in lang.
+func (t localizedText) In(lang string) string {
+	if text, ok := t[lang]; ok {
+		return text
+	} else if text, ok := t["en"]; ok {
+		return text
+	}
+	return t[""]
+}
+
+// Add the translations in other, replacing any that are already there.
+func (t localizedText) merge(other map[string]string) {
+	for lang, text := range other {
+		t[lang] = text
+	}
+}
+
+// Blue Burst clients are either English or Japanese, which shows up in the
+// marker at the start of the character's name (\tE or \tJ).
+func clientLanguage(c *Client) string {
+	if c.character != nil && len(c.character.Name) >= 4 &&
+		c.character.Name[0] == '\t' && c.character.Name[2] == 'J' {
+		return "ja"
+	}
+	return "en"
+}
+
+type questManifest struct {
+	Name        map[string]string `json:"name"`
+	Description map[string]string `json:"description"`
+	// Position on the menu relative to the other categories.
+	Order  int                  `json:"order"`
+	Quests []questManifestEntry `json:"quests"`
+}
+
+type questManifestEntry struct {
+	// Name of the quest's .qst or .bin file in the category directory.
+	File string `json:"file"`
+	// Episode the quest is for (1, 2, or 4), if the quest file has it wrong.
+	Episode int `json:"episode"`
+	// Difficulties on which the quest is offered; all of them if it's empty.
+	Difficulties []string `json:"difficulties"`
+	// Only offer the quest in games of this mode (solo, multi, or battle).
+	Mode string `json:"mode"`
+	// Stage of challenge mode that the quest is, starting at 1.
+	ChallengeStage int `json:"challenge_stage"`
+	// Leave the quest off the menu.
+	Hidden           bool              `json:"hidden"`
+	Name             map[string]string `json:"name"`
+	ShortDescription map[string]string `json:"short_description"`
+	LongDescription  map[string]string `json:"long_description"`
+}
+
+// Read the manifest in a category directory. Directories without one get an
+// empty manifest.
+func loadQuestManifest(dir string) (*questManifest, error) {
+	manifest := new(questManifest)
+	path := filepath.Join(dir, questManifestFile)
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return manifest, nil
+	} else if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(contents))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(manifest); err != nil {
+		return nil, fmt.Errorf("invalid %s: %s", path, err.Error())
+	}
+	return manifest, nil
+}
+
+// Build a category from the quests loaded from the files in its directory,
+// arranged and changed as the manifest says.
+func (m *questManifest) apply(dir string, filenames []string, loaded map[string]*Quest) (*questCategory, error) {
+	category := &questCategory{
+		dir:         dir,
+		name:        localizedText{"": dir},
+		description: localizedText{},
+		order:       m.Order,
+	}
+	category.name.merge(m.Name)
+	category.description.merge(m.Description)
+
+	listed := make(map[string]bool)
+	for _, entry := range m.Quests {
+		if listed[entry.File] {
+			return nil, fmt.Errorf("%s is listed more than once in the manifest for %s", entry.File, dir)
+		}
+		listed[entry.File] = true
+		q := loaded[entry.File]
+		if q == nil {
+			log.Warnf("The manifest for %s lists %s, which wasn't loaded", dir, entry.File)
+			continue
+		}
+		if err := entry.apply(q); err != nil {
+			return nil, fmt.Errorf("invalid entry for %s in the manifest for %s: %s", entry.File, dir, err.Error())
+		}
+		if !entry.Hidden {
+			category.quests = append(category.quests, q)
+		}
+	}
+	for _, filename := range filenames {
+		if !listed[filename] {
+			category.quests = append(category.quests, loaded[filename])
+		}
+	}
+	return category, nil
+}
+
+// Change a quest according to its entry in the manifest.
+func (entry *questManifestEntry) apply(q *Quest) error {
+	switch entry.Episode {
+	case 0:
+	case 1, 2:
+		q.episode = uint8(entry.Episode)
+	case 4:
+		q.episode = 3
+	default:
+		return errors.New("episode must be 1, 2, or 4")
+	}
+	for _, name := range entry.Difficulties {
+		difficulty, ok := parseDifficulty(name)
+		if !ok {
+			return errors.New("difficulties must be normal, hard, very_hard, or ultimate")
+		}
+		q.difficulties = append(q.difficulties, difficulty)
+	}
+	switch entry.Mode {
+	case "", questModeSolo, questModeMulti, questModeBattle:
+	default:
+		return errors.New("mode must be solo, multi, or battle")
+	}
+	q.mode = entry.Mode
+	if entry.ChallengeStage != 0 && challengeStages(q.episode) == 0 {
+		return errors.New("challenge mode is only in Episodes 1 and 2")
+	} else if entry.ChallengeStage < 0 || entry.ChallengeStage > challengeStages(q.episode) {
+		return fmt.Errorf("challenge_stage must be between 1 and %d", challengeStages(q.episode))
+	}
+	q.challengeStage = uint8(entry.ChallengeStage)
+	q.name.merge(entry.Name)
+	q.shortDescription.merge(entry.ShortDescription)
+	q.longDescription.merge(entry.LongDescription)
+	return nil
+}
+
+// Returns the difficulty with a name.
+func parseDifficulty(name string) (uint8, bool) {
+	for difficulty, n := range difficultyNames {
+		if name == n {
+			return uint8(difficulty), true
+		}
+	}
+	return 0, false
+}
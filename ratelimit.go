@@ -0,0 +1,169 @@
+/*
+* Per-address limits on new connections to the servers that accept passwords
+* (and on requests to the web API, which does too), to slow down credential
+* stuffing and connection floods. Addresses that keep connecting after they've
+* been throttled are blocked for a while.
+ */
+package main
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Connections are counted over fixed windows of this length.
+const rateLimitWindow = time.Minute
+
+// Connection counts for an address or subnet during the current window.
+type rateWindow struct {
+	start       time.Time
+	connections int
+	// Connections that were refused for being over the limit.
+	refused      int
+	blockedUntil time.Time
+}
+
+type rateLimiter struct {
+	sync.Mutex
+	ipLimit        int
+	subnetLimit    int
+	blockThreshold int
+	blockDuration  time.Duration
+	whitelist      []*net.IPNet
+
+	ips       map[string]*rateWindow
+	subnets   map[string]*rateWindow
+	lastSweep time.Time
+}
+
+func newRateLimiter(cfg RateLimitConfig) (*rateLimiter, error) {
+	rl := &rateLimiter{
+		ips:       make(map[string]*rateWindow),
+		subnets:   make(map[string]*rateWindow),
+		lastSweep: time.Now(),
+	}
+	return rl, rl.configure(cfg)
+}
+
+// Apply new limits. Addresses that are already blocked stay blocked.
+func (rl *rateLimiter) configure(cfg RateLimitConfig) error {
+	whitelist, err := parseNetworks(cfg.Whitelist)
+	if err != nil {
+		return err
+	}
+
+	rl.Lock()
+	defer rl.Unlock()
+	rl.ipLimit = cfg.IPConnections
+	rl.subnetLimit = cfg.SubnetConnections
+	rl.blockThreshold = cfg.BlockThreshold
+	rl.blockDuration = time.Duration(cfg.BlockMinutes) * time.Minute
+	rl.whitelist = whitelist
+	return nil
+}
+
+// Allow records a connection from addr and returns whether it should be accepted.
+func (rl *rateLimiter) Allow(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return true
+	}
+	ip := tcpAddr.IP
+
+	rl.Lock()
+	defer rl.Unlock()
+	if rl.ipLimit <= 0 && rl.subnetLimit <= 0 {
+		return true
+	}
+	for _, network := range rl.whitelist {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	now := time.Now()
+	rl.sweep(now)
+
+	ipWindow := rl.window(rl.ips, ip.String(), now)
+	if now.Before(ipWindow.blockedUntil) {
+		return false
+	}
+	subnetWindow := rl.window(rl.subnets, subnet(ip), now)
+	ipWindow.connections++
+	subnetWindow.connections++
+	if !overLimit(ipWindow.connections, rl.ipLimit) && !overLimit(subnetWindow.connections, rl.subnetLimit) {
+		return true
+	}
+
+	ipWindow.refused++
+	if rl.blockThreshold > 0 && ipWindow.refused >= rl.blockThreshold {
+		ipWindow.blockedUntil = now.Add(rl.blockDuration)
+		log.Warnf("Blocking connections from %s until %s", ip, ipWindow.blockedUntil.Format(time.RFC1123))
+	} else if ipWindow.refused == 1 {
+		log.Infof("Throttling connections from %s", ip)
+	}
+	return false
+}
+
+// Parse a list of IP addresses and CIDR ranges, treating an address as a range
+// containing only itself.
+func parseNetworks(entries []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			if strings.Contains(entry, ":") {
+				entry += "/128"
+			} else {
+				entry += "/32"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func overLimit(connections int, limit int) bool {
+	return limit > 0 && connections > limit
+}
+
+// Returns the counts for key, starting a new window if the last one is over.
+func (rl *rateLimiter) window(windows map[string]*rateWindow, key string, now time.Time) *rateWindow {
+	w, ok := windows[key]
+	if !ok {
+		w = &rateWindow{start: now}
+		windows[key] = w
+	} else if now.Sub(w.start) >= rateLimitWindow {
+		w.start = now
+		w.connections = 0
+		w.refused = 0
+	}
+	return w
+}
+
+// Forget about addresses that haven't connected recently and aren't blocked.
+func (rl *rateLimiter) sweep(now time.Time) {
+	if now.Sub(rl.lastSweep) < rateLimitWindow {
+		return
+	}
+	rl.lastSweep = now
+	for _, windows := range []map[string]*rateWindow{rl.ips, rl.subnets} {
+		for key, w := range windows {
+			if now.Sub(w.start) >= rateLimitWindow && now.After(w.blockedUntil) {
+				delete(windows, key)
+			}
+		}
+	}
+}
+
+// Returns the /24 (for IPv4) or /64 (for IPv6) network containing ip.
+func subnet(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(64, 128)).String()
+}
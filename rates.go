@@ -0,0 +1,87 @@
+/*
+* Multipliers for the drop, rare drop, and experience rates. Each game's rates
+* are the product of the ones that apply to it: drops.drop_rate and
+* drops.rare_rate, rates.exp_rate, those of every entry in rates.overrides that
+* matches its episode and difficulty, and those of any scheduled events that are
+* running (see events.go). For example, to double the experience in Ultimate and
+* the rares in Episode 4 on Ultimate:
+*
+*	rates:
+*	  exp_rate: 1
+*	  overrides:
+*	    - difficulty: ultimate
+*	      exp_rate: 2
+*	    - episode: ep4
+*	      difficulty: ultimate
+*	      rare_rate: 2
+*
+* The rates can be changed without a restart through the admin API, which lasts
+* until the config is reloaded.
+ */
+package main
+
+import "errors"
+
+// A rate override, parsed.
+type rateOverride struct {
+	RateOverride
+	// Game episode (1-3), or 0 for every episode.
+	episode uint8
+	// Game difficulty, or -1 for every difficulty.
+	difficulty int
+}
+
+func (o rateOverride) matches(g *Game) bool {
+	return (o.episode == 0 || o.episode == g.episode) &&
+		(o.difficulty < 0 || o.difficulty == int(g.difficulty))
+}
+
+func parseRateOverrides(overrides []RateOverride) ([]rateOverride, error) {
+	var parsed []rateOverride
+	for _, override := range overrides {
+		o := rateOverride{RateOverride: override, difficulty: -1}
+		if override.Episode != "" {
+			if o.episode = parseEpisode(override.Episode); o.episode == 0 {
+				return nil, errors.New("episodes must be ep1, ep2, or ep4")
+			}
+		}
+		if override.Difficulty != "" {
+			for difficulty, name := range difficultyNames {
+				if name == override.Difficulty {
+					o.difficulty = difficulty
+				}
+			}
+			if o.difficulty < 0 {
+				return nil, errors.New("difficulties must be normal, hard, very_hard, or ultimate")
+			}
+		}
+		if override.ExpRate < 0 || override.RareRate < 0 {
+			return nil, errors.New("rates can't be negative")
+		}
+		parsed = append(parsed, o)
+	}
+	return parsed, nil
+}
+
+// Returns how much the drop, rare drop, and experience rates are multiplied by
+// in the game.
+func (g *Game) rates() (dropRate, rareRate, expRate float64) {
+	drops := config.Drops()
+	rates, overrides := config.Rates()
+	eventDropRate, eventRareRate, eventExpRate := currentEventRates()
+	dropRate = drops.DropRate * eventDropRate
+	rareRate = drops.RareRate * eventRareRate
+	expRate = rates.ExpRate * eventExpRate
+	for _, o := range overrides {
+		if !o.matches(g) {
+			continue
+		}
+		if o.RareRate > 0 {
+			rareRate *= o.RareRate
+		}
+		if o.ExpRate > 0 {
+			expRate *= o.ExpRate
+		}
+	}
+	return dropRate, rareRate, expRate
+}
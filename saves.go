@@ -0,0 +1,464 @@
+/*
+* Write-behind saves for the characters of the players on the ship. Rather than
+* writing to the database every time a player gains experience or picks up an
+* item, the block servers queue a copy of the player's character at most every
+* save_seconds while they're playing (and right away after bank transactions,
+* level ups, and quest flag changes), and the queue is written to the database
+* in batches. A batch only holds the latest of the saves queued for each part of
+* a character.
+*
+* Each save is appended to the journal before it's queued. A flush moves the
+* journal aside, writes the batch, and then removes it, so saves that hadn't been
+* written when the server went down are found in one of the two files and are
+* written when the ship server starts again. Saves are numbered so that replaying
+* them (or queueing them again after a failed write) can't undo a newer one. Bank
+* saves carry the character with them and are written in one transaction, since
+* items and meseta move between the two. Trades carry both characters in theirs,
+* and challenge mode records the character whose inventory holds the prizes. A
+* player's character is flushed when they log off, before its lock is released
+* (see charlock.go), so that it can't be loaded elsewhere before it's written.
+ */
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dcrodman/archon/data"
+)
+
+// Parts of a character that are saved separately.
+const (
+	saveCharacterData = iota
+	saveBankData
+	saveQuestFlagData
+	saveTradeData
+	saveChallengeData
+)
+
+type questFlagChange struct {
+	Difficulty byte   `json:"difficulty"`
+	Flag       uint16 `json:"flag"`
+	Set        bool   `json:"set"`
+}
+
+// Both of the characters in a trade as they are after it, written in one
+// transaction along with the record of the trade.
+type tradeSave struct {
+	Trade       data.Trade         `json:"trade"`
+	Characters  [2]*data.Character `json:"characters"`
+	Inventories [2][]data.Item     `json:"inventories"`
+}
+
+// A change to one of the characters as it's journaled. Only the parts that are
+// set are saved; the inventory and techniques go along with the character, which
+// goes along with the bank.
+type characterSave struct {
+	Seq        uint64          `json:"seq"`
+	Guildcard  uint32          `json:"guildcard"`
+	Slot       uint32          `json:"slot"`
+	Character  *data.Character `json:"character,omitempty"`
+	Inventory  []data.Item     `json:"inventory,omitempty"`
+	Techniques data.Techniques `json:"techniques"`
+	// Slot that the bank is kept under (see bankSlot).
+	BankSlot  uint32           `json:"bank_slot,omitempty"`
+	Bank      *data.Bank       `json:"bank,omitempty"`
+	QuestFlag *questFlagChange `json:"quest_flag,omitempty"`
+	Trade     *tradeSave       `json:"trade,omitempty"`
+	// Challenge mode record, saved with the character since prizes are added to
+	// the inventory when ranks are awarded.
+	Challenge *data.ChallengeRecord `json:"challenge,omitempty"`
+}
+
+// Identifies the part of a character that a queued save replaces.
+type saveKey struct {
+	part       int
+	guildcard  uint32
+	slot       uint32
+	bankSlot   uint32
+	difficulty byte
+	flag       uint16
+	// Trades are never replaced, so each is keyed by its own number.
+	seq uint64
+}
+
+// Split the save into one save for each part of the character that it covers.
+func (s characterSave) parts() map[saveKey]characterSave {
+	parts := make(map[saveKey]characterSave)
+	if s.Trade != nil {
+		parts[saveKey{part: saveTradeData, seq: s.Seq}] = s
+		return parts
+	}
+	if s.Bank != nil {
+		// Writing these in order with the character's other saves leaves the
+		// newest copy of the character either way.
+		part := s
+		part.QuestFlag = nil
+		parts[saveKey{part: saveBankData, guildcard: s.Guildcard, slot: s.Slot, bankSlot: s.BankSlot}] = part
+	} else if s.Challenge != nil {
+		part := s
+		part.QuestFlag = nil
+		parts[saveKey{part: saveChallengeData, guildcard: s.Guildcard, slot: s.Slot}] = part
+	} else if s.Character != nil {
+		part := s
+		part.QuestFlag = nil
+		parts[saveKey{part: saveCharacterData, guildcard: s.Guildcard, slot: s.Slot}] = part
+	}
+	if s.QuestFlag != nil {
+		part := characterSave{Seq: s.Seq, Guildcard: s.Guildcard, Slot: s.Slot, QuestFlag: s.QuestFlag}
+		key := saveKey{
+			part:       saveQuestFlagData,
+			guildcard:  s.Guildcard,
+			slot:       s.Slot,
+			difficulty: s.QuestFlag.Difficulty,
+			flag:       s.QuestFlag.Flag,
+		}
+		parts[key] = part
+	}
+	return parts
+}
+
+// Returns the characters that the save changes, as guildcard and slot.
+func (s characterSave) characters() [][2]uint32 {
+	if s.Trade == nil {
+		return [][2]uint32{{s.Guildcard, s.Slot}}
+	}
+	sides := s.Trade.Trade.Sides
+	return [][2]uint32{{sides[0].Guildcard, sides[0].SlotNum}, {sides[1].Guildcard, sides[1].SlotNum}}
+}
+
+// Write the save (which covers one part of the character) to the database.
+func (s characterSave) write() error {
+	switch {
+	case s.Trade != nil:
+		trade := s.Trade.Trade
+		if err := database.CompleteTrade(&trade, s.Trade.Characters, s.Trade.Inventories); err != nil {
+			return fmt.Errorf("Failed to save trade between guildcards %d and %d: %s",
+				trade.Sides[0].Guildcard, trade.Sides[1].Guildcard, err.Error())
+		}
+		for _, side := range trade.Sides {
+			cacheDelete(previewCacheKey(side.Guildcard, side.SlotNum))
+		}
+		return nil
+	case s.Character != nil && s.Bank != nil:
+		err := database.UpdateCharacterAndBank(s.Guildcard, s.Slot, s.Character, s.Inventory, s.BankSlot, s.Bank)
+		if err != nil {
+			return fmt.Errorf("Failed to save bank for guildcard %d: %s", s.Guildcard, err.Error())
+		}
+		if err := database.UpdateTechniques(s.Guildcard, s.Slot, s.Techniques); err != nil {
+			return fmt.Errorf("Failed to save techniques for guildcard %d: %s", s.Guildcard, err.Error())
+		}
+	case s.Character != nil && s.Challenge != nil:
+		err := database.UpdateCharacterAndChallengeRecord(s.Guildcard, s.Slot, s.Character, s.Inventory, s.Challenge)
+		if err != nil {
+			return fmt.Errorf("Failed to save challenge record for guildcard %d: %s", s.Guildcard, err.Error())
+		}
+		if err := database.UpdateTechniques(s.Guildcard, s.Slot, s.Techniques); err != nil {
+			return fmt.Errorf("Failed to save techniques for guildcard %d: %s", s.Guildcard, err.Error())
+		}
+	case s.Character != nil:
+		if err := database.UpdateCharacter(s.Guildcard, s.Slot, s.Character); err != nil {
+			return fmt.Errorf("Failed to save character for guildcard %d: %s", s.Guildcard, err.Error())
+		}
+		if err := database.UpdateItems(s.Guildcard, s.Slot, data.ItemLocationInventory, s.Inventory); err != nil {
+			return fmt.Errorf("Failed to save inventory for guildcard %d: %s", s.Guildcard, err.Error())
+		}
+		if err := database.UpdateTechniques(s.Guildcard, s.Slot, s.Techniques); err != nil {
+			return fmt.Errorf("Failed to save techniques for guildcard %d: %s", s.Guildcard, err.Error())
+		}
+	case s.Bank != nil:
+		if err := database.UpdateBank(s.Guildcard, s.BankSlot, s.Bank); err != nil {
+			return fmt.Errorf("Failed to save bank for guildcard %d: %s", s.Guildcard, err.Error())
+		}
+	case s.QuestFlag != nil:
+		flag := s.QuestFlag
+		if err := database.UpdateQuestFlag(s.Guildcard, s.Slot, flag.Difficulty, flag.Flag, flag.Set); err != nil {
+			return fmt.Errorf("Failed to save quest flag for guildcard %d: %s", s.Guildcard, err.Error())
+		}
+	}
+	if s.Character != nil {
+		// The level, experience, and playtime shown in the preview may have changed.
+		cacheDelete(previewCacheKey(s.Guildcard, s.Slot))
+	}
+	return nil
+}
+
+// The queue of saves waiting to be written and the journal that backs it.
+type savePipeline struct {
+	pending map[saveKey]characterSave
+	seq     uint64
+	path    string
+	journal *os.File
+	sync.Mutex
+	// Held while a batch is being written so that flushes don't overlap.
+	flushing sync.Mutex
+}
+
+var saves = &savePipeline{pending: make(map[saveKey]characterSave)}
+
+// Open the journal at path, first writing any saves left in it (or in the file
+// of a flush that didn't finish) by a previous run.
+func (p *savePipeline) Open(path string) error {
+	p.Lock()
+	p.path = path
+	for _, file := range []string{p.flushingPath(), path} {
+		n, err := p.replay(file)
+		if err != nil {
+			p.Unlock()
+			return fmt.Errorf("Error reading save journal %s: %s", file, err.Error())
+		} else if n > 0 {
+			log.Infof("Recovered %d unwritten saves from %s", n, file)
+		}
+	}
+	p.Unlock()
+	// Nothing is written to the journal until it's been opened below, so the
+	// recovered saves stay in the old files until they're all written.
+	if written, _ := p.flush(); !written {
+		return fmt.Errorf("Failed to write the saves recovered from %s", path)
+	}
+	os.Remove(p.flushingPath())
+
+	p.Lock()
+	defer p.Unlock()
+	journal, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("Error opening save journal: %s", err.Error())
+	}
+	p.journal = journal
+	return nil
+}
+
+func (p *savePipeline) flushingPath() string {
+	return p.path + ".flushing"
+}
+
+// Queue the saves in a journal file, returning how many there were.
+func (p *savePipeline) replay(path string) (int, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	n := 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var save characterSave
+		if err := json.Unmarshal(scanner.Bytes(), &save); err != nil {
+			// Most likely the last line, cut off by a crash while it was written.
+			log.Warnf("Skipping unreadable save in %s: %s", path, err.Error())
+			continue
+		}
+		if save.Seq > p.seq {
+			p.seq = save.Seq
+		}
+		p.merge(save)
+		n++
+	}
+	return n, scanner.Err()
+}
+
+// Queue the parts of a save that are newer than what's already queued for them.
+func (p *savePipeline) merge(save characterSave) {
+	for key, part := range save.parts() {
+		if current, ok := p.pending[key]; !ok || current.Seq < part.Seq {
+			p.pending[key] = part
+		}
+	}
+}
+
+// Journal a save and queue it to be written. The save is copied, since the
+// player's data keeps changing after it's queued.
+func (p *savePipeline) Queue(save characterSave) error {
+	p.Lock()
+	defer p.Unlock()
+	p.seq++
+	save.Seq = p.seq
+	line, err := json.Marshal(save)
+	if err != nil {
+		return err
+	}
+	var saved characterSave
+	if err := json.Unmarshal(line, &saved); err != nil {
+		return err
+	}
+	err = p.appendJournal(line)
+	p.merge(saved)
+	return err
+}
+
+func (p *savePipeline) appendJournal(line []byte) error {
+	if p.journal == nil {
+		return nil
+	}
+	if _, err := p.journal.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("Failed to journal save: %s", err.Error())
+	}
+	if err := p.journal.Sync(); err != nil {
+		return fmt.Errorf("Failed to journal save: %s", err.Error())
+	}
+	return nil
+}
+
+// Flush writes the queued saves to the database. Saves that can't be written
+// stay queued for the next flush.
+func (p *savePipeline) Flush() {
+	p.flushing.Lock()
+	defer p.flushing.Unlock()
+	if _, rotated := p.flush(); rotated {
+		// The saves in the old journal have all been written or journaled again.
+		if err := os.Remove(p.flushingPath()); err != nil && !os.IsNotExist(err) {
+			log.Warn("Failed to remove flushed save journal: " + err.Error())
+		}
+	}
+}
+
+// Write the queued saves in the order they were made, returning whether they
+// all were and whether the journal was moved aside. It's moved first so that
+// saves made while the batch is being written go into a new one.
+func (p *savePipeline) flush() (written bool, rotated bool) {
+	p.Lock()
+	if len(p.pending) == 0 {
+		p.Unlock()
+		return true, false
+	}
+	batch := make([]characterSave, 0, len(p.pending))
+	for _, save := range p.pending {
+		batch = append(batch, save)
+	}
+	p.pending = make(map[saveKey]characterSave)
+	rotated = p.rotateJournal()
+	p.Unlock()
+
+	sort.Slice(batch, func(i, j int) bool { return batch[i].Seq < batch[j].Seq })
+	var failed []characterSave
+	// Characters with a save that couldn't be written. Their later saves are held
+	// back with it, since writing them first would leave the older one to land on
+	// top of them (a trade replacing the inventory that the player has since
+	// saved, say).
+	held := make(map[[2]uint32]bool)
+	for _, save := range batch {
+		hold := false
+		for _, character := range save.characters() {
+			hold = hold || held[character]
+		}
+		if !hold {
+			err := save.write()
+			if err == nil {
+				continue
+			}
+			log.Error(err.Error())
+		}
+		for _, character := range save.characters() {
+			held[character] = true
+		}
+		failed = append(failed, save)
+	}
+	if len(failed) == 0 {
+		return true, rotated
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	for _, save := range failed {
+		p.merge(save)
+		if !rotated {
+			continue
+		}
+		// Keeping their numbers means they can't replace newer saves on replay.
+		if line, err := json.Marshal(save); err != nil {
+			log.Error(err.Error())
+		} else if err := p.appendJournal(line); err != nil {
+			log.Error(err.Error())
+		}
+	}
+	return false, rotated
+}
+
+// Move the journal to the flushing path and start a new one, returning false
+// (and carrying on with the old one) if it couldn't be.
+func (p *savePipeline) rotateJournal() bool {
+	if p.journal == nil {
+		return false
+	}
+	if err := os.Rename(p.path, p.flushingPath()); err != nil {
+		log.Warn("Failed to move save journal aside: " + err.Error())
+		return false
+	}
+	journal, err := os.OpenFile(p.path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		// Keep writing to the old one; it can be replayed from either name.
+		log.Error("Failed to start new save journal: " + err.Error())
+		return false
+	}
+	p.journal.Close()
+	p.journal = journal
+	return true
+}
+
+// Loop for the life of the server, writing the queued saves every interval.
+func (p *savePipeline) run(interval time.Duration) {
+	for range time.Tick(interval) {
+		p.Flush()
+	}
+}
+
+func saveInterval() time.Duration {
+	return time.Duration(config.SaveSeconds) * time.Second
+}
+
+// Copy of the player's character, inventory, and techniques to be saved.
+func characterSaveOf(c *Client) characterSave {
+	return characterSave{
+		Guildcard:  c.guildcard,
+		Slot:       uint32(c.config.SlotNum),
+		Character:  c.character,
+		Inventory:  c.inventory,
+		Techniques: c.techniques,
+	}
+}
+
+// Queue the player's character to be saved. Any trade that's been saved for the
+// player is applied first, and the trade lock is held until the save is queued
+// so that one can't be saved in between and then overwritten by this one.
+func saveCharacter(c *Client) error {
+	c.tradeResultLock.Lock()
+	defer c.tradeResultLock.Unlock()
+	c.applyTradeResult()
+	c.lastSave = time.Now()
+	if err := saves.Queue(characterSaveOf(c)); err != nil {
+		return fmt.Errorf("Failed to queue save for guildcard %d: %s", c.guildcard, err.Error())
+	}
+	return nil
+}
+
+// Queue the player's character to be saved if it hasn't been for a while.
+func saveCharacterIfDue(c *Client) {
+	if c.character == nil || time.Since(c.lastSave) < saveInterval() {
+		return
+	}
+	if err := saveCharacter(c); err != nil {
+		c.log.Error(err.Error())
+	}
+}
+
+// Queue a quest flag that the player set or cleared to be saved.
+func saveQuestFlag(c *Client, difficulty byte, flag uint16, set bool) error {
+	err := saves.Queue(characterSave{
+		Guildcard: c.guildcard,
+		Slot:      uint32(c.config.SlotNum),
+		QuestFlag: &questFlagChange{Difficulty: difficulty, Flag: flag, Set: set},
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to queue quest flag for guildcard %d: %s", c.guildcard, err.Error())
+	}
+	return nil
+}
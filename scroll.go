@@ -0,0 +1,85 @@
+/*
+* The scrolling message shown on the ship and block select screens. The message
+* is a text/template so that it can mention the player and the state of the
+* server, for example:
+*
+*	Welcome back, {{.PlayerName}}! {{.OnlineCount}} players online.
+*	{{if .EventName}}The {{.EventName}} event is on now!{{end}}
+ */
+package main
+
+import (
+	"strings"
+	"text/template"
+
+	"github.com/dcrodman/archon/packets"
+	"github.com/dcrodman/archon/util"
+)
+
+// Variables available to the scroll message template.
+type ScrollMessageVars struct {
+	// Name of the character the player chose, or their username if they
+	// haven't chosen one yet.
+	PlayerName string
+	// Number of players on all of the ships.
+	OnlineCount int
+	EventName   string
+	ShipName    string
+}
+
+func parseScrollTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Option("missingkey=error").Parse(text)
+}
+
+// Fill in the variables for the player on c, who is playing the character in slot.
+func scrollMessageVars(c *Client, slot int) ScrollMessageVars {
+	vars := ScrollMessageVars{
+		PlayerName: c.username,
+		EventName:  currentEventName(),
+		ShipName:   config.ShipName,
+	}
+	if slot >= 0 {
+		character, err := c.db().FindCharacter(c.guildcard, uint32(slot))
+		if err != nil {
+			c.log.Warn("Failed to load character for scroll message: " + err.Error())
+		} else if character != nil {
+			vars.PlayerName = characterName(character)
+		}
+	}
+	for _, ship := range ships.List() {
+		vars.OnlineCount += ship.NumPlayers()
+	}
+	return vars
+}
+
+// Render the scroll message in UTF-16 for the client. If the template fails
+// for some reason then the message is sent as written.
+func renderScrollMessage(tmpl *template.Template, vars ScrollMessageVars) []byte {
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, vars); err != nil {
+		log.Warn("Failed to render scroll message: " + err.Error())
+		return util.ConvertToUtf16(tmpl.Root.String())
+	}
+	return util.ConvertToUtf16(sb.String())
+}
+
+// Send the scroll message configured for this server to the player on c, or
+// that of a scheduled event if one with its own is running.
+func sendScrollMessage(c *Client, slot int, ship bool) error {
+	tmpl := currentEventScrollTemplate()
+	if tmpl == nil {
+		tmpl = config.ScrollTemplate(ship)
+	}
+	pkt := &packets.ScrollMessagePacket{
+		Header:  packets.BBHeader{Type: packets.LoginScrollMessageType},
+		Message: renderScrollMessage(tmpl, scrollMessageVars(c, slot)),
+	}
+
+	data, size := util.BytesFromStruct(pkt)
+	// The end of the message appears to be garbled unless
+	// there is a block of extra bytes on the end; add an extra
+	// and let fixLength add the rest.
+	data = append(data, 0x00)
+	c.log.Debug("Sending Scroll Message Packet")
+	return c.SendEncrypted(data, size+1)
+}
@@ -0,0 +1,54 @@
+/*
+* Session tokens. The login server gives each player a random token in the
+* client config it sends with the security packet, which the client echoes back
+* whenever it connects to the character, ship, and block servers. Those servers
+* check the token against the one stored for the account so that the rest of
+* the config (e.g. the selected character) can't be forged. Only a hash of the
+* token is stored, and its expiry is pushed back each time it's used.
+ */
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// How long a session lasts after the player last connected with it.
+const sessionTimeout = 24 * time.Hour
+
+func hashSessionToken(token [16]byte) string {
+	sum := sha256.Sum256(token[:])
+	return hex.EncodeToString(sum[:])
+}
+
+// Issue a new session token for the player on c and put it in their config.
+// Any session they had before is ended.
+func startSession(c *Client) error {
+	var token [16]byte
+	if _, err := rand.Read(token[:]); err != nil {
+		return err
+	}
+	expires := time.Now().Add(sessionTimeout)
+	if err := c.db().CreateSession(c.guildcard, hashSessionToken(token), expires); err != nil {
+		return err
+	}
+	c.config.SessionToken = token
+	return nil
+}
+
+// Check that the session token in c's config was issued to the account they
+// logged in with.
+func verifySession(c *Client) error {
+	guildcard, err := c.db().RefreshSession(hashSessionToken(c.config.SessionToken),
+		time.Now().Add(sessionTimeout))
+	if err != nil {
+		c.log.Error("Failed to check session: " + err.Error())
+		return err
+	} else if guildcard == 0 || guildcard != c.guildcard {
+		return errors.New("Invalid or expired session token from " + c.IPAddr())
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+/*
+* Limits on the number of clients logged in at once, per account and per IP
+* address (see the session_limits section of the config). Clients count from the
+* time a login to any of the servers is accepted until they disconnect, so a
+* player who is partway through moving between servers may briefly count twice.
+* Logins are only counted once every other check on them has passed. With
+* takeover on, a login that would go over an account's limit disconnects that
+* account's oldest sessions rather than being turned away.
+*
+* Even with takeover off, sessions from the same address as the new login are
+* treated as ghosts left behind by a client that crashed or lost its connection
+* and are taken over. The new login waits for a ghost's connection to be cleaned
+* up, which saves its character, so that the character isn't loaded before the
+* ghost's progress is. Ghosts on other ships are found through the online player
+* registry and disconnected by way of the shipgate (see online.go).
+ */
+package main
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dcrodman/archon/data"
+)
+
+// How long a new login waits for the sessions it takes over to be cleaned up.
+const ghostReleaseTimeout = 5 * time.Second
+
+// Account that a client logged in with and when. The registry keeps its own
+// copy so that it can be read from any goroutine.
+type loginSession struct {
+	username  string
+	guildcard uint32
+	since     time.Time
+}
+
+// Synchronized set of the clients that have logged in.
+type sessionRegistry struct {
+	clients map[*Client]loginSession
+	sync.Mutex
+}
+
+var loginSessions = &sessionRegistry{clients: make(map[*Client]loginSession)}
+
+// Claim returns an error if the login of the player on c, who has been set up
+// for their account, would go over the limits, and otherwise counts them. The
+// sessions that c takes over are no longer counted and are returned for the
+// caller to disconnect.
+func (r *sessionRegistry) Claim(c *Client, limits SessionLimitConfig) ([]*Client, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	var sameAccount []*Client
+	for other, session := range r.clients {
+		if other != c && session.username == c.username {
+			sameAccount = append(sameAccount, other)
+		}
+	}
+	var replaced []*Client
+	if limits.SessionsPerAccount > 0 && len(sameAccount) >= limits.SessionsPerAccount {
+		var replaceable []*Client
+		for _, other := range sameAccount {
+			if limits.SessionTakeover || other.IPAddr() == c.IPAddr() {
+				replaceable = append(replaceable, other)
+			}
+		}
+		over := len(sameAccount) - limits.SessionsPerAccount + 1
+		if len(replaceable) < over {
+			return nil, errors.New("Too many sessions for account " + c.username)
+		}
+		sort.Slice(replaceable, func(i, j int) bool {
+			return r.clients[replaceable[i]].since.Before(r.clients[replaceable[j]].since)
+		})
+		replaced = replaceable[:over]
+	}
+
+	if limits.SessionsPerIP > 0 {
+		sameIP := 0
+		for other := range r.clients {
+			if other != c && other.IPAddr() == c.IPAddr() && !containsClient(replaced, other) {
+				sameIP++
+			}
+		}
+		if sameIP >= limits.SessionsPerIP {
+			return nil, errors.New("Too many sessions from " + c.IPAddr())
+		}
+	}
+
+	for _, other := range replaced {
+		delete(r.clients, other)
+	}
+	r.clients[c] = loginSession{
+		username:  c.username,
+		guildcard: c.guildcard,
+		since:     time.Now(),
+	}
+	return replaced, nil
+}
+
+// Release stops counting a client once it's disconnected.
+func (r *sessionRegistry) Release(c *Client) {
+	r.Lock()
+	delete(r.clients, c)
+	r.Unlock()
+}
+
+// Take removes the sessions logged in with a guildcard for which take returns
+// true and returns them.
+func (r *sessionRegistry) Take(guildcard uint32, take func(c *Client) bool) []*Client {
+	r.Lock()
+	defer r.Unlock()
+	var taken []*Client
+	for c, session := range r.clients {
+		if session.guildcard == guildcard && take(c) {
+			delete(r.clients, c)
+			taken = append(taken, c)
+		}
+	}
+	return taken
+}
+
+func containsClient(clients []*Client, c *Client) bool {
+	for _, other := range clients {
+		if other == c {
+			return true
+		}
+	}
+	return false
+}
+
+// Count the login of the player on c against the session limits and disconnect
+// the sessions that it takes over, or turn them away if they're over. This has
+// to wait until every other check on the login has passed (the two-factor code,
+// maintenance mode, and the session token), so that someone who only has the
+// password can't knock the player's other sessions offline.
+func admitSession(c *Client) error {
+	replaced, err := claimSession(c)
+	if err != nil {
+		return err
+	}
+	takeOverSessions(c, replaced)
+	return nil
+}
+
+// Count the player on c against the session limits, turning them away if
+// they're over. The sessions that they take over are returned to be passed to
+// takeOverSessions.
+func claimSession(c *Client) ([]*Client, error) {
+	replaced, err := loginSessions.Claim(c, config.SessionLimits())
+	if err != nil {
+		SendClientMessage(c, "You can't log in to any more sessions at the moment.\n\n"+
+			"Please close your other sessions and try again.")
+		return nil, refuseLogin(data.LoginSessionLimit, err)
+	}
+	return replaced, nil
+}
+
+// Disconnect the sessions that the player on c took over by logging in, and
+// wait for them to be cleaned up.
+func takeOverSessions(c *Client, replaced []*Client) {
+	for _, other := range replaced {
+		disconnectGhost(other, c.IPAddr())
+	}
+	for _, other := range replaced {
+		if !other.waitDisconnected(ghostReleaseTimeout) {
+			c.log.Warnf("Timed out waiting for the session that guildcard %d took over to end", c.guildcard)
+		}
+	}
+	clearGhostPresence(c)
+}
+
+// Disconnect the sessions on this ship for a guildcard that logged in again from
+// ip on another ship, following the same rules as a login here would.
+func takeOverGhosts(guildcard uint32, ip string) {
+	takeover := config.SessionLimits().SessionTakeover
+	ghosts := loginSessions.Take(guildcard, func(c *Client) bool {
+		return takeover || c.IPAddr() == ip
+	})
+	for _, ghost := range ghosts {
+		disconnectGhost(ghost, ip)
+	}
+}
+
+func disconnectGhost(ghost *Client, ip string) {
+	ghost.log.Infof("Disconnecting session taken over by a new login from %s", ip)
+	SendClientMessage(ghost, "Your account has logged in from somewhere else.")
+	ghost.Close()
+}
@@ -0,0 +1,459 @@
+/*
+ * Generates the binary encoding of packet structs, so that packets can be
+ * encoded and decoded without going through reflection. For each of the named
+ * types (or every struct type in the package if none are named) and the struct
+ * types they contain, it writes AppendBinary, MarshalBinary, and UnmarshalBinary
+ * methods that lay the fields out the same way as util.BytesFromStruct and
+ * util.DecodeStruct, which use them when they exist.
+ *
+ * Run in the directory of the package by "go generate" (see packets/packets.go):
+ *
+ * Usage: go run ../setup/tools/packetgen.go -root .. -o packets_gen.go [Type...]
+ */
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var (
+	output     = flag.String("o", "packets_gen.go", "file to write")
+	modulePath = flag.String("module", "github.com/dcrodman/archon", "import path of the repository")
+	root       = flag.String("root", ".", "path to the root of the repository")
+)
+
+// Type checks the packages in the repository from source. Only the constants
+// and struct layouts matter here, so imports from outside the repository (and
+// the errors from using them) are ignored.
+type sourceImporter struct {
+	fset     *token.FileSet
+	packages map[string]*types.Package
+}
+
+func (imp *sourceImporter) Import(path string) (*types.Package, error) {
+	if pkg, ok := imp.packages[path]; ok {
+		return pkg, nil
+	}
+	if !strings.HasPrefix(path, *modulePath+"/") {
+		pkg := types.NewPackage(path, filepath.Base(path))
+		pkg.MarkComplete()
+		imp.packages[path] = pkg
+		return pkg, nil
+	}
+	pkg, err := imp.check(path, filepath.Join(*root, strings.TrimPrefix(path, *modulePath+"/")))
+	imp.packages[path] = pkg
+	return pkg, err
+}
+
+func (imp *sourceImporter) check(path, dir string) (*types.Package, error) {
+	pkgs, err := parser.ParseDir(imp.fset, dir, func(info os.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go") && info.Name() != filepath.Base(*output)
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+	var files []*ast.File
+	for _, pkg := range pkgs {
+		for _, file := range pkg.Files {
+			files = append(files, file)
+		}
+	}
+	conf := types.Config{Importer: imp, Error: func(error) {}, FakeImportC: true}
+	pkg, _ := conf.Check(path, imp.fset, files, nil)
+	return pkg, nil
+}
+
+// Returns the import path of the package in the current directory.
+func packagePath() (string, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	rootDir, err := filepath.Abs(*root)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(rootDir, wd)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("%s isn't in the repository at %s", wd, rootDir)
+	}
+	if rel == "." {
+		return "main", nil
+	}
+	return *modulePath + "/" + filepath.ToSlash(rel), nil
+}
+
+type generator struct {
+	pkg  *types.Package
+	buf  bytes.Buffer
+	done map[string]bool
+	// Struct types still to be generated.
+	queue []*types.Named
+	// Depth of the loops being generated, for naming their indices.
+	depth int
+	// Whether the code uses the math package, and whether the decoding being
+	// generated calls another type's decoding (and so needs an err).
+	usesMath, decodesStruct bool
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+func (g *generator) typeName(t types.Type) string {
+	return types.TypeString(t, func(pkg *types.Package) string {
+		if pkg == g.pkg {
+			return ""
+		}
+		return pkg.Name()
+	})
+}
+
+func (g *generator) enqueue(named *types.Named) {
+	if name := named.Obj().Name(); !g.done[name] {
+		g.done[name] = true
+		g.queue = append(g.queue, named)
+	}
+}
+
+// Returns the size in bytes of a basic type, or 0 if it isn't supported.
+func basicSize(t types.Type) int {
+	basic, ok := t.Underlying().(*types.Basic)
+	if !ok {
+		return 0
+	}
+	switch basic.Kind() {
+	case types.Bool, types.Uint8, types.Int8:
+		return 1
+	case types.Uint16, types.Int16:
+		return 2
+	case types.Uint32, types.Int32, types.Float32:
+		return 4
+	case types.Uint64, types.Int64, types.Float64:
+		return 8
+	}
+	return 0
+}
+
+func isByte(t types.Type) bool {
+	return types.Identical(t, types.Typ[types.Uint8])
+}
+
+// Returns the expression that converts the basic value x to its bits.
+func (g *generator) bitsOf(x string, t types.Type) string {
+	basic := t.Underlying().(*types.Basic)
+	switch basic.Kind() {
+	case types.Bool:
+		return "boolByte(" + x + ")"
+	case types.Float32:
+		g.usesMath = true
+		return "math.Float32bits(float32(" + x + "))"
+	case types.Float64:
+		g.usesMath = true
+		return "math.Float64bits(float64(" + x + "))"
+	}
+	return fmt.Sprintf("uint%d(%s)", basicSize(t)*8, x)
+}
+
+// Returns the expression that converts bits to a value of the basic type t.
+func (g *generator) valueOf(bits string, t types.Type) string {
+	name := g.typeName(t)
+	basic := t.Underlying().(*types.Basic)
+	switch basic.Kind() {
+	case types.Bool:
+		return bits + " != 0"
+	case types.Float32:
+		g.usesMath = true
+		return name + "(math.Float32frombits(" + bits + "))"
+	case types.Float64:
+		g.usesMath = true
+		return name + "(math.Float64frombits(" + bits + "))"
+	}
+	return name + "(" + bits + ")"
+}
+
+// Returns the expression that reads the bits of a basic value of size bytes at
+// the start of data.
+func readBits(size int, data string) string {
+	if size == 1 {
+		return data + "[0]"
+	}
+	return fmt.Sprintf("binary.LittleEndian.Uint%d(%s)", size*8, data)
+}
+
+func appendFunc(size int) string {
+	if size == 1 {
+		return "append"
+	}
+	return fmt.Sprintf("appendUint%d", size*8)
+}
+
+func (g *generator) index() string {
+	return fmt.Sprintf("i%d", g.depth)
+}
+
+// Write the statements that append x, of type t, to b.
+func (g *generator) appendValue(x string, t types.Type, field string) error {
+	if size := basicSize(t); size > 0 {
+		g.printf("b = %s(b, %s)\n", appendFunc(size), g.bitsOf(x, t))
+		return nil
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Struct:
+		named, ok := t.(*types.Named)
+		if !ok || named.Obj().Pkg() != g.pkg {
+			return fmt.Errorf("field %s: only struct types declared in the package are supported", field)
+		}
+		g.enqueue(named)
+		g.printf("b = %s.appendBinary(b)\n", x)
+	case *types.Pointer:
+		// Encoded as the struct it points to, which has to be there.
+		named, ok := u.Elem().(*types.Named)
+		if _, isStruct := u.Elem().Underlying().(*types.Struct); !ok || !isStruct || named.Obj().Pkg() != g.pkg {
+			return fmt.Errorf("field %s: only pointers to struct types declared in the package are supported", field)
+		}
+		g.enqueue(named)
+		g.printf("b = %s.appendBinary(b)\n", x)
+	case *types.Array:
+		if isByte(u.Elem()) {
+			g.printf("b = append(b, %s[:]...)\n", x)
+			return nil
+		}
+		return g.appendElements(x, u.Elem(), field)
+	case *types.Slice:
+		if isByte(u.Elem()) {
+			g.printf("b = append(b, %s...)\n", x)
+			return nil
+		}
+		return g.appendElements(x, u.Elem(), field)
+	default:
+		return fmt.Errorf("field %s: unsupported type %s", field, g.typeName(t))
+	}
+	return nil
+}
+
+func (g *generator) appendElements(x string, elem types.Type, field string) error {
+	i := g.index()
+	g.printf("for %s := range %s {\n", i, x)
+	g.depth++
+	err := g.appendValue(x+"["+i+"]", elem, field)
+	g.depth--
+	g.printf("}\n")
+	return err
+}
+
+// Write the statements that decode x, of type t, from the start of data.
+func (g *generator) decodeValue(x string, t types.Type, field string) error {
+	if size := basicSize(t); size > 0 {
+		g.printf("if len(data) < %d {\nreturn data, shortField(%q)\n}\n", size, field)
+		g.printf("%s = %s\n", x, g.valueOf(readBits(size, "data"), t))
+		g.printf("data = data[%d:]\n", size)
+		return nil
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Struct:
+		named, ok := t.(*types.Named)
+		if !ok || named.Obj().Pkg() != g.pkg {
+			return fmt.Errorf("field %s: only struct types declared in the package are supported", field)
+		}
+		g.enqueue(named)
+		g.decodesStruct = true
+		g.printf("if data, err = %s.decodeBinary(data); err != nil {\nreturn data, err\n}\n", x)
+	case *types.Pointer:
+		named, ok := u.Elem().(*types.Named)
+		if _, isStruct := u.Elem().Underlying().(*types.Struct); !ok || !isStruct || named.Obj().Pkg() != g.pkg {
+			return fmt.Errorf("field %s: only pointers to struct types declared in the package are supported", field)
+		}
+		g.enqueue(named)
+		g.decodesStruct = true
+		g.printf("if %s == nil {\n%s = new(%s)\n}\n", x, x, g.typeName(named))
+		g.printf("if data, err = %s.decodeBinary(data); err != nil {\nreturn data, err\n}\n", x)
+	case *types.Array:
+		return g.decodeElements(x, u.Elem(), fmt.Sprint(u.Len()), field)
+	case *types.Slice:
+		// Slices are read at their current length, as binary.Read does.
+		return g.decodeElements(x, u.Elem(), "len("+x+")", field)
+	default:
+		return fmt.Errorf("field %s: unsupported type %s", field, g.typeName(t))
+	}
+	return nil
+}
+
+func (g *generator) decodeElements(x string, elem types.Type, n string, field string) error {
+	size := basicSize(elem)
+	if size == 0 {
+		i := g.index()
+		g.printf("for %s := range %s {\n", i, x)
+		g.depth++
+		err := g.decodeValue(x+"["+i+"]", elem, field)
+		g.depth--
+		g.printf("}\n")
+		return err
+	}
+	// Check the length once for the whole array rather than for each element.
+	total := n
+	if size > 1 {
+		total = fmt.Sprintf("%s*%d", n, size)
+	}
+	g.printf("if len(data) < %s {\nreturn data, shortField(%q)\n}\n", total, field)
+	if isByte(elem) {
+		g.printf("copy(%s[:], data)\n", x)
+	} else {
+		i := g.index()
+		g.printf("for %s := range %s {\n", i, x)
+		g.printf("%s[%s] = %s\n", x, i, g.valueOf(readBits(size, fmt.Sprintf("data[%s*%d:]", i, size)), elem))
+		g.printf("}\n")
+	}
+	g.printf("data = data[%s:]\n", total)
+	return nil
+}
+
+func (g *generator) generate(named *types.Named) error {
+	name := named.Obj().Name()
+	st, ok := named.Underlying().(*types.Struct)
+	if !ok {
+		return fmt.Errorf("%s isn't a struct", name)
+	}
+
+	g.printf("\n// AppendBinary appends the encoding of p to b.\n")
+	g.printf("func (p *%s) AppendBinary(b []byte) ([]byte, error) {\nreturn p.appendBinary(b), nil\n}\n", name)
+	g.printf("\n// MarshalBinary returns the encoding of p.\n")
+	g.printf("func (p *%s) MarshalBinary() ([]byte, error) {\nreturn p.appendBinary(nil), nil\n}\n", name)
+	g.printf("\n// UnmarshalBinary fills in p from the start of data.\n")
+	g.printf("func (p *%s) UnmarshalBinary(data []byte) error {\n_, err := p.decodeBinary(data)\nreturn err\n}\n", name)
+
+	g.printf("\nfunc (p *%s) appendBinary(b []byte) []byte {\n", name)
+	for i := 0; i < st.NumFields(); i++ {
+		f := st.Field(i)
+		if err := g.appendValue("p."+f.Name(), f.Type(), name+"."+f.Name()); err != nil {
+			return err
+		}
+	}
+	g.printf("return b\n}\n")
+
+	// Generate the body first to find out whether it needs an err.
+	g.printf("\nfunc (p *%s) decodeBinary(data []byte) ([]byte, error) {\n", name)
+	decl := g.buf
+	g.buf, g.decodesStruct = bytes.Buffer{}, false
+	for i := 0; i < st.NumFields(); i++ {
+		f := st.Field(i)
+		if err := g.decodeValue("p."+f.Name(), f.Type(), f.Name()); err != nil {
+			return err
+		}
+	}
+	body := g.buf
+	g.buf = decl
+	if g.decodesStruct {
+		g.printf("var err error\n")
+	}
+	g.buf.Write(body.Bytes())
+	g.printf("return data, nil\n}\n")
+	return nil
+}
+
+const header = `// Code generated by setup/tools/packetgen.go; DO NOT EDIT.
+// Run "go generate" after changing any of these structs.
+
+package %s
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v), byte(v>>8))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return appendUint32(appendUint32(b, uint32(v)), uint32(v>>32))
+}
+
+func boolByte(v bool) byte {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+// Matches the errors from util.DecodeStruct.
+func shortField(name string) error {
+	return fmt.Errorf("field %%s: %%s", name, io.ErrUnexpectedEOF)
+}
+`
+
+func main() {
+	flag.Parse()
+
+	path, err := packagePath()
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	imp := &sourceImporter{fset: token.NewFileSet(), packages: make(map[string]*types.Package)}
+	pkg, err := imp.check(path, ".")
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	g := &generator{pkg: pkg, done: make(map[string]bool)}
+	names := flag.Args()
+	if len(names) == 0 {
+		for _, name := range pkg.Scope().Names() {
+			if obj, ok := pkg.Scope().Lookup(name).(*types.TypeName); ok {
+				if _, ok = obj.Type().Underlying().(*types.Struct); ok {
+					names = append(names, name)
+				}
+			}
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		obj, ok := pkg.Scope().Lookup(name).(*types.TypeName)
+		if !ok {
+			fmt.Println("No type named " + name)
+			os.Exit(1)
+		}
+		g.enqueue(obj.Type().(*types.Named))
+	}
+	for len(g.queue) > 0 {
+		named := g.queue[0]
+		g.queue = g.queue[1:]
+		if err := g.generate(named); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+	}
+
+	prelude := fmt.Sprintf(header, pkg.Name())
+	if g.usesMath {
+		prelude = strings.Replace(prelude, "\t\"io\"\n", "\t\"io\"\n\t\"math\"\n", 1)
+	}
+	src, err := format.Source(append([]byte(prelude), g.buf.Bytes()...))
+	if err != nil {
+		fmt.Println("Failed to format the generated code: " + err.Error())
+		os.Exit(1)
+	}
+	if err = ioutil.WriteFile(*output, src, 0644); err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+}
@@ -25,17 +25,23 @@ import (
 	"net"
 	"strconv"
 
-	crypto "github.com/dcrodman/archon/encryption"
+	crypto "github.com/dcrodman/archon/libarchon/encryption"
+	"github.com/dcrodman/archon/packets"
 	"github.com/dcrodman/archon/util"
 )
 
-// Block ID reserved for returning to the ship select menu.
-const BackMenuItem = 0xFF
+const (
+	// Block ID reserved for returning to the ship select menu.
+	BackMenuItem = 0xFF
+	// Id sent in the menu selection packet to tell the server that
+	// the selection was made on the block menu.
+	BlockSelectionMenuId uint16 = 0x12
+)
 
-func NewShipClient(conn *net.TCPConn) (*Client, error) {
+func NewShipClient(conn net.Conn) (*Client, error) {
 	cCrypt := crypto.NewBBCrypt()
 	sCrypt := crypto.NewBBCrypt()
-	sc := NewClient(conn, BBHeaderSize, cCrypt, sCrypt)
+	sc := NewClient(conn, packets.BBHeaderSize, cCrypt, sCrypt)
 
 	err := error(nil)
 	if SendWelcome(sc) != nil {
@@ -47,65 +53,177 @@ func NewShipClient(conn *net.TCPConn) (*Client, error) {
 
 type ShipServer struct {
 	// Precomputed block packet.
-	blockPkt *BlockListPacket
+	blockPkt *packets.BlockListPacket
 }
 
 func (server *ShipServer) Name() string { return "SHIP" }
 
 func (server *ShipServer) Port() string { return config.ShipPort }
 
+var shipPacketSizes = map[uint16]int{
+	packets.LoginType:      packetSize(&packets.LoginPkt{}),
+	packets.MenuSelectType: packetSize(&packets.MenuSelectionPacket{}),
+}
+
+func (server *ShipServer) MinPacketSizes() map[uint16]int { return shipPacketSizes }
+
 func (server *ShipServer) Init() error {
 	// Precompute the block list packet since it's not going to change.
-	numBlocks := config.NumBlocks
-	ship := shipList[0]
+	server.blockPkt = newBlockListPacket(localShip, config.NumBlocks)
+	n, err := quests.Load(config.QuestDir)
+	if err != nil {
+		return errors.New("Error loading quests: " + err.Error())
+	}
+	fmt.Printf("Loaded %d quests from %s\n", n, config.QuestDir)
+	if n, err = dropTables.Load(config.DropDir); err != nil {
+		return errors.New("Error loading drop tables: " + err.Error())
+	}
+	fmt.Printf("Loaded %d drop tables from %s\n", n, config.DropDir)
+	if err = shops.Load(config.ShopFile); err != nil {
+		return errors.New("Error loading shops: " + err.Error())
+	}
+	if err = experience.Load(config.ExperienceFile); err != nil {
+		return errors.New("Error loading experience: " + err.Error())
+	}
+	if n, err = mapLayouts.Load(config.MapDir); err != nil {
+		return errors.New("Error loading maps: " + err.Error())
+	}
+	fmt.Printf("Loaded %d map layouts from %s\n", n, config.MapDir)
+	if err = challenges.Load(config.ChallengeFile); err != nil {
+		return errors.New("Error loading challenge ranks: " + err.Error())
+	}
+	if err = battleRules.Load(config.BattleFile); err != nil {
+		return errors.New("Error loading battle rules: " + err.Error())
+	}
+	if err = chatFilters.Load(config.ChatFilterFile); err != nil {
+		return errors.New("Error loading chat filters: " + err.Error())
+	}
+	if err = anticheat.Load(config.AnticheatFile); err != nil {
+		return errors.New("Error loading anti-cheat rules: " + err.Error())
+	}
+	if err = nameFilter.Load(config.NameFilterFile); err != nil {
+		return errors.New("Error loading name filter: " + err.Error())
+	}
+	if err = infoPages.Load(config.InfoFile); err != nil {
+		return errors.New("Error loading information pages: " + err.Error())
+	}
+	if levels, err = loadLevelTable(config.ParametersDir); err != nil {
+		return err
+	}
+	if err = saves.Open(config.SaveJournal); err != nil {
+		return err
+	}
+	go saves.run(saveInterval())
+	go itemAudit.run(itemAuditInterval)
+	go purgeSnapshots()
+	go enforceBans()
+	go runEconomyStats()
+	return nil
+}
+
+// Reload the quests, their manifests, the drop tables, the shops, the experience
+// given for each enemy, the map layouts, the challenge mode ranks, the battle
+// rules, the chat filters, the anti-cheat rules, the name filter, and the
+// information pages. Games that have already started a quest keep playing the
+// one they loaded.
+func (server *ShipServer) Reload() error {
+	n, err := quests.Load(config.QuestDir)
+	if err != nil {
+		return err
+	}
+	log.Infof("Loaded %d quests from %s", n, config.QuestDir)
+	if n, err = dropTables.Load(config.DropDir); err != nil {
+		return err
+	}
+	log.Infof("Loaded %d drop tables from %s", n, config.DropDir)
+	if err = shops.Load(config.ShopFile); err != nil {
+		return err
+	}
+	if err = experience.Load(config.ExperienceFile); err != nil {
+		return err
+	}
+	if n, err = mapLayouts.Load(config.MapDir); err != nil {
+		return err
+	}
+	log.Infof("Loaded %d map layouts from %s", n, config.MapDir)
+	if err = challenges.Load(config.ChallengeFile); err != nil {
+		return err
+	}
+	if err = battleRules.Load(config.BattleFile); err != nil {
+		return err
+	}
+	if err = chatFilters.Load(config.ChatFilterFile); err != nil {
+		return err
+	}
+	if err = anticheat.Load(config.AnticheatFile); err != nil {
+		return err
+	}
+	if err = nameFilter.Load(config.NameFilterFile); err != nil {
+		return err
+	}
+	return infoPages.Load(config.InfoFile)
+}
 
-	server.blockPkt = &BlockListPacket{
-		Header:  BBHeader{Type: BlockListType, Flags: uint32(numBlocks + 1)},
+// Build the block selection menu for a ship. This is shared by the ship
+// and block servers since players can also change blocks from a lobby.
+func newBlockListPacket(ship *Ship, numBlocks int) *packets.BlockListPacket {
+	pkt := &packets.BlockListPacket{
+		Header:  packets.BBHeader{Type: packets.BlockListType, Flags: uint32(numBlocks + 1)},
 		Unknown: 0x08,
-		Blocks:  make([]Block, numBlocks+1),
+		Blocks:  make([]packets.Block, numBlocks+1),
 	}
-	shipName := fmt.Sprintf("%d:%s", ship.id, ship.name)
-	copy(server.blockPkt.ShipName[:], util.ConvertToUtf16(shipName))
+	shipName := fmt.Sprintf("%d:%s", ship.id, util.StripPadding(ship.name[:]))
+	copy(pkt.ShipName[:], util.ConvertToUtf16(shipName))
 
 	for i := 0; i < numBlocks; i++ {
-		b := &server.blockPkt.Blocks[i]
-		b.Unknown = 0x12
+		b := &pkt.Blocks[i]
+		b.MenuId = BlockSelectionMenuId
 		b.BlockId = uint32(i + 1)
 		blockName := fmt.Sprintf("BLOCK %02d", i+1)
 		copy(b.BlockName[:], util.ConvertToUtf16(blockName))
 	}
 	// Always append a menu item for returning to the ship select screen.
-	b := &server.blockPkt.Blocks[numBlocks]
-	b.Unknown = 0x12
+	b := &pkt.Blocks[numBlocks]
+	b.MenuId = BlockSelectionMenuId
 	b.BlockId = BackMenuItem
 	copy(b.BlockName[:], util.ConvertToUtf16("Ship Selection"))
-	return nil
+	return pkt
 }
 
-func (server *ShipServer) NewClient(conn *net.TCPConn) (*Client, error) {
+func (server *ShipServer) NewClient(conn net.Conn) (*Client, error) {
 	return NewShipClient(conn)
 }
 
 func (server *ShipServer) Handle(c *Client) error {
-	var hdr BBHeader
-	util.StructFromBytes(c.Data()[:BBHeaderSize], &hdr)
+	var hdr packets.BBHeader
+	if err := c.Decode(&hdr); err != nil {
+		return err
+	}
 
 	var err error
 	switch hdr.Type {
-	case LoginType:
+	case packets.LoginType:
 		err = server.HandleShipLogin(c)
-	case MenuSelectType:
-		var pkt MenuSelectionPacket
-		util.StructFromBytes(c.Data(), &pkt)
+	case packets.MenuSelectType:
+		var pkt packets.MenuSelectionPacket
+		if err := c.Decode(&pkt); err != nil {
+			return err
+		}
 		// They can be at either the ship or block selection menu, so make sure we have the right one.
-		if pkt.MenuId == ShipSelectionMenuId {
-			// TODO: Hack for now, but this coupling on the login server logic needs to go away.
-			err = server.HandleShipSelection(c)
-		} else {
+		switch pkt.MenuId {
+		case ShipSelectionMenuId:
+			err = server.HandleShipSelection(c, pkt)
+		case BlockSelectionMenuId:
 			err = server.HandleBlockSelection(c, pkt)
+		case InfoMenuId:
+			err = infoPages.HandleSelection(c, pkt, func() error {
+				return server.SendShipList(c, ships.List())
+			})
+		default:
+			err = fmt.Errorf("Unknown menu selection %x from %s", pkt.MenuId, c.IPAddr())
 		}
 	default:
-		log.Infof("Received unknown packet %02x from %s", hdr.Type, c.IPAddr())
+		c.log.Infof("Received unknown packet %02x", hdr.Type)
 	}
 	return err
 }
@@ -114,20 +232,40 @@ func (server *ShipServer) HandleShipLogin(sc *Client) error {
 	if _, err := VerifyAccount(sc); err != nil {
 		return err
 	}
-	if err := server.sendSecurity(sc, BBLoginErrorNone, sc.guildcard, sc.teamId); err != nil {
+	if err := verifySession(sc); err != nil {
+		server.sendSecurity(sc, packets.BBLoginErrorUnknown, sc.guildcard, sc.teamId)
+		return err
+	}
+	// The ship list won't send them here, but they could connect anyway.
+	if localShip.Locked(sc) {
+		server.sendSecurity(sc, packets.BBLoginErrorLocked, sc.guildcard, sc.teamId)
+		return errors.New("Refused ship login without privileges for username: " + sc.username)
+	}
+	if err := admitSession(sc); err != nil {
+		return err
+	}
+	if localShip.Full() {
+		SendClientMessage(sc, "This ship is full.")
+		server.sendSecurity(sc, packets.BBLoginErrorFull, sc.guildcard, sc.teamId)
+		return errors.New("Refused ship login to a full ship for username: " + sc.username)
+	}
+	if err := server.sendSecurity(sc, packets.BBLoginErrorNone, sc.guildcard, sc.teamId); err != nil {
 		return err
 	}
-	return server.sendBlockList(sc)
+	if err := server.sendBlockList(sc); err != nil {
+		return err
+	}
+	return sendScrollMessage(sc, int(sc.config.SlotNum), true)
 }
 
 // Send the security initialization packet with information about the user's
 // authentication status.
-func (server *ShipServer) sendSecurity(client *Client, errorCode BBLoginError,
+func (server *ShipServer) sendSecurity(client *Client, errorCode packets.BBLoginError,
 	guildcard uint32, teamId uint32) error {
 
 	// Constants set according to how Newserv does it.
-	pkt := &SecurityPacket{
-		Header:       BBHeader{Type: LoginSecurityType},
+	pkt := &packets.SecurityPacket{
+		Header:       packets.BBHeader{Type: packets.LoginSecurityType},
 		ErrorCode:    uint32(errorCode),
 		PlayerTag:    0x00010000,
 		Guildcard:    guildcard,
@@ -135,61 +273,41 @@ func (server *ShipServer) sendSecurity(client *Client, errorCode BBLoginError,
 		Config:       &client.config,
 		Capabilities: 0x00000102,
 	}
-	DebugLog("Sending Security Packet")
+	client.log.Debug("Sending Security Packet")
 	return EncryptAndSend(client, pkt)
 }
 
 // Send the client the block list on the selection screen.
 func (server *ShipServer) sendBlockList(client *Client) error {
-	DebugLog("Sending Block Packet")
+	client.log.Debug("Sending Block Packet")
 	return EncryptAndSend(client, server.blockPkt)
 }
 
 // Player selected one of the items on the ship select screen.
-func (server *ShipServer) HandleShipSelection(client *Client) error {
-	var pkt MenuSelectionPacket
-	util.StructFromBytes(client.Data(), &pkt)
-	selectedShip := pkt.ItemId - 1
-	if selectedShip < 0 || selectedShip >= uint32(len(shipList)) {
-		return errors.New("Invalid ship selection: " + string(selectedShip))
-	}
-	s := &shipList[selectedShip]
-	return SendRedirect(client, s.ipAddr[:], s.port)
+func (server *ShipServer) HandleShipSelection(client *Client, pkt packets.MenuSelectionPacket) error {
+	return selectShip(client, pkt, func() error {
+		return server.SendShipList(client, ships.List())
+	})
 }
 
 // The player selected a block to join from the menu.
-func (server *ShipServer) HandleBlockSelection(sc *Client, pkt MenuSelectionPacket) error {
+func (server *ShipServer) HandleBlockSelection(sc *Client, pkt packets.MenuSelectionPacket) error {
 	// Grab the chosen block and redirect them to the selected block server.
 	port, _ := strconv.ParseInt(config.ShipPort, 10, 16)
 	selectedBlock := pkt.ItemId
 	if selectedBlock == BackMenuItem {
-		server.SendShipList(sc, shipList)
-	} else if int(selectedBlock) > config.NumBlocks {
+		return server.SendShipList(sc, ships.List())
+	} else if selectedBlock < 1 || int(selectedBlock) > config.NumBlocks {
 		return fmt.Errorf("Block selection %v out of range %v", selectedBlock, config.NumBlocks)
 	}
-	ipAddr := config.BroadcastIP()
-	return SendRedirect(sc, ipAddr[:], uint16(uint32(port)+selectedBlock))
+	return SendRedirect(sc, redirectIP(sc, fmt.Sprintf("block%d", selectedBlock)), uint16(uint32(port)+selectedBlock))
 }
 
 // Send the menu items for the ship select screen.
-func (server *ShipServer) SendShipList(client *Client, ships []Ship) error {
-	pkt := &ShipListPacket{
-		Header:      BBHeader{Type: LoginShipListType, Flags: 0x01},
-		Unknown:     0x02,
-		Unknown2:    0xFFFFFFF4,
-		Unknown3:    0x04,
-		ShipEntries: make([]ShipMenuEntry, len(ships)),
-	}
-	copy(pkt.ServerName[:], "Archon")
-
-	// TODO: Will eventually need a mutex for read.
-	for i, ship := range ships {
-		item := &pkt.ShipEntries[i]
-		item.MenuId = ShipSelectionMenuId
-		item.ShipId = ship.id
-		copy(item.Shipname[:], util.ConvertToUtf16(string(ship.name[:])))
+func (server *ShipServer) SendShipList(client *Client, ships []*Ship) error {
+	client.log.Debug("Sending Ship List Packet")
+	if err := EncryptAndSend(client, newShipListPacket(client, ships)); err != nil {
+		return err
 	}
-
-	DebugLog("Sending Ship List Packet")
-	return EncryptAndSend(client, pkt)
+	return sendScrollMessage(client, int(client.config.SlotNum), true)
 }
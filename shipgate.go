@@ -1,349 +1,693 @@
 /*
-* Handles the connection initialization and management for connected
-* ships. This module handles all of its own connection logic since the
-* shipgate protocol differs from the way game clients are processed.
+* The SHIPGATE keeps track of the ships available to players and relays
+* messages between them. The ship hosted by this server is always registered;
+* other servers can register their ships by connecting over TLS and presenting
+* the shared shipgate key. The certificate can be generated with
+* setup/tools/generate_cert.go.
+*
+* The ships also keep each other up to date on who is online where so that
+* players can be reached on other ships (see online.go).
  */
 package main
 
 import (
-	// 	"crypto/tls"
-	// 	"errors"
-	// "fmt"
-	// 	"io"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
 	"net"
-	// 	"os"
-	// 	"runtime/debug"
-	// 	"strings"
-	// 	"sync"
-	// 	"time"
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/dcrodman/archon/util"
 )
 
+// Packet types for the shipgate. These can overlap since they aren't
+// processed by the same set of handlers as the client ones.
+const (
+	ShipgateHeaderSize    = 8
+	ShipgateAuthType      = 0x01
+	ShipgateAuthAckType   = 0x02
+	ShipgateMessageType   = 0x03
+	ShipgateHeartbeatType = 0x04
+	ShipgatePlayerType    = 0x05
+	ShipgateMailType      = 0x06
+	ShipgateKickType      = 0x07
+	ShipgateLockType      = 0x08
+)
+
+// Version of the shipgate protocol that ships send when they register. It's
+// bumped whenever a packet changes in a way that the other end can't read, and
+// the shipgate turns away ships that speak any other version.
+const ShipgateVersion = 2
+
+// Status codes sent in the auth acknowledgement.
+const (
+	ShipgateAuthOk       = 0
+	ShipgateAuthRejected = 1
+	// The ship speaks a different ShipgateVersion.
+	ShipgateAuthVersion = 2
+)
+
+const (
+	// How long a ship waits before trying to reconnect to the shipgate.
+	shipgateRetryInterval = 10 * time.Second
+	// How often ships report their status to the shipgate.
+	shipHeartbeatInterval = 30 * time.Second
+	// Ships that haven't sent a heartbeat in this long are dropped from the menu.
+	shipHeartbeatTimeout = 3 * shipHeartbeatInterval
+)
+
+type ShipgateHeader struct {
+	Size uint16
+	Type uint16
+	// Used to distinguish between requests.
+	Id uint32
+}
+
+// Sent by a ship to register itself with the shipgate.
+type ShipgateAuthPacket struct {
+	Header ShipgateHeader
+	// SHA-256 of the shared shipgate key.
+	Key     [32]byte
+	Name    [23]byte
+	Padding byte
+	IPAddr  [4]byte
+	Port    uint16
+	// Most players the ship can hold, or 0 if it has no limit.
+	MaxPlayers uint16
+	// All zeroes if the ship has no IPv6 address.
+	IPv6Addr [16]byte
+	// ShipgateVersion of the ship. Ships from before the version was sent have
+	// a shorter packet.
+	Version uint16
+	// Lowest privilege tier that can join the ship.
+	MinPrivilege byte
+	Unused       byte
+}
+
+// Response to a ship's registration with the id it was assigned.
+type ShipgateAuthAckPacket struct {
+	Header ShipgateHeader
+	Status uint32
+	ShipId uint32
+}
+
+// Message from a player (or the server, if Sender is 0) to be displayed to a
+// player on another ship, or to everyone if Recipient is 0.
+type ShipgateMessagePacket struct {
+	Header    ShipgateHeader
+	Sender    uint32
+	Recipient uint32
+	Message   []byte
+}
+
+// Sent periodically by each ship to let the shipgate know it's still alive.
+type ShipgateHeartbeatPacket struct {
+	Header     ShipgateHeader
+	NumPlayers uint32
+}
+
+// Sent by a ship when a player logs on to or off of one of its blocks and relayed
+// by the shipgate to the other ships (see online.go), with ShipId set to the id
+// that the shipgate knows the ship by.
+type ShipgatePlayerPacket struct {
+	Header    ShipgateHeader
+	Guildcard uint32
+	ShipId    uint32
+	// 0 once the player has logged off.
+	Block    uint32
+	ShipName [23]byte
+	Padding  byte
+	// Address of the player's block.
+	IPAddr [4]byte
+	Port   uint16
+	// Number of the player's lobby, counting from 1, or 0 if they're in a game.
+	Lobby uint16
+	Name  [16]uint16
+	// Unix time at which the player logged on to the ship.
+	Since int64
+}
+
+// Simple mail for a player on another ship.
+type ShipgateMailPacket struct {
+	Header     ShipgateHeader
+	Sender     uint32
+	Recipient  uint32
+	SentAt     int64
+	SenderName [16]uint16
+	Message    [0x200]uint16
+}
+
+// Asks the ship that a player is on to disconnect them because they've logged in
+// again from IPAddr.
+type ShipgateKickPacket struct {
+	Header    ShipgateHeader
+	Guildcard uint32
+	IPAddr    [4]byte
+}
+
+// Takes, refreshes, or releases the lock on a character (see charlock.go). The
+// shipgate answers requests to take a lock with the same packet and a Status.
+type ShipgateLockPacket struct {
+	Header    ShipgateHeader
+	Guildcard uint32
+	Slot      uint32
+	Action    uint16
+	Status    uint16
+}
+
+// Ship is an entry on the ship selection menu.
 type Ship struct {
 	name [23]byte
 	id   uint32
 
 	ipAddr [4]byte
 	port   uint16
+	// Given to clients that connected over IPv6; all zeroes if the ship has none.
+	ipv6Addr [16]byte
+
+	// Connection to the shipgate for ships hosted by other servers; nil for our own.
+	client *Client
+	// Most players the ship can hold, or 0 if it has no limit.
+	maxPlayers int
+	// Lowest privilege tier that can join the ship.
+	minPrivilege byte
+	// Reported by remote ships with each heartbeat. Accessed atomically.
+	numPlayers    uint32
+	lastHeartbeat int64
+}
 
-	// conn   net.Conn
-	// recvSize   int
-	// packetSize uint16
-	// buffer     []byte
-}
-
-// func (s *Ship) Client() Client { return s }
-// func (s *Ship) IPAddr() string { return s.ipAddr }
-// func (s *Ship) Data() []byte   { return s.buffer[:s.packetSize] }
-// func (s *Ship) Close()         { s.conn.Close() }
-
-// // Encryption/decryption is handled by the TLS connection.
-// func (s *Ship) Encrypt(data []byte, size uint32) {}
-// func (s *Ship) Decrypt(data []byte, size uint32) {}
-
-// func (s *Ship) Send(data []byte) error {
-// 	_, err := s.conn.Write(data)
-// 	return err
-// }
-
-// func (s *Ship) Process() error {
-// 	s.recvSize = 0
-// 	s.packetSize = 0
-
-// 	// Wait for the packet header.
-// 	for s.recvSize < ShipgateHeaderSize {
-// 		bytes, err := s.conn.Read(s.buffer[s.recvSize:ShipgateHeaderSize])
-// 		if bytes == 0 || err == io.EOF {
-// 			// The client disconnected, we're done.
-// 			return err
-// 		} else if err != nil {
-// 			fmt.Println("Sockt error")
-// 			// Socket error, nothing we can do now
-// 			return errors.New("Socket Error (" + s.ipAddr + ") " + err.Error())
-// 		}
-// 		s.recvSize += bytes
-// 		s.packetSize, _ = util.GetPacketSize(s.buffer[:2])
-// 	}
-// 	pktSize := int(s.packetSize)
-
-// 	// Grow the client's receive buffer if they send us a packet bigger
-// 	// than its current capacity.
-// 	if pktSize > cap(s.buffer) {
-// 		newSize := pktSize + len(s.buffer)
-// 		newBuf := make([]byte, newSize)
-// 		copy(newBuf, s.buffer)
-// 		s.buffer = newBuf
-// 	}
-
-// 	// Read in the rest of the packet.
-// 	for s.recvSize < pktSize {
-// 		remaining := pktSize - s.recvSize
-// 		bytes, err := s.conn.Read(s.buffer[s.recvSize : s.recvSize+remaining])
-// 		if err != nil {
-// 			return errors.New("Socket Error (" + s.ipAddr + ") " + err.Error())
-// 		}
-// 		s.recvSize += bytes
-// 	}
-// 	return nil
-// }
-
-// // Wraps Process() in a channel that can be used for timeouts.
-// func (s *Ship) Read() <-chan error {
-// 	c := make(chan error)
-// 	go func() {
-// 		c <- s.Process()
-// 	}()
-// 	return c
-// }
+// NumPlayers returns the number of players connected to the ship's blocks.
+func (s *Ship) NumPlayers() int {
+	if s.client == nil {
+		return players.Len()
+	}
+	return int(atomic.LoadUint32(&s.numPlayers))
+}
+
+// Locked returns true if the player on c doesn't have the privileges to join the
+// ship.
+func (s *Ship) Locked(c *Client) bool {
+	return !c.hasPrivilege(s.minPrivilege)
+}
+
+// Full returns true if the ship has no room for another player.
+func (s *Ship) Full() bool {
+	return s.maxPlayers > 0 && s.NumPlayers() >= s.maxPlayers
+}
+
+// Returns the address of the ship to redirect client to. The addresses of
+// remote ships are the ones they registered with.
+func (s *Ship) redirectIP(client *Client) []byte {
+	if s.client == nil {
+		return redirectIP(client, "ship")
+	}
+	return chooseRedirectIP(client, s.ipAddr, s.ipv6Addr)
+}
+
+// Record a heartbeat from a remote ship.
+func (s *Ship) heartbeat(numPlayers uint32) {
+	atomic.StoreUint32(&s.numPlayers, numPlayers)
+	atomic.StoreInt64(&s.lastHeartbeat, time.Now().Unix())
+}
+
+// Returns true if a remote ship hasn't sent a heartbeat within the timeout.
+func (s *Ship) expired(now time.Time) bool {
+	last := time.Unix(atomic.LoadInt64(&s.lastHeartbeat), 0)
+	return s.client != nil && now.Sub(last) > shipHeartbeatTimeout
+}
+
+// Synchronized list of the registered ships. Ships are assigned ids in the
+// order in which they register and are listed in that order.
+type shipRegistry struct {
+	ships  []*Ship
+	nextId uint32
+	sync.RWMutex
+}
+
+var (
+	ships = new(shipRegistry)
+	// The ship hosted by this server.
+	localShip = new(Ship)
+	// Connection to a remote shipgate if this ship is registered with one.
+	shipgateLink     *Client
+	shipgateLinkLock sync.RWMutex
+)
+
+func (r *shipRegistry) Add(s *Ship) {
+	r.Lock()
+	r.nextId++
+	s.id = r.nextId
+	r.ships = append(r.ships, s)
+	r.Unlock()
+}
+
+func (r *shipRegistry) Remove(s *Ship) {
+	r.Lock()
+	defer r.Unlock()
+	for i, ship := range r.ships {
+		if ship == s {
+			r.ships = append(r.ships[:i], r.ships[i+1:]...)
+			return
+		}
+	}
+}
+
+// Find returns the ship with the specified id or nil if it isn't registered.
+func (r *shipRegistry) Find(id uint32) *Ship {
+	r.RLock()
+	defer r.RUnlock()
+	for _, s := range r.ships {
+		if s.id == id {
+			return s
+		}
+	}
+	return nil
+}
+
+// List returns a copy of the registered ships that's safe to iterate over.
+func (r *shipRegistry) List() []*Ship {
+	r.RLock()
+	defer r.RUnlock()
+	return append([]*Ship(nil), r.ships...)
+}
 
-// Packet types for the shipgate. These can overlap since they aren't
-// processed by the same set of handlers as the client ones.
-// const (
-// 	ShipgateHeaderSize  = 8
-// 	ShipgateAuthType    = 0x01
-// 	ShipgateAuthAckType = 0x02
-// 	ShipgatePingType    = 0x03
-// )
-
-// type ShipgateHeader struct {
-// 	Size uint16
-// 	Type uint16
-// 	// Used to distinguish between requests.
-// 	Id uint32
-// }
-
-// // Initial auth request sent to the shipgate.
-// type ShipgateAuthPkt struct {
-// 	Header ShipgateHeader
-// 	Name   [24]byte
-// }
-
-// // Send the packet serialized (or otherwise contained) in pkt to a ship.
-// func SendShipPacket(ship *Ship, pkt []byte, length uint16) int {
-// 	if err := ship.Send(pkt[:length]); err != nil {
-// 		log.Warn("Error sending to ship %v: %s", ship.IPAddr(), err.Error())
-// 		return -1
-// 	}
-// 	return 0
-// }
-
-// // Ship name acknowledgement.
-// func SendAuthAck(ship *Ship) int {
-// 	pkt := &ShipgateHeader{
-// 		Size: ShipgateHeaderSize,
-// 		Type: ShipgateAuthAckType,
-// 		Id:   0,
-// 	}
-// 	data, size := util.BytesFromStruct(pkt)
-// 	if config.DebugMode {
-// 		fmt.Println("Sending Auth Ack")
-// 		util.PrintPayload(data, size)
-// 		fmt.Println()
-// 	}
-// 	return SendShipPacket(ship, data, uint16(size))
-// }
-
-// // Liveliness check.
-// func SendPing(ship *Ship) int {
-// 	pkt := &ShipgateHeader{
-// 		Size: ShipgateHeaderSize,
-// 		Type: ShipgatePingType,
-// 		Id:   0,
-// 	}
-// 	data, size := util.BytesFromStruct(pkt)
-// 	if config.DebugMode {
-// 		fmt.Println("Sending Ping")
-// 		util.PrintPayload(data, size)
-// 		fmt.Println()
-// 	}
-// 	return SendShipPacket(ship, data, uint16(size))
-// }
-
-// Loop for the life of the server, pinging the shipgate every 30
-// seconds to update the list of available ships.
-// func fetchShipList() {
-// 	config := GetConfig()
-// 	errorInterval, pingInterval := time.Second*5, time.Second*60
-// 	shipgateUrl := fmt.Sprintf("http://%s:%s/list", config.ShipgateHost, config.ShipgatePort)
-// 	for {
-// 		resp, err := http.Get(shipgateUrl)
-// 		if err != nil {
-// 			log.Error("Failed to connect to shipgate: "+err.Error(), logger.CriticalPriority)
-// 			// Sleep for a shorter interval since we want to know as soon
-// 			// as the shipgate is back online.
-// 			time.Sleep(errorInterval)
-// 		} else {
-// 			ships := make([]ShipgateListEntry, 1)
-// 			// Extract the Http response and convert it from JSON.
-// 			shipData := make([]byte, 100)
-// 			resp.Body.Read(shipData)
-// 			if err = json.Unmarshal(util.StripPadding(shipData), &ships); err != nil {
-// 				log.Error("Error parsing JSON response from shipgate: "+err.Error(),
-// 					logger.MediumPriority)
-// 				time.Sleep(errorInterval)
-// 				continue
-// 			}
-
-// 			// Taking the easy way out and just reallocating the entire slice
-// 			// to make the GC do the hard part. If this becomes an issue for
-// 			// memory footprint then the list should be overwritten in-place.
-// 			shipListMutex.Lock()
-// 			if len(ships) < 1 {
-// 				shipList = []ShipEntry{defaultShip}
-// 			} else {
-// 				shipList = make([]ShipEntry, len(shipList))
-// 				for i := range ships {
-// 					ship := shipList[i]
-// 					ship.Unknown = 0x12
-// 					// TODO: Does this have any actual significance? Will the possibility
-// 					// of a ship id changing for the same ship break things?
-// 					ship.Id = uint32(i)
-// 					ship.Shipname = ships[i].Shipname
-// 				}
-// 			}
-// 			shipListMutex.Unlock()
-// 			log.Info("Updated ship list", logger.LowPriority)
-// 			time.Sleep(pingInterval)
-// 		}
-// 	}
-// }
-
-// func processShipgatePacket(ship *Ship) {
-// 	var hdr ShipgateHeader
-// 	util.StructFromBytes(ship.Data()[:ShipgateHeaderSize], &hdr)
-
-// 	var err error
-// 	switch hdr.Type {
-// 	case ShipgateAuthType:
-// 		var pkt ShipgateAuthPkt
-// 		util.StructFromBytes(ship.Data(), &pkt)
-// 		ship.name = string(pkt.Name[:])
-// 		SendAuthAck(ship)
-// 		log.Info("Registered ship: %v", ship.name)
-// 	default:
-// 		log.Info("Received unknown packet %x from %s", hdr.Type, ship.IPAddr())
-// 	}
-
-// 	// Just log the error and let the handlers above do any cleanup. We don't
-// 	// want to close the connection here like we would for a game client
-// 	// in order to prevent one packet error from causing a reconnect.
-// 	if err != nil {
-// 		log.Warn(err.Error())
-// 	}
-// }
-
-// // Per-ship connection loop. Unlike the other servers where each client
-// // gets their own goroutine, each individual packet from the shipgate gets
-// // its own goroutine and the ship handles mapping the responses to the
-// // initiating client.
-// func handleShipConnection(conn net.Conn) {
-// 	addr := strings.Split(conn.RemoteAddr().String(), ":")
-// 	ship := &Ship{
-// 		conn:   conn,
-// 		ipAddr: addr[0],
-// 		port:   addr[1],
-// 		buffer: make([]byte, 512),
-// 	}
-// 	// shipConnections.AddClient(ship)
-// 	log.Info("Accepted ship connection from %v", ship.IPAddr())
-
-// 	defer func() {
-// 		if err := recover(); err != nil {
-// 			log.Error("Error in ship communication: %s: %s\n%s\n",
-// 				ship.IPAddr(), err, debug.Stack())
-// 		}
-// 		conn.Close()
-// 		log.Info("Disconnected ship: %s", ship.name)
-// 		// shipConnections.RemoveClient(ship)
-// 	}()
-
-// 	var err error
-// 	for {
-// 		select {
-// 		// If we don't hear from a ship for 60 seconds, ping it to
-// 		// make sure it's still alive.
-// 		case <-time.After(time.Second * 60):
-// 			// TODO: Ping the ship
-// 			continue
-// 		case err = <-ship.Read():
-// 		}
-
-// 		if err == io.EOF {
-// 			break
-// 		} else if err != nil {
-// 			// Error communicating with the client.
-// 			log.Warn(err.Error())
-// 			break
-// 		}
-// 		go processShipgatePacket(ship)
-// 	}
-// }
-
-// func startShipgate(wg *sync.WaitGroup) {
-// 	// Load our certificate file ship auth.
-// 	cert, err := tls.LoadX509KeyPair(CertificateFile, KeyFile)
-// 	if err != nil {
-// 		fmt.Println(err.Error())
-// 		os.Exit(-1)
-// 	}
-// 	tlsCfg := &tls.Config{Certificates: []tls.Certificate{cert}}
-
-// 	socket, err := tls.Listen("tcp", config.Hostname+":"+config.ShipgatePort, tlsCfg)
-// 	if err != nil {
-// 		fmt.Println(err.Error())
-// 		os.Exit(-1)
-// 	}
-
-// 	fmt.Printf("Waiting for SHIPGATE connections on %s:%s...\n",
-// 		config.Hostname, config.ShipgatePort)
-
-// 	// Wait for ship connections and spin off goroutines to handle them.
-// 	for {
-// 		conn, err := socket.Accept()
-// 		if err != nil {
-// 			log.Warn("Failed to accept connection: %s", err.Error())
-// 			continue
-// 		}
-// 		go handleShipConnection(conn)
-// 	}
-
-// 	wg.Done()
-// }
-//
 // Shipgate sub-server definition.
-type ShipgateServer struct{}
+type ShipgateServer struct {
+	// Nil if no certificate was configured, in which case remote ships can't register.
+	tlsConfig *tls.Config
+	keyHash   [32]byte
+}
+
+func (server *ShipgateServer) Name() string { return "SHIPGATE" }
+
+func (server *ShipgateServer) Port() string { return config.ShipgatePort }
 
-func (server ShipgateServer) Name() string { return "SHIPGATE" }
+var shipgatePacketSizes = map[uint16]int{
+	ShipgateAuthType:      packetSize(&ShipgateAuthPacket{}),
+	ShipgateHeartbeatType: packetSize(&ShipgateHeartbeatPacket{}),
+	ShipgatePlayerType:    packetSize(&ShipgatePlayerPacket{}),
+	ShipgateMailType:      packetSize(&ShipgateMailPacket{}),
+	ShipgateKickType:      packetSize(&ShipgateKickPacket{}),
+	ShipgateLockType:      packetSize(&ShipgateLockPacket{}),
+	// The header followed by the sender and recipient guildcards.
+	ShipgateMessageType: ShipgateHeaderSize + 8,
+}
 
-func (server ShipgateServer) Port() string { return config.ShipgatePort }
+func (server *ShipgateServer) MinPacketSizes() map[uint16]int { return shipgatePacketSizes }
+
+// Set up what the process needs to know about the ships before any of the
+// servers start: the entry for the ship it hosts, if it runs the ship server,
+// the record of who's online, and the link to the remote shipgate if there is
+// one.
+func initShips(hostShip bool) error {
+	if hostShip {
+		// Create our ship entry for the built-in ship server. Any other connected
+		// ships will be added to this list as they register.
+		localShip.ipAddr = config.ServerIP("ship")
+		localShip.ipv6Addr = config.BroadcastIPv6()
+		port, _ := strconv.ParseUint(config.ShipPort, 10, 16)
+		localShip.port = uint16(port)
+		localShip.maxPlayers = shipCapacity()
+		localShip.minPrivilege = config.shipMinPrivilege
+		copy(localShip.name[:], config.ShipName)
+		ships.Add(localShip)
+	}
+
+	store, err := newPresenceStore()
+	if err != nil {
+		return err
+	}
+	onlinePlayers = store
+	go refreshCharacterLocks()
+
+	if hostShip && config.ShipgateAddress != "" {
+		tlsConfig, err := shipgateClientTLSConfig()
+		if err != nil {
+			return err
+		}
+		go maintainShipgateLink(tlsConfig)
+	}
+	return nil
+}
+
+// Returns the most players that the ship hosted by this server can hold: the
+// configured max_players, or else as many as will fit in its blocks' lobbies.
+func shipCapacity() int {
+	if config.ShipMaxPlayers > 0 {
+		return config.ShipMaxPlayers
+	}
+	return config.NumBlocks * config.NumLobbies * MaxLobbyPlayers
+}
 
 func (server *ShipgateServer) Init() error {
-	// Create our ship entry for the built-in ship server. Any other connected
-	// ships will be added to this list by the shipgate, if it's enabled.
-	s := &shipList[0]
-	s.id = 1
-	s.ipAddr = config.BroadcastIP()
-	port, _ := strconv.ParseUint(config.ShipPort, 10, 16)
-	s.port = uint16(port)
-	copy(s.name[:], config.ShipName)
+	if config.CertificateFile != "" && config.KeyFile != "" {
+		if config.ShipgateKey == "" {
+			return errors.New("shipgate_key must be set in order to accept ships")
+		}
+		cert, err := tls.LoadX509KeyPair(config.CertificateFile, config.KeyFile)
+		if err != nil {
+			return errors.New("Failed to load shipgate certificate: " + err.Error())
+		}
+		server.tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		server.keyHash = sha256.Sum256([]byte(config.ShipgateKey))
+		go server.dropExpiredShips()
+	}
 	return nil
 }
 
-func (server ShipgateServer) NewClient(conn *net.TCPConn) (*Client, error) {
-	return NewLoginClient(conn)
+func (server *ShipgateServer) NewClient(conn net.Conn) (*Client, error) {
+	if server.tlsConfig == nil {
+		conn.Close()
+		return nil, errors.New("Shipgate is not configured to accept ships; rejected " + conn.RemoteAddr().String())
+	}
+	// Encryption is handled by the TLS connection.
+	return NewClient(tls.Server(conn, server.tlsConfig), ShipgateHeaderSize, nil, nil), nil
 }
 
-// Basically a no-op at this point since we only have one ship.
-func (server ShipgateServer) Handle(c *Client) error {
-	var err error
-	var hdr BBHeader
-	util.StructFromBytes(c.Data()[:BBHeaderSize], &hdr)
+func (server *ShipgateServer) Handle(c *Client) error {
+	var hdr ShipgateHeader
+	util.StructFromBytes(c.Data()[:ShipgateHeaderSize], &hdr)
+
+	if hdr.Type != ShipgateAuthType && c.ship == nil {
+		return errors.New("Ship sent a packet before registering: " + c.IPAddr())
+	}
 
+	var err error
 	switch hdr.Type {
+	case ShipgateAuthType:
+		err = server.HandleShipAuth(c)
+	case ShipgateMessageType:
+		sender, recipient, message := parseShipMessage(c.Data(), hdr)
+		relayShipMessage(sender, recipient, message, c.ship)
+	case ShipgateHeartbeatType:
+		var pkt ShipgateHeartbeatPacket
+		if err := c.Decode(&pkt); err != nil {
+			return err
+		}
+		c.ship.heartbeat(pkt.NumPlayers)
+	case ShipgatePlayerType:
+		err = handleShipPlayer(c)
+	case ShipgateMailType:
+		err = handleShipMail(c)
+	case ShipgateKickType:
+		err = handleShipKick(c)
+	case ShipgateLockType:
+		err = handleShipLock(c)
 	default:
-		log.Infof("Received unknown packet %x from %s", hdr.Type, c.IPAddr())
+		c.log.Infof("Received unknown packet %x", hdr.Type)
 	}
 	return err
 }
+
+// A ship hosted by another server is registering itself.
+func (server *ShipgateServer) HandleShipAuth(c *Client) error {
+	var pkt ShipgateAuthPacket
+	ack := &ShipgateAuthAckPacket{Header: ShipgateHeader{Type: ShipgateAuthAckType}}
+	if err := c.Decode(&pkt); err != nil {
+		// Most likely a ship from before the version was sent.
+		ack.Status = ShipgateAuthVersion
+		EncryptAndSend(c, ack)
+		return err
+	}
+
+	if c.ship != nil {
+		return errors.New("Ship attempted to register twice: " + c.IPAddr())
+	} else if subtle.ConstantTimeCompare(pkt.Key[:], server.keyHash[:]) != 1 {
+		ack.Status = ShipgateAuthRejected
+		EncryptAndSend(c, ack)
+		return errors.New("Ship presented an invalid shipgate key: " + c.IPAddr())
+	} else if pkt.Version != ShipgateVersion {
+		ack.Status = ShipgateAuthVersion
+		EncryptAndSend(c, ack)
+		return fmt.Errorf("Ship %s speaks shipgate version %d instead of %d",
+			c.IPAddr(), pkt.Version, ShipgateVersion)
+	}
+
+	ship := &Ship{
+		name:     pkt.Name,
+		ipAddr:   pkt.IPAddr,
+		port:     pkt.Port,
+		ipv6Addr: pkt.IPv6Addr,
+		client:   c,
+		// Ships that don't report a limit don't have one.
+		maxPlayers:   int(pkt.MaxPlayers),
+		minPrivilege: pkt.MinPrivilege,
+	}
+	ship.heartbeat(0)
+	ships.Add(ship)
+	c.ship = ship
+	c.log.Infof("Registered ship %s", util.StripPadding(ship.name[:]))
+
+	ack.Status = ShipgateAuthOk
+	ack.ShipId = ship.id
+	if err := EncryptAndSend(c, ack); err != nil {
+		return err
+	}
+	return sendOnlinePlayers(c, false)
+}
+
+// Remove the ship from the menu once its connection closes.
+func (server *ShipgateServer) Disconnect(c *Client) {
+	if c.ship != nil {
+		ships.Remove(c.ship)
+		dropShipPlayers(c.ship)
+		characterLocks.ReleaseHolder(c.ship.key())
+		c.log.Infof("Unregistered ship %s", util.StripPadding(c.ship.name[:]))
+	}
+}
+
+// Loop for the life of the server, disconnecting any ships that have stopped
+// sending heartbeats so that players aren't sent to a ship that's gone.
+func (server *ShipgateServer) dropExpiredShips() {
+	for now := range time.Tick(shipHeartbeatInterval) {
+		for _, ship := range ships.List() {
+			if ship.expired(now) {
+				log.Warnf("Ship %s stopped responding; removing it", util.StripPadding(ship.name[:]))
+				ships.Remove(ship)
+				dropShipPlayers(ship)
+				characterLocks.ReleaseHolder(ship.key())
+				ship.client.Close()
+			}
+		}
+	}
+}
+
+// SendShipMessage delivers a message to players on this ship and every other
+// ship that we know about. A recipient of 0 sends it to everyone.
+func SendShipMessage(sender, recipient uint32, message string) {
+	deliverShipMessage(sender, recipient, []byte(message))
+	sendRemoteShipMessage(sender, recipient, []byte(message))
+}
+
+// Deliver a message to the players on every ship but this one.
+func sendRemoteShipMessage(sender, recipient uint32, message []byte) {
+	relayShipMessage(sender, recipient, message, localShip)
+	if link := currentShipgateLink(); link != nil {
+		if err := sendShipMessage(link, sender, recipient, message); err != nil {
+			log.Warn(err.Error())
+		}
+	}
+}
+
+// Deliver a message to every ship registered with us (including our own) other
+// than the one it came from.
+func relayShipMessage(sender, recipient uint32, message []byte, from *Ship) {
+	for _, ship := range ships.List() {
+		if ship == from {
+			continue
+		}
+		if ship.client == nil {
+			deliverShipMessage(sender, recipient, message)
+		} else if err := sendShipMessage(ship.client, sender, recipient, message); err != nil {
+			log.Warn(err.Error())
+		}
+	}
+}
+
+// Display a message from another ship to the players on this one.
+func deliverShipMessage(sender, recipient uint32, message []byte) {
+	if recipient == 0 {
+		for _, c := range players.List() {
+			if !c.blocked.Blocks(sender) {
+				SendClientMessage(c, string(message))
+			}
+		}
+	} else if c := players.Find(recipient); c != nil && !c.blocked.Blocks(sender) {
+		SendClientMessage(c, string(message))
+	}
+}
+
+func sendShipMessage(c *Client, sender, recipient uint32, message []byte) error {
+	pkt := &ShipgateMessagePacket{
+		Header:    ShipgateHeader{Type: ShipgateMessageType},
+		Sender:    sender,
+		Recipient: recipient,
+		Message:   message,
+	}
+	c.log.Debug("Sending Ship Message")
+	return EncryptAndSend(c, pkt)
+}
+
+func parseShipMessage(data []byte, hdr ShipgateHeader) (sender, recipient uint32, message []byte) {
+	var pkt struct {
+		Header    ShipgateHeader
+		Sender    uint32
+		Recipient uint32
+	}
+	util.StructFromBytes(data[:16], &pkt)
+	if int(hdr.Size) > 16 && int(hdr.Size) <= len(data) {
+		message = util.StripPadding(data[16:hdr.Size])
+	}
+	return pkt.Sender, pkt.Recipient, message
+}
+
+// Ships connecting to a remote shipgate verify it against the configured certificate.
+func shipgateClientTLSConfig() (*tls.Config, error) {
+	if config.CertificateFile == "" || config.ShipgateKey == "" {
+		return nil, errors.New("certificate_file and shipgate_key are required to connect to a shipgate")
+	}
+	cert, err := ioutil.ReadFile(config.CertificateFile)
+	if err != nil {
+		return nil, errors.New("Failed to load shipgate certificate: " + err.Error())
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(cert) {
+		return nil, errors.New("Failed to parse shipgate certificate " + config.CertificateFile)
+	}
+	host, _, err := net.SplitHostPort(config.ShipgateAddress)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{RootCAs: roots, ServerName: host}, nil
+}
+
+// Loop for the life of the server, keeping this ship registered with the
+// remote shipgate and reconnecting whenever the connection drops.
+func maintainShipgateLink(tlsConfig *tls.Config) {
+	for {
+		if err := runShipgateLink(tlsConfig); err != nil && err != io.EOF {
+			log.Warn("Shipgate connection failed: " + err.Error())
+		}
+		shipgateLinkLock.Lock()
+		shipgateLink = nil
+		shipgateLinkLock.Unlock()
+		forgetRemotePlayers()
+		time.Sleep(shipgateRetryInterval)
+	}
+}
+
+// Register with the shipgate and then process messages until the connection closes.
+func runShipgateLink(tlsConfig *tls.Config) error {
+	conn, err := tls.Dial("tcp", config.ShipgateAddress, tlsConfig)
+	if err != nil {
+		return err
+	}
+	c := NewClient(conn, ShipgateHeaderSize, nil, nil)
+	c.log = clientLogger("SHIPGATE", c)
+	defer c.Close()
+
+	pkt := &ShipgateAuthPacket{
+		Header:       ShipgateHeader{Type: ShipgateAuthType},
+		Key:          sha256.Sum256([]byte(config.ShipgateKey)),
+		Name:         localShip.name,
+		IPAddr:       localShip.ipAddr,
+		Port:         localShip.port,
+		IPv6Addr:     localShip.ipv6Addr,
+		Version:      ShipgateVersion,
+		MinPrivilege: localShip.minPrivilege,
+	}
+	if localShip.maxPlayers <= math.MaxUint16 {
+		pkt.MaxPlayers = uint16(localShip.maxPlayers)
+	}
+	c.log.Debug("Sending Shipgate Auth")
+	if err := EncryptAndSend(c, pkt); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+
+	for {
+		if err := c.Process(); err != nil {
+			return err
+		}
+		var hdr ShipgateHeader
+		util.StructFromBytes(c.Data()[:ShipgateHeaderSize], &hdr)
+
+		switch hdr.Type {
+		case ShipgateAuthAckType:
+			var ack ShipgateAuthAckPacket
+			if err := c.Decode(&ack); err != nil {
+				return err
+			}
+			switch ack.Status {
+			case ShipgateAuthOk:
+			case ShipgateAuthVersion:
+				return fmt.Errorf("shipgate doesn't speak version %d; "+
+					"this server and the shipgate's need to be the same version", ShipgateVersion)
+			default:
+				return errors.New("shipgate rejected our key")
+			}
+			shipgateLinkLock.Lock()
+			shipgateLink = c
+			shipgateLinkLock.Unlock()
+			log.Infof("Registered with shipgate %s as ship %d", config.ShipgateAddress, ack.ShipId)
+			go sendHeartbeats(c, done)
+			if err := sendOnlinePlayers(c, true); err != nil {
+				return err
+			}
+			renewCharacterLocks()
+		case ShipgateMessageType:
+			sender, recipient, message := parseShipMessage(c.Data(), hdr)
+			deliverShipMessage(sender, recipient, message)
+		case ShipgatePlayerType:
+			if err := handleShipPlayer(c); err != nil {
+				return err
+			}
+		case ShipgateMailType:
+			if err := handleShipMail(c); err != nil {
+				c.log.Warn(err.Error())
+			}
+		case ShipgateKickType:
+			if err := handleShipKick(c); err != nil {
+				c.log.Warn(err.Error())
+			}
+		case ShipgateLockType:
+			if err := handleShipLockReply(c); err != nil {
+				return err
+			}
+		default:
+			log.Infof("Received unknown packet %x from shipgate", hdr.Type)
+		}
+	}
+}
+
+// Report our population to the shipgate until done is closed.
+func sendHeartbeats(c *Client, done <-chan struct{}) {
+	ticker := time.NewTicker(shipHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		pkt := &ShipgateHeartbeatPacket{
+			Header:     ShipgateHeader{Type: ShipgateHeartbeatType},
+			NumPlayers: uint32(players.Len()),
+		}
+		c.log.Debug("Sending Shipgate Heartbeat")
+		if err := EncryptAndSend(c, pkt); err != nil {
+			c.log.Warn(err.Error())
+			return
+		}
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,353 @@
+/*
+* The tool, weapon, and armor shops on Pioneer 2. What each shop sells comes from
+* the shop file, which lists the items that can be stocked along with their prices
+* and the character levels and difficulties they're sold at:
+*
+*	{
+*		"sell_rate": 0.125,
+*		"tool": {"items": [{"item": "030000", "price": 50}]},
+*		"weapon": {
+*			"size": 10,
+*			"items": [{"item": "000100", "price": 250, "min_level": 1, "max_level": 20, "difficulties": ["normal"]}]
+*		},
+*		"armor": {"size": 8, "items": [{"item": "010100", "price": 150}]}
+*	}
+*
+* A shop with a size stocks that many of the items it can sell, picked at random;
+* otherwise it stocks all of them. The picks are seeded by the game and the
+* player's level, so the stock doesn't change when they leave and come back to
+* the game. Everything is bought and sold at the prices in the file, with items
+* selling for sell_rate of the price they're bought at. Items that aren't sold
+* in any shop sell for default_sell_price.
+ */
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sync"
+
+	"github.com/dcrodman/archon/data"
+	"github.com/dcrodman/archon/packets"
+)
+
+const (
+	// Shop types sent in the ShopRequestPacket.
+	ShopTool   = 0
+	ShopWeapon = 1
+	ShopArmor  = 2
+	// Players within this many levels of each other see the same stock.
+	shopLevelBand = 10
+)
+
+// Item that a shop can stock.
+type shopEntry struct {
+	// Item data in hex.
+	Item  string `json:"item"`
+	Price uint32 `json:"price"`
+	// Range of character levels the item is sold to; any level if they're 0.
+	MinLevel uint32 `json:"min_level"`
+	MaxLevel uint32 `json:"max_level"`
+	// Difficulties the item is sold on; all of them if it's empty.
+	Difficulties []string `json:"difficulties"`
+	// Item data padded to 12 bytes.
+	data         []byte
+	difficulties []uint8
+}
+
+// Sold at a level (starting at 0, as it's stored) on a difficulty.
+func (e *shopEntry) soldTo(level uint32, difficulty uint8) bool {
+	if (e.MinLevel > 0 && level+1 < e.MinLevel) || (e.MaxLevel > 0 && level+1 > e.MaxLevel) {
+		return false
+	}
+	if len(e.difficulties) == 0 {
+		return true
+	}
+	for _, d := range e.difficulties {
+		if d == difficulty {
+			return true
+		}
+	}
+	return false
+}
+
+type shopConfig struct {
+	// Number of items to stock; all of the ones that can be sold if it's 0.
+	Size  int         `json:"size"`
+	Items []shopEntry `json:"items"`
+}
+
+type shopFile struct {
+	SellRate         float64    `json:"sell_rate"`
+	DefaultSellPrice uint32     `json:"default_sell_price"`
+	Tool             shopConfig `json:"tool"`
+	Weapon           shopConfig `json:"weapon"`
+	Armor            shopConfig `json:"armor"`
+}
+
+// Item for sale in the shop that a player opened.
+type shopItem struct {
+	item  data.Item
+	price uint32
+}
+
+// Synchronized set of the shops loaded from the shop file.
+type shopList struct {
+	file *shopFile
+	sync.RWMutex
+}
+
+var shops = &shopList{file: new(shopFile)}
+
+// Load the shops from the file at path, replacing the ones that were loaded
+// before. The shops are left empty if the file doesn't exist.
+func (sl *shopList) Load(path string) error {
+	file := new(shopFile)
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		log.Warnf("Shop file %s doesn't exist; the shops won't sell anything", path)
+	} else if err != nil {
+		return err
+	} else {
+		decoder := json.NewDecoder(bytes.NewReader(contents))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(file); err != nil {
+			return fmt.Errorf("invalid %s: %s", path, err.Error())
+		}
+		if err := file.prepare(); err != nil {
+			return fmt.Errorf("invalid %s: %s", path, err.Error())
+		}
+	}
+
+	sl.Lock()
+	sl.file = file
+	sl.Unlock()
+	return nil
+}
+
+// Check the file and decode its items.
+func (f *shopFile) prepare() error {
+	if f.SellRate < 0 || f.SellRate > 1 {
+		return errors.New("sell_rate must be between 0 and 1")
+	}
+	for _, shop := range []*shopConfig{&f.Tool, &f.Weapon, &f.Armor} {
+		if shop.Size < 0 || shop.Size > packets.MaxShopItems {
+			return fmt.Errorf("shop sizes must be between 0 and %d", packets.MaxShopItems)
+		}
+		for i := range shop.Items {
+			entry := &shop.Items[i]
+			itemData, err := parseDropItem(entry.Item)
+			if err != nil {
+				return err
+			} else if entry.Price == 0 {
+				return fmt.Errorf("item %s needs a price", entry.Item)
+			}
+			for _, name := range entry.Difficulties {
+				difficulty, ok := parseDifficulty(name)
+				if !ok {
+					return errors.New("difficulties must be normal, hard, very_hard, or ultimate")
+				}
+				entry.difficulties = append(entry.difficulties, difficulty)
+			}
+			entry.data = make([]byte, 12)
+			copy(entry.data, itemData)
+		}
+	}
+	return nil
+}
+
+func (f *shopFile) shop(shopType uint8) *shopConfig {
+	switch shopType {
+	case ShopTool:
+		return &f.Tool
+	case ShopWeapon:
+		return &f.Weapon
+	case ShopArmor:
+		return &f.Armor
+	}
+	return nil
+}
+
+// Stock returns the items for sale in one of the shops for a character in a game.
+func (sl *shopList) Stock(shopType uint8, g *Game, level uint32) []shopItem {
+	sl.RLock()
+	defer sl.RUnlock()
+	shop := sl.file.shop(shopType)
+	if shop == nil {
+		return nil
+	}
+	var candidates []*shopEntry
+	for i := range shop.Items {
+		if shop.Items[i].soldTo(level, g.difficulty) {
+			candidates = append(candidates, &shop.Items[i])
+		}
+	}
+	if shop.Size > 0 && len(candidates) > shop.Size {
+		seed := int64(g.shopSeed) ^ int64(shopType)<<32 ^ int64(level/shopLevelBand)<<40
+		rand.New(rand.NewSource(seed)).Shuffle(len(candidates), func(i, j int) {
+			candidates[i], candidates[j] = candidates[j], candidates[i]
+		})
+		candidates = candidates[:shop.Size]
+	}
+	if len(candidates) > packets.MaxShopItems {
+		candidates = candidates[:packets.MaxShopItems]
+	}
+
+	stock := make([]shopItem, len(candidates))
+	for i, entry := range candidates {
+		stock[i] = shopItem{item: newDropItem(entry.data), price: entry.Price}
+	}
+	return stock
+}
+
+// SellPrice returns what the shops pay for one of an item.
+func (sl *shopList) SellPrice(item data.Item) uint32 {
+	sl.RLock()
+	defer sl.RUnlock()
+	for _, shop := range []*shopConfig{&sl.file.Tool, &sl.file.Weapon, &sl.file.Armor} {
+		for _, entry := range shop.Items {
+			// Items are the same kind if their type, subtype, and index match.
+			if bytes.Equal(entry.data[:3], item.Data[:3]) {
+				return uint32(float64(entry.Price) * sl.file.SellRate)
+			}
+		}
+	}
+	return sl.file.DefaultSellPrice
+}
+
+// The player talked to a shopkeeper; send them what the shop has for sale.
+func (server *BlockServer) HandleShopRequest(c *Client) error {
+	var pkt packets.ShopRequestPacket
+	if err := c.Decode(&pkt); err != nil {
+		return err
+	}
+	g := c.game
+	if g == nil || c.character == nil {
+		return nil
+	}
+	c.shopType = pkt.ShopType
+	c.shopStock = shops.Stock(pkt.ShopType, g, c.character.Level)
+
+	contents := &packets.ShopContentsPacket{
+		Header:    packets.BBHeader{Type: packets.GameCommandType},
+		SubHeader: packets.SubCmdHeader{Type: packets.SubCmdShopContents, Size: 2 + 5*packets.MaxShopItems},
+		ShopType:  pkt.ShopType,
+		NumItems:  uint8(len(c.shopStock)),
+	}
+	for i, stocked := range c.shopStock {
+		contents.Items[i] = newItemData(stocked.item)
+		binary.LittleEndian.PutUint32(contents.Items[i].Data2[:], stocked.price)
+	}
+	c.log.Debug("Sending Shop Contents")
+	return EncryptAndSend(c, contents)
+}
+
+// The player bought an item from the shop they opened last.
+func (server *BlockServer) HandleBuyItem(c *Client) error {
+	var pkt packets.BuyItemPacket
+	if err := c.Decode(&pkt); err != nil {
+		return err
+	}
+	g := c.game
+	if g == nil || c.character == nil || c.trading() {
+		return nil
+	}
+	if pkt.ShopType != c.shopType || int(pkt.Index) >= len(c.shopStock) {
+		c.log.Warnf("Guildcard %d tried to buy item %d, which isn't for sale", c.guildcard, pkt.Index)
+		return nil
+	}
+	stocked := c.shopStock[pkt.Index]
+	// The item gets an id from the server rather than the one the client picked.
+	bought := copyItem(stocked.item)
+	amount := uint8(1)
+	if isStackable(bought) && pkt.Amount > 1 {
+		// A new stack can't be any bigger than one that's added to.
+		amount = pkt.Amount
+		if amount > MaxStackSize {
+			amount = MaxStackSize
+		}
+		bought.Data[5] = amount
+	}
+	price := stocked.price * uint32(amount)
+	if price > c.character.Meseta {
+		c.log.Warnf("Guildcard %d tried to buy an item for %d meseta but only has %d",
+			c.guildcard, price, c.character.Meseta)
+		return nil
+	}
+
+	stack := -1
+	if isStackable(bought) {
+		stack = findStack(c.inventory, bought)
+	}
+	if stack >= 0 {
+		if itemAmount(c.inventory[stack])+amount > MaxStackSize {
+			return nil
+		}
+		c.inventory[stack].Data[5] += amount
+		bought.ItemId = c.inventory[stack].ItemId
+	} else {
+		if len(c.inventory) >= MaxInventoryItems {
+			return nil
+		}
+		bought.ItemId = c.newItemId()
+		c.inventory = append(c.inventory, bought)
+	}
+	c.character.Meseta -= price
+	auditItem(c, data.ItemAuditBought, &bought, amount, price)
+
+	g.Broadcast(&packets.CreateInventoryItemPacket{
+		Header:    packets.BBHeader{Type: packets.GameCommandType},
+		SubHeader: packets.SubCmdHeader{Type: packets.SubCmdCreateInventoryItem, Size: 7, ClientId: uint16(c.clientId)},
+		Item:      newItemData(bought),
+	}, nil)
+	return nil
+}
+
+// The player sold an item (or some of a stack) to a shop.
+func (server *BlockServer) HandleSellItem(c *Client) error {
+	var pkt packets.SellItemPacket
+	if err := c.Decode(&pkt); err != nil {
+		return err
+	}
+	g := c.game
+	if g == nil || c.character == nil || c.trading() {
+		return nil
+	}
+	index := findItem(c.inventory, pkt.ItemId)
+	if index < 0 || c.inventory[index].Flags&ItemEquipped != 0 {
+		rejectItemCommand(c, "sell", pkt.ItemId)
+		return nil
+	}
+	item := c.inventory[index]
+	amount := itemAmount(item)
+	if isStackable(item) && pkt.Amount > 0 && pkt.Amount < uint32(amount) {
+		amount = uint8(pkt.Amount)
+	}
+
+	price := shops.SellPrice(item) * uint32(amount)
+	c.character.Meseta += price
+	if c.character.Meseta > MaxMeseta {
+		c.character.Meseta = MaxMeseta
+	}
+	auditItem(c, data.ItemAuditSold, &item, amount, price)
+	if amount < itemAmount(item) {
+		c.inventory[index].Data[5] -= amount
+	} else {
+		c.inventory = append(c.inventory[:index], c.inventory[index+1:]...)
+	}
+
+	// The player's client has already removed the item; let everyone else know.
+	g.Broadcast(&packets.DestroyItemPacket{
+		Header:    packets.BBHeader{Type: packets.GameCommandType},
+		SubHeader: packets.SubCmdHeader{Type: packets.SubCmdDestroyItem, Size: 3, ClientId: uint16(c.clientId)},
+		ItemId:    pkt.ItemId,
+		Amount:    uint32(amount),
+	}, c)
+	return nil
+}
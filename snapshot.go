@@ -0,0 +1,238 @@
+/*
+* Snapshots of characters for rolling them back after they've been corrupted or
+* stolen from. The ship takes a snapshot of a player's character (with their
+* inventory, techniques, and bank) each time they log off, keeping the latest
+* snapshots_per_character of them for up to snapshot_days.
+*
+* Snapshots are listed and restored with "archon snapshot" or through the admin
+* API. Restoring one overwrites the character in the slot it was taken from, so
+* the player needs to be logged off first or the ship will save over it; the
+* admin API checks for this, but the command can't tell whether they're online.
+ */
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dcrodman/archon/data"
+)
+
+// How often snapshots older than snapshot_days are removed.
+const snapshotPurgeInterval = time.Hour
+
+// Summary of a snapshot as it's listed by the command and the admin API.
+type snapshotSummary struct {
+	Id         int64     `json:"id"`
+	Guildcard  uint32    `json:"guildcard"`
+	Slot       uint32    `json:"slot"`
+	CreatedAt  time.Time `json:"created_at"`
+	Name       string    `json:"name"`
+	Level      uint32    `json:"level"`
+	Experience uint32    `json:"experience"`
+	Meseta     uint32    `json:"meseta"`
+	Inventory  int       `json:"inventory"`
+	BankMeseta uint32    `json:"bank_meseta"`
+	Bank       int       `json:"bank"`
+}
+
+type adminRestoreSnapshotRequest struct {
+	Id int64 `json:"id"`
+}
+
+func newSnapshotSummary(snapshot data.CharacterSnapshot) snapshotSummary {
+	summary := snapshotSummary{
+		Id:        snapshot.Id,
+		Guildcard: snapshot.Guildcard,
+		Slot:      snapshot.Slot,
+		CreatedAt: snapshot.CreatedAt,
+		Inventory: len(snapshot.Inventory),
+	}
+	if character := snapshot.Character; character != nil {
+		summary.Name = characterName(character)
+		summary.Level = character.Level + 1
+		summary.Experience = character.Experience
+		summary.Meseta = character.Meseta
+	}
+	if snapshot.Bank != nil {
+		summary.BankMeseta = snapshot.Bank.Meseta
+		summary.Bank = len(snapshot.Bank.Items)
+	}
+	return summary
+}
+
+// Take a snapshot of the character of the player on c as they log off. Their
+// character should have been saved first.
+func snapshotCharacter(c *Client) {
+	if config.SnapshotsPerCharacter == 0 {
+		return
+	}
+	bank := c.bank
+	if bank == nil {
+		// They didn't open the bank this time, so it's as it was saved.
+		var err error
+		if bank, err = database.FindBank(c.guildcard, bankSlot(c)); err != nil {
+			c.log.Errorf("Failed to load bank to snapshot guildcard %d: %s", c.guildcard, err.Error())
+			return
+		}
+	}
+	err := database.CreateSnapshot(&data.CharacterSnapshot{
+		Guildcard:  c.guildcard,
+		Slot:       uint32(c.config.SlotNum),
+		CreatedAt:  time.Now(),
+		Character:  c.character,
+		Inventory:  c.inventory,
+		Techniques: c.techniques,
+		BankSlot:   bankSlot(c),
+		Bank:       bank,
+	}, config.SnapshotsPerCharacter)
+	if err != nil {
+		c.log.Errorf("Failed to snapshot character for guildcard %d: %s", c.guildcard, err.Error())
+	}
+}
+
+// Loop for the life of the server, removing snapshots that are older than
+// snapshot_days.
+func purgeSnapshots() {
+	for config.SnapshotDays > 0 {
+		cutoff := time.Now().AddDate(0, 0, -config.SnapshotDays)
+		if n, err := database.PurgeSnapshots(cutoff); err != nil {
+			log.Error("Failed to purge snapshots: " + err.Error())
+		} else if n > 0 {
+			log.Infof("Purged %d character snapshots", n)
+		}
+		time.Sleep(snapshotPurgeInterval)
+	}
+}
+
+func runSnapshotCommand(args []string) error {
+	switch {
+	case len(args) == 3 && args[0] == "list":
+		guildcard, err := strconv.ParseUint(args[1], 10, 32)
+		if err != nil {
+			return errors.New("invalid guildcard " + args[1])
+		}
+		slot, err := strconv.ParseUint(args[2], 10, 32)
+		if err != nil || slot >= NumCharacterSlots {
+			return fmt.Errorf("slot must be less than %d", NumCharacterSlots)
+		}
+		return listSnapshots(uint32(guildcard), uint32(slot))
+	case len(args) == 2 && args[0] == "restore":
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return errors.New("invalid snapshot id " + args[1])
+		}
+		snapshot, err := restoreSnapshot(id)
+		if err == data.ErrCharacterNameTaken {
+			return errors.New("another character has taken the name")
+		} else if err != nil {
+			return err
+		}
+		fmt.Printf("Rolled back slot %d for guildcard %d to snapshot %d\n",
+			snapshot.Slot, snapshot.Guildcard, snapshot.Id)
+		return nil
+	default:
+		return errors.New("usage: snapshot list <guildcard> <slot> | restore <id>")
+	}
+}
+
+func listSnapshots(guildcard, slot uint32) error {
+	snapshots, err := database.FindSnapshots(guildcard, slot)
+	if err != nil {
+		return err
+	}
+	if len(snapshots) == 0 {
+		fmt.Printf("No snapshots for slot %d of guildcard %d\n", slot, guildcard)
+	}
+	for _, snapshot := range snapshots {
+		s := newSnapshotSummary(snapshot)
+		fmt.Printf("Id %d: %s (level %d, %d exp, %d meseta, %d items; bank %d meseta, %d items) taken %s\n",
+			s.Id, s.Name, s.Level, s.Experience, s.Meseta, s.Inventory, s.BankMeseta, s.Bank,
+			s.CreatedAt.Local().Format(time.RFC1123))
+	}
+	return nil
+}
+
+// Roll a character back to the snapshot with the given id, returning the snapshot.
+func restoreSnapshot(id int64) (*data.CharacterSnapshot, error) {
+	snapshot, err := database.FindSnapshot(id)
+	if err != nil {
+		return nil, err
+	} else if snapshot == nil {
+		return nil, data.ErrSnapshotNotFound
+	}
+	var key string
+	if snapshot.Character != nil {
+		key = characterNameKey(snapshot.Guildcard, snapshot.Character.Name)
+	}
+	if err = database.RestoreSnapshot(id, key); err != nil {
+		return nil, err
+	}
+	cacheDelete(previewCacheKey(snapshot.Guildcard, snapshot.Slot))
+	return snapshot, nil
+}
+
+// GET lists the snapshots of the character in a slot given by the guildcard and
+// slot query parameters.
+func handleAdminSnapshots(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	guildcard, err := strconv.ParseUint(req.URL.Query().Get("guildcard"), 10, 32)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "guildcard is required")
+		return
+	}
+	slot, err := strconv.ParseUint(req.URL.Query().Get("slot"), 10, 32)
+	if err != nil || slot >= NumCharacterSlots {
+		writeError(w, http.StatusBadRequest, "slot is required")
+		return
+	}
+	snapshots, err := database.FindSnapshots(uint32(guildcard), uint32(slot))
+	if err != nil {
+		log.Error("Failed to look up snapshots: " + err.Error())
+		writeError(w, http.StatusInternalServerError, "unable to look up snapshots")
+		return
+	}
+	resp := []snapshotSummary{}
+	for _, snapshot := range snapshots {
+		resp = append(resp, newSnapshotSummary(snapshot))
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// POST rolls a character back to a snapshot, as long as its player isn't online.
+func handleAdminRestoreSnapshot(w http.ResponseWriter, req *http.Request) {
+	var body adminRestoreSnapshotRequest
+	if !readJSON(w, req, &body) {
+		return
+	}
+	snapshot, err := database.FindSnapshot(body.Id)
+	if err != nil {
+		log.Error("Failed to look up snapshot: " + err.Error())
+		writeError(w, http.StatusInternalServerError, "unable to look up snapshot")
+		return
+	} else if snapshot == nil {
+		writeError(w, http.StatusNotFound, "no such snapshot")
+		return
+	}
+	if _, online := onlinePlayers.Find(snapshot.Guildcard); online || players.Find(snapshot.Guildcard) != nil {
+		writeError(w, http.StatusConflict, "the player needs to log off first")
+		return
+	}
+	if _, err = restoreSnapshot(body.Id); err == data.ErrCharacterNameTaken {
+		writeError(w, http.StatusConflict, "another character has taken the name")
+		return
+	} else if err != nil {
+		log.Error("Failed to restore snapshot: " + err.Error())
+		writeError(w, http.StatusInternalServerError, "unable to restore snapshot")
+		return
+	}
+	log.Infof("Rolled back slot %d for guildcard %d to snapshot %d by admin request from %s",
+		snapshot.Slot, snapshot.Guildcard, snapshot.Id, req.RemoteAddr)
+	writeJSON(w, http.StatusOK, newSnapshotSummary(*snapshot))
+}
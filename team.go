@@ -0,0 +1,418 @@
+/*
+* Teams (guilds). A player can create a team, becoming its master, and then
+* invite other players who are online to it. An invited player joins by typing
+* /team accept (or turns the invite down with /team decline) before the invite
+* runs out. The master can remove members and change
+* the team's flag, and anyone but the master can leave. Members can talk to each
+* other on the team chat from anywhere on the ship, and the team's name is shown
+* on their guildcards.
+*
+* Teams are saved with the account, so all of the account's characters are in
+* the same team.
+ */
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf16"
+
+	"github.com/dcrodman/archon/data"
+	"github.com/dcrodman/archon/packets"
+	"github.com/dcrodman/archon/util"
+)
+
+// Results sent in the Flags of the team result packets.
+const (
+	TeamResultOk        = 0
+	TeamResultFailed    = 1
+	TeamResultNameTaken = 2
+)
+
+// Most members a team can have.
+const MaxTeamMembers = 100
+
+// How long a player has to accept an invite to a team.
+const teamInviteTimeout = 2 * time.Minute
+
+// An invite to join a team that's waiting for the player to accept it.
+type teamInvite struct {
+	teamId   int64
+	teamName string
+	// Guildcard and character name of the master who sent it.
+	inviter     uint32
+	inviterName string
+	expires     time.Time
+}
+
+// Look up the team that the client's account is in. An account left in a team
+// that has since been disbanded is taken out of it.
+func loadTeam(c *Client) error {
+	c.team, c.teamPrivilege = nil, data.TeamPrivilegeMember
+	if c.teamId == 0 {
+		return nil
+	}
+	team, err := c.db().FindTeam(int64(c.teamId))
+	if err != nil {
+		return err
+	} else if team == nil {
+		c.teamId = 0
+		return nil
+	}
+	members, err := c.db().FindTeamMembers(team.Id)
+	if err != nil {
+		return err
+	}
+	for _, member := range members {
+		if member.Guildcard == c.guildcard {
+			c.team, c.teamPrivilege = team, member.Privilege
+			return nil
+		}
+	}
+	c.teamId = 0
+	return nil
+}
+
+// Set the team that the player is in and let their client know.
+func setTeam(c *Client, team *data.Team, privilege byte) error {
+	c.team, c.teamPrivilege, c.teamId = team, privilege, 0
+	if team != nil {
+		c.teamId = uint32(team.Id)
+	}
+	return sendTeamInfo(c)
+}
+
+func sendTeamInfo(c *Client) error {
+	pkt := &packets.TeamInfoPacket{
+		Header:         packets.BBHeader{Type: packets.TeamInfoType},
+		Guildcard:      c.guildcard,
+		PrivilegeLevel: uint16(c.teamPrivilege),
+	}
+	if c.team != nil {
+		pkt.TeamId = uint32(c.team.Id)
+		copy(pkt.TeamName[:], c.team.Name)
+		copy(pkt.TeamFlag[:], c.team.Flag)
+	}
+	return EncryptAndSend(c, pkt)
+}
+
+func sendTeamResult(c *Client, pktType uint16, result uint32) error {
+	return EncryptAndSend(c, &packets.BBHeader{Type: pktType, Flags: result})
+}
+
+// Returns the name of a character the way it's kept for a team member.
+func memberName(character *data.Character) []uint16 {
+	var name [16]uint16
+	copyUtf16(name[:], character.Name)
+	return trimUtf16(name[:])
+}
+
+// Returns the name of a team without its language marker.
+func teamName(team *data.Team) string {
+	name := string(utf16.Decode(team.Name))
+	if len(name) >= 2 && name[0] == '\t' {
+		name = name[2:]
+	}
+	return name
+}
+
+// Returns the members of a team who are online.
+func onlineTeamMembers(teamId int64) []*Client {
+	var members []*Client
+	for _, p := range players.List() {
+		if p.team != nil && p.team.Id == teamId {
+			members = append(members, p)
+		}
+	}
+	return members
+}
+
+// The player is creating a team.
+func (server *BlockServer) HandleTeamCreate(c *Client) error {
+	var pkt packets.TeamCreatePacket
+	if err := c.Decode(&pkt); err != nil {
+		return err
+	}
+	name := trimUtf16(pkt.Name[:])
+	if c.team != nil || c.character == nil || len(name) == 0 {
+		return sendTeamResult(c, packets.TeamCreateResultType, TeamResultFailed)
+	}
+	if err := nameFilter.CheckUtf16(name); err != nil {
+		c.log.Infof("Refused team name: %s", err.Error())
+		SendClientMessage(c, nameRefusedMessage("team", err))
+		return sendTeamResult(c, packets.TeamCreateResultType, TeamResultFailed)
+	}
+
+	team := &data.Team{
+		Name:      name,
+		Flag:      make([]byte, data.TeamFlagSize),
+		CreatedAt: time.Now(),
+	}
+	master := &data.TeamMember{
+		Guildcard: c.guildcard,
+		Name:      memberName(c.character),
+		Privilege: data.TeamPrivilegeMaster,
+		JoinedAt:  team.CreatedAt,
+	}
+	err := c.db().CreateTeam(team, master)
+	if err == data.ErrTeamNameTaken {
+		return sendTeamResult(c, packets.TeamCreateResultType, TeamResultNameTaken)
+	} else if err != nil {
+		sendTeamResult(c, packets.TeamCreateResultType, TeamResultFailed)
+		return errors.New("Failed to create team: " + err.Error())
+	}
+	c.log.Infof("Created team %d", team.Id)
+
+	if err := sendTeamResult(c, packets.TeamCreateResultType, TeamResultOk); err != nil {
+		return err
+	}
+	return setTeam(c, team, data.TeamPrivilegeMaster)
+}
+
+// The team's master is inviting a player to the team. The player has to be
+// online, and the invite is given to them on their own goroutine.
+func (server *BlockServer) HandleTeamAddMember(c *Client) error {
+	var pkt packets.TeamMemberPacket
+	if err := c.Decode(&pkt); err != nil {
+		return err
+	}
+	target := players.Find(pkt.Guildcard)
+	if c.team == nil || c.teamPrivilege != data.TeamPrivilegeMaster || c.character == nil ||
+		target == nil || target == c {
+		return sendTeamResult(c, packets.TeamAddMemberResultType, TeamResultFailed)
+	}
+	invite := &teamInvite{
+		teamId:      c.team.Id,
+		teamName:    teamName(c.team),
+		inviter:     c.guildcard,
+		inviterName: characterName(c.character),
+		expires:     time.Now().Add(teamInviteTimeout),
+	}
+	target.Queue(func() {
+		result := uint32(TeamResultOk)
+		if target.team != nil || target.character == nil {
+			result = TeamResultFailed
+		} else {
+			target.teamInvite = invite
+			SendClientMessage(target, fmt.Sprintf("%s has invited you to join the team %s.\n"+
+				"Type /team accept to join or /team decline to turn it down.", invite.inviterName, invite.teamName))
+		}
+		sendTeamResult(c, packets.TeamAddMemberResultType, result)
+	})
+	return nil
+}
+
+// Accept or decline the last invite to a team that the player was sent.
+func runTeamCommand(c *Client, args string) error {
+	invite := c.teamInvite
+	if invite == nil || time.Now().After(invite.expires) {
+		c.teamInvite = nil
+		return errors.New("You haven't been invited to a team.")
+	}
+	switch args {
+	case "accept":
+		c.teamInvite = nil
+		return joinTeam(c, invite)
+	case "decline":
+		c.teamInvite = nil
+		if inviter := players.Find(invite.inviter); inviter != nil {
+			SendClientMessage(inviter, characterName(c.character)+" turned down your invite.")
+		}
+		return SendClientMessage(c, "You turned down the invite to "+invite.teamName+".")
+	}
+	return errors.New("Usage: /team accept|decline")
+}
+
+// Add the player to the team that they accepted an invite to.
+func joinTeam(c *Client, invite *teamInvite) error {
+	if c.team != nil || c.character == nil {
+		return errors.New("You're already in a team.")
+	}
+	team, err := c.db().FindTeam(invite.teamId)
+	if err != nil {
+		c.log.Error(err.Error())
+		return errors.New("Unable to join the team.")
+	} else if team == nil {
+		return errors.New("That team has been disbanded.")
+	}
+	members, err := c.db().FindTeamMembers(team.Id)
+	if err != nil {
+		c.log.Error(err.Error())
+		return errors.New("Unable to join the team.")
+	} else if len(members) >= MaxTeamMembers {
+		return errors.New("That team is full.")
+	}
+
+	err = c.db().AddTeamMember(&data.TeamMember{
+		TeamId:    team.Id,
+		Guildcard: c.guildcard,
+		Name:      memberName(c.character),
+		Privilege: data.TeamPrivilegeMember,
+		JoinedAt:  time.Now(),
+	})
+	if err != nil {
+		c.log.Error("Failed to add team member: " + err.Error())
+		return errors.New("Unable to join the team.")
+	}
+	c.log.Infof("Joined team %d", team.Id)
+	if inviter := players.Find(invite.inviter); inviter != nil {
+		SendClientMessage(inviter, characterName(c.character)+" has joined your team.")
+	}
+	return setTeam(c, team, data.TeamPrivilegeMember)
+}
+
+// The player is leaving their team, or the master is removing someone from it.
+// The master can't leave; they have to disband the team instead.
+func (server *BlockServer) HandleTeamRemoveMember(c *Client) error {
+	var pkt packets.TeamMemberPacket
+	if err := c.Decode(&pkt); err != nil {
+		return err
+	}
+	leaving := pkt.Guildcard == c.guildcard
+	if c.team == nil || (leaving && c.teamPrivilege == data.TeamPrivilegeMaster) ||
+		(!leaving && c.teamPrivilege != data.TeamPrivilegeMaster) {
+		return sendTeamResult(c, packets.TeamRemoveMemberResultType, TeamResultFailed)
+	}
+	team := c.team
+	if err := c.db().RemoveTeamMember(team.Id, pkt.Guildcard); err != nil {
+		sendTeamResult(c, packets.TeamRemoveMemberResultType, TeamResultFailed)
+		return errors.New("Failed to remove team member: " + err.Error())
+	}
+	c.log.Infof("Removed guildcard %d from team %d", pkt.Guildcard, team.Id)
+
+	if err := sendTeamResult(c, packets.TeamRemoveMemberResultType, TeamResultOk); err != nil {
+		return err
+	}
+	if leaving {
+		return setTeam(c, nil, data.TeamPrivilegeMember)
+	}
+	if target := players.Find(pkt.Guildcard); target != nil {
+		target.Queue(func() {
+			if target.team == nil || target.team.Id != team.Id {
+				return
+			}
+			SendClientMessage(target, "You've been removed from your team.")
+			if err := setTeam(target, nil, data.TeamPrivilegeMember); err != nil {
+				target.log.Warn(err.Error())
+			}
+		})
+	}
+	return nil
+}
+
+// The player sent a message to their team, which goes to the members who are
+// online and haven't blocked them.
+func (server *BlockServer) HandleTeamChat(c *Client) {
+	if c.team == nil || c.character == nil {
+		return
+	}
+	var hdr packets.BBHeader
+	if c.Decode(&hdr) != nil || hdr.Size <= 16 {
+		return
+	}
+	message := util.StripPadding(c.Data()[16:hdr.Size])
+	if len(message)%2 != 0 {
+		message = append(message, 0)
+	}
+	if holdMutedChat(c, packets.TeamChatType, message) {
+		return
+	}
+	message, ok := chatFilters.Filter(c, message)
+	if !ok {
+		return
+	}
+	pkt := newChatPacket(c, packets.TeamChatType, message)
+	for _, member := range onlineTeamMembers(c.team.Id) {
+		if member.blocked.Blocks(c.guildcard) {
+			continue
+		}
+		if err := EncryptAndSend(member, pkt); err != nil {
+			member.log.Warn(err.Error())
+		}
+	}
+}
+
+// Send the members of the player's team.
+func (server *BlockServer) sendTeamMemberList(c *Client) error {
+	if c.team == nil {
+		return nil
+	}
+	members, err := c.db().FindTeamMembers(c.team.Id)
+	if err != nil {
+		return err
+	}
+	pkt := &packets.TeamMemberListPacket{
+		Header:     packets.BBHeader{Type: packets.TeamMemberListType},
+		NumMembers: uint32(len(members)),
+	}
+	for i, member := range members {
+		entry := packets.TeamMemberListEntry{
+			Index:     uint32(i + 1),
+			Privilege: uint32(member.Privilege),
+			Guildcard: member.Guildcard,
+		}
+		copy(entry.Name[:], member.Name)
+		pkt.Entries = append(pkt.Entries, entry)
+	}
+	return EncryptAndSend(c, pkt)
+}
+
+// The team's master changed the team's flag.
+func (server *BlockServer) HandleTeamFlag(c *Client) error {
+	var pkt packets.TeamFlagPacket
+	if err := c.Decode(&pkt); err != nil {
+		return err
+	}
+	if c.team == nil || c.teamPrivilege != data.TeamPrivilegeMaster {
+		return nil
+	}
+	flag := make([]byte, data.TeamFlagSize)
+	copy(flag, pkt.Flag[:])
+	if err := c.db().UpdateTeamFlag(c.team.Id, flag); err != nil {
+		return errors.New("Failed to save team flag: " + err.Error())
+	}
+	teamId := c.team.Id
+	for _, member := range onlineTeamMembers(teamId) {
+		member := member
+		member.Queue(func() {
+			if member.team == nil || member.team.Id != teamId {
+				return
+			}
+			member.team.Flag = flag
+			if err := sendTeamInfo(member); err != nil {
+				member.log.Warn(err.Error())
+			}
+		})
+	}
+	return nil
+}
+
+// The team's master is disbanding the team.
+func (server *BlockServer) HandleTeamDisband(c *Client) error {
+	if c.team == nil || c.teamPrivilege != data.TeamPrivilegeMaster {
+		return nil
+	}
+	team := c.team
+	if err := c.db().DeleteTeam(team.Id); err != nil {
+		return errors.New("Failed to disband team: " + err.Error())
+	}
+	c.log.Infof("Disbanded team %d", team.Id)
+
+	for _, member := range onlineTeamMembers(team.Id) {
+		if member == c {
+			continue
+		}
+		member := member
+		member.Queue(func() {
+			if member.team == nil || member.team.Id != team.Id {
+				return
+			}
+			SendClientMessage(member, "Your team has been disbanded.")
+			if err := setTeam(member, nil, data.TeamPrivilegeMember); err != nil {
+				member.log.Warn(err.Error())
+			}
+		})
+	}
+	return setTeam(c, nil, data.TeamPrivilegeMember)
+}
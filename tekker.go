@@ -0,0 +1,188 @@
+/*
+* Appraisal of unidentified weapons by the tekker. Rare weapons and weapons with a
+* special are dropped unidentified, and the player has to pay the tekker to find
+* out what they are. Appraising a weapon can move its special up or down a grade
+* within the same family (e.g. Heat, Fire, Flame, Burning) and shift each of its
+* attributes up or down a little. The player can accept the result or refuse it,
+* in which case the weapon stays unidentified and can be appraised again.
+ */
+package main
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/dcrodman/archon/data"
+	"github.com/dcrodman/archon/packets"
+)
+
+const (
+	// Bits in the fifth byte of a weapon's data.
+	WeaponSpecialMask  = 0x3F
+	WeaponUnidentified = 0x80
+)
+
+// Specials that can be appraised into each other, from worst to best.
+var specialFamilies = [][]uint8{
+	{1, 2, 3, 4},     // Draw, Drain, Fill, Gush
+	{5, 6, 7, 8},     // Heart, Mind, Soul, Geist
+	{9, 10, 11},      // Master's, Lord's, King's
+	{15, 16, 17, 18}, // Ice, Frost, Freeze, Blizzard
+	{19, 20, 21, 22}, // Bind, Hold, Seize, Arrest
+	{23, 24, 25, 26}, // Heat, Fire, Flame, Burning
+	{27, 28, 29, 30}, // Shock, Thunder, Storm, Tempest
+	{31, 32, 33, 34}, // Dim, Shadow, Dark, Hell
+	{35, 36, 37, 38}, // Panic, Riot, Havoc, Chaos
+	{39, 40},         // Devil's, Demon's
+}
+
+// Changes to a weapon's special and to each of its attributes, from worst to best,
+// and how likely each is without any bias.
+var (
+	specialChanges = []int{-1, 0, 1}
+	specialWeights = []float64{1, 8, 1}
+	percentChanges = []int{-10, -5, 0, 5, 10}
+	percentWeights = []float64{1, 2, 4, 2, 1}
+)
+
+func isWeapon(item data.Item) bool {
+	return len(item.Data) == 12 && item.Data[0] == 0x00
+}
+
+func isUnidentified(item data.Item) bool {
+	return isWeapon(item) && item.Data[4]&WeaponUnidentified != 0
+}
+
+// Mark a dropped weapon as unidentified if it's rare or has a special.
+func unidentified(item data.Item, rare bool) data.Item {
+	if isWeapon(item) && (rare || item.Data[4]&WeaponSpecialMask != 0) {
+		item.Data[4] |= WeaponUnidentified
+	}
+	return item
+}
+
+// Pick one of the changes with the chances given by the weights, shifted towards
+// the better changes by a positive bias or the worse ones by a negative bias.
+func rollChange(changes []int, weights []float64, bias float64) int {
+	biased := make([]float64, len(weights))
+	total := 0.0
+	for i, weight := range weights {
+		biased[i] = weight * math.Exp(2*bias*float64(i-len(weights)/2))
+		total += biased[i]
+	}
+	n := rand.Float64() * total
+	for i, weight := range biased {
+		if n < weight {
+			return changes[i]
+		}
+		n -= weight
+	}
+	return changes[len(changes)-1]
+}
+
+// Returns the weapon as the tekker appraised it.
+func appraise(item data.Item, bias float64) data.Item {
+	result := copyItem(item)
+	result.Data[4] &^= WeaponUnidentified
+
+	special := result.Data[4] & WeaponSpecialMask
+	for _, family := range specialFamilies {
+		for i, s := range family {
+			if s != special {
+				continue
+			}
+			grade := i + rollChange(specialChanges, specialWeights, bias)
+			if grade < 0 {
+				grade = 0
+			} else if grade >= len(family) {
+				grade = len(family) - 1
+			}
+			result.Data[4] = result.Data[4]&^WeaponSpecialMask | family[grade]
+		}
+	}
+
+	// Up to three attributes, each an attribute type followed by a percentage.
+	for i := 6; i < 12; i += 2 {
+		if result.Data[i] == 0 {
+			continue
+		}
+		percent := int(int8(result.Data[i+1])) + rollChange(percentChanges, percentWeights, bias)
+		if percent < 0 {
+			percent = 0
+		} else if percent > 100 {
+			percent = 100
+		}
+		result.Data[i+1] = uint8(percent)
+	}
+	return result
+}
+
+// The player asked the tekker to appraise a weapon. They're charged for it and
+// shown the result, which isn't applied until they accept it.
+func (server *BlockServer) HandleIdentifyItem(c *Client) error {
+	var pkt packets.IdentifyItemPacket
+	if err := c.Decode(&pkt); err != nil {
+		return err
+	}
+	if c.game == nil || c.character == nil || c.trading() {
+		return nil
+	}
+	index := findItem(c.inventory, pkt.ItemId)
+	if index < 0 {
+		rejectItemCommand(c, "identify", pkt.ItemId)
+		return nil
+	} else if !isUnidentified(c.inventory[index]) {
+		return nil
+	}
+	tekker := config.Tekker()
+	if c.character.Meseta < tekker.TekkerCost {
+		return nil
+	}
+	c.character.Meseta -= tekker.TekkerCost
+	auditItem(c, data.ItemAuditAppraised, &c.inventory[index], 0, tekker.TekkerCost)
+
+	result := appraise(c.inventory[index], tekker.TekkerBias)
+	c.identifyResult = &result
+	c.log.Debug("Sending Identify Result")
+	return EncryptAndSend(c, &packets.IdentifyResultPacket{
+		Header:    packets.BBHeader{Type: packets.GameCommandType},
+		SubHeader: packets.SubCmdHeader{Type: packets.SubCmdIdentifyResult, Size: 6, ClientId: uint16(c.clientId)},
+		Item:      newItemData(result),
+	})
+}
+
+// The player accepted the tekker's appraisal, so the weapon becomes what the
+// tekker said it was.
+func (server *BlockServer) HandleAcceptIdentify(c *Client) error {
+	var pkt packets.IdentifyItemPacket
+	if err := c.Decode(&pkt); err != nil {
+		return err
+	}
+	g := c.game
+	result := c.identifyResult
+	c.identifyResult = nil
+	if g == nil || result == nil || result.ItemId != pkt.ItemId || c.trading() {
+		return nil
+	}
+	index := findItem(c.inventory, pkt.ItemId)
+	if index < 0 {
+		rejectItemCommand(c, "accept the appraisal of", pkt.ItemId)
+		return nil
+	}
+	result.Flags = c.inventory[index].Flags
+	c.inventory[index] = *result
+	auditItem(c, data.ItemAuditIdentified, result, 0, 0)
+
+	g.Broadcast(&packets.DestroyItemPacket{
+		Header:    packets.BBHeader{Type: packets.GameCommandType},
+		SubHeader: packets.SubCmdHeader{Type: packets.SubCmdDestroyItem, Size: 3, ClientId: uint16(c.clientId)},
+		ItemId:    pkt.ItemId,
+		Amount:    1,
+	}, nil)
+	g.Broadcast(&packets.CreateInventoryItemPacket{
+		Header:    packets.BBHeader{Type: packets.GameCommandType},
+		SubHeader: packets.SubCmdHeader{Type: packets.SubCmdCreateInventoryItem, Size: 7, ClientId: uint16(c.clientId)},
+		Item:      newItemData(*result),
+	}, nil)
+	return nil
+}
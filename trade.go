@@ -0,0 +1,424 @@
+/*
+* Trades between players in a game. The players agree on what to trade in the
+* trade window, which their clients handle between themselves, and then each
+* client sends the server what its player is giving. The server checks that each
+* player really has what they're giving and, once both confirm, moves the items
+* and meseta between their inventories and saves both characters together (in
+* order with their other saves; see saves.go) so that a failure partway through
+* can't lose or duplicate anything. Completed trades are recorded in the database
+* for auditing the economy.
+*
+* Each player's side of a trade is worked out and applied on that player's own
+* goroutine. The player who confirms last works out their side and queues the
+* rest of the trade on their partner (see Client.Queue), who works out theirs
+* and saves the trade. The first player's side is applied once their goroutine
+* gets to it, and before anything else can change their inventory.
+ */
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dcrodman/archon/data"
+	"github.com/dcrodman/archon/packets"
+)
+
+// What one of the players in a trade is giving to the other.
+type tradeOffer struct {
+	// Client id of the player that the items are going to.
+	partner   uint8
+	items     []data.Item
+	meseta    uint32
+	confirmed bool
+	// Set once both players have confirmed, after which neither can change or
+	// cancel the trade short of leaving the game.
+	completing bool
+}
+
+// One player's inventory and meseta as they'll be after a trade.
+type tradeResult struct {
+	// Copy of the character with the new meseta, to be saved with the trade.
+	character *data.Character
+	inventory []data.Item
+	meseta    uint32
+	// The items received, with the ids they were given, to announce to the game.
+	received []data.Item
+}
+
+// Check the items and meseta that the player is offering against what they have.
+func newTradeOffer(c *Client, pkt *packets.TradeItemsPacket) (*tradeOffer, error) {
+	if pkt.NumItems > packets.MaxTradeItems {
+		return nil, errors.New("too many items")
+	}
+	offer := &tradeOffer{partner: uint8(pkt.TargetClientId)}
+	offered := make(map[uint32]bool)
+	for _, itemData := range pkt.Items[:pkt.NumItems] {
+		item := data.Item{Data: itemData.Data[:], Data2: itemData.Data2[:], ItemId: itemData.ItemId}
+		if isMeseta(item) {
+			offer.meseta += mesetaAmount(item)
+			continue
+		}
+		index := findItem(c.inventory, item.ItemId)
+		if index < 0 || offered[item.ItemId] {
+			return nil, fmt.Errorf("item %x isn't in their inventory", item.ItemId)
+		}
+		offered[item.ItemId] = true
+		held := c.inventory[index]
+		if held.Flags&ItemEquipped != 0 {
+			return nil, fmt.Errorf("item %x is equipped", item.ItemId)
+		}
+
+		given := copyItem(held)
+		given.Flags = 0
+		if isStackable(held) {
+			// Stacks can be split, so only the amount can differ.
+			amount := itemAmount(item)
+			if amount > itemAmount(held) {
+				return nil, fmt.Errorf("item %x doesn't have %d in its stack", item.ItemId, amount)
+			}
+			given.Data[5] = amount
+		}
+		if !bytes.Equal(given.Data, item.Data) {
+			return nil, fmt.Errorf("item %x doesn't match their inventory", item.ItemId)
+		}
+		offer.items = append(offer.items, given)
+	}
+	if offer.meseta > c.character.Meseta {
+		return nil, fmt.Errorf("offered %d meseta but only has %d", offer.meseta, c.character.Meseta)
+	}
+	return offer, nil
+}
+
+// Returns true if the player has offered a trade that hasn't been completed or
+// cancelled. Their inventory can't change in the meantime, since their partner
+// could complete the trade at any moment. A trade that's been saved but not yet
+// applied to the player is applied first. Only to be called from the player's
+// own goroutine.
+func (c *Client) trading() bool {
+	c.tradeResultLock.Lock()
+	c.applyTradeResult()
+	c.tradeResultLock.Unlock()
+	g := c.game
+	if g == nil {
+		return false
+	}
+	g.tradeLock.Lock()
+	defer g.tradeLock.Unlock()
+	return g.trades[c.clientId] != nil
+}
+
+// Apply the trade that's been saved for the player, if there is one. Only to be
+// called from the player's own goroutine while holding their tradeResultLock.
+func (c *Client) applyTradeResult() {
+	if result := c.tradeResult; result != nil {
+		c.tradeResult = nil
+		if c.character != nil {
+			c.character.Meseta = result.meseta
+		}
+		c.inventory = result.inventory
+	}
+}
+
+// Cancel any trade that the player is part of and let everyone in it know. Once
+// both players have confirmed, the trade is only cancelled if the player is
+// leaving, since it may already be being carried out.
+func (g *Game) cancelTrade(c *Client, leaving bool) {
+	g.tradeLock.Lock()
+	involved := make(map[uint8]bool)
+	if offer := g.trades[c.clientId]; offer != nil {
+		if offer.completing && !leaving {
+			g.tradeLock.Unlock()
+			return
+		}
+		involved[offer.partner] = true
+		delete(g.trades, c.clientId)
+	}
+	for clientId, offer := range g.trades {
+		if offer.partner == c.clientId {
+			involved[clientId] = true
+			delete(g.trades, clientId)
+		}
+	}
+	g.tradeLock.Unlock()
+
+	if len(involved) == 0 {
+		return
+	}
+	involved[c.clientId] = true
+	g.sendTradeResult(involved, false)
+}
+
+// Tell the players in a trade whether it went through.
+func (g *Game) sendTradeResult(clientIds map[uint8]bool, completed bool) {
+	result := &packets.BBHeader{Type: packets.TradeCompleteType}
+	if completed {
+		result.Flags = 1
+	}
+	for clientId := range clientIds {
+		if player := g.Client(clientId); player != nil {
+			EncryptAndSend(player, result)
+		}
+	}
+}
+
+// The player offered items and meseta in a trade. Once both players have made
+// their offers, they're asked to confirm.
+func (server *BlockServer) HandleTradeItems(c *Client) error {
+	var pkt packets.TradeItemsPacket
+	if err := c.Decode(&pkt); err != nil {
+		return err
+	}
+	g := c.game
+	if g == nil || c.character == nil {
+		return nil
+	}
+	partner := g.Client(uint8(pkt.TargetClientId))
+	if partner == nil || partner == c {
+		g.cancelTrade(c, false)
+		return nil
+	}
+	offer, err := newTradeOffer(c, &pkt)
+	if err != nil {
+		c.log.Warnf("Invalid trade offer from guildcard %d: %s", c.guildcard, err.Error())
+		g.cancelTrade(c, false)
+		return nil
+	}
+
+	g.tradeLock.Lock()
+	if current := g.trades[c.clientId]; current != nil && current.completing {
+		g.tradeLock.Unlock()
+		return nil
+	}
+	g.trades[c.clientId] = offer
+	other := g.trades[offer.partner]
+	ready := other != nil && other.partner == c.clientId
+	g.tradeLock.Unlock()
+
+	if ready {
+		EncryptAndSend(partner, &packets.BBHeader{Type: packets.TradeReadyType})
+		return EncryptAndSend(c, &packets.BBHeader{Type: packets.TradeReadyType})
+	}
+	return nil
+}
+
+// The player confirmed the trade. The trade is carried out once both have.
+func (server *BlockServer) HandleTradeConfirm(c *Client) error {
+	g := c.game
+	if g == nil {
+		return nil
+	}
+	g.tradeLock.Lock()
+	offer := g.trades[c.clientId]
+	if offer == nil || offer.completing {
+		g.tradeLock.Unlock()
+		return nil
+	}
+	offer.confirmed = true
+	other := g.trades[offer.partner]
+	if other == nil || other.partner != c.clientId || !other.confirmed {
+		g.tradeLock.Unlock()
+		return nil
+	}
+	offer.completing, other.completing = true, true
+	g.tradeLock.Unlock()
+
+	partner := g.Client(offer.partner)
+	if partner == nil {
+		g.cancelTrade(c, true)
+		return nil
+	}
+	result, err := tradeResultOf(c, offer, other)
+	if err != nil {
+		c.log.Warnf("Trade between guildcards %d and %d failed: %s", c.guildcard, partner.guildcard, err.Error())
+		g.cancelTrade(c, true)
+		return nil
+	}
+	partner.Queue(func() {
+		g.completeTrade(c, offer, result, partner, other)
+	})
+	return nil
+}
+
+// The player cancelled the trade.
+func (server *BlockServer) HandleTradeCancel(c *Client) {
+	if c.game != nil {
+		c.game.cancelTrade(c, false)
+	}
+}
+
+// Returns the player's inventory and meseta as they'd be after giving away what
+// they offered and receiving what their partner did. The received items are
+// given new ids, so this needs to be called from the player's own goroutine.
+func tradeResultOf(c *Client, given *tradeOffer, received *tradeOffer) (*tradeResult, error) {
+	if c.character == nil {
+		return nil, errors.New("missing character")
+	}
+	if given.meseta > c.character.Meseta {
+		return nil, errors.New("not enough meseta")
+	}
+	meseta := c.character.Meseta - given.meseta + received.meseta
+	if meseta > MaxMeseta {
+		return nil, errors.New("too much meseta")
+	}
+	inventory, err := removeTradeItems(c.inventory, given.items)
+	if err != nil {
+		return nil, err
+	}
+	inventory, announced, err := addTradeItems(c, inventory, received.items)
+	if err != nil {
+		return nil, err
+	}
+	character := *c.character
+	character.Meseta = meseta
+	return &tradeResult{character: &character, inventory: inventory, meseta: meseta, received: announced}, nil
+}
+
+// Work out the partner's side of the trade and save it along with the player's,
+// then apply the partner's side and queue the player's to be applied. Runs on
+// the partner's goroutine. Nothing changes for either player unless the trade
+// is saved.
+func (g *Game) completeTrade(a *Client, offerA *tradeOffer, resultA *tradeResult, b *Client, offerB *tradeOffer) {
+	resultB, err := tradeResultOf(b, offerB, offerA)
+	if err == nil && b.game != g {
+		err = errors.New("partner left the game")
+	}
+	if err != nil {
+		b.log.Warnf("Trade between guildcards %d and %d failed: %s", a.guildcard, b.guildcard, err.Error())
+		g.cancelTrade(b, true)
+		return
+	}
+
+	trade := data.Trade{
+		TradedAt: time.Now(),
+		Sides: [2]data.TradeSide{
+			{Guildcard: a.guildcard, SlotNum: uint32(a.config.SlotNum), Meseta: offerA.meseta, Items: offerA.items},
+			{Guildcard: b.guildcard, SlotNum: uint32(b.config.SlotNum), Meseta: offerB.meseta, Items: offerB.items},
+		},
+	}
+
+	// Either player leaving cancels the trade, so it's only saved if both are
+	// still in it. The player's character is saved from a copy of the character
+	// made when the trade was worked out, since it's only theirs to read.
+	g.tradeLock.Lock()
+	if g.trades[a.clientId] != offerA || g.trades[b.clientId] != offerB {
+		g.tradeLock.Unlock()
+		return
+	}
+	delete(g.trades, a.clientId)
+	delete(g.trades, b.clientId)
+	a.tradeResultLock.Lock()
+	// The trade goes through the save journal so that it's written in order with
+	// the saves already queued for either character.
+	err = saves.Queue(characterSave{Trade: &tradeSave{
+		Trade:       trade,
+		Characters:  [2]*data.Character{resultA.character, resultB.character},
+		Inventories: [2][]data.Item{resultA.inventory, resultB.inventory},
+	}})
+	if err == nil {
+		a.tradeResult = resultA
+	}
+	a.tradeResultLock.Unlock()
+	g.tradeLock.Unlock()
+
+	players := map[uint8]bool{a.clientId: true, b.clientId: true}
+	if err != nil {
+		b.log.Errorf("Failed to save trade between guildcards %d and %d: %s", a.guildcard, b.guildcard, err.Error())
+		g.sendTradeResult(players, false)
+		return
+	}
+	b.character.Meseta = resultB.meseta
+	b.inventory = resultB.inventory
+	a.Queue(func() {
+		a.tradeResultLock.Lock()
+		a.applyTradeResult()
+		a.tradeResultLock.Unlock()
+	})
+	auditTrade(a, offerA, b, offerB)
+	log.Infof("Trade: guildcard %d gave %d items and %d meseta to guildcard %d for %d items and %d meseta",
+		a.guildcard, len(offerA.items), offerA.meseta, b.guildcard, len(offerB.items), offerB.meseta)
+
+	// The clients leave it to the server to move the items, so everyone in the
+	// game needs to be told about them.
+	g.announceTrade(a, offerA, resultA.received, offerB.meseta)
+	g.announceTrade(b, offerB, resultB.received, offerA.meseta)
+	g.sendTradeResult(players, true)
+}
+
+// Let everyone know what the player gave and received in a trade.
+func (g *Game) announceTrade(c *Client, given *tradeOffer, received []data.Item, receivedMeseta uint32) {
+	destroy := func(itemId uint32, amount uint32) {
+		g.Broadcast(&packets.DestroyItemPacket{
+			Header:    packets.BBHeader{Type: packets.GameCommandType},
+			SubHeader: packets.SubCmdHeader{Type: packets.SubCmdDestroyItem, Size: 3, ClientId: uint16(c.clientId)},
+			ItemId:    itemId,
+			Amount:    amount,
+		}, nil)
+	}
+	for _, item := range given.items {
+		destroy(item.ItemId, uint32(itemAmount(item)))
+	}
+	if given.meseta > 0 {
+		destroy(MesetaItemId, given.meseta)
+	}
+	if receivedMeseta > 0 {
+		received = append(received, newMesetaItem(receivedMeseta))
+	}
+	for _, item := range received {
+		g.Broadcast(&packets.CreateInventoryItemPacket{
+			Header:    packets.BBHeader{Type: packets.GameCommandType},
+			SubHeader: packets.SubCmdHeader{Type: packets.SubCmdCreateInventoryItem, Size: 7, ClientId: uint16(c.clientId)},
+			Item:      newItemData(item),
+		}, nil)
+	}
+}
+
+// Returns a copy of the inventory without the items given in a trade.
+func removeTradeItems(inventory []data.Item, given []data.Item) ([]data.Item, error) {
+	remaining := make([]data.Item, 0, len(inventory))
+	for _, item := range inventory {
+		remaining = append(remaining, copyItem(item))
+	}
+	for _, item := range given {
+		index := findItem(remaining, item.ItemId)
+		if index < 0 || itemAmount(item) > itemAmount(remaining[index]) {
+			return nil, fmt.Errorf("item %x is no longer in the inventory", item.ItemId)
+		}
+		if isStackable(item) && itemAmount(item) < itemAmount(remaining[index]) {
+			remaining[index].Data[5] -= itemAmount(item)
+		} else {
+			remaining = append(remaining[:index], remaining[index+1:]...)
+		}
+	}
+	return remaining, nil
+}
+
+// Returns the inventory with the items received in a trade added to it, along with
+// the items as they're announced to the game (with the ids they were given).
+func addTradeItems(c *Client, inventory []data.Item, received []data.Item) ([]data.Item, []data.Item, error) {
+	var announced []data.Item
+	for _, item := range received {
+		added := copyItem(item)
+		stack := -1
+		if isStackable(item) {
+			stack = findStack(inventory, item)
+		}
+		if stack >= 0 {
+			if itemAmount(inventory[stack])+itemAmount(item) > MaxStackSize {
+				return nil, nil, errors.New("stack is full")
+			}
+			inventory[stack].Data[5] += itemAmount(item)
+			added.ItemId = inventory[stack].ItemId
+		} else {
+			if len(inventory) >= MaxInventoryItems {
+				return nil, nil, errors.New("inventory is full")
+			}
+			added.ItemId = c.newItemId()
+			inventory = append(inventory, added)
+		}
+		announced = append(announced, added)
+	}
+	return inventory, announced, nil
+}
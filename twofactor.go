@@ -0,0 +1,211 @@
+/*
+* Optional TOTP (RFC 6238) two-factor authentication. Players enroll through the
+* web API, which gives them a secret for their authenticator app along with a
+* set of recovery codes, and turn it on by confirming a code from the app. Blue
+* Burst only has the one password field, so from then on players type the
+* current code straight after their password. Only the login server checks the
+* code; the client sends the same password every time it connects, so the
+* character and ship servers strip the code off and rely on the session token
+* instead (see session.go). A recovery code can be used in place of a code to
+* turn two-factor authentication off if the authenticator is lost.
+ */
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dcrodman/archon/data"
+	"github.com/dcrodman/archon/packets"
+	"github.com/dcrodman/archon/util"
+)
+
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	// Codes from this many steps either side of the current one are accepted
+	// to allow for clocks that are a little off.
+	totpSkew          = 1
+	recoveryCodeCount = 8
+	// Passwords have to leave room in the login packet for the code.
+	MaxTwoFactorPasswordLength = MaxPasswordLength - totpDigits
+)
+
+var (
+	ErrTwoFactorPassword = fmt.Errorf("passwords must be at most %d characters long to "+
+		"leave room for the code", MaxTwoFactorPasswordLength)
+	ErrTwoFactorEnabled     = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnrolled = errors.New("two-factor authentication is not enabled")
+	ErrInvalidTwoFactorCode = errors.New("invalid code")
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Returns the code for a time step.
+func totpCode(key []byte, step int64) string {
+	mac := hmac.New(sha1.New, key)
+	binary.Write(mac, binary.BigEndian, step)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0F
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7FFFFFFF
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// Check code against the secret at time t. Codes from steps at or before the
+// last one that was accepted are rejected so that they can't be replayed. If
+// the code is good then LastStep is updated, but it's up to the caller to save it.
+func checkTOTP(twoFactor *data.TwoFactor, code string, t time.Time) bool {
+	key, err := totpEncoding.DecodeString(twoFactor.Secret)
+	if err != nil || len(code) != totpDigits {
+		return false
+	}
+	current := t.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step > twoFactor.LastStep && hmac.Equal([]byte(totpCode(key, step)), []byte(code)) {
+			twoFactor.LastStep = step
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the otpauth URI for the secret, which authenticator apps can read
+// (usually from a QR code) to add the account.
+func totpURI(username, secret string) string {
+	label := url.PathEscape(config.ShipName + ":" + username)
+	return "otpauth://totp/" + label + "?secret=" + secret + "&issuer=" +
+		url.QueryEscape(config.ShipName) + fmt.Sprintf("&digits=%d&period=%d", totpDigits, totpPeriod)
+}
+
+// Split the code that players with two-factor authentication type after their
+// password off of what was in the password field.
+func splitTwoFactorCode(password string) (string, string) {
+	if len(password) <= totpDigits {
+		return password, ""
+	}
+	return password[:len(password)-totpDigits], password[len(password)-totpDigits:]
+}
+
+// Recovery codes are only stored as hashes in case the database is leaked.
+// They're case insensitive, and any spaces or dashes are ignored.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// EnrollTwoFactor generates a new secret and recovery codes for an account. Two
+// factor authentication isn't enforced until it's confirmed with a code from the
+// secret. Enrolling again before then replaces the secret and codes.
+func EnrollTwoFactor(account *data.Account, password string) (secret string, recoveryCodes []string, err error) {
+	if len(password) > MaxTwoFactorPasswordLength {
+		return "", nil, ErrTwoFactorPassword
+	}
+	existing, err := database.FindTwoFactor(account.Username)
+	if err != nil {
+		return "", nil, err
+	} else if existing != nil && existing.Enabled {
+		return "", nil, ErrTwoFactorEnabled
+	}
+
+	key := make([]byte, 20)
+	if _, err = rand.Read(key); err != nil {
+		return "", nil, err
+	}
+	var hashes []string
+	for i := 0; i < recoveryCodeCount; i++ {
+		codeBytes := make([]byte, 5)
+		if _, err = rand.Read(codeBytes); err != nil {
+			return "", nil, err
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(codeBytes))
+		recoveryCodes = append(recoveryCodes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	secret = totpEncoding.EncodeToString(key)
+	twoFactor := &data.TwoFactor{Username: account.Username, Secret: secret}
+	if err = database.SaveTwoFactor(twoFactor, hashes); err != nil {
+		return "", nil, err
+	}
+	return secret, recoveryCodes, nil
+}
+
+// ConfirmTwoFactor turns on two-factor authentication for an account once the
+// player has shown that their authenticator works.
+func ConfirmTwoFactor(username, code string) error {
+	twoFactor, err := database.FindTwoFactor(username)
+	if err != nil {
+		return err
+	} else if twoFactor == nil {
+		return ErrTwoFactorNotEnrolled
+	} else if twoFactor.Enabled {
+		return ErrTwoFactorEnabled
+	}
+	if !checkTOTP(twoFactor, code, time.Now()) {
+		return ErrInvalidTwoFactorCode
+	}
+	twoFactor.Enabled = true
+	if err = database.UpdateTwoFactor(twoFactor); err == data.ErrTwoFactorStepUsed {
+		return ErrInvalidTwoFactorCode
+	}
+	return err
+}
+
+// DisableTwoFactor turns off two-factor authentication for an account given
+// either a code from the authenticator or one of the recovery codes.
+func DisableTwoFactor(username, code string) error {
+	twoFactor, err := database.FindTwoFactor(username)
+	if err != nil {
+		return err
+	} else if twoFactor == nil || !twoFactor.Enabled {
+		return ErrTwoFactorNotEnrolled
+	}
+	if !checkTOTP(twoFactor, code, time.Now()) {
+		ok, err := database.UseRecoveryCode(username, hashRecoveryCode(code))
+		if err != nil {
+			return err
+		} else if !ok {
+			return ErrInvalidTwoFactorCode
+		}
+	}
+	return database.DeleteTwoFactor(username)
+}
+
+// Check the code typed after the password in the login packet if the player
+// has two-factor authentication enabled.
+func checkTwoFactorLogin(client *Client, loginPkt *packets.LoginPkt) error {
+	twoFactor, err := client.db().FindTwoFactor(client.username)
+	if err != nil {
+		sendDatabaseError(client, err)
+		return err
+	} else if twoFactor == nil || !twoFactor.Enabled {
+		return nil
+	}
+
+	// VerifyAccount has already checked the password itself.
+	_, code := splitTwoFactorCode(string(util.StripPadding(loginPkt.Password[:])))
+	if !checkTOTP(twoFactor, code, time.Now()) {
+		SendSecurity(client, packets.BBLoginErrorPassword, 0, 0)
+		return refuseLogin(data.LoginBadTwoFactor,
+			errors.New("Invalid two-factor code for username: "+client.username))
+	}
+	if err = client.db().UpdateTwoFactor(twoFactor); err == data.ErrTwoFactorStepUsed {
+		// Another login used the same code first.
+		SendSecurity(client, packets.BBLoginErrorPassword, 0, 0)
+		return refuseLogin(data.LoginBadTwoFactor,
+			errors.New("Reused two-factor code for username: "+client.username))
+	} else if err != nil {
+		client.log.Warn("Failed to save two-factor step: " + err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+/*
+ * Pool of buffers for packet I/O, so that sending and receiving packets doesn't
+ * allocate a new buffer for each one. Buffers that have grown very large (e.g.
+ * for a quest file) aren't kept, so that one big packet doesn't hold on to its
+ * memory for the life of the server.
+ */
+package util
+
+import "sync"
+
+// Buffers larger than this are left for the garbage collector.
+const maxPooledBufferSize = 64 * 1024
+
+// Slices are stored by pointer, since the pool holds interface{} values.
+var bytesPool sync.Pool
+
+// GetBytes returns a slice of size bytes, reusing one from the pool if there's
+// one big enough. Its contents are whatever was left in it.
+func GetBytes(size int) []byte {
+	if b, ok := bytesPool.Get().(*[]byte); ok && cap(*b) >= size {
+		return (*b)[:size]
+	}
+	return make([]byte, size)
+}
+
+// PutBytes returns a slice from GetBytes to the pool once nothing refers to it.
+func PutBytes(b []byte) {
+	if cap(b) <= maxPooledBufferSize {
+		bytesPool.Put(&b)
+	}
+}
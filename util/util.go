@@ -5,12 +5,15 @@ package util
 
 import (
 	"bytes"
+	"encoding"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"math"
 	"reflect"
 	"strconv"
 	"unicode/utf16"
+	"unsafe"
 )
 
 const displayWidth = 16
@@ -64,14 +67,38 @@ func ZeroSlice(arr []byte, length int) {
 	}
 }
 
+// Implemented by the structs with generated encodings (see
+// setup/tools/packetgen.go), which are used instead of reflection.
+type binaryAppender interface {
+	AppendBinary(b []byte) ([]byte, error)
+}
+
 // Serializes the fields of a struct to an array of bytes in the order in
 // which the fields are declared. Calls panic() if data is not a struct or
 // pointer to struct, or if there was an error writing a field.
 func BytesFromStruct(data interface{}) ([]byte, int) {
+	scratch := AppendStruct(GetBytes(0), data)
+	b := make([]byte, len(scratch))
+	copy(b, scratch)
+	PutBytes(scratch)
+	return b, len(b)
+}
+
+// AppendStruct is BytesFromStruct for callers that have somewhere to put the
+// bytes already, such as a slice from GetBytes, and appends them to b.
+func AppendStruct(b []byte, data interface{}) []byte {
+	if appender, ok := data.(binaryAppender); ok {
+		b, err := appender.AppendBinary(b)
+		if err != nil {
+			panic(err.Error())
+		}
+		return b
+	}
+
 	val := reflect.ValueOf(data)
 	valKind := val.Kind()
 	if valKind == reflect.Ptr {
-		val = reflect.ValueOf(data).Elem()
+		val = val.Elem()
 		valKind = val.Kind()
 	}
 
@@ -79,34 +106,109 @@ func BytesFromStruct(data interface{}) ([]byte, int) {
 		panic("BytesFromStruct(): data must of type struct " +
 			"or ptr to struct, got: " + valKind.String())
 	}
+	return appendFields(b, val)
+}
 
-	bytes := new(bytes.Buffer)
-	// It's possible to use binary.Write on val.Interface itself, but doing
-	// so prevents this function from working with dynamically sized types.
+func appendFields(b []byte, val reflect.Value) []byte {
+	// It's possible to use binary.Write on the whole struct, but doing so
+	// prevents this function from working with dynamically sized types.
 	for i := 0; i < val.NumField(); i++ {
-		field := val.Field(i)
-
 		var err error
-		switch kind := field.Kind(); kind {
-		case reflect.Struct, reflect.Ptr:
-			b, _ := BytesFromStruct(field.Interface())
-			err = binary.Write(bytes, binary.LittleEndian, b)
-		default:
-			err = binary.Write(bytes, binary.LittleEndian, field.Interface())
-		}
-		if err != nil {
+		if b, err = appendValue(b, val.Field(i)); err != nil {
 			panic(err.Error())
 		}
 	}
-	return bytes.Bytes(), bytes.Len()
+	return b
+}
+
+// Append a value in little endian byte order as binary.Write would, but without
+// the allocations that binary.Write makes for every value it's given.
+func appendValue(b []byte, val reflect.Value) ([]byte, error) {
+	switch val.Kind() {
+	case reflect.Struct:
+		if val.CanAddr() {
+			if appender, ok := val.Addr().Interface().(binaryAppender); ok {
+				return appender.AppendBinary(b)
+			}
+		}
+		return appendFields(b, val), nil
+	case reflect.Ptr:
+		return AppendStruct(b, val.Interface()), nil
+	case reflect.Bool:
+		if val.Bool() {
+			return append(b, 1), nil
+		}
+		return append(b, 0), nil
+	case reflect.Uint8, reflect.Int8:
+		return append(b, byte(intBits(val))), nil
+	case reflect.Uint16, reflect.Int16:
+		v := intBits(val)
+		return append(b, byte(v), byte(v>>8)), nil
+	case reflect.Uint32, reflect.Int32:
+		return appendUint32(b, uint32(intBits(val))), nil
+	case reflect.Uint64, reflect.Int64:
+		v := intBits(val)
+		return appendUint32(appendUint32(b, uint32(v)), uint32(v>>32)), nil
+	case reflect.Float32:
+		return appendUint32(b, math.Float32bits(float32(val.Float()))), nil
+	case reflect.Float64:
+		v := math.Float64bits(val.Float())
+		return appendUint32(appendUint32(b, uint32(v)), uint32(v>>32)), nil
+	case reflect.Array, reflect.Slice:
+		if val.Type().Elem().Kind() == reflect.Uint8 {
+			if val.Kind() == reflect.Slice {
+				return append(b, val.Bytes()...), nil
+			} else if n := val.Len(); val.CanAddr() && n > 0 {
+				// Copy the array straight from the struct's memory, since going
+				// through reflect one byte at a time is slow and slicing the
+				// array with reflect allocates.
+				return append(b, (*[1 << 30]byte)(unsafe.Pointer(val.UnsafeAddr()))[:n:n]...), nil
+			}
+		}
+		var err error
+		for i := 0; i < val.Len(); i++ {
+			if b, err = appendValue(b, val.Index(i)); err != nil {
+				return b, err
+			}
+		}
+		return b, nil
+	}
+	buf := bytes.NewBuffer(b)
+	err := binary.Write(buf, binary.LittleEndian, val.Interface())
+	return buf.Bytes(), err
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+// Returns the bits of a signed or unsigned integer value.
+func intBits(val reflect.Value) uint64 {
+	switch val.Kind() {
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return uint64(val.Int())
+	}
+	return val.Uint()
 }
 
 // Populates the struct pointed to by targetStruct by reading in a stream of
-// bytes and filling the values in sequential order.
+// bytes and filling the values in sequential order. Calls panic() if data is
+// too short; use DecodeStruct for data that comes from a client.
 func StructFromBytes(data []byte, targetStruct interface{}) {
+	if err := DecodeStruct(data, targetStruct); err != nil {
+		panic(err.Error())
+	}
+}
+
+// DecodeStruct is StructFromBytes for untrusted data, returning an error
+// rather than panicking if data isn't long enough to fill targetStruct.
+func DecodeStruct(data []byte, targetStruct interface{}) error {
+	if unmarshaler, ok := targetStruct.(encoding.BinaryUnmarshaler); ok {
+		return unmarshaler.UnmarshalBinary(data)
+	}
 	targetVal := reflect.ValueOf(targetStruct)
 	if valKind := targetVal.Kind(); valKind != reflect.Ptr {
-		panic("StructFromBytes(): targetStruct must be a " +
+		panic("DecodeStruct(): targetStruct must be a " +
 			"ptr to struct, got: " + valKind.String())
 	}
 	reader := bytes.NewReader(data)
@@ -122,9 +224,10 @@ func StructFromBytes(data []byte, targetStruct interface{}) {
 			err = binary.Read(reader, binary.LittleEndian, field.Addr().Interface())
 		}
 		if err != nil {
-			panic(err.Error())
+			return fmt.Errorf("field %s: %s", val.Type().Field(i).Name, err.Error())
 		}
 	}
+	return nil
 }
 
 // Write one line of data to stdout.
@@ -0,0 +1,124 @@
+package util
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+type benchHeader struct {
+	Size  uint16
+	Type  uint16
+	Flags uint32
+}
+
+type benchPlayer struct {
+	Tag       uint32
+	Guildcard uint32
+	ClientId  uint16
+	Unknown   [3]uint16
+	Name      [16]uint16
+}
+
+// Shaped like a lobby join packet: a header, some scalars, and an array of
+// nested structs with arrays of their own.
+type benchPacket struct {
+	Header   benchHeader
+	ClientId uint8
+	LeaderId uint8
+	Disable  bool
+	LobbyNum uint8
+	Block    uint16
+	Event    uint16
+	Rate     float32
+	Players  [4]benchPlayer
+	Padding  [64]byte
+}
+
+func newBenchPacket() *benchPacket {
+	pkt := &benchPacket{
+		Header:   benchHeader{Type: 0x67, Flags: 4},
+		ClientId: 2, LeaderId: 1, LobbyNum: 5, Block: 1, Event: 3, Rate: 1.5,
+	}
+	for i := range pkt.Players {
+		pkt.Players[i].Guildcard = 42000000 + uint32(i)
+		pkt.Players[i].ClientId = uint16(i)
+		copy(pkt.Players[i].Name[:], []uint16{'P', 'l', 'a', 'y', 'e', 'r', '0' + uint16(i)})
+	}
+	pkt.Header.Size = uint16(binary.Size(pkt))
+	return pkt
+}
+
+// Serializes a struct the way BytesFromStruct did before it had a pool and its
+// own encoder, with binary.Write into a fresh buffer for every field.
+func writeFields(data interface{}) []byte {
+	val := reflect.Indirect(reflect.ValueOf(data))
+	buf := new(bytes.Buffer)
+	for i := 0; i < val.NumField(); i++ {
+		field := val.Field(i)
+		var err error
+		switch field.Kind() {
+		case reflect.Struct, reflect.Ptr:
+			err = binary.Write(buf, binary.LittleEndian, writeFields(field.Interface()))
+		default:
+			err = binary.Write(buf, binary.LittleEndian, field.Interface())
+		}
+		if err != nil {
+			panic(err.Error())
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestBytesFromStruct(t *testing.T) {
+	pkt := newBenchPacket()
+	expected := writeFields(pkt)
+	b, size := BytesFromStruct(pkt)
+	if size != len(b) || !bytes.Equal(b, expected) {
+		t.Fatalf("got %d bytes\n%x\nexpected\n%x", size, b, expected)
+	}
+	if appended := AppendStruct([]byte{0xff}, pkt); !bytes.Equal(appended[1:], expected) || appended[0] != 0xff {
+		t.Errorf("AppendStruct didn't append to the slice it was given")
+	}
+
+	decoded := new(benchPacket)
+	if err := DecodeStruct(b, decoded); err != nil {
+		t.Fatal(err)
+	} else if *decoded != *pkt {
+		t.Errorf("decoded %+v, expected %+v", decoded, pkt)
+	}
+	if err := DecodeStruct(b[:len(b)-1], decoded); err == nil {
+		t.Error("decoded a packet that was too short")
+	}
+}
+
+func BenchmarkBytesFromStruct(b *testing.B) {
+	pkt := newBenchPacket()
+	b.Run("pooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			BytesFromStruct(pkt)
+		}
+	})
+	// The same encoder, growing a new slice each time.
+	b.Run("unpooled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			AppendStruct(nil, pkt)
+		}
+	})
+	b.Run("append to pooled buffer", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			buf := AppendStruct(GetBytes(0), pkt)
+			PutBytes(buf)
+		}
+	})
+	b.Run("binary.Write", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			writeFields(pkt)
+		}
+	})
+}
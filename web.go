@@ -0,0 +1,420 @@
+/*
+* Optional HTTP API for things that players can't do from the client, backed by
+* the same account store as the login server:
+*
+*	POST /api/accounts                   Register an account.
+*	POST /api/password-reset             Email a password reset token.
+*	POST /api/password-reset/confirm     Set a new password using a token.
+*	GET  /api/guildcards/<guildcard>     Look up the characters on a guildcard.
+*	POST /api/two-factor/enroll          Get a new two-factor secret and recovery codes.
+*	POST /api/two-factor/confirm         Turn on two-factor authentication with a code.
+*	POST /api/two-factor/disable         Turn it off with a code or a recovery code.
+*
+* Requests and responses are JSON, and POSTs have to be sent as
+* application/json. Errors are returned as {"error": "..."}.
+ */
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dcrodman/archon/data"
+)
+
+const (
+	// How long a password reset token can be used after it's issued.
+	passwordResetTimeout = time.Hour
+	// Requests to the API are small, so anything larger is rejected.
+	maxWebRequestSize = 4096
+)
+
+// Routes for the web server. The debug handler is added here as well when the
+// API is enabled since they share a port.
+var webMux = http.NewServeMux()
+
+type registerRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Email    string `json:"email"`
+}
+
+type registerResponse struct {
+	Username  string `json:"username"`
+	Guildcard int    `json:"guildcard"`
+}
+
+type passwordResetRequest struct {
+	Email string `json:"email"`
+}
+
+type passwordResetConfirmRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type twoFactorRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// Not needed to enroll.
+	Code string `json:"code"`
+}
+
+type twoFactorEnrollResponse struct {
+	Secret string `json:"secret"`
+	// otpauth URI to show as a QR code for authenticator apps.
+	URI           string   `json:"uri"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type guildcardCharacter struct {
+	Slot      uint32 `json:"slot"`
+	Name      string `json:"name"`
+	Class     byte   `json:"class"`
+	SectionID byte   `json:"section_id"`
+	Level     uint32 `json:"level"`
+}
+
+type guildcardResponse struct {
+	Guildcard  uint32               `json:"guildcard"`
+	Characters []guildcardCharacter `json:"characters"`
+}
+
+type webError struct {
+	Error string `json:"error"`
+}
+
+// StartWebServer starts serving the account API if it's enabled. Requests count
+// towards the same per-address limits as connections to the login servers, so
+// the API can't be used to guess passwords any faster.
+func StartWebServer(limiter *rateLimiter) {
+	if !config.WebEnabled {
+		return
+	}
+	webMux.HandleFunc("/api/accounts", handleRegister)
+	webMux.HandleFunc("/api/password-reset", handlePasswordReset)
+	webMux.HandleFunc("/api/password-reset/confirm", handlePasswordResetConfirm)
+	webMux.HandleFunc("/api/guildcards/", handleGuildcardLookup)
+	webMux.HandleFunc("/api/two-factor/enroll", handleTwoFactorEnroll)
+	webMux.HandleFunc("/api/two-factor/confirm", handleTwoFactorConfirm)
+	webMux.HandleFunc("/api/two-factor/disable", handleTwoFactorDisable)
+
+	server := &http.Server{
+		Addr:         net.JoinHostPort(config.Hostname, config.WebPort),
+		Handler:      limitRequests(limiter, webMux),
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+	go func() {
+		if config.WebCertificateFile != "" {
+			fmt.Println("Serving the web API over HTTPS on " + server.Addr)
+		} else {
+			fmt.Println("Serving the web API on " + server.Addr)
+		}
+		err := serveHTTP(server, config.WebCertificateFile, config.WebKeyFile)
+		log.Error("Web server stopped: " + err.Error())
+	}()
+}
+
+func handleRegister(w http.ResponseWriter, req *http.Request) {
+	var body registerRequest
+	if !readJSON(w, req, &body) {
+		return
+	}
+	account, err := RegisterAccount(body.Username, body.Password, body.Email)
+	switch err {
+	case nil:
+		writeJSON(w, http.StatusCreated, registerResponse{account.Username, account.Guildcard})
+	case ErrInvalidUsername, ErrInvalidPassword, ErrInvalidEmail:
+		writeError(w, http.StatusBadRequest, err.Error())
+	case data.ErrAccountExists, data.ErrEmailInUse:
+		// Which of them is taken isn't said, so that this can't be used to find
+		// out whether someone has an account with their email.
+		writeError(w, http.StatusConflict, "username or email is already registered")
+	default:
+		log.Error("Failed to register account: " + err.Error())
+		writeError(w, http.StatusInternalServerError, "unable to register account")
+	}
+}
+
+// Email a reset token to the owner of an account. The response is the same
+// whether or not the email is registered, and the email is sent after it, so
+// that neither the response nor how long it takes can be used to find out who
+// has an account.
+func handlePasswordReset(w http.ResponseWriter, req *http.Request) {
+	if config.SMTPAddress == "" {
+		writeError(w, http.StatusNotImplemented, "password resets are disabled")
+		return
+	}
+	var body passwordResetRequest
+	if !readJSON(w, req, &body) {
+		return
+	}
+	go func(email string) {
+		if err := sendPasswordReset(email); err != nil {
+			log.Error("Failed to send password reset: " + err.Error())
+		}
+	}(strings.TrimSpace(body.Email))
+	writeJSON(w, http.StatusAccepted, struct{}{})
+}
+
+func sendPasswordReset(email string) error {
+	account, err := database.FindAccountByEmail(email)
+	if err != nil || account == nil {
+		return err
+	}
+	if banned, err := accountBanned(account); err != nil || banned {
+		return err
+	}
+
+	tokenBytes := make([]byte, 32)
+	if _, err = rand.Read(tokenBytes); err != nil {
+		return err
+	}
+	token := hex.EncodeToString(tokenBytes)
+	expires := time.Now().Add(passwordResetTimeout)
+	if err = database.CreatePasswordReset(account.Username, hashResetToken(token), expires); err != nil {
+		return err
+	}
+
+	return sendEmail([]string{account.Email}, config.ShipName+" password reset",
+		"A password reset was requested for the account "+account.Username+".\r\n"+
+			"Use this link within the next hour to choose a new password:\r\n\r\n"+
+			config.PasswordResetURL+token+"\r\n\r\n"+
+			"If you didn't request this, you can ignore this email.\r\n")
+}
+
+// Send an email through the configured SMTP server.
+func sendEmail(to []string, subject string, body string) error {
+	host, _, _ := net.SplitHostPort(config.SMTPAddress)
+	var auth smtp.Auth
+	if config.SMTPUsername != "" {
+		auth = smtp.PlainAuth("", config.SMTPUsername, config.SMTPPassword, host)
+	}
+	message := "From: " + config.MailFrom + "\r\n" +
+		"To: " + strings.Join(to, ", ") + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"\r\n" +
+		body
+	return smtp.SendMail(config.SMTPAddress, auth, config.MailFrom, to, []byte(message))
+}
+
+func handlePasswordResetConfirm(w http.ResponseWriter, req *http.Request) {
+	var body passwordResetConfirmRequest
+	if !readJSON(w, req, &body) {
+		return
+	}
+	// Check the password first so that the token isn't used up by a typo.
+	if err := validatePassword(body.Password); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	username, err := database.UsePasswordReset(hashResetToken(body.Token))
+	if err == nil && username == "" {
+		writeError(w, http.StatusBadRequest, "invalid or expired token")
+		return
+	}
+	if err == nil {
+		err = ResetPassword(username, body.Password)
+	}
+	if err != nil {
+		log.Error("Failed to reset password: " + err.Error())
+		writeError(w, http.StatusInternalServerError, "unable to reset password")
+		return
+	}
+	writeJSON(w, http.StatusOK, struct{}{})
+}
+
+// Tokens are only stored as hashes in case the database is leaked.
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Check the username and password in a request, writing an error response and
+// returning nil if they're wrong.
+func authenticateRequest(w http.ResponseWriter, username, password string) *data.Account {
+	account, err := database.FindAccount(username)
+	if err != nil {
+		log.Error("Failed to look up account: " + err.Error())
+		writeError(w, http.StatusInternalServerError, "unable to look up account")
+		return nil
+	} else if account == nil {
+		checkDecoyPassword(password)
+	}
+	if account == nil || !checkPassword(account.Password, password) || !account.Active {
+		writeError(w, http.StatusUnauthorized, "invalid username or password")
+		return nil
+	}
+	if banned, err := accountBanned(account); err != nil {
+		log.Error("Failed to check bans: " + err.Error())
+		writeError(w, http.StatusInternalServerError, "unable to look up account")
+		return nil
+	} else if banned {
+		writeError(w, http.StatusUnauthorized, "invalid username or password")
+		return nil
+	}
+	return account
+}
+
+func handleTwoFactorEnroll(w http.ResponseWriter, req *http.Request) {
+	var body twoFactorRequest
+	if !readJSON(w, req, &body) {
+		return
+	}
+	account := authenticateRequest(w, body.Username, body.Password)
+	if account == nil {
+		return
+	}
+	secret, codes, err := EnrollTwoFactor(account, body.Password)
+	switch err {
+	case nil:
+		writeJSON(w, http.StatusOK, twoFactorEnrollResponse{
+			Secret:        secret,
+			URI:           totpURI(account.Username, secret),
+			RecoveryCodes: codes,
+		})
+	case ErrTwoFactorPassword:
+		writeError(w, http.StatusBadRequest, err.Error())
+	case ErrTwoFactorEnabled:
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		log.Error("Failed to enroll in two-factor authentication: " + err.Error())
+		writeError(w, http.StatusInternalServerError, "unable to enroll")
+	}
+}
+
+func handleTwoFactorConfirm(w http.ResponseWriter, req *http.Request) {
+	var body twoFactorRequest
+	if !readJSON(w, req, &body) {
+		return
+	}
+	account := authenticateRequest(w, body.Username, body.Password)
+	if account == nil {
+		return
+	}
+	writeTwoFactorResult(w, ConfirmTwoFactor(account.Username, strings.TrimSpace(body.Code)))
+}
+
+func handleTwoFactorDisable(w http.ResponseWriter, req *http.Request) {
+	var body twoFactorRequest
+	if !readJSON(w, req, &body) {
+		return
+	}
+	account := authenticateRequest(w, body.Username, body.Password)
+	if account == nil {
+		return
+	}
+	writeTwoFactorResult(w, DisableTwoFactor(account.Username, strings.TrimSpace(body.Code)))
+}
+
+func writeTwoFactorResult(w http.ResponseWriter, err error) {
+	switch err {
+	case nil:
+		writeJSON(w, http.StatusOK, struct{}{})
+	case ErrInvalidTwoFactorCode:
+		writeError(w, http.StatusUnauthorized, err.Error())
+	case ErrTwoFactorEnabled, ErrTwoFactorNotEnrolled:
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		log.Error("Failed to update two-factor authentication: " + err.Error())
+		writeError(w, http.StatusInternalServerError, "unable to update two-factor authentication")
+	}
+}
+
+func handleGuildcardLookup(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	num, err := strconv.ParseUint(strings.TrimPrefix(req.URL.Path, "/api/guildcards/"), 10, 32)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid guildcard")
+		return
+	}
+
+	guildcard := uint32(num)
+	resp := guildcardResponse{Guildcard: guildcard, Characters: []guildcardCharacter{}}
+	for slot := uint32(0); slot < NumCharacterSlots; slot++ {
+		character, err := database.Replica().FindCharacter(guildcard, slot)
+		if err != nil {
+			log.Error("Failed to look up guildcard: " + err.Error())
+			writeError(w, http.StatusInternalServerError, "unable to look up guildcard")
+			return
+		} else if character == nil {
+			continue
+		}
+		resp.Characters = append(resp.Characters, guildcardCharacter{
+			Slot:      slot,
+			Name:      characterName(character),
+			Class:     character.Class,
+			SectionID: character.SectionID,
+			Level:     character.Level + 1,
+		})
+	}
+	if len(resp.Characters) == 0 {
+		writeError(w, http.StatusNotFound, "guildcard not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// Refuse requests from addresses that are over the rate limits.
+func limitRequests(limiter *rateLimiter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		host, _, _ := net.SplitHostPort(req.RemoteAddr)
+		if !limiter.Allow(&net.TCPAddr{IP: net.ParseIP(host)}) {
+			writeError(w, http.StatusTooManyRequests, "too many requests")
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// Decode the JSON body of a POST request, writing an error response and
+// returning false if it isn't one.
+func readJSON(w http.ResponseWriter, req *http.Request, v interface{}) bool {
+	return readJSONUpTo(w, req, v, maxWebRequestSize)
+}
+
+// readJSON for requests that can be up to limit bytes.
+func readJSONUpTo(w http.ResponseWriter, req *http.Request, v interface{}, limit int64) bool {
+	if req.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return false
+	}
+	// Browsers can't send JSON to another site without asking it first, unlike
+	// the content types that forms can send.
+	if mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); mediaType != "application/json" {
+		writeError(w, http.StatusUnsupportedMediaType, "the request must be application/json")
+		return false
+	}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, req.Body, limit))
+	if err := decoder.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "malformed request: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Warn("Failed to write web response: " + err.Error())
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, webError{message})
+}